@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runChangelog renders the applied migrations as Markdown
+func runChangelog(args []string) error {
+	fs := flag.NewFlagSet("changelog", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	output := fs.String("output", "", "Write the changelog to this file instead of standard output")
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	changelog, err := mm.Changelog()
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		fmt.Print(changelog)
+		return nil
+	}
+	if err := os.WriteFile(*output, []byte(changelog), 0644); err != nil {
+		return fmt.Errorf("error writing changelog: %w", err)
+	}
+	return nil
+}
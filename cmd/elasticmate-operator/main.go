@@ -0,0 +1,41 @@
+// Command elasticmate-operator applies the migrations of
+// ElasticsearchMigration resources to their clusters and reports the outcome
+// in their status. It runs in the cluster with a service account allowed to
+// read the resources, their ConfigMaps and Secrets, and update their status;
+// crd.yaml defines the resource.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration/operator"
+)
+
+func main() {
+	namespace := flag.String("namespace", "", "Only reconcile resources in this namespace (default all namespaces)")
+	interval := flag.Duration("interval", time.Minute, "How often resources are reconciled")
+	flag.Parse()
+
+	kube, err := operator.InClusterClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	controller := operator.NewController(kube, *namespace)
+	controller.Interval = *interval
+	controller.Logger = log.New(os.Stderr, "", log.LstdFlags)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Reconciling %s every %s", operator.Resource, *interval)
+	if err := controller.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
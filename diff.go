@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// runDiff generates a migration from two versions of a mapping file
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	index := fs.String("index", "", "Index, or alias in front of it, the mappings are of")
+	dir := fs.String("dir", "migrations", "Directory of the migrations package")
+	pkg := fs.String("package", "", "Package name of the generated files (default the directory name)")
+	description := fs.String("description", "", "Description of the migration (default \"Update mapping of <index>\")")
+
+	// flags may follow the file names
+	var files []string
+	for fs.Parse(args); fs.NArg() > 0; fs.Parse(args) {
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) != 2 || *index == "" {
+		return fmt.Errorf("usage: elasticmate diff [-dir migrations] -index <index> <old_mapping.json> <new_mapping.json>")
+	}
+
+	old, err := os.ReadFile(files[0])
+	if err != nil {
+		return fmt.Errorf("error reading old mapping: %w", err)
+	}
+	desired, err := os.ReadFile(files[1])
+	if err != nil {
+		return fmt.Errorf("error reading new mapping: %w", err)
+	}
+
+	path, diff, err := migration.ScaffoldMappingDiff(migration.MappingDiffConfig{
+		Dir:         *dir,
+		Package:     *pkg,
+		Index:       *index,
+		Old:         old,
+		New:         desired,
+		Description: *description,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(diff)
+	fmt.Printf("Created %s\n", path)
+	return nil
+}
@@ -40,7 +40,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	mm := migration.NewMigrationManager(client, "")
+	mm := migration.NewMigrationManager(client)
 	mm.Register(migration.NewMigration(
 		"Create users index",
 		createUsersIndex,
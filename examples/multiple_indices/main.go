@@ -69,7 +69,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	mm := migration.NewMigrationManager(client, "")
+	mm := migration.NewMigrationManager(client)
 	
 	mm.Register(migration.NewMigration(
 		"Create products index",
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// runGraph prints the dependency graph of the migrations
+func runGraph(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	format := fs.String("format", "dot", "Output format: dot or mermaid")
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	graph, err := mm.Graph()
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "dot":
+		fmt.Print(graph.DOT())
+	case "mermaid":
+		fmt.Print(graph.Mermaid())
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return nil
+}
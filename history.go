@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runHistory exports the migration history or imports one exported from
+// another cluster
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	exportPath := fs.String("export", "", "Write the history to this file (- for standard output)")
+	importPath := fs.String("import", "", "Record the migrations of an exported history as applied (- for standard input)")
+	fs.Parse(args)
+
+	if (*exportPath == "") == (*importPath == "") {
+		return fmt.Errorf("history requires one of -export or -import")
+	}
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	if *exportPath != "" {
+		if *exportPath == "-" {
+			return mm.ExportHistory(os.Stdout)
+		}
+		file, err := os.Create(*exportPath)
+		if err != nil {
+			return fmt.Errorf("error creating history file: %w", err)
+		}
+		if err := mm.ExportHistory(file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	var r io.Reader = os.Stdin
+	if *importPath != "-" {
+		file, err := os.Open(*importPath)
+		if err != nil {
+			return fmt.Errorf("error opening history file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	imported, err := mm.ImportHistory(r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d migration records\n", imported)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// runIntrospect prints a migration recreating an existing index
+func runIntrospect(args []string) error {
+	fs := flag.NewFlagSet("introspect", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	index := fs.String("index", "", "Index to introspect")
+	outputFormat := fs.String("format", "go", "Output format: go or yaml")
+	pkg := fs.String("package", "migrations", "Package name of the generated Go file")
+	output := fs.String("output", "", "Write the migration to this file instead of standard output")
+	fs.Parse(args)
+
+	if *index == "" {
+		return fmt.Errorf("introspect requires -index")
+	}
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	definition, err := migration.Introspect(mm.Client, *index)
+	if err != nil {
+		return err
+	}
+
+	var source []byte
+	switch *outputFormat {
+	case "go":
+		source, err = definition.RenderGo(*pkg)
+	case "yaml":
+		source, err = definition.RenderYAML()
+	default:
+		return fmt.Errorf("unknown format %q, expected go or yaml", *outputFormat)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+	if err := os.WriteFile(*output, source, 0644); err != nil {
+		return fmt.Errorf("error writing migration: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// runLint checks the migration definitions for common problems
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	strict := fs.Bool("strict", false, "Fail on warnings as well as errors")
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	report := mm.Lint()
+	fmt.Print(report)
+	if report.HasErrors() || (*strict && len(report.Issues) > 0) {
+		return errors.New("lint failed")
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -29,26 +30,302 @@ func createUsersIndex(client *elasticsearch.Client) error {
 	return nil
 }
 
-func main() {
-	esURL := flag.String("url", "http://localhost:9200", "Elasticsearch URL")
-	filePath := flag.String("file", "", "Optional path to text file for version management")
-	flag.Parse()
+// commands are the subcommands besides running migrations
+var commands = map[string]func(args []string) error{
+	"changelog":  runChangelog,
+	"diff":       runDiff,
+	"graph":      runGraph,
+	"history":    runHistory,
+	"introspect": runIntrospect,
+	"lint":       runLint,
+	"new":        runNew,
+	"refresh":    runRefresh,
+	"rehearse":   runRehearse,
+	"serve":      runServe,
+	"status":     runStatus,
+	"verify":     runVerify,
+	"watch":      runWatch,
+}
+
+// versionFuncs are the strategies of -versions; hash is the default
+var versionFuncs = map[string]migration.VersionFunc{
+	"hash":       nil,
+	"sequential": migration.SequentialVersion,
+	"prefix":     migration.DescriptionPrefixVersion,
+	"filename":   migration.FileNameVersion,
+	"content":    migration.ContentVersion,
+}
+
+// Environment variables holding credentials
+const (
+	passwordEnv    = "ELASTICMATE_PASSWORD"
+	apiKeyEnv      = "ELASTICMATE_API_KEY"
+	bearerTokenEnv = "ELASTICMATE_BEARER_TOKEN"
+)
+
+// connection holds the flags shared by every command
+type connection struct {
+	esURL      *string
+	cloudID    *string
+	username   *string
+	caCert     *string
+	filePath   *string
+	namespace  *string
+	openSearch *bool
+	serverless *bool
+	dir        *string
+	source     *string
+	vars       variablesFlag
+	strictVars *bool
+	versions   *string
+	secrets    *string
+	// allowBreaking is only registered by commands that apply migrations
+	allowBreaking *bool
+}
+
+// variablesFlag collects repeated -var NAME=VALUE flags
+type variablesFlag map[string]string
+
+func (v variablesFlag) String() string {
+	return fmt.Sprint(map[string]string(v))
+}
+
+func (v variablesFlag) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected NAME=VALUE, got %q", value)
+	}
+	v[name] = val
+	return nil
+}
+
+func connectionFlags(fs *flag.FlagSet) *connection {
+	c := &connection{
+		esURL:      fs.String("url", "http://localhost:9200", "Elasticsearch URL"),
+		cloudID:    fs.String("cloud-id", "", "Elastic Cloud deployment to connect to instead of -url"),
+		username:   fs.String("username", "", "Username for basic auth; the password is read from "+passwordEnv),
+		caCert:     fs.String("ca-cert", "", "Optional PEM file of the certificate authority the cluster certificate is verified with"),
+		filePath:   fs.String("file", "", "Optional path to text file for version management"),
+		namespace:  fs.String("namespace", "", "Optional namespace scoping the migrations tracking index"),
+		openSearch: fs.Bool("opensearch", false, "Connect to an OpenSearch cluster instead of Elasticsearch"),
+		serverless: fs.Bool("serverless", false, "Detect Elastic Cloud Serverless projects and drop the settings they manage from declarative migrations"),
+		dir:        fs.String("dir", "", "Optional directory of declarative migration files"),
+		source:     fs.String("source", "", "Optional source of declarative migration files: git+URL@REF#path, an HTTP(S) zip bundle URL#sha256:HEX or oci://registry/repository@sha256:HEX"),
+		vars:       variablesFlag{},
+		strictVars: fs.Bool("strict-vars", false, "Fail on ${NAME} placeholders in declarative migrations that do not resolve"),
+		versions:   fs.String("versions", "hash", "How migration versions are derived: hash, sequential, prefix, filename or content"),
+		secrets:    fs.String("secrets", "", "Provider resolving ${secret:REF} placeholders in credentials and declarative migrations: env, file:<dir>, vault or aws"),
+	}
+	fs.Var(c.vars, "var", "Value of a ${NAME} placeholder in declarative migrations, as NAME=VALUE; may be repeated")
+	return c
+}
 
-	client, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{*esURL},
-	})
+// manager returns a migration manager connected with the parsed flags and
+// configured with opts
+func (c *connection) manager(opts ...migration.Option) (*migration.MigrationManager, error) {
+	secrets, err := secretProvider(*c.secrets)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	cfg := migration.ClientConfig{
+		CloudID:    *c.cloudID,
+		Username:   *c.username,
+		CACertFile: *c.caCert,
+		OpenSearch: *c.openSearch,
+		// secrets come from the environment rather than the command line
+		Password:    os.Getenv(passwordEnv),
+		APIKey:      os.Getenv(apiKeyEnv),
+		BearerToken: os.Getenv(bearerTokenEnv),
+		Secrets:     secrets,
+	}
+	if cfg.CloudID == "" {
+		cfg.Addresses = []string{*c.esURL}
+	}
+	client, err := migration.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]migration.Option{
+		migration.WithFilePath(*c.filePath),
+		migration.WithNamespace(*c.namespace),
+		// placeholders resolve from -var, then the environment
+		migration.WithVariables(migration.Variables{Values: c.vars, Env: true, Strict: *c.strictVars}),
+	}, opts...)
+	versionFunc, ok := versionFuncs[*c.versions]
+	if !ok {
+		return nil, fmt.Errorf("unknown version strategy %q", *c.versions)
+	}
+	if versionFunc != nil {
+		opts = append(opts, migration.WithVersionFunc(versionFunc))
+	}
+	if *c.serverless {
+		opts = append(opts, migration.WithServerlessCompatibility())
+	}
+	if secrets != nil {
+		opts = append(opts, migration.WithSecrets(secrets))
+	}
+	if c.allowBreaking != nil && *c.allowBreaking {
+		opts = append(opts, migration.WithAllowBreakingChanges())
+	}
+	if *c.filePath == "" {
+		// Let fleet-wide status report what this service has pending
+		opts = append(opts, migration.WithManifestExport())
+	}
+
+	mm := migration.NewMigrationManager(client, opts...)
+	registerMigrations(mm)
+	if *c.dir != "" {
+		if err := mm.RegisterFS(os.DirFS(*c.dir)); err != nil {
+			return nil, err
+		}
+	}
+	if *c.source != "" {
+		source, err := migration.ParseSource(*c.source)
+		if err != nil {
+			return nil, err
+		}
+		if err := mm.RegisterSource(context.Background(), source); err != nil {
+			return nil, err
+		}
 	}
+	return mm, nil
+}
+
+// secretProvider returns the provider named by -secrets, configured from
+// the environment variables of the respective tools
+func secretProvider(name string) (migration.SecretProvider, error) {
+	switch {
+	case name == "":
+		return nil, nil
+	case name == "env":
+		return migration.EnvSecrets{}, nil
+	case strings.HasPrefix(name, "file:"):
+		return migration.FileSecrets{Dir: strings.TrimPrefix(name, "file:")}, nil
+	case name == "vault":
+		return migration.VaultSecrets{Address: os.Getenv("VAULT_ADDR"), Token: os.Getenv("VAULT_TOKEN")}, nil
+	case name == "aws":
+		return migration.AWSSecrets{
+			Region:          os.Getenv("AWS_REGION"),
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown secret provider %q, expected env, file:<dir>, vault or aws", name)
+}
 
-	mm := migration.NewMigrationManager(client, *filePath)
+// registerMigrations registers the migrations shipped with this binary
+func registerMigrations(mm *migration.MigrationManager) {
 	mm.Register(migration.NewMigration(
 		"Create users index",
 		createUsersIndex,
 	))
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	conn := connectionFlags(flag.CommandLine)
+	conn.allowBreaking = flag.Bool("allow-breaking", false, "Apply mapping changes Elasticsearch rejects or that reindexing should make instead")
+	showPlan := flag.Bool("plan", false, "Print the requests pending migrations will send instead of running them")
+	validateMappings := flag.Bool("validate-mappings", false, "Validate the mappings of pending migrations in throwaway indices instead of running them")
+	checkPrivileges := flag.Bool("check-privileges", false, "Check the credentials have the privileges pending migrations need instead of running them")
+	validateSamples := flag.Int("validate-samples", 0, "Index this many documents of each index pending mappings change into throwaway copies and report those rejected, instead of running")
+	output := flag.String("output", "text", "Format of the run result: text or json")
+	detailedExitCode := flag.Bool("detailed-exit-code", false, "Exit with 2 when migrations were applied and 0 when there was nothing to do")
+	requestsPerSecond := flag.Float64("requests-per-second", 0, "Throttle reindex and by query tasks to this many documents per second")
+	slices := flag.String("slices", "", "Number of slices reindex and by query tasks run in, or auto")
+	window := flag.String("window", "", "Off-peak window pending migrations wait for, as HH:MM-HH:MM")
+	windowZone := flag.String("window-tz", "UTC", "Time zone of -window, e.g. Europe/Berlin")
+	orphans := flag.String("orphans", "warn", "What to do about applied migrations that are not registered: warn, fail or ignore")
+	flag.Parse()
+
+	opts := []migration.Option{migration.WithProgressReporter(newProgressBar())}
+	switch policy := migration.OrphanPolicy(*orphans); policy {
+	case migration.OrphanWarn, migration.OrphanFail, migration.OrphanIgnore:
+		opts = append(opts, migration.WithOrphanPolicy(policy))
+	default:
+		log.Fatalf("unknown orphan policy %q", *orphans)
+	}
+	if *window != "" {
+		parsed, err := migration.ParseWindow(*window, *windowZone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, migration.WithWindow(parsed))
+	}
+	if *requestsPerSecond != 0 || *slices != "" {
+		opts = append(opts, migration.WithThrottle(migration.Throttle{RequestsPerSecond: *requestsPerSecond, Slices: *slices}))
+	}
+	switch *output {
+	case "text":
+	case "json":
+		// keep standard output for the result
+		opts = append(opts, migration.WithLogger(log.New(os.Stderr, "", 0)))
+	default:
+		log.Fatalf("unknown output format %q", *output)
+	}
+
+	mm, err := conn.manager(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *showPlan {
+		plan, err := mm.Plan()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(plan)
+		return
+	}
+
+	if *validateMappings {
+		if err := mm.ValidateMappings(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Pending mappings are valid")
+		return
+	}
 
-	if err := mm.RunMigrations(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if *checkPrivileges {
+		if err := mm.CheckPrivileges(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Credentials have the privileges pending migrations need")
+		return
 	}
+
+	if *validateSamples > 0 {
+		conflicts, err := mm.ValidateSamples(*validateSamples)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, conflict := range conflicts {
+			fmt.Println(conflict)
+		}
+		if len(conflicts) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Sampled documents index under pending mappings")
+		return
+	}
+
+	summary, err := mm.RunMigrationsSummary(context.Background())
+	result := newRunResult(summary, err, *detailedExitCode)
+	result.report(os.Stdout, os.Stderr, *output)
+	os.Exit(result.ExitCode)
 }
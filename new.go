@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// runNew scaffolds a numbered migration file and regenerates the registry
+func runNew(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	dir := fs.String("dir", "migrations", "Directory of the migrations package")
+	pkg := fs.String("package", "", "Package name of the generated files (default the directory name)")
+	outputFormat := fs.String("format", "go", "Migration format: go or yaml")
+	fs.Parse(args)
+
+	description := strings.Join(fs.Args(), " ")
+	if description == "" {
+		return fmt.Errorf("usage: elasticmate new [-dir migrations] [-format go|yaml] <description>")
+	}
+
+	path, err := migration.Scaffold(migration.ScaffoldConfig{
+		Dir:         *dir,
+		Package:     *pkg,
+		Description: description,
+		Format:      *outputFormat,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s\n", path)
+	return nil
+}
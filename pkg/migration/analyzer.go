@@ -0,0 +1,150 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// AnalyzeCheck compares the tokens produced for Text before and after an
+// analyzer change, using the analyzer of Field or the named Analyzer
+type AnalyzeCheck struct {
+	Field    string
+	Analyzer string
+	Text     string
+	// Expect, when set, are the tokens the new index must produce; a mismatch
+	// fails the migration before the alias is moved
+	Expect []string
+}
+
+// ChangeAnalyzer returns a migration that rebuilds the index behind alias with
+// new analysis settings, reindexes into it and swaps the alias. The analysis
+// settings replace those of the current index, e.g.
+// {"analyzer": {"default": {"tokenizer": "standard", "filter": ["lowercase", "asciifolding"]}}}.
+// Checks run against both indices through the Analyze API after the copy.
+func ChangeAnalyzer(alias string, analysis map[string]interface{}, checks ...AnalyzeCheck) Migration {
+	data, err := json.Marshal(analysis)
+	hash := sha256.Sum256(data)
+	state := hex.EncodeToString(hash[:])[:8]
+
+	m := NewMigration(fmt.Sprintf("Change analyzers of %s to %s", alias, state), func(client *elasticsearch.Client) error {
+		source, err := aliasTarget(client, alias)
+		if err != nil {
+			return err
+		}
+		if source == "" {
+			return fmt.Errorf("analyzer change requires %s to be an alias that can be moved to a new index", alias)
+		}
+
+		settings := make(map[string]interface{})
+		for key, value := range flattenSettings(map[string]interface{}{"analysis": analysis}) {
+			settings[normalizeSettingKey(key)] = value
+		}
+
+		return RebuildIndex(client, RebuildConfig{
+			Source:       source,
+			Target:       fmt.Sprintf("%s_%s", alias, state),
+			Alias:        alias,
+			Settings:     settings,
+			DropSettings: []string{"index.analysis."},
+			Verify: func(client *elasticsearch.Client, source, target string) error {
+				if err := compareAnalysis(client, source, target, checks); err != nil {
+					deleteIndex(client, target)
+					return err
+				}
+				return nil
+			},
+		})
+	})
+	m.validate = func() error {
+		if alias == "" || len(analysis) == 0 {
+			return fmt.Errorf("analyzer change requires an alias and analysis settings")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid analysis settings for %s: %w", alias, err)
+		}
+		for _, check := range checks {
+			if check.Text == "" || (check.Field == "") == (check.Analyzer == "") {
+				return fmt.Errorf("analyze check on %s requires text and either a field or an analyzer", alias)
+			}
+		}
+		return nil
+	}
+	return m
+}
+
+func compareAnalysis(client *elasticsearch.Client, source, target string, checks []AnalyzeCheck) error {
+	for _, check := range checks {
+		before, err := AnalyzeTokens(client, source, check)
+		if err != nil {
+			return err
+		}
+		after, err := AnalyzeTokens(client, target, check)
+		if err != nil {
+			return err
+		}
+
+		if strings.Join(before, "\x00") != strings.Join(after, "\x00") {
+			clientLogf(client)("Tokens for %q changed: %v -> %v", check.Text, before, after)
+		}
+		if check.Expect != nil && strings.Join(after, "\x00") != strings.Join(check.Expect, "\x00") {
+			return fmt.Errorf("analyze check on %s failed: %q produced %v, expected %v", target, check.Text, after, check.Expect)
+		}
+	}
+	return nil
+}
+
+// AnalyzeTokens returns the tokens index produces for the check's text
+func AnalyzeTokens(client *elasticsearch.Client, index string, check AnalyzeCheck) ([]string, error) {
+	request := map[string]string{"text": check.Text}
+	if check.Field != "" {
+		request["field"] = check.Field
+	} else {
+		request["analyzer"] = check.Analyzer
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling analyze request: %w", err)
+	}
+
+	res, err := client.Indices.Analyze(
+		client.Indices.Analyze.WithIndex(index),
+		client.Indices.Analyze.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error analyzing text on %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error analyzing text on %s: %s", index, res.String())
+	}
+
+	var result struct {
+		Tokens []struct {
+			Token string `json:"token"`
+		} `json:"tokens"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing analyze response: %w", err)
+	}
+
+	tokens := make([]string, len(result.Tokens))
+	for i, token := range result.Tokens {
+		tokens[i] = token.Token
+	}
+	return tokens, nil
+}
+
+// deleteIndex removes index, ignoring failures; it cleans up after an
+// aborted rebuild so the migration can be retried
+func deleteIndex(client *elasticsearch.Client, index string) {
+	res, err := client.Indices.Delete([]string{index})
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+}
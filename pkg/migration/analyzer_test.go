@@ -0,0 +1,68 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChangeAnalyzer(t *testing.T) {
+	analysis := map[string]interface{}{
+		"analyzer": map[string]interface{}{
+			"default": map[string]interface{}{"tokenizer": "standard", "filter": []string{"lowercase", "asciifolding"}},
+		},
+	}
+	check := AnalyzeCheck{Field: "title", Text: "Café"}
+
+	setup := func(expect []string) (*routeTransport, Migration, string) {
+		check := check
+		check.Expect = expect
+		m := ChangeAnalyzer("articles", analysis, check)
+		target := "articles_" + m.Description[strings.LastIndex(m.Description, " ")+1:]
+
+		transport := &routeTransport{routes: map[string]string{
+			"GET /_alias/articles":          `{"articles_v1": {"aliases": {"articles": {}}}}`,
+			"GET /articles_v1":              `{"articles_v1": {"settings": {"index.analysis.analyzer.default.tokenizer": "whitespace"}, "mappings": {}}}`,
+			"POST /articles_v1/_analyze":    `{"tokens": [{"token": "Café"}]}`,
+			"POST /" + target + "/_analyze": `{"tokens": [{"token": "cafe"}]}`,
+			"POST /_reindex":                `{"task": "node-1:7"}`,
+			"GET /_tasks/node-1:7":          `{"completed": true, "response": {"total": 1, "created": 1}}`,
+		}}
+		return transport, m, target
+	}
+
+	t.Run("Test Rebuilds And Swaps Alias", func(t *testing.T) {
+		transport, m, target := setup([]string{"cafe"})
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Failed to validate migration: %v", err)
+		}
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+
+		for _, request := range []string{"PUT /" + target, "POST /_reindex", "POST /" + target + "/_analyze", "POST /_aliases"} {
+			if !transport.sent(request) {
+				t.Errorf("Expected request %s, got %v", request, transport.requests)
+			}
+		}
+	})
+
+	t.Run("Test Failed Check Keeps Alias", func(t *testing.T) {
+		transport, m, target := setup([]string{"café"})
+		err := m.run(ClientFromTransport(transport), nil)
+		if err == nil || !strings.Contains(err.Error(), "analyze check") {
+			t.Fatalf("Expected an analyze check failure, got %v", err)
+		}
+		if transport.sent("POST /_aliases") {
+			t.Error("Expected the alias to stay on the old index")
+		}
+		if !transport.sent("DELETE /" + target) {
+			t.Errorf("Expected the new index to be deleted, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Check Requires Field Or Analyzer", func(t *testing.T) {
+		if err := ChangeAnalyzer("articles", analysis, AnalyzeCheck{Text: "Café"}).Validate(); err == nil {
+			t.Error("Expected validation error for a check without field or analyzer")
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// AuditAction is what an audit event records
+type AuditAction string
+
+const (
+	AuditLockAcquired    AuditAction = "lock_acquired"
+	AuditLockReleased    AuditAction = "lock_released"
+	AuditStart           AuditAction = "start"
+	AuditSuccess         AuditAction = "success"
+	AuditFailure         AuditAction = "failure"
+	AuditRollback        AuditAction = "rollback"
+	AuditRollbackFailure AuditAction = "rollback_failure"
+)
+
+// AuditEvent is a single migration action, sent to audit sinks as it
+// happens. Lock events carry no version.
+type AuditEvent struct {
+	Timestamp   time.Time      `json:"@timestamp"`
+	Action      AuditAction    `json:"action"`
+	RunID       string         `json:"run_id,omitempty"`
+	Namespace   string         `json:"namespace,omitempty"`
+	Version     string         `json:"version,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Destructive bool           `json:"destructive,omitempty"`
+	Indices     []string       `json:"indices,omitempty"`
+	DurationMS  int64          `json:"duration_ms,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Runner      RunnerMetadata `json:"runner"`
+}
+
+// AuditSink receives audit events, e.g. to keep them for compliance review
+type AuditSink interface {
+	Audit(ctx context.Context, event AuditEvent) error
+}
+
+// AuditSinkFunc adapts a function to an AuditSink
+type AuditSinkFunc func(ctx context.Context, event AuditEvent) error
+
+func (f AuditSinkFunc) Audit(ctx context.Context, event AuditEvent) error {
+	return f(ctx, event)
+}
+
+// WithAuditSink sends an audit event to each sink when the manager acquires
+// or releases the lock of the version store, and when a migration starts,
+// succeeds, fails or is rolled back. Sink errors are logged without failing
+// the run.
+func WithAuditSink(sinks ...AuditSink) Option {
+	return func(mm *MigrationManager) {
+		mm.auditSinks = append(mm.auditSinks, sinks...)
+	}
+}
+
+// audit sends event to the audit sinks, completing its timestamp, namespace
+// and runner
+func (mm *MigrationManager) audit(event AuditEvent) {
+	if len(mm.auditSinks) == 0 {
+		return
+	}
+	event.Timestamp = time.Now().UTC()
+	event.Namespace = mm.Namespace
+	if event.Runner == (RunnerMetadata{}) {
+		event.Runner = mm.runnerMetadata()
+	}
+	for _, sink := range mm.auditSinks {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := sink.Audit(ctx, event); err != nil {
+			mm.logf("Failed to send %s audit event: %v", event.Action, err)
+		}
+		cancel()
+	}
+}
+
+// lock acquires the lock of the version store, auditing its acquisition and
+// release
+func (mm *MigrationManager) lock(runID string) (func(), error) {
+	unlock, err := mm.store().Lock()
+	if err != nil {
+		return nil, err
+	}
+	mm.audit(AuditEvent{Action: AuditLockAcquired, RunID: runID})
+	return func() {
+		if err := unlock(); err != nil {
+			mm.logf("Failed to release the migration lock: %v", err)
+		}
+		mm.audit(AuditEvent{Action: AuditLockReleased, RunID: runID})
+	}, nil
+}
+
+// auditIndex is the default index of ESAuditSink
+const auditIndex = ".elasticmate_audit"
+
+// auditMapping maps the fields of AuditEvent
+const auditMapping = `{
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "@timestamp": {"type": "date"},
+      "action": {"type": "keyword"},
+      "run_id": {"type": "keyword"},
+      "namespace": {"type": "keyword"},
+      "version": {"type": "keyword"},
+      "description": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 512}}},
+      "destructive": {"type": "boolean"},
+      "indices": {"type": "keyword"},
+      "duration_ms": {"type": "long"},
+      "error": {"type": "text"},
+      "runner": {
+        "properties": {
+          "hostname": {"type": "keyword"},
+          "user": {"type": "keyword"},
+          "app_version": {"type": "keyword"},
+          "git_sha": {"type": "keyword"}
+        }
+      }
+    }
+  }
+}`
+
+// ESAuditSink indexes audit events into an Elasticsearch index, created with
+// a strict mapping on the first event. It can point at another cluster than
+// the one migrated, so operators of the migrated cluster cannot edit the
+// trail.
+type ESAuditSink struct {
+	Client *elasticsearch.Client
+	// Index defaults to .elasticmate_audit
+	Index string
+
+	mu      sync.Mutex
+	created bool
+}
+
+func (s *ESAuditSink) Audit(ctx context.Context, event AuditEvent) error {
+	index := s.Index
+	if index == "" {
+		index = auditIndex
+	}
+	if err := s.ensureIndex(ctx, index); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding audit event: %w", err)
+	}
+	res, err := s.Client.Index(index, bytes.NewReader(body), s.Client.Index.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error indexing audit event: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error indexing audit event: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESAuditSink) ensureIndex(ctx context.Context, index string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created {
+		return nil
+	}
+	res, err := s.Client.Indices.Create(index,
+		s.Client.Indices.Create.WithContext(ctx),
+		s.Client.Indices.Create.WithBody(strings.NewReader(auditMapping)),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating audit index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() && !strings.Contains(res.String(), "resource_already_exists_exception") {
+		return fmt.Errorf("error creating audit index %s: %s", index, res.String())
+	}
+	s.created = true
+	return nil
+}
+
+// WebhookAuditSink posts each audit event as JSON to URL
+type WebhookAuditSink struct {
+	URL string
+	// Headers are added to the request, e.g. for authentication
+	Headers map[string]string
+	// Client sends the request; http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+func (s *WebhookAuditSink) Audit(ctx context.Context, event AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding audit event: %w", err)
+	}
+	return postJSON(ctx, s.Client, s.URL, s.Headers, body)
+}
+
+// KafkaAuditSink produces each audit event to a Kafka topic through a
+// Confluent REST Proxy (API v2), keyed by run ID so the events of a run stay
+// in order
+type KafkaAuditSink struct {
+	// URL is the REST Proxy, e.g. http://kafka-rest:8082
+	URL   string
+	Topic string
+	// Headers are added to the request, e.g. for authentication
+	Headers map[string]string
+	// Client sends the request; http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+func (s *KafkaAuditSink) Audit(ctx context.Context, event AuditEvent) error {
+	record := map[string]interface{}{"value": event}
+	if event.RunID != "" {
+		record["key"] = event.RunID
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": []interface{}{record}})
+	if err != nil {
+		return fmt.Errorf("error encoding audit event: %w", err)
+	}
+	headers := map[string]string{"Content-Type": "application/vnd.kafka.json.v2+json"}
+	for key, value := range s.Headers {
+		headers[key] = value
+	}
+	return postJSON(ctx, s.Client, strings.TrimRight(s.URL, "/")+"/topics/"+s.Topic, headers, body)
+}
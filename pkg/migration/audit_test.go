@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestAuditEvents(t *testing.T) {
+	var events []AuditEvent
+	sink := AuditSinkFunc(func(ctx context.Context, event AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	mm := NewMigrationManager(ClientFromTransport(&routeTransport{}),
+		WithStore(NewMemoryStore()),
+		WithFailureMode(ContinueOnError),
+		WithNamespace("ordersvc"),
+		WithAuditSink(sink),
+	)
+	created := NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil }).
+		WithDown(func(client *elasticsearch.Client) error { return nil })
+	mm.Register(created)
+	mm.Register(NewMigration("Broken migration", func(client *elasticsearch.Client) error { return errors.New("boom") }))
+
+	if err := mm.RunMigrations(); err == nil {
+		t.Fatal("Expected the broken migration to fail the run")
+	}
+
+	t.Run("Test Run Events", func(t *testing.T) {
+		var actions []string
+		for _, event := range events {
+			actions = append(actions, string(event.Action)+" "+event.Version)
+		}
+		want := []string{"lock_acquired "}
+		for _, version := range sortedRegistered(t, mm) {
+			outcome := AuditFailure
+			if version == created.Version() {
+				outcome = AuditSuccess
+			}
+			want = append(want, "start "+version, string(outcome)+" "+version)
+		}
+		want = append(want, "lock_released ")
+		if strings.Join(actions, ", ") != strings.Join(want, ", ") {
+			t.Errorf("Expected %v, got %v", want, actions)
+		}
+		for _, event := range events {
+			if event.RunID != events[0].RunID || event.Namespace != "ordersvc" || event.Timestamp.IsZero() {
+				t.Errorf("Expected every event to carry the run, namespace and time, got %+v", event)
+			}
+			if event.Action == AuditFailure && event.Error != "boom" {
+				t.Errorf("Expected the failure to carry its error, got %q", event.Error)
+			}
+		}
+	})
+
+	t.Run("Test Rollback Event", func(t *testing.T) {
+		events = nil
+		if err := mm.Rollback(created.Version()); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		if len(events) != 3 || events[1].Action != AuditRollback || events[1].Version != created.Version() {
+			t.Errorf("Expected a rollback event between lock events, got %+v", events)
+		}
+	})
+}
+
+// sortedRegistered returns the versions of the registered migrations in the
+// order a run applies them
+func sortedRegistered(t *testing.T, mm *MigrationManager) []string {
+	t.Helper()
+	migrations, err := mm.registered()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sortMigrations(migrations); err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version())
+	}
+	return versions
+}
+
+func TestAuditSinks(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type")+" "+string(body))
+	}))
+	defer server.Close()
+	event := AuditEvent{Action: AuditSuccess, RunID: "run-1", Version: "3fa2c1d0"}
+
+	t.Run("Test Webhook", func(t *testing.T) {
+		requests = nil
+		if err := (&WebhookAuditSink{URL: server.URL + "/audit"}).Audit(context.Background(), event); err != nil {
+			t.Fatalf("Failed to send event: %v", err)
+		}
+		if len(requests) != 1 || !strings.HasPrefix(requests[0], "POST /audit application/json ") || !strings.Contains(requests[0], `"version":"3fa2c1d0"`) {
+			t.Errorf("Expected the event posted as JSON, got %v", requests)
+		}
+	})
+
+	t.Run("Test Kafka REST Proxy", func(t *testing.T) {
+		requests = nil
+		if err := (&KafkaAuditSink{URL: server.URL, Topic: "migrations"}).Audit(context.Background(), event); err != nil {
+			t.Fatalf("Failed to send event: %v", err)
+		}
+		if len(requests) != 1 || !strings.HasPrefix(requests[0], "POST /topics/migrations application/vnd.kafka.json.v2+json ") {
+			t.Fatalf("Expected a produce request, got %v", requests)
+		}
+		var produced struct {
+			Records []struct {
+				Key   string     `json:"key"`
+				Value AuditEvent `json:"value"`
+			} `json:"records"`
+		}
+		json.Unmarshal([]byte(requests[0][strings.Index(requests[0], "{"):]), &produced)
+		if len(produced.Records) != 1 || produced.Records[0].Key != "run-1" || produced.Records[0].Value.Action != AuditSuccess {
+			t.Errorf("Expected one record keyed by run, got %+v", produced)
+		}
+	})
+
+	t.Run("Test Elasticsearch Index", func(t *testing.T) {
+		transport := &routeTransport{}
+		sink := &ESAuditSink{Client: ClientFromTransport(transport)}
+		for i := 0; i < 2; i++ {
+			if err := sink.Audit(context.Background(), event); err != nil {
+				t.Fatalf("Failed to index event: %v", err)
+			}
+		}
+		want := "PUT /.elasticmate_audit, POST /.elasticmate_audit/_doc, POST /.elasticmate_audit/_doc"
+		if strings.Join(transport.requests, ", ") != want {
+			t.Errorf("Expected the index created once, got %v", transport.requests)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ErrNotReady is returned by AutoMigration.Ready while migrations are being
+// applied
+var ErrNotReady = errors.New("migrations are not applied yet")
+
+// lockPollInterval is how often AutoMigrate retries while another instance
+// holds the lock
+var lockPollInterval = 2 * time.Second
+
+// WithMigrations registers migrations on the manager
+func WithMigrations(migrations ...Migration) Option {
+	return func(mm *MigrationManager) {
+		for _, migration := range migrations {
+			mm.Register(migration)
+		}
+	}
+}
+
+// AutoMigration applies migrations when a service starts and gates its
+// readiness on them
+type AutoMigration struct {
+	mm   *MigrationManager
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// AutoMigrate applies pending migrations from a service's main and blocks
+// until they are applied, or until ctx is done. While another instance holds
+// the lock it waits for that instance to finish and then checks that nothing
+// is left pending. Migrations are registered with WithMigrations.
+func AutoMigrate(ctx context.Context, client *elasticsearch.Client, opts ...Option) (*AutoMigration, error) {
+	auto := StartAutoMigrate(ctx, client, opts...)
+	return auto, auto.Wait(ctx)
+}
+
+// StartAutoMigrate applies pending migrations like AutoMigrate in the
+// background, so the service can serve health checks meanwhile and report
+// itself ready once Ready returns nil
+func StartAutoMigrate(ctx context.Context, client *elasticsearch.Client, opts ...Option) *AutoMigration {
+	auto := &AutoMigration{
+		mm:   NewMigrationManager(client, opts...),
+		done: make(chan struct{}),
+		err:  ErrNotReady,
+	}
+	go auto.run(ctx)
+	return auto
+}
+
+func (a *AutoMigration) run(ctx context.Context) {
+	defer close(a.done)
+	for {
+		err := a.mm.RunMigrationsContext(ctx)
+		if !errors.Is(err, ErrLocked) {
+			a.setErr(err)
+			return
+		}
+
+		a.mm.logf("Migrations locked by another instance, retrying in %s", lockPollInterval)
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.setErr(ctx.Err())
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (a *AutoMigration) setErr(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.err = err
+}
+
+// Manager returns the manager applying the migrations
+func (a *AutoMigration) Manager() *MigrationManager {
+	return a.mm
+}
+
+// Wait blocks until the migrations are applied or failed, or ctx is done,
+// and returns the result of Ready
+func (a *AutoMigration) Wait(ctx context.Context) error {
+	select {
+	case <-a.done:
+		return a.Ready()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ready returns nil once every migration is applied, ErrNotReady while they
+// are being applied and the error of the run if it failed
+func (a *AutoMigration) Ready() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// ServeHTTP answers readiness probes with 200 once migrations are applied and
+// 503 otherwise
+func (a *AutoMigration) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := a.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
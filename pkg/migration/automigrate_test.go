@@ -0,0 +1,75 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestAutoMigrate(t *testing.T) {
+	lockPollInterval = 10 * time.Millisecond
+	defer func() { lockPollInterval = 2 * time.Second }()
+
+	created := NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil })
+
+	t.Run("Test Blocks Until Applied", func(t *testing.T) {
+		store := NewMemoryStore()
+		auto, err := AutoMigrate(context.Background(), nil, WithStore(store), WithMigrations(created))
+		if err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+		if err := auto.Ready(); err != nil {
+			t.Errorf("Expected ready, got %v", err)
+		}
+		if records, _ := store.GetApplied(); len(records) != 1 {
+			t.Errorf("Expected the migration applied, got %+v", records)
+		}
+	})
+
+	t.Run("Test Waits For Another Instance", func(t *testing.T) {
+		store := NewMemoryStore()
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Failed to lock store: %v", err)
+		}
+
+		auto := StartAutoMigrate(context.Background(), nil, WithStore(store), WithMigrations(created))
+		time.Sleep(30 * time.Millisecond)
+		if err := auto.Ready(); !errors.Is(err, ErrNotReady) {
+			t.Fatalf("Expected not ready while locked, got %v", err)
+		}
+		probe := httptest.NewRecorder()
+		auto.ServeHTTP(probe, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if probe.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 while not ready, got %d", probe.Code)
+		}
+
+		// the other instance applies the migration and releases the lock
+		store.Record(MigrationRecord{Version: created.Version(), AppliedAt: time.Now()})
+		unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := auto.Wait(ctx); err != nil {
+			t.Fatalf("Expected ready once unlocked, got %v", err)
+		}
+		probe = httptest.NewRecorder()
+		auto.ServeHTTP(probe, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if probe.Code != http.StatusOK {
+			t.Errorf("Expected 200 once ready, got %d", probe.Code)
+		}
+	})
+
+	t.Run("Test Failure Is Reported", func(t *testing.T) {
+		broken := NewMigration("Broken migration", func(client *elasticsearch.Client) error { return errors.New("boom") })
+		auto, err := AutoMigrate(context.Background(), nil, WithStore(NewMemoryStore()), WithMigrations(broken))
+		if err == nil || auto.Ready() == nil {
+			t.Errorf("Expected the failure to be reported, got %v", err)
+		}
+	})
+}
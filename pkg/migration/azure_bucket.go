@@ -0,0 +1,200 @@
+package migration
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureStorageVersion is the Blob service REST API version requests use
+const azureStorageVersion = "2021-08-06"
+
+// AzureBlobBucket implements ObjectBucket for Azure Blob Storage, with blob
+// ETags as revisions. Requests are authorized with the account key (Shared
+// Key) or a SAS token.
+type AzureBlobBucket struct {
+	Account   string
+	Container string
+	Endpoint  string // Optional, defaults to https://<account>.blob.core.windows.net
+
+	AccountKey string // Base64 encoded account key
+	SASToken   string // Used instead of AccountKey, e.g. sv=...&sig=...
+
+	HTTPClient *http.Client
+}
+
+func (b *AzureBlobBucket) Get(key string) ([]byte, string, error) {
+	res, err := b.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", ErrObjectNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", s3Error(res)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading blob %s: %w", key, err)
+	}
+
+	return data, res.Header.Get("ETag"), nil
+}
+
+func (b *AzureBlobBucket) Put(key string, data []byte, ifRevision string) (string, error) {
+	headers := map[string]string{
+		"Content-Type":   "application/json",
+		"X-Ms-Blob-Type": "BlockBlob",
+	}
+	if ifRevision == "" {
+		headers["If-None-Match"] = "*"
+	} else {
+		headers["If-Match"] = ifRevision
+	}
+
+	res, err := b.do(http.MethodPut, key, data, headers)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	// Azure answers 409 BlobAlreadyExists to If-None-Match: *
+	if res.StatusCode == http.StatusPreconditionFailed || res.StatusCode == http.StatusConflict {
+		return "", ErrPreconditionFailed
+	}
+	if res.StatusCode != http.StatusCreated {
+		return "", s3Error(res)
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+func (b *AzureBlobBucket) Delete(key string, ifRevision string) error {
+	var headers map[string]string
+	if ifRevision != "" {
+		headers = map[string]string{"If-Match": ifRevision}
+	}
+
+	res, err := b.do(http.MethodDelete, key, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPreconditionFailed || (ifRevision != "" && res.StatusCode == http.StatusNotFound) {
+		return ErrPreconditionFailed
+	}
+	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+
+	return nil
+}
+
+func (b *AzureBlobBucket) do(method, key string, body []byte, headers map[string]string) (*http.Response, error) {
+	endpoint := b.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + b.Account + ".blob.core.windows.net"
+	}
+	target := strings.TrimRight(endpoint, "/") + "/" + b.Container + "/" + escapeS3Key(key)
+	if b.SASToken != "" {
+		target += "?" + strings.TrimPrefix(b.SASToken, "?")
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error building request for blob %s: %w", key, err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureStorageVersion)
+	if b.SASToken == "" {
+		if err := b.sign(req, len(body)); err != nil {
+			return nil, err
+		}
+	}
+
+	client := b.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting blob %s: %w", key, err)
+	}
+	return res, nil
+}
+
+// sign adds a Shared Key Authorization header to req
+func (b *AzureBlobBucket) sign(req *http.Request, contentLength int) error {
+	key, err := base64.StdEncoding.DecodeString(b.AccountKey)
+	if err != nil {
+		return fmt.Errorf("error decoding Azure account key: %w", err)
+	}
+
+	length := ""
+	if contentLength > 0 {
+		length = strconv.Itoa(contentLength)
+	}
+
+	var msHeaders []string
+	for name := range req.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name)
+		}
+	}
+	sort.Strings(msHeaders)
+	var canonicalHeaders strings.Builder
+	for _, name := range msHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	canonicalResource := "/" + b.Account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		canonicalResource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + canonicalResource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+b.Account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
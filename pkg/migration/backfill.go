@@ -0,0 +1,412 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Document is a document read by a backfill
+type Document struct {
+	Index  string
+	ID     string
+	Source json.RawMessage
+}
+
+// BackfillProgress is reported after every written batch
+type BackfillProgress struct {
+	// Total is the number of documents matching the query
+	Total   int64
+	Scanned int64
+	Updated int64
+	Skipped int64
+	Failed  int64
+	Elapsed time.Duration
+}
+
+// BackfillConfig configures a backfill
+type BackfillConfig struct {
+	Index string
+	// Query is a query clause such as {"bool": {"must_not": {"exists": {"field": "slug"}}}};
+	// all documents are scanned when empty
+	Query string
+
+	// Transform returns the fields to set on a document, or nil to leave it
+	// unchanged. The fields are applied as a partial update.
+	Transform func(doc Document) (map[string]interface{}, error)
+
+	// BatchSize is the number of documents read per page and written per bulk
+	// request (default 500)
+	BatchSize int
+	// Concurrency is the number of bulk requests in flight (default 2)
+	Concurrency int
+	// RequestsPerSecond limits the rate of bulk requests when greater than zero
+	RequestsPerSecond float64
+	// MaxRetries bounds the retries of requests and documents rejected with
+	// 429 Too Many Requests (default 5)
+	MaxRetries int
+	// KeepAlive is the point in time keep alive between pages (default "5m")
+	KeepAlive string
+
+	// Progress, when set, is called after every written batch
+	Progress func(BackfillProgress)
+}
+
+// Backfill returns a migration that scans an index, transforms each document
+// and bulk-writes the results
+func Backfill(description string, cfg BackfillConfig) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		return RunBackfill(context.Background(), client, cfg)
+	})
+	m.validate = func() error {
+		if cfg.Index == "" || cfg.Transform == nil {
+			return fmt.Errorf("backfill requires an index and a transform")
+		}
+		return nil
+	}
+	return m
+}
+
+type backfill struct {
+	client  *elasticsearch.Client
+	cfg     BackfillConfig
+	start   time.Time
+	limiter <-chan time.Time
+
+	total, scanned, updated, skipped, failed int64
+
+	mu       sync.Mutex
+	firstErr error
+}
+
+// RunBackfill scans cfg.Index with a point in time and search_after, applies
+// cfg.Transform to each document and writes the changes with bulk updates. It
+// stops when ctx is cancelled.
+func RunBackfill(ctx context.Context, client *elasticsearch.Client, cfg BackfillConfig) error {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 2
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.KeepAlive == "" {
+		cfg.KeepAlive = "5m"
+	}
+
+	b := &backfill{client: client, cfg: cfg, start: time.Now()}
+	if cfg.RequestsPerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.RequestsPerSecond))
+		defer ticker.Stop()
+		b.limiter = ticker.C
+	}
+
+	pit, err := b.openPIT(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { b.closePIT(pit) }()
+
+	batches := make(chan []Document)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				b.write(ctx, batch)
+			}
+		}()
+	}
+
+	scanErr := b.scan(ctx, &pit, batches)
+	close(batches)
+	wg.Wait()
+
+	if scanErr != nil {
+		return scanErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.failed > 0 {
+		return fmt.Errorf("backfill of %s: %d documents failed, first error: %w", cfg.Index, b.failed, b.firstErr)
+	}
+
+	clientLogf(client)("Backfill of %s: %d scanned, %d updated, %d skipped in %s",
+		cfg.Index, b.scanned, b.updated, b.skipped, time.Since(b.start).Round(time.Millisecond))
+	return nil
+}
+
+func (b *backfill) openPIT(ctx context.Context) (string, error) {
+	res, err := b.client.OpenPointInTime(
+		[]string{b.cfg.Index},
+		b.cfg.KeepAlive,
+		b.client.OpenPointInTime.WithContext(ctx),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error opening point in time on %s: %w", b.cfg.Index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("error opening point in time on %s: %s", b.cfg.Index, res.String())
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing point in time: %w", err)
+	}
+	return result.ID, nil
+}
+
+func (b *backfill) closePIT(pit string) {
+	body := fmt.Sprintf(`{"id": %q}`, pit)
+	res, err := b.client.ClosePointInTime(b.client.ClosePointInTime.WithBody(strings.NewReader(body)))
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+}
+
+// scan pages through the point in time and sends each page to the writers;
+// pit is updated with the id returned by each page
+func (b *backfill) scan(ctx context.Context, pit *string, batches chan<- []Document) error {
+	query := json.RawMessage(`{"match_all": {}}`)
+	if b.cfg.Query != "" {
+		query = json.RawMessage(b.cfg.Query)
+	}
+
+	var searchAfter json.RawMessage
+	for first := true; ; first = false {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		request := map[string]interface{}{
+			"size":  b.cfg.BatchSize,
+			"query": query,
+			"pit":   map[string]string{"id": *pit, "keep_alive": b.cfg.KeepAlive},
+			"sort":  []string{"_shard_doc"},
+		}
+		if first {
+			request["track_total_hits"] = true
+		}
+		if searchAfter != nil {
+			request["search_after"] = searchAfter
+		}
+		data, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("error marshaling backfill query: %w", err)
+		}
+
+		res, err := b.client.Search(
+			b.client.Search.WithContext(ctx),
+			b.client.Search.WithBody(bytes.NewReader(data)),
+		)
+		if err != nil {
+			return fmt.Errorf("error scanning %s: %w", b.cfg.Index, err)
+		}
+
+		var result struct {
+			PitID string `json:"pit_id"`
+			Hits  struct {
+				Total struct {
+					Value int64 `json:"value"`
+				} `json:"total"`
+				Hits []struct {
+					Index  string          `json:"_index"`
+					ID     string          `json:"_id"`
+					Source json.RawMessage `json:"_source"`
+					Sort   json.RawMessage `json:"sort"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return fmt.Errorf("error scanning %s: %s", b.cfg.Index, res.String())
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error parsing backfill page: %w", err)
+		}
+
+		if first {
+			atomic.StoreInt64(&b.total, result.Hits.Total.Value)
+		}
+		if result.PitID != "" {
+			*pit = result.PitID
+		}
+		if len(result.Hits.Hits) == 0 {
+			return nil
+		}
+
+		docs := make([]Document, 0, len(result.Hits.Hits))
+		for _, hit := range result.Hits.Hits {
+			docs = append(docs, Document{Index: hit.Index, ID: hit.ID, Source: hit.Source})
+		}
+		atomic.AddInt64(&b.scanned, int64(len(docs)))
+
+		select {
+		case batches <- docs:
+		case <-ctx.Done():
+			return nil
+		}
+		searchAfter = result.Hits.Hits[len(result.Hits.Hits)-1].Sort
+	}
+}
+
+// write transforms a batch and sends the updates, retrying documents rejected
+// with 429
+func (b *backfill) write(ctx context.Context, docs []Document) {
+	type update struct {
+		doc  Document
+		body []byte
+	}
+
+	var pending []update
+	for _, doc := range docs {
+		fields, err := b.cfg.Transform(doc)
+		if err != nil {
+			b.fail(fmt.Errorf("transform of %s: %w", doc.ID, err), 1)
+			continue
+		}
+		if fields == nil {
+			atomic.AddInt64(&b.skipped, 1)
+			continue
+		}
+		body, err := json.Marshal(map[string]interface{}{"doc": fields})
+		if err != nil {
+			b.fail(fmt.Errorf("marshaling update of %s: %w", doc.ID, err), 1)
+			continue
+		}
+		pending = append(pending, update{doc: doc, body: body})
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > b.cfg.MaxRetries {
+				b.fail(fmt.Errorf("%d documents still rejected after %d retries", len(pending), b.cfg.MaxRetries), int64(len(pending)))
+				break
+			}
+			if !sleepContext(ctx, retryBackoff(attempt)) {
+				return
+			}
+		}
+		if b.limiter != nil {
+			select {
+			case <-b.limiter:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		var body bytes.Buffer
+		for _, u := range pending {
+			meta, _ := json.Marshal(map[string]interface{}{"update": map[string]string{"_index": u.doc.Index, "_id": u.doc.ID}})
+			body.Write(meta)
+			body.WriteByte('\n')
+			body.Write(u.body)
+			body.WriteByte('\n')
+		}
+
+		res, err := b.client.Bulk(&body, b.client.Bulk.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.fail(fmt.Errorf("bulk request: %w", err), int64(len(pending)))
+			break
+		}
+		if res.StatusCode == 429 {
+			res.Body.Close()
+			continue
+		}
+		if res.IsError() {
+			b.fail(fmt.Errorf("bulk request: %s", res.String()), int64(len(pending)))
+			res.Body.Close()
+			break
+		}
+
+		var result struct {
+			Items []map[string]struct {
+				Status int             `json:"status"`
+				Error  json.RawMessage `json:"error"`
+			} `json:"items"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			b.fail(fmt.Errorf("parsing bulk response: %w", err), int64(len(pending)))
+			break
+		}
+
+		var retry []update
+		for i, item := range result.Items {
+			for _, outcome := range item {
+				switch {
+				case outcome.Status == 429 && i < len(pending):
+					retry = append(retry, pending[i])
+				case len(outcome.Error) > 0:
+					b.fail(fmt.Errorf("document %s: %s", pending[i].doc.ID, outcome.Error), 1)
+				default:
+					atomic.AddInt64(&b.updated, 1)
+				}
+			}
+		}
+		pending = retry
+	}
+
+	if b.cfg.Progress != nil {
+		b.cfg.Progress(BackfillProgress{
+			Total:   atomic.LoadInt64(&b.total),
+			Scanned: atomic.LoadInt64(&b.scanned),
+			Updated: atomic.LoadInt64(&b.updated),
+			Skipped: atomic.LoadInt64(&b.skipped),
+			Failed:  atomic.LoadInt64(&b.failed),
+			Elapsed: time.Since(b.start),
+		})
+	}
+}
+
+func (b *backfill) fail(err error, count int64) {
+	atomic.AddInt64(&b.failed, count)
+	b.mu.Lock()
+	if b.firstErr == nil {
+		b.firstErr = err
+	}
+	b.mu.Unlock()
+}
+
+// retryBackoff returns the wait before retry attempt, doubling from 500ms up
+// to 30s
+func retryBackoff(attempt int) time.Duration {
+	wait := 500 * time.Millisecond << (attempt - 1)
+	if wait > 30*time.Second || wait <= 0 {
+		wait = 30 * time.Second
+	}
+	return wait
+}
+
+// sleepContext waits for d and reports false if ctx was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// backfillTransport serves a point in time over docs and accepts bulk
+// updates, rejecting the first bulk request with 429
+type backfillTransport struct {
+	docs int
+
+	mu       sync.Mutex
+	bulks    int
+	updated  map[string]string
+	closedID string
+}
+
+func (b *backfillTransport) Perform(req *http.Request) (*http.Response, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status, body := 200, `{}`
+	var data []byte
+	if req.Body != nil {
+		data, _ = io.ReadAll(req.Body)
+	}
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_pit") && req.Method == http.MethodPost:
+		body = `{"id": "pit-1"}`
+	case req.URL.Path == "/_pit" && req.Method == http.MethodDelete:
+		var payload struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(data, &payload)
+		b.closedID = payload.ID
+	case req.URL.Path == "/_search":
+		var payload struct {
+			Size        int   `json:"size"`
+			SearchAfter []int `json:"search_after"`
+		}
+		json.Unmarshal(data, &payload)
+		from := 0
+		if len(payload.SearchAfter) > 0 {
+			from = payload.SearchAfter[0] + 1
+		}
+		var hits []string
+		for i := from; i < b.docs && i < from+payload.Size; i++ {
+			hits = append(hits, fmt.Sprintf(`{"_index": "articles", "_id": "%d", "_source": {"title": "Article %d"}, "sort": [%d]}`, i, i, i))
+		}
+		body = fmt.Sprintf(`{"pit_id": "pit-2", "hits": {"total": {"value": %d}, "hits": [%s]}}`, b.docs, strings.Join(hits, ","))
+	case req.URL.Path == "/_bulk":
+		b.bulks++
+		if b.bulks == 1 {
+			status, body = 429, `{"error": {"type": "es_rejected_execution_exception"}}`
+			break
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		var items []string
+		for i := 0; i+1 < len(lines); i += 2 {
+			var meta struct {
+				Update struct {
+					ID string `json:"_id"`
+				} `json:"update"`
+			}
+			json.Unmarshal([]byte(lines[i]), &meta)
+			b.updated[meta.Update.ID] = lines[i+1]
+			items = append(items, `{"update": {"status": 200}}`)
+		}
+		body = `{"items": [` + strings.Join(items, ",") + `]}`
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestBackfill(t *testing.T) {
+	transport := &backfillTransport{docs: 7, updated: map[string]string{}}
+
+	var mu sync.Mutex
+	var last BackfillProgress
+	m := Backfill("Backfill slugs", BackfillConfig{
+		Index:     "articles",
+		BatchSize: 3,
+		Transform: func(doc Document) (map[string]interface{}, error) {
+			if doc.ID == "3" {
+				return nil, nil
+			}
+			var source struct {
+				Title string `json:"title"`
+			}
+			if err := json.Unmarshal(doc.Source, &source); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"slug": strings.ToLower(strings.ReplaceAll(source.Title, " ", "-"))}, nil
+		},
+		Progress: func(p BackfillProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if p.Updated+p.Skipped >= last.Updated+last.Skipped {
+				last = p
+			}
+		},
+	})
+
+	if err := m.run(ClientFromTransport(transport), nil); err != nil {
+		t.Fatalf("Failed to backfill: %v", err)
+	}
+
+	if len(transport.updated) != 6 {
+		t.Errorf("Expected 6 updated documents, got %d", len(transport.updated))
+	}
+	if !strings.Contains(transport.updated["5"], `"slug":"article-5"`) {
+		t.Errorf("Expected a partial update with the slug, got %s", transport.updated["5"])
+	}
+	if transport.closedID != "pit-2" {
+		t.Errorf("Expected the latest point in time to be closed, got %q", transport.closedID)
+	}
+	if last.Total != 7 || last.Updated != 6 || last.Skipped != 1 {
+		t.Errorf("Unexpected final progress %+v", last)
+	}
+}
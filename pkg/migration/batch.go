@@ -0,0 +1,148 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Batch groups migrations that must land together, such as changes to
+// several indices read by one release, into a single migration. Its members
+// are applied in order like top-level migrations: each captures its state
+// before it runs, and a failing member is compensated. When one fails, the
+// members applied before it are rolled back in reverse order, from their
+// captured state or with their down functions, and the batch is recorded
+// only once every member is applied. Rolling back the batch rolls back every
+// member.
+//
+// Every member but the last must be able to roll back, see HasDown. The
+// batch is destructive when a member is, and declares the mappings, objects
+// and verifications of its members.
+func Batch(description string, members ...Migration) Migration {
+	members = append([]Migration{}, members...)
+
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		states := make([]json.RawMessage, len(members))
+		for i, member := range members {
+			err := applyMember(client, member, &states[i])
+			if err == nil {
+				continue
+			}
+			err = fmt.Errorf("batch member %s (%s) failed: %w", member.Version(), member.Description, err)
+			if rerr := rollbackMembers(client, members[:i], states[:i]); rerr != nil {
+				return fmt.Errorf("%w; %v", err, rerr)
+			}
+			return err
+		}
+		return nil
+	})
+	m.DownFunc = func(client *elasticsearch.Client) error {
+		return rollbackMembers(client, members, nil)
+	}
+
+	hasher := sha256.New()
+	hasher.Write([]byte(description))
+	captures := false
+	for _, member := range members {
+		m.desired = append(m.desired, member.desired...)
+		m.resources = append(m.resources, member.resources...)
+		m.verifications = append(m.verifications, member.verifications...)
+		m.destructive = m.destructive || member.destructive
+		captures = captures || member.capture != nil
+		hasher.Write([]byte(member.Version()))
+	}
+	m.version = hex.EncodeToString(hasher.Sum(nil))[:8]
+
+	// the record keeps the state of every member, captured before the first
+	// runs, so rolling back the batch restores them
+	if captures {
+		m.capture = func(client *elasticsearch.Client) (json.RawMessage, error) {
+			states := make([]json.RawMessage, len(members))
+			for i, member := range members {
+				if member.capture == nil {
+					continue
+				}
+				state, err := member.capture(client)
+				if err != nil {
+					return nil, fmt.Errorf("batch member %s (%s): %w", member.Version(), member.Description, err)
+				}
+				states[i] = state
+			}
+			return json.Marshal(states)
+		}
+		m.restore = func(client *elasticsearch.Client, state json.RawMessage) error {
+			var states []json.RawMessage
+			if err := json.Unmarshal(state, &states); err != nil {
+				return fmt.Errorf("error parsing batch state: %w", err)
+			}
+			return rollbackMembers(client, members, states)
+		}
+	}
+
+	m.validate = func() error {
+		if len(members) == 0 {
+			return fmt.Errorf("batch %q has no members", description)
+		}
+		var missing []string
+		for i, member := range members {
+			if err := member.Validate(); err != nil {
+				return fmt.Errorf("batch member %s (%s): %w", member.Version(), member.Description, err)
+			}
+			if i < len(members)-1 && !member.HasDown() {
+				missing = append(missing, member.Description)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("batch %q cannot be rolled back: %s have no down function", description, strings.Join(missing, ", "))
+		}
+		return nil
+	}
+	return m
+}
+
+// applyMember captures the state of a batch member into state, then applies
+// it, running its compensations when it fails
+func applyMember(client *elasticsearch.Client, member Migration, state *json.RawMessage) error {
+	if member.capture != nil {
+		captured, err := member.capture(client)
+		if err != nil {
+			return fmt.Errorf("error capturing state: %w", err)
+		}
+		*state = captured
+	}
+
+	err := member.run(client, nil)
+	if err == nil {
+		return nil
+	}
+	if cerr := runCompensations(client, member, clientLogf(client)); cerr != nil {
+		err = fmt.Errorf("%w (compensation failed: %v)", err, cerr)
+	}
+	return err
+}
+
+// rollbackMembers reverts applied batch members in reverse order, from the
+// state each captured when there is one and with its down function otherwise,
+// stopping at the first failure
+func rollbackMembers(client *elasticsearch.Client, applied []Migration, states []json.RawMessage) error {
+	for i := len(applied) - 1; i >= 0; i-- {
+		member := applied[i]
+		var err error
+		switch {
+		case member.restore != nil && i < len(states) && len(states[i]) > 0 && string(states[i]) != "null":
+			err = member.restore(client, states[i])
+		case member.DownFunc != nil:
+			err = member.DownFunc(client)
+		default:
+			return fmt.Errorf("batch member %s (%s) has no down function", member.Version(), member.Description)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to roll back batch member %s (%s): %w", member.Version(), member.Description, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestBatch(t *testing.T) {
+	var calls []string
+	step := func(name string, err error) func(client *elasticsearch.Client) error {
+		return func(client *elasticsearch.Client) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	articles := NewMigration("Add tags to articles", step("articles up", nil)).WithDown(step("articles down", nil))
+	comments := NewMigration("Add tags to comments", step("comments up", nil)).WithDown(step("comments down", nil))
+
+	t.Run("Test Members Are Applied As One Migration", func(t *testing.T) {
+		calls = nil
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store))
+		batch := Batch("Add tags", articles, comments)
+		mm.Register(batch)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if strings.Join(calls, ",") != "articles up,comments up" {
+			t.Errorf("Expected members applied in order, got %v", calls)
+		}
+		records, _ := store.GetApplied()
+		if len(records) != 1 || records[0].Version != batch.Version() {
+			t.Errorf("Expected only the batch recorded, got %+v", records)
+		}
+	})
+
+	t.Run("Test Failed Member Rolls Back Applied Members", func(t *testing.T) {
+		calls = nil
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store))
+		failing := NewMigration("Add tags to users", step("users up", errors.New("mapper conflict")))
+		mm.Register(Batch("Add tags", articles, comments, failing))
+
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "mapper conflict") {
+			t.Fatalf("Expected the member error, got %v", err)
+		}
+		if strings.Join(calls, ",") != "articles up,comments up,users up,comments down,articles down" {
+			t.Errorf("Expected applied members rolled back in reverse, got %v", calls)
+		}
+		if records, _ := store.GetApplied(); len(records) != 0 {
+			t.Errorf("Expected nothing recorded, got %+v", records)
+		}
+	})
+
+	t.Run("Test Members Without Down Fail Validation", func(t *testing.T) {
+		err := Batch("Add tags", NewMigration("Add tags to users", step("users up", nil)), articles).Validate()
+		if err == nil || !strings.Contains(err.Error(), "Add tags to users have no down function") {
+			t.Errorf("Expected a validation error, got %v", err)
+		}
+	})
+
+	// settings captures the value it overwrites and restores it, like
+	// PutClusterSettings, and has no down function
+	value := "old"
+	settings := NewMigration("Raise the shard limit", func(client *elasticsearch.Client) error {
+		calls = append(calls, "settings up")
+		value = "new"
+		return nil
+	})
+	settings.capture = func(client *elasticsearch.Client) (json.RawMessage, error) {
+		return json.Marshal(value)
+	}
+	settings.restore = func(client *elasticsearch.Client, state json.RawMessage) error {
+		calls = append(calls, "settings restore")
+		return json.Unmarshal(state, &value)
+	}
+
+	t.Run("Test Failed Member Restores Captured State", func(t *testing.T) {
+		calls, value = nil, "old"
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(NewMemoryStore()))
+		failing := NewMigration("Add tags to users", step("users up", errors.New("mapper conflict")))
+		batch := Batch("Add tags", settings, articles, failing)
+		if err := batch.Validate(); err != nil {
+			t.Fatalf("Expected a member with restore to be accepted, got %v", err)
+		}
+		mm.Register(batch)
+
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected the member error")
+		}
+		if strings.Join(calls, ",") != "settings up,articles up,users up,articles down,settings restore" {
+			t.Errorf("Expected applied members rolled back in reverse, got %v", calls)
+		}
+		if value != "old" {
+			t.Errorf("Expected the captured value restored, got %q", value)
+		}
+	})
+
+	t.Run("Test Failed Member Is Compensated", func(t *testing.T) {
+		calls = nil
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(NewMemoryStore()))
+		failing := NewMigration("Add tags to users", step("users up", errors.New("mapper conflict"))).
+			OnFailure(step("users compensate", nil))
+		mm.Register(Batch("Add tags", articles, failing))
+
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected the member error")
+		}
+		if strings.Join(calls, ",") != "articles up,users up,users compensate,articles down" {
+			t.Errorf("Expected the failed member compensated before rollback, got %v", calls)
+		}
+	})
+
+	t.Run("Test Rollback Restores Recorded State", func(t *testing.T) {
+		calls, value = nil, "old"
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(NewMemoryStore()))
+		batch := Batch("Add tags", settings, articles)
+		mm.Register(batch)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if err := mm.Rollback(batch.Version()); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		if strings.Join(calls, ",") != "settings up,articles up,articles down,settings restore" {
+			t.Errorf("Expected members rolled back in reverse, got %v", calls)
+		}
+		if value != "old" {
+			t.Errorf("Expected the recorded value restored, got %q", value)
+		}
+	})
+
+	t.Run("Test Version Follows Members", func(t *testing.T) {
+		if Batch("Add tags", articles, comments).Version() == Batch("Add tags", articles).Version() {
+			t.Error("Expected batches of different members to have different versions")
+		}
+	})
+}
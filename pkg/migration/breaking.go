@@ -0,0 +1,79 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/punitsu/elasticmate/pkg/migration/schema"
+)
+
+// BreakingChangeError is returned when a pending migration puts a mapping
+// that Elasticsearch rejects or that would index existing fields differently,
+// such as a new field type or a removed analyzer
+type BreakingChangeError struct {
+	Version     string
+	Description string
+	Index       string
+	Changes     []schema.Change
+}
+
+func (e *BreakingChangeError) Error() string {
+	changes := make([]string, len(e.Changes))
+	for i, change := range e.Changes {
+		changes[i] = change.String()
+	}
+	return fmt.Sprintf("migration %s changes the mapping of %s incompatibly (%s); reindex into a new index instead, "+
+		"e.g. with RebuildIndex or GenerateFromState, or allow breaking changes",
+		e.Version, e.Index, strings.Join(changes, ", "))
+}
+
+// WithAllowBreakingChanges lets RunMigrations put mappings that change
+// existing fields incompatibly. They are refused by default.
+func WithAllowBreakingChanges() Option {
+	return func(mm *MigrationManager) {
+		mm.allowBreaking = true
+	}
+}
+
+// checkBreakingChanges compares the mappings pending migrations merge into
+// their indices, in order, against the live mappings and refuses the first
+// migration changing an existing field incompatibly. Migrations converging to
+// an exact mapping, which reindex when needed, are not refused.
+func (mm *MigrationManager) checkBreakingChanges(migrations []Migration, applied map[string]bool) error {
+	projected := map[string]schema.Mapping{}
+	for _, migration := range migrations {
+		if applied[migration.Version()] {
+			continue
+		}
+		for _, desired := range migration.desired {
+			current, ok := projected[desired.index]
+			if !ok {
+				var err error
+				current, err = schema.Fetch(mm.client(), desired.index)
+				if errors.Is(err, schema.ErrIndexNotFound) {
+					current, err = schema.Mapping{}, nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if desired.exact {
+				projected[desired.index] = desired.mapping
+				continue
+			}
+			next := current.Merge(desired.mapping)
+			if breaking := schema.Compare(current, next).Breaking(); len(breaking) > 0 {
+				return &BreakingChangeError{
+					Version:     migration.Version(),
+					Description: migration.Description,
+					Index:       desired.index,
+					Changes:     breaking,
+				}
+			}
+			projected[desired.index] = next
+		}
+	}
+	return nil
+}
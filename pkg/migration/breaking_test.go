@@ -0,0 +1,65 @@
+package migration
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBreakingChanges(t *testing.T) {
+	live := `{"articles": {"mappings": {"properties": {"title": {"type": "text", "analyzer": "english"}, "views": {"type": "long"}}}}}`
+	newManager := func(opts ...Option) (*MigrationManager, *routeTransport) {
+		transport := &routeTransport{routes: map[string]string{"GET /articles/_mapping": live, "HEAD /articles": `{}`}}
+		return NewMigrationManager(ClientFromTransport(transport), append([]Option{WithStore(NewMemoryStore())}, opts...)...), transport
+	}
+
+	t.Run("Test Compatible Changes Run", func(t *testing.T) {
+		mm, transport := newManager()
+		mm.Register(PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}, "title": {"type": "text", "analyzer": "english", "search_analyzer": "english_search"}}}`))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !transport.sent("PUT /articles/_mapping") {
+			t.Errorf("Expected the mapping to be put, got %v", transport.requests)
+		}
+	})
+
+	for name, mapping := range map[string]string{
+		"Test Type Changes Are Refused":    `{"properties": {"views": {"type": "keyword"}}}`,
+		"Test Removed Analyzer Is Refused": `{"properties": {"title": {"type": "text"}}}`,
+		"Test Later Migration Is Refused":  `{"properties": {"tags": {"type": "keyword"}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			mm, transport := newManager()
+			first := PutMapping("Change articles mapping", "articles", mapping)
+			mm.Register(first)
+			if name == "Test Later Migration Is Refused" {
+				// the field added by the first migration is changed by the second
+				mm.Register(PutMapping("Change tags type", "articles", `{"properties": {"tags": {"type": "text"}}}`))
+			}
+
+			err := mm.RunMigrations()
+			var breaking *BreakingChangeError
+			if !errors.As(err, &breaking) {
+				t.Fatalf("Expected a breaking change error, got %v", err)
+			}
+			if !strings.Contains(err.Error(), "reindex") {
+				t.Errorf("Expected the error to suggest a reindex, got %v", err)
+			}
+			if transport.sent("PUT /articles/_mapping") {
+				t.Errorf("Expected nothing to be applied, got %v", transport.requests)
+			}
+		})
+	}
+
+	t.Run("Test Breaking Changes Can Be Allowed", func(t *testing.T) {
+		mm, transport := newManager(WithAllowBreakingChanges())
+		mm.Register(PutMapping("Change views type", "articles", `{"properties": {"views": {"type": "keyword"}}}`))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !transport.sent("PUT /articles/_mapping") {
+			t.Errorf("Expected the mapping to be put, got %v", transport.requests)
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// taskPollInterval is the wait between Tasks API polls
+var taskPollInterval = time.Second
+
+// taskCancelTimeout bounds the request cancelling an abandoned task
+const taskCancelTimeout = 30 * time.Second
+
+// ConflictError reports documents an update or delete by query could not
+// change because they were modified while the task ran
+type ConflictError struct {
+	Operation string
+	Index     string
+	Conflicts int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s on %s had %d version conflicts", e.Operation, e.Index, e.Conflicts)
+}
+
+// byQueryResult is the response of a completed update by query, delete by
+// query or reindex task
+type byQueryResult struct {
+	Total            int64             `json:"total"`
+	Created          int64             `json:"created"`
+	Updated          int64             `json:"updated"`
+	Deleted          int64             `json:"deleted"`
+	VersionConflicts int64             `json:"version_conflicts"`
+	Failures         []json.RawMessage `json:"failures"`
+}
+
+// UpdateByQuery returns a migration that runs a Painless script on every
+// document of index matching query. The task is cancelled when the migration
+// times out or the run is cancelled.
+func UpdateByQuery(index, query, script string) Migration {
+	description := fmt.Sprintf("Update %s by query %s with %s", index, compactJSON(query), script)
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		return RunUpdateByQuery(clientContext(client), client, index, query, script)
+	})
+	m.validate = func() error {
+		if index == "" || script == "" {
+			return fmt.Errorf("update by query requires an index and a script")
+		}
+		return validQuery(query)
+	}
+	return m
+}
+
+// DeleteByQuery returns a migration that deletes every document of index
+// matching query. The task is cancelled when the migration times out or the
+// run is cancelled.
+func DeleteByQuery(index, query string) Migration {
+	description := fmt.Sprintf("Delete from %s by query %s", index, compactJSON(query))
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		return RunDeleteByQuery(clientContext(client), client, index, query)
+	})
+	m.validate = func() error {
+		if index == "" || query == "" {
+			return fmt.Errorf("delete by query requires an index and a query")
+		}
+		return validQuery(query)
+	}
+	return m.MarkDestructive()
+}
+
+// RunUpdateByQuery submits an update by query task and waits for it to
+// complete. The task is cancelled when ctx is.
+func RunUpdateByQuery(ctx context.Context, client *elasticsearch.Client, index, query, script string) error {
+	body, err := byQueryBody(query, map[string]interface{}{"lang": "painless", "source": script})
+	if err != nil {
+		return err
+	}
+
+	res, err := client.UpdateByQuery(
+		[]string{index},
+		client.UpdateByQuery.WithContext(ctx),
+		client.UpdateByQuery.WithBody(bytes.NewReader(body)),
+		client.UpdateByQuery.WithConflicts("proceed"),
+		client.UpdateByQuery.WithRefresh(true),
+		client.UpdateByQuery.WithSlices("auto"),
+		client.UpdateByQuery.WithWaitForCompletion(false),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating %s by query: %w", index, err)
+	}
+	task, err := submittedTask(res)
+	if err != nil {
+		return fmt.Errorf("error updating %s by query: %w", index, err)
+	}
+
+	result, err := waitForTask(ctx, client, task)
+	if err != nil {
+		return fmt.Errorf("error updating %s by query: %w", index, err)
+	}
+	if err := checkByQueryResult("update by query", index, result); err != nil {
+		return err
+	}
+
+	clientLogf(client)("Updated %d of %d documents in %s", result.Updated, result.Total, index)
+	return nil
+}
+
+// RunDeleteByQuery submits a delete by query task and waits for it to
+// complete. The task is cancelled when ctx is.
+func RunDeleteByQuery(ctx context.Context, client *elasticsearch.Client, index, query string) error {
+	body, err := byQueryBody(query, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.DeleteByQuery(
+		[]string{index},
+		bytes.NewReader(body),
+		client.DeleteByQuery.WithContext(ctx),
+		client.DeleteByQuery.WithConflicts("proceed"),
+		client.DeleteByQuery.WithRefresh(true),
+		client.DeleteByQuery.WithSlices("auto"),
+		client.DeleteByQuery.WithWaitForCompletion(false),
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s by query: %w", index, err)
+	}
+	task, err := submittedTask(res)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s by query: %w", index, err)
+	}
+
+	result, err := waitForTask(ctx, client, task)
+	if err != nil {
+		return fmt.Errorf("error deleting from %s by query: %w", index, err)
+	}
+	if err := checkByQueryResult("delete by query", index, result); err != nil {
+		return err
+	}
+
+	clientLogf(client)("Deleted %d of %d documents in %s", result.Deleted, result.Total, index)
+	return nil
+}
+
+func byQueryBody(query string, script map[string]interface{}) ([]byte, error) {
+	request := map[string]interface{}{}
+	if query != "" {
+		request["query"] = json.RawMessage(query)
+	}
+	if script != nil {
+		request["script"] = script
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling by query request: %w", err)
+	}
+	return body, nil
+}
+
+// submittedTask returns the id of the task started by a request submitted
+// without waiting for completion
+func submittedTask(res *esapi.Response) (string, error) {
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("%s", res.String())
+	}
+	var result struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing task submission: %w", err)
+	}
+	if result.Task == "" {
+		return "", fmt.Errorf("no task id in response")
+	}
+	return result.Task, nil
+}
+
+// waitForTask polls the Tasks API until task completes. When polling is
+// abandoned first, because ctx is done or the task can't be polled, the task
+// is cancelled so it doesn't keep changing documents.
+func waitForTask(ctx context.Context, client *elasticsearch.Client, task string) (*byQueryResult, error) {
+	for {
+		res, err := client.Tasks.Get(task, client.Tasks.Get.WithContext(ctx))
+		if err != nil {
+			cancelTask(client, task)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("error polling task %s: %w", task, err)
+		}
+
+		var status struct {
+			Completed bool            `json:"completed"`
+			Response  *byQueryResult  `json:"response"`
+			Error     json.RawMessage `json:"error"`
+		}
+		if res.IsError() {
+			res.Body.Close()
+			cancelTask(client, task)
+			return nil, fmt.Errorf("error polling task %s: %s", task, res.String())
+		}
+		err = json.NewDecoder(res.Body).Decode(&status)
+		res.Body.Close()
+		if err != nil {
+			cancelTask(client, task)
+			return nil, fmt.Errorf("error parsing task %s: %w", task, err)
+		}
+
+		if status.Completed {
+			if len(status.Error) > 0 {
+				return nil, fmt.Errorf("task %s failed: %s", task, status.Error)
+			}
+			if status.Response == nil {
+				return &byQueryResult{}, nil
+			}
+			return status.Response, nil
+		}
+
+		if !sleepContext(ctx, taskPollInterval) {
+			cancelTask(client, task)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cancelTask cancels task, also after the migration it belongs to has timed
+// out. Failures are logged, as the task can't be waited for anymore.
+func cancelTask(client *elasticsearch.Client, task string) {
+	ctx, cancel := context.WithTimeout(detached(context.Background()), taskCancelTimeout)
+	defer cancel()
+	res, err := client.Tasks.Cancel(client.Tasks.Cancel.WithTaskID(task), client.Tasks.Cancel.WithContext(ctx))
+	if err != nil {
+		clientLogf(client)("Failed to cancel task %s: %v", task, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		clientLogf(client)("Failed to cancel task %s: %s", task, res.String())
+	}
+}
+
+func checkByQueryResult(operation, index string, result *byQueryResult) error {
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("%s on %s had %d failures: %s", operation, index, len(result.Failures), result.Failures[0])
+	}
+	if result.VersionConflicts > 0 {
+		return &ConflictError{Operation: operation, Index: index, Conflicts: result.VersionConflicts}
+	}
+	return nil
+}
+
+func validQuery(query string) error {
+	if query != "" && !json.Valid([]byte(query)) {
+		return fmt.Errorf("invalid query: %s", query)
+	}
+	return nil
+}
+
+// compactJSON strips insignificant whitespace from JSON, returning other
+// input unchanged
+func compactJSON(data string) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(data)); err != nil {
+		return strings.TrimSpace(data)
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// taskTransport accepts by query submissions and reports the task complete
+// after polls requests to the Tasks API
+type taskTransport struct {
+	polls    int
+	response string
+	// pollStatus, when set, fails every poll with that status
+	pollStatus int
+
+	mu        sync.Mutex
+	submitted string
+	polled    int
+	cancelled bool
+}
+
+func (t *taskTransport) Perform(req *http.Request) (*http.Response, error) {
+	// like a network transport, requests of a done context are not sent
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	body, status := `{}`, 200
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_update_by_query"), strings.HasSuffix(req.URL.Path, "/_delete_by_query"):
+		data, _ := io.ReadAll(req.Body)
+		t.submitted = req.URL.Path + "?" + req.URL.RawQuery + " " + string(data)
+		body = `{"task": "node-1:42"}`
+	case req.URL.Path == "/_tasks/node-1:42":
+		t.polled++
+		body = fmt.Sprintf(`{"completed": false, "task": {"node": "node-1", "id": 42, "action": "indices:data/write/update/byquery",
+			"status": {"total": 6, "updated": %d}, "running_time_in_nanos": %d}}`, 2*t.polled, t.polled*int(time.Second))
+		if t.polls > 0 && t.polled >= t.polls {
+			body = `{"completed": true, "response": ` + t.response + `}`
+		}
+		if t.pollStatus != 0 {
+			body, status = `{"error": {"type": "node_not_connected_exception"}}`, t.pollStatus
+		}
+	case req.URL.Path == "/_tasks/node-1:42/_cancel":
+		t.cancelled = true
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func (t *taskTransport) wasCancelled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancelled
+}
+
+func TestByQuery(t *testing.T) {
+	interval := taskPollInterval
+	taskPollInterval = time.Millisecond
+	defer func() { taskPollInterval = interval }()
+
+	t.Run("Test Update By Query Polls Until Complete", func(t *testing.T) {
+		transport := &taskTransport{polls: 3, response: `{"total": 5, "updated": 5}`}
+		m := UpdateByQuery("articles", `{"term": {"status": "draft"}}`, "ctx._source.status = 'review'")
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Failed to validate migration: %v", err)
+		}
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+
+		if transport.polled != 3 {
+			t.Errorf("Expected 3 polls, got %d", transport.polled)
+		}
+		if !strings.Contains(transport.submitted, "wait_for_completion=false") || !strings.Contains(transport.submitted, "ctx._source.status") {
+			t.Errorf("Expected an async request with the script, got %s", transport.submitted)
+		}
+	})
+
+	t.Run("Test Version Conflicts Are Surfaced", func(t *testing.T) {
+		transport := &taskTransport{polls: 1, response: `{"total": 5, "deleted": 3, "version_conflicts": 2}`}
+		err := DeleteByQuery("articles", `{"term": {"status": "spam"}}`).run(ClientFromTransport(transport), nil)
+
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) || conflict.Conflicts != 2 {
+			t.Fatalf("Expected a conflict error with 2 conflicts, got %v", err)
+		}
+	})
+
+	t.Run("Test Cancellation Cancels Task", func(t *testing.T) {
+		transport := &taskTransport{}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := RunDeleteByQuery(ctx, ClientFromTransport(transport), "articles", `{"match_all": {}}`)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected a deadline error, got %v", err)
+		}
+		if !transport.cancelled {
+			t.Error("Expected the task to be cancelled")
+		}
+	})
+
+	t.Run("Test Failed Poll Cancels Task", func(t *testing.T) {
+		transport := &taskTransport{pollStatus: http.StatusInternalServerError}
+		err := RunDeleteByQuery(context.Background(), ClientFromTransport(transport), "articles", `{"match_all": {}}`)
+		if err == nil || !strings.Contains(err.Error(), "error polling task node-1:42") {
+			t.Fatalf("Expected a polling error, got %v", err)
+		}
+		if !transport.wasCancelled() {
+			t.Error("Expected the abandoned task to be cancelled")
+		}
+	})
+
+	t.Run("Test Migration Timeout Cancels Task", func(t *testing.T) {
+		transport := &taskTransport{}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithLogger(log.New(io.Discard, "", 0)))
+		mm.Register(UpdateByQuery("articles", `{"match_all": {}}`, "ctx._source.views = 0").WithTimeout(20 * time.Millisecond))
+
+		if err := mm.RunMigrations(); !errors.Is(err, ErrMigrationTimeout) {
+			t.Fatalf("Expected a timeout error, got %v", err)
+		}
+		// the abandoned migration cancels its task in the background
+		deadline := time.Now().Add(2 * time.Second)
+		for !transport.wasCancelled() {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the task to be cancelled after the timeout")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	t.Run("Test Invalid Query Fails Validation", func(t *testing.T) {
+		if err := DeleteByQuery("articles", `{"term":`).Validate(); err == nil {
+			t.Error("Expected an invalid query to fail validation")
+		}
+	})
+	t.Run("Test Result Goes To The Manager Logger", func(t *testing.T) {
+		var output bytes.Buffer
+		transport := &taskTransport{polls: 1, response: `{"total": 4, "deleted": 4}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithLogger(log.New(&output, "", 0)))
+		mm.Register(DeleteByQuery("articles", `{"term": {"status": "spam"}}`))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !strings.Contains(output.String(), "Deleted 4 of 4 documents in articles") {
+			t.Errorf("Expected the result in the manager log, got %q", output.String())
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Changelog renders the applied migrations as Markdown
+func (mm *MigrationManager) Changelog() (string, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return "", err
+	}
+	return RenderChangelog(records), nil
+}
+
+// RenderChangelog renders migration records as Markdown, one section per run
+// with the most recent run first. Records written before runs were tracked are
+// grouped by the day they were applied.
+func RenderChangelog(records []MigrationRecord) string {
+	type run struct {
+		title   string
+		started time.Time
+		records []MigrationRecord
+	}
+
+	runs := map[string]*run{}
+	for _, record := range records {
+		key, title := record.RunID, "Run "+record.RunID
+		if key == "" {
+			key = record.AppliedAt.UTC().Format("2006-01-02")
+			title = "Applied " + key
+		}
+
+		r, ok := runs[key]
+		if !ok {
+			r = &run{title: title, started: record.AppliedAt}
+			runs[key] = r
+		}
+		if record.AppliedAt.Before(r.started) {
+			r.started = record.AppliedAt
+		}
+		r.records = append(r.records, record)
+	}
+
+	ordered := make([]*run, 0, len(runs))
+	for _, r := range runs {
+		sort.SliceStable(r.records, func(i, j int) bool {
+			return r.records[i].AppliedAt.Before(r.records[j].AppliedAt)
+		})
+		ordered = append(ordered, r)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].started.After(ordered[j].started)
+	})
+
+	var b strings.Builder
+	b.WriteString("# Migration Changelog\n")
+	if len(ordered) == 0 {
+		b.WriteString("\nNo migrations applied.\n")
+	}
+
+	for _, r := range ordered {
+		var total time.Duration
+		for _, record := range r.records {
+			total += time.Duration(record.DurationMS) * time.Millisecond
+		}
+
+		fmt.Fprintf(&b, "\n## %s\n\n", r.title)
+		fmt.Fprintf(&b, "Started %s, %d migrations", r.started.UTC().Format("2006-01-02 15:04 UTC"), len(r.records))
+		if total > 0 {
+			fmt.Fprintf(&b, ", %s", formatDuration(total))
+		}
+		if runner := r.records[0].RunnerMetadata; runner != (RunnerMetadata{}) {
+			fmt.Fprintf(&b, ", %s", runner.describe())
+		}
+		b.WriteString(".\n\n")
+
+		b.WriteString("| Version | Description | Applied | Duration | Indices |\n")
+		b.WriteString("|---------|-------------|---------|----------|---------|\n")
+		for _, record := range r.records {
+			duration := "-"
+			if record.DurationMS > 0 {
+				duration = formatDuration(time.Duration(record.DurationMS) * time.Millisecond)
+			}
+			indices := "-"
+			if len(record.Indices) > 0 {
+				indices = "`" + strings.Join(record.Indices, "`, `") + "`"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n",
+				record.Version,
+				strings.ReplaceAll(record.Description, "|", "\\|"),
+				record.AppliedAt.UTC().Format("15:04:05"),
+				duration,
+				indices,
+			)
+		}
+	}
+
+	return b.String()
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
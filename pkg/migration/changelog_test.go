@@ -0,0 +1,67 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestChangelog(t *testing.T) {
+	t.Run("Test Run Metadata Is Recorded", func(t *testing.T) {
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store))
+		mm.Register(NewMigration("Create articles", func(client *elasticsearch.Client) error {
+			res, err := client.Indices.Create("articles")
+			if err != nil {
+				return err
+			}
+			return res.Body.Close()
+		}))
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		records, err := store.GetApplied()
+		if err != nil {
+			t.Fatalf("Failed to read records: %v", err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record, got %d", len(records))
+		}
+		if records[0].RunID == "" {
+			t.Error("Expected the record to carry a run ID")
+		}
+		if strings.Join(records[0].Indices, ",") != "articles" {
+			t.Errorf("Expected affected indices [articles], got %v", records[0].Indices)
+		}
+	})
+
+	t.Run("Test Markdown Grouped By Run", func(t *testing.T) {
+		base := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+		output := RenderChangelog([]MigrationRecord{
+			{Version: "aaaa0001", Description: "Legacy", AppliedAt: base.AddDate(0, 0, -30)},
+			{Version: "aaaa0002", Description: "Add tags", AppliedAt: base, RunID: "run-1", DurationMS: 1200, Indices: []string{"articles"}},
+			{Version: "aaaa0003", Description: "Backfill | tags", AppliedAt: base.Add(time.Second), RunID: "run-1", DurationMS: 300},
+			{Version: "aaaa0004", Description: "Drop legacy", AppliedAt: base.AddDate(0, 0, 1), RunID: "run-2"},
+		})
+
+		for _, expected := range []string{
+			"## Run run-2",
+			"## Run run-1",
+			"## Applied 2024-04-15",
+			"| `aaaa0002` | Add tags | 12:00:00 | 1.2s | `articles` |",
+			"Backfill \\| tags",
+			"2 migrations, 1.5s",
+		} {
+			if !strings.Contains(output, expected) {
+				t.Errorf("Expected changelog to contain %q, got:\n%s", expected, output)
+			}
+		}
+		if strings.Index(output, "run-2") > strings.Index(output, "run-1") {
+			t.Errorf("Expected the most recent run first, got:\n%s", output)
+		}
+	})
+}
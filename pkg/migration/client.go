@@ -0,0 +1,108 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ClientConfig configures the client NewClient connects with. At most one of
+// basic auth, APIKey and BearerToken may be set. Credentials may be
+// ${secret:REF} placeholders, resolved with Secrets.
+type ClientConfig struct {
+	// Addresses are the cluster URLs, http://localhost:9200 by default
+	Addresses []string
+	// CloudID connects to an Elastic Cloud deployment instead of Addresses
+	CloudID string
+
+	Username string
+	Password string
+	// APIKey is the base64 encoded id:api_key pair returned by the create
+	// API key API
+	APIKey string
+	// BearerToken is sent as an Authorization: Bearer header, e.g. a service
+	// account token
+	BearerToken string
+	// Secrets resolves ${secret:REF} placeholders in the credentials
+	Secrets SecretProvider
+
+	// CACert is a PEM encoded certificate authority the cluster
+	// certificate is verified with. CACertFile is read when it is empty.
+	CACert     []byte
+	CACertFile string
+
+	// OpenSearch connects to an OpenSearch cluster, see NewOpenSearchClient
+	OpenSearch bool
+
+	// Transport is an optional HTTP transport
+	Transport http.RoundTripper
+}
+
+// NewClient returns a client for the cluster and credentials in cfg
+func NewClient(cfg ClientConfig) (*elasticsearch.Client, error) {
+	if err := cfg.resolveCredentials(); err != nil {
+		return nil, err
+	}
+	credentials := 0
+	for _, set := range []bool{cfg.Username != "" || cfg.Password != "", cfg.APIKey != "", cfg.BearerToken != ""} {
+		if set {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return nil, errors.New("set only one of username and password, API key and bearer token")
+	}
+	if cfg.CloudID != "" && len(cfg.Addresses) > 0 {
+		return nil, errors.New("set either a Cloud ID or addresses, not both")
+	}
+
+	caCert := cfg.CACert
+	if len(caCert) == 0 && cfg.CACertFile != "" {
+		var err error
+		caCert, err = os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificate: %w", err)
+		}
+	}
+
+	if cfg.OpenSearch {
+		if cfg.CloudID != "" || cfg.APIKey != "" || cfg.BearerToken != "" {
+			return nil, errors.New("OpenSearch clusters support only basic auth")
+		}
+		return NewOpenSearchClient(OpenSearchConfig{
+			Addresses: cfg.Addresses,
+			Username:  cfg.Username,
+			Password:  cfg.Password,
+			CACert:    caCert,
+			Transport: cfg.Transport,
+		})
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    cfg.Addresses,
+		CloudID:      cfg.CloudID,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		APIKey:       cfg.APIKey,
+		ServiceToken: cfg.BearerToken,
+		CACert:       caCert,
+		Transport:    cfg.Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Elasticsearch client: %w", err)
+	}
+	return client, nil
+}
+
+// NewMigrationManagerFromConfig connects to the cluster in cfg and returns a
+// manager using the connection
+func NewMigrationManagerFromConfig(cfg ClientConfig, opts ...Option) (*MigrationManager, error) {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrationManager(client, opts...), nil
+}
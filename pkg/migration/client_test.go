@@ -0,0 +1,117 @@
+package migration
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// authTransport records the URL and Authorization header of the last request.
+// Like OpenSearch, it omits the Elasticsearch product header when opensearch
+// is set.
+type authTransport struct {
+	opensearch    bool
+	url           string
+	authorization string
+}
+
+func (a *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	a.url = req.URL.String()
+	a.authorization = req.Header.Get("Authorization")
+	header := http.Header{"X-Elastic-Product": []string{"Elasticsearch"}}
+	if a.opensearch {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+func TestNewClient(t *testing.T) {
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("elastic:changeme"))
+	for name, tc := range map[string]struct {
+		cfg           ClientConfig
+		authorization string
+	}{
+		"Test Basic Auth":   {ClientConfig{Username: "elastic", Password: "changeme"}, basic},
+		"Test API Key":      {ClientConfig{APIKey: "a2V5OnNlY3JldA=="}, "APIKey a2V5OnNlY3JldA=="},
+		"Test Bearer Token": {ClientConfig{BearerToken: "token"}, "Bearer token"},
+		"Test OpenSearch":   {ClientConfig{Username: "elastic", Password: "changeme", OpenSearch: true}, basic},
+	} {
+		t.Run(name, func(t *testing.T) {
+			transport := &authTransport{opensearch: tc.cfg.OpenSearch}
+			tc.cfg.Transport = transport
+			mm, err := NewMigrationManagerFromConfig(tc.cfg, WithStore(NewMemoryStore()))
+			if err != nil {
+				t.Fatalf("Failed to create manager: %v", err)
+			}
+			if _, err := IndexExists(mm.Client, "articles"); err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if transport.authorization != tc.authorization {
+				t.Errorf("Expected authorization %q, got %q", tc.authorization, transport.authorization)
+			}
+		})
+	}
+
+	t.Run("Test Cloud ID", func(t *testing.T) {
+		transport := &authTransport{}
+		cloudID := "prod:" + base64.StdEncoding.EncodeToString([]byte("eu-west-1.aws.found.io$abc123$def456"))
+		client, err := NewClient(ClientConfig{CloudID: cloudID, APIKey: "key", Transport: transport})
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		if _, err := IndexExists(client, "articles"); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if !strings.HasPrefix(transport.url, "https://abc123.eu-west-1.aws.found.io") {
+			t.Errorf("Expected the deployment URL, got %s", transport.url)
+		}
+	})
+
+	for name, cfg := range map[string]ClientConfig{
+		"Test Conflicting Credentials Are Refused": {Username: "elastic", APIKey: "key"},
+		"Test Cloud ID And Addresses Are Refused":  {CloudID: "prod:abc", Addresses: []string{"http://localhost:9200"}},
+		"Test OpenSearch API Key Is Refused":       {APIKey: "key", OpenSearch: true},
+		"Test Missing CA Certificate Is Reported":  {CACertFile: "testdata/missing.pem"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewClient(cfg); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	t.Run("Test OpenSearch Migrations Without The Product Header", func(t *testing.T) {
+		transport := &authTransport{opensearch: true}
+		mm, err := NewMigrationManagerFromConfig(ClientConfig{OpenSearch: true, Transport: transport}, WithStore(NewMemoryStore()), WithLogger(log.New(io.Discard, "", 0)))
+		if err != nil {
+			t.Fatalf("Failed to create manager: %v", err)
+		}
+		mm.Register(NewMigration("Create articles index", func(client *elasticsearch.Client) error {
+			res, err := client.Indices.Create("articles")
+			if err != nil {
+				return err
+			}
+			return res.Body.Close()
+		}))
+		mm.Register(NewTypedMigration("Create users index", func(client *elasticsearch.TypedClient) error {
+			_, err := client.Indices.Create("users").Do(context.Background())
+			return err
+		}))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Expected migrations to run against OpenSearch, got %v", err)
+		}
+		if applied, _ := mm.GetAppliedMigrations(); len(applied) != 2 {
+			t.Errorf("Expected both migrations to be applied, got %v", applied)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ClusterSettings are persistent and transient cluster settings, with flat
+// keys such as "search.max_buckets" or
+// "cluster.routing.allocation.disk.watermark.low". A nil value resets the
+// setting to its default.
+type ClusterSettings struct {
+	Persistent map[string]interface{} `json:"persistent,omitempty"`
+	Transient  map[string]interface{} `json:"transient,omitempty"`
+}
+
+// PutClusterSettings returns a migration that updates cluster settings. The
+// previous values of the changed settings are stored in the migration record,
+// and Rollback restores them.
+func PutClusterSettings(description string, settings ClusterSettings) Migration {
+	settings = ClusterSettings{
+		Persistent: flattenSettings(settings.Persistent),
+		Transient:  flattenSettings(settings.Transient),
+	}
+
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		if err := putClusterSettings(client, settings); err != nil {
+			return err
+		}
+		clientLogf(client)("Updated cluster settings %s", strings.Join(settings.keys(), ", "))
+		return nil
+	})
+	m.capture = func(client *elasticsearch.Client) (json.RawMessage, error) {
+		current, err := GetClusterSettings(client)
+		if err != nil {
+			return nil, err
+		}
+		previous := ClusterSettings{
+			Persistent: previousValues(current.Persistent, settings.Persistent),
+			Transient:  previousValues(current.Transient, settings.Transient),
+		}
+		return json.Marshal(previous)
+	}
+	m.restore = func(client *elasticsearch.Client, state json.RawMessage) error {
+		var previous ClusterSettings
+		if err := json.Unmarshal(state, &previous); err != nil {
+			return fmt.Errorf("error parsing previous cluster settings: %w", err)
+		}
+		if err := putClusterSettings(client, previous); err != nil {
+			return err
+		}
+		clientLogf(client)("Restored cluster settings %s", strings.Join(previous.keys(), ", "))
+		return nil
+	}
+	m.validate = func() error {
+		if len(settings.Persistent) == 0 && len(settings.Transient) == 0 {
+			return fmt.Errorf("cluster settings change requires persistent or transient settings")
+		}
+		return nil
+	}
+	return m
+}
+
+// GetClusterSettings returns the explicitly set cluster settings with flat keys
+func GetClusterSettings(client *elasticsearch.Client) (*ClusterSettings, error) {
+	res, err := client.Cluster.GetSettings(client.Cluster.GetSettings.WithFlatSettings(true))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster settings: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading cluster settings: %s", res.String())
+	}
+
+	var settings ClusterSettings
+	if err := json.NewDecoder(res.Body).Decode(&settings); err != nil {
+		return nil, fmt.Errorf("error parsing cluster settings: %w", err)
+	}
+	return &settings, nil
+}
+
+func putClusterSettings(client *elasticsearch.Client, settings ClusterSettings) error {
+	body := map[string]interface{}{}
+	if len(settings.Persistent) > 0 {
+		body["persistent"] = settings.Persistent
+	}
+	if len(settings.Transient) > 0 {
+		body["transient"] = settings.Transient
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshaling cluster settings: %w", err)
+	}
+
+	res, err := client.Cluster.PutSettings(strings.NewReader(string(data)))
+	if err != nil {
+		return fmt.Errorf("error updating cluster settings: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error updating cluster settings: %s", res.String())
+	}
+	return nil
+}
+
+// previousValues returns the current value of every key in changed, nil for
+// keys that are not set
+func previousValues(current, changed map[string]interface{}) map[string]interface{} {
+	if len(changed) == 0 {
+		return nil
+	}
+	previous := make(map[string]interface{}, len(changed))
+	for key := range changed {
+		previous[key] = current[key]
+	}
+	return previous
+}
+
+func (s ClusterSettings) keys() []string {
+	var keys []string
+	for key := range s.Persistent {
+		keys = append(keys, key)
+	}
+	for key := range s.Transient {
+		keys = append(keys, key+" (transient)")
+	}
+	sort.Strings(keys)
+	return keys
+}
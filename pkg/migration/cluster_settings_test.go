@@ -0,0 +1,87 @@
+package migration
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// clusterTransport keeps persistent cluster settings, dropping null values as
+// Elasticsearch does
+type clusterTransport struct {
+	persistent map[string]interface{}
+}
+
+func (c *clusterTransport) Perform(req *http.Request) (*http.Response, error) {
+	body := `{}`
+	if req.URL.Path == "/_cluster/settings" {
+		if req.Method == http.MethodPut {
+			var update ClusterSettings
+			data, _ := io.ReadAll(req.Body)
+			json.Unmarshal(data, &update)
+			for key, value := range update.Persistent {
+				if value == nil {
+					delete(c.persistent, key)
+				} else {
+					c.persistent[key] = value
+				}
+			}
+		}
+		data, _ := json.Marshal(map[string]interface{}{"persistent": c.persistent, "transient": map[string]interface{}{}})
+		body = string(data)
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestClusterSettings(t *testing.T) {
+	transport := &clusterTransport{persistent: map[string]interface{}{"search.max_buckets": "65536"}}
+	store := NewMemoryStore()
+	mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store))
+
+	m := PutClusterSettings("Raise bucket limit", ClusterSettings{
+		Persistent: map[string]interface{}{
+			"search.max_buckets": 100000,
+			"cluster":            map[string]interface{}{"routing.allocation.disk.watermark.low": "90%"},
+		},
+	})
+	mm.Register(m)
+
+	t.Run("Test Previous Values Are Recorded", func(t *testing.T) {
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if transport.persistent["cluster.routing.allocation.disk.watermark.low"] != "90%" {
+			t.Errorf("Expected the watermark to be set, got %v", transport.persistent)
+		}
+
+		records, _ := store.GetApplied()
+		if len(records) != 1 {
+			t.Fatalf("Expected 1 record, got %d", len(records))
+		}
+		var previous ClusterSettings
+		if err := json.Unmarshal(records[0].State, &previous); err != nil {
+			t.Fatalf("Failed to parse recorded state: %v", err)
+		}
+		if previous.Persistent["search.max_buckets"] != "65536" || previous.Persistent["cluster.routing.allocation.disk.watermark.low"] != nil {
+			t.Errorf("Unexpected previous values: %v", previous.Persistent)
+		}
+	})
+
+	t.Run("Test Rollback Restores Previous Values", func(t *testing.T) {
+		if err := mm.Rollback(m.Version()); err != nil {
+			t.Fatalf("Failed to roll back migration: %v", err)
+		}
+		if len(transport.persistent) != 1 || transport.persistent["search.max_buckets"] != "65536" {
+			t.Errorf("Expected the original settings, got %v", transport.persistent)
+		}
+		if records, _ := store.GetApplied(); len(records) != 0 {
+			t.Errorf("Expected the record to be removed, got %v", records)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// CodecChange configures a ChangeCodec migration
+type CodecChange struct {
+	Index string
+	// Codec is the new index.codec, e.g. "best_compression"
+	Codec string
+
+	// Rebuild copies the data into a new index created with the codec instead
+	// of closing and reopening Index. It requires Target and usually Alias.
+	Rebuild bool
+	Target  string
+	Alias   string
+
+	// ForceMerge rewrites existing segments with the new codec after an
+	// in-place change. Without it only newly written segments are compressed.
+	ForceMerge bool
+}
+
+// ChangeCodec returns a migration that changes index.codec, either in place
+// through close/update/open or by rebuilding into a new index, and reports the
+// store size before and after the change
+func ChangeCodec(description string, change CodecChange) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		if change.Index == "" || change.Codec == "" {
+			return fmt.Errorf("codec change requires an index and a codec")
+		}
+
+		before, err := IndexStoreSize(client, change.Index)
+		if err != nil {
+			return err
+		}
+
+		target := change.Index
+		if change.Rebuild {
+			if change.Target == "" {
+				return fmt.Errorf("codec rebuild of %s requires a target index", change.Index)
+			}
+			target = change.Target
+			err = RebuildIndex(client, RebuildConfig{
+				Source:   change.Index,
+				Target:   change.Target,
+				Alias:    change.Alias,
+				Settings: map[string]interface{}{"index.codec": change.Codec},
+			})
+		} else {
+			err = changeCodecInPlace(client, change)
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := IndexStoreSize(client, target)
+		if err != nil {
+			return err
+		}
+
+		clientLogf(client)("Codec of %s changed to %s: %s -> %s", target, change.Codec, formatBytes(before), formatBytes(after))
+		return nil
+	})
+}
+
+func changeCodecInPlace(client *elasticsearch.Client, change CodecChange) error {
+	if err := closeIndex(client, change.Index); err != nil {
+		return err
+	}
+
+	settings := fmt.Sprintf(`{"index": {"codec": %q}}`, change.Codec)
+	res, err := client.Indices.PutSettings(
+		strings.NewReader(settings),
+		client.Indices.PutSettings.WithIndex(change.Index),
+	)
+	if err != nil {
+		openIndex(client, change.Index)
+		return fmt.Errorf("error updating codec of %s: %w", change.Index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		openIndex(client, change.Index)
+		return fmt.Errorf("error updating codec of %s: %s", change.Index, res.String())
+	}
+
+	if err := openIndex(client, change.Index); err != nil {
+		return err
+	}
+
+	if change.ForceMerge {
+		res, err := client.Indices.Forcemerge(
+			client.Indices.Forcemerge.WithIndex(change.Index),
+			client.Indices.Forcemerge.WithMaxNumSegments(1),
+		)
+		if err != nil {
+			return fmt.Errorf("error force merging %s: %w", change.Index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error force merging %s: %s", change.Index, res.String())
+		}
+	}
+
+	return nil
+}
+
+func closeIndex(client *elasticsearch.Client, index string) error {
+	res, err := client.Indices.Close([]string{index})
+	if err != nil {
+		return fmt.Errorf("error closing index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error closing index %s: %s", index, res.String())
+	}
+	return nil
+}
+
+// openIndex reopens index and waits for its primaries to be active
+func openIndex(client *elasticsearch.Client, index string) error {
+	res, err := client.Indices.Open([]string{index}, client.Indices.Open.WithWaitForActiveShards("1"))
+	if err != nil {
+		return fmt.Errorf("error opening index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error opening index %s: %s", index, res.String())
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
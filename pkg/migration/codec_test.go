@@ -0,0 +1,83 @@
+package migration_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestChangeCodec(t *testing.T) {
+	stats := `{"_all": {"primaries": {"store": {"size_in_bytes": 2048}}}}`
+
+	t.Run("Test Codec Changed In Place", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles", `{}`)
+		es.Respond("GET", "/articles/_stats/store", 200, stats)
+		var output bytes.Buffer
+		mm := es.Manager(migration.WithStore(migration.NewMemoryStore()), migration.WithLogger(log.New(&output, "", 0)))
+		mm.Register(migration.ChangeCodec("Compress articles", migration.CodecChange{
+			Index:      "articles",
+			Codec:      "best_compression",
+			ForceMerge: true,
+		}))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		if codec := es.Setting("articles", "index.codec"); codec != "best_compression" {
+			t.Errorf("Expected the codec to be updated, got %v", codec)
+		}
+		for _, path := range []string{"/articles/_close", "/articles/_open", "/articles/_forcemerge"} {
+			if !es.Sent("POST", path) {
+				t.Errorf("Expected POST %s, got %v", path, es.Requests())
+			}
+		}
+		if !strings.Contains(output.String(), "Codec of articles changed to best_compression: 2.0 KiB -> 2.0 KiB") {
+			t.Errorf("Expected the sizes in the manager log, got %q", output.String())
+		}
+	})
+
+	t.Run("Test Codec Changed By Rebuild", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles_v1", `{"aliases": {"articles": {}}}`)
+		es.AddDocument("articles_v1", "1", map[string]interface{}{"title": "Hello"})
+		es.Respond("GET", "/articles_v1/_stats/store", 200, stats)
+		es.Respond("GET", "/articles_v2/_stats/store", 200, stats)
+		mm := es.Manager(migration.WithStore(migration.NewMemoryStore()), migration.WithLogger(log.New(&bytes.Buffer{}, "", 0)))
+		mm.Register(migration.ChangeCodec("Compress articles", migration.CodecChange{
+			Index:   "articles_v1",
+			Codec:   "best_compression",
+			Rebuild: true,
+			Target:  "articles_v2",
+			Alias:   "articles",
+		}))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		if codec := es.Setting("articles_v2", "index.codec"); codec != "best_compression" {
+			t.Errorf("Expected the target to be created with the codec, got %v", codec)
+		}
+		if docs := es.Documents("articles_v2"); len(docs) != 1 {
+			t.Errorf("Expected the documents to be copied, got %v", docs)
+		}
+		if es.Sent("POST", "/articles_v1/_close") {
+			t.Error("Expected the source to stay open")
+		}
+	})
+
+	t.Run("Test Rebuild Requires A Target", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles", `{}`)
+		es.Respond("GET", "/articles/_stats/store", 200, stats)
+		mm := es.Manager(migration.WithStore(migration.NewMemoryStore()))
+		mm.Register(migration.ChangeCodec("Compress articles", migration.CodecChange{Index: "articles", Codec: "best_compression", Rebuild: true}))
+		if err := mm.RunMigrations(); err == nil || !strings.Contains(err.Error(), "requires a target") {
+			t.Errorf("Expected a missing target to fail, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Compensation undoes the partial effect of a migration whose function
+// failed, such as deleting an index it created before failing
+type Compensation func(client *elasticsearch.Client) error
+
+// OnFailure registers compensating actions that run when the migration
+// function fails, so a failed run leaves the cluster clean for a retry. They
+// run in reverse order of registration, each one even when an earlier one
+// fails.
+func (m Migration) OnFailure(compensations ...Compensation) Migration {
+	m.compensations = append(append([]Compensation{}, m.compensations...), compensations...)
+	return m
+}
+
+// RollbackOnFailure runs the down function of the migration when its function
+// fails, after the compensating actions registered with OnFailure. The down
+// function must then tolerate a partially applied migration.
+func (m Migration) RollbackOnFailure() Migration {
+	m.downOnFailure = true
+	return m
+}
+
+// DropIndex returns a compensating action that deletes index, for indices the
+// migration creates. A missing index is not an error.
+func DropIndex(index string) Compensation {
+	return func(client *elasticsearch.Client) error {
+		res, err := client.Indices.Delete([]string{index})
+		if err != nil {
+			return fmt.Errorf("error deleting index %s: %w", index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() && res.StatusCode != 404 {
+			return fmt.Errorf("error deleting index %s: %s", index, res.String())
+		}
+		return nil
+	}
+}
+
+// compensate runs the compensating actions of a failed migration and returns
+// their errors joined
+func (mm *MigrationManager) compensate(migration Migration) error {
+	return runCompensations(mm.client(), migration, mm.logf)
+}
+
+// runCompensations runs the compensating actions of a failed migration with client,
+// logging through logf, and returns their errors joined
+func runCompensations(client *elasticsearch.Client, migration Migration, logf func(string, ...interface{})) error {
+	compensations := make([]Compensation, 0, len(migration.compensations)+1)
+	for i := len(migration.compensations) - 1; i >= 0; i-- {
+		compensations = append(compensations, migration.compensations[i])
+	}
+	if migration.downOnFailure && migration.DownFunc != nil {
+		compensations = append(compensations, migration.DownFunc)
+	}
+	if len(compensations) == 0 {
+		return nil
+	}
+
+	logf("Compensating failed migration %s", migration.Version())
+	var errs []error
+	for _, compensation := range compensations {
+		if err := compensation(client); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		logf("Compensation of migration %s failed: %v", migration.Version(), err)
+		return err
+	}
+	logf("Migration %s compensated", migration.Version())
+	return nil
+}
@@ -0,0 +1,74 @@
+package migration
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestCompensation(t *testing.T) {
+	var calls []string
+	step := func(name string, err error) func(client *elasticsearch.Client) error {
+		return func(client *elasticsearch.Client) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	failing := func(client *elasticsearch.Client) error {
+		if _, err := client.Indices.Create("articles_v2"); err != nil {
+			return err
+		}
+		return errors.New("reindex failed")
+	}
+
+	t.Run("Test Compensations Run On Failure", func(t *testing.T) {
+		calls = nil
+		transport := &routeTransport{}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+		mm.Register(NewMigration("Rebuild articles", failing).
+			OnFailure(DropIndex("articles_v2"), step("first", nil), step("second", nil)).
+			WithDown(step("down", nil)).
+			RollbackOnFailure())
+
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "reindex failed") {
+			t.Fatalf("Expected the migration error, got %v", err)
+		}
+		if strings.Join(calls, ",") != "second,first,down" {
+			t.Errorf("Expected compensations in reverse order then down, got %v", calls)
+		}
+		if !transport.sent("DELETE /articles_v2") {
+			t.Errorf("Expected the created index deleted, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Compensation Errors Are Reported", func(t *testing.T) {
+		calls = nil
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(NewMemoryStore()))
+		mm.Register(NewMigration("Rebuild articles", failing).
+			OnFailure(step("first", nil), step("second", errors.New("delete refused"))))
+
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "compensation failed: delete refused") {
+			t.Fatalf("Expected the compensation error, got %v", err)
+		}
+		if len(calls) != 2 {
+			t.Errorf("Expected every compensation to run, got %v", calls)
+		}
+	})
+
+	t.Run("Test Successful Migration Is Not Compensated", func(t *testing.T) {
+		calls = nil
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(NewMemoryStore()))
+		mm.Register(NewMigration("Create articles", step("up", nil)).OnFailure(step("compensate", nil)))
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if strings.Join(calls, ",") != "up" {
+			t.Errorf("Expected no compensation, got %v", calls)
+		}
+	})
+}
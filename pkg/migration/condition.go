@@ -0,0 +1,92 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Condition decides at run time whether a migration applies, for example
+// only when an index holds documents
+type Condition func(ctx context.Context, client *elasticsearch.Client) (bool, error)
+
+// WithCondition runs the migration only when every condition holds. A
+// migration whose condition does not hold is recorded as skipped, so it is
+// not evaluated again, and reported as skipped by condition in the history.
+func (m Migration) WithCondition(conditions ...Condition) Migration {
+	m.conditions = append(append([]Condition{}, m.conditions...), conditions...)
+	return m
+}
+
+// IndexHasDocuments returns a condition that holds when index exists and
+// holds at least one document
+func IndexHasDocuments(index string) Condition {
+	return func(ctx context.Context, client *elasticsearch.Client) (bool, error) {
+		res, err := client.Count(client.Count.WithContext(ctx), client.Count.WithIndex(index))
+		if err != nil {
+			return false, fmt.Errorf("error counting documents of %s: %w", index, err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode == 404 {
+			return false, nil
+		}
+		if res.IsError() {
+			return false, fmt.Errorf("error counting documents of %s: %s", index, res.String())
+		}
+		var result struct {
+			Count int64 `json:"count"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return false, fmt.Errorf("error parsing document count of %s: %w", index, err)
+		}
+		return result.Count > 0, nil
+	}
+}
+
+// NodeAttribute returns a condition that holds when a node of the cluster
+// has the custom attribute name set to value, e.g. "zone" or "data_tier"
+// set with node.attr
+func NodeAttribute(name, value string) Condition {
+	return func(ctx context.Context, client *elasticsearch.Client) (bool, error) {
+		res, err := client.Nodes.Info(client.Nodes.Info.WithContext(ctx), client.Nodes.Info.WithMetric("attributes"))
+		if err != nil {
+			return false, fmt.Errorf("error reading node attributes: %w", err)
+		}
+		defer res.Body.Close()
+
+		if res.IsError() {
+			return false, fmt.Errorf("error reading node attributes: %s", res.String())
+		}
+		var result struct {
+			Nodes map[string]struct {
+				Attributes map[string]string `json:"attributes"`
+			} `json:"nodes"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return false, fmt.Errorf("error parsing node attributes: %w", err)
+		}
+		for _, node := range result.Nodes {
+			if node.Attributes[name] == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// conditionsHold reports whether every condition of the migration holds
+func (mm *MigrationManager) conditionsHold(ctx context.Context, migration Migration) (bool, error) {
+	for _, condition := range migration.conditions {
+		holds, err := condition(ctx, mm.client())
+		if err != nil {
+			return false, fmt.Errorf("error evaluating condition of migration %s: %w", migration.Version(), err)
+		}
+		if !holds {
+			return false, nil
+		}
+	}
+	return true, nil
+}
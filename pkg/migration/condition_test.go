@@ -0,0 +1,84 @@
+package migration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestConditions(t *testing.T) {
+	transport := &routeTransport{routes: map[string]string{
+		"POST /articles/_count": `{"count": 12}`,
+		"POST /drafts/_count":   `{"count": 0}`,
+		"GET /_nodes/attributes": `{"nodes": {
+			"n1": {"attributes": {"zone": "eu-west-1a"}},
+			"n2": {"attributes": {"zone": "eu-west-1b", "data_tier": "warm"}}
+		}}`,
+	}}
+	client := ClientFromTransport(transport)
+
+	for _, test := range []struct {
+		name      string
+		condition Condition
+		expected  bool
+	}{
+		{"Test Index With Documents", IndexHasDocuments("articles"), true},
+		{"Test Empty Index", IndexHasDocuments("drafts"), false},
+		{"Test Missing Index", IndexHasDocuments("comments"), false},
+		{"Test Node Attribute Set", NodeAttribute("data_tier", "warm"), true},
+		{"Test Node Attribute Not Set", NodeAttribute("data_tier", "cold"), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			holds, err := test.condition(context.Background(), client)
+			if err != nil {
+				t.Fatalf("Failed to evaluate condition: %v", err)
+			}
+			if holds != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, holds)
+			}
+		})
+	}
+}
+
+func TestConditionalMigrations(t *testing.T) {
+	store := NewMemoryStore()
+	mm := NewMigrationManager(nil, WithStore(store))
+
+	ran := 0
+	up := func(client *elasticsearch.Client) error {
+		ran++
+		return nil
+	}
+	holds := func(result bool) Condition {
+		return func(ctx context.Context, client *elasticsearch.Client) (bool, error) {
+			return result, nil
+		}
+	}
+	mm.Register(NewMigration("Backfill articles", up).WithCondition(holds(true)))
+	skipped := NewMigration("Backfill drafts", up).WithCondition(holds(true), holds(false))
+	mm.Register(skipped)
+
+	summary, err := mm.RunMigrationsSummary(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if ran != 1 || len(summary.Applied) != 1 || len(summary.Skipped) != 1 {
+		t.Fatalf("Expected one migration applied and one skipped, got %d runs and %+v", ran, summary)
+	}
+
+	entries, err := mm.History(HistoryFilter{Statuses: []HistoryStatus{HistorySkipped}})
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Version != skipped.Version() || !entries[0].Skipped {
+		t.Errorf("Expected the skipped migration in the history, got %+v", entries)
+	}
+
+	if err := mm.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations again: %v", err)
+	}
+	if ran != 1 {
+		t.Errorf("Expected the skipped migration not to be evaluated again, ran %d times", ran)
+	}
+}
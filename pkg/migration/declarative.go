@@ -0,0 +1,405 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"gopkg.in/yaml.v3"
+)
+
+// Declaration is a migration described in a YAML or JSON file instead of Go
+// code, in the format written by `elasticmate introspect -format yaml`:
+//
+//	description: Create articles index
+//	action: create_index
+//	index: articles
+//	body:
+//	  mappings:
+//	    properties:
+//	      title:
+//	        type: text
+type Declaration struct {
+	Description string `yaml:"description"`
+	// Action is one of create_index, delete_index, put_mapping, put_settings,
+	// update_by_query or delete_by_query
+	Action string `yaml:"action"`
+	Index  string `yaml:"index"`
+	// Body is the create index body for create_index, the mapping for
+	// put_mapping and the settings for put_settings
+	Body map[string]interface{} `yaml:"body"`
+	// Query and Script are used by the by query actions
+	Query  map[string]interface{} `yaml:"query"`
+	Script string                 `yaml:"script"`
+	// AllowDowntime permits put_settings to close the index for static
+	// settings
+	AllowDowntime bool `yaml:"allow_downtime"`
+	// Repeatable runs the migration again whenever the declaration changes
+	Repeatable bool `yaml:"repeatable"`
+	// DependsOn lists the descriptions or versions of migrations applied
+	// before this one
+	DependsOn []string `yaml:"depends_on" json:",omitempty"`
+
+	// serverlessNoop is set on put_settings declarations whose settings were
+	// all dropped for Serverless
+	serverlessNoop bool
+	// secrets maps the secrets resolved into the declaration to their
+	// placeholders, so plans do not show them
+	secrets map[string]string
+}
+
+// declarationExtensions are the file extensions LoadFS reads
+var declarationExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// LoadFS reads every .yaml, .yml and .json file of fsys as a declared
+// migration, so migrations embedded with go:embed run without files on disk:
+//
+//	//go:embed migrations
+//	var migrations embed.FS
+//
+// Files are read in lexical order of their path; like other migrations they
+// are applied in version order. Files named as in Flyway,
+// V{version}__{description}.es.json such as V1_2__add_tags.es.json, take
+// their version from the name, and their description too when they declare
+// none.
+func LoadFS(fsys fs.FS) ([]Migration, error) {
+	return loadFS(fsys, nil)
+}
+
+// loadFS reads the migrations declared in fsys, passing each declaration
+// through prepare, when set, e.g. to resolve placeholders
+func loadFS(fsys fs.FS, prepare func(Declaration) (Declaration, error)) ([]Migration, error) {
+	var migrations []Migration
+	versions := map[string]string{}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !declarationExtensions[path.Ext(name)] {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %w", name, err)
+		}
+		d, err := decodeDeclaration(data)
+		version, description, versioned := versionedFileName(path.Base(name))
+		if versioned && d.Description == "" {
+			d.Description = description
+		}
+		if err == nil && prepare != nil {
+			d, err = prepare(d)
+		}
+		if err != nil {
+			return fmt.Errorf("error loading migration %s: %w", name, err)
+		}
+		migration, err := d.Migration()
+		if err != nil {
+			return fmt.Errorf("error loading migration %s: %w", name, err)
+		}
+		migration.source = name
+		if versioned {
+			if other, ok := versions[version]; ok {
+				return fmt.Errorf("migrations %s and %s share version %s", other, name, version)
+			}
+			versions[version] = name
+			migration = migration.WithVersion(version)
+		}
+		migrations = append(migrations, migration)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+// versionedFile matches file names following the Flyway convention
+// V{version}__{description}.es.json, e.g. V1_2__add_tags.es.json
+var versionedFile = regexp.MustCompile(`^V(\d+(?:[._]\d+)*)__(.+)\.es\.(json|ya?ml)$`)
+
+// versionedFileName returns the version and description in a file name
+// following the Flyway convention. As in Flyway, underscores separate the
+// parts of the version and the words of the description.
+func versionedFileName(name string) (version, description string, ok bool) {
+	match := versionedFile.FindStringSubmatch(name)
+	if match == nil {
+		return "", "", false
+	}
+	return strings.ReplaceAll(match[1], "_", "."), strings.ReplaceAll(match[2], "_", " "), true
+}
+
+// RegisterFS registers the migrations declared in fsys, see LoadFS, resolving
+// placeholders with the manager's variables and secrets
+func (mm *MigrationManager) RegisterFS(fsys fs.FS) error {
+	serverless, err := mm.serverless()
+	if err != nil {
+		return err
+	}
+	migrations, err := loadFS(fsys, func(d Declaration) (Declaration, error) {
+		if mm.variables != nil || mm.secrets != nil {
+			var vars Variables
+			if mm.variables != nil {
+				vars = *mm.variables
+			}
+			if mm.secrets != nil {
+				vars.Secrets = mm.secrets
+			}
+			var err error
+			if d, err = d.Resolve(vars); err != nil {
+				return d, err
+			}
+		}
+		if serverless {
+			var removed []string
+			if d, removed = d.ForServerless(); len(removed) > 0 {
+				mm.logf("Dropping %s from %q, managed by Serverless", strings.Join(removed, ", "), d.Description)
+			}
+		}
+		return d, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		mm.Register(migration)
+	}
+	return nil
+}
+
+// Source returns the path of the file a declared migration was loaded from,
+// relative to the file system it was loaded from, empty for other migrations
+func (m Migration) Source() string {
+	return m.source
+}
+
+// ParseDeclaration returns the migration declared by a YAML or JSON document
+func ParseDeclaration(data []byte) (Migration, error) {
+	d, err := decodeDeclaration(data)
+	if err != nil {
+		return Migration{}, err
+	}
+	return d.Migration()
+}
+
+func decodeDeclaration(data []byte) (Declaration, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var d Declaration
+	if err := decoder.Decode(&d); err != nil {
+		return Declaration{}, fmt.Errorf("error parsing declaration: %w", err)
+	}
+	return d, nil
+}
+
+// Migration returns the migration performing the declared action
+func (d Declaration) Migration() (Migration, error) {
+	if d.Description == "" {
+		return Migration{}, fmt.Errorf("declaration has no description")
+	}
+	if d.Index == "" {
+		return Migration{}, fmt.Errorf("declaration %q has no index", d.Description)
+	}
+
+	var m Migration
+	switch d.Action {
+	case "create_index":
+		body, err := declaredJSON(d.Body)
+		if err != nil {
+			return Migration{}, err
+		}
+		m = NewMigration(d.Description, func(client *elasticsearch.Client) error {
+			return createIndex(client, d.Index, body)
+		}).Declares(Resource{Kind: ResourceIndex, Name: d.Index})
+		if mappings, ok := d.Body["mappings"].(map[string]interface{}); ok {
+			mapping, err := declaredJSON(mappings)
+			if err != nil {
+				return Migration{}, err
+			}
+			m = m.WithDesiredMapping(d.Index, mapping)
+			if settings, ok := d.Body["settings"].(map[string]interface{}); ok {
+				m.desired[len(m.desired)-1].settings = settings
+			}
+		}
+	case "delete_index":
+		m = NewMigration(d.Description, func(client *elasticsearch.Client) error {
+			res, err := client.Indices.Delete([]string{d.Index})
+			if err != nil {
+				return fmt.Errorf("error deleting index %s: %w", d.Index, err)
+			}
+			defer res.Body.Close()
+			if res.IsError() {
+				return fmt.Errorf("error deleting index %s: %s", d.Index, res.String())
+			}
+			return nil
+		}).MarkDestructive().Removes(Resource{Kind: ResourceIndex, Name: d.Index})
+	case "put_mapping":
+		mapping, err := declaredJSON(d.Body)
+		if err != nil {
+			return Migration{}, err
+		}
+		m = PutMapping(d.Description, d.Index, mapping).Declares(Resource{Kind: ResourceIndex, Name: d.Index})
+	case "put_settings":
+		m = PutSettings(d.Description, d.Index, d.Body)
+		if d.AllowDowntime {
+			m = m.AllowDowntime()
+		}
+		if d.serverlessNoop {
+			// keep the version so the migration is recorded like elsewhere
+			m.UpFunc = func(*elasticsearch.Client) error { return nil }
+			m.validate = nil
+			m.downtimeSettings = nil
+		}
+	case "update_by_query", "delete_by_query":
+		query, err := declaredJSON(d.Query)
+		if err != nil {
+			return Migration{}, err
+		}
+		if d.Action == "update_by_query" {
+			m = UpdateByQuery(d.Index, query, d.Script)
+		} else {
+			m = DeleteByQuery(d.Index, query)
+		}
+		// the builders describe the change themselves; keep the declared text
+		m.Description = d.Description
+		m.version = m.computeVersion()
+	default:
+		return Migration{}, fmt.Errorf("declaration %q has unknown action %q", d.Description, d.Action)
+	}
+	m.plan = d.requests
+	if len(d.secrets) > 0 {
+		m.plan = d.redactedRequests
+	}
+	m = m.DependsOn(d.DependsOn...)
+	if d.Repeatable {
+		content, err := json.Marshal(d)
+		if err != nil {
+			return Migration{}, fmt.Errorf("error encoding declaration %q: %w", d.Description, err)
+		}
+		m = m.Repeatable(content)
+	}
+	return m, nil
+}
+
+// requests returns the requests the declared action sends, given whether an
+// index exists when it runs
+func (d Declaration) requests(exists func(index string) (bool, error)) ([]PlannedRequest, error) {
+	indexPath := "/" + d.Index
+	switch d.Action {
+	case "create_index":
+		body, err := declaredJSON(d.Body)
+		if err != nil {
+			return nil, err
+		}
+		return []PlannedRequest{{Method: http.MethodPut, Path: indexPath, Body: json.RawMessage(body)}}, nil
+	case "delete_index":
+		return []PlannedRequest{{Method: http.MethodDelete, Path: indexPath}}, nil
+	case "put_mapping":
+		mapping, err := declaredJSON(d.Body)
+		if err != nil {
+			return nil, err
+		}
+		found, err := exists(d.Index)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			body := fmt.Sprintf(`{"mappings": %s}`, mapping)
+			return []PlannedRequest{{Method: http.MethodPut, Path: indexPath, Body: json.RawMessage(body)}}, nil
+		}
+		return []PlannedRequest{{Method: http.MethodPut, Path: indexPath + "/_mapping", Body: json.RawMessage(mapping)}}, nil
+	case "put_settings":
+		if d.serverlessNoop {
+			return nil, nil
+		}
+		body, err := declaredJSON(d.Body)
+		if err != nil {
+			return nil, err
+		}
+		put := PlannedRequest{Method: http.MethodPut, Path: indexPath + "/_settings", Body: json.RawMessage(body)}
+		if len(StaticSettings(d.Body)) == 0 {
+			return []PlannedRequest{put}, nil
+		}
+		return []PlannedRequest{
+			{Method: http.MethodPost, Path: indexPath + "/_close"},
+			put,
+			{Method: http.MethodPost, Path: indexPath + "/_open?wait_for_active_shards=1"},
+		}, nil
+	case "update_by_query", "delete_by_query":
+		query, err := declaredJSON(d.Query)
+		if err != nil {
+			return nil, err
+		}
+		var script map[string]interface{}
+		endpoint := "/_delete_by_query"
+		if d.Action == "update_by_query" {
+			script = map[string]interface{}{"lang": "painless", "source": d.Script}
+			endpoint = "/_update_by_query"
+		}
+		body, err := byQueryBody(query, script)
+		if err != nil {
+			return nil, err
+		}
+		return []PlannedRequest{{
+			Method: http.MethodPost,
+			Path:   indexPath + endpoint + "?conflicts=proceed&refresh=true&slices=auto&wait_for_completion=false",
+			Body:   body,
+		}}, nil
+	}
+	return nil, nil
+}
+
+// redactedRequests returns the requests of the declaration with the
+// secrets resolved into it replaced by their placeholders
+func (d Declaration) redactedRequests(exists func(index string) (bool, error)) ([]PlannedRequest, error) {
+	requests, err := d.requests(exists)
+	if err != nil {
+		return nil, err
+	}
+	var replacements []string
+	for secret, placeholder := range d.secrets {
+		// bodies hold secrets JSON escaped
+		escaped, _ := json.Marshal(secret)
+		quoted, _ := json.Marshal(placeholder)
+		replacements = append(replacements, secret, placeholder, string(escaped[1:len(escaped)-1]), string(quoted[1:len(quoted)-1]))
+	}
+	redact := strings.NewReplacer(replacements...)
+	for i := range requests {
+		requests[i].Path = redact.Replace(requests[i].Path)
+		if requests[i].Body != nil {
+			requests[i].Body = json.RawMessage(redact.Replace(string(requests[i].Body)))
+		}
+	}
+	return requests, nil
+}
+
+// declaredJSON encodes a declared body, which may be empty
+func declaredJSON(value map[string]interface{}) (string, error) {
+	if value == nil {
+		return "{}", nil
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("error encoding declared body: %w", err)
+	}
+	return string(body), nil
+}
+
+func createIndex(client *elasticsearch.Client, index, body string) error {
+	res, err := client.Indices.Create(index, client.Indices.Create.WithBody(strings.NewReader(body)))
+	if err != nil {
+		return fmt.Errorf("error creating index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error creating index %s: %s", index, res.String())
+	}
+	return nil
+}
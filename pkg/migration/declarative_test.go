@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadFS(t *testing.T) {
+	definition := &IndexDefinition{
+		Name:     "articles_v1",
+		Mappings: []byte(`{"properties": {"title": {"type": "text"}}}`),
+	}
+	created, err := definition.RenderYAML()
+	if err != nil {
+		t.Fatalf("Failed to render YAML: %v", err)
+	}
+
+	fsys := fstest.MapFS{
+		"migrations/001_create_articles.yaml": {Data: created},
+		"migrations/002_add_tags.json": {Data: []byte(`{
+			"description": "Add tags to articles",
+			"action": "put_mapping",
+			"index": "articles_v1",
+			"body": {"properties": {"tags": {"type": "keyword"}}}
+		}`)},
+		"migrations/README.md": {Data: []byte("not a migration")},
+	}
+
+	t.Run("Test Declared Migrations Run", func(t *testing.T) {
+		migrations, err := LoadFS(fsys)
+		if err != nil {
+			t.Fatalf("Failed to load migrations: %v", err)
+		}
+		if len(migrations) != 2 {
+			t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+		}
+
+		transport := &routeTransport{routes: map[string]string{"HEAD /articles_v1": `{}`}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+		if err := mm.RegisterFS(fsys); err != nil {
+			t.Fatalf("Failed to register migrations: %v", err)
+		}
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		for _, request := range []string{"PUT /articles_v1", "PUT /articles_v1/_mapping"} {
+			if !transport.sent(request) {
+				t.Errorf("Expected %s to be sent, got %v", request, transport.requests)
+			}
+		}
+	})
+
+	t.Run("Test Invalid Declaration Names The File", func(t *testing.T) {
+		_, err := LoadFS(fstest.MapFS{
+			"migrations/003_reindex.yaml": {Data: []byte("description: Reindex\naction: reindex\nindex: articles\n")},
+		})
+		if err == nil || !strings.Contains(err.Error(), "003_reindex.yaml") || !strings.Contains(err.Error(), `unknown action "reindex"`) {
+			t.Errorf("Expected an unknown action error naming the file, got %v", err)
+		}
+	})
+
+	t.Run("Test Plan Shows Requests", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{"HEAD /articles_v1": `{}`}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+		if err := mm.RegisterFS(fsys); err != nil {
+			t.Fatalf("Failed to register migrations: %v", err)
+		}
+		plan, err := mm.Plan()
+		if err != nil {
+			t.Fatalf("Failed to plan migrations: %v", err)
+		}
+
+		var requests []string
+		for _, step := range plan.Steps {
+			for _, request := range step.Requests {
+				requests = append(requests, request.Method+" "+request.Path)
+			}
+		}
+		if strings.Join(requests, ", ") != "PUT /articles_v1/_mapping, PUT /articles_v1" {
+			t.Errorf("Expected a put mapping and a create request, got %v", requests)
+		}
+		if !strings.Contains(plan.String(), `  PUT /articles_v1/_mapping
+  {
+    "properties": {
+      "tags": {`) {
+			t.Errorf("Expected the plan to show the request body, got:\n%s", plan)
+		}
+		for _, request := range transport.requests {
+			if !strings.HasPrefix(request, "GET ") && !strings.HasPrefix(request, "HEAD ") {
+				t.Errorf("Expected planning to only read the cluster, got %s", request)
+			}
+		}
+	})
+}
+
+func TestVersionedFileNames(t *testing.T) {
+	mapping := func(field string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`{"action": "put_mapping", "index": "articles", "body": {"properties": {"` + field + `": {"type": "keyword"}}}}`)}
+	}
+
+	t.Run("Test Version And Description From Name", func(t *testing.T) {
+		migrations, err := LoadFS(fstest.MapFS{
+			"migrations/V1__create_articles.es.json": mapping("title"),
+			"migrations/V1_10__add_author.es.json":   mapping("author"),
+			"migrations/V1_2__add_tags.es.yaml":      {Data: []byte("description: Add tags to articles\naction: put_mapping\nindex: articles\nbody:\n  properties:\n    tags:\n      type: keyword\n")},
+		})
+		if err != nil {
+			t.Fatalf("Failed to load migrations: %v", err)
+		}
+
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()), WithVersionFunc(SequentialVersion))
+		for _, migration := range migrations {
+			mm.Register(migration)
+		}
+		sort.SliceStable(mm.Migrations, func(i, j int) bool { return versionLess(mm.Migrations[i], mm.Migrations[j]) })
+		var got []string
+		for _, migration := range mm.Migrations {
+			got = append(got, migration.Version()+" "+migration.Description)
+		}
+		want := "1 create articles, 1.2 Add tags to articles, 1.10 add author"
+		if strings.Join(got, ", ") != want {
+			t.Errorf("Expected %q, got %q", want, strings.Join(got, ", "))
+		}
+	})
+
+	t.Run("Test Duplicate Versions", func(t *testing.T) {
+		_, err := LoadFS(fstest.MapFS{
+			"migrations/V2__add_tags.es.json":   mapping("tags"),
+			"migrations/V2__add_author.es.json": mapping("author"),
+		})
+		if err == nil || !strings.Contains(err.Error(), "share version 2") {
+			t.Errorf("Expected a duplicate version error, got %v", err)
+		}
+	})
+
+	t.Run("Test Other Names Keep Their Version", func(t *testing.T) {
+		if _, _, ok := versionedFileName("V1__add_tags.json"); ok {
+			t.Error("Expected files without the .es suffix to be ignored")
+		}
+		if _, _, ok := versionedFileName("0003_add_tags.yaml"); ok {
+			t.Error("Expected numbered files to be ignored")
+		}
+	})
+}
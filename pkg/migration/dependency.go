@@ -0,0 +1,100 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DependsOn declares migrations that must be applied before this one, by
+// version or description. Migrations are applied in version order, moved
+// after the migrations they depend on; a dependency on a squashed migration
+// is one on its baseline. Unknown and circular dependencies fail the run.
+func (m Migration) DependsOn(migrations ...string) Migration {
+	m.dependencies = append(append([]string{}, m.dependencies...), migrations...)
+	return m
+}
+
+// Dependencies returns the versions or descriptions the migration depends on
+func (m Migration) Dependencies() []string {
+	return append([]string{}, m.dependencies...)
+}
+
+// resolveDependencies returns the versions each migration depends on
+func resolveDependencies(migrations []Migration) (map[string][]string, error) {
+	refs := make(map[string]string, 2*len(migrations))
+	for _, migration := range migrations {
+		refs[migration.Description] = migration.Version()
+		for _, squashed := range migration.squashes {
+			refs[squashed] = migration.Version()
+		}
+	}
+	// versions take precedence over descriptions that look like one
+	for _, migration := range migrations {
+		refs[migration.Version()] = migration.Version()
+	}
+
+	dependencies := make(map[string][]string)
+	for _, migration := range migrations {
+		for _, ref := range migration.dependencies {
+			version, ok := refs[ref]
+			if !ok {
+				return nil, fmt.Errorf("migration %s (%s) depends on unknown migration %q", migration.Version(), migration.Description, ref)
+			}
+			if version == migration.Version() {
+				return nil, fmt.Errorf("migration %s (%s) depends on itself", migration.Version(), migration.Description)
+			}
+			dependencies[migration.Version()] = append(dependencies[migration.Version()], version)
+		}
+	}
+	return dependencies, nil
+}
+
+// sortMigrations orders migrations by version, repeatable migrations last,
+// then moves each after the migrations it depends on
+func sortMigrations(migrations []Migration) error {
+	sort.Slice(migrations, func(i, j int) bool {
+		if migrations[i].IsRepeatable() != migrations[j].IsRepeatable() {
+			return !migrations[i].IsRepeatable()
+		}
+		return versionLess(migrations[i], migrations[j])
+	})
+
+	dependencies, err := resolveDependencies(migrations)
+	if err != nil || len(dependencies) == 0 {
+		return err
+	}
+
+	// Repeatedly take the first migration whose dependencies are placed, so
+	// the version order is kept wherever dependencies allow
+	placed := make(map[string]bool, len(migrations))
+	remaining := append([]Migration{}, migrations...)
+	ordered := migrations[:0]
+	for len(remaining) > 0 {
+		next := -1
+		for i, migration := range remaining {
+			ready := true
+			for _, dependency := range dependencies[migration.Version()] {
+				if !placed[dependency] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			cycle := make([]string, len(remaining))
+			for i, migration := range remaining {
+				cycle[i] = migration.Version()
+			}
+			return fmt.Errorf("circular dependencies between migrations %s", strings.Join(cycle, ", "))
+		}
+		placed[remaining[next].Version()] = true
+		ordered = append(ordered, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestDependencies(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	versions := func(migrations []Migration) []string {
+		var versions []string
+		for _, migration := range migrations {
+			versions = append(versions, migration.Version())
+		}
+		return versions
+	}
+
+	t.Run("Test Migrations Follow Their Dependencies", func(t *testing.T) {
+		create := NewMigration("Create articles index", noop)
+		tags := NewMigration("Add tags to articles", noop).DependsOn("Create articles index")
+		author := NewMigration("Add author to articles", noop).DependsOn(tags.Version(), create.Version())
+		other := NewMigration("Create comments index", noop)
+
+		migrations := []Migration{author, other, tags, create}
+		if err := sortMigrations(migrations); err != nil {
+			t.Fatalf("Failed to sort migrations: %v", err)
+		}
+		position := make(map[string]int)
+		for i, version := range versions(migrations) {
+			position[version] = i
+		}
+		if position[create.Version()] > position[tags.Version()] || position[tags.Version()] > position[author.Version()] {
+			t.Errorf("Expected create, tags then author, got %v", versions(migrations))
+		}
+		if len(migrations) != 4 {
+			t.Errorf("Expected every migration kept, got %v", versions(migrations))
+		}
+	})
+
+	t.Run("Test Unknown Dependency Fails", func(t *testing.T) {
+		migrations := []Migration{NewMigration("Add tags to articles", noop).DependsOn("Create articles index")}
+		err := sortMigrations(migrations)
+		if err == nil || !strings.Contains(err.Error(), `unknown migration "Create articles index"`) {
+			t.Errorf("Expected an unknown dependency error, got %v", err)
+		}
+	})
+
+	t.Run("Test Circular Dependencies Fail", func(t *testing.T) {
+		migrations := []Migration{
+			NewMigration("Create articles index", noop).DependsOn("Add tags to articles"),
+			NewMigration("Add tags to articles", noop).DependsOn("Create articles index"),
+		}
+		err := sortMigrations(migrations)
+		if err == nil || !strings.Contains(err.Error(), "circular dependencies") {
+			t.Errorf("Expected a circular dependency error, got %v", err)
+		}
+	})
+
+	t.Run("Test Dependency On Squashed Migration Resolves To Baseline", func(t *testing.T) {
+		baseline := NewMigration("Articles baseline", noop).Squashes("0000abcd")
+		tags := NewMigration("Add tags to articles", noop).DependsOn("0000abcd")
+		migrations := []Migration{tags, baseline}
+		if err := sortMigrations(migrations); err != nil {
+			t.Fatalf("Failed to sort migrations: %v", err)
+		}
+		if migrations[0].Version() != baseline.Version() {
+			t.Errorf("Expected the baseline first, got %v", versions(migrations))
+		}
+	})
+}
+
+func TestGraph(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	create := NewMigration("Create articles index", noop)
+	tags := NewMigration("Add \"tags\" to articles", noop).DependsOn("Create articles index")
+
+	store := NewMemoryStore()
+	store.Record(MigrationRecord{Version: create.Version()})
+	mm := NewMigrationManager(nil, WithStore(store))
+	mm.Register(tags)
+	mm.Register(create)
+
+	graph, err := mm.Graph()
+	if err != nil {
+		t.Fatalf("Failed to build graph: %v", err)
+	}
+
+	t.Run("Test DOT", func(t *testing.T) {
+		dot := graph.DOT()
+		for _, expected := range []string{
+			`"` + create.Version() + `" -> "` + tags.Version() + `";`,
+			`"` + create.Version() + `" [label="` + create.Version() + `\nCreate articles index", fillcolor=palegreen];`,
+			`fillcolor=lightyellow`,
+		} {
+			if !strings.Contains(dot, expected) {
+				t.Errorf("Expected %s in:\n%s", expected, dot)
+			}
+		}
+	})
+
+	t.Run("Test Mermaid", func(t *testing.T) {
+		mermaid := graph.Mermaid()
+		for _, expected := range []string{
+			"m" + create.Version() + " --> m" + tags.Version(),
+			":::applied",
+			"Add #quot;tags#quot; to articles\"]:::pending",
+		} {
+			if !strings.Contains(mermaid, expected) {
+				t.Errorf("Expected %s in:\n%s", expected, mermaid)
+			}
+		}
+	})
+}
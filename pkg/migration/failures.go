@@ -0,0 +1,111 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FailureMode controls what RunMigrations does when a migration fails
+type FailureMode int
+
+const (
+	// FailFast stops the run at the first failure. It is the default.
+	FailFast FailureMode = iota
+	// ContinueOnError skips a failed migration, applies the remaining ones and
+	// returns a *RunError listing every failure
+	ContinueOnError
+	// Quarantine continues like ContinueOnError and quarantines failed
+	// migrations in the version store, so later runs skip them until they are
+	// released with Release
+	Quarantine
+)
+
+// RunError lists the migrations that failed in a run that continued past
+// failures
+type RunError struct {
+	Failures []MigrationFailure
+	errs     []error
+}
+
+func (e *RunError) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		messages[i] = fmt.Sprintf("%s (%s): %s", failure.Version, failure.Description, failure.Error)
+	}
+	return fmt.Sprintf("%d migrations failed: %s", len(e.Failures), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the failed migrations
+func (e *RunError) Unwrap() []error {
+	return e.errs
+}
+
+func (e *RunError) add(failure MigrationFailure, err error) {
+	e.Failures = append(e.Failures, failure)
+	e.errs = append(e.errs, err)
+}
+
+// WithFailureMode sets what RunMigrations does when a migration fails
+func WithFailureMode(mode FailureMode) Option {
+	return func(mm *MigrationManager) {
+		mm.failureMode = mode
+	}
+}
+
+// Release lifts the quarantine of a migration so the next run retries it
+func (mm *MigrationManager) Release(version string) error {
+	store, ok := mm.store().(QuarantineStore)
+	if !ok {
+		return fmt.Errorf("version store %T does not support quarantine", mm.store())
+	}
+	return store.Release(version)
+}
+
+// quarantined returns the quarantined migrations by version when the manager
+// runs in Quarantine mode
+func (mm *MigrationManager) quarantined() (map[string]MigrationFailure, error) {
+	if mm.failureMode != Quarantine {
+		return nil, nil
+	}
+	store, ok := mm.store().(QuarantineStore)
+	if !ok {
+		return nil, fmt.Errorf("version store %T does not support quarantine", mm.store())
+	}
+
+	failures, err := store.Quarantined()
+	if err != nil {
+		return nil, err
+	}
+	quarantined := make(map[string]MigrationFailure, len(failures))
+	for _, failure := range failures {
+		quarantined[failure.Version] = failure
+	}
+	return quarantined, nil
+}
+
+func newFailure(migration Migration, runID string, start time.Time, err error) MigrationFailure {
+	return MigrationFailure{
+		Version:     migration.Version(),
+		Description: migration.Description,
+		FailedAt:    time.Now(),
+		RunID:       runID,
+		DurationMS:  time.Since(start).Milliseconds(),
+		Error:       err.Error(),
+	}
+}
+
+// recordFailure stores a failed attempt when the version store keeps them,
+// and quarantines the migration in Quarantine mode
+func (mm *MigrationManager) recordFailure(failure MigrationFailure) {
+	if store, ok := mm.store().(FailureStore); ok {
+		if err := store.RecordFailure(failure); err != nil {
+			mm.logf("Failed to record failure of migration %s: %v", failure.Version, err)
+		}
+	}
+	if store, ok := mm.store().(QuarantineStore); ok && mm.failureMode == Quarantine {
+		if err := store.Quarantine(failure); err != nil {
+			mm.logf("Failed to quarantine migration %s: %v", failure.Version, err)
+		}
+	}
+}
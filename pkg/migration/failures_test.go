@@ -0,0 +1,85 @@
+package migration
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestFailureModes(t *testing.T) {
+	errBroken := errors.New("broken mapping")
+
+	setup := func(mode FailureMode) (*MigrationManager, *MemoryStore, Migration, *int) {
+		store := NewMemoryStore()
+		mm := NewMigrationManager(nil, WithStore(store), WithFailureMode(mode))
+
+		applied := 0
+		broken := NewMigration("Broken migration", func(client *elasticsearch.Client) error {
+			return errBroken
+		})
+		mm.Register(broken)
+		for _, description := range []string{"First", "Second"} {
+			mm.Register(NewMigration(description, func(client *elasticsearch.Client) error {
+				applied++
+				return nil
+			}))
+		}
+		return mm, store, broken, &applied
+	}
+
+	t.Run("Test Fail Fast Stops At First Failure", func(t *testing.T) {
+		mm, store, _, _ := setup(FailFast)
+		err := mm.RunMigrations()
+		if !errors.Is(err, errBroken) {
+			t.Fatalf("Expected the migration error, got %v", err)
+		}
+		var runErr *RunError
+		if errors.As(err, &runErr) {
+			t.Errorf("Expected a plain error in fail fast mode, got %v", err)
+		}
+		if failures, _ := store.GetFailures(); len(failures) != 1 {
+			t.Errorf("Expected 1 recorded failure, got %d", len(failures))
+		}
+	})
+
+	t.Run("Test Continue On Error Applies The Rest", func(t *testing.T) {
+		mm, store, broken, applied := setup(ContinueOnError)
+		err := mm.RunMigrations()
+
+		var runErr *RunError
+		if !errors.As(err, &runErr) || len(runErr.Failures) != 1 || runErr.Failures[0].Version != broken.Version() {
+			t.Fatalf("Expected a run error listing the broken migration, got %v", err)
+		}
+		if !errors.Is(err, errBroken) {
+			t.Errorf("Expected the run error to wrap the migration error")
+		}
+		if *applied != 2 {
+			t.Errorf("Expected 2 migrations applied, got %d", *applied)
+		}
+		if records, _ := store.GetApplied(); len(records) != 2 {
+			t.Errorf("Expected 2 records, got %d", len(records))
+		}
+	})
+
+	t.Run("Test Quarantined Migrations Are Skipped Until Released", func(t *testing.T) {
+		mm, store, broken, _ := setup(Quarantine)
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected the first run to report the failure")
+		}
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Expected the quarantined migration to be skipped, got %v", err)
+		}
+		if failures, _ := store.GetFailures(); len(failures) != 1 {
+			t.Errorf("Expected the quarantined migration not to run again, got %d failures", len(failures))
+		}
+
+		if err := mm.Release(broken.Version()); err != nil {
+			t.Fatalf("Failed to release migration: %v", err)
+		}
+		if err := mm.RunMigrations(); !errors.Is(err, errBroken) {
+			t.Errorf("Expected the released migration to run again, got %v", err)
+		}
+	})
+}
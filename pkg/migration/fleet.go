@@ -0,0 +1,290 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const manifestDocumentID = "_elasticmate_manifest"
+
+// ManifestEntry is a migration registered with a manager
+type ManifestEntry struct {
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// Manifest lists the migrations a service registers, exported to its store so
+// tools without the service's code can tell what is pending
+type Manifest struct {
+	Namespace  string          `json:"namespace,omitempty"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Migrations []ManifestEntry `json:"migrations"`
+}
+
+// ManifestStore is implemented by version stores that can keep a manifest
+type ManifestStore interface {
+	// SaveManifest replaces the stored manifest
+	SaveManifest(manifest Manifest) error
+	// LoadManifest returns the stored manifest, nil if none was exported
+	LoadManifest() (*Manifest, error)
+}
+
+// WithManifestExport makes RunMigrations export the manifest of registered
+// migrations before applying them
+func WithManifestExport() Option {
+	return func(mm *MigrationManager) {
+		mm.exportManifest = true
+	}
+}
+
+// ExportManifest writes the registered migrations to the version store
+func (mm *MigrationManager) ExportManifest() error {
+	store, ok := mm.store().(ManifestStore)
+	if !ok {
+		return fmt.Errorf("version store %T does not support manifests", mm.store())
+	}
+
+	migrations, err := mm.Registered()
+	if err != nil {
+		return err
+	}
+	manifest := Manifest{
+		Namespace:  mm.Namespace,
+		ExportedAt: time.Now(),
+		Migrations: migrations,
+	}
+
+	return store.SaveManifest(manifest)
+}
+
+// Registered lists the migrations registered with the manager in version
+// order, templated migrations expanded for every tenant
+func (mm *MigrationManager) Registered() ([]ManifestEntry, error) {
+	migrations, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ManifestEntry, 0, len(migrations))
+	for _, migration := range migrations {
+		entries = append(entries, ManifestEntry{
+			Version:     migration.Version(),
+			Description: migration.Description,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Version < entries[j].Version
+	})
+	return entries, nil
+}
+
+func (s *ESStore) SaveManifest(manifest Manifest) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("error marshaling manifest: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Index(
+		s.Index,
+		strings.NewReader(string(data)),
+		s.Client.Index.WithDocumentID(manifestDocumentID),
+		s.Client.Index.WithContext(ctx),
+		s.Client.Index.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error saving manifest: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error saving manifest: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) LoadManifest() (*Manifest, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Get(s.Index, manifestDocumentID, s.Client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading manifest: %s", res.String())
+	}
+
+	var doc struct {
+		Source Manifest `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	return &doc.Source, nil
+}
+
+// NamespaceStatus is the migration state of one namespace in a cluster
+type NamespaceStatus struct {
+	Namespace string
+	Index     string
+	Applied   int
+	// Current is the most recently applied migration, nil when none is
+	Current *MigrationRecord
+
+	// Manifest is nil when the namespace never exported one; Pending and
+	// Unknown are only computed when it did
+	Manifest *Manifest
+	// Pending are manifest migrations that have not been applied
+	Pending []ManifestEntry
+	// Unknown are applied versions missing from the manifest
+	Unknown []string
+}
+
+// trackingGenerationSuffix matches the generation of a concrete tracking index
+var trackingGenerationSuffix = regexp.MustCompile(`-[0-9]{6}$`)
+
+// FleetStatus scans the cluster for the tracking index of every namespace and
+// reports the state of each
+func FleetStatus(client *elasticsearch.Client) ([]NamespaceStatus, error) {
+	res, err := client.Cat.Indices(
+		client.Cat.Indices.WithIndex(migrationsIndex+"*"),
+		client.Cat.Indices.WithExpandWildcards("all"),
+		client.Cat.Indices.WithFormat("json"),
+		client.Cat.Indices.WithH("index"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing migration indices: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error listing migration indices: %s", res.String())
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, fmt.Errorf("error parsing migration indices: %w", err)
+	}
+
+	var statuses []NamespaceStatus
+	seen := map[string]bool{}
+	for _, entry := range indices {
+		// tracking indices are listed by their concrete index, behind an
+		// alias of the tracking index name since the first generation
+		index := trackingGenerationSuffix.ReplaceAllString(entry.Index, "")
+		if (index != migrationsIndex && !strings.HasPrefix(index, migrationsIndex+"_")) || seen[index] {
+			continue
+		}
+		seen[index] = true
+		namespace := strings.TrimPrefix(index, migrationsIndex+"_")
+		if index == migrationsIndex {
+			namespace = ""
+		}
+
+		store := NewESStore(client)
+		store.Index = index
+
+		status, err := namespaceStatus(store)
+		if err != nil {
+			return nil, err
+		}
+		status.Namespace = namespace
+		status.Index = index
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Namespace < statuses[j].Namespace
+	})
+	return statuses, nil
+}
+
+func namespaceStatus(store *ESStore) (NamespaceStatus, error) {
+	records, err := store.GetApplied()
+	if err != nil {
+		return NamespaceStatus{}, err
+	}
+
+	manifest, err := store.LoadManifest()
+	if err != nil {
+		return NamespaceStatus{}, err
+	}
+
+	return compareManifest(records, manifest), nil
+}
+
+// Status reports the state of the manager's namespace, using the registered
+// migrations as the manifest
+func (mm *MigrationManager) Status() (NamespaceStatus, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return NamespaceStatus{}, err
+	}
+
+	migrations, err := mm.registered()
+	if err != nil {
+		return NamespaceStatus{}, err
+	}
+	manifest := &Manifest{Namespace: mm.Namespace, ExportedAt: time.Now()}
+	for _, migration := range migrations {
+		manifest.Migrations = append(manifest.Migrations, ManifestEntry{
+			Version:     migration.Version(),
+			Description: migration.Description,
+		})
+	}
+
+	status := compareManifest(records, manifest)
+	status.Namespace = mm.Namespace
+	if status.Index, err = mm.TrackingIndex(); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+func compareManifest(records []MigrationRecord, manifest *Manifest) NamespaceStatus {
+	status := NamespaceStatus{Applied: len(records), Manifest: manifest}
+	if len(records) > 0 {
+		status.Current = &records[len(records)-1]
+	}
+	if manifest == nil {
+		return status
+	}
+
+	applied := make(map[string]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	registered := make(map[string]bool, len(manifest.Migrations))
+	for _, entry := range manifest.Migrations {
+		registered[entry.Version] = true
+		if !applied[entry.Version] {
+			status.Pending = append(status.Pending, entry)
+		}
+	}
+	for _, record := range records {
+		if !registered[record.Version] {
+			status.Unknown = append(status.Unknown, record.Version)
+		}
+	}
+
+	return status
+}
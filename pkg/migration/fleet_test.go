@@ -0,0 +1,54 @@
+package migration
+
+import (
+	"testing"
+)
+
+func TestFleetStatus(t *testing.T) {
+	hits := func(versions ...string) string {
+		body := `{"hits": {"hits": [`
+		for i, version := range versions {
+			if i > 0 {
+				body += ","
+			}
+			body += `{"_source": {"version": "` + version + `", "applied_at": "2024-05-15T12:00:0` + string(rune('0'+i)) + `Z"}}`
+		}
+		return body + `]}}`
+	}
+
+	transport := &routeTransport{routes: map[string]string{
+		"GET /_cat/indices/.elasticmate_migrations*": `[{"index": ".elasticmate_migrations_orders-000001"}, {"index": ".elasticmate_migrations"}, {"index": ".elasticmate_maintenance"}]`,
+
+		"HEAD /.elasticmate_migrations":         `{}`,
+		"POST /.elasticmate_migrations/_search": hits("aaaa0001"),
+
+		"HEAD /.elasticmate_migrations_orders":         `{}`,
+		"POST /.elasticmate_migrations_orders/_search": hits("bbbb0001", "bbbb0009"),
+		"GET /.elasticmate_migrations_orders/_doc/_elasticmate_manifest": `{"_source": {"migrations": [
+			{"version": "bbbb0001", "description": "Create orders"},
+			{"version": "bbbb0002", "description": "Add order totals"}
+		]}}`,
+	}}
+
+	statuses, err := FleetStatus(ClientFromTransport(transport))
+	if err != nil {
+		t.Fatalf("Failed to read fleet status: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 namespaces, got %+v", statuses)
+	}
+
+	defaults, orders := statuses[0], statuses[1]
+	if defaults.Namespace != "" || defaults.Applied != 1 || defaults.Manifest != nil {
+		t.Errorf("Unexpected default namespace status %+v", defaults)
+	}
+	if orders.Namespace != "orders" || orders.Current == nil || orders.Current.Version != "bbbb0009" {
+		t.Errorf("Expected orders to be at bbbb0009, got %+v", orders)
+	}
+	if len(orders.Pending) != 1 || orders.Pending[0].Version != "bbbb0002" {
+		t.Errorf("Expected bbbb0002 to be pending, got %+v", orders.Pending)
+	}
+	if len(orders.Unknown) != 1 || orders.Unknown[0] != "bbbb0009" {
+		t.Errorf("Expected bbbb0009 to be unknown to the manifest, got %v", orders.Unknown)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build !unix
+
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// errFileLocked is returned by a non-blocking flock of a file locked elsewhere
+var errFileLocked = errors.New("file is locked")
+
+// flockRetryInterval is how often a blocking flock retries a held lock
+const flockRetryInterval = 50 * time.Millisecond
+
+// flock falls back to creating a marker file next to file with O_EXCL where
+// flock(2) is unavailable, such as on Windows, waiting for it to be removed
+// when block is set. Unlike flock(2), the marker outlives a crashed process
+// and has to be removed by hand.
+func flock(file *os.File, block bool) error {
+	for {
+		marker, err := os.OpenFile(markerPath(file), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(marker, "%d\n", os.Getpid())
+			return marker.Close()
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		if !block {
+			return errFileLocked
+		}
+		time.Sleep(flockRetryInterval)
+	}
+}
+
+func funlock(file *os.File) error {
+	if err := os.Remove(markerPath(file)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// markerPath is the marker file held while file is locked
+func markerPath(file *os.File) string {
+	return file.Name() + ".held"
+}
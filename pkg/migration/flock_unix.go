@@ -0,0 +1,34 @@
+//go:build unix
+
+package migration
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errFileLocked is returned by a non-blocking flock of a file locked elsewhere
+var errFileLocked = errors.New("file is locked")
+
+// flock takes an exclusive lock on file, waiting for it when block is set
+func flock(file *os.File, block bool) error {
+	how := syscall.LOCK_EX
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		switch {
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return errFileLocked
+		}
+		return err
+	}
+}
+
+func funlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,192 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsMetadataToken is the metadata server endpoint returning an access token
+// of the service account attached to the instance, function or job
+const gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCSBucket implements ObjectBucket for Google Cloud Storage using its JSON
+// API, with object generations as revisions
+type GCSBucket struct {
+	Bucket   string
+	Endpoint string // Optional, defaults to https://storage.googleapis.com
+
+	// TokenSource returns an OAuth2 access token. When nil, a token of the
+	// attached service account is requested from the metadata server.
+	TokenSource func() (string, error)
+
+	HTTPClient *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (b *GCSBucket) Get(key string) ([]byte, string, error) {
+	res, err := b.do(http.MethodGet, b.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", ErrObjectNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", s3Error(res)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading object %s: %w", key, err)
+	}
+
+	return data, res.Header.Get("X-Goog-Generation"), nil
+}
+
+func (b *GCSBucket) Put(key string, data []byte, ifRevision string) (string, error) {
+	// generation 0 requires that the object does not exist yet
+	generation := ifRevision
+	if generation == "" {
+		generation = "0"
+	}
+	query := url.Values{
+		"uploadType":        {"media"},
+		"name":              {key},
+		"ifGenerationMatch": {generation},
+	}
+
+	res, err := b.do(http.MethodPost, b.endpoint()+"/upload/storage/v1/b/"+url.PathEscape(b.Bucket)+"/o?"+query.Encode(), data)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return "", ErrPreconditionFailed
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", s3Error(res)
+	}
+
+	var object struct {
+		Generation string `json:"generation"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&object); err != nil {
+		return "", fmt.Errorf("error parsing upload of object %s: %w", key, err)
+	}
+	return object.Generation, nil
+}
+
+func (b *GCSBucket) Delete(key string, ifRevision string) error {
+	target := b.objectURL(key)
+	if ifRevision != "" {
+		target += "?ifGenerationMatch=" + url.QueryEscape(ifRevision)
+	}
+
+	res, err := b.do(http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPreconditionFailed || (ifRevision != "" && res.StatusCode == http.StatusNotFound) {
+		return ErrPreconditionFailed
+	}
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+
+	return nil
+}
+
+func (b *GCSBucket) endpoint() string {
+	if b.Endpoint == "" {
+		return "https://storage.googleapis.com"
+	}
+	return strings.TrimRight(b.Endpoint, "/")
+}
+
+// objectURL escapes key as a single path segment, as the JSON API requires
+func (b *GCSBucket) objectURL(key string) string {
+	return b.endpoint() + "/storage/v1/b/" + url.PathEscape(b.Bucket) + "/o/" + url.PathEscape(key)
+}
+
+func (b *GCSBucket) do(method, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error building request %s: %w", target, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token, err := b.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := b.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %s: %w", target, err)
+	}
+	return res, nil
+}
+
+func (b *GCSBucket) client() *http.Client {
+	if b.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return b.HTTPClient
+}
+
+// accessToken returns the token of TokenSource, or a metadata server token
+// cached until shortly before it expires
+func (b *GCSBucket) accessToken() (string, error) {
+	if b.TokenSource != nil {
+		return b.TokenSource()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Now().Before(b.expires) {
+		return b.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, gcsMetadataToken, nil)
+	if err != nil {
+		return "", fmt.Errorf("error building metadata token request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := b.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting a token from the metadata server: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", s3Error(res)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error parsing metadata server token: %w", err)
+	}
+	b.token = token.AccessToken
+	b.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return b.token, nil
+}
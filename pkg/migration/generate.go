@@ -0,0 +1,153 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration/schema"
+)
+
+// Mapping is a flattened index mapping, see schema.Mapping
+type Mapping = schema.Mapping
+
+// GenerateFromState returns one migration per index that brings its live
+// mapping to the desired state. When applied, each migration creates a missing
+// index, sends a put mapping request for compatible changes, or, when a field
+// is removed or changed incompatibly, rebuilds the index behind the alias
+// named like the key of desired into a new index with the desired mapping.
+//
+// The version of a generated migration depends on the desired mapping, so
+// changing the declared state produces a new pending migration.
+func GenerateFromState(desired map[string]Mapping) ([]Migration, error) {
+	indices := make([]string, 0, len(desired))
+	for index := range desired {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+
+	migrations := make([]Migration, 0, len(indices))
+	for _, index := range indices {
+		index, mapping := index, desired[index]
+
+		body, err := mapping.Render()
+		if err != nil {
+			return nil, fmt.Errorf("error rendering mapping of %s: %w", index, err)
+		}
+		sum := sha256.Sum256(body)
+		state := hex.EncodeToString(sum[:])[:8]
+
+		m := NewMigration(fmt.Sprintf("Converge mapping of %s to state %s", index, state), func(client *elasticsearch.Client) error {
+			return convergeMapping(client, index, state, mapping, body)
+		})
+		m.desired = []desiredMapping{{index: index, mapping: mapping, exact: true}}
+		migrations = append(migrations, m)
+	}
+
+	return migrations, nil
+}
+
+// ConvergeMapping returns a migration bringing index to mapping, a complete
+// mapping body such as {"properties": {...}}, the way those of
+// GenerateFromState do: it creates a missing index, sends a put mapping
+// request for compatible changes, or rebuilds the index behind the alias
+// index into <index>_<state> when a field is removed or changed
+// incompatibly. Such migrations are not refused as breaking changes.
+func ConvergeMapping(description, index, mapping string) Migration {
+	desired, err := schema.Parse([]byte(mapping))
+	sum := sha256.Sum256([]byte(mapping))
+	state := hex.EncodeToString(sum[:])[:8]
+
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		return convergeMapping(client, index, state, desired, []byte(mapping))
+	})
+	m.desired = []desiredMapping{{index: index, mapping: desired, err: err, exact: true, raw: mapping}}
+	return m
+}
+
+func convergeMapping(client *elasticsearch.Client, index, state string, desired Mapping, body []byte) error {
+	live, err := schema.Fetch(client, index)
+	if errors.Is(err, schema.ErrIndexNotFound) {
+		create := fmt.Sprintf(`{"mappings": %s}`, body)
+		res, err := client.Indices.Create(index, client.Indices.Create.WithBody(strings.NewReader(create)))
+		if err != nil {
+			return fmt.Errorf("error creating index %s: %w", index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error creating index %s: %s", index, res.String())
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	diff := schema.Compare(live, desired)
+	if diff.Empty() {
+		return nil
+	}
+
+	breaking := diff.Breaking()
+	if len(breaking) == 0 {
+		res, err := client.Indices.PutMapping([]string{index}, strings.NewReader(string(body)))
+		if err != nil {
+			return fmt.Errorf("error updating mapping of %s: %w", index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error updating mapping of %s: %s", index, res.String())
+		}
+		return nil
+	}
+
+	source, err := aliasTarget(client, index)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		return fmt.Errorf("incompatible mapping change to %s (%s) requires a reindex, but %s is not an alias that can be moved to a new index",
+			index, breaking[0], index)
+	}
+
+	return RebuildIndex(client, RebuildConfig{
+		Source:   source,
+		Target:   fmt.Sprintf("%s_%s", index, state),
+		Alias:    index,
+		Mappings: json.RawMessage(body),
+	})
+}
+
+// aliasTarget returns the single index alias points to, or an empty string
+// when alias is not an alias
+func aliasTarget(client *elasticsearch.Client, alias string) (string, error) {
+	res, err := client.Indices.GetAlias(client.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return "", fmt.Errorf("error reading alias %s: %w", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return "", nil
+	}
+	if res.IsError() {
+		return "", fmt.Errorf("error reading alias %s: %s", alias, res.String())
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing alias %s: %w", alias, err)
+	}
+	if len(result) != 1 {
+		return "", fmt.Errorf("alias %s points to %d indices, expected 1", alias, len(result))
+	}
+	for index := range result {
+		return index, nil
+	}
+	return "", nil
+}
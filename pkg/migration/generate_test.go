@@ -0,0 +1,106 @@
+package migration
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration/schema"
+)
+
+// routeTransport answers requests by "METHOD /path" and records them
+type routeTransport struct {
+	mu       sync.Mutex
+	routes   map[string]string
+	requests []string
+}
+
+func (r *routeTransport) Perform(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.Path
+	r.mu.Lock()
+	r.requests = append(r.requests, key)
+	r.mu.Unlock()
+
+	status, body := 200, `{}`
+	if response, ok := r.routes[key]; ok {
+		body = response
+	} else if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		status, body = 404, `{"error": {"type": "index_not_found_exception"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func (r *routeTransport) sent(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, request := range r.requests {
+		if request == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGenerateFromState(t *testing.T) {
+	live := `{"articles_v1": {"mappings": {"properties": {"title": {"type": "text"}, "views": {"type": "integer"}}}}}`
+
+	generate := func(t *testing.T, mapping string) Migration {
+		t.Helper()
+		desired, err := schema.Parse([]byte(mapping))
+		if err != nil {
+			t.Fatalf("Failed to parse mapping: %v", err)
+		}
+		migrations, err := GenerateFromState(map[string]Mapping{"articles": desired})
+		if err != nil {
+			t.Fatalf("Failed to generate migrations: %v", err)
+		}
+		if len(migrations) != 1 {
+			t.Fatalf("Expected 1 migration, got %d", len(migrations))
+		}
+		return migrations[0]
+	}
+
+	t.Run("Test Compatible Change Uses Put Mapping", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{"GET /articles/_mapping": live}}
+		m := generate(t, `{"properties": {"title": {"type": "text"}, "views": {"type": "integer"}, "tags": {"type": "keyword"}}}`)
+
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		if !transport.sent("PUT /articles/_mapping") || transport.sent("POST /_reindex") {
+			t.Errorf("Expected a put mapping request and no reindex, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Type Change Rebuilds Behind Alias", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{
+			"GET /articles/_mapping": live,
+			"GET /_alias/articles":   `{"articles_v1": {"aliases": {"articles": {}}}}`,
+			"GET /articles_v1":       live,
+			"POST /_reindex":         `{"task": "node-1:7"}`,
+			"GET /_tasks/node-1:7":   `{"completed": true, "response": {"total": 2, "created": 2}}`,
+		}}
+		m := generate(t, `{"properties": {"title": {"type": "text"}, "views": {"type": "long"}}}`)
+
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		if !transport.sent("POST /_reindex") || !transport.sent("POST /_aliases") || transport.sent("PUT /articles/_mapping") {
+			t.Errorf("Expected a reindex and alias swap, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Version Follows State", func(t *testing.T) {
+		a := generate(t, `{"properties": {"title": {"type": "text"}}}`)
+		b := generate(t, `{"properties": {"title": {"type": "keyword"}}}`)
+		if a.Version() == b.Version() {
+			t.Error("Expected different desired states to produce different versions")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GraphNode is a migration in the dependency graph
+type GraphNode struct {
+	Version     string
+	Description string
+	Applied     bool
+}
+
+// Graph is the dependency graph of the registered migrations, in the order
+// they are applied. Edges point from a migration to the migrations that
+// depend on it.
+type Graph struct {
+	Nodes []GraphNode
+	// Edges map a version to the versions depending on it
+	Edges map[string][]string
+}
+
+// Graph returns the dependency graph of the registered migrations, marking
+// those applied
+func (mm *MigrationManager) Graph() (*Graph, error) {
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	registered, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	migrations := append([]Migration{}, registered...)
+	if err := sortMigrations(migrations); err != nil {
+		return nil, err
+	}
+	dependencies, err := resolveDependencies(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &Graph{Edges: make(map[string][]string)}
+	for _, migration := range migrations {
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			Version:     migration.Version(),
+			Description: migration.Description,
+			Applied:     applied[migration.Version()],
+		})
+		for _, dependency := range dependencies[migration.Version()] {
+			graph.Edges[dependency] = append(graph.Edges[dependency], migration.Version())
+		}
+	}
+	return graph, nil
+}
+
+// DOT renders the graph in Graphviz DOT, applied migrations filled green and
+// pending ones yellow
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph migrations {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=filled];\n")
+	for _, node := range g.Nodes {
+		color := "lightyellow"
+		if node.Applied {
+			color = "palegreen"
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, fillcolor=%s];\n", node.Version, node.Version+"\n"+node.Description, color)
+	}
+	for _, node := range g.Nodes {
+		for _, dependent := range g.Edges[node.Version] {
+			fmt.Fprintf(&b, "  %q -> %q;\n", node.Version, dependent)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, applied migrations in the
+// applied class and pending ones in the pending class
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	b.WriteString("  classDef applied fill:#98fb98,stroke:#2e8b57\n")
+	b.WriteString("  classDef pending fill:#ffffe0,stroke:#b8860b\n")
+	for _, node := range g.Nodes {
+		class := "pending"
+		if node.Applied {
+			class = "applied"
+		}
+		label := strings.ReplaceAll(node.Description, `"`, "#quot;")
+		fmt.Fprintf(&b, "  %s[\"%s<br/>%s\"]:::%s\n", mermaidID(node.Version), node.Version, label, class)
+	}
+	for _, node := range g.Nodes {
+		for _, dependent := range g.Edges[node.Version] {
+			fmt.Fprintf(&b, "  %s --> %s\n", mermaidID(node.Version), mermaidID(dependent))
+		}
+	}
+	return b.String()
+}
+
+// mermaidID returns a Mermaid node id for a version, which may hold a tenant
+// name with characters Mermaid ids cannot
+func mermaidID(version string) string {
+	return "m" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, version)
+}
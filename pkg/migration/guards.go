@@ -0,0 +1,125 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Guard wraps a migration function, deciding whether it needs to run. A guard
+// that skips the function returns nil, so the migration is recorded as
+// applied.
+type Guard func(up func(client *elasticsearch.Client) error) func(client *elasticsearch.Client) error
+
+// SkipIf returns a guard that skips the function when check reports true
+func SkipIf(reason string, check func(client *elasticsearch.Client) (bool, error)) Guard {
+	return func(up func(client *elasticsearch.Client) error) func(client *elasticsearch.Client) error {
+		return func(client *elasticsearch.Client) error {
+			skip, err := check(client)
+			if err != nil {
+				return err
+			}
+			if skip {
+				clientLogf(client)("Skipping: %s", reason)
+				return nil
+			}
+			return up(client)
+		}
+	}
+}
+
+// SkipIfIndexExists returns a guard that skips the function when index, or an
+// alias of that name, exists
+func SkipIfIndexExists(index string) Guard {
+	return SkipIf(fmt.Sprintf("index %s exists", index), func(client *elasticsearch.Client) (bool, error) {
+		return IndexExists(client, index)
+	})
+}
+
+// SkipIfFieldExists returns a guard that skips the function when index maps
+// field; nested fields use dotted paths such as "author.name"
+func SkipIfFieldExists(index, field string) Guard {
+	return SkipIf(fmt.Sprintf("field %s exists in %s", field, index), func(client *elasticsearch.Client) (bool, error) {
+		return FieldExists(client, index, field)
+	})
+}
+
+// WithGuard wraps the migration function in guards, the first guard running
+// first. The version of the migration is unchanged. On a migration templated
+// with ForEachTenant the guards wrap the function of every tenant.
+func (m Migration) WithGuard(guards ...Guard) Migration {
+	if m.tenant != nil {
+		template := *m.tenant
+		template.guards = append([]Guard{}, template.guards...)
+		m.tenant = &template
+	}
+	for i := len(guards) - 1; i >= 0; i-- {
+		guard := guards[i]
+		if m.tenant != nil {
+			m.tenant.guards = append(m.tenant.guards, guard)
+			continue
+		}
+		if m.TypedUpFunc != nil {
+			up := m.TypedUpFunc
+			m.TypedUpFunc = func(typed *elasticsearch.TypedClient) error {
+				return guard(func(*elasticsearch.Client) error {
+					return up(typed)
+				})(ClientFromTransport(typed.Transport))
+			}
+			continue
+		}
+		m.UpFunc = guard(m.UpFunc)
+		if m.steps != nil {
+			m.stepGuards = append(append([]Guard{}, m.stepGuards...), guard)
+		}
+	}
+	return m
+}
+
+// IndexExists reports whether an index or alias exists
+func IndexExists(client *elasticsearch.Client, index string) (bool, error) {
+	res, err := client.Indices.Exists([]string{index})
+	if err != nil {
+		return false, fmt.Errorf("error checking index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("error checking index %s: %s", index, res.String())
+	}
+}
+
+// FieldExists reports whether index maps field. A missing index has no fields.
+func FieldExists(client *elasticsearch.Client, index, field string) (bool, error) {
+	res, err := client.Indices.GetFieldMapping([]string{field}, client.Indices.GetFieldMapping.WithIndex(index))
+	if err != nil {
+		return false, fmt.Errorf("error checking field %s of %s: %w", field, index, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return false, nil
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("error checking field %s of %s: %s", field, index, res.String())
+	}
+
+	var result map[string]struct {
+		Mappings map[string]json.RawMessage `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("error parsing field mapping of %s: %w", index, err)
+	}
+	for _, definition := range result {
+		if _, ok := definition.Mappings[field]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,48 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestGuards(t *testing.T) {
+	transport := &routeTransport{routes: map[string]string{
+		"HEAD /articles":                      `{}`,
+		"GET /articles/_mapping/field/tags":   `{"articles_v1": {"mappings": {"tags": {"full_name": "tags", "mapping": {"tags": {"type": "keyword"}}}}}}`,
+		"GET /articles/_mapping/field/author": `{"articles_v1": {"mappings": {}}}`,
+	}}
+	client := ClientFromTransport(transport)
+
+	ran := 0
+	up := func(client *elasticsearch.Client) error {
+		ran++
+		return nil
+	}
+
+	for _, test := range []struct {
+		name     string
+		guard    Guard
+		expected int
+	}{
+		{"Test Existing Index Is Skipped", SkipIfIndexExists("articles"), 0},
+		{"Test Missing Index Runs", SkipIfIndexExists("comments"), 1},
+		{"Test Existing Field Is Skipped", SkipIfFieldExists("articles", "tags"), 0},
+		{"Test Missing Field Runs", SkipIfFieldExists("articles", "author"), 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ran = 0
+			m := NewMigration("Guarded migration", up)
+			guarded := m.WithGuard(test.guard)
+			if guarded.Version() != m.Version() {
+				t.Errorf("Expected the guard to keep version %s, got %s", m.Version(), guarded.Version())
+			}
+			if err := guarded.run(client, nil); err != nil {
+				t.Fatalf("Failed to run migration: %v", err)
+			}
+			if ran != test.expected {
+				t.Errorf("Expected the migration to run %d times, ran %d", test.expected, ran)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// HistoryExport is the exported migration history of a version store
+type HistoryExport struct {
+	Namespace  string            `json:"namespace,omitempty"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Records    []MigrationRecord `json:"records"`
+}
+
+// ExportHistory writes the records of applied migrations to w as JSON, for
+// ImportHistory to copy them to another cluster or store
+func (mm *MigrationManager) ExportHistory(w io.Writer) error {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return err
+	}
+
+	history := HistoryExport{
+		Namespace:  mm.Namespace,
+		ExportedAt: time.Now().UTC(),
+		Records:    records,
+	}
+	if history.Records == nil {
+		history.Records = []MigrationRecord{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(history); err != nil {
+		return fmt.Errorf("error writing history: %w", err)
+	}
+	return nil
+}
+
+// ImportHistory records the migrations of a history written by ExportHistory
+// as applied, keeping their original timestamps and run ids, without running
+// them. Records already in the store are left unchanged. It returns the
+// number of records imported.
+func (mm *MigrationManager) ImportHistory(r io.Reader) (int, error) {
+	var history HistoryExport
+	if err := json.NewDecoder(r).Decode(&history); err != nil {
+		return 0, fmt.Errorf("error parsing history: %w", err)
+	}
+	if history.Namespace != mm.Namespace {
+		mm.logf("Importing history of namespace %q into namespace %q", history.Namespace, mm.Namespace)
+	}
+
+	if !mm.disableLock {
+		unlock, err := mm.lock("")
+		if err != nil {
+			return 0, err
+		}
+		defer unlock()
+	}
+
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, record := range history.Records {
+		if record.Version == "" {
+			return imported, fmt.Errorf("history record %q has no version", record.Description)
+		}
+		if applied[record.Version] {
+			continue
+		}
+		if err := mm.store().Record(record); err != nil {
+			return imported, err
+		}
+		applied[record.Version] = true
+		imported++
+	}
+	return imported, nil
+}
+
+// HistoryStatus is the kind of a history entry
+type HistoryStatus string
+
+const (
+	HistoryApplied    HistoryStatus = "applied"
+	HistoryFailed     HistoryStatus = "failed"
+	HistoryRolledBack HistoryStatus = "rolled_back"
+	// HistorySkipped marks a migration recorded without running because a
+	// condition did not hold
+	HistorySkipped HistoryStatus = "skipped_by_condition"
+	// HistoryRemoved marks a record removed from a FileStore history, e.g.
+	// when its migration is rolled back
+	HistoryRemoved HistoryStatus = "removed"
+)
+
+// HistoryEntry is one event in the history of a migration. Failed entries
+// carry the version, description, run and duration of the attempt.
+type HistoryEntry struct {
+	Status HistoryStatus `json:"status"`
+	At     time.Time     `json:"at"`
+	MigrationRecord
+	// Error is the error of a failed attempt
+	Error string `json:"error,omitempty"`
+}
+
+// HistoryFilter selects history entries. Zero fields match everything.
+type HistoryFilter struct {
+	// Since and Until bound the time of an entry, Since inclusive and Until
+	// exclusive
+	Since time.Time
+	Until time.Time
+	// Statuses limits entries to the given statuses
+	Statuses []HistoryStatus
+	Version  string
+}
+
+func (f HistoryFilter) matches(entry HistoryEntry) bool {
+	if !f.Since.IsZero() && entry.At.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.At.Before(f.Until) {
+		return false
+	}
+	if f.Version != "" && entry.Version != f.Version {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, status := range f.Statuses {
+		if entry.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// History returns every application, failed attempt and rollback the version
+// store keeps, oldest first. A rolled back migration appears as applied and
+// then rolled back. Stores that do not implement FailureStore or
+// RollbackStore only report applied migrations.
+func (mm *MigrationManager) History(filter HistoryFilter) ([]HistoryEntry, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	for _, record := range records {
+		status := HistoryApplied
+		if record.Skipped {
+			status = HistorySkipped
+		}
+		entries = append(entries, HistoryEntry{Status: status, At: record.AppliedAt, MigrationRecord: record})
+	}
+
+	if store, ok := mm.store().(FailureStore); ok {
+		failures, err := store.GetFailures()
+		if err != nil {
+			return nil, err
+		}
+		for _, failure := range failures {
+			entries = append(entries, HistoryEntry{
+				Status: HistoryFailed,
+				At:     failure.FailedAt,
+				MigrationRecord: MigrationRecord{
+					Version:     failure.Version,
+					Description: failure.Description,
+					RunID:       failure.RunID,
+					DurationMS:  failure.DurationMS,
+				},
+				Error: failure.Error,
+			})
+		}
+	}
+
+	if store, ok := mm.store().(RollbackStore); ok {
+		rollbacks, err := store.GetRollbacks()
+		if err != nil {
+			return nil, err
+		}
+		for _, rollback := range rollbacks {
+			entries = append(entries, HistoryEntry{Status: HistoryApplied, At: rollback.Record.AppliedAt, MigrationRecord: rollback.Record})
+			rolledBack := rollback.Record
+			rolledBack.RunnerMetadata = rollback.RunnerMetadata
+			entries = append(entries, HistoryEntry{Status: HistoryRolledBack, At: rollback.RolledBackAt, MigrationRecord: rolledBack})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].At.Before(entries[j].At)
+	})
+
+	filtered := entries[:0]
+	for _, entry := range entries {
+		if filter.matches(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered, nil
+}
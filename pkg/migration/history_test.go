@@ -0,0 +1,101 @@
+package migration
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestHistory(t *testing.T) {
+	appliedAt := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	prod := NewMemoryStore()
+	prod.Record(MigrationRecord{Version: "3fa2c1d0", Description: "Create articles index", AppliedAt: appliedAt, RunID: "run-1"})
+	prod.Record(MigrationRecord{Version: "9b7e2a11", Description: "Add tags to articles", AppliedAt: appliedAt.Add(time.Hour), RunID: "run-2"})
+
+	var exported bytes.Buffer
+	source := NewMigrationManager(nil, WithStore(prod))
+	if err := source.ExportHistory(&exported); err != nil {
+		t.Fatalf("Failed to export history: %v", err)
+	}
+
+	t.Run("Test Import Keeps Records", func(t *testing.T) {
+		staging := NewMemoryStore()
+		staging.Record(MigrationRecord{Version: "3fa2c1d0", Description: "Create articles index", AppliedAt: appliedAt.Add(24 * time.Hour)})
+
+		mm := NewMigrationManager(nil, WithStore(staging))
+		imported, err := mm.ImportHistory(bytes.NewReader(exported.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to import history: %v", err)
+		}
+		if imported != 1 {
+			t.Errorf("Expected 1 record imported, got %d", imported)
+		}
+
+		records, _ := staging.GetApplied()
+		if len(records) != 2 {
+			t.Fatalf("Expected 2 records, got %d", len(records))
+		}
+		byVersion := map[string]MigrationRecord{}
+		for _, record := range records {
+			byVersion[record.Version] = record
+		}
+		if existing := byVersion["3fa2c1d0"]; !existing.AppliedAt.Equal(appliedAt.Add(24 * time.Hour)) {
+			t.Errorf("Expected the existing record to be kept, got %v", existing.AppliedAt)
+		}
+		if added := byVersion["9b7e2a11"]; added.RunID != "run-2" || !added.AppliedAt.Equal(appliedAt.Add(time.Hour)) {
+			t.Errorf("Expected the imported record to keep its run and timestamp, got %+v", added)
+		}
+	})
+
+	t.Run("Test Invalid History", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		if _, err := mm.ImportHistory(bytes.NewReader([]byte(`{"records": [{"description": "No version"}]}`))); err == nil {
+			t.Error("Expected an error for a record without a version")
+		}
+	})
+}
+
+func TestHistoryEntries(t *testing.T) {
+	store := NewMemoryStore()
+	mm := NewMigrationManager(nil, WithStore(store), WithFailureMode(ContinueOnError))
+	created := NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil }).
+		WithDown(func(client *elasticsearch.Client) error { return nil })
+	mm.Register(created)
+	mm.Register(NewMigration("Broken migration", func(client *elasticsearch.Client) error { return errors.New("boom") }))
+
+	mm.RunMigrations()
+	if err := mm.Rollback(created.Version()); err != nil {
+		t.Fatalf("Failed to roll back migration: %v", err)
+	}
+
+	t.Run("Test Full History", func(t *testing.T) {
+		entries, err := mm.History(HistoryFilter{})
+		if err != nil {
+			t.Fatalf("Failed to read history: %v", err)
+		}
+		statuses := map[HistoryStatus]int{}
+		for _, entry := range entries {
+			statuses[entry.Status]++
+		}
+		if statuses[HistoryApplied] != 1 || statuses[HistoryFailed] != 1 || statuses[HistoryRolledBack] != 1 {
+			t.Fatalf("Expected an applied, a failed and a rolled back entry, got %+v", entries)
+		}
+		if last := entries[len(entries)-1]; last.Status != HistoryRolledBack || last.Version != created.Version() {
+			t.Errorf("Expected the rollback last, got %+v", last)
+		}
+	})
+
+	t.Run("Test Filters", func(t *testing.T) {
+		failed, _ := mm.History(HistoryFilter{Statuses: []HistoryStatus{HistoryFailed}})
+		if len(failed) != 1 || failed[0].Error != "boom" {
+			t.Errorf("Expected the failed attempt, got %+v", failed)
+		}
+		future, _ := mm.History(HistoryFilter{Since: time.Now().Add(time.Hour)})
+		if len(future) != 0 {
+			t.Errorf("Expected no entries after now, got %+v", future)
+		}
+	})
+}
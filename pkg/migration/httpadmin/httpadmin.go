@@ -0,0 +1,192 @@
+// Package httpadmin exposes the migration status, history and runs of a
+// service over HTTP, for mounting on its admin port:
+//
+//	admin, err := httpadmin.New(mm, httpadmin.Config{Token: os.Getenv("ADMIN_TOKEN")})
+//	mux.Handle("/migrations/", admin)
+package httpadmin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// Config configures the admin handler
+type Config struct {
+	// Token must be sent as a bearer token with every request
+	Token string
+	// Prefix is the path the handler is mounted under (default "/migrations")
+	Prefix string
+	// RunTimeout, when set, bounds runs triggered over HTTP
+	RunTimeout time.Duration
+}
+
+// Handler serves GET {prefix}/status, GET {prefix}/history and
+// POST {prefix}/run
+type Handler struct {
+	mm      *migration.MigrationManager
+	cfg     Config
+	running sync.Mutex
+	// registered are the migrations registered when the handler was created.
+	// The manager's list is not read while a run may be sorting it.
+	registered []migration.ManifestEntry
+	mux        *http.ServeMux
+}
+
+// New returns the admin handler for mm. Migrations must be registered before
+// it is created.
+func New(mm *migration.MigrationManager, cfg Config) (*Handler, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("admin handler requires a token")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "/migrations"
+	}
+	cfg.Prefix = strings.TrimSuffix(cfg.Prefix, "/")
+
+	registered, err := mm.Registered()
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{mm: mm, cfg: cfg, registered: registered, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET "+cfg.Prefix+"/status", h.status)
+	h.mux.HandleFunc("GET "+cfg.Prefix+"/history", h.history)
+	h.mux.HandleFunc("POST "+cfg.Prefix+"/run", h.run)
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.Token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// Status is the response of the status endpoint
+type Status struct {
+	Namespace string                     `json:"namespace,omitempty"`
+	Index     string                     `json:"index"`
+	Applied   int                        `json:"applied"`
+	Current   *migration.MigrationRecord `json:"current,omitempty"`
+	Pending   []migration.ManifestEntry  `json:"pending"`
+	// Running is set while a run triggered over HTTP is in progress
+	Running bool `json:"running"`
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	records, err := h.mm.AppliedRecords()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	index, err := h.mm.TrackingIndex()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := Status{
+		Namespace: h.mm.Namespace,
+		Index:     index,
+		Applied:   len(records),
+		Pending:   []migration.ManifestEntry{},
+	}
+	if len(records) > 0 {
+		status.Current = &records[len(records)-1]
+	}
+	applied := make(map[string]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	for _, entry := range h.registered {
+		if !applied[entry.Version] {
+			status.Pending = append(status.Pending, entry)
+		}
+	}
+	if h.running.TryLock() {
+		h.running.Unlock()
+	} else {
+		status.Running = true
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// history lists history entries, filtered by the since and until (RFC 3339),
+// status (comma separated) and version query parameters
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter migration.HistoryFilter
+	for name, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+				return
+			}
+			*bound = t
+		}
+	}
+	if statuses := query.Get("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			filter.Statuses = append(filter.Statuses, migration.HistoryStatus(strings.TrimSpace(status)))
+		}
+	}
+	filter.Version = query.Get("version")
+
+	entries, err := h.mm.History(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if entries == nil {
+		entries = []migration.HistoryEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// run applies pending migrations and responds with the run summary. The run
+// is not cancelled when the caller disconnects.
+func (h *Handler) run(w http.ResponseWriter, r *http.Request) {
+	if !h.running.TryLock() {
+		writeError(w, http.StatusConflict, errors.New("a migration run is already in progress"))
+		return
+	}
+	defer h.running.Unlock()
+
+	ctx := context.WithoutCancel(r.Context())
+	if h.cfg.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.RunTimeout)
+		defer cancel()
+	}
+
+	summary, err := h.mm.RunMigrationsSummary(ctx)
+	switch {
+	case errors.Is(err, migration.ErrLocked), errors.Is(err, migration.ErrAlreadyRunning):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, summary)
+	default:
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
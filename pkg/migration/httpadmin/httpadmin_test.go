@@ -0,0 +1,121 @@
+package httpadmin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+func TestHandler(t *testing.T) {
+	mm := migration.NewMigrationManager(nil, migration.WithStore(migration.NewMemoryStore()))
+	mm.Register(migration.NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil }))
+
+	admin, err := New(mm, Config{Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	request := func(method, path, token string, response interface{}) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		if response != nil {
+			json.NewDecoder(rec.Body).Decode(response)
+		}
+		return rec.Code
+	}
+
+	t.Run("Test Token Is Required", func(t *testing.T) {
+		if code := request("GET", "/migrations/status", "", nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 without a token, got %d", code)
+		}
+		if code := request("GET", "/migrations/status", "wrong", nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 with a wrong token, got %d", code)
+		}
+	})
+
+	t.Run("Test Run And Inspect", func(t *testing.T) {
+		var before Status
+		if code := request("GET", "/migrations/status", "secret", &before); code != http.StatusOK || len(before.Pending) != 1 {
+			t.Fatalf("Expected 1 pending migration, got %d %+v", code, before)
+		}
+
+		if code := request("GET", "/migrations/run", "secret", nil); code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected runs to require POST, got %d", code)
+		}
+		var summary migration.RunSummary
+		if code := request("POST", "/migrations/run", "secret", &summary); code != http.StatusOK || len(summary.Applied) != 1 {
+			t.Fatalf("Expected the run to apply 1 migration, got %d %+v", code, summary)
+		}
+
+		var after Status
+		request("GET", "/migrations/status", "secret", &after)
+		if after.Applied != 1 || len(after.Pending) != 0 || after.Current == nil {
+			t.Errorf("Expected the migration to be applied, got %+v", after)
+		}
+
+		var entries []migration.HistoryEntry
+		if code := request("GET", "/migrations/history?status=applied", "secret", &entries); code != http.StatusOK || len(entries) != 1 {
+			t.Errorf("Expected 1 history entry, got %d %+v", code, entries)
+		}
+		if code := request("GET", "/migrations/history?since=yesterday", "secret", nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid time, got %d", code)
+		}
+	})
+
+	t.Run("Test Tenant Migrations Are Pending Per Tenant", func(t *testing.T) {
+		mm := migration.NewMigrationManager(nil, migration.WithStore(migration.NewMemoryStore()),
+			migration.WithTenants(migration.StaticTenants("acme", "globex")))
+		mm.Register(migration.ForEachTenant("Create orders index", "orders-{tenant}", func(client *elasticsearch.Client, index string) error { return nil }))
+		admin, err := New(mm, Config{Token: "secret"})
+		if err != nil {
+			t.Fatalf("Failed to create handler: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/migrations/status", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		var status Status
+		json.NewDecoder(rec.Body).Decode(&status)
+		if len(status.Pending) != 2 {
+			t.Errorf("Expected 1 pending migration per tenant, got %+v", status.Pending)
+		}
+	})
+
+	t.Run("Test Run In Progress Elsewhere Conflicts", func(t *testing.T) {
+		mm := migration.NewMigrationManager(nil, migration.WithStore(migration.NewMemoryStore()))
+		started, release := make(chan struct{}), make(chan struct{})
+		mm.Register(migration.NewMigration("Create articles index", func(client *elasticsearch.Client) error {
+			close(started)
+			<-release
+			return nil
+		}))
+		admin, err := New(mm, Config{Token: "secret"})
+		if err != nil {
+			t.Fatalf("Failed to create handler: %v", err)
+		}
+
+		done := make(chan error)
+		go func() { done <- mm.RunMigrations() }()
+		<-started
+		req := httptest.NewRequest("POST", "/migrations/run", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if rec.Code != http.StatusConflict {
+			t.Errorf("Expected 409 while another run is in progress, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package migration
+
+import "fmt"
+
+// WithID gives the migration a stable identity hashed into its version in
+// place of its function name, so renaming or moving the function keeps the
+// version. Passing the function name a migration was applied with, e.g.
+// "main.addTagsMapping", keeps the version it was applied under.
+func (m Migration) WithID(id string) Migration {
+	m.id = id
+	if !m.customVersion {
+		m.version = m.computeVersion()
+	}
+	return m
+}
+
+// ID returns the identity of the migration, its function name unless set
+// with WithID
+func (m Migration) ID() string {
+	if m.id != "" {
+		return m.id
+	}
+	return m.funcName()
+}
+
+// Rename is an applied migration whose function no longer maps to a
+// registered migration, most likely because it was renamed and now has a new
+// version under which it will be applied again
+type Rename struct {
+	Record MigrationRecord
+	// Candidate is the pending migration with the same description, nil if
+	// there is none
+	Candidate *Migration
+}
+
+func (r Rename) String() string {
+	message := fmt.Sprintf("applied migration %s (%s) was applied by %s, which no longer maps to a registered migration", r.Record.Version, r.Record.Description, r.Record.FuncName)
+	if r.Candidate != nil {
+		message += fmt.Sprintf("; migration %s has the same description and will be applied again unless given WithID(%q)", r.Candidate.Version(), r.Record.FuncName)
+	}
+	return message
+}
+
+// DetectRenames returns the applied migrations whose version and function are
+// both unknown to the registered migrations
+func (mm *MigrationManager) DetectRenames() ([]Rename, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	registered, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	return detectRenames(records, registered), nil
+}
+
+func detectRenames(records []MigrationRecord, registered []Migration) []Rename {
+	versions := make(map[string]bool, len(registered))
+	ids := make(map[string]bool, len(registered))
+	for _, migration := range registered {
+		versions[migration.Version()] = true
+		ids[migration.ID()] = true
+		ids[migration.funcName()] = true
+	}
+
+	var renames []Rename
+	for _, record := range records {
+		if record.FuncName == "" || versions[record.Version] || ids[record.FuncName] {
+			continue
+		}
+		rename := Rename{Record: record}
+		for i, migration := range registered {
+			if migration.Description == record.Description {
+				rename.Candidate = &registered[i]
+				break
+			}
+		}
+		renames = append(renames, rename)
+	}
+	return renames
+}
@@ -0,0 +1,70 @@
+package migration
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func addTagsMapping(client *elasticsearch.Client) error { return nil }
+
+func addTagsMappingRenamed(client *elasticsearch.Client) error { return nil }
+
+func TestIdentity(t *testing.T) {
+	applied := NewMigration("Add tags mapping", addTagsMapping)
+
+	t.Run("Test ID Keeps The Version Of A Renamed Function", func(t *testing.T) {
+		renamed := NewMigration("Add tags mapping", addTagsMappingRenamed)
+		if renamed.Version() == applied.Version() {
+			t.Fatalf("Expected renaming the function to change the version")
+		}
+		pinned := renamed.WithID(applied.funcName())
+		if pinned.Version() != applied.Version() {
+			t.Errorf("Expected version %s, got %s", applied.Version(), pinned.Version())
+		}
+		if pinned.ID() != applied.funcName() {
+			t.Errorf("Expected the pinned ID, got %s", pinned.ID())
+		}
+	})
+
+	t.Run("Test Renamed Functions Are Reported", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: applied.Version(), Description: applied.Description, FuncName: applied.funcName()})
+
+		var output bytes.Buffer
+		renamed := NewMigration("Add tags mapping", addTagsMappingRenamed)
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store), WithLogger(log.New(&output, "", 0)), WithMigrations(renamed))
+
+		renames, err := mm.DetectRenames()
+		if err != nil {
+			t.Fatalf("Failed to detect renames: %v", err)
+		}
+		if len(renames) != 1 || renames[0].Candidate == nil || renames[0].Candidate.Version() != renamed.Version() {
+			t.Fatalf("Expected the renamed migration reported, got %+v", renames)
+		}
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !strings.Contains(output.String(), "Warning: applied migration "+applied.Version()) {
+			t.Errorf("Expected a warning on the run, got %q", output.String())
+		}
+	})
+
+	t.Run("Test Pinned Functions Are Not Reported", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: applied.Version(), Description: applied.Description, FuncName: applied.funcName()})
+		pinned := NewMigration("Add tags mapping", addTagsMappingRenamed).WithID(applied.funcName())
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store), WithMigrations(pinned))
+
+		renames, err := mm.DetectRenames()
+		if err != nil {
+			t.Fatalf("Failed to detect renames: %v", err)
+		}
+		if len(renames) != 0 {
+			t.Errorf("Expected no renames, got %+v", renames)
+		}
+	})
+}
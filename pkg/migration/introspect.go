@@ -0,0 +1,224 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// IndexDefinition is the user-settable definition of an existing index
+type IndexDefinition struct {
+	Name     string
+	Settings map[string]interface{}
+	Mappings json.RawMessage
+	Aliases  map[string]json.RawMessage
+}
+
+// Introspect reads the settings, mappings and aliases of index. When index is
+// an alias, the definition of the index it points to is returned.
+func Introspect(client *elasticsearch.Client, index string) (*IndexDefinition, error) {
+	res, err := client.Indices.Get([]string{index}, client.Indices.Get.WithFlatSettings(true))
+	if err != nil {
+		return nil, fmt.Errorf("error reading index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading index %s: %s", index, res.String())
+	}
+
+	var result map[string]struct {
+		Settings map[string]interface{}     `json:"settings"`
+		Mappings json.RawMessage            `json:"mappings"`
+		Aliases  map[string]json.RawMessage `json:"aliases"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing index %s: %w", index, err)
+	}
+	if len(result) != 1 {
+		return nil, fmt.Errorf("%s resolves to %d indices, expected 1", index, len(result))
+	}
+
+	for name, definition := range result {
+		return &IndexDefinition{
+			Name:     name,
+			Settings: userSettings(definition.Settings),
+			Mappings: definition.Mappings,
+			Aliases:  definition.Aliases,
+		}, nil
+	}
+	return nil, nil
+}
+
+// Body returns the index creation request body for the definition
+func (d *IndexDefinition) Body() ([]byte, error) {
+	body := map[string]interface{}{
+		"settings": d.Settings,
+	}
+	if len(d.Mappings) > 0 {
+		body["mappings"] = d.Mappings
+	}
+	if len(d.Aliases) > 0 {
+		body["aliases"] = d.Aliases
+	}
+
+	data, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling index %s: %w", d.Name, err)
+	}
+	return data, nil
+}
+
+func (d *IndexDefinition) description() string {
+	return fmt.Sprintf("Create %s index", d.Name)
+}
+
+// RenderGo returns the source of a Go file in package pkg declaring a
+// migration that recreates the index
+func (d *IndexDefinition) RenderGo(pkg string) ([]byte, error) {
+	body, err := d.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	literal := "`" + string(body) + "`"
+	if bytes.ContainsRune(body, '`') {
+		literal = strconv.Quote(string(body))
+	}
+
+	name := goIdentifier(d.Name)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("import (\n\t\"fmt\"\n\t\"strings\"\n\n")
+	b.WriteString("\t\"github.com/elastic/go-elasticsearch/v8\"\n")
+	b.WriteString("\t\"github.com/punitsu/elasticmate/pkg/migration\"\n)\n\n")
+	fmt.Fprintf(&b, "// Create%s recreates the %s index as introspected on %s\n", name, d.Name, time.Now().UTC().Format("2006-01-02"))
+	fmt.Fprintf(&b, "var Create%s = migration.NewMigration(%q, create%s)\n\n", name, d.description(), name)
+	fmt.Fprintf(&b, "func create%s(client *elasticsearch.Client) error {\n", name)
+	fmt.Fprintf(&b, "body := %s\n\n", literal)
+	fmt.Fprintf(&b, "res, err := client.Indices.Create(%q, client.Indices.Create.WithBody(strings.NewReader(body)))\n", d.Name)
+	fmt.Fprintf(&b, "if err != nil {\nreturn fmt.Errorf(\"error creating %s index: %%w\", err)\n}\n", d.Name)
+	b.WriteString("defer res.Body.Close()\n\n")
+	fmt.Fprintf(&b, "if res.IsError() {\nreturn fmt.Errorf(\"error creating %s index: %%s\", res.String())\n}\n", d.Name)
+	b.WriteString("return nil\n}\n")
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error formatting generated code: %w", err)
+	}
+	return source, nil
+}
+
+// RenderYAML returns a YAML document describing a migration that recreates
+// the index
+func (d *IndexDefinition) RenderYAML() ([]byte, error) {
+	body, err := d.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("error parsing index %s: %w", d.Name, err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "description: %s\n", yamlScalar(d.description()))
+	b.WriteString("action: create_index\n")
+	fmt.Fprintf(&b, "index: %s\n", yamlScalar(d.Name))
+	b.WriteString("body:")
+	writeYAML(&b, value, 1)
+	return b.Bytes(), nil
+}
+
+// writeYAML writes value in block style, starting after a key on the
+// current line
+func writeYAML(b *bytes.Buffer, value interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("\n")
+		for _, key := range keys {
+			fmt.Fprintf(b, "%s%s:", indent, yamlScalar(key))
+			writeYAML(b, v[key], depth+1)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		for _, item := range v {
+			fmt.Fprintf(b, "%s-", indent)
+			writeYAML(b, item, depth+1)
+		}
+	default:
+		fmt.Fprintf(b, " %s\n", yamlScalar(v))
+	}
+}
+
+var plainYAML = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-/ ]*$`)
+
+// yamlScalar formats a scalar, quoting strings that YAML could read as
+// another type
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "false", "yes", "no", "on", "off", "null", "~":
+			return strconv.Quote(v)
+		}
+		if plainYAML.MatchString(v) && !strings.HasSuffix(v, " ") {
+			return v
+		}
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// goIdentifier turns an index name into an exported Go identifier
+func goIdentifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	identifier := b.String()
+	if identifier == "" || unicode.IsDigit(rune(identifier[0])) {
+		identifier = "Index" + identifier
+	}
+	return identifier
+}
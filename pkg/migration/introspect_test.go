@@ -0,0 +1,59 @@
+package migration
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestIntrospect(t *testing.T) {
+	transport := &routeTransport{routes: map[string]string{
+		"GET /articles": `{"articles_v1": {
+			"aliases": {"articles": {}},
+			"mappings": {"properties": {"title": {"type": "text"}}},
+			"settings": {"index.number_of_shards": "1", "index.uuid": "abc", "index.creation_date": "1700000000000"}
+		}}`,
+	}}
+
+	definition, err := Introspect(ClientFromTransport(transport), "articles")
+	if err != nil {
+		t.Fatalf("Failed to introspect index: %v", err)
+	}
+	if definition.Name != "articles_v1" {
+		t.Errorf("Expected the concrete index articles_v1, got %s", definition.Name)
+	}
+	if _, ok := definition.Settings["index.uuid"]; ok {
+		t.Error("Expected cluster-managed settings to be dropped")
+	}
+
+	t.Run("Test Go Output", func(t *testing.T) {
+		source, err := definition.RenderGo("migrations")
+		if err != nil {
+			t.Fatalf("Failed to render Go: %v", err)
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), "create.go", source, 0); err != nil {
+			t.Fatalf("Generated code does not parse: %v\n%s", err, source)
+		}
+		if !strings.Contains(string(source), "var CreateArticlesV1 = migration.NewMigration(") {
+			t.Errorf("Expected a CreateArticlesV1 migration, got:\n%s", source)
+		}
+	})
+
+	t.Run("Test YAML Output", func(t *testing.T) {
+		source, err := definition.RenderYAML()
+		if err != nil {
+			t.Fatalf("Failed to render YAML: %v", err)
+		}
+		for _, line := range []string{
+			"index: articles_v1",
+			"    index.number_of_shards: \"1\"",
+			"        type: text",
+			"    articles: {}",
+		} {
+			if !strings.Contains(string(source), line+"\n") {
+				t.Errorf("Expected YAML to contain %q, got:\n%s", line, source)
+			}
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LatencyQuery is a representative search run to benchmark an index
+type LatencyQuery struct {
+	Name string
+	// Body is the search request body
+	Body string
+	// Budget is the highest acceptable p95 latency, ignored when zero
+	Budget time.Duration
+}
+
+// LatencyCheck benchmarks queries against an index before and after a
+// migration and reports p95 regressions
+type LatencyCheck struct {
+	Index   string
+	Queries []LatencyQuery
+	// Iterations is the number of times each query runs (default 20)
+	Iterations int
+	// MaxRegression is the tolerated relative p95 increase over the baseline
+	// measured before the migration, e.g. 0.25 for 25%. Ignored when zero.
+	MaxRegression float64
+	// WarnOnly logs regressions instead of failing the run
+	WarnOnly bool
+}
+
+// WithLatencyCheck attaches a search latency benchmark to the migration
+func (m Migration) WithLatencyCheck(check LatencyCheck) Migration {
+	m.latencyCheck = &check
+	return m
+}
+
+// measureLatency returns the p95 latency of every query in check. Queries
+// against a missing index are skipped.
+func (mm *MigrationManager) measureLatency(check LatencyCheck) (map[string]time.Duration, error) {
+	exists, err := mm.Client.Indices.Exists([]string{check.Index})
+	if err != nil {
+		return nil, fmt.Errorf("error checking index %s: %w", check.Index, err)
+	}
+	exists.Body.Close()
+	if exists.StatusCode == 404 {
+		return nil, nil
+	}
+
+	iterations := check.Iterations
+	if iterations <= 0 {
+		iterations = 20
+	}
+
+	results := make(map[string]time.Duration, len(check.Queries))
+	for _, query := range check.Queries {
+		durations := make([]time.Duration, 0, iterations)
+
+		// The first request warms caches and is not measured
+		for i := 0; i <= iterations; i++ {
+			start := time.Now()
+			res, err := mm.Client.Search(
+				mm.Client.Search.WithIndex(check.Index),
+				mm.Client.Search.WithBody(strings.NewReader(query.Body)),
+				mm.Client.Search.WithRequestCache(false),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error running latency query %s: %w", query.Name, err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			elapsed := time.Since(start)
+
+			if res.IsError() {
+				return nil, fmt.Errorf("error running latency query %s: %s", query.Name, res.Status())
+			}
+			if i > 0 {
+				durations = append(durations, elapsed)
+			}
+		}
+
+		results[query.Name] = percentile(durations, 0.95)
+	}
+
+	return results, nil
+}
+
+// checkLatency compares the latencies measured after a migration against the
+// budgets and the baseline
+func (mm *MigrationManager) checkLatency(migration Migration, baseline map[string]time.Duration) error {
+	check := *migration.latencyCheck
+
+	after, err := mm.measureLatency(check)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, query := range check.Queries {
+		p95, ok := after[query.Name]
+		if !ok {
+			continue
+		}
+		mm.logf("Latency query %s on %s: p95 %s", query.Name, check.Index, p95)
+
+		if query.Budget > 0 && p95 > query.Budget {
+			problems = append(problems, fmt.Sprintf("%s p95 %s exceeds budget %s", query.Name, p95, query.Budget))
+		}
+		if before, ok := baseline[query.Name]; ok && check.MaxRegression > 0 {
+			limit := time.Duration(float64(before) * (1 + check.MaxRegression))
+			if p95 > limit {
+				problems = append(problems, fmt.Sprintf("%s p95 regressed from %s to %s", query.Name, before, p95))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("search latency regression after migration %s on %s: %s", migration.Version(), check.Index, strings.Join(problems, "; "))
+	if check.WarnOnly {
+		mm.logf("Warning: %s", message)
+		return nil
+	}
+	return fmt.Errorf("%s", message)
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := int(float64(len(sorted))*p+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
@@ -0,0 +1,184 @@
+package migration
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// delayTransport answers searches after delay, and every other request
+// immediately. The articles index exists unless missing is set.
+type delayTransport struct {
+	mu       sync.Mutex
+	delay    time.Duration
+	missing  bool
+	searches int
+}
+
+func (d *delayTransport) Perform(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	delay, missing := d.delay, d.missing
+	status := http.StatusOK
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_search"):
+		d.searches++
+	case req.Method == http.MethodHead && missing:
+		status = http.StatusNotFound
+	}
+	d.mu.Unlock()
+
+	if strings.HasSuffix(req.URL.Path, "/_search") {
+		time.Sleep(delay)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+func (d *delayTransport) setDelay(delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.delay = delay
+}
+
+func TestLatencyCheck(t *testing.T) {
+	check := LatencyCheck{
+		Index:      "articles",
+		Queries:    []LatencyQuery{{Name: "recent", Body: `{"query": {"match_all": {}}}`}},
+		Iterations: 3,
+	}
+
+	// slowDown registers a migration making searches take delay, followed by
+	// one that records whether the run reached it
+	slowDown := func(mm *MigrationManager, transport *delayTransport, delay time.Duration, check LatencyCheck) *bool {
+		slow := NewMigration("Drop the title index", func(client *elasticsearch.Client) error {
+			transport.setDelay(delay)
+			return nil
+		}).WithLatencyCheck(check)
+		mm.Register(slow)
+
+		reached := false
+		for i := 0; ; i++ {
+			next := NewMigration(fmt.Sprintf("Add summary field %d", i), func(client *elasticsearch.Client) error {
+				reached = true
+				return nil
+			})
+			if next.Version() > slow.Version() {
+				mm.Register(next)
+				return &reached
+			}
+		}
+	}
+
+	t.Run("Test P95 Of Delayed Searches", func(t *testing.T) {
+		transport := &delayTransport{delay: 10 * time.Millisecond}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+
+		latencies, err := mm.measureLatency(check)
+		if err != nil {
+			t.Fatalf("Failed to measure latency: %v", err)
+		}
+		if latencies["recent"] < 10*time.Millisecond {
+			t.Errorf("Expected a p95 of at least the injected delay, got %s", latencies["recent"])
+		}
+		// the warm-up search is not measured
+		if transport.searches != check.Iterations+1 {
+			t.Errorf("Expected %d searches, got %d", check.Iterations+1, transport.searches)
+		}
+	})
+
+	t.Run("Test Missing Index Is Skipped", func(t *testing.T) {
+		transport := &delayTransport{missing: true}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+
+		latencies, err := mm.measureLatency(check)
+		if err != nil || latencies != nil || transport.searches != 0 {
+			t.Errorf("Expected no measurement of a missing index, got %v %v after %d searches", latencies, err, transport.searches)
+		}
+	})
+
+	t.Run("Test Exceeded Budget Aborts The Run", func(t *testing.T) {
+		transport := &delayTransport{}
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store), WithLogger(log.New(io.Discard, "", 0)))
+
+		budget := check
+		budget.Queries = []LatencyQuery{{Name: "recent", Body: `{}`, Budget: 5 * time.Millisecond}}
+		reached := slowDown(mm, transport, 20*time.Millisecond, budget)
+
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "recent p95") || !strings.Contains(err.Error(), "exceeds budget 5ms") {
+			t.Fatalf("Expected the budget to be exceeded, got %v", err)
+		}
+		if *reached {
+			t.Error("Expected the run to stop after the regression")
+		}
+		// the migration itself succeeded and stays recorded
+		if records, _ := store.GetApplied(); len(records) != 1 {
+			t.Errorf("Expected the checked migration to be recorded, got %v", records)
+		}
+	})
+
+	t.Run("Test Regression Over The Baseline Aborts The Run", func(t *testing.T) {
+		transport := &delayTransport{delay: 2 * time.Millisecond}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithLogger(log.New(io.Discard, "", 0)))
+
+		regression := check
+		regression.MaxRegression = 0.5
+		reached := slowDown(mm, transport, 30*time.Millisecond, regression)
+
+		if err := mm.RunMigrations(); err == nil || !strings.Contains(err.Error(), "recent p95 regressed from") {
+			t.Fatalf("Expected a regression over the baseline, got %v", err)
+		}
+		if *reached {
+			t.Error("Expected the run to stop after the regression")
+		}
+	})
+
+	t.Run("Test Latency Within The Threshold Passes", func(t *testing.T) {
+		transport := &delayTransport{delay: 5 * time.Millisecond}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithLogger(log.New(io.Discard, "", 0)))
+
+		regression := check
+		regression.MaxRegression = 10
+		reached := slowDown(mm, transport, 5*time.Millisecond, regression)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Expected the latency to stay within the threshold, got %v", err)
+		}
+		if !*reached {
+			t.Error("Expected the run to continue")
+		}
+	})
+
+	t.Run("Test Warn Only Logs The Regression", func(t *testing.T) {
+		transport := &delayTransport{}
+		var output bytes.Buffer
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithLogger(log.New(&output, "", 0)))
+
+		warn := check
+		warn.WarnOnly = true
+		warn.Queries = []LatencyQuery{{Name: "recent", Body: `{}`, Budget: 5 * time.Millisecond}}
+		reached := slowDown(mm, transport, 20*time.Millisecond, warn)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Expected the regression not to fail the run, got %v", err)
+		}
+		if !*reached {
+			t.Error("Expected the run to continue")
+		}
+		if !strings.Contains(output.String(), "Warning: search latency regression") {
+			t.Errorf("Expected the regression to be logged, got %q", output.String())
+		}
+	})
+}
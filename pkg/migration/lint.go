@@ -0,0 +1,168 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LintSeverity ranks lint issues
+type LintSeverity string
+
+const (
+	// LintError marks a problem that makes the migrations fail or misbehave
+	LintError LintSeverity = "error"
+	// LintWarning marks a likely mistake
+	LintWarning LintSeverity = "warning"
+)
+
+// Lint rules
+const (
+	LintDuplicateVersion     = "duplicate-version"
+	LintDuplicateDescription = "duplicate-description"
+	LintMissingDown          = "missing-down"
+	LintOversizedBulk        = "oversized-bulk"
+	LintUnknownFieldType     = "unknown-field-type"
+	LintInvalid              = "invalid"
+	LintDependencies         = "dependencies"
+)
+
+// lintMaxBulkBytes is the bulk body size above which requests are reported,
+// well above the 5 to 15 MB Elasticsearch recommends per bulk request
+const lintMaxBulkBytes = 20 << 20
+
+// fieldTypes are the mapping field types of Elasticsearch and OpenSearch
+var fieldTypes = map[string]bool{
+	"aggregate_metric_double": true, "alias": true, "annotated_text": true, "binary": true,
+	"boolean": true, "byte": true, "completion": true, "constant_keyword": true,
+	"counted_keyword": true, "date": true, "date_nanos": true, "date_range": true,
+	"dense_vector": true, "double": true, "double_range": true, "flat_object": true,
+	"flattened": true, "float": true, "float_range": true, "geo_point": true,
+	"geo_shape": true, "half_float": true, "histogram": true, "integer": true,
+	"integer_range": true, "ip": true, "ip_range": true, "join": true,
+	"keyword": true, "knn_vector": true, "long": true, "long_range": true,
+	"match_only_text": true, "murmur3": true, "nested": true, "object": true,
+	"passthrough": true, "percolator": true, "point": true, "rank_feature": true,
+	"rank_features": true, "scaled_float": true, "search_as_you_type": true,
+	"semantic_text": true, "shape": true, "short": true, "sparse_vector": true,
+	"text": true, "token_count": true, "unsigned_long": true, "version": true,
+	"wildcard": true,
+}
+
+// LintIssue is a problem found in a migration definition
+type LintIssue struct {
+	Rule        string
+	Severity    LintSeverity
+	Version     string
+	Description string
+	Message     string
+}
+
+func (i LintIssue) String() string {
+	if i.Version == "" {
+		return fmt.Sprintf("%s [%s] %s", i.Severity, i.Rule, i.Message)
+	}
+	return fmt.Sprintf("%s %s [%s] %s: %s", i.Severity, i.Version, i.Rule, i.Description, i.Message)
+}
+
+// LintReport is the result of Lint
+type LintReport struct {
+	Issues []LintIssue
+}
+
+// HasErrors reports whether an issue has error severity
+func (r *LintReport) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *LintReport) String() string {
+	if len(r.Issues) == 0 {
+		return "No problems found.\n"
+	}
+	var b strings.Builder
+	errors := 0
+	for _, issue := range r.Issues {
+		fmt.Fprintf(&b, "%s\n", issue)
+		if issue.Severity == LintError {
+			errors++
+		}
+	}
+	fmt.Fprintf(&b, "\n%d problems (%d errors, %d warnings)\n", len(r.Issues), errors, len(r.Issues)-errors)
+	return b.String()
+}
+
+func (r *LintReport) add(rule string, severity LintSeverity, m Migration, format string, v ...interface{}) {
+	r.Issues = append(r.Issues, LintIssue{
+		Rule:        rule,
+		Severity:    severity,
+		Version:     m.Version(),
+		Description: m.Description,
+		Message:     fmt.Sprintf(format, v...),
+	})
+}
+
+// Lint checks the registered migrations for common problems without
+// contacting the cluster: duplicate versions and descriptions, destructive
+// migrations that cannot be rolled back, bulk bodies too large to send,
+// unknown mapping field types, invalid definitions and broken dependencies.
+// Issues are ordered by migration version.
+func (mm *MigrationManager) Lint() *LintReport {
+	migrations := append([]Migration{}, mm.Migrations...)
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].Version() < migrations[j].Version()
+	})
+
+	report := &LintReport{}
+	versions := make(map[string]Migration)
+	descriptions := make(map[string]Migration)
+	for _, m := range migrations {
+		if first, ok := versions[m.Version()]; ok {
+			report.add(LintDuplicateVersion, LintError, m, "version is also used by %q; only one of them is applied", first.Description)
+		} else {
+			versions[m.Version()] = m
+		}
+		// migrations of one builder share a function, so only the
+		// description tells their versions apart
+		if first, ok := descriptions[m.Description]; ok && first.Version() != m.Version() {
+			report.add(LintDuplicateDescription, LintWarning, m, "description is also used by migration %s; changing either function may collide their versions", first.Version())
+		} else if !ok {
+			descriptions[m.Description] = m
+		}
+
+		if m.IsDestructive() && !m.HasDown() {
+			report.add(LintMissingDown, LintWarning, m, "destructive migration has no down function to roll it back")
+		}
+		if err := m.Validate(); err != nil {
+			report.add(LintInvalid, LintError, m, "%v", err)
+		} else if m.bulkBytes != nil {
+			size, err := m.bulkBytes()
+			if err != nil {
+				report.add(LintInvalid, LintError, m, "%v", err)
+			} else if size > lintMaxBulkBytes {
+				report.add(LintOversizedBulk, LintWarning, m, "bulk request of %d MB exceeds %d MB; lower the batch size", size>>20, lintMaxBulkBytes>>20)
+			}
+		}
+		for _, desired := range m.desired {
+			fields := make([]string, 0, len(desired.mapping))
+			for name := range desired.mapping {
+				fields = append(fields, name)
+			}
+			sort.Strings(fields)
+			for _, name := range fields {
+				if fieldType := desired.mapping[name].Type; !fieldTypes[fieldType] {
+					report.add(LintUnknownFieldType, LintError, m, "field %s of %s has unknown type %q", name, desired.index, fieldType)
+				}
+			}
+		}
+	}
+
+	if err := sortMigrations(append([]Migration{}, migrations...)); err != nil {
+		report.Issues = append(report.Issues, LintIssue{Rule: LintDependencies, Severity: LintError, Message: err.Error()})
+	}
+	return report
+}
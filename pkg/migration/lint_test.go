@@ -0,0 +1,82 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestLint(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	lint := func(migrations ...Migration) *LintReport {
+		mm := NewMigrationManager(nil)
+		for _, migration := range migrations {
+			mm.Register(migration)
+		}
+		return mm.Lint()
+	}
+	rules := func(report *LintReport) string {
+		var rules []string
+		for _, issue := range report.Issues {
+			rules = append(rules, issue.Rule)
+		}
+		return strings.Join(rules, ",")
+	}
+
+	t.Run("Test Clean Migrations", func(t *testing.T) {
+		report := lint(
+			PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}}}`),
+			DeleteStoredScript("Drop old script", "old").WithDown(noop),
+		)
+		if len(report.Issues) != 0 {
+			t.Errorf("Expected no issues, got %s", report)
+		}
+	})
+
+	t.Run("Test Duplicates", func(t *testing.T) {
+		m := NewMigration("Create articles index", noop)
+		report := lint(m, m, PutMapping("Create articles index", "articles", `{"properties": {"tags": {"type": "keyword"}}}`))
+		if rules(report) != "duplicate-version,duplicate-description" && rules(report) != "duplicate-description,duplicate-version" {
+			t.Errorf("Expected a duplicate version and description, got %s", report)
+		}
+		if !report.HasErrors() {
+			t.Error("Expected a duplicate version to be an error")
+		}
+	})
+
+	t.Run("Test Destructive Migration Without Down", func(t *testing.T) {
+		report := lint(DeleteStoredScript("Drop old script", "old"))
+		if rules(report) != LintMissingDown || report.HasErrors() {
+			t.Errorf("Expected a missing down warning, got %s", report)
+		}
+	})
+
+	t.Run("Test Unknown Field Type", func(t *testing.T) {
+		report := lint(PutMapping("Add tags to articles", "articles", `{"properties": {"author": {"properties": {"name": {"type": "keywrod"}}}}}`))
+		if rules(report) != LintUnknownFieldType || !strings.Contains(report.Issues[0].Message, `field author.name of articles has unknown type "keywrod"`) {
+			t.Errorf("Expected an unknown type error, got %s", report)
+		}
+	})
+
+	t.Run("Test Oversized Bulk", func(t *testing.T) {
+		doc := `{"body": "` + strings.Repeat("x", 1<<20) + `"}`
+		data := strings.TrimSuffix(strings.Repeat(doc+"\n", 25), "\n")
+		report := lint(SeedMigration("Seed articles", SeedConfig{Index: "articles", Data: []byte(data)}))
+		if rules(report) != LintOversizedBulk {
+			t.Errorf("Expected an oversized bulk warning, got %s", report)
+		}
+
+		report = lint(SeedMigration("Seed articles", SeedConfig{Index: "articles", Data: []byte(data), BatchSize: 10}))
+		if len(report.Issues) != 0 {
+			t.Errorf("Expected smaller batches to pass, got %s", report)
+		}
+	})
+
+	t.Run("Test Invalid Definitions And Dependencies", func(t *testing.T) {
+		report := lint(SeedMigration("Seed articles", SeedConfig{}), NewMigration("Add tags", noop).DependsOn("Create articles index"))
+		if rules(report) != "invalid,dependencies" && rules(report) != "dependencies,invalid" {
+			t.Errorf("Expected an invalid definition and a dependency error, got %s", report)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	maintenanceIndex      = ".elasticmate_maintenance"
+	maintenanceDocumentID = "flag"
+)
+
+// MaintenanceFlag tells application instances that migrations are in progress
+// and writes to the listed indices should be paused
+type MaintenanceFlag struct {
+	Active  bool      `json:"active"`
+	Indices []string  `json:"indices"`
+	Reason  string    `json:"reason"`
+	Version string    `json:"version,omitempty"`
+	SetAt   time.Time `json:"set_at"`
+}
+
+// Affects reports whether writes to index should be paused. A flag without
+// indices affects every index.
+func (f MaintenanceFlag) Affects(index string) bool {
+	if !f.Active {
+		return false
+	}
+	if len(f.Indices) == 0 {
+		return true
+	}
+	for _, i := range f.Indices {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// WithMaintenance sets the maintenance flag for the given indices while the
+// migration runs and clears it afterwards, whether it succeeds or fails
+func (m Migration) WithMaintenance(indices ...string) Migration {
+	m.maintenanceIndices = append([]string{}, indices...)
+	if len(m.maintenanceIndices) == 0 {
+		m.maintenanceIndices = []string{"*"}
+	}
+	return m
+}
+
+func (m Migration) requiresMaintenance() bool {
+	return len(m.maintenanceIndices) > 0
+}
+
+// SetMaintenance stores the maintenance flag in the cluster
+func SetMaintenance(client *elasticsearch.Client, flag MaintenanceFlag) error {
+	flag.Active = true
+	if flag.SetAt.IsZero() {
+		flag.SetAt = time.Now()
+	}
+	if len(flag.Indices) == 1 && flag.Indices[0] == "*" {
+		flag.Indices = nil
+	}
+
+	data, err := json.Marshal(flag)
+	if err != nil {
+		return fmt.Errorf("error marshaling maintenance flag: %w", err)
+	}
+
+	res, err := client.Index(
+		maintenanceIndex,
+		strings.NewReader(string(data)),
+		client.Index.WithDocumentID(maintenanceDocumentID),
+		client.Index.WithRefresh("true"),
+	)
+	if err != nil {
+		return fmt.Errorf("error setting maintenance flag: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error setting maintenance flag: %s", res.String())
+	}
+
+	return nil
+}
+
+// ClearMaintenance removes the maintenance flag so writes can resume
+func ClearMaintenance(client *elasticsearch.Client) error {
+	res, err := client.Delete(maintenanceIndex, maintenanceDocumentID, client.Delete.WithRefresh("true"))
+	if err != nil {
+		return fmt.Errorf("error clearing maintenance flag: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error clearing maintenance flag: %s", res.String())
+	}
+
+	return nil
+}
+
+// GetMaintenance returns the current maintenance flag. An inactive flag is
+// returned when none is set.
+func GetMaintenance(client *elasticsearch.Client) (MaintenanceFlag, error) {
+	var flag MaintenanceFlag
+
+	res, err := client.Get(maintenanceIndex, maintenanceDocumentID)
+	if err != nil {
+		return flag, fmt.Errorf("error reading maintenance flag: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return flag, nil
+	}
+	if res.IsError() {
+		return flag, fmt.Errorf("error reading maintenance flag: %s", res.String())
+	}
+
+	var doc struct {
+		Source MaintenanceFlag `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return flag, fmt.Errorf("error parsing maintenance flag: %w", err)
+	}
+
+	return doc.Source, nil
+}
+
+// MaintenanceWatcher polls the maintenance flag so application instances can
+// pause writes while sensitive migrations run
+type MaintenanceWatcher struct {
+	client   *elasticsearch.Client
+	interval time.Duration
+
+	mu      sync.RWMutex
+	flag    MaintenanceFlag
+	changed chan struct{}
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewMaintenanceWatcher(client *elasticsearch.Client, interval time.Duration) *MaintenanceWatcher {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &MaintenanceWatcher{
+		client:   client,
+		interval: interval,
+		changed:  make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start polls the flag in the background until Stop is called. Calling it
+// again, or after Stop, does nothing.
+func (w *MaintenanceWatcher) Start() {
+	w.startOnce.Do(func() {
+		go func() {
+			defer close(w.done)
+
+			ticker := time.NewTicker(w.interval)
+			defer ticker.Stop()
+
+			for {
+				w.poll()
+				select {
+				case <-w.stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	})
+}
+
+// Stop stops polling and waits for a poll in progress to finish. It is safe
+// to call more than once, and before Start.
+func (w *MaintenanceWatcher) Stop() {
+	// a watcher that was never started is marked done, so Start does nothing
+	w.startOnce.Do(func() { close(w.done) })
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *MaintenanceWatcher) poll() {
+	flag, err := GetMaintenance(w.client)
+	if err != nil {
+		// Keep the last known state when the cluster can't be reached
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.flag = flag
+	if !flag.Active {
+		close(w.changed)
+		w.changed = make(chan struct{})
+	}
+}
+
+// WritesPaused reports whether writes to index should currently be paused
+func (w *MaintenanceWatcher) WritesPaused(index string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.flag.Affects(index)
+}
+
+// WaitForWrites blocks until writes to index may resume or ctx is done
+func (w *MaintenanceWatcher) WaitForWrites(ctx context.Context, index string) error {
+	for {
+		w.mu.RLock()
+		paused := w.flag.Affects(index)
+		changed := w.changed
+		w.mu.RUnlock()
+
+		if !paused {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package migration_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestMaintenanceFlagAffects(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		flag     migration.MaintenanceFlag
+		index    string
+		expected bool
+	}{
+		{name: "Test Inactive Flag", flag: migration.MaintenanceFlag{Indices: []string{"articles"}}, index: "articles", expected: false},
+		{name: "Test Every Index", flag: migration.MaintenanceFlag{Active: true}, index: "articles", expected: true},
+		{name: "Test Listed Index", flag: migration.MaintenanceFlag{Active: true, Indices: []string{"users", "articles"}}, index: "articles", expected: true},
+		{name: "Test Other Index", flag: migration.MaintenanceFlag{Active: true, Indices: []string{"users"}}, index: "articles", expected: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if affects := test.flag.Affects(test.index); affects != test.expected {
+				t.Errorf("Expected Affects(%q) to be %v, got %v", test.index, test.expected, affects)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWatcher(t *testing.T) {
+	es := migrationtest.NewFakeES(t)
+	client := es.Client()
+
+	// eventually polls until the watcher reports want for articles
+	eventually := func(w *migration.MaintenanceWatcher, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for w.WritesPaused("articles") != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected writes paused to become %v", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("Test Flag Is Polled", func(t *testing.T) {
+		w := migration.NewMaintenanceWatcher(client, 10*time.Millisecond)
+		w.Start()
+		defer w.Stop()
+
+		if w.WritesPaused("articles") {
+			t.Errorf("Expected writes to flow without a flag")
+		}
+		if err := migration.SetMaintenance(client, migration.MaintenanceFlag{Indices: []string{"articles"}, Reason: "reindex"}); err != nil {
+			t.Fatalf("Failed to set the flag: %v", err)
+		}
+		eventually(w, true)
+		if w.WritesPaused("users") {
+			t.Errorf("Expected writes to an unlisted index to flow")
+		}
+
+		waited := make(chan error, 1)
+		go func() { waited <- w.WaitForWrites(context.Background(), "articles") }()
+		select {
+		case err := <-waited:
+			t.Fatalf("Expected WaitForWrites to block while the flag is set, got %v", err)
+		case <-time.After(30 * time.Millisecond):
+		}
+
+		if err := migration.ClearMaintenance(client); err != nil {
+			t.Fatalf("Failed to clear the flag: %v", err)
+		}
+		select {
+		case err := <-waited:
+			if err != nil {
+				t.Errorf("Expected writes to resume, got %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected WaitForWrites to return once the flag is cleared")
+		}
+		eventually(w, false)
+	})
+
+	t.Run("Test Wait Ends With The Context", func(t *testing.T) {
+		if err := migration.SetMaintenance(client, migration.MaintenanceFlag{}); err != nil {
+			t.Fatalf("Failed to set the flag: %v", err)
+		}
+		defer migration.ClearMaintenance(client)
+
+		w := migration.NewMaintenanceWatcher(client, 10*time.Millisecond)
+		w.Start()
+		defer w.Stop()
+		eventually(w, true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := w.WaitForWrites(ctx, "articles"); err != context.DeadlineExceeded {
+			t.Errorf("Expected the deadline to end the wait, got %v", err)
+		}
+	})
+
+	t.Run("Test Start And Stop Are Idempotent", func(t *testing.T) {
+		w := migration.NewMaintenanceWatcher(client, 10*time.Millisecond)
+		w.Start()
+		w.Start()
+		w.Stop()
+		w.Stop()
+		w.Start()
+
+		unstarted := migration.NewMaintenanceWatcher(client, 10*time.Millisecond)
+		unstarted.Stop()
+		unstarted.Stop()
+	})
+}
@@ -0,0 +1,307 @@
+// Package mapping builds index mappings in Go instead of raw JSON strings:
+//
+//	body, err := mapping.New().
+//		Text("title", mapping.Analyzer("english"), mapping.Fields(mapping.New().Keyword("raw"))).
+//		Keyword("author").
+//		Date("created_at", mapping.Format("strict_date_optional_time")).
+//		Nested("products", mapping.New().Keyword("sku").ScaledFloat("price", 100)).
+//		JSON()
+//
+// The rendered body can be passed to migration.PutMapping or
+// Migration.WithDesiredMapping.
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Mapping is a mapping being built. Its methods add fields and return the
+// mapping so calls can be chained; the first mistake, such as a field added
+// twice, is reported when the mapping is rendered.
+type Mapping struct {
+	properties map[string]map[string]interface{}
+	params     map[string]interface{}
+	err        error
+}
+
+// New returns an empty mapping
+func New() *Mapping {
+	return &Mapping{properties: map[string]map[string]interface{}{}, params: map[string]interface{}{}}
+}
+
+// Param sets a mapping parameter on a field
+type Param func(field map[string]interface{})
+
+// With sets any mapping parameter not covered by a helper
+func With(name string, value interface{}) Param {
+	return func(field map[string]interface{}) {
+		field[name] = value
+	}
+}
+
+// Analyzer sets the analyzer of a text field
+func Analyzer(name string) Param {
+	return With("analyzer", name)
+}
+
+// SearchAnalyzer sets the analyzer used at search time
+func SearchAnalyzer(name string) Param {
+	return With("search_analyzer", name)
+}
+
+// Normalizer sets the normalizer of a keyword field
+func Normalizer(name string) Param {
+	return With("normalizer", name)
+}
+
+// Format sets the format of a date field
+func Format(format string) Param {
+	return With("format", format)
+}
+
+// IgnoreAbove skips indexing keyword values longer than length
+func IgnoreAbove(length int) Param {
+	return With("ignore_above", length)
+}
+
+// NotIndexed keeps a field out of the index so it cannot be searched
+func NotIndexed() Param {
+	return With("index", false)
+}
+
+// NoDocValues disables doc values, so the field cannot be sorted or
+// aggregated on
+func NoDocValues() Param {
+	return With("doc_values", false)
+}
+
+// CopyTo copies the field's values into other fields
+func CopyTo(fields ...string) Param {
+	return With("copy_to", fields)
+}
+
+// NullValue indexes value in place of explicit nulls
+func NullValue(value interface{}) Param {
+	return With("null_value", value)
+}
+
+// Fields adds multi-fields, indexing the same value in other ways, e.g. a
+// keyword "raw" field under a text field
+func Fields(fields *Mapping) Param {
+	return func(field map[string]interface{}) {
+		field["fields"] = properties{fields}
+	}
+}
+
+// properties renders the fields of a mapping without its own parameters, for
+// the properties of objects and for multi-fields
+type properties struct {
+	m *Mapping
+}
+
+func (p properties) MarshalJSON() ([]byte, error) {
+	if err := p.m.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(p.m.properties)
+}
+
+// Field adds a field of any type
+func (m *Mapping) Field(name, fieldType string, params ...Param) *Mapping {
+	if m.err != nil {
+		return m
+	}
+	if name == "" {
+		m.err = fmt.Errorf("field of type %s has no name", fieldType)
+		return m
+	}
+	if _, ok := m.properties[name]; ok {
+		m.err = fmt.Errorf("field %s is mapped twice", name)
+		return m
+	}
+
+	field := map[string]interface{}{}
+	if fieldType != "" {
+		field["type"] = fieldType
+	}
+	for _, param := range params {
+		param(field)
+	}
+	m.properties[name] = field
+	return m
+}
+
+func (m *Mapping) Text(name string, params ...Param) *Mapping {
+	return m.Field(name, "text", params...)
+}
+
+func (m *Mapping) Keyword(name string, params ...Param) *Mapping {
+	return m.Field(name, "keyword", params...)
+}
+
+func (m *Mapping) Wildcard(name string, params ...Param) *Mapping {
+	return m.Field(name, "wildcard", params...)
+}
+
+func (m *Mapping) Date(name string, params ...Param) *Mapping {
+	return m.Field(name, "date", params...)
+}
+
+func (m *Mapping) Boolean(name string, params ...Param) *Mapping {
+	return m.Field(name, "boolean", params...)
+}
+
+func (m *Mapping) Long(name string, params ...Param) *Mapping {
+	return m.Field(name, "long", params...)
+}
+
+func (m *Mapping) Integer(name string, params ...Param) *Mapping {
+	return m.Field(name, "integer", params...)
+}
+
+func (m *Mapping) Short(name string, params ...Param) *Mapping {
+	return m.Field(name, "short", params...)
+}
+
+func (m *Mapping) Double(name string, params ...Param) *Mapping {
+	return m.Field(name, "double", params...)
+}
+
+func (m *Mapping) Float(name string, params ...Param) *Mapping {
+	return m.Field(name, "float", params...)
+}
+
+// ScaledFloat adds a float stored as a long scaled by factor, e.g. 100 for
+// prices in cents
+func (m *Mapping) ScaledFloat(name string, factor float64, params ...Param) *Mapping {
+	if factor <= 0 && m.err == nil {
+		m.err = fmt.Errorf("scaled_float field %s needs a positive scaling factor", name)
+		return m
+	}
+	return m.Field(name, "scaled_float", append([]Param{With("scaling_factor", factor)}, params...)...)
+}
+
+func (m *Mapping) IP(name string, params ...Param) *Mapping {
+	return m.Field(name, "ip", params...)
+}
+
+func (m *Mapping) GeoPoint(name string, params ...Param) *Mapping {
+	return m.Field(name, "geo_point", params...)
+}
+
+func (m *Mapping) Binary(name string, params ...Param) *Mapping {
+	return m.Field(name, "binary", params...)
+}
+
+func (m *Mapping) Flattened(name string, params ...Param) *Mapping {
+	return m.Field(name, "flattened", params...)
+}
+
+func (m *Mapping) Completion(name string, params ...Param) *Mapping {
+	return m.Field(name, "completion", params...)
+}
+
+// DenseVector adds a vector field of dims dimensions
+func (m *Mapping) DenseVector(name string, dims int, params ...Param) *Mapping {
+	if dims <= 0 && m.err == nil {
+		m.err = fmt.Errorf("dense_vector field %s needs a positive number of dimensions", name)
+		return m
+	}
+	return m.Field(name, "dense_vector", append([]Param{With("dims", dims)}, params...)...)
+}
+
+// Object adds an object field with the fields of sub. Parameters set on sub,
+// such as Dynamic, apply to the object.
+func (m *Mapping) Object(name string, sub *Mapping, params ...Param) *Mapping {
+	return m.Field(name, "", append(sub.objectParams(), params...)...)
+}
+
+// Nested adds a nested field, whose objects are indexed as separate documents
+// so their fields can be queried together
+func (m *Mapping) Nested(name string, sub *Mapping, params ...Param) *Mapping {
+	return m.Field(name, "nested", append(sub.objectParams(), params...)...)
+}
+
+func (m *Mapping) objectParams() []Param {
+	params := []Param{With("properties", properties{m})}
+	for key, value := range m.params {
+		params = append(params, With(key, value))
+	}
+	return params
+}
+
+// Dynamic sets how unmapped fields are handled: "true", "false", "strict" or
+// "runtime"
+func (m *Mapping) Dynamic(value string) *Mapping {
+	switch value {
+	case "true", "false", "strict", "runtime":
+		m.params["dynamic"] = value
+	default:
+		if m.err == nil {
+			m.err = fmt.Errorf("invalid dynamic value %q", value)
+		}
+	}
+	return m
+}
+
+// Meta sets the _meta of the mapping
+func (m *Mapping) Meta(meta map[string]interface{}) *Mapping {
+	m.params["_meta"] = meta
+	return m
+}
+
+// Err returns the first mistake made building the mapping, including in
+// nested properties and multi-fields
+func (m *Mapping) Err() error {
+	if m.err != nil {
+		return m.err
+	}
+	for name, field := range m.properties {
+		for _, key := range []string{"properties", "fields"} {
+			if sub, ok := field[key].(properties); ok {
+				if err := sub.m.Err(); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalJSON renders the mapping as {"properties": {...}}
+func (m *Mapping) MarshalJSON() ([]byte, error) {
+	if err := m.Err(); err != nil {
+		return nil, err
+	}
+	body := make(map[string]interface{}, len(m.params)+1)
+	for key, value := range m.params {
+		body[key] = value
+	}
+	body["properties"] = m.properties
+	return json.Marshal(body)
+}
+
+// JSON renders the mapping as {"properties": {...}}, the body PutMapping
+// expects
+func (m *Mapping) JSON() (string, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("error rendering mapping: %w", err)
+	}
+	return string(body), nil
+}
+
+// IndexBody renders an index creation body, {"mappings": {...}}, with
+// optional settings
+func (m *Mapping) IndexBody(settings map[string]interface{}) (string, error) {
+	body := map[string]interface{}{"mappings": m}
+	if len(settings) > 0 {
+		body["settings"] = settings
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("error rendering mapping: %w", err)
+	}
+	return string(data), nil
+}
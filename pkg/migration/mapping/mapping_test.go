@@ -0,0 +1,82 @@
+package mapping
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration/schema"
+)
+
+func TestMapping(t *testing.T) {
+	t.Run("Test Fields Render", func(t *testing.T) {
+		body, err := New().
+			Dynamic("strict").
+			Text("title", Analyzer("english"), Fields(New().Keyword("raw", IgnoreAbove(256)))).
+			Keyword("author").
+			Date("created_at", Format("strict_date_optional_time")).
+			Nested("products", New().Keyword("sku").ScaledFloat("price", 100)).
+			Object("meta", New().Dynamic("false").Boolean("draft")).
+			JSON()
+		if err != nil {
+			t.Fatalf("Failed to render mapping: %v", err)
+		}
+
+		expected := `{
+			"dynamic": "strict",
+			"properties": {
+				"title": {"type": "text", "analyzer": "english", "fields": {"raw": {"type": "keyword", "ignore_above": 256}}},
+				"author": {"type": "keyword"},
+				"created_at": {"type": "date", "format": "strict_date_optional_time"},
+				"products": {"type": "nested", "properties": {"sku": {"type": "keyword"}, "price": {"type": "scaled_float", "scaling_factor": 100}}},
+				"meta": {"dynamic": "false", "properties": {"draft": {"type": "boolean"}}}
+			}
+		}`
+		var got, want interface{}
+		json.Unmarshal([]byte(body), &got)
+		json.Unmarshal([]byte(expected), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected mapping:\n%s", body)
+		}
+
+		parsed, err := schema.Parse([]byte(body))
+		if err != nil {
+			t.Fatalf("Failed to parse rendered mapping: %v", err)
+		}
+		for _, field := range []string{"title.raw", "products.sku", "meta.draft"} {
+			if _, ok := parsed[field]; !ok {
+				t.Errorf("Expected %s in the parsed mapping, got %v", field, parsed)
+			}
+		}
+	})
+
+	t.Run("Test Index Body", func(t *testing.T) {
+		body, err := New().Keyword("id").IndexBody(map[string]interface{}{"number_of_shards": 1})
+		if err != nil {
+			t.Fatalf("Failed to render index body: %v", err)
+		}
+		if body != `{"mappings":{"properties":{"id":{"type":"keyword"}}},"settings":{"number_of_shards":1}}` {
+			t.Errorf("Unexpected index body: %s", body)
+		}
+	})
+
+	t.Run("Test Mistakes Are Reported", func(t *testing.T) {
+		for _, m := range []*Mapping{
+			New().Keyword("id").Long("id"),
+			New().Text(""),
+			New().Dynamic("sometimes"),
+			New().ScaledFloat("price", 0),
+			New().Nested("products", New().Keyword("sku").Keyword("sku")),
+		} {
+			if _, err := m.JSON(); err == nil {
+				t.Errorf("Expected an error for %v", m.properties)
+			}
+		}
+
+		_, err := New().Nested("products", New().Keyword("sku").Keyword("sku")).JSON()
+		if err == nil || !strings.Contains(err.Error(), "products: field sku is mapped twice") {
+			t.Errorf("Expected the error to name the nested field, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// FromStruct derives the mapping of the documents v encodes to, so the index
+// stays in sync with the structs a service indexes. Fields are named by their
+// json tag and typed by their es tag, the type followed by parameters:
+//
+//	type Article struct {
+//		Title    string    `json:"title" es:"text,analyzer=english,fields.raw=keyword"`
+//		Author   string    `json:"author" es:"keyword,ignore_above=256"`
+//		Products []Product `json:"products" es:"nested"`
+//		Internal string    `json:"internal" es:"-"`
+//	}
+//
+// A fields.<name>=<type> parameter adds a multi-field. Untagged fields are
+// typed from their Go type: strings as keyword, integers as long, floats as
+// double, time.Time as date and structs as objects. Slices are mapped as
+// their element. Mistakes, such as a struct containing itself, are reported
+// when the mapping is rendered.
+func FromStruct(v any) *Mapping {
+	m := New()
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		m.err = fmt.Errorf("cannot derive a mapping from %T, expected a struct", v)
+		return m
+	}
+	m.err = m.addStruct(t, map[reflect.Type]bool{})
+	return m
+}
+
+func (m *Mapping) addStruct(t reflect.Type, visiting map[reflect.Type]bool) error {
+	if visiting[t] {
+		return fmt.Errorf("%s contains itself", t)
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// encoding/json promotes the fields of unexported embedded structs
+		if !field.IsExported() && !(field.Anonymous && elemType(field.Type).Kind() == reflect.Struct) {
+			continue
+		}
+		name, skip := jsonName(field)
+		tag, tagged := field.Tag.Lookup("es")
+		if skip || tag == "-" {
+			continue
+		}
+
+		// embedded structs without a json name are flattened, like encoding/json
+		ft := elemType(field.Type)
+		if field.Anonymous && name == "" && !tagged && ft.Kind() == reflect.Struct && ft != timeType {
+			if err := m.addStruct(ft, visiting); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		props, err := fieldMapping(ft, tag, visiting)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if _, ok := m.properties[name]; ok {
+			return fmt.Errorf("field %s is mapped twice", name)
+		}
+		m.properties[name] = props
+	}
+	return nil
+}
+
+// fieldMapping returns the mapping of a field of type t tagged with tag
+func fieldMapping(t reflect.Type, tag string, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	parts := strings.Split(tag, ",")
+	fieldType := strings.TrimSpace(parts[0])
+	if fieldType == "" {
+		fieldType = inferType(t)
+		if fieldType == "" {
+			return nil, fmt.Errorf("cannot infer a type for %s; set one with an es tag", t)
+		}
+	}
+
+	props := map[string]interface{}{}
+	if fieldType != "object" {
+		props["type"] = fieldType
+	}
+	if (fieldType == "object" || fieldType == "nested") && t.Kind() == reflect.Struct {
+		sub := New()
+		if err := sub.addStruct(t, visiting); err != nil {
+			return nil, err
+		}
+		props["properties"] = properties{sub}
+	}
+
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid es tag parameter %q, expected name=value", part)
+		}
+		if sub, ok := strings.CutPrefix(key, "fields."); ok {
+			fields, _ := props["fields"].(map[string]interface{})
+			if fields == nil {
+				fields = map[string]interface{}{}
+				props["fields"] = fields
+			}
+			fields[sub] = map[string]interface{}{"type": value}
+			continue
+		}
+		props[key] = tagValue(value)
+	}
+	return props, nil
+}
+
+// elemType dereferences pointers and slices to the type of the values indexed
+func elemType(t reflect.Type) reflect.Type {
+	for {
+		switch {
+		case t.Kind() == reflect.Pointer:
+			t = t.Elem()
+		case t == rawType || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8):
+			return t
+		case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+			t = t.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+func inferType(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "date"
+	case t == rawType:
+		return "object"
+	case t.Kind() == reflect.Slice:
+		// []byte, encoded as base64 by encoding/json
+		return "binary"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "keyword"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return "long"
+	case reflect.Int32, reflect.Uint16:
+		return "integer"
+	case reflect.Int16, reflect.Uint8:
+		return "short"
+	case reflect.Int8:
+		return "byte"
+	case reflect.Float64:
+		return "double"
+	case reflect.Float32:
+		return "float"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return ""
+}
+
+// tagValue types a tag parameter value as JSON would
+func tagValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
+
+// jsonName returns the name encoding/json gives a field, and whether it skips it
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	return name, false
+}
@@ -0,0 +1,78 @@
+package mapping
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type product struct {
+	SKU   string  `json:"sku"`
+	Price float64 `json:"price" es:"scaled_float,scaling_factor=100"`
+}
+
+type audit struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type article struct {
+	audit
+	Title    string            `json:"title" es:"text,analyzer=english,fields.raw=keyword"`
+	Author   string            `json:"author" es:"keyword,ignore_above=256"`
+	Views    int               `json:"views,omitempty"`
+	Tags     []string          `json:"tags"`
+	Draft    *bool             `json:"draft"`
+	Products []product         `json:"products" es:"nested"`
+	Labels   map[string]string `json:"labels" es:"flattened"`
+	Internal string            `json:"internal" es:"-"`
+	Ignored  string            `json:"-"`
+	secret   string
+}
+
+type node struct {
+	Name     string `json:"name"`
+	Children []node `json:"children"`
+}
+
+func TestFromStruct(t *testing.T) {
+	t.Run("Test Tags And Types Are Mapped", func(t *testing.T) {
+		body, err := FromStruct(&article{}).JSON()
+		if err != nil {
+			t.Fatalf("Failed to derive mapping: %v", err)
+		}
+
+		expected := `{"properties": {
+			"created_at": {"type": "date"},
+			"title": {"type": "text", "analyzer": "english", "fields": {"raw": {"type": "keyword"}}},
+			"author": {"type": "keyword", "ignore_above": 256},
+			"views": {"type": "long"},
+			"tags": {"type": "keyword"},
+			"draft": {"type": "boolean"},
+			"products": {"type": "nested", "properties": {"sku": {"type": "keyword"}, "price": {"type": "scaled_float", "scaling_factor": 100}}},
+			"labels": {"type": "flattened"}
+		}}`
+		var got, want interface{}
+		json.Unmarshal([]byte(body), &got)
+		json.Unmarshal([]byte(expected), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected mapping:\n%s", body)
+		}
+	})
+
+	t.Run("Test Mistakes Are Reported", func(t *testing.T) {
+		if _, err := FromStruct("articles").JSON(); err == nil {
+			t.Errorf("Expected an error for a value that is not a struct")
+		}
+		if _, err := FromStruct(node{}).JSON(); err == nil || !strings.Contains(err.Error(), "contains itself") {
+			t.Errorf("Expected an error for a recursive struct, got %v", err)
+		}
+		type invalid struct {
+			Title string `json:"title" es:"text,analyzer"`
+		}
+		if _, err := FromStruct(invalid{}).JSON(); err == nil || !strings.Contains(err.Error(), "Title") {
+			t.Errorf("Expected an error naming the field, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/punitsu/elasticmate/pkg/migration/schema"
+)
+
+// MappingDiffConfig configures ScaffoldMappingDiff
+type MappingDiffConfig struct {
+	// Dir is the directory holding the migrations package
+	Dir string
+	// Package is the Go package name (default the base name of Dir)
+	Package string
+	// Index is the index, or the alias in front of it, the mappings are of
+	Index string
+	// Old and New are the mapping before and after the change, as an index
+	// creation body, a mapping or a bare properties object
+	Old []byte
+	New []byte
+	// Description defaults to "Update mapping of <index>"
+	Description string
+}
+
+// ScaffoldMappingDiff compares two versions of the mapping of an index and
+// writes the migration applying the change as the next numbered Go file of
+// cfg.Dir, regenerating the registry. Additive changes become a PutMapping
+// migration; removed or incompatibly changed fields become a ConvergeMapping
+// migration, which rebuilds the index behind the alias with the new mapping.
+// It returns the path of the file and the diff.
+func ScaffoldMappingDiff(cfg MappingDiffConfig) (string, schema.Diff, error) {
+	if cfg.Index == "" {
+		return "", schema.Diff{}, fmt.Errorf("mapping diff requires an index")
+	}
+	if cfg.Package == "" {
+		cfg.Package = filepath.Base(cfg.Dir)
+	}
+	if cfg.Description == "" {
+		cfg.Description = fmt.Sprintf("Update mapping of %s", cfg.Index)
+	}
+
+	old, err := schema.Parse(cfg.Old)
+	if err != nil {
+		return "", schema.Diff{}, fmt.Errorf("old mapping: %w", err)
+	}
+	desired, err := schema.Parse(cfg.New)
+	if err != nil {
+		return "", schema.Diff{}, fmt.Errorf("new mapping: %w", err)
+	}
+	diff := schema.Compare(old, desired)
+	diff.Index = cfg.Index
+	if diff.Empty() {
+		return "", diff, fmt.Errorf("mappings of %s are identical", cfg.Index)
+	}
+	body, err := mappingBody(cfg.New)
+	if err != nil {
+		return "", diff, err
+	}
+
+	slug := migrationSlug(cfg.Description)
+	if slug == "" {
+		return "", diff, fmt.Errorf("description %q has no letters or digits to name the migration", cfg.Description)
+	}
+	name, sequence, err := nextFileName(cfg.Dir, slug)
+	if err != nil {
+		return "", diff, err
+	}
+	source, err := renderMappingDiff(cfg, diff, body, goIdentifier(slug), sequence)
+	if err != nil {
+		return "", diff, err
+	}
+
+	path := filepath.Join(cfg.Dir, name+".go")
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return "", diff, fmt.Errorf("error creating %s: %w", cfg.Dir, err)
+	}
+	if err := os.WriteFile(path, source, 0644); err != nil {
+		return "", diff, fmt.Errorf("error writing migration: %w", err)
+	}
+	if err := WriteRegistry(cfg.Dir, cfg.Package); err != nil {
+		return "", diff, err
+	}
+	return path, diff, nil
+}
+
+// mappingBody returns data, in any of the forms schema.Parse accepts, as an
+// indented mapping body for put mapping requests
+func mappingBody(data []byte) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("error parsing mapping: %w", err)
+	}
+	if mappings, ok := body["mappings"]; ok {
+		return mappingBody(mappings)
+	}
+
+	mapping := data
+	if _, ok := body["properties"]; !ok {
+		// a bare properties object parses into fields, a mapping without
+		// properties into none
+		if fields, err := schema.Parse(data); err == nil && len(fields) > 0 {
+			mapping = []byte(fmt.Sprintf(`{"properties": %s}`, data))
+		}
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, bytes.TrimSpace(mapping), "", "  "); err != nil {
+		return nil, fmt.Errorf("error formatting mapping: %w", err)
+	}
+	return indented.Bytes(), nil
+}
+
+// renderMappingDiff returns a Go migration applying diff, listing the
+// changes in its doc comment
+func renderMappingDiff(cfg MappingDiffConfig, diff schema.Diff, body []byte, name string, sequence int) ([]byte, error) {
+	builder := "PutMapping"
+	if len(diff.Breaking()) > 0 {
+		builder = "ConvergeMapping"
+	}
+	literal := "`" + string(body) + "`"
+	if strings.Contains(string(body), "`") {
+		literal = strconv.Quote(string(body))
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "package %s\n\n", cfg.Package)
+	b.WriteString("import \"github.com/punitsu/elasticmate/pkg/migration\"\n\n")
+	fmt.Fprintf(&b, "// %s is migration %04d. It was generated from a mapping diff of %s:\n//\n", name, sequence, cfg.Index)
+	for _, change := range diff.Changes {
+		// the +, - and ~ of Change.String would be reformatted as list markers
+		switch change.Kind {
+		case schema.Added:
+			fmt.Fprintf(&b, "//\tadded %s (%s)\n", change.Path, change.To)
+		case schema.Removed:
+			fmt.Fprintf(&b, "//\tremoved %s (%s)\n", change.Path, change.From)
+		default:
+			fmt.Fprintf(&b, "//\tchanged %s (%s -> %s)\n", change.Path, change.From, change.To)
+		}
+	}
+	if builder == "ConvergeMapping" {
+		fmt.Fprintf(&b, "//\n// Fields are removed or changed incompatibly, so the index behind the %s\n// alias is rebuilt with the new mapping.\n", cfg.Index)
+	}
+	fmt.Fprintf(&b, "var %s = migration.%s(%q, %q, %s)\n", name, builder, cfg.Description, cfg.Index, literal)
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error formatting generated code: %w", err)
+	}
+	return source, nil
+}
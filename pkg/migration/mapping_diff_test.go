@@ -0,0 +1,91 @@
+package migration
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScaffoldMappingDiff(t *testing.T) {
+	old := []byte(`{"mappings": {"properties": {"title": {"type": "text"}, "views": {"type": "integer"}}}}`)
+
+	t.Run("Test Additive Change Uses Put Mapping", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "migrations")
+		path, diff, err := ScaffoldMappingDiff(MappingDiffConfig{
+			Dir:   dir,
+			Index: "articles",
+			Old:   old,
+			New:   []byte(`{"properties": {"title": {"type": "text"}, "views": {"type": "integer"}, "tags": {"type": "keyword"}}}`),
+		})
+		if err != nil {
+			t.Fatalf("Failed to scaffold migration: %v", err)
+		}
+		if filepath.Base(path) != "0001_update_mapping_of_articles.go" || len(diff.Changes) != 1 {
+			t.Errorf("Expected one added field in a numbered file, got %s and %v", path, diff.Changes)
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), path, nil, 0); err != nil {
+			t.Fatalf("Generated code does not parse: %v", err)
+		}
+
+		source, _ := os.ReadFile(path)
+		for _, want := range []string{"migration.PutMapping(\"Update mapping of articles\", \"articles\", `{", "//\tadded tags (keyword)"} {
+			if !strings.Contains(string(source), want) {
+				t.Errorf("Expected the migration to contain %q, got:\n%s", want, source)
+			}
+		}
+		registry, _ := os.ReadFile(filepath.Join(dir, RegistryFile))
+		if !strings.Contains(string(registry), "mm.Register(UpdateMappingOfArticles)") {
+			t.Errorf("Expected the registry to register the migration, got:\n%s", registry)
+		}
+	})
+
+	t.Run("Test Breaking Change Converges", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "migrations")
+		path, _, err := ScaffoldMappingDiff(MappingDiffConfig{
+			Dir:         dir,
+			Index:       "articles",
+			Old:         old,
+			New:         []byte(`{"title": {"type": "text"}, "views": {"type": "long"}}`),
+			Description: "Widen views",
+		})
+		if err != nil {
+			t.Fatalf("Failed to scaffold migration: %v", err)
+		}
+
+		source, _ := os.ReadFile(path)
+		for _, want := range []string{"migration.ConvergeMapping(\"Widen views\"", "//\tchanged views (integer -> long)", `"properties": {`} {
+			if !strings.Contains(string(source), want) {
+				t.Errorf("Expected the migration to contain %q, got:\n%s", want, source)
+			}
+		}
+	})
+
+	t.Run("Test Identical Mappings", func(t *testing.T) {
+		if _, _, err := ScaffoldMappingDiff(MappingDiffConfig{Dir: t.TempDir(), Index: "articles", Old: old, New: old}); err == nil {
+			t.Error("Expected an error for identical mappings")
+		}
+	})
+}
+
+func TestConvergeMapping(t *testing.T) {
+	live := `{"articles_v1": {"mappings": {"properties": {"title": {"type": "text"}}}}}`
+	transport := &routeTransport{routes: map[string]string{"GET /articles/_mapping": live}}
+
+	m := ConvergeMapping("Add tags", "articles", `{"properties": {"title": {"type": "text"}, "tags": {"type": "keyword"}}}`)
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Expected a valid migration, got %v", err)
+	}
+	if err := m.run(ClientFromTransport(transport), nil); err != nil {
+		t.Fatalf("Failed to run migration: %v", err)
+	}
+	if !transport.sent("PUT /articles/_mapping") {
+		t.Errorf("Expected a put mapping request, got %v", transport.requests)
+	}
+
+	if err := ConvergeMapping("Broken", "articles", `{"properties": `).Validate(); err == nil {
+		t.Error("Expected an invalid mapping to fail validation")
+	}
+}
@@ -0,0 +1,39 @@
+package migration
+
+import (
+	"errors"
+)
+
+// ErrSkipMigration can be returned by a middleware to leave a migration
+// pending without failing the run. The migration is not recorded.
+var ErrSkipMigration = errors.New("migration skipped")
+
+// Handler applies a single migration
+type Handler func(migration Migration) error
+
+// Middleware wraps the execution of each migration, in the same way
+// http.Handler middleware wraps requests. It can run code before and after
+// calling next, change the error returned, or not call next at all.
+type Middleware func(next Handler) Handler
+
+// Use appends middleware to the chain run around every migration. The first
+// middleware added is the outermost.
+func (mm *MigrationManager) Use(middleware ...Middleware) {
+	mm.middleware = append(mm.middleware, middleware...)
+}
+
+// WithMiddleware adds middleware to the chain run around every migration
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(mm *MigrationManager) {
+		mm.Use(middleware...)
+	}
+}
+
+// handler returns the apply step wrapped in the configured middleware
+func (mm *MigrationManager) handler() Handler {
+	h := Handler(mm.apply)
+	for i := len(mm.middleware) - 1; i >= 0; i-- {
+		h = mm.middleware[i](h)
+	}
+	return h
+}
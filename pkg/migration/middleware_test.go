@@ -0,0 +1,69 @@
+package migration
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(m Migration) error {
+				calls = append(calls, name+" before")
+				err := next(m)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+
+	skip := func(next Handler) Handler {
+		return func(m Migration) error {
+			if m.Description == "Flagged off" {
+				return fmt.Errorf("feature disabled: %w", ErrSkipMigration)
+			}
+			return next(m)
+		}
+	}
+
+	mm := NewMigrationManager(nil,
+		WithStore(NewMemoryStore()),
+		WithMiddleware(trace("outer"), trace("inner"), skip),
+	)
+
+	applied := NewMigration("Apply me", func(client *elasticsearch.Client) error {
+		calls = append(calls, "migration")
+		return nil
+	})
+	flagged := NewMigration("Flagged off", func(client *elasticsearch.Client) error {
+		t.Error("Expected skipped migration not to run")
+		return nil
+	})
+	mm.Register(applied)
+
+	if err := mm.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	expected := "[outer before inner before migration inner after outer after]"
+	if fmt.Sprint(calls) != expected {
+		t.Errorf("Expected calls %s, got %v", expected, calls)
+	}
+
+	mm.Register(flagged)
+	if err := mm.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	records, err := mm.GetAppliedMigrations()
+	if err != nil {
+		t.Fatalf("Failed to get applied migrations: %v", err)
+	}
+	if !records[applied.Version()] || records[flagged.Version()] {
+		t.Errorf("Expected only %s to be applied, got %v", applied.Version(), records)
+	}
+}
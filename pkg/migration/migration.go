@@ -1,28 +1,136 @@
 package migration
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	migrationsIndex = ".elasticmate_migrations"
 )
 
+// ErrAlreadyRunning is returned by RunMigrations while another call on the
+// same manager is applying migrations
+var ErrAlreadyRunning = errors.New("migrations are already running in this process")
+
 type Migration struct {
 	Description string
 	UpFunc      func(client *elasticsearch.Client) error
-	version     string
+	// TypedUpFunc is used instead of UpFunc by migrations written against the
+	// typed API
+	TypedUpFunc func(client *elasticsearch.TypedClient) error
+	// DownFunc, when set, reverts the migration on Rollback
+	DownFunc func(client *elasticsearch.Client) error
+	// MinESVersion and MaxESVersion, when set, bound the cluster versions the
+	// migration is compatible with, inclusive at the precision given
+	MinESVersion string
+	MaxESVersion string
+	version      string
+	destructive  bool
+
+	maintenanceIndices []string
+	latencyCheck       *LatencyCheck
+	desired            []desiredMapping
+	schedule           *schedule
+	timeout            time.Duration
+
+	// resources are the cluster objects the migration creates or deletes,
+	// checked by Refresh
+	resources []Resource
+	// verifications are postconditions checked by Verify
+	verifications []Verification
+
+	// compensations run when the migration function fails, followed by
+	// DownFunc when downOnFailure is set
+	compensations []Compensation
+	downOnFailure bool
+
+	// dependencies are versions or descriptions of migrations applied first
+	dependencies []string
+
+	// bulkBytes returns the size of the largest bulk body the migration
+	// sends, checked by Lint
+	bulkBytes func() (int64, error)
+
+	// ctx is set on the copy of a migration being applied under a timeout or
+	// run deadline, and cancels its requests
+	ctx context.Context
+	// traceCtx carries the span of the migration being applied to the spans
+	// of its requests
+	traceCtx context.Context
+
+	// downtimeSettings are static settings the migration closes an index to
+	// apply, refused unless allowDowntime is set
+	downtimeSettings []string
+	allowDowntime    bool
+
+	// capture saves state before the migration runs, stored in its record
+	// for restore to revert the migration
+	capture func(client *elasticsearch.Client) (json.RawMessage, error)
+	restore func(client *elasticsearch.Client, state json.RawMessage) error
+
+	// plan returns the requests a declarative migration will send, given
+	// whether an index exists once earlier pending migrations are applied
+	plan func(exists func(index string) (bool, error)) ([]PlannedRequest, error)
+
+	// validate checks the migration before any pending migration is applied
+	validate func() error
+
+	// tenant is set on migrations templated per tenant until they are
+	// expanded; tenantID is set on the expanded migration of each tenant
+	tenant   *tenantTemplate
+	tenantID string
+
+	// squashes are the versions of the migrations a baseline replaces
+	squashes []string
+
+	// conditions decide at run time whether the migration applies
+	conditions []Condition
+
+	// privileges are declared with RequiresPrivileges, checked by
+	// CheckPrivileges
+	privileges []Privileges
+
+	// throttle overrides the throttle of the manager for the tasks of the
+	// migration
+	throttle *Throttle
+
+	// checksum is the checksum of the content of a repeatable migration
+	checksum string
+
+	// customVersion is set on versions set explicitly or by a VersionFunc,
+	// which are compared in natural order; fixedVersion on those set with
+	// WithVersion, which a VersionFunc keeps
+	customVersion bool
+	fixedVersion  bool
+
+	// source is the path of the file a declared migration was loaded from
+	source string
+
+	// id replaces the function name in the version hash when set
+	id string
+
+	// steps are the steps of a Steps migration, and stepGuards the guards
+	// wrapping its function, innermost first, reapplied around the
+	// checkpointing function
+	steps      []Step
+	stepGuards []Guard
 }
 
 func NewMigration(description string, upFunc func(client *elasticsearch.Client) error) Migration {
@@ -34,12 +142,76 @@ func NewMigration(description string, upFunc func(client *elasticsearch.Client)
 	return m
 }
 
+// NewTypedMigration creates a migration written against elasticsearch.TypedClient
+func NewTypedMigration(description string, upFunc func(client *elasticsearch.TypedClient) error) Migration {
+	m := Migration{
+		Description: description,
+		TypedUpFunc: upFunc,
+	}
+	m.version = m.computeVersion()
+	return m
+}
+
 func (m Migration) Version() string {
 	return m.version
 }
 
+// MarkDestructive flags the migration as destructive (deleting indices, reindexing
+// with delete, removing fields) so a snapshot can be taken before it runs.
+func (m Migration) MarkDestructive() Migration {
+	m.destructive = true
+	return m
+}
+
+func (m Migration) IsDestructive() bool {
+	return m.destructive
+}
+
+// Validate reports problems that can be detected without running the
+// migration, such as settings that cannot be applied the way it requests
+func (m Migration) Validate() error {
+	for _, desired := range m.desired {
+		if desired.err != nil {
+			return fmt.Errorf("invalid desired mapping for %s: %w", desired.index, desired.err)
+		}
+	}
+	if err := m.schedule.validate(); err != nil {
+		return err
+	}
+	if len(m.downtimeSettings) > 0 && !m.allowDowntime {
+		return fmt.Errorf("%s require closing the index; mark the migration with AllowDowntime", strings.Join(m.downtimeSettings, ", "))
+	}
+	if m.validate == nil {
+		return nil
+	}
+	return m.validate()
+}
+
+// funcName returns the name of the function applying the migration
+func (m Migration) funcName() string {
+	if m.TypedUpFunc != nil {
+		return runtime.FuncForPC(reflect.ValueOf(m.TypedUpFunc).Pointer()).Name()
+	}
+	return runtime.FuncForPC(reflect.ValueOf(m.UpFunc).Pointer()).Name()
+}
+
+// run applies the migration with the client matching its signature. A typed
+// client is built on top of client when typed is nil.
+func (m Migration) run(client *elasticsearch.Client, typed *elasticsearch.TypedClient) error {
+	if m.TypedUpFunc != nil {
+		if typed == nil {
+			typed = TypedClientFromTransport(transportOf(client))
+		}
+		return m.TypedUpFunc(typed)
+	}
+	return m.UpFunc(client)
+}
+
 func (m Migration) computeVersion() string {
-	funcName := runtime.FuncForPC(reflect.ValueOf(m.UpFunc).Pointer()).Name()
+	funcName := m.funcName()
+	if m.id != "" {
+		funcName = m.id
+	}
 
 	hasher := sha256.New()
 	hasher.Write([]byte(funcName))
@@ -54,237 +226,688 @@ type MigrationRecord struct {
 	Description string    `json:"description"`
 	AppliedAt   time.Time `json:"applied_at"`
 	FuncName    string    `json:"func_name"`
+
+	// RunID identifies the RunMigrations call that applied the migration
+	RunID      string `json:"run_id,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	// Indices are the indices the migration wrote to
+	Indices []string `json:"indices,omitempty"`
+	// State is what the migration captured before it ran, such as previous
+	// setting values, used to roll it back
+	State json.RawMessage `json:"state,omitempty"`
+	// Checksum is the checksum of the content of a repeatable migration
+	Checksum string `json:"checksum,omitempty"`
+	// Skipped is set when a condition of the migration did not hold, so it
+	// was recorded without running
+	Skipped bool `json:"skipped,omitempty"`
+	// RunnerMetadata identifies who applied the migration and from which build
+	RunnerMetadata
 }
 
 // MigrationManager handles tracking and applying migrations
 type MigrationManager struct {
-	Client     *elasticsearch.Client
-	Migrations []Migration
-	FilePath   string // Optional path to text file for version management
+	Client *elasticsearch.Client
+	// TypedClient is handed to typed migrations. When nil, one is built on
+	// top of Client.
+	TypedClient *elasticsearch.TypedClient
+	Migrations  []Migration
+	FilePath    string // Optional path to text file for version management
+
+	// Namespace scopes the tracking index so several services can keep
+	// separate migration histories in one cluster
+	Namespace string
+
+	// Store is an optional custom version store. When nil, a FileStore is used
+	// if FilePath is set and an ESStore otherwise.
+	Store VersionStore
+
+	// ClientPolicy, when set, hands migrations a scoped client that enforces
+	// index prefixes and protected indices, and optionally captures requests
+	ClientPolicy *ClientPolicy
+
+	// appliedMu guards captured, written and states, which a migration
+	// abandoned after its timeout may still write while the run goes on
+	appliedMu sync.Mutex
+	captured  map[string][]CapturedRequest
+	// written holds the indices each migration applied in this process wrote to
+	written map[string][]string
+	// states holds the state captured by migrations applied in this process
+	states map[string]json.RawMessage
+
+	// SnapshotRepository is an optional snapshot repository used to snapshot
+	// the cluster before destructive migrations run
+	SnapshotRepository string
+
+	// Logger receives progress messages. Standard output is used when nil.
+	Logger Logger
+
+	middleware []Middleware
+
+	trackingIndex    string
+	requestTimeout   time.Duration
+	refreshPolicy    string
+	disableLock      bool
+	exportManifest   bool
+	failureMode      FailureMode
+	preflight        *Preflight
+	skipIncompatible bool
+	tracer           trace.Tracer
+	progress         ProgressReporter
+	versionFunc      VersionFunc
+	orphanPolicy     OrphanPolicy
+	notifiers        []Notifier
+	auditSinks       []AuditSink
+	metadata         MetadataProvider
+	tenants          TenantSource
+	variables        *Variables
+	secrets          SecretProvider
+	validateMappings bool
+	checkPrivileges  bool
+	throttle         Throttle
+	window           *Window
+	sampleSize       int
+	schemaVersions   bool
+	allowBreaking    bool
+	retry            *RetryPolicy
+	serverlessCompat bool
+	// serverlessDetected caches whether the cluster is a Serverless project,
+	// guarded by serverlessMu
+	serverlessDetected *bool
+	serverlessMu       sync.Mutex
+
+	deferred []DeferredMigration
+	now      func() time.Time
+
+	// mu guards Migrations, which init functions of several packages may
+	// register into at once
+	mu sync.Mutex
+	// running is set while RunMigrations applies migrations
+	running atomic.Bool
 }
 
-func NewMigrationManager(client *elasticsearch.Client, filePath string) *MigrationManager {
-	return &MigrationManager{
+func NewMigrationManager(client *elasticsearch.Client, opts ...Option) *MigrationManager {
+	mm := &MigrationManager{
 		Client:     client,
 		Migrations: []Migration{},
-		FilePath:   filePath,
 	}
+	for _, opt := range opts {
+		opt(mm)
+	}
+	return mm
 }
 
-func (mm *MigrationManager) Register(migration Migration) {
-	mm.Migrations = append(mm.Migrations, migration)
+// NewTypedMigrationManager creates a manager from a typed client. Both typed
+// and untyped migrations can be registered on it.
+func NewTypedMigrationManager(client *elasticsearch.TypedClient, opts ...Option) *MigrationManager {
+	opts = append([]Option{WithTypedClient(client)}, opts...)
+	return NewMigrationManager(ClientFromTransport(client.Transport), opts...)
 }
 
-func (mm *MigrationManager) useTextFile() bool {
-	return mm.FilePath != ""
+func (mm *MigrationManager) Register(migration Migration) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.Migrations = append(mm.Migrations, mm.stampVersion(migration, len(mm.Migrations)))
 }
 
-// readVersionsFromFile reads applied migrations from a text file
-func (mm *MigrationManager) readVersionsFromFile() (map[string]bool, error) {
-	if !mm.useTextFile() {
-		return nil, fmt.Errorf("text file path not provided")
+// store returns the version store used to track applied migrations
+func (mm *MigrationManager) store() VersionStore {
+	if mm.Store != nil {
+		return mm.Store
 	}
-
-	// Check if file exists
-	if _, err := os.Stat(mm.FilePath); os.IsNotExist(err) {
-		// File doesn't exist, return empty map
-		return make(map[string]bool), nil
+	if mm.FilePath != "" {
+		return NewFileStore(mm.FilePath)
 	}
-
-	file, err := os.Open(mm.FilePath)
+	index, err := mm.TrackingIndex()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open version file: %w", err)
+		return failedStore{err}
 	}
-	defer file.Close()
-
-	var versions map[string]bool
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&versions); err != nil {
-		// If file is empty or invalid JSON, return empty map
-		if err.Error() == "EOF" || strings.Contains(err.Error(), "unexpected end of JSON input") {
-			return make(map[string]bool), nil
-		}
-		return nil, fmt.Errorf("failed to decode version file: %w", err)
+	store := NewESStore(mm.client())
+	store.Index = index
+	store.RequestTimeout = mm.requestTimeout
+	if mm.refreshPolicy != "" {
+		store.Refresh = mm.refreshPolicy
 	}
+	return store
+}
 
-	// If versions is nil, return empty map
-	if versions == nil {
-		return make(map[string]bool), nil
+// TrackingIndex returns the name of the Elasticsearch index holding migration
+// records, e.g. .elasticmate_migrations_ordersvc for namespace "ordersvc".
+// With WithServerlessCompatibility it fails when the project type can't be
+// detected, as the dotted name is rejected by Serverless.
+func (mm *MigrationManager) TrackingIndex() (string, error) {
+	if mm.trackingIndex != "" {
+		return mm.trackingIndex, nil
 	}
-
-	return versions, nil
+	index := migrationsIndex
+	if mm.Namespace != "" {
+		index += "_" + strings.ToLower(mm.Namespace)
+	}
+	serverless, err := mm.serverless()
+	if err != nil {
+		return "", fmt.Errorf("error detecting a Serverless project: %w", err)
+	}
+	if serverless {
+		// Serverless reserves dot-prefixed names for system indices
+		index = strings.TrimPrefix(index, ".")
+	}
+	return index, nil
 }
 
-// writeVersionsToFile writes applied migrations to a text file
-func (mm *MigrationManager) writeVersionsToFile(versions map[string]bool) error {
-	if !mm.useTextFile() {
-		return fmt.Errorf("text file path not provided")
-	}
+// AppliedRecords returns the records of applied migrations in the order they
+// were applied
+func (mm *MigrationManager) AppliedRecords() ([]MigrationRecord, error) {
+	return mm.store().GetApplied()
+}
 
-	file, err := os.Create(mm.FilePath)
+func (mm *MigrationManager) GetAppliedMigrations() (map[string]bool, error) {
+	records, err := mm.store().GetApplied()
 	if err != nil {
-		return fmt.Errorf("failed to create version file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(versions); err != nil {
-		return fmt.Errorf("failed to encode version file: %w", err)
+	applied := make(map[string]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
 	}
 
-	return nil
+	return applied, nil
 }
 
-func (mm *MigrationManager) ensureMigrationsIndex() error {
-	// Skip if using text file
-	if mm.useTextFile() {
-		return nil
+func (mm *MigrationManager) RecordMigration(migration Migration) error {
+	return mm.store().Record(mm.newRecord(migration))
+}
+
+func (mm *MigrationManager) newRecord(migration Migration) MigrationRecord {
+	return MigrationRecord{
+		Version:     migration.Version(),
+		Description: migration.Description,
+		AppliedAt:   time.Now(),
+		FuncName:    migration.funcName(),
+		Checksum:    migration.checksum,
 	}
+}
 
-	res, err := mm.Client.Indices.Exists([]string{migrationsIndex})
-	if err != nil {
-		return fmt.Errorf("error checking migrations index: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == 404 {
-		mapping := `{
-			"mappings": {
-				"properties": {
-					"version": { "type": "keyword" },
-					"description": { "type": "text" },
-					"applied_at": { "type": "date" },
-					"func_name": { "type": "keyword" }
-				}
-			}
-		}`
+// RemoveMigrationRecord deletes the record of an applied migration so it runs again
+func (mm *MigrationManager) RemoveMigrationRecord(version string) error {
+	return mm.store().Remove(version)
+}
 
-		res, err := mm.Client.Indices.Create(
-			migrationsIndex,
-			mm.Client.Indices.Create.WithBody(strings.NewReader(mapping)),
-		)
-		if err != nil {
-			return fmt.Errorf("error creating migrations index: %w", err)
-		}
-		defer res.Body.Close()
-	}
+func (mm *MigrationManager) RunMigrations() error {
+	return mm.RunMigrationsContext(context.Background())
+}
 
-	return nil
+// RunMigrationsContext applies pending migrations until ctx is done. A
+// deadline on ctx bounds the whole run; a migration still running when it
+// passes is abandoned and recorded as failed.
+func (mm *MigrationManager) RunMigrationsContext(ctx context.Context) error {
+	_, err := mm.RunMigrationsSummary(ctx)
+	return err
 }
 
-func (mm *MigrationManager) GetAppliedMigrations() (map[string]bool, error) {
-	if mm.useTextFile() {
-		return mm.readVersionsFromFile()
+// RunMigrationsSummary applies pending migrations like RunMigrationsContext
+// and also returns the summary sent to notifiers
+func (mm *MigrationManager) RunMigrationsSummary(ctx context.Context) (RunSummary, error) {
+	summary := &RunSummary{Namespace: mm.Namespace, StartedAt: time.Now()}
+	err := mm.runMigrations(ctx, summary)
+	summary.DurationMS = time.Since(summary.StartedAt).Milliseconds()
+	if err != nil {
+		summary.Error = err.Error()
 	}
+	mm.notify(ctx, summary)
+	return *summary, err
+}
 
-	applied := make(map[string]bool)
+// runMigrations applies pending migrations, noting their outcome in summary
+func (mm *MigrationManager) runMigrations(ctx context.Context, summary *RunSummary) error {
+	if !mm.running.CompareAndSwap(false, true) {
+		return ErrAlreadyRunning
+	}
+	defer mm.running.Store(false)
 
-	if err := mm.ensureMigrationsIndex(); err != nil {
-		return nil, err
+	runID := newRunID()
+	summary.RunID = runID
+	runner := mm.runnerMetadata()
+
+	if !mm.disableLock {
+		unlock, err := mm.lock(runID)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 	}
 
-	query := `{"query": {"match_all": {}}}`
-	res, err := mm.Client.Search(
-		mm.Client.Search.WithIndex(migrationsIndex),
-		mm.Client.Search.WithBody(strings.NewReader(query)),
-		mm.Client.Search.WithSize(1000),
-	)
+	if mm.exportManifest {
+		if err := mm.ExportManifest(); err != nil {
+			return err
+		}
+	}
+
+	applied, err := mm.GetAppliedMigrations()
 	if err != nil {
-		return nil, fmt.Errorf("error querying migrations: %w", err)
+		return err
+	}
+	quarantined, err := mm.quarantined()
+	if err != nil {
+		return err
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		return nil, fmt.Errorf("error querying migrations: %s", res.String())
+	migrations, err := mm.registered()
+	if err != nil {
+		return err
+	}
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return err
+	}
+	if err := mm.checkOrphans(records, migrations); err != nil {
+		return err
 	}
 
-	var result struct {
-		Hits struct {
-			Hits []struct {
-				Source MigrationRecord `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
+	// Sort migrations by version and dependencies, repeatable migrations last
+	if err := sortMigrations(migrations); err != nil {
+		return err
 	}
 
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("error parsing migrations: %w", err)
+	// Repeatable migrations whose content changed are pending again
+	outdated, err := mm.outdatedRepeatables(migrations, applied)
+	if err != nil {
+		return err
+	}
+	for version := range outdated {
+		applied[version] = false
 	}
 
-	for _, hit := range result.Hits.Hits {
-		applied[hit.Source.Version] = true
+	if err := checkSquashed(migrations); err != nil {
+		return err
 	}
 
-	return applied, nil
-}
+	// Validate every pending migration before applying any of them
+	pending := 0
+	for _, migration := range migrations {
+		if _, ok := quarantined[migration.Version()]; applied[migration.Version()] || ok {
+			continue
+		}
+		if err := migration.Validate(); err != nil {
+			return fmt.Errorf("invalid migration %s: %w", migration.Version(), err)
+		}
+		if err := mm.scheduleOf(migration).validate(); err != nil {
+			return fmt.Errorf("invalid window: %w", err)
+		}
+		pending++
+	}
 
-func (mm *MigrationManager) RecordMigration(migration Migration) error {
-	if mm.useTextFile() {
-		applied, err := mm.readVersionsFromFile()
-		if err != nil {
+	if !mm.allowBreaking && pending > 0 {
+		if err := mm.checkBreakingChanges(migrations, applied); err != nil {
 			return err
 		}
-		applied[migration.Version()] = true
-		return mm.writeVersionsToFile(applied)
 	}
 
-	record := MigrationRecord{
-		Version:     migration.Version(),
-		Description: migration.Description,
-		AppliedAt:   time.Now(),
-		FuncName:    runtime.FuncForPC(reflect.ValueOf(migration.UpFunc).Pointer()).Name(),
+	if mm.validateMappings && pending > 0 {
+		if err := mm.validatePendingMappings(migrations, applied); err != nil {
+			return err
+		}
 	}
 
-	data, err := json.Marshal(record)
-	if err != nil {
-		return fmt.Errorf("error marshaling migration record: %w", err)
+	if mm.checkPrivileges && pending > 0 {
+		if err := mm.checkPendingPrivileges(migrations, applied); err != nil {
+			return err
+		}
 	}
 
-	res, err := mm.Client.Index(
-		migrationsIndex,
-		strings.NewReader(string(data)),
-		mm.Client.Index.WithRefresh("true"),
-	)
-	if err != nil {
-		return fmt.Errorf("error recording migration: %w", err)
+	if mm.sampleSize > 0 && pending > 0 {
+		conflicts, err := mm.validatePendingSamples(migrations, applied, mm.sampleSize)
+		if err != nil {
+			return err
+		}
+		if len(conflicts) > 0 {
+			return &MappingConflictError{Conflicts: conflicts}
+		}
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		return fmt.Errorf("error recording migration: %s", res.String())
+	if mm.preflight != nil && pending > 0 {
+		if err := CheckCluster(mm.client(), *mm.preflight); err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
-
-func (mm *MigrationManager) RunMigrations() error {
-	applied, err := mm.GetAppliedMigrations()
+	incompatible, err := mm.incompatible(migrations, applied)
 	if err != nil {
 		return err
 	}
 
-	// Sort migrations by version
-	sort.Slice(mm.Migrations, func(i, j int) bool {
-		return mm.Migrations[i].Version() < mm.Migrations[j].Version()
-	})
+	handle := mm.handler()
+	mm.deferred = nil
+	runErr := &RunError{}
 
 	// Apply pending migrations
-	for _, migration := range mm.Migrations {
+	for _, migration := range migrations {
+		if failure, ok := quarantined[migration.Version()]; ok && !applied[migration.Version()] {
+			mm.logf("Skipping migration %s: quarantined after failing: %s", migration.Version(), failure.Error)
+			summary.skip(migration, "quarantined")
+			continue
+		}
+		if version, ok := incompatible[migration.Version()]; ok {
+			mm.logf("Skipping migration %s: not compatible with Elasticsearch %s", migration.Version(), version)
+			summary.skip(migration, "not compatible with Elasticsearch "+version)
+			continue
+		}
+		if !applied[migration.Version()] && len(migration.squashes) > 0 {
+			record, err := mm.adoptSquashed(migration, applied, runID)
+			if err != nil {
+				return err
+			}
+			if record != nil {
+				summary.apply(*record)
+				continue
+			}
+		}
 		if !applied[migration.Version()] {
-			fmt.Printf("Applying migration %s: %s\n", migration.Version(), migration.Description)
-
-			if err := migration.UpFunc(mm.Client); err != nil {
-				return fmt.Errorf("failed to apply migration %s: %w", migration.Version(), err)
+			if schedule, now := mm.scheduleOf(migration), mm.clock(); !schedule.eligible(now) {
+				next, _ := schedule.next(now)
+				deferred := DeferredMigration{
+					Version:          migration.Version(),
+					Description:      migration.Description,
+					Next:             next,
+					WaitingForWindow: schedule.waitsForWindow(now),
+				}
+				mm.deferred = append(mm.deferred, deferred)
+				switch {
+				case next.IsZero():
+					mm.logf("Deferring migration %s: no window within a year", migration.Version())
+					summary.skip(migration, "no window within a year")
+				case deferred.WaitingForWindow:
+					mm.logf("Migration %s is waiting for its window, which opens at %s", migration.Version(), next.Format(time.RFC3339))
+					summary.skip(migration, "waiting for window until "+next.Format(time.RFC3339))
+				default:
+					mm.logf("Deferring migration %s until %s", migration.Version(), next.Format(time.RFC3339))
+					summary.skip(migration, "deferred until "+next.Format(time.RFC3339))
+				}
+				continue
 			}
 
-			if err := mm.RecordMigration(migration); err != nil {
+			if holds, err := mm.conditionsHold(ctx, migration); err != nil {
 				return err
+			} else if !holds {
+				record := mm.newRecord(migration)
+				record.RunID = runID
+				record.Skipped = true
+				record.RunnerMetadata = runner
+				if outdated[migration.Version()] {
+					err = mm.recordRepeated(record)
+				} else {
+					err = mm.store().Record(record)
+				}
+				if err != nil {
+					return err
+				}
+				mm.logf("Skipping migration %s: condition does not hold", migration.Version())
+				summary.skip(migration, "skipped by condition")
+				continue
+			}
+
+			if migration.IsDestructive() && mm.SnapshotRepository != "" {
+				if err := mm.takeSnapshot(migration); err != nil {
+					return err
+				}
+			}
+
+			var baseline map[string]time.Duration
+			if migration.latencyCheck != nil {
+				if baseline, err = mm.measureLatency(*migration.latencyCheck); err != nil {
+					return err
+				}
+			}
+
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("run deadline exceeded before migration %s: %w", migration.Version(), err)
 			}
 
-			fmt.Printf("Migration %s applied successfully\n", migration.Version())
+			mm.logf("Applying migration %s: %s", migration.Version(), migration.Description)
+			mm.audit(AuditEvent{
+				Action:      AuditStart,
+				RunID:       runID,
+				Version:     migration.Version(),
+				Description: migration.Description,
+				Destructive: migration.IsDestructive(),
+				Runner:      runner,
+			})
+
+			start := time.Now()
+			migrationCtx, span := mm.startSpan(ctx, migration, runID)
+			if span != nil {
+				migration.traceCtx = migrationCtx
+			}
+			err := runWithDeadline(migrationCtx, migration, handle)
+			endSpan(span, err)
+			if err != nil {
+				if errors.Is(err, ErrSkipMigration) {
+					mm.logf("Skipping migration %s: %v", migration.Version(), err)
+					summary.skip(migration, err.Error())
+					continue
+				}
+				if cerr := mm.compensate(migration); cerr != nil {
+					err = fmt.Errorf("%w (compensation failed: %v)", err, cerr)
+				}
+				failure := newFailure(migration, runID, start, err)
+				mm.recordFailure(failure)
+				mm.audit(AuditEvent{
+					Action:      AuditFailure,
+					RunID:       runID,
+					Version:     migration.Version(),
+					Description: migration.Description,
+					Destructive: migration.IsDestructive(),
+					Indices:     mm.affectedIndices(migration),
+					DurationMS:  failure.DurationMS,
+					Error:       failure.Error,
+					Runner:      runner,
+				})
+				summary.fail(failure)
+				if mm.failureMode == FailFast || ctx.Err() != nil {
+					return fmt.Errorf("failed to apply migration %s: %w", migration.Version(), err)
+				}
+				mm.logf("Migration %s failed, continuing: %v", migration.Version(), err)
+				runErr.add(failure, err)
+				continue
+			}
+
+			record := mm.newRecord(migration)
+			record.RunID = runID
+			record.DurationMS = time.Since(start).Milliseconds()
+			record.Indices = mm.affectedIndices(migration)
+			record.State = mm.capturedState(migration)
+			record.RunnerMetadata = runner
+			if outdated[migration.Version()] {
+				err = mm.recordRepeated(record)
+			} else {
+				err = mm.store().Record(record)
+			}
+			if err != nil {
+				return err
+			}
+			if mm.schemaVersions {
+				mm.stampSchemaVersion(record)
+			}
+			summary.apply(record)
+			mm.audit(AuditEvent{
+				Action:      AuditSuccess,
+				RunID:       runID,
+				Version:     migration.Version(),
+				Description: migration.Description,
+				Destructive: migration.IsDestructive(),
+				Indices:     record.Indices,
+				DurationMS:  record.DurationMS,
+				Runner:      runner,
+			})
+
+			mm.logf("Migration %s applied successfully", migration.Version())
+
+			if migration.latencyCheck != nil {
+				if err := mm.checkLatency(migration, baseline); err != nil {
+					return err
+				}
+			}
 		} else {
-			fmt.Printf("Skipping migration %s: already applied\n", migration.Version())
+			mm.logf("Skipping migration %s: already applied", migration.Version())
 		}
 	}
 
+	if len(runErr.Failures) > 0 {
+		return runErr
+	}
 	return nil
 }
+
+// apply runs the migration, setting the maintenance flag around it when requested
+func (mm *MigrationManager) apply(migration Migration) error {
+	if migration.requiresMaintenance() {
+		flag := MaintenanceFlag{
+			Indices: migration.maintenanceIndices,
+			Reason:  migration.Description,
+			Version: migration.Version(),
+		}
+		if err := SetMaintenance(mm.client(), flag); err != nil {
+			return err
+		}
+		defer func() {
+			if err := ClearMaintenance(mm.client()); err != nil {
+				mm.logf("Failed to clear maintenance flag after migration %s: %v", migration.Version(), err)
+			}
+		}()
+	}
+
+	// Note the indices the migration writes to for its record
+	var next, typedNext esapi.Transport = mm.retryTransport(transportOf(mm.Client)), nil
+	if mm.TypedClient != nil {
+		typedNext = mm.TypedClient.Transport
+	}
+	// Record the tasks the migration starts, resuming one an interrupted run
+	// left running
+	tasks, resumes := mm.store().(TaskStore)
+	if resumes {
+		task, err := tasks.GetTask(migration.Version())
+		if err != nil {
+			return err
+		}
+		next = &resumeTransport{next: next, store: tasks, version: migration.Version(), resume: task, logf: mm.logf}
+	}
+	if throttle := mm.throttleFor(migration); throttle != (Throttle{}) {
+		next = &throttleTransport{next: next, throttle: throttle}
+	}
+	if migration.ctx != nil {
+		next = &contextTransport{next: next, ctx: migration.ctx}
+		if mm.TypedClient != nil {
+			typedNext = &contextTransport{next: typedNext, ctx: migration.ctx}
+		}
+	}
+	if mm.progress != nil {
+		next = &progressTransport{next: next, reporter: mm.progress, version: migration.Version()}
+	}
+	if migration.traceCtx != nil {
+		next = &tracingTransport{next: next, tracer: mm.tracer, ctx: migration.traceCtx}
+		if mm.TypedClient != nil {
+			typedNext = &tracingTransport{next: typedNext, tracer: mm.tracer, ctx: migration.traceCtx}
+		}
+	}
+	recorder := &writeRecorder{next: next}
+	typedRecorder := &writeRecorder{next: typedNext}
+	client := ClientFromTransport(recorder)
+	var typed *elasticsearch.TypedClient
+	if mm.TypedClient != nil {
+		typed = TypedClientFromTransport(typedRecorder)
+	}
+	defer func() {
+		mm.appliedMu.Lock()
+		defer mm.appliedMu.Unlock()
+		if mm.written == nil {
+			mm.written = make(map[string][]string)
+		}
+		mm.written[migration.Version()] = append(recorder.Indices(), typedRecorder.Indices()...)
+	}()
+
+	var scope *ScopedTransport
+	if mm.ClientPolicy != nil {
+		client, scope = NewScopedClient(client, *mm.ClientPolicy)
+		typed = nil
+	}
+	client = ClientFromTransport(&loggingTransport{next: transportOf(client), logf: mm.logf, ctx: migration.ctx})
+
+	if migration.capture != nil {
+		state, err := migration.capture(client)
+		if err != nil {
+			return fmt.Errorf("error capturing state: %w", err)
+		}
+		mm.appliedMu.Lock()
+		if mm.states == nil {
+			mm.states = make(map[string]json.RawMessage)
+		}
+		mm.states[migration.Version()] = state
+		mm.appliedMu.Unlock()
+	}
+
+	var err error
+	if migration.steps != nil {
+		err = mm.stepsFunc(migration)(client)
+	} else {
+		err = migration.run(client, typed)
+	}
+	if err == nil && resumes {
+		if err := tasks.RemoveTask(migration.Version()); err != nil {
+			mm.logf("Failed to remove task of migration %s: %v", migration.Version(), err)
+		}
+	}
+
+	if scope != nil && mm.ClientPolicy.Capture {
+		mm.appliedMu.Lock()
+		if mm.captured == nil {
+			mm.captured = make(map[string][]CapturedRequest)
+		}
+		mm.captured[migration.Version()] = scope.Requests()
+		mm.appliedMu.Unlock()
+	}
+
+	return err
+}
+
+// CapturedRequests returns the requests made by a migration applied in this
+// process when ClientPolicy.Capture is enabled
+func (mm *MigrationManager) CapturedRequests(version string) []CapturedRequest {
+	mm.appliedMu.Lock()
+	defer mm.appliedMu.Unlock()
+	return mm.captured[version]
+}
+
+// capturedState returns the state captured by a migration applied in this
+// process
+func (mm *MigrationManager) capturedState(migration Migration) json.RawMessage {
+	mm.appliedMu.Lock()
+	defer mm.appliedMu.Unlock()
+	return mm.states[migration.Version()]
+}
+
+// affectedIndices returns the indices a migration wrote to or declared a
+// mapping for, sorted and without duplicates
+func (mm *MigrationManager) affectedIndices(migration Migration) []string {
+	seen := make(map[string]bool)
+	mm.appliedMu.Lock()
+	for _, index := range mm.written[migration.Version()] {
+		seen[index] = true
+	}
+	mm.appliedMu.Unlock()
+	for _, desired := range migration.desired {
+		seen[desired.index] = true
+	}
+
+	indices := make([]string, 0, len(seen))
+	for index := range seen {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices
+}
+
+// newRunID returns an identifier for a migration run, ordered by start time
+func newRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(suffix))
+}
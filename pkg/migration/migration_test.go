@@ -1,30 +1,79 @@
-package migration
+package migration_test
 
 import (
-	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
 )
 
-func TestMigrationManager(t *testing.T) {
-	cleanup, err := setupTestES()
-	if err != nil {
-		t.Fatalf("Failed to setup test environment: %v", err)
-	}
-	defer cleanup()
+func TestConcurrentUse(t *testing.T) {
+	t.Run("Test Concurrent Register", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		mm := es.Manager()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				mm.Register(migration.NewMigration(fmt.Sprintf("Migration %d", i), func(client *elasticsearch.Client) error {
+					return nil
+				}))
+			}(i)
+		}
+		wg.Wait()
 
-	// Create ES client
-	client, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{"http://localhost:9200"},
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		applied, err := mm.GetAppliedMigrations()
+		if err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+		if len(applied) != 20 {
+			t.Errorf("Expected 20 applied migrations, got %d", len(applied))
+		}
 	})
-	if err != nil {
-		t.Fatalf("Failed to create ES client: %v", err)
-	}
+
+	t.Run("Test Concurrent Runs Are Refused", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		started, release := make(chan struct{}), make(chan struct{})
+		mm := es.Manager(migration.WithLock(false), migration.WithMigrations(
+			migration.NewMigration("Block", func(client *elasticsearch.Client) error {
+				close(started)
+				<-release
+				return nil
+			}),
+		))
+
+		done := make(chan error)
+		go func() { done <- mm.RunMigrations() }()
+		<-started
+		if err := mm.RunMigrations(); !errors.Is(err, migration.ErrAlreadyRunning) {
+			t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if err := mm.RunMigrations(); err != nil {
+			t.Errorf("Expected a later run to proceed, got %v", err)
+		}
+	})
+}
+
+func TestMigrationManager(t *testing.T) {
+	client := migrationtest.StartElasticsearch(t, "8.12.1").Client()
 
 	t.Run("Test Migration Registration and Execution", func(t *testing.T) {
-		mm := NewMigrationManager(client, "")
+		mm := migration.NewMigrationManager(client)
 
 		migration1 := createTestMigration("test_index_1", "Create test index 1")
 		migration2 := createTestMigration("test_index_2", "Create test index 2")
@@ -51,18 +100,18 @@ func TestMigrationManager(t *testing.T) {
 			t.Fatalf("Failed to get applied migrations: %v", err)
 		}
 
-		for _, migration := range []Migration{migration1, migration2} {
-			if !applied[migration.Version()] {
-				t.Errorf("Expected migration %s to be applied", migration.Description)
+		for _, m := range []migration.Migration{migration1, migration2} {
+			if !applied[m.Version()] {
+				t.Errorf("Expected migration %s to be applied", m.Description)
 			}
 		}
 	})
 
 	t.Run("Test Migration Idempotence", func(t *testing.T) {
-		mm := NewMigrationManager(client, "")
+		mm := migration.NewMigrationManager(client)
 
-		migration := createTestMigration("test_idempotence", "Create test idempotence index")
-		mm.Register(migration)
+		m := createTestMigration("test_idempotence", "Create test idempotence index")
+		mm.Register(m)
 
 		// Run migrations twice
 		if err := mm.RunMigrations(); err != nil {
@@ -85,11 +134,11 @@ func TestMigrationManager(t *testing.T) {
 
 	t.Run("Test Migration Versioning", func(t *testing.T) {
 		// Test that migrations with the same function but different descriptions get different versions
-		migration1 := NewMigration("Description 1", func(client *elasticsearch.Client) error {
+		migration1 := migration.NewMigration("Description 1", func(client *elasticsearch.Client) error {
 			return nil
 		})
 
-		migration2 := NewMigration("Description 2", func(client *elasticsearch.Client) error {
+		migration2 := migration.NewMigration("Description 2", func(client *elasticsearch.Client) error {
 			return nil
 		})
 
@@ -98,16 +147,17 @@ func TestMigrationManager(t *testing.T) {
 		}
 	})
 
-	t.Run("Test Migration Manager With Text File", func(t *testing.T) {
-		filePath := "test_versions.json"
-		defer os.Remove(filePath)
+}
 
-		mm := NewMigrationManager(nil, filePath)
+func TestMigrationManagerWithFileStore(t *testing.T) {
+	t.Run("Test Migration Manager With Text File", func(t *testing.T) {
+		filePath := filepath.Join(t.TempDir(), "test_versions.json")
+		mm := migration.NewMigrationManager(nil, migration.WithFilePath(filePath))
 
-		migration1 := NewMigration("Create test index 1", func(client *elasticsearch.Client) error {
+		migration1 := migration.NewMigration("Create test index 1", func(client *elasticsearch.Client) error {
 			return nil
 		})
-		migration2 := NewMigration("Create test index 2", func(client *elasticsearch.Client) error {
+		migration2 := migration.NewMigration("Create test index 2", func(client *elasticsearch.Client) error {
 			return nil
 		})
 
@@ -123,27 +173,66 @@ func TestMigrationManager(t *testing.T) {
 			t.Fatalf("Failed to get applied migrations: %v", err)
 		}
 
-		for _, migration := range []Migration{migration1, migration2} {
-			if !applied[migration.Version()] {
-				t.Errorf("Expected migration %s to be applied", migration.Description)
+		for _, m := range []migration.Migration{migration1, migration2} {
+			if !applied[m.Version()] {
+				t.Errorf("Expected migration %s to be applied", m.Description)
 			}
 		}
 
-		// Verify the file exists and contains the correct data
-		fileData, err := os.ReadFile(filePath)
+		// A new store reading the file sees the same records
+		records, err := migration.NewFileStore(filePath).GetApplied()
 		if err != nil {
 			t.Fatalf("Failed to read version file: %v", err)
 		}
 
-		var versions map[string]bool
-		if err := json.Unmarshal(fileData, &versions); err != nil {
-			t.Fatalf("Failed to parse version file: %v", err)
+		recorded := make(map[string]string)
+		for _, record := range records {
+			recorded[record.Version] = record.Description
 		}
-
-		for _, migration := range []Migration{migration1, migration2} {
-			if !versions[migration.Version()] {
-				t.Errorf("Expected migration %s to be in version file", migration.Description)
+		for _, m := range []migration.Migration{migration1, migration2} {
+			if recorded[m.Version()] != m.Description {
+				t.Errorf("Expected migration %s to be in version file, got %v", m.Description, records)
 			}
 		}
 	})
 }
+
+func createTestMigration(indexName, description string) migration.Migration {
+	return migration.NewMigration(
+		description,
+		func(client *elasticsearch.Client) error {
+			mapping := `{
+				"mappings": {
+					"properties": {
+						"test_field": { "type": "keyword" }
+					}
+				}
+			}`
+
+			res, err := client.Indices.Create(
+				indexName,
+				client.Indices.Create.WithBody(strings.NewReader(mapping)),
+			)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+
+			if res.IsError() {
+				return fmt.Errorf("error creating index: %s", res.String())
+			}
+
+			return nil
+		},
+	)
+}
+
+func indexExists(client *elasticsearch.Client, index string) (bool, error) {
+	res, err := client.Indices.Exists([]string{index})
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	return res.StatusCode == 200, nil
+}
@@ -0,0 +1,176 @@
+// Package migrationtest provides utilities for testing code built on the
+// migration package, such as migrations, custom version stores and migration
+// managers.
+package migrationtest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// lockWaitTimeout bounds how long a runner retries while another runner holds the lock
+const lockWaitTimeout = 30 * time.Second
+
+// ConcurrencyConfig configures RunConcurrent
+type ConcurrencyConfig struct {
+	// Runners is the number of managers running migrations at the same time
+	Runners int
+	// Migrations is the number of migrations registered on every runner
+	Migrations int
+	// NewManager returns a fresh manager backed by the store under test.
+	// Every manager returned must share the same underlying store.
+	NewManager func() *migration.MigrationManager
+}
+
+// RunConcurrent simulates several runners applying the same migrations against
+// one store and verifies that every migration is applied and recorded exactly
+// once, and that a failed migration leaves no record behind.
+func RunConcurrent(t *testing.T, cfg ConcurrencyConfig) {
+	t.Helper()
+
+	if cfg.NewManager == nil {
+		t.Fatal("migrationtest: NewManager is required")
+	}
+	if cfg.Runners <= 0 {
+		cfg.Runners = 8
+	}
+	if cfg.Migrations <= 0 {
+		cfg.Migrations = 5
+	}
+
+	t.Run("Each Migration Applied Once", func(t *testing.T) {
+		token := fmt.Sprintf("%d", time.Now().UnixNano())
+		counts := make([]int32, cfg.Migrations)
+
+		newRunner := func() *migration.MigrationManager {
+			mm := cfg.NewManager()
+			for i := 0; i < cfg.Migrations; i++ {
+				i := i
+				mm.Register(migration.NewMigration(
+					fmt.Sprintf("migrationtest %s step %d", token, i),
+					func(client *elasticsearch.Client) error {
+						atomic.AddInt32(&counts[i], 1)
+						return nil
+					},
+				))
+			}
+			return mm
+		}
+
+		errs := runAll(cfg.Runners, newRunner)
+		for _, err := range errs {
+			t.Errorf("Runner failed: %v", err)
+		}
+
+		for i, count := range counts {
+			if count != 1 {
+				t.Errorf("Expected migration %d to run once, ran %d times", i, count)
+			}
+		}
+
+		verifyApplied(t, newRunner())
+	})
+
+	t.Run("Failed Migration Not Recorded", func(t *testing.T) {
+		token := fmt.Sprintf("%d", time.Now().UnixNano())
+		var failing atomic.Bool
+		failing.Store(true)
+
+		newRunner := func() *migration.MigrationManager {
+			mm := cfg.NewManager()
+			mm.Register(migration.NewMigration(
+				fmt.Sprintf("migrationtest %s flaky", token),
+				func(client *elasticsearch.Client) error {
+					if failing.Load() {
+						return fmt.Errorf("injected failure")
+					}
+					return nil
+				},
+			))
+			return mm
+		}
+
+		mm := newRunner()
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected run with failing migration to return an error")
+		}
+
+		applied, err := mm.GetAppliedMigrations()
+		if err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+		for _, m := range mm.Migrations {
+			if applied[m.Version()] {
+				t.Errorf("Expected failed migration %s not to be recorded", m.Version())
+			}
+		}
+
+		failing.Store(false)
+		errs := runAll(cfg.Runners, newRunner)
+		for _, err := range errs {
+			t.Errorf("Runner failed after fix: %v", err)
+		}
+
+		verifyApplied(t, newRunner())
+	})
+}
+
+// runAll starts n runners at the same time and collects their errors. Runners
+// that find the lock held retry until the lock is released.
+func runAll(n int, newRunner func() *migration.MigrationManager) []error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []error
+		start = make(chan struct{})
+	)
+
+	for i := 0; i < n; i++ {
+		mm := newRunner()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			deadline := time.Now().Add(lockWaitTimeout)
+			for {
+				err := mm.RunMigrations()
+				if errors.Is(err, migration.ErrLocked) && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+				return
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func verifyApplied(t *testing.T, mm *migration.MigrationManager) {
+	t.Helper()
+
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		t.Fatalf("Failed to get applied migrations: %v", err)
+	}
+
+	for _, m := range mm.Migrations {
+		if !applied[m.Version()] {
+			t.Errorf("Expected migration %s to be recorded", m.Description)
+		}
+	}
+}
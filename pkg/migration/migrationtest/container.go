@@ -0,0 +1,84 @@
+package migrationtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// DefaultElasticsearchVersion is the version StartElasticsearch runs when
+// none is given
+const DefaultElasticsearchVersion = "8.17.1"
+
+// elasticsearchImage is the image repository of Elasticsearch
+const elasticsearchImage = "docker.elastic.co/elasticsearch/elasticsearch"
+
+// startupTimeout bounds how long a container may take to report a healthy
+// cluster
+const startupTimeout = 3 * time.Minute
+
+// Elasticsearch is a single-node cluster started by StartElasticsearch
+type Elasticsearch struct {
+	// URL is the address of the cluster, on a random host port
+	URL string
+}
+
+// Client returns a client of the cluster
+func (e *Elasticsearch) Client() *elasticsearch.Client {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{e.URL}})
+	if err != nil {
+		// the config holds a valid address only
+		panic(err)
+	}
+	return client
+}
+
+// Manager returns a migration manager tracking migrations in the cluster
+func (e *Elasticsearch) Manager(opts ...migration.Option) *migration.MigrationManager {
+	return migration.NewMigrationManager(e.Client(), opts...)
+}
+
+// StartElasticsearch starts a single-node Elasticsearch container of
+// version, DefaultElasticsearchVersion when empty, with security disabled and
+// its HTTP port mapped to a random host port. It waits until the cluster is
+// healthy and removes the container when the test ends. The test is skipped
+// when Docker is not available.
+func StartElasticsearch(t *testing.T, version string) *Elasticsearch {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	if version == "" {
+		version = DefaultElasticsearchVersion
+	}
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        elasticsearchImage + ":" + version,
+			ExposedPorts: []string{"9200/tcp"},
+			Env: map[string]string{
+				"discovery.type":         "single-node",
+				"xpack.security.enabled": "false",
+				"ES_JAVA_OPTS":           "-Xms512m -Xmx512m",
+			},
+			WaitingFor: wait.ForHTTP("/_cluster/health?wait_for_status=yellow").
+				WithPort("9200/tcp").
+				WithStartupTimeout(startupTimeout),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start Elasticsearch %s: %v", version, err)
+	}
+
+	endpoint, err := container.PortEndpoint(ctx, "9200/tcp", "http")
+	if err != nil {
+		t.Fatalf("Failed to get the Elasticsearch address: %v", err)
+	}
+	return &Elasticsearch{URL: endpoint}
+}
@@ -0,0 +1,1268 @@
+package migrationtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// FakeES is an in-memory Elasticsearch served by an httptest.Server, so
+// migrations and managers can be tested without a cluster. It keeps indices
+// with their mappings, settings, aliases and documents, and answers the
+// requests migrations and the tracking index make:
+//
+//   - index create, get, exists and delete; mapping, field mapping and
+//     settings get and update; aliases
+//   - document index, create, update, get and delete, and bulk requests
+//   - search and count with match_all, exists, term, terms, ids, bool,
+//     constant_score and function_score queries, sorted on fields
+//   - reindex, update by query and delete by query, also as tasks with
+//     wait_for_completion=false. Scripts are not run.
+//
+// Other API objects, such as pipelines, templates and roles, are kept as sent
+// under their path. Other requests are answered with 200 and
+// {"acknowledged": true}, or 404 for GET and HEAD. Every request is recorded,
+// and responses can be canned with Respond.
+type FakeES struct {
+	// Version is the version reported by the cluster info API
+	Version string
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	indices  map[string]*fakeIndex
+	objects  map[string]json.RawMessage
+	tasks    map[string]map[string]interface{}
+	canned   map[string]cannedResponse
+	requests []Request
+	sequence int
+	// seqNo is the sequence number of the last document write
+	seqNo int
+}
+
+// Request is a request received by FakeES
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   []byte
+}
+
+func (r Request) String() string {
+	return r.Method + " " + r.Path
+}
+
+type cannedResponse struct {
+	status int
+	body   string
+}
+
+type fakeIndex struct {
+	mappings map[string]interface{}
+	// settings are flat, e.g. index.number_of_shards
+	settings map[string]interface{}
+	aliases  map[string]bool
+	docs     map[string]map[string]interface{}
+	// ids are the document IDs in indexing order
+	ids []string
+	// seqNos are the sequence numbers of the last write of each document
+	seqNos map[string]int
+}
+
+// NewFakeES starts a fake Elasticsearch, stopped when the test ends
+func NewFakeES(t testing.TB) *FakeES {
+	t.Helper()
+
+	f := &FakeES{
+		Version: "8.17.1",
+		indices: make(map[string]*fakeIndex),
+		objects: make(map[string]json.RawMessage),
+		tasks:   make(map[string]map[string]interface{}),
+		canned:  make(map[string]cannedResponse),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL returns the address of the fake
+func (f *FakeES) URL() string {
+	return f.server.URL
+}
+
+// Client returns a client of the fake
+func (f *FakeES) Client() *elasticsearch.Client {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{f.server.URL}})
+	if err != nil {
+		// the config holds a valid address only
+		panic(err)
+	}
+	return client
+}
+
+// Manager returns a migration manager tracking migrations in the fake
+func (f *FakeES) Manager(opts ...migration.Option) *migration.MigrationManager {
+	return migration.NewMigrationManager(f.Client(), opts...)
+}
+
+// Respond answers requests for method and path, e.g. "GET" and
+// "/_cluster/health", with status and body instead of the fake behaviour
+func (f *FakeES) Respond(method, path string, status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.canned[method+" "+path] = cannedResponse{status: status, body: body}
+}
+
+// Requests returns the requests received so far
+func (f *FakeES) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request{}, f.requests...)
+}
+
+// Sent reports whether a request for method and path was received
+func (f *FakeES) Sent(method, path string) bool {
+	for _, request := range f.Requests() {
+		if request.Method == method && request.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexExists reports whether the fake holds index
+func (f *FakeES) IndexExists(index string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.indices[index]
+	return ok
+}
+
+// Mapping returns the mappings of index, nil when it does not exist
+func (f *FakeES) Mapping(index string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if idx, ok := f.indices[index]; ok {
+		return copyMap(idx.mappings)
+	}
+	return nil
+}
+
+// Setting returns a setting of index by its flat name, e.g.
+// "index.number_of_replicas"
+func (f *FakeES) Setting(index, name string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if idx, ok := f.indices[index]; ok {
+		return idx.settings[name]
+	}
+	return nil
+}
+
+// Documents returns the sources of the documents of index in indexing order
+func (f *FakeES) Documents(index string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	idx, ok := f.indices[index]
+	if !ok {
+		return nil
+	}
+	docs := make([]map[string]interface{}, 0, len(idx.ids))
+	for _, id := range idx.ids {
+		docs = append(docs, copyMap(idx.docs[id]))
+	}
+	return docs
+}
+
+// CreateIndex creates an index with a create index request body, to start a
+// test from an existing cluster state
+func (f *FakeES) CreateIndex(index, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var request map[string]interface{}
+	if body != "" {
+		if err := json.Unmarshal([]byte(body), &request); err != nil {
+			return fmt.Errorf("error parsing index body: %w", err)
+		}
+	}
+	if _, ok := f.indices[index]; ok {
+		return fmt.Errorf("index %s already exists", index)
+	}
+	f.createIndex(index, request)
+	return nil
+}
+
+// AddDocument indexes a document, creating its index when missing
+func (f *FakeES) AddDocument(index, id string, source map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.putDocument(f.autoCreate(index), id, source)
+}
+
+func (f *FakeES) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Body: body})
+
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	if canned, ok := f.canned[r.Method+" "+r.URL.Path]; ok {
+		w.WriteHeader(canned.status)
+		io.WriteString(w, canned.body)
+		return
+	}
+
+	status, response := f.handle(r.Method, r.URL.Path, r.URL.Query(), body)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handle routes a request to the fake behaviour
+func (f *FakeES) handle(method, urlPath string, query url.Values, body []byte) (int, interface{}) {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if segments[0] == "" {
+		return http.StatusOK, map[string]interface{}{
+			"name":         "fake",
+			"cluster_name": "elasticmate-fake",
+			"version":      map[string]interface{}{"number": f.Version, "build_flavor": "default"},
+			"tagline":      "You Know, for Search",
+		}
+	}
+
+	switch segments[0] {
+	case "_cluster":
+		if len(segments) > 1 && segments[1] == "health" {
+			return http.StatusOK, map[string]interface{}{"status": "green", "cluster_name": "elasticmate-fake"}
+		}
+	case "_refresh":
+		return http.StatusOK, acknowledged()
+	case "_bulk":
+		return f.bulk("", body)
+	case "_search":
+		return f.search("_all", query, body)
+	case "_reindex":
+		return f.reindex(query, body)
+	case "_tasks":
+		if len(segments) == 2 {
+			if task, ok := f.tasks[segments[1]]; ok {
+				return http.StatusOK, task
+			}
+			return errorResponse(http.StatusNotFound, "resource_not_found_exception", "task ["+segments[1]+"] isn't running and hasn't stored its results")
+		}
+	case "_aliases":
+		return f.updateAliases(body)
+	case "_alias":
+		if len(segments) == 2 {
+			return f.getAlias("_all", segments[1])
+		}
+	}
+	if strings.HasPrefix(segments[0], "_") {
+		return f.object(method, urlPath, body)
+	}
+	return f.handleIndex(method, segments, query, body)
+}
+
+// handleIndex serves the APIs under an index name
+func (f *FakeES) handleIndex(method string, segments []string, query url.Values, body []byte) (int, interface{}) {
+	name := segments[0]
+	if len(segments) == 1 {
+		switch method {
+		case http.MethodHead:
+			if len(f.resolve(name)) == 0 {
+				return http.StatusNotFound, nil
+			}
+			return http.StatusOK, nil
+		case http.MethodGet:
+			return f.getIndex(name, query)
+		case http.MethodPut:
+			return f.putIndex(name, body)
+		case http.MethodDelete:
+			return f.deleteIndex(name)
+		}
+	}
+
+	api := segments[1]
+	var id string
+	if len(segments) > 2 {
+		id = segments[2]
+	}
+	switch api {
+	case "_mapping":
+		if method == http.MethodGet && len(segments) == 4 && segments[2] == "field" {
+			return f.getFieldMapping(name, strings.Split(segments[3], ","))
+		}
+		if method == http.MethodGet {
+			return f.getMapping(name)
+		}
+		return f.putMapping(name, body)
+	case "_settings":
+		if method == http.MethodGet {
+			return f.getSettings(name, query)
+		}
+		return f.putSettings(name, body)
+	case "_alias", "_aliases":
+		if method == http.MethodGet || method == http.MethodHead {
+			return f.getAlias(name, id)
+		}
+		idx, ok := f.indices[name]
+		if !ok {
+			return indexNotFound(name)
+		}
+		idx.aliases[id] = true
+		return http.StatusOK, acknowledged()
+	case "_doc", "_create":
+		return f.document(method, name, api, id, query, body)
+	case "_update":
+		return f.updateDocument(name, id, body)
+	case "_search":
+		return f.search(name, query, body)
+	case "_count":
+		return f.count(name, query, body)
+	case "_delete_by_query", "_update_by_query":
+		return f.byQuery(api, name, query, body)
+	case "_bulk":
+		return f.bulk(name, body)
+	case "_refresh", "_flush", "_forcemerge":
+		return http.StatusOK, acknowledged()
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return errorResponse(http.StatusNotFound, "resource_not_found_exception", "no handler for "+method+" "+strings.Join(segments, "/"))
+	}
+	return http.StatusOK, acknowledged()
+}
+
+// object keeps API objects other than indices, such as pipelines, as sent
+func (f *FakeES) object(method, urlPath string, body []byte) (int, interface{}) {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		if object, ok := f.objects[urlPath]; ok {
+			return http.StatusOK, object
+		}
+		return errorResponse(http.StatusNotFound, "resource_not_found_exception", urlPath+" not found")
+	case http.MethodDelete:
+		if _, ok := f.objects[urlPath]; !ok {
+			return errorResponse(http.StatusNotFound, "resource_not_found_exception", urlPath+" not found")
+		}
+		delete(f.objects, urlPath)
+	default:
+		if json.Valid(body) {
+			f.objects[urlPath] = append(json.RawMessage{}, body...)
+		}
+	}
+	return http.StatusOK, acknowledged()
+}
+
+// resolve returns the indices matching a comma separated list of names,
+// aliases and wildcard patterns
+func (f *FakeES) resolve(names string) []string {
+	seen := make(map[string]bool)
+	var indices []string
+	add := func(index string) {
+		if !seen[index] {
+			seen[index] = true
+			indices = append(indices, index)
+		}
+	}
+	for _, name := range strings.Split(names, ",") {
+		for index, idx := range f.indices {
+			matched, _ := path.Match(name, index)
+			if name == "_all" || matched || idx.aliases[name] {
+				add(index)
+			}
+		}
+	}
+	sort.Strings(indices)
+	return indices
+}
+
+func (f *FakeES) createIndex(name string, request map[string]interface{}) *fakeIndex {
+	idx := &fakeIndex{
+		mappings: map[string]interface{}{},
+		settings: map[string]interface{}{"index.number_of_shards": "1", "index.number_of_replicas": "1"},
+		aliases:  map[string]bool{},
+		docs:     map[string]map[string]interface{}{},
+		seqNos:   map[string]int{},
+	}
+	if mappings, ok := request["mappings"].(map[string]interface{}); ok {
+		idx.mappings = mappings
+	}
+	if settings, ok := request["settings"].(map[string]interface{}); ok {
+		mergeSettings(idx.settings, settings)
+	}
+	if aliases, ok := request["aliases"].(map[string]interface{}); ok {
+		for alias := range aliases {
+			idx.aliases[alias] = true
+		}
+	}
+	f.indices[name] = idx
+	return idx
+}
+
+// autoCreate returns the index a document is written to, creating it like
+// Elasticsearch does when it is missing
+func (f *FakeES) autoCreate(name string) *fakeIndex {
+	if indices := f.resolve(name); len(indices) > 0 && !strings.ContainsAny(name, "*,") {
+		return f.indices[indices[0]]
+	}
+	return f.createIndex(name, nil)
+}
+
+func (f *FakeES) putIndex(name string, body []byte) (int, interface{}) {
+	if _, ok := f.indices[name]; ok {
+		return errorResponse(http.StatusBadRequest, "resource_already_exists_exception", "index ["+name+"] already exists")
+	}
+	var request map[string]interface{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return parseError(err)
+		}
+	}
+	f.createIndex(name, request)
+	return http.StatusOK, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": name}
+}
+
+func (f *FakeES) getIndex(name string, query url.Values) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	response := make(map[string]interface{}, len(indices))
+	for _, index := range indices {
+		idx := f.indices[index]
+		response[index] = map[string]interface{}{
+			"aliases":  aliasMap(idx),
+			"mappings": idx.mappings,
+			"settings": formatSettings(idx.settings, query),
+		}
+	}
+	return http.StatusOK, response
+}
+
+func (f *FakeES) deleteIndex(name string) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	for _, index := range indices {
+		delete(f.indices, index)
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func (f *FakeES) getMapping(name string) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	response := make(map[string]interface{}, len(indices))
+	for _, index := range indices {
+		response[index] = map[string]interface{}{"mappings": f.indices[index].mappings}
+	}
+	return http.StatusOK, response
+}
+
+func (f *FakeES) getFieldMapping(name string, fields []string) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	response := make(map[string]interface{}, len(indices))
+	for _, index := range indices {
+		mappings := make(map[string]interface{})
+		for _, field := range fields {
+			if definition, ok := fieldMapping(f.indices[index].mappings, field); ok {
+				leaf := field[strings.LastIndex(field, ".")+1:]
+				mappings[field] = map[string]interface{}{"full_name": field, "mapping": map[string]interface{}{leaf: definition}}
+			}
+		}
+		response[index] = map[string]interface{}{"mappings": mappings}
+	}
+	return http.StatusOK, response
+}
+
+// fieldMapping returns the definition of a dotted field in mappings
+func fieldMapping(mappings map[string]interface{}, field string) (interface{}, bool) {
+	properties, _ := mappings["properties"].(map[string]interface{})
+	head, rest, nested := strings.Cut(field, ".")
+	definition, ok := properties[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if !nested {
+		return definition, true
+	}
+	if subfield, ok := fieldMapping(map[string]interface{}{"properties": definition["fields"]}, rest); ok {
+		return subfield, true
+	}
+	return fieldMapping(definition, rest)
+}
+
+func (f *FakeES) putMapping(name string, body []byte) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	var mapping map[string]interface{}
+	if err := json.Unmarshal(body, &mapping); err != nil {
+		return parseError(err)
+	}
+	for _, index := range indices {
+		mergeMaps(f.indices[index].mappings, mapping)
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func (f *FakeES) getSettings(name string, query url.Values) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	response := make(map[string]interface{}, len(indices))
+	for _, index := range indices {
+		response[index] = map[string]interface{}{"settings": formatSettings(f.indices[index].settings, query)}
+	}
+	return http.StatusOK, response
+}
+
+func (f *FakeES) putSettings(name string, body []byte) (int, interface{}) {
+	indices := f.resolve(name)
+	if len(indices) == 0 {
+		return indexNotFound(name)
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(body, &settings); err != nil {
+		return parseError(err)
+	}
+	if nested, ok := settings["settings"].(map[string]interface{}); ok {
+		settings = nested
+	}
+	for _, index := range indices {
+		mergeSettings(f.indices[index].settings, settings)
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func (f *FakeES) getAlias(name, alias string) (int, interface{}) {
+	response := make(map[string]interface{})
+	for _, index := range f.resolve(name) {
+		aliases := make(map[string]interface{})
+		for a := range f.indices[index].aliases {
+			if matched, _ := path.Match(alias, a); alias == "" || matched {
+				aliases[a] = map[string]interface{}{}
+			}
+		}
+		if len(aliases) > 0 || alias == "" {
+			response[index] = map[string]interface{}{"aliases": aliases}
+		}
+	}
+	if len(response) == 0 {
+		return errorResponse(http.StatusNotFound, "aliases_not_found_exception", "alias ["+alias+"] missing")
+	}
+	return http.StatusOK, response
+}
+
+func (f *FakeES) updateAliases(body []byte) (int, interface{}) {
+	var request struct {
+		Actions []map[string]struct {
+			Index   string   `json:"index"`
+			Indices []string `json:"indices"`
+			Alias   string   `json:"alias"`
+			Aliases []string `json:"aliases"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return parseError(err)
+	}
+	for _, action := range request.Actions {
+		for kind, target := range action {
+			names := append([]string{target.Index}, target.Indices...)
+			aliases := append([]string{target.Alias}, target.Aliases...)
+			for _, name := range names {
+				if name == "" {
+					continue
+				}
+				indices := f.resolve(name)
+				if len(indices) == 0 {
+					return indexNotFound(name)
+				}
+				for _, index := range indices {
+					for _, alias := range aliases {
+						if alias == "" && kind != "remove_index" {
+							continue
+						}
+						switch kind {
+						case "add":
+							f.indices[index].aliases[alias] = true
+						case "remove":
+							delete(f.indices[index].aliases, alias)
+						case "remove_index":
+							delete(f.indices, index)
+						}
+					}
+				}
+			}
+		}
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func (f *FakeES) putDocument(idx *fakeIndex, id string, source map[string]interface{}) (string, bool) {
+	if id == "" {
+		f.sequence++
+		id = fmt.Sprintf("fake-%d", f.sequence)
+	}
+	_, exists := idx.docs[id]
+	if !exists {
+		idx.ids = append(idx.ids, id)
+	}
+	idx.docs[id] = source
+	f.seqNo++
+	idx.seqNos[id] = f.seqNo
+	return id, !exists
+}
+
+func (f *FakeES) removeDocument(idx *fakeIndex, id string) bool {
+	if _, ok := idx.docs[id]; !ok {
+		return false
+	}
+	delete(idx.docs, id)
+	delete(idx.seqNos, id)
+	for i, existing := range idx.ids {
+		if existing == id {
+			idx.ids = append(idx.ids[:i:i], idx.ids[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (f *FakeES) document(method, name, api, id string, query url.Values, body []byte) (int, interface{}) {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		for _, index := range f.resolve(name) {
+			if source, ok := f.indices[index].docs[id]; ok {
+				return http.StatusOK, map[string]interface{}{"_index": index, "_id": id, "found": true, "_source": source,
+					"_seq_no": f.indices[index].seqNos[id], "_primary_term": 1}
+			}
+		}
+		return http.StatusNotFound, map[string]interface{}{"_index": name, "_id": id, "found": false}
+	case http.MethodDelete:
+		for _, index := range f.resolve(name) {
+			if _, ok := f.indices[index].docs[id]; !ok {
+				continue
+			}
+			if status, response, ok := f.checkSeqNo(f.indices[index], id, query); !ok {
+				return status, response
+			}
+			f.removeDocument(f.indices[index], id)
+			f.seqNo++
+			return http.StatusOK, map[string]interface{}{"_index": index, "_id": id, "result": "deleted", "_seq_no": f.seqNo, "_primary_term": 1}
+		}
+		if query.Has("if_seq_no") {
+			return errorResponse(http.StatusConflict, "version_conflict_engine_exception", "["+id+"]: version conflict, required seqNo ["+query.Get("if_seq_no")+"], but no document was found")
+		}
+		return http.StatusNotFound, map[string]interface{}{"_index": name, "_id": id, "result": "not_found"}
+	}
+
+	var source map[string]interface{}
+	if err := json.Unmarshal(body, &source); err != nil {
+		return parseError(err)
+	}
+	idx := f.autoCreate(name)
+	if _, exists := idx.docs[id]; exists && api == "_create" {
+		return errorResponse(http.StatusConflict, "version_conflict_engine_exception", "["+id+"]: version conflict, document already exists")
+	}
+	if status, response, ok := f.checkSeqNo(idx, id, query); !ok {
+		return status, response
+	}
+	id, created := f.putDocument(idx, id, source)
+	response := map[string]interface{}{"_index": name, "_id": id, "result": "updated", "_seq_no": idx.seqNos[id], "_primary_term": 1}
+	if created {
+		response["result"], response["_version"] = "created", 1
+		return http.StatusCreated, response
+	}
+	return http.StatusOK, response
+}
+
+// checkSeqNo applies the if_seq_no and if_primary_term conditions of a write
+// to document id, reporting a version conflict when they do not hold
+func (f *FakeES) checkSeqNo(idx *fakeIndex, id string, query url.Values) (int, interface{}, bool) {
+	if !query.Has("if_seq_no") {
+		return 0, nil, true
+	}
+	seqNo, ok := idx.seqNos[id]
+	if !ok || query.Get("if_seq_no") != strconv.Itoa(seqNo) || query.Get("if_primary_term") != "1" {
+		status, response := errorResponse(http.StatusConflict, "version_conflict_engine_exception",
+			"["+id+"]: version conflict, required seqNo ["+query.Get("if_seq_no")+"], current document has seqNo ["+strconv.Itoa(seqNo)+"]")
+		return status, response, false
+	}
+	return 0, nil, true
+}
+
+func (f *FakeES) updateDocument(name, id string, body []byte) (int, interface{}) {
+	var request struct {
+		Doc         map[string]interface{} `json:"doc"`
+		Upsert      map[string]interface{} `json:"upsert"`
+		DocAsUpsert bool                   `json:"doc_as_upsert"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return parseError(err)
+	}
+	idx := f.autoCreate(name)
+	source, ok := idx.docs[id]
+	switch {
+	case ok:
+		mergeMaps(source, request.Doc)
+	case request.Upsert != nil:
+		f.putDocument(idx, id, request.Upsert)
+	case request.DocAsUpsert:
+		f.putDocument(idx, id, request.Doc)
+	default:
+		return errorResponse(http.StatusNotFound, "document_missing_exception", "["+id+"]: document missing")
+	}
+	return http.StatusOK, map[string]interface{}{"_index": name, "_id": id, "result": "updated"}
+}
+
+// hit is a document matched by a query
+type hit struct {
+	index  string
+	id     string
+	source map[string]interface{}
+}
+
+// match returns the documents of the indices of name matching the query of
+// a search body, sorted by its sort clause
+func (f *FakeES) match(name string, query url.Values, body []byte) ([]hit, map[string]interface{}, error) {
+	var request map[string]interface{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, nil, err
+		}
+	}
+	if q := query.Get("q"); q != "" {
+		return nil, nil, fmt.Errorf("query strings are not supported by the fake")
+	}
+
+	var hits []hit
+	for _, index := range f.resolve(name) {
+		idx := f.indices[index]
+		for _, id := range idx.ids {
+			ok, err := matches(idx.docs[id], id, request["query"])
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				hits = append(hits, hit{index: index, id: id, source: idx.docs[id]})
+			}
+		}
+	}
+	sortHits(hits, request["sort"])
+	return hits, request, nil
+}
+
+func (f *FakeES) search(name string, query url.Values, body []byte) (int, interface{}) {
+	if !strings.ContainsAny(name, "*,") && name != "_all" && len(f.resolve(name)) == 0 && query.Get("ignore_unavailable") != "true" {
+		return indexNotFound(name)
+	}
+	hits, request, err := f.match(name, query, body)
+	if err != nil {
+		return parseError(err)
+	}
+
+	size, from := 10, 0
+	if s, ok := request["size"].(float64); ok {
+		size = int(s)
+	}
+	if s, err := strconv.Atoi(query.Get("size")); err == nil {
+		size = s
+	}
+	if s, ok := request["from"].(float64); ok {
+		from = int(s)
+	}
+	if s, err := strconv.Atoi(query.Get("from")); err == nil {
+		from = s
+	}
+
+	total := len(hits)
+	if from > len(hits) {
+		from = len(hits)
+	}
+	hits = hits[from:]
+	if size < len(hits) {
+		hits = hits[:size]
+	}
+	results := make([]interface{}, 0, len(hits))
+	for _, h := range hits {
+		results = append(results, map[string]interface{}{"_index": h.index, "_id": h.id, "_source": h.source})
+	}
+	return http.StatusOK, map[string]interface{}{
+		"took":      1,
+		"timed_out": false,
+		"hits": map[string]interface{}{
+			"total": map[string]interface{}{"value": total, "relation": "eq"},
+			"hits":  results,
+		},
+	}
+}
+
+func (f *FakeES) count(name string, query url.Values, body []byte) (int, interface{}) {
+	if len(f.resolve(name)) == 0 {
+		return indexNotFound(name)
+	}
+	hits, _, err := f.match(name, query, body)
+	if err != nil {
+		return parseError(err)
+	}
+	return http.StatusOK, map[string]interface{}{"count": len(hits)}
+}
+
+// byQuery deletes or counts as updated the documents matching a query
+func (f *FakeES) byQuery(api, name string, query url.Values, body []byte) (int, interface{}) {
+	if len(f.resolve(name)) == 0 {
+		if query.Get("ignore_unavailable") == "true" {
+			return http.StatusOK, map[string]interface{}{"total": 0, "deleted": 0, "updated": 0, "failures": []interface{}{}}
+		}
+		return indexNotFound(name)
+	}
+	hits, _, err := f.match(name, query, body)
+	if err != nil {
+		return parseError(err)
+	}
+	result := map[string]interface{}{"total": len(hits), "deleted": 0, "updated": 0, "failures": []interface{}{}}
+	if api == "_delete_by_query" {
+		for _, h := range hits {
+			f.removeDocument(f.indices[h.index], h.id)
+		}
+		result["deleted"] = len(hits)
+	} else {
+		result["updated"] = len(hits)
+	}
+	return f.respondTask(query, result)
+}
+
+func (f *FakeES) reindex(query url.Values, body []byte) (int, interface{}) {
+	var request struct {
+		Source struct {
+			Index interface{}     `json:"index"`
+			Query json.RawMessage `json:"query"`
+		} `json:"source"`
+		Dest struct {
+			Index string `json:"index"`
+		} `json:"dest"`
+		MaxDocs int `json:"max_docs"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return parseError(err)
+	}
+	var sources []string
+	switch index := request.Source.Index.(type) {
+	case string:
+		sources = []string{index}
+	case []interface{}:
+		for _, i := range index {
+			sources = append(sources, fmt.Sprint(i))
+		}
+	}
+	name := strings.Join(sources, ",")
+	if len(f.resolve(name)) == 0 {
+		return indexNotFound(name)
+	}
+
+	search := []byte("{}")
+	if len(request.Source.Query) > 0 {
+		search, _ = json.Marshal(map[string]json.RawMessage{"query": request.Source.Query})
+	}
+	hits, _, err := f.match(name, url.Values{}, search)
+	if err != nil {
+		return parseError(err)
+	}
+	if request.MaxDocs > 0 && request.MaxDocs < len(hits) {
+		hits = hits[:request.MaxDocs]
+	}
+	dest := f.autoCreate(request.Dest.Index)
+	created, updated := 0, 0
+	for _, h := range hits {
+		if _, isNew := f.putDocument(dest, h.id, copyMap(h.source)); isNew {
+			created++
+		} else {
+			updated++
+		}
+	}
+	return f.respondTask(query, map[string]interface{}{
+		"took": 1, "total": len(hits), "created": created, "updated": updated, "failures": []interface{}{},
+	})
+}
+
+// respondTask returns result, or a task completed with it when the request
+// does not wait for completion
+func (f *FakeES) respondTask(query url.Values, result map[string]interface{}) (int, interface{}) {
+	if query.Get("wait_for_completion") != "false" {
+		return http.StatusOK, result
+	}
+	f.sequence++
+	id := fmt.Sprintf("fake:%d", f.sequence)
+	f.tasks[id] = map[string]interface{}{
+		"completed": true,
+		"task":      map[string]interface{}{"id": f.sequence, "status": result},
+		"response":  result,
+	}
+	return http.StatusOK, map[string]interface{}{"task": id}
+}
+
+func (f *FakeES) bulk(name string, body []byte) (int, interface{}) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	var items []interface{}
+	failed := false
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil {
+			return parseError(err)
+		}
+		for kind, meta := range action {
+			index := meta.Index
+			if index == "" {
+				index = name
+			}
+			var status int
+			var response interface{}
+			if kind == "delete" {
+				status, response = f.document(http.MethodDelete, index, "_doc", meta.ID, url.Values{}, nil)
+			} else {
+				if !scanner.Scan() {
+					return errorResponse(http.StatusBadRequest, "illegal_argument_exception", "bulk action "+kind+" has no source")
+				}
+				source := append([]byte{}, scanner.Bytes()...)
+				switch kind {
+				case "create":
+					status, response = f.document(http.MethodPut, index, "_create", meta.ID, url.Values{}, source)
+				case "update":
+					status, response = f.updateDocument(index, meta.ID, source)
+				default:
+					status, response = f.document(http.MethodPut, index, "_doc", meta.ID, url.Values{}, source)
+				}
+			}
+			item, _ := response.(map[string]interface{})
+			if item == nil {
+				item = map[string]interface{}{}
+			}
+			item["status"] = status
+			if status >= 300 {
+				failed = true
+			}
+			items = append(items, map[string]interface{}{kind: item})
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"took": 1, "errors": failed, "items": items}
+}
+
+// matches evaluates a query against a document
+func matches(source map[string]interface{}, id string, query interface{}) (bool, error) {
+	if query == nil {
+		return true, nil
+	}
+	clauses, ok := query.(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("malformed query %v", query)
+	}
+	for kind, clause := range clauses {
+		ok, err := matchClause(source, id, kind, clause)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchClause(source map[string]interface{}, id, kind string, clause interface{}) (bool, error) {
+	params, _ := clause.(map[string]interface{})
+	switch kind {
+	case "match_all":
+		return true, nil
+	case "function_score", "constant_score":
+		// scores are not computed; only the inner query filters
+		inner := params["query"]
+		if kind == "constant_score" {
+			inner = params["filter"]
+		}
+		return matches(source, id, inner)
+	case "match_none":
+		return false, nil
+	case "exists":
+		_, ok := lookup(source, fmt.Sprint(params["field"]))
+		return ok, nil
+	case "ids":
+		values, _ := params["values"].([]interface{})
+		for _, value := range values {
+			if fmt.Sprint(value) == id {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "term", "terms":
+		for field, expected := range params {
+			if field == "boost" {
+				continue
+			}
+			if term, ok := expected.(map[string]interface{}); ok && kind == "term" {
+				expected = term["value"]
+			}
+			candidates, ok := expected.([]interface{})
+			if !ok {
+				candidates = []interface{}{expected}
+			}
+			value, _ := lookup(source, field)
+			for _, candidate := range candidates {
+				if equalValues(value, candidate) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return true, nil
+	case "bool":
+		list := func(key string) []interface{} {
+			switch clauses := params[key].(type) {
+			case []interface{}:
+				return clauses
+			case map[string]interface{}:
+				return []interface{}{clauses}
+			}
+			return nil
+		}
+		for _, key := range []string{"must", "filter"} {
+			for _, sub := range list(key) {
+				if ok, err := matches(source, id, sub); err != nil || !ok {
+					return false, err
+				}
+			}
+		}
+		for _, sub := range list("must_not") {
+			if ok, err := matches(source, id, sub); err != nil || ok {
+				return false, err
+			}
+		}
+		should := list("should")
+		if len(should) == 0 || len(list("must"))+len(list("filter")) > 0 {
+			return true, nil
+		}
+		for _, sub := range should {
+			if ok, err := matches(source, id, sub); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("[%s] query is not supported by the fake", kind)
+}
+
+// lookup returns the value of a dotted field of a document
+func lookup(source map[string]interface{}, field string) (interface{}, bool) {
+	if value, ok := source[field]; ok {
+		return value, value != nil
+	}
+	head, rest, found := strings.Cut(field, ".")
+	if !found {
+		return nil, false
+	}
+	nested, ok := source[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookup(nested, rest)
+}
+
+// equalValues compares a field value with a query term. Array values match
+// when one element does.
+func equalValues(value, term interface{}) bool {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if equalValues(v, term) {
+				return true
+			}
+		}
+		return false
+	}
+	return value != nil && fmt.Sprint(value) == fmt.Sprint(term)
+}
+
+// sortHits orders hits by a sort clause. Documents missing a field sort last.
+func sortHits(hits []hit, clause interface{}) {
+	var sorts []interface{}
+	switch s := clause.(type) {
+	case []interface{}:
+		sorts = s
+	case nil:
+		return
+	default:
+		sorts = []interface{}{s}
+	}
+
+	type key struct {
+		field string
+		desc  bool
+	}
+	var keys []key
+	for _, s := range sorts {
+		switch s := s.(type) {
+		case string:
+			keys = append(keys, key{field: s})
+		case map[string]interface{}:
+			for field, order := range s {
+				desc := order == "desc"
+				if options, ok := order.(map[string]interface{}); ok {
+					desc = options["order"] == "desc"
+				}
+				keys = append(keys, key{field: field, desc: desc})
+			}
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		for _, k := range keys {
+			a, aok := lookup(hits[i].source, k.field)
+			b, bok := lookup(hits[j].source, k.field)
+			if aok != bok {
+				return aok
+			}
+			if c := compareValues(a, b); c != 0 {
+				return (c < 0) != k.desc
+			}
+		}
+		return false
+	})
+}
+
+func compareValues(a, b interface{}) int {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// mergeSettings adds settings, nested or flat, to flat settings prefixed
+// with index.
+func mergeSettings(flat, settings map[string]interface{}) {
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		if nested, ok := value.(map[string]interface{}); ok {
+			for key, v := range nested {
+				walk(prefix+"."+key, v)
+			}
+			return
+		}
+		name := strings.TrimPrefix(prefix, ".")
+		if !strings.HasPrefix(name, "index.") {
+			name = "index." + name
+		}
+		if value == nil {
+			delete(flat, name)
+		} else {
+			flat[name] = fmt.Sprint(value)
+		}
+	}
+	walk("", settings)
+}
+
+// formatSettings returns flat settings as flat_settings asks for
+func formatSettings(flat map[string]interface{}, query url.Values) map[string]interface{} {
+	if query.Get("flat_settings") == "true" {
+		return copyMap(flat)
+	}
+	nested := make(map[string]interface{})
+	for name, value := range flat {
+		parts := strings.Split(name, ".")
+		current := nested
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := current[part].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				current[part] = next
+			}
+			current = next
+		}
+		current[parts[len(parts)-1]] = value
+	}
+	return nested
+}
+
+// mergeMaps merges src into dst recursively
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if nested, ok := value.(map[string]interface{}); ok {
+			if existing, ok := dst[key].(map[string]interface{}); ok {
+				mergeMaps(existing, nested)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(m)
+	var copied map[string]interface{}
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+func aliasMap(idx *fakeIndex) map[string]interface{} {
+	aliases := make(map[string]interface{}, len(idx.aliases))
+	for alias := range idx.aliases {
+		aliases[alias] = map[string]interface{}{}
+	}
+	return aliases
+}
+
+func acknowledged() map[string]interface{} {
+	return map[string]interface{}{"acknowledged": true}
+}
+
+func errorResponse(status int, kind, reason string) (int, interface{}) {
+	cause := map[string]interface{}{"type": kind, "reason": reason}
+	return status, map[string]interface{}{
+		"error":  map[string]interface{}{"root_cause": []interface{}{cause}, "type": kind, "reason": reason},
+		"status": status,
+	}
+}
+
+func indexNotFound(name string) (int, interface{}) {
+	return errorResponse(http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+}
+
+func parseError(err error) (int, interface{}) {
+	return errorResponse(http.StatusBadRequest, "parsing_exception", err.Error())
+}
@@ -0,0 +1,116 @@
+package migrationtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+func TestFakeES(t *testing.T) {
+	t.Run("Test Migrations Are Applied And Tracked", func(t *testing.T) {
+		es := NewFakeES(t)
+		create := migration.PutMapping("Create articles index", "articles", `{"properties": {"title": {"type": "text"}}}`)
+		tags := migration.PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}}}`)
+		seed := migration.SeedMigration("Seed articles", migration.SeedConfig{
+			Index:   "articles",
+			Data:    []byte(`[{"id": "1", "title": "Hello"}, {"id": "2", "title": "World"}]`),
+			IDField: "id",
+		})
+
+		for run := 0; run < 2; run++ {
+			mm := es.Manager(migration.WithMigrations(create, tags, seed))
+			if err := mm.RunMigrations(); err != nil {
+				t.Fatalf("Failed to run migrations: %v", err)
+			}
+		}
+
+		properties, _ := es.Mapping("articles")["properties"].(map[string]interface{})
+		if properties["title"] == nil || properties["tags"] == nil {
+			t.Errorf("Expected title and tags mapped, got %v", properties)
+		}
+		if docs := es.Documents("articles"); len(docs) != 2 {
+			t.Errorf("Expected 2 seeded documents after two runs, got %d", len(docs))
+		}
+
+		applied, err := es.Manager().AppliedRecords()
+		if err != nil {
+			t.Fatalf("Failed to get applied records: %v", err)
+		}
+		if len(applied) != 3 {
+			t.Errorf("Expected 3 records, got %+v", applied)
+		}
+	})
+
+	t.Run("Test Migrations Read The Cluster State", func(t *testing.T) {
+		es := NewFakeES(t)
+		if err := es.CreateIndex("articles", `{"settings": {"number_of_replicas": 0}}`); err != nil {
+			t.Fatalf("Failed to create index: %v", err)
+		}
+		es.AddDocument("articles", "1", map[string]interface{}{"title": "Hello", "meta": map[string]interface{}{"draft": true}})
+		es.AddDocument("articles", "2", map[string]interface{}{"title": "World"})
+
+		var drafts int
+		mm := es.Manager(migration.WithMigrations(migration.NewMigration("Count drafts", func(client *elasticsearch.Client) error {
+			res, err := client.Count(
+				client.Count.WithIndex("articles"),
+				client.Count.WithBody(strings.NewReader(`{"query": {"bool": {"filter": [{"term": {"meta.draft": true}}]}}}`)),
+			)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if res.IsError() {
+				return fmt.Errorf("error counting drafts: %s", res.String())
+			}
+			var count struct {
+				Count int `json:"count"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&count); err != nil {
+				return err
+			}
+			drafts = count.Count
+			return nil
+		})))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if drafts != 1 {
+			t.Errorf("Expected 1 draft counted, got %d", drafts)
+		}
+		if replicas := es.Setting("articles", "index.number_of_replicas"); replicas != "0" {
+			t.Errorf("Expected 0 replicas, got %v", replicas)
+		}
+		if !es.Sent(http.MethodPost, "/articles/_count") {
+			t.Errorf("Expected the count request recorded")
+		}
+	})
+
+	t.Run("Test Canned Responses", func(t *testing.T) {
+		es := NewFakeES(t)
+		es.Respond(http.MethodPut, "/broken", http.StatusBadRequest, `{"error": {"type": "mapper_parsing_exception", "reason": "bad mapping"}, "status": 400}`)
+
+		mm := es.Manager(migration.WithMigrations(migration.PutMapping("Create broken index", "broken", `{"properties": {}}`)))
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+			t.Fatalf("Expected the canned error, got %v", err)
+		}
+		applied, _ := mm.GetAppliedMigrations()
+		if len(applied) != 0 {
+			t.Errorf("Expected nothing recorded, got %v", applied)
+		}
+	})
+
+	t.Run("Test Concurrent Runners", func(t *testing.T) {
+		es := NewFakeES(t)
+		RunConcurrent(t, ConcurrencyConfig{
+			Runners:    4,
+			Migrations: 3,
+			NewManager: func() *migration.MigrationManager { return es.Manager() },
+		})
+	})
+}
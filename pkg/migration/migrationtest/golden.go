@@ -0,0 +1,170 @@
+package migrationtest
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// UpdateGoldenEnv names the environment variable that makes AssertGolden
+// write golden files instead of comparing them, e.g.
+// ELASTICMATE_UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "ELASTICMATE_UPDATE_GOLDEN"
+
+// goldenExt is the extension of golden files
+const goldenExt = ".golden"
+
+// RenderRequests renders the requests each declarative migration sends, in
+// the order given, against a cluster holding the existing indices on which
+// every migration is applied after the previous ones. It returns the text of
+// each migration in order; migrations written in Go render their
+// description only.
+func RenderRequests(migrations []migration.Migration, existing ...string) ([]string, error) {
+	indices := make(map[string]bool, len(existing))
+	for _, index := range existing {
+		indices[index] = true
+	}
+	exists := func(index string) (bool, error) {
+		return indices[index], nil
+	}
+
+	rendered := make([]string, 0, len(migrations))
+	for _, m := range migrations {
+		requests, err := m.PlannedRequests(exists)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering migration %s: %w", m.Version(), err)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "# %s\n", m.Description)
+		for _, request := range requests {
+			b.WriteString("\n")
+			b.WriteString(request.String())
+
+			// requests to the index itself create or delete it
+			index, rest, _ := strings.Cut(strings.TrimPrefix(request.Path, "/"), "/")
+			if rest != "" || strings.HasPrefix(index, "_") {
+				continue
+			}
+			index, _, _ = strings.Cut(index, "?")
+			switch request.Method {
+			case http.MethodPut:
+				indices[index] = true
+			case http.MethodDelete:
+				delete(indices, index)
+			}
+		}
+		rendered = append(rendered, b.String())
+	}
+	return rendered, nil
+}
+
+// AssertGolden renders the requests of migrations with RenderRequests and
+// compares each with its golden file in dir, so an edit to a declared schema
+// shows up as a test failure and a diff in review. Golden files are named
+// after the file a migration was loaded from, or its version, with a .golden
+// extension. Golden files of migrations that no longer exist fail the test.
+//
+// With ELASTICMATE_UPDATE_GOLDEN set, the golden files are written instead,
+// and those of removed migrations deleted.
+func AssertGolden(t *testing.T, migrations []migration.Migration, dir string, existing ...string) {
+	t.Helper()
+
+	rendered, err := RenderRequests(migrations, existing...)
+	if err != nil {
+		t.Fatalf("Failed to render migrations: %v", err)
+	}
+	update := os.Getenv(UpdateGoldenEnv) != ""
+	if update {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("Failed to create golden directory: %v", err)
+		}
+	}
+
+	expected := make(map[string]bool, len(migrations))
+	for i, m := range migrations {
+		name := goldenName(m)
+		if expected[name] {
+			t.Errorf("Migrations %s share the golden file %s", m.Version(), name)
+			continue
+		}
+		expected[name] = true
+		file := filepath.Join(dir, name)
+
+		if update {
+			if err := os.WriteFile(file, []byte(rendered[i]), 0o644); err != nil {
+				t.Fatalf("Failed to write golden file: %v", err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			t.Errorf("Golden file %s of migration %s does not exist; run the test with %s=1 to create it", file, m.Version(), UpdateGoldenEnv)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to read golden file: %v", err)
+		}
+		if diff := lineDiff(string(want), rendered[i]); diff != "" {
+			t.Errorf("Migration %s (%s) differs from %s; run the test with %s=1 if the change is intended:\n%s", m.Version(), m.Description, file, UpdateGoldenEnv, diff)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+goldenExt))
+	for _, file := range files {
+		if expected[filepath.Base(file)] {
+			continue
+		}
+		if update {
+			if err := os.Remove(file); err != nil {
+				t.Fatalf("Failed to remove golden file: %v", err)
+			}
+			continue
+		}
+		t.Errorf("Golden file %s has no migration; run the test with %s=1 to remove it", file, UpdateGoldenEnv)
+	}
+}
+
+// goldenName returns the golden file name of a migration
+func goldenName(m migration.Migration) string {
+	if source := m.Source(); source != "" {
+		return strings.TrimSuffix(path.Base(source), path.Ext(source)) + goldenExt
+	}
+	return m.Version() + goldenExt
+}
+
+// lineDiff returns the lines of want and got that differ, prefixed with - and
+// +, empty when they are equal
+func lineDiff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	// trim the common prefix and suffix, and show what lies between
+	start := 0
+	for start < len(wantLines) && start < len(gotLines) && wantLines[start] == gotLines[start] {
+		start++
+	}
+	wantEnd, gotEnd := len(wantLines), len(gotLines)
+	for wantEnd > start && gotEnd > start && wantLines[wantEnd-1] == gotLines[gotEnd-1] {
+		wantEnd--
+		gotEnd--
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ line %d @@\n", start+1)
+	for _, line := range wantLines[start:wantEnd] {
+		fmt.Fprintf(&b, "-%s\n", line)
+	}
+	for _, line := range gotLines[start:gotEnd] {
+		fmt.Fprintf(&b, "+%s\n", line)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package migrationtest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+func TestGolden(t *testing.T) {
+	migrations, err := migration.LoadFS(os.DirFS("testdata/migrations"))
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	t.Run("Test Golden Files Match", func(t *testing.T) {
+		AssertGolden(t, migrations, "testdata/golden")
+	})
+
+	t.Run("Test Requests Follow Earlier Migrations", func(t *testing.T) {
+		rendered, err := RenderRequests(migrations)
+		if err != nil {
+			t.Fatalf("Failed to render migrations: %v", err)
+		}
+		if !strings.Contains(rendered[1], "PUT /articles/_mapping") {
+			t.Errorf("Expected tags put on the created index, got:\n%s", rendered[1])
+		}
+		if !strings.Contains(rendered[2], "PUT /comments\n") {
+			t.Errorf("Expected the comments index created, got:\n%s", rendered[2])
+		}
+
+		rendered, err = RenderRequests(migrations, "comments")
+		if err != nil {
+			t.Fatalf("Failed to render migrations: %v", err)
+		}
+		if !strings.Contains(rendered[2], "PUT /comments/_mapping") {
+			t.Errorf("Expected the mapping put on the existing index, got:\n%s", rendered[2])
+		}
+	})
+
+	t.Run("Test Update Writes And Prunes Golden Files", func(t *testing.T) {
+		dir := t.TempDir()
+		stale := filepath.Join(dir, "0000_removed.golden")
+		os.WriteFile(stale, []byte("# Removed\n"), 0o644)
+
+		t.Setenv(UpdateGoldenEnv, "1")
+		AssertGolden(t, migrations, dir)
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Errorf("Expected the stale golden file removed")
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "0002_add_tags.golden"))
+		if err != nil || !strings.HasPrefix(string(data), "# Add tags to articles\n") {
+			t.Errorf("Expected the golden file written, got %q, %v", data, err)
+		}
+
+		t.Setenv(UpdateGoldenEnv, "")
+		AssertGolden(t, migrations, dir)
+	})
+
+	t.Run("Test Line Diff", func(t *testing.T) {
+		diff := lineDiff("a\nb\nc\n", "a\nx\nc\n")
+		if diff != "@@ line 2 @@\n-b\n+x\n" {
+			t.Errorf("Expected one changed line, got %q", diff)
+		}
+		if lineDiff("a\n", "a\n") != "" {
+			t.Errorf("Expected no diff for equal text")
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// defaultClusterConcurrency is how many clusters MultiClusterManager migrates
+// at once unless told otherwise
+const defaultClusterConcurrency = 4
+
+// Cluster is one of the clusters a MultiClusterManager migrates
+type Cluster struct {
+	Name   string
+	Client *elasticsearch.Client
+	// Options are applied after the options shared by every cluster, e.g. a
+	// version store or namespace specific to this cluster
+	Options []Option
+}
+
+// MultiClusterManager applies the same migrations to several clusters, such as
+// one per environment or tenant. Every cluster tracks its own applied
+// migrations, so a cluster that fails or lags behind does not hold back the
+// others.
+type MultiClusterManager struct {
+	Clusters   []Cluster
+	Migrations []Migration
+	// Concurrency bounds how many clusters are migrated at once, 4 by default
+	Concurrency int
+
+	opts []Option
+}
+
+// NewMultiClusterManager returns a manager migrating clusters, configuring the
+// manager of each with opts followed by the cluster's own options. Options
+// sharing state, such as WithStore, belong in Cluster.Options.
+func NewMultiClusterManager(clusters []Cluster, opts ...Option) *MultiClusterManager {
+	return &MultiClusterManager{Clusters: clusters, opts: opts}
+}
+
+func (m *MultiClusterManager) Register(migration Migration) {
+	m.Migrations = append(m.Migrations, migration)
+}
+
+// Manager returns the manager migrating the named cluster, nil when there is
+// no such cluster
+func (m *MultiClusterManager) Manager(name string) *MigrationManager {
+	for _, cluster := range m.Clusters {
+		if cluster.Name == name {
+			return m.manager(cluster)
+		}
+	}
+	return nil
+}
+
+func (m *MultiClusterManager) manager(cluster Cluster) *MigrationManager {
+	opts := append(append([]Option{}, m.opts...), cluster.Options...)
+	mm := NewMigrationManager(cluster.Client, opts...)
+	// prefix the cluster to progress messages, which interleave across clusters
+	mm.Logger = clusterLogger{logger: mm.Logger, prefix: "[" + cluster.Name + "] "}
+	// every manager sorts its own copy of the migrations
+	mm.Migrations = append([]Migration{}, m.Migrations...)
+	return mm
+}
+
+// clusterLogger prefixes messages with the name of a cluster
+type clusterLogger struct {
+	logger Logger
+	prefix string
+}
+
+func (l clusterLogger) Printf(format string, v ...interface{}) {
+	if l.logger == nil {
+		defaultLogger.Printf(l.prefix+format, v...)
+		return
+	}
+	l.logger.Printf(l.prefix+format, v...)
+}
+
+// ClusterOutcome is the state of a migration on a cluster after a run
+type ClusterOutcome string
+
+const (
+	// OutcomeApplied migrations were applied by the run
+	OutcomeApplied ClusterOutcome = "applied"
+	// OutcomeCurrent migrations were applied before the run
+	OutcomeCurrent ClusterOutcome = "current"
+	OutcomeSkipped ClusterOutcome = "skipped"
+	OutcomeFailed  ClusterOutcome = "failed"
+	// OutcomePending migrations were not reached, e.g. after an earlier failure
+	OutcomePending ClusterOutcome = "pending"
+)
+
+// ClusterResult is the result of the run on one cluster
+type ClusterResult struct {
+	Cluster string     `json:"cluster"`
+	Summary RunSummary `json:"summary"`
+	// Outcomes maps the version of every registered migration to its state
+	Outcomes map[string]ClusterOutcome `json:"outcomes"`
+	// Err is the error the run on the cluster returned
+	Err error `json:"-"`
+}
+
+// ResultMatrix is the consolidated result of a multi-cluster run, one row per
+// migration and one column per cluster
+type ResultMatrix struct {
+	Migrations []ManifestEntry `json:"migrations"`
+	Clusters   []ClusterResult `json:"clusters"`
+}
+
+// Outcome returns the state of a migration on a cluster
+func (r *ResultMatrix) Outcome(cluster, version string) ClusterOutcome {
+	for _, result := range r.Clusters {
+		if result.Cluster == cluster {
+			return result.Outcomes[version]
+		}
+	}
+	return ""
+}
+
+// Failed returns the names of the clusters whose run returned an error
+func (r *ResultMatrix) Failed() []string {
+	var failed []string
+	for _, result := range r.Clusters {
+		if result.Err != nil {
+			failed = append(failed, result.Cluster)
+		}
+	}
+	return failed
+}
+
+// Write renders the matrix as a table
+func (r *ResultMatrix) Write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := []string{"VERSION", "DESCRIPTION"}
+	for _, result := range r.Clusters {
+		header = append(header, strings.ToUpper(result.Cluster))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, entry := range r.Migrations {
+		row := []string{entry.Version, entry.Description}
+		for _, result := range r.Clusters {
+			row = append(row, string(result.Outcomes[entry.Version]))
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func (r *ResultMatrix) String() string {
+	var b strings.Builder
+	r.Write(&b)
+	return b.String()
+}
+
+// RunMigrations applies pending migrations to every cluster, at most
+// Concurrency at a time, until ctx is done. Clusters are migrated to
+// completion independently; the returned error joins the errors of the
+// clusters that failed, and the matrix reports every cluster either way.
+func (m *MultiClusterManager) RunMigrations(ctx context.Context) (*ResultMatrix, error) {
+	matrix := &ResultMatrix{Clusters: make([]ClusterResult, len(m.Clusters))}
+	for _, migration := range m.Migrations {
+		matrix.Migrations = append(matrix.Migrations, ManifestEntry{Version: migration.Version(), Description: migration.Description})
+	}
+	sort.Slice(matrix.Migrations, func(i, j int) bool {
+		return matrix.Migrations[i].Version < matrix.Migrations[j].Version
+	})
+
+	concurrency := m.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultClusterConcurrency
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, cluster := range m.Clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			matrix.Clusters[i] = m.runCluster(ctx, cluster, matrix.Migrations)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, result := range matrix.Clusters {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", result.Cluster, result.Err))
+		}
+	}
+	return matrix, errors.Join(errs...)
+}
+
+func (m *MultiClusterManager) runCluster(ctx context.Context, cluster Cluster, migrations []ManifestEntry) ClusterResult {
+	result := ClusterResult{Cluster: cluster.Name, Outcomes: make(map[string]ClusterOutcome, len(migrations))}
+	var applied map[string]bool
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+	} else {
+		mm := m.manager(cluster)
+		result.Summary, result.Err = mm.RunMigrationsSummary(ctx)
+		// an unreachable store leaves everything not applied by the run pending
+		applied, _ = mm.GetAppliedMigrations()
+	}
+
+	for _, entry := range migrations {
+		result.Outcomes[entry.Version] = OutcomePending
+		if applied[entry.Version] {
+			result.Outcomes[entry.Version] = OutcomeCurrent
+		}
+	}
+	for _, migration := range result.Summary.Applied {
+		result.Outcomes[migration.Version] = OutcomeApplied
+	}
+	for _, migration := range result.Summary.Skipped {
+		result.Outcomes[migration.Version] = OutcomeSkipped
+	}
+	for _, migration := range result.Summary.Failed {
+		result.Outcomes[migration.Version] = OutcomeFailed
+	}
+	return result
+}
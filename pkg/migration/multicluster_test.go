@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// checkArticles fails on clusters without an articles index
+func checkArticles(client *elasticsearch.Client) error {
+	res, err := client.Indices.Get([]string{"articles"})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error getting index articles: %s", res.String())
+	}
+	return nil
+}
+
+func TestMultiClusterManager(t *testing.T) {
+	newCluster := func(name string, hasArticles bool) (Cluster, *MemoryStore) {
+		transport := &routeTransport{routes: map[string]string{}}
+		if hasArticles {
+			transport.routes["GET /articles"] = `{"articles": {}}`
+		}
+		store := NewMemoryStore()
+		return Cluster{Name: name, Client: ClientFromTransport(transport), Options: []Option{WithStore(store)}}, store
+	}
+
+	var running, maxRunning int32
+	first := NewMigration("Create articles index", func(client *elasticsearch.Client) error {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	second := NewMigration("Check articles index", checkArticles)
+
+	dev, devStore := newCluster("dev", true)
+	prod, prodStore := newCluster("prod", true)
+	broken, brokenStore := newCluster("broken", false)
+
+	// prod already applied the first migration in an earlier run
+	if err := prodStore.Record(MigrationRecord{Version: first.Version(), Description: first.Description}); err != nil {
+		t.Fatalf("Failed to record migration: %v", err)
+	}
+
+	var output bytes.Buffer
+	mcm := NewMultiClusterManager([]Cluster{dev, prod, broken}, WithLogger(log.New(&output, "", 0)))
+	mcm.Concurrency = 1
+	mcm.Register(first)
+	mcm.Register(second)
+
+	matrix, err := mcm.RunMigrations(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cluster broken") {
+		t.Fatalf("Expected the broken cluster to fail the run, got %v", err)
+	}
+
+	t.Run("Test Clusters Are Tracked Independently", func(t *testing.T) {
+		for _, store := range []*MemoryStore{devStore, prodStore} {
+			if records, _ := store.GetApplied(); len(records) != 2 {
+				t.Errorf("Expected 2 applied migrations, got %d", len(records))
+			}
+		}
+		if records, _ := brokenStore.GetApplied(); len(records) != 1 {
+			t.Errorf("Expected the broken cluster to keep its first migration, got %d", len(records))
+		}
+	})
+
+	t.Run("Test Result Matrix", func(t *testing.T) {
+		expected := map[string][2]ClusterOutcome{
+			"dev":    {OutcomeApplied, OutcomeApplied},
+			"prod":   {OutcomeCurrent, OutcomeApplied},
+			"broken": {OutcomeApplied, OutcomeFailed},
+		}
+		for cluster, outcomes := range expected {
+			if got := matrix.Outcome(cluster, first.Version()); got != outcomes[0] {
+				t.Errorf("Expected %s on %s for the first migration, got %s", outcomes[0], cluster, got)
+			}
+			if got := matrix.Outcome(cluster, second.Version()); got != outcomes[1] {
+				t.Errorf("Expected %s on %s for the second migration, got %s", outcomes[1], cluster, got)
+			}
+		}
+		if failed := matrix.Failed(); len(failed) != 1 || failed[0] != "broken" {
+			t.Errorf("Expected only the broken cluster to fail, got %v", failed)
+		}
+		if table := matrix.String(); !strings.Contains(table, "BROKEN") || !strings.Contains(table, "Check articles index") {
+			t.Errorf("Expected the table to list clusters and migrations, got:\n%s", table)
+		}
+	})
+
+	t.Run("Test Messages Name The Cluster", func(t *testing.T) {
+		if !strings.Contains(output.String(), "[dev] Applying migration") {
+			t.Errorf("Expected progress messages prefixed by the cluster, got:\n%s", output.String())
+		}
+	})
+
+	t.Run("Test Concurrency Is Bounded", func(t *testing.T) {
+		if maxRunning != 1 {
+			t.Errorf("Expected one cluster at a time, got %d", maxRunning)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package migration_test
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestNamespaces(t *testing.T) {
+	es := migrationtest.NewFakeES(t)
+	quiet := migration.WithLogger(log.New(io.Discard, "", 0))
+	noop := func(client *elasticsearch.Client) error { return nil }
+
+	orders := es.Manager(migration.WithNamespace("OrderSvc"), quiet)
+	billing := es.Manager(migration.WithNamespace("billing"), quiet)
+
+	t.Run("Test Tracking Index Is Lowercased", func(t *testing.T) {
+		explicit := es.Manager(migration.WithNamespace("OrderSvc"), migration.WithTrackingIndex("orders_history"))
+		for _, test := range []struct {
+			mm       *migration.MigrationManager
+			expected string
+		}{
+			{orders, ".elasticmate_migrations_ordersvc"},
+			{billing, ".elasticmate_migrations_billing"},
+			{es.Manager(), ".elasticmate_migrations"},
+			// WithTrackingIndex takes precedence over the namespace
+			{explicit, "orders_history"},
+		} {
+			if index, err := test.mm.TrackingIndex(); err != nil || index != test.expected {
+				t.Errorf("Expected tracking index %s, got %s %v", test.expected, index, err)
+			}
+		}
+	})
+
+	t.Run("Test Histories Are Kept Apart", func(t *testing.T) {
+		createOrders := migration.NewMigration("Create orders index", noop)
+		createInvoices := migration.NewMigration("Create invoices index", noop)
+		orders.Register(createOrders)
+		billing.Register(createInvoices)
+
+		if err := orders.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run the orders migrations: %v", err)
+		}
+		if err := billing.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run the billing migrations: %v", err)
+		}
+
+		// tracking indices are aliases of their first generation
+		for _, index := range []string{".elasticmate_migrations_ordersvc-000001", ".elasticmate_migrations_billing-000001"} {
+			if !es.IndexExists(index) {
+				t.Errorf("Expected tracking index %s to exist", index)
+			}
+		}
+		if es.IndexExists(".elasticmate_migrations-000001") {
+			t.Errorf("Expected no shared tracking index")
+		}
+
+		for _, test := range []struct {
+			mm       *migration.MigrationManager
+			applied  migration.Migration
+			excluded migration.Migration
+		}{
+			{orders, createOrders, createInvoices},
+			{billing, createInvoices, createOrders},
+		} {
+			applied, err := test.mm.GetAppliedMigrations()
+			if err != nil {
+				t.Fatalf("Failed to read applied migrations: %v", err)
+			}
+			if len(applied) != 1 || !applied[test.applied.Version()] || applied[test.excluded.Version()] {
+				t.Errorf("Expected only %q in the %s namespace, got %v", test.applied.Description, test.mm.Namespace, applied)
+			}
+		}
+
+		// a manager of the same namespace in another case reads the same history
+		again := es.Manager(migration.WithNamespace("ordersvc"), quiet)
+		if applied, err := again.GetAppliedMigrations(); err != nil || !applied[createOrders.Version()] {
+			t.Errorf("Expected the orders history to be shared regardless of case, got %v %v", applied, err)
+		}
+	})
+}
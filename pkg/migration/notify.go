@@ -0,0 +1,181 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifyTimeout bounds how long each notifier may take at the end of a run
+const notifyTimeout = 10 * time.Second
+
+// RunSummary describes a migration run for notifiers
+type RunSummary struct {
+	RunID      string            `json:"run_id,omitempty"`
+	Namespace  string            `json:"namespace,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	DurationMS int64             `json:"duration_ms"`
+	Applied    []MigrationResult `json:"applied"`
+	Skipped    []MigrationResult `json:"skipped"`
+	Failed     []MigrationResult `json:"failed"`
+	// Error is the error the run returned, if any
+	Error string `json:"error,omitempty"`
+}
+
+// MigrationResult is the outcome of one migration in a run
+type MigrationResult struct {
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	DurationMS  int64  `json:"duration_ms,omitempty"`
+	// Reason is why a migration was skipped
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *RunSummary) apply(record MigrationRecord) {
+	s.Applied = append(s.Applied, MigrationResult{Version: record.Version, Description: record.Description, DurationMS: record.DurationMS})
+}
+
+func (s *RunSummary) skip(migration Migration, reason string) {
+	s.Skipped = append(s.Skipped, MigrationResult{Version: migration.Version(), Description: migration.Description, Reason: reason})
+}
+
+func (s *RunSummary) fail(failure MigrationFailure) {
+	s.Failed = append(s.Failed, MigrationResult{
+		Version:     failure.Version,
+		Description: failure.Description,
+		DurationMS:  failure.DurationMS,
+		Error:       failure.Error,
+	})
+}
+
+// empty reports whether the run neither changed nor attempted anything
+func (s *RunSummary) empty() bool {
+	return len(s.Applied) == 0 && len(s.Skipped) == 0 && len(s.Failed) == 0 && s.Error == ""
+}
+
+// Notifier is told the outcome of every run that applied, skipped or failed
+// a migration
+type Notifier interface {
+	Notify(ctx context.Context, summary RunSummary) error
+}
+
+// NotifierFunc adapts a function to a Notifier
+type NotifierFunc func(ctx context.Context, summary RunSummary) error
+
+func (f NotifierFunc) Notify(ctx context.Context, summary RunSummary) error {
+	return f(ctx, summary)
+}
+
+// WithNotifier sends a summary to each notifier at the end of RunMigrations.
+// Runs with nothing pending are not reported and notification errors are
+// logged without failing the run.
+func WithNotifier(notifiers ...Notifier) Option {
+	return func(mm *MigrationManager) {
+		mm.notifiers = append(mm.notifiers, notifiers...)
+	}
+}
+
+func (mm *MigrationManager) notify(ctx context.Context, summary *RunSummary) {
+	if len(mm.notifiers) == 0 || summary.empty() {
+		return
+	}
+
+	// notify even when the run stopped because ctx expired
+	ctx = context.WithoutCancel(ctx)
+	for _, notifier := range mm.notifiers {
+		notifyCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		if err := notifier.Notify(notifyCtx, *summary); err != nil {
+			mm.logf("Failed to send run notification: %v", err)
+		}
+		cancel()
+	}
+}
+
+// WebhookNotifier posts the run summary as JSON to URL
+type WebhookNotifier struct {
+	URL string
+	// Headers are added to the request, e.g. for authentication
+	Headers map[string]string
+	// Client sends the request; http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, summary RunSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("error encoding run summary: %w", err)
+	}
+	return postJSON(ctx, n.Client, n.URL, n.Headers, body)
+}
+
+// SlackNotifier posts the run summary to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	// Client sends the request; http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, summary RunSummary) error {
+	body, err := json.Marshal(map[string]string{"text": summary.Text()})
+	if err != nil {
+		return fmt.Errorf("error encoding Slack message: %w", err)
+	}
+	return postJSON(ctx, n.Client, n.WebhookURL, nil, body)
+}
+
+// Text renders the summary as plain text, one line per migration
+func (s RunSummary) Text() string {
+	var b strings.Builder
+	b.WriteString("Migration run")
+	if s.RunID != "" {
+		fmt.Fprintf(&b, " %s", s.RunID)
+	}
+	if s.Namespace != "" {
+		fmt.Fprintf(&b, " (%s)", s.Namespace)
+	}
+	fmt.Fprintf(&b, ": %d applied, %d skipped, %d failed in %s\n",
+		len(s.Applied), len(s.Skipped), len(s.Failed), time.Duration(s.DurationMS)*time.Millisecond)
+
+	for _, result := range s.Applied {
+		fmt.Fprintf(&b, "• applied %s %s (%s)\n", result.Version, result.Description, time.Duration(result.DurationMS)*time.Millisecond)
+	}
+	for _, result := range s.Skipped {
+		fmt.Fprintf(&b, "• skipped %s %s: %s\n", result.Version, result.Description, result.Reason)
+	}
+	for _, result := range s.Failed {
+		fmt.Fprintf(&b, "• failed %s %s: %s\n", result.Version, result.Description, result.Error)
+	}
+	if s.Error != "" && len(s.Failed) == 0 {
+		fmt.Fprintf(&b, "Error: %s\n", s.Error)
+	}
+	return b.String()
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending notification: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("error sending notification: %s", res.Status)
+	}
+	return nil
+}
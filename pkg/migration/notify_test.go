@@ -0,0 +1,71 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestNotifiers(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+	}))
+	defer server.Close()
+
+	mm := NewMigrationManager(ClientFromTransport(&routeTransport{}),
+		WithStore(NewMemoryStore()),
+		WithFailureMode(ContinueOnError),
+		WithNotifier(
+			&SlackNotifier{WebhookURL: server.URL + "/slack"},
+			&WebhookNotifier{URL: server.URL + "/hook"},
+		),
+	)
+	mm.Register(NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil }))
+	mm.Register(NewMigration("Broken migration", func(client *elasticsearch.Client) error { return errors.New("boom") }))
+
+	if err := mm.RunMigrations(); err == nil {
+		t.Fatal("Expected the broken migration to fail the run")
+	}
+
+	t.Run("Test Slack Message", func(t *testing.T) {
+		if len(bodies) != 2 || !strings.HasPrefix(bodies[0], "/slack ") {
+			t.Fatalf("Expected a Slack and a webhook notification, got %v", bodies)
+		}
+		var message struct {
+			Text string `json:"text"`
+		}
+		json.Unmarshal([]byte(strings.TrimPrefix(bodies[0], "/slack ")), &message)
+		if !strings.Contains(message.Text, "1 applied, 0 skipped, 1 failed") || !strings.Contains(message.Text, "Broken migration: boom") {
+			t.Errorf("Expected the run summary, got %q", message.Text)
+		}
+	})
+
+	t.Run("Test Webhook Summary", func(t *testing.T) {
+		var summary RunSummary
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(bodies[1], "/hook ")), &summary); err != nil {
+			t.Fatalf("Failed to parse summary: %v", err)
+		}
+		if summary.RunID == "" || len(summary.Applied) != 1 || len(summary.Failed) != 1 || summary.Error == "" {
+			t.Errorf("Expected one applied and one failed migration, got %+v", summary)
+		}
+	})
+
+	t.Run("Test Nothing Pending Is Not Reported", func(t *testing.T) {
+		bodies = nil
+		mm.Migrations = mm.Migrations[:0]
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if len(bodies) != 0 {
+			t.Errorf("Expected no notification, got %v", bodies)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package migration_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/storetest"
+)
+
+// objectServer keeps objects with a generation per write, for fake object
+// storage APIs with conditional requests
+type objectServer struct {
+	mu          sync.Mutex
+	objects     map[string][]byte
+	generations map[string]int
+	generation  int
+}
+
+func newObjectServer() *objectServer {
+	return &objectServer{objects: make(map[string][]byte), generations: make(map[string]int)}
+}
+
+// matches reports whether the object at key is at revision, where an empty
+// revision matches a missing object
+func (o *objectServer) matches(key, revision string) bool {
+	_, exists := o.objects[key]
+	if revision == "" {
+		return !exists
+	}
+	return exists && revision == strconv.Itoa(o.generations[key])
+}
+
+func (o *objectServer) put(key string, data []byte) string {
+	o.generation++
+	o.objects[key] = data
+	o.generations[key] = o.generation
+	return strconv.Itoa(o.generation)
+}
+
+// gcsServer serves the JSON API calls GCSBucket makes
+func gcsServer(t *testing.T, objects *objectServer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gcs-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		objects.mu.Lock()
+		defer objects.mu.Unlock()
+
+		query := r.URL.Query()
+		generation := query.Get("ifGenerationMatch")
+		if generation == "0" {
+			generation = ""
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/deploy-state/o":
+			if !objects.matches(query.Get("name"), generation) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			fmt.Fprintf(w, `{"generation": %q}`, objects.put(query.Get("name"), data))
+		case strings.HasPrefix(r.URL.Path, "/storage/v1/b/deploy-state/o/"):
+			key, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/deploy-state/o/"))
+			data, exists := objects.objects[key]
+			switch {
+			case !exists:
+				w.WriteHeader(http.StatusNotFound)
+			case r.Method == http.MethodGet:
+				w.Header().Set("X-Goog-Generation", strconv.Itoa(objects.generations[key]))
+				w.Write(data)
+			case query.Has("ifGenerationMatch") && !objects.matches(key, generation):
+				w.WriteHeader(http.StatusPreconditionFailed)
+			default:
+				delete(objects.objects, key)
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+// azureServer serves the Blob service calls AzureBlobBucket makes
+func azureServer(t *testing.T, objects *objectServer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey deploy:") || r.Header.Get("X-Ms-Date") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		objects.mu.Lock()
+		defer objects.mu.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/state/")
+		etag := func(revision string) string { return strings.Trim(revision, `"`) }
+		_, exists := objects.objects[key]
+		switch {
+		case r.Method == http.MethodGet && !exists:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			w.Header().Set("ETag", strconv.Quote(strconv.Itoa(objects.generations[key])))
+			w.Write(objects.objects[key])
+		case r.Method == http.MethodPut && r.Header.Get("X-Ms-Blob-Type") != "BlockBlob":
+			w.WriteHeader(http.StatusBadRequest)
+		case r.Method == http.MethodPut && r.Header.Get("If-None-Match") == "*" && exists:
+			w.WriteHeader(http.StatusConflict)
+		case r.Method == http.MethodPut && r.Header.Get("If-Match") != "" && !objects.matches(key, etag(r.Header.Get("If-Match"))):
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			w.Header().Set("ETag", strconv.Quote(objects.put(key, data)))
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && !exists:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodDelete && r.Header.Get("If-Match") != "" && !objects.matches(key, etag(r.Header.Get("If-Match"))):
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case r.Method == http.MethodDelete:
+			delete(objects.objects, key)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestGCSBucket(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) migration.VersionStore {
+		server := gcsServer(t, newObjectServer())
+		t.Cleanup(server.Close)
+		return migration.NewObjectStore(&migration.GCSBucket{
+			Bucket:      "deploy-state",
+			Endpoint:    server.URL,
+			TokenSource: func() (string, error) { return "gcs-token", nil },
+		}, "elasticmate/versions.json")
+	})
+}
+
+func TestAzureBlobBucket(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) migration.VersionStore {
+		server := azureServer(t, newObjectServer())
+		t.Cleanup(server.Close)
+		return migration.NewObjectStore(&migration.AzureBlobBucket{
+			Account:    "deploy",
+			Container:  "state",
+			Endpoint:   server.URL,
+			AccountKey: base64.StdEncoding.EncodeToString([]byte("account-key")),
+		}, "elasticmate/versions.json")
+	})
+}
@@ -0,0 +1,54 @@
+package migration
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// OpenSearchConfig configures a client for OpenSearch clusters
+type OpenSearchConfig struct {
+	Addresses []string
+	Username  string
+	Password  string
+	CACert    []byte
+
+	// Transport is an optional HTTP transport, e.g. one that signs requests
+	// for Amazon OpenSearch Service
+	Transport http.RoundTripper
+}
+
+// NewOpenSearchClient returns a client that can run migrations against
+// OpenSearch. The go-elasticsearch client refuses to talk to servers that are
+// not Elasticsearch, so requests are sent through the transport layer directly.
+func NewOpenSearchClient(cfg OpenSearchConfig) (*elasticsearch.Client, error) {
+	addresses := cfg.Addresses
+	if len(addresses) == 0 {
+		addresses = []string{"http://localhost:9200"}
+	}
+
+	urls := make([]*url.URL, 0, len(addresses))
+	for _, address := range addresses {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenSearch address %q: %w", address, err)
+		}
+		urls = append(urls, u)
+	}
+
+	transport, err := elastictransport.New(elastictransport.Config{
+		URLs:      urls,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		CACert:    cfg.CACert,
+		Transport: cfg.Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating OpenSearch transport: %w", err)
+	}
+
+	return ClientFromTransport(transport), nil
+}
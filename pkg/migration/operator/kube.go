@@ -0,0 +1,182 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubeClient is a minimal client of the Kubernetes API, covering the
+// requests the controller makes
+type KubeClient struct {
+	// BaseURL is the API server URL, e.g. https://10.0.0.1:443
+	BaseURL string
+	// Token is sent as a bearer token. TokenFile is read on every request
+	// when Token is empty, so rotated service account tokens are picked up.
+	Token     string
+	TokenFile string
+	HTTP      *http.Client
+}
+
+// InClusterClient returns a client authenticated with the service account of
+// the pod it runs in
+func InClusterClient() (*KubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST is not set")
+	}
+	caCert, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("service account CA contains no certificates")
+	}
+	return &KubeClient{
+		BaseURL:   "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		HTTP: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// StatusError is an error response of the API server
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kubernetes API returned %d: %s", e.Code, e.Message)
+}
+
+// do sends a request with an optional JSON body and decodes the response into
+// out when set
+func (k *KubeClient) do(ctx context.Context, method, path, contentType string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(k.BaseURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	token := k.Token
+	if token == "" && k.TokenFile != "" {
+		data, err := os.ReadFile(k.TokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading service account token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := k.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling kubernetes API: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(res.Body)
+		if json.Unmarshal(data, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return &StatusError{Code: res.StatusCode, Message: status.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("error parsing kubernetes API response: %w", err)
+	}
+	return nil
+}
+
+// ConfigMap is the part of a ConfigMap the controller reads
+type ConfigMap struct {
+	Data       map[string]string `json:"data"`
+	BinaryData map[string][]byte `json:"binaryData"`
+}
+
+// Secret is the part of a Secret the controller reads
+type Secret struct {
+	Data map[string][]byte `json:"data"`
+}
+
+func (k *KubeClient) configMap(ctx context.Context, namespace, name string) (*ConfigMap, error) {
+	var configMap ConfigMap
+	if err := k.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", namespace, name), "", nil, &configMap); err != nil {
+		return nil, fmt.Errorf("error reading ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	return &configMap, nil
+}
+
+func (k *KubeClient) secret(ctx context.Context, namespace, name string) (*Secret, error) {
+	var secret Secret
+	if err := k.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name), "", nil, &secret); err != nil {
+		return nil, fmt.Errorf("error reading Secret %s/%s: %w", namespace, name, err)
+	}
+	return &secret, nil
+}
+
+// resourcePath returns the path of the ElasticsearchMigration resources of
+// namespace, of every namespace when it is empty
+func resourcePath(namespace string) string {
+	if namespace == "" {
+		return "/apis/" + Group + "/" + Version + "/" + Resource
+	}
+	return "/apis/" + Group + "/" + Version + "/namespaces/" + namespace + "/" + Resource
+}
+
+func (k *KubeClient) listMigrations(ctx context.Context, namespace string) ([]ElasticsearchMigration, error) {
+	var list struct {
+		Items []ElasticsearchMigration `json:"items"`
+	}
+	if err := k.do(ctx, http.MethodGet, resourcePath(namespace), "", nil, &list); err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", Resource, err)
+	}
+	return list.Items, nil
+}
+
+// updateStatus replaces the status of a migration resource through the
+// status subresource
+func (k *KubeClient) updateStatus(ctx context.Context, m ElasticsearchMigration) error {
+	path := resourcePath(m.Metadata.Namespace) + "/" + m.Metadata.Name + "/status"
+	patch := map[string]interface{}{"status": m.Status}
+	if err := k.do(ctx, http.MethodPatch, path, "application/merge-patch+json", patch, nil); err != nil {
+		return fmt.Errorf("error updating status of %s/%s: %w", m.Metadata.Namespace, m.Metadata.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+// Package operator reconciles ElasticsearchMigration resources, so schemas
+// can be managed with GitOps: each resource references a ConfigMap of
+// declarative migration files (see migration.LoadFS) and the cluster to
+// apply them to, and the controller writes the outcome back to its status.
+// It backs the elasticmate-operator command.
+//
+//	apiVersion: elasticmate.io/v1alpha1
+//	kind: ElasticsearchMigration
+//	metadata:
+//	  name: ordersvc
+//	spec:
+//	  cluster:
+//	    addresses: ["https://search:9200"]
+//	    credentialsSecret: search-credentials
+//	  configMap: ordersvc-migrations
+package operator
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"testing/fstest"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// The API group, version and plural resource name of ElasticsearchMigration
+const (
+	Group    = "elasticmate.io"
+	Version  = "v1alpha1"
+	Resource = "elasticsearchmigrations"
+)
+
+// Phases of an ElasticsearchMigration
+const (
+	PhaseApplied = "Applied"
+	PhaseFailed  = "Failed"
+	// PhaseLocked is reported while another runner holds the lock
+	PhaseLocked = "Locked"
+)
+
+// ElasticsearchMigration is a set of migrations to apply to a cluster
+type ElasticsearchMigration struct {
+	Metadata Metadata        `json:"metadata"`
+	Spec     MigrationSpec   `json:"spec"`
+	Status   MigrationStatus `json:"status"`
+}
+
+// Metadata is the part of the object metadata the controller reads
+type Metadata struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Generation int64  `json:"generation"`
+}
+
+// MigrationSpec is the desired state of an ElasticsearchMigration
+type MigrationSpec struct {
+	Cluster ClusterSpec `json:"cluster"`
+	// ConfigMap names the ConfigMap holding the migration files, in the
+	// namespace of the resource. Each key is a file; binaryData keys ending
+	// in .zip are read as bundles like those of `elasticmate serve`.
+	ConfigMap string `json:"configMap,omitempty"`
+	// Image is a migration bundle image. It is not supported yet.
+	Image string `json:"image,omitempty"`
+	// Namespace scopes the tracking index, the resource name by default
+	Namespace string `json:"namespace,omitempty"`
+	// Suspend stops the controller from applying migrations
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// ClusterSpec is the cluster migrations are applied to
+type ClusterSpec struct {
+	Addresses []string `json:"addresses,omitempty"`
+	CloudID   string   `json:"cloudID,omitempty"`
+	// CredentialsSecret names a Secret in the namespace of the resource with
+	// the optional keys username, password, api_key, bearer_token and ca.crt
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	OpenSearch        bool   `json:"openSearch,omitempty"`
+}
+
+// MigrationStatus is the outcome of the last reconciliation. Its fields are
+// always sent so a merge patch clears values of earlier outcomes.
+type MigrationStatus struct {
+	Phase              string `json:"phase"`
+	ObservedGeneration int64  `json:"observedGeneration"`
+	// Applied and Pending count the migrations of the ConfigMap
+	Applied int `json:"applied"`
+	Pending int `json:"pending"`
+	// LastRunID is the run that last applied a migration
+	LastRunID string `json:"lastRunID"`
+	Message   string `json:"message"`
+	// LastTransitionTime is when the phase last changed
+	LastTransitionTime *time.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// Controller applies the migrations of ElasticsearchMigration resources
+type Controller struct {
+	Kube *KubeClient
+	// Namespace limits the controller to one namespace, all when empty
+	Namespace string
+	// Interval is how often resources are reconciled, a minute by default
+	Interval time.Duration
+	Logger   migration.Logger
+
+	// newManager is replaced in tests
+	newManager func(cluster ClusterSpec, credentials map[string][]byte, opts ...migration.Option) (*migration.MigrationManager, error)
+}
+
+// NewController returns a controller using kube
+func NewController(kube *KubeClient, namespace string) *Controller {
+	return &Controller{Kube: kube, Namespace: namespace, newManager: newManager}
+}
+
+func (c *Controller) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// Run reconciles every Interval until ctx is done
+func (c *Controller) Run(ctx context.Context) error {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	for {
+		if err := c.Reconcile(ctx); err != nil {
+			c.logf("Reconciliation failed: %v", err)
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// Reconcile applies the pending migrations of every resource once and
+// updates their status. The failure of one resource does not stop the
+// others; it is reported in its status.
+func (c *Controller) Reconcile(ctx context.Context) error {
+	resources, err := c.Kube.listMigrations(ctx, c.Namespace)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, resource := range resources {
+		if resource.Spec.Suspend {
+			continue
+		}
+		status := c.reconcile(ctx, resource)
+		if status.Phase != resource.Status.Phase {
+			now := time.Now().UTC().Truncate(time.Second)
+			status.LastTransitionTime = &now
+		} else {
+			status.LastTransitionTime = resource.Status.LastTransitionTime
+		}
+		if status.LastRunID == "" {
+			status.LastRunID = resource.Status.LastRunID
+		}
+		if statusEqual(status, resource.Status) {
+			continue
+		}
+
+		resource.Status = status
+		if err := c.Kube.updateStatus(ctx, resource); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reconcile applies the migrations of a resource and returns its new status
+func (c *Controller) reconcile(ctx context.Context, resource ElasticsearchMigration) MigrationStatus {
+	name := resource.Metadata.Namespace + "/" + resource.Metadata.Name
+	status := MigrationStatus{ObservedGeneration: resource.Metadata.Generation}
+	failed := func(err error) MigrationStatus {
+		c.logf("Failed to reconcile %s: %v", name, err)
+		status.Phase = PhaseFailed
+		status.Message = err.Error()
+		return status
+	}
+
+	if resource.Spec.ConfigMap == "" {
+		if resource.Spec.Image != "" {
+			return failed(errors.New("image bundles are not supported; reference a ConfigMap"))
+		}
+		return failed(errors.New("spec.configMap is required"))
+	}
+	configMap, err := c.Kube.configMap(ctx, resource.Metadata.Namespace, resource.Spec.ConfigMap)
+	if err != nil {
+		return failed(err)
+	}
+	migrations, err := loadConfigMap(configMap)
+	if err != nil {
+		return failed(err)
+	}
+
+	var credentials map[string][]byte
+	if secret := resource.Spec.Cluster.CredentialsSecret; secret != "" {
+		s, err := c.Kube.secret(ctx, resource.Metadata.Namespace, secret)
+		if err != nil {
+			return failed(err)
+		}
+		credentials = s.Data
+	}
+
+	namespace := resource.Spec.Namespace
+	if namespace == "" {
+		namespace = resource.Metadata.Name
+	}
+	opts := []migration.Option{migration.WithNamespace(namespace), migration.WithMigrations(migrations...)}
+	if c.Logger != nil {
+		opts = append(opts, migration.WithLogger(c.Logger))
+	}
+	mm, err := c.newManager(resource.Spec.Cluster, credentials, opts...)
+	if err != nil {
+		return failed(err)
+	}
+
+	summary, runErr := mm.RunMigrationsSummary(ctx)
+	if len(summary.Applied) > 0 {
+		status.LastRunID = summary.RunID
+	}
+
+	records, err := mm.AppliedRecords()
+	if err == nil {
+		applied := make(map[string]bool, len(records))
+		for _, record := range records {
+			applied[record.Version] = true
+		}
+		for _, m := range migrations {
+			if applied[m.Version()] {
+				status.Applied++
+			} else {
+				status.Pending++
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(runErr, migration.ErrLocked):
+		status.Phase = PhaseLocked
+		status.Message = runErr.Error()
+	case runErr != nil:
+		return failed(runErr)
+	case err != nil:
+		return failed(err)
+	default:
+		status.Phase = PhaseApplied
+	}
+	return status
+}
+
+// loadConfigMap reads the migration files of a ConfigMap
+func loadConfigMap(configMap *ConfigMap) ([]migration.Migration, error) {
+	files := fstest.MapFS{}
+	for name, content := range configMap.Data {
+		files[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	var migrations []migration.Migration
+	for name, content := range configMap.BinaryData {
+		if path.Ext(name) != ".zip" {
+			files[name] = &fstest.MapFile{Data: content}
+			continue
+		}
+		archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading bundle %s: %w", name, err)
+		}
+		bundled, err := migration.LoadFS(archive)
+		if err != nil {
+			return nil, fmt.Errorf("error loading bundle %s: %w", name, err)
+		}
+		migrations = append(migrations, bundled...)
+	}
+
+	loaded, err := migration.LoadFS(files)
+	if err != nil {
+		return nil, err
+	}
+	migrations = append(migrations, loaded...)
+	if len(migrations) == 0 {
+		return nil, errors.New("ConfigMap contains no migrations")
+	}
+	return migrations, nil
+}
+
+// statusEqual compares statuses ignoring the transition time
+func statusEqual(a, b MigrationStatus) bool {
+	a.LastTransitionTime, b.LastTransitionTime = nil, nil
+	return a == b
+}
+
+// newManager connects to the cluster with the credentials of its Secret
+func newManager(cluster ClusterSpec, credentials map[string][]byte, opts ...migration.Option) (*migration.MigrationManager, error) {
+	mm, err := migration.NewMigrationManagerFromConfig(migration.ClientConfig{
+		Addresses:   cluster.Addresses,
+		CloudID:     cluster.CloudID,
+		Username:    string(credentials["username"]),
+		Password:    string(credentials["password"]),
+		APIKey:      string(credentials["api_key"]),
+		BearerToken: string(credentials["bearer_token"]),
+		CACert:      credentials["ca.crt"],
+		OpenSearch:  cluster.OpenSearch,
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to cluster: %w", err)
+	}
+	return mm, nil
+}
@@ -0,0 +1,127 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+const articlesMapping = `description: Add articles mapping
+action: put_mapping
+index: articles
+body:
+  properties:
+    title:
+      type: text
+`
+
+// okTransport answers every Elasticsearch request with 200 {}
+type okTransport struct{}
+
+func (okTransport) Perform(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}, "X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+	}, nil
+}
+
+// fakeKube serves the Kubernetes API requests of the controller
+type fakeKube struct {
+	mu        sync.Mutex
+	resources []ElasticsearchMigration
+	configMap ConfigMap
+	patches   []MigrationStatus
+}
+
+func (f *fakeKube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, `{"message": "unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == resourcePath(""):
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": f.resources})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/search/configmaps/articles-migrations":
+		json.NewEncoder(w).Encode(f.configMap)
+	case r.Method == http.MethodPatch && r.URL.Path == resourcePath("search")+"/articles/status":
+		var patch struct {
+			Status MigrationStatus `json:"status"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &patch)
+		f.patches = append(f.patches, patch.Status)
+		f.resources[0].Status = patch.Status
+		w.Write([]byte(`{}`))
+	default:
+		http.Error(w, `{"message": "not found"}`, http.StatusNotFound)
+	}
+}
+
+func TestController(t *testing.T) {
+	kube := &fakeKube{
+		resources: []ElasticsearchMigration{{
+			Metadata: Metadata{Name: "articles", Namespace: "search", Generation: 3},
+			Spec: MigrationSpec{
+				Cluster:   ClusterSpec{Addresses: []string{"http://search:9200"}},
+				ConfigMap: "articles-migrations",
+			},
+		}},
+		configMap: ConfigMap{Data: map[string]string{"0001_articles.yaml": articlesMapping}},
+	}
+	api := httptest.NewServer(kube)
+	defer api.Close()
+
+	store := migration.NewMemoryStore()
+	controller := NewController(&KubeClient{BaseURL: api.URL, Token: "secret"}, "")
+	var namespace string
+	controller.newManager = func(cluster ClusterSpec, credentials map[string][]byte, opts ...migration.Option) (*migration.MigrationManager, error) {
+		mm := migration.NewMigrationManager(migration.ClientFromTransport(okTransport{}), append(opts, migration.WithStore(store))...)
+		namespace = mm.Namespace
+		return mm, nil
+	}
+
+	if err := controller.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if namespace != "articles" {
+		t.Errorf("Expected the resource name as namespace, got %q", namespace)
+	}
+	if len(kube.patches) != 1 {
+		t.Fatalf("Expected one status update, got %d", len(kube.patches))
+	}
+	status := kube.patches[0]
+	if status.Phase != PhaseApplied || status.Applied != 1 || status.Pending != 0 || status.ObservedGeneration != 3 || status.LastRunID == "" {
+		t.Errorf("Expected the migration applied, got %+v", status)
+	}
+
+	t.Run("Test Unchanged Status Is Not Written", func(t *testing.T) {
+		if err := controller.Reconcile(context.Background()); err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+		if len(kube.patches) != 1 {
+			t.Errorf("Expected no status update, got %+v", kube.patches[1:])
+		}
+	})
+
+	t.Run("Test Invalid Migrations Are Reported", func(t *testing.T) {
+		kube.configMap = ConfigMap{Data: map[string]string{"0002_broken.yaml": "action: create_index"}}
+		if err := controller.Reconcile(context.Background()); err != nil {
+			t.Fatalf("Failed to reconcile: %v", err)
+		}
+		status := kube.patches[len(kube.patches)-1]
+		if status.Phase != PhaseFailed || status.Message == "" || status.LastRunID == "" {
+			t.Errorf("Expected a failed phase keeping the last run, got %+v", status)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ForceMergeConfig configures a ForceMerge migration
+type ForceMergeConfig struct {
+	// Index is an index, or a comma-separated list or pattern of indices
+	Index string
+	// MaxNumSegments is the number of segments each shard is merged into, 1
+	// by default
+	MaxNumSegments int
+	// OnlyExpungeDeletes only merges away deleted documents
+	OnlyExpungeDeletes bool
+	// BlockWrites makes the indices read-only before merging, e.g. after a
+	// reindex into an archive index. Otherwise they must already be
+	// read-only or rolled over.
+	BlockWrites bool
+}
+
+// ForceMerge returns a migration merging the segments of indices that are
+// no longer written to, such as rolled-over or freshly reindexed indices,
+// so searches touch fewer segments. Merging an index that still receives
+// writes produces large segments that are never merged again, so the
+// migration fails unless each index is read-only, is rolled over (not the
+// write index of its alias), or BlockWrites is set. The merge runs as a task
+// that is polled until done.
+func ForceMerge(description string, cfg ForceMergeConfig) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		if cfg.BlockWrites {
+			if err := putSettings(client, cfg.Index, map[string]interface{}{"index.blocks.write": true}); err != nil {
+				return err
+			}
+		} else if err := checkFinished(client, cfg.Index); err != nil {
+			return err
+		}
+		return RunForceMerge(context.Background(), client, cfg)
+	})
+	m.validate = func() error {
+		if cfg.Index == "" {
+			return fmt.Errorf("force merge requires an index")
+		}
+		if cfg.MaxNumSegments < 0 || (cfg.MaxNumSegments > 0 && cfg.OnlyExpungeDeletes) {
+			return fmt.Errorf("force merge of %s takes either a positive number of segments or only expunges deletes", cfg.Index)
+		}
+		return nil
+	}
+	return m
+}
+
+// RunForceMerge submits a force merge task and waits for it to complete,
+// without checking that the indices are no longer written to. The task is
+// cancelled when ctx is.
+func RunForceMerge(ctx context.Context, client *elasticsearch.Client, cfg ForceMergeConfig) error {
+	opts := []func(*esapi.IndicesForcemergeRequest){
+		client.Indices.Forcemerge.WithContext(ctx),
+		client.Indices.Forcemerge.WithIndex(cfg.Index),
+		client.Indices.Forcemerge.WithWaitForCompletion(false),
+	}
+	if cfg.OnlyExpungeDeletes {
+		opts = append(opts, client.Indices.Forcemerge.WithOnlyExpungeDeletes(true))
+	} else {
+		segments := cfg.MaxNumSegments
+		if segments == 0 {
+			segments = 1
+		}
+		opts = append(opts, client.Indices.Forcemerge.WithMaxNumSegments(segments))
+	}
+
+	clientLogf(client)("Force merging %s", cfg.Index)
+	res, err := client.Indices.Forcemerge(opts...)
+	if err != nil {
+		return fmt.Errorf("error force merging %s: %w", cfg.Index, err)
+	}
+	task, err := submittedTask(res)
+	if err != nil {
+		return fmt.Errorf("error force merging %s: %w", cfg.Index, err)
+	}
+	if _, err := waitForTask(ctx, client, task); err != nil {
+		return fmt.Errorf("error force merging %s: %w", cfg.Index, err)
+	}
+	return nil
+}
+
+// checkFinished fails unless every index matching index is read-only or
+// rolled over
+func checkFinished(client *elasticsearch.Client, index string) error {
+	res, err := client.Indices.GetSettings(
+		client.Indices.GetSettings.WithIndex(index),
+		client.Indices.GetSettings.WithName("index.blocks.*"),
+		client.Indices.GetSettings.WithFlatSettings(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error reading settings of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error reading settings of %s: %s", index, res.String())
+	}
+	var settings map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&settings); err != nil {
+		return fmt.Errorf("error parsing settings of %s: %w", index, err)
+	}
+
+	aliases, err := writeAliases(client, index)
+	if err != nil {
+		return err
+	}
+
+	var writable []string
+	for name, definition := range settings {
+		blocked := false
+		for _, block := range []string{"index.blocks.write", "index.blocks.read_only", "index.blocks.read_only_allow_delete"} {
+			if fmt.Sprint(definition.Settings[block]) == "true" {
+				blocked = true
+			}
+		}
+		if rolledOver, ok := aliases[name]; !blocked && !(ok && rolledOver) {
+			writable = append(writable, name)
+		}
+	}
+	if len(writable) > 0 {
+		sort.Strings(writable)
+		return fmt.Errorf("refusing to force merge %s: still writable; block writes, roll it over or set BlockWrites", strings.Join(writable, ", "))
+	}
+	return nil
+}
+
+// writeAliases reports, for each index matching index that has aliases,
+// whether it was rolled over: every alias marks it explicitly as not the
+// write index, as rollovers leave it
+func writeAliases(client *elasticsearch.Client, index string) (map[string]bool, error) {
+	res, err := client.Indices.GetAlias(client.Indices.GetAlias.WithIndex(index))
+	if err != nil {
+		return nil, fmt.Errorf("error reading aliases of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return map[string]bool{}, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading aliases of %s: %s", index, res.String())
+	}
+	var result map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex *bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing aliases of %s: %w", index, err)
+	}
+
+	rolledOver := make(map[string]bool, len(result))
+	for name, definition := range result {
+		if len(definition.Aliases) == 0 {
+			continue
+		}
+		finished := true
+		for _, alias := range definition.Aliases {
+			if alias.IsWriteIndex == nil || *alias.IsWriteIndex {
+				finished = false
+			}
+		}
+		rolledOver[name] = finished
+	}
+	return rolledOver, nil
+}
+
+// RefreshIndex returns a migration refreshing indices, so documents written
+// by earlier migrations, e.g. a backfill, are visible to searches and
+// verifications right away
+func RefreshIndex(index string) Migration {
+	m := NewMigration(fmt.Sprintf("Refresh %s", index), func(client *elasticsearch.Client) error {
+		res, err := client.Indices.Refresh(client.Indices.Refresh.WithIndex(index))
+		if err != nil {
+			return fmt.Errorf("error refreshing %s: %w", index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error refreshing %s: %s", index, res.String())
+		}
+		return nil
+	})
+	m.validate = func() error {
+		if index == "" {
+			return fmt.Errorf("refresh requires an index")
+		}
+		return nil
+	}
+	return m
+}
+
+// Caches ClearCache can clear
+const (
+	CacheQuery     = "query"
+	CacheRequest   = "request"
+	CacheFielddata = "fielddata"
+)
+
+// ClearCache returns a migration clearing caches of indices, all of them
+// when none are given, e.g. fielddata built from a field whose mapping a
+// reindex changed
+func ClearCache(index string, caches ...string) Migration {
+	description := fmt.Sprintf("Clear caches of %s", index)
+	if len(caches) > 0 {
+		description = fmt.Sprintf("Clear %s caches of %s", strings.Join(caches, ", "), index)
+	}
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		opts := []func(*esapi.IndicesClearCacheRequest){client.Indices.ClearCache.WithIndex(index)}
+		for _, cache := range caches {
+			switch cache {
+			case CacheQuery:
+				opts = append(opts, client.Indices.ClearCache.WithQuery(true))
+			case CacheRequest:
+				opts = append(opts, client.Indices.ClearCache.WithRequest(true))
+			case CacheFielddata:
+				opts = append(opts, client.Indices.ClearCache.WithFielddata(true))
+			}
+		}
+		res, err := client.Indices.ClearCache(opts...)
+		if err != nil {
+			return fmt.Errorf("error clearing caches of %s: %w", index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error clearing caches of %s: %s", index, res.String())
+		}
+		return nil
+	})
+	m.validate = func() error {
+		if index == "" {
+			return fmt.Errorf("clearing caches requires an index")
+		}
+		for _, cache := range caches {
+			if cache != CacheQuery && cache != CacheRequest && cache != CacheFielddata {
+				return fmt.Errorf("unknown cache %q of %s", cache, index)
+			}
+		}
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,66 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForceMerge(t *testing.T) {
+	newTransport := func(settings, aliases string) *routeTransport {
+		return &routeTransport{routes: map[string]string{
+			"GET /logs-*/_settings/index.blocks.*": settings,
+			"GET /logs-*/_alias":                   aliases,
+			"POST /logs-*/_forcemerge":             `{"task": "node:1"}`,
+			"GET /_tasks/node:1":                   `{"completed": true}`,
+		}}
+	}
+
+	t.Run("Test Rolled Over And Read-Only Indices Are Merged", func(t *testing.T) {
+		transport := newTransport(
+			`{"logs-000001": {"settings": {}}, "logs-000002": {"settings": {"index.blocks.write": "true"}}}`,
+			`{"logs-000001": {"aliases": {"logs": {"is_write_index": false}}}, "logs-000002": {"aliases": {}}}`,
+		)
+		m := ForceMerge("Merge old logs", ForceMergeConfig{Index: "logs-*"})
+		if err := m.UpFunc(ClientFromTransport(transport)); err != nil {
+			t.Fatalf("Failed to force merge: %v", err)
+		}
+		if !transport.sent("POST /logs-*/_forcemerge") {
+			t.Errorf("Expected a force merge, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Writable Indices Are Refused", func(t *testing.T) {
+		transport := newTransport(
+			`{"logs-000001": {"settings": {}}, "logs-000002": {"settings": {}}}`,
+			`{"logs-000001": {"aliases": {"logs": {"is_write_index": false}}}, "logs-000002": {"aliases": {"logs": {"is_write_index": true}}}}`,
+		)
+		m := ForceMerge("Merge logs", ForceMergeConfig{Index: "logs-*"})
+		err := m.UpFunc(ClientFromTransport(transport))
+		if err == nil || !strings.Contains(err.Error(), "logs-000002: still writable") {
+			t.Fatalf("Expected the write index refused, got %v", err)
+		}
+		if transport.sent("POST /logs-*/_forcemerge") {
+			t.Error("Expected no force merge")
+		}
+	})
+
+	t.Run("Test Block Writes Skips The Check", func(t *testing.T) {
+		transport := newTransport(`{}`, `{}`)
+		m := ForceMerge("Merge archive", ForceMergeConfig{Index: "logs-*", BlockWrites: true})
+		if err := m.UpFunc(ClientFromTransport(transport)); err != nil {
+			t.Fatalf("Failed to force merge: %v", err)
+		}
+		if !transport.sent("PUT /logs-*/_settings") || transport.sent("GET /logs-*/_alias") {
+			t.Errorf("Expected writes blocked without checks, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Invalid Options Fail Validation", func(t *testing.T) {
+		if err := ForceMerge("Merge", ForceMergeConfig{Index: "logs", MaxNumSegments: 2, OnlyExpungeDeletes: true}).Validate(); err == nil {
+			t.Error("Expected segments and expunge deletes refused together")
+		}
+		if err := ClearCache("logs", "everything").Validate(); err == nil {
+			t.Error("Expected an unknown cache refused")
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Option configures a MigrationManager
+type Option func(*MigrationManager)
+
+// Logger receives the progress messages of a MigrationManager. *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithFilePath tracks applied migrations in a JSON text file instead of an
+// Elasticsearch index
+func WithFilePath(path string) Option {
+	return func(mm *MigrationManager) {
+		mm.FilePath = path
+	}
+}
+
+// WithStore tracks applied migrations in a custom VersionStore
+func WithStore(store VersionStore) Option {
+	return func(mm *MigrationManager) {
+		mm.Store = store
+	}
+}
+
+// WithNamespace scopes the tracking index to a namespace
+func WithNamespace(namespace string) Option {
+	return func(mm *MigrationManager) {
+		mm.Namespace = namespace
+	}
+}
+
+// WithTrackingIndex sets the name of the Elasticsearch index holding migration
+// records. It takes precedence over the namespace.
+func WithTrackingIndex(index string) Option {
+	return func(mm *MigrationManager) {
+		mm.trackingIndex = index
+	}
+}
+
+// WithRequestTimeout bounds each request the manager makes to track migrations
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(mm *MigrationManager) {
+		mm.requestTimeout = timeout
+	}
+}
+
+// WithRefreshPolicy sets the refresh parameter ("true", "false" or "wait_for")
+// used when writing migration records
+func WithRefreshPolicy(policy string) Option {
+	return func(mm *MigrationManager) {
+		mm.refreshPolicy = policy
+	}
+}
+
+// WithLogger sets where progress messages are written. The default writes to
+// standard output.
+func WithLogger(logger Logger) Option {
+	return func(mm *MigrationManager) {
+		mm.Logger = logger
+	}
+}
+
+// WithLock controls whether RunMigrations holds the store lock while it runs.
+// Locking is enabled by default.
+func WithLock(enabled bool) Option {
+	return func(mm *MigrationManager) {
+		mm.disableLock = !enabled
+	}
+}
+
+// WithSnapshotRepository snapshots the cluster into repository before
+// destructive migrations
+func WithSnapshotRepository(repository string) Option {
+	return func(mm *MigrationManager) {
+		mm.SnapshotRepository = repository
+	}
+}
+
+// WithClientPolicy hands migrations a scoped client enforcing policy
+func WithClientPolicy(policy ClientPolicy) Option {
+	return func(mm *MigrationManager) {
+		mm.ClientPolicy = &policy
+	}
+}
+
+// WithTypedClient sets the client handed to typed migrations
+func WithTypedClient(client *elasticsearch.TypedClient) Option {
+	return func(mm *MigrationManager) {
+		mm.TypedClient = client
+	}
+}
+
+var defaultLogger = log.New(os.Stdout, "", 0)
+
+func (mm *MigrationManager) logf(format string, v ...interface{}) {
+	if mm.Logger == nil {
+		defaultLogger.Printf(format, v...)
+		return
+	}
+	mm.Logger.Printf(format, v...)
+}
+
+// loggingTransport carries the logger of the manager applying a migration,
+// and the context the migration runs under, to the helpers the migration
+// calls with its client
+type loggingTransport struct {
+	next esapi.Transport
+	logf func(format string, v ...interface{})
+	ctx  context.Context
+}
+
+func (t *loggingTransport) Perform(req *http.Request) (*http.Response, error) {
+	return t.next.Perform(req)
+}
+
+// clientLogf returns the logger of the manager that handed out client, or
+// standard output for clients built elsewhere
+func clientLogf(client *elasticsearch.Client) func(format string, v ...interface{}) {
+	if t, ok := client.Transport.(*loggingTransport); ok {
+		return t.logf
+	}
+	return defaultLogger.Printf
+}
+
+// clientContext returns the context of the migration that was handed client,
+// done when the migration times out or the run is cancelled, or a background
+// context for clients built elsewhere
+func clientContext(client *elasticsearch.Client) context.Context {
+	if t, ok := client.Transport.(*loggingTransport); ok && t.ctx != nil {
+		return t.ctx
+	}
+	return context.Background()
+}
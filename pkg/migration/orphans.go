@@ -0,0 +1,95 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOrphanedRecords is returned by runs with OrphanFail when the store has
+// records of migrations that are not registered
+var ErrOrphanedRecords = errors.New("applied migrations are not registered")
+
+// OrphanPolicy is what a run does about records of migrations that are not
+// registered, most often because an older release was deployed
+type OrphanPolicy string
+
+const (
+	// OrphanWarn logs orphaned records and runs. It is the default.
+	OrphanWarn OrphanPolicy = "warn"
+	// OrphanFail refuses to run while there are orphaned records
+	OrphanFail OrphanPolicy = "fail"
+	// OrphanIgnore runs without checking
+	OrphanIgnore OrphanPolicy = "ignore"
+)
+
+// WithOrphanPolicy sets what runs do about records in the store of
+// migrations that are not registered. OrphanFail catches deploys of binaries
+// older than the schema.
+func WithOrphanPolicy(policy OrphanPolicy) Option {
+	return func(mm *MigrationManager) {
+		mm.orphanPolicy = policy
+	}
+}
+
+// Orphans returns the records of applied migrations that are not registered.
+// Records of migrations squashed into a registered baseline are not orphans.
+func (mm *MigrationManager) Orphans() ([]MigrationRecord, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	registered, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	return orphans(records, registered), nil
+}
+
+func orphans(records []MigrationRecord, registered []Migration) []MigrationRecord {
+	known := make(map[string]bool, len(registered))
+	for _, migration := range registered {
+		known[migration.Version()] = true
+		for _, version := range migration.squashes {
+			known[version] = true
+		}
+	}
+	var orphaned []MigrationRecord
+	for _, record := range records {
+		if !known[record.Version] {
+			orphaned = append(orphaned, record)
+		}
+	}
+	return orphaned
+}
+
+// checkOrphans applies the orphan policy to the records of a run. Renamed
+// functions are reported as such rather than as orphans.
+func (mm *MigrationManager) checkOrphans(records []MigrationRecord, registered []Migration) error {
+	renamed := make(map[string]bool)
+	for _, rename := range detectRenames(records, registered) {
+		mm.logf("Warning: %s", rename)
+		renamed[rename.Record.Version] = true
+	}
+
+	if mm.orphanPolicy == OrphanIgnore {
+		return nil
+	}
+	orphaned := orphans(records, registered)
+	if len(orphaned) == 0 {
+		return nil
+	}
+	if mm.orphanPolicy == OrphanFail {
+		versions := make([]string, len(orphaned))
+		for i, record := range orphaned {
+			versions[i] = record.Version
+		}
+		return fmt.Errorf("%w: %s; deploy the release that registers them or remove their records", ErrOrphanedRecords, strings.Join(versions, ", "))
+	}
+	for _, record := range orphaned {
+		if !renamed[record.Version] {
+			mm.logf("Warning: applied migration %s (%s) is not registered; was an older release deployed?", record.Version, record.Description)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package migration
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestOrphans(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	create := NewMigration("Create articles index", noop)
+	newStore := func() *MemoryStore {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: create.Version(), Description: create.Description})
+		store.Record(MigrationRecord{Version: "9f8e7d6c", Description: "Add tags to articles"})
+		store.Record(MigrationRecord{Version: "1a2b3c4d", Description: "Squashed migration"})
+		return store
+	}
+	baseline := NewMigration("Articles baseline", noop).Squashes("1a2b3c4d")
+
+	t.Run("Test Orphans Are Listed", func(t *testing.T) {
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(newStore()), WithMigrations(create, baseline))
+		orphaned, err := mm.Orphans()
+		if err != nil {
+			t.Fatalf("Failed to list orphans: %v", err)
+		}
+		if len(orphaned) != 1 || orphaned[0].Version != "9f8e7d6c" {
+			t.Errorf("Expected only 9f8e7d6c orphaned, got %+v", orphaned)
+		}
+	})
+
+	t.Run("Test Orphans Are Warned About", func(t *testing.T) {
+		var output bytes.Buffer
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(newStore()), WithLogger(log.New(&output, "", 0)), WithMigrations(create))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !strings.Contains(output.String(), "Warning: applied migration 9f8e7d6c (Add tags to articles) is not registered") {
+			t.Errorf("Expected a warning, got %q", output.String())
+		}
+	})
+
+	t.Run("Test Fail Policy Stops The Run", func(t *testing.T) {
+		store := newStore()
+		tags := NewMigration("Add author to articles", noop)
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store), WithOrphanPolicy(OrphanFail), WithMigrations(create, baseline, tags))
+		err := mm.RunMigrations()
+		if !errors.Is(err, ErrOrphanedRecords) || !strings.Contains(err.Error(), "9f8e7d6c") {
+			t.Fatalf("Expected ErrOrphanedRecords for 9f8e7d6c, got %v", err)
+		}
+		applied, _ := mm.GetAppliedMigrations()
+		if applied[tags.Version()] {
+			t.Errorf("Expected nothing applied")
+		}
+	})
+
+	t.Run("Test Ignore Policy Runs Silently", func(t *testing.T) {
+		var output bytes.Buffer
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(newStore()), WithLogger(log.New(&output, "", 0)), WithOrphanPolicy(OrphanIgnore), WithMigrations(create))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if strings.Contains(output.String(), "Warning") {
+			t.Errorf("Expected no warning, got %q", output.String())
+		}
+	})
+}
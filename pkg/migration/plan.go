@@ -0,0 +1,264 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration/schema"
+)
+
+// desiredMapping is a mapping a migration declares for an index once applied
+type desiredMapping struct {
+	index   string
+	mapping schema.Mapping
+	err     error
+	// exact is set when mapping is the complete end state rather than fields
+	// merged into the existing mapping
+	exact bool
+	// raw is the mapping as declared, sent to the cluster to validate it
+	raw string
+	// settings are the settings an index is created with, for the analyzers
+	// the mapping refers to
+	settings map[string]interface{}
+}
+
+// WithDesiredMapping declares the mapping the migration leaves index with, so
+// Plan can show the field changes it makes. Like a put mapping request, the
+// declared fields are merged into the existing mapping.
+func (m Migration) WithDesiredMapping(index string, mapping string) Migration {
+	parsed, err := schema.Parse([]byte(mapping))
+	m.desired = append(append([]desiredMapping{}, m.desired...), desiredMapping{
+		index:   index,
+		mapping: parsed,
+		err:     err,
+		raw:     mapping,
+	})
+	return m
+}
+
+// PutMapping returns a migration that adds the fields in mapping to index,
+// creating the index when it does not exist. mapping is a mapping body such
+// as {"properties": {...}}.
+func PutMapping(description, index, mapping string) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		exists, err := client.Indices.Exists([]string{index})
+		if err != nil {
+			return fmt.Errorf("error checking index %s: %w", index, err)
+		}
+		exists.Body.Close()
+
+		if exists.StatusCode == 404 {
+			body := fmt.Sprintf(`{"mappings": %s}`, mapping)
+			res, err := client.Indices.Create(index, client.Indices.Create.WithBody(strings.NewReader(body)))
+			if err != nil {
+				return fmt.Errorf("error creating index %s: %w", index, err)
+			}
+			defer res.Body.Close()
+			if res.IsError() {
+				return fmt.Errorf("error creating index %s: %s", index, res.String())
+			}
+			return nil
+		}
+
+		res, err := client.Indices.PutMapping([]string{index}, strings.NewReader(mapping))
+		if err != nil {
+			return fmt.Errorf("error updating mapping of %s: %w", index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error updating mapping of %s: %s", index, res.String())
+		}
+		return nil
+	}).WithDesiredMapping(index, mapping)
+}
+
+// PlanStep is a pending migration and the mapping changes it declares
+type PlanStep struct {
+	Version     string
+	Description string
+	Destructive bool
+	// Deferred is set when the schedule of the migration does not allow it to
+	// run now. Next is the earliest time it may run.
+	Deferred bool
+	Next     time.Time
+	// WaitingForWindow is set on deferred migrations waiting for a window
+	WaitingForWindow bool
+	// Diffs is empty for migrations that do not declare a desired mapping
+	Diffs []schema.Diff
+	// Requests are the requests a declarative migration will send
+	Requests []PlannedRequest
+}
+
+// PlannedRequest is a request a pending migration will send
+type PlannedRequest struct {
+	Method string
+	// Path includes the query string
+	Path string
+	Body json.RawMessage
+}
+
+func (r PlannedRequest) String() string {
+	line := r.Method + " " + r.Path + "\n"
+	if len(r.Body) == 0 {
+		return line
+	}
+	var body bytes.Buffer
+	if err := json.Indent(&body, r.Body, "", "  "); err != nil {
+		return line + string(r.Body) + "\n"
+	}
+	return line + body.String() + "\n"
+}
+
+// PlannedRequests returns the requests a declarative migration will send,
+// given whether indices exist. It returns nil for migrations written in Go.
+func (m Migration) PlannedRequests(exists func(index string) (bool, error)) ([]PlannedRequest, error) {
+	if m.plan == nil {
+		return nil, nil
+	}
+	return m.plan(exists)
+}
+
+// Plan lists what a migration run would change
+type Plan struct {
+	Steps []PlanStep
+}
+
+// Plan returns the pending migrations in the order RunMigrations applies them,
+// with the field changes of declared mappings computed against the live
+// cluster. Each step is compared against the state left by the previous
+// steps. Nothing is written to the cluster.
+func (mm *MigrationManager) Plan() (*Plan, error) {
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	registered, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	migrations := append([]Migration{}, registered...)
+	if err := sortMigrations(migrations); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	projected := map[string]schema.Mapping{}
+	// exists reports whether index exists once the previous steps are applied
+	exists := func(index string) (bool, error) {
+		if _, ok := projected[index]; ok {
+			return true, nil
+		}
+		return IndexExists(mm.client(), index)
+	}
+
+	for _, migration := range migrations {
+		if applied[migration.Version()] {
+			continue
+		}
+		if err := migration.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid migration %s: %w", migration.Version(), err)
+		}
+
+		step := PlanStep{
+			Version:     migration.Version(),
+			Description: migration.Description,
+			Destructive: migration.IsDestructive(),
+		}
+		if schedule, now := mm.scheduleOf(migration), mm.clock(); !schedule.eligible(now) {
+			step.Deferred = true
+			step.Next, _ = schedule.next(now)
+			step.WaitingForWindow = schedule.waitsForWindow(now)
+		}
+
+		if migration.plan != nil {
+			if step.Requests, err = migration.plan(exists); err != nil {
+				return nil, fmt.Errorf("error planning migration %s: %w", migration.Version(), err)
+			}
+		}
+
+		for _, desired := range migration.desired {
+			current, ok := projected[desired.index]
+			if !ok {
+				current, err = schema.Fetch(mm.client(), desired.index)
+				if errors.Is(err, schema.ErrIndexNotFound) {
+					current, err = schema.Mapping{}, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			next := current.Merge(desired.mapping)
+			if desired.exact {
+				next = desired.mapping
+			}
+			diff := schema.Compare(current, next)
+			diff.Index = desired.index
+			step.Diffs = append(step.Diffs, diff)
+			projected[desired.index] = next
+		}
+
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	return plan, nil
+}
+
+// Empty reports whether there are no pending migrations
+func (p *Plan) Empty() bool {
+	return len(p.Steps) == 0
+}
+
+func (p *Plan) String() string {
+	if p.Empty() {
+		return "No pending migrations.\n"
+	}
+
+	var b strings.Builder
+	counts := map[schema.ChangeKind]int{}
+
+	for _, step := range p.Steps {
+		fmt.Fprintf(&b, "Migration %s: %s", step.Version, step.Description)
+		if step.Destructive {
+			b.WriteString(" [destructive]")
+		}
+		if step.Deferred {
+			switch {
+			case step.Next.IsZero():
+				b.WriteString(" [deferred]")
+			case step.WaitingForWindow:
+				fmt.Fprintf(&b, " [waiting for window until %s]", step.Next.Format(time.RFC3339))
+			default:
+				fmt.Fprintf(&b, " [deferred until %s]", step.Next.Format(time.RFC3339))
+			}
+		}
+		b.WriteString("\n")
+
+		for _, request := range step.Requests {
+			for _, line := range strings.Split(strings.TrimSuffix(request.String(), "\n"), "\n") {
+				fmt.Fprintf(&b, "  %s\n", line)
+			}
+		}
+		if len(step.Diffs) == 0 && len(step.Requests) == 0 {
+			b.WriteString("  (changes not declared)\n")
+		}
+		for _, diff := range step.Diffs {
+			for _, line := range strings.Split(strings.TrimSuffix(diff.String(), "\n"), "\n") {
+				fmt.Fprintf(&b, "  %s\n", line)
+			}
+			for _, kind := range []schema.ChangeKind{schema.Added, schema.Changed, schema.Removed} {
+				counts[kind] += diff.Count(kind)
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "\nPlan: %d pending migrations, %d fields to add, %d to change, %d to remove.\n",
+		len(p.Steps), counts[schema.Added], counts[schema.Changed], counts[schema.Removed])
+	return b.String()
+}
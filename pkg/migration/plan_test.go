@@ -0,0 +1,53 @@
+package migration
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type mappingTransport map[string]string
+
+func (m mappingTransport) Perform(req *http.Request) (*http.Response, error) {
+	status, body := 404, `{"error": {"type": "index_not_found_exception"}}`
+	if mapping, ok := m[req.URL.Path]; ok {
+		status, body = 200, mapping
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestPlan(t *testing.T) {
+	client := ClientFromTransport(mappingTransport{
+		"/articles/_mapping": `{"articles": {"mappings": {"properties": {"title": {"type": "text"}}}}}`,
+	})
+	mm := NewMigrationManager(client, WithStore(NewMemoryStore()))
+
+	mm.Register(PutMapping("Add article tags", "articles", `{"properties": {"tags": {"type": "keyword"}}}`))
+	mm.Register(PutMapping("Create authors", "authors", `{"properties": {"name": {"type": "keyword"}}}`))
+
+	plan, err := mm.Plan()
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+
+	if len(plan.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(plan.Steps))
+	}
+	for _, step := range plan.Steps {
+		if len(step.Diffs) != 1 || len(step.Diffs[0].Changes) != 1 {
+			t.Errorf("Expected one added field for %s, got %+v", step.Description, step.Diffs)
+		}
+	}
+
+	output := plan.String()
+	for _, line := range []string{"+ tags (keyword)", "+ name (keyword)", "2 fields to add"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected plan to contain %q, got:\n%s", line, output)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Preflight configures the checks run against the cluster before pending
+// migrations are applied
+type Preflight struct {
+	// MinHealth is the lowest acceptable cluster health, "yellow" or "green"
+	// (default "yellow")
+	MinHealth string
+	// MinFreeDiskPercent is the free disk every data node must have
+	MinFreeDiskPercent float64
+	// AllowRelocating permits shards relocating while migrations run
+	AllowRelocating bool
+	// MinVersion and MaxVersion bound the cluster version, inclusive at the
+	// precision given: MaxVersion "8" accepts every 8.x release
+	MinVersion string
+	MaxVersion string
+}
+
+// PreflightError lists the reasons the cluster is not ready for migrations
+type PreflightError struct {
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	return "cluster is not ready for migrations: " + strings.Join(e.Problems, "; ")
+}
+
+// WithPreflight checks cluster health, disk, relocations and version before
+// RunMigrations applies any pending migration
+func WithPreflight(preflight Preflight) Option {
+	return func(mm *MigrationManager) {
+		mm.preflight = &preflight
+	}
+}
+
+var healthRank = map[string]int{"red": 0, "yellow": 1, "green": 2}
+
+// CheckCluster runs the preflight checks and returns a *PreflightError
+// describing every failed check
+func CheckCluster(client *elasticsearch.Client, preflight Preflight) error {
+	var problems []string
+
+	minHealth := preflight.MinHealth
+	if minHealth == "" {
+		minHealth = "yellow"
+	}
+	if _, ok := healthRank[minHealth]; !ok {
+		return fmt.Errorf("invalid minimum health %q", minHealth)
+	}
+
+	health, err := clusterHealth(client)
+	if err != nil {
+		return err
+	}
+	if healthRank[health.Status] < healthRank[minHealth] {
+		problems = append(problems, fmt.Sprintf("cluster health is %s, %s required", health.Status, minHealth))
+	}
+	if health.RelocatingShards > 0 && !preflight.AllowRelocating {
+		problems = append(problems, fmt.Sprintf("%d shards are relocating", health.RelocatingShards))
+	}
+
+	if preflight.MinFreeDiskPercent > 0 {
+		usage, err := diskUsage(client)
+		if err != nil {
+			return err
+		}
+		for _, node := range usage {
+			if free := 100 - node.usedPercent; free < preflight.MinFreeDiskPercent {
+				problems = append(problems, fmt.Sprintf("node %s has %.0f%% free disk, %.0f%% required", node.name, free, preflight.MinFreeDiskPercent))
+			}
+		}
+	}
+
+	if preflight.MinVersion != "" || preflight.MaxVersion != "" {
+		version, err := ClusterVersion(client)
+		if err != nil {
+			return err
+		}
+		if !versionInRange(version, preflight.MinVersion, preflight.MaxVersion) {
+			problems = append(problems, fmt.Sprintf("cluster version %s is outside %s", version, versionRange(preflight.MinVersion, preflight.MaxVersion)))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &PreflightError{Problems: problems}
+	}
+	return nil
+}
+
+type health struct {
+	Status           string `json:"status"`
+	RelocatingShards int    `json:"relocating_shards"`
+}
+
+func clusterHealth(client *elasticsearch.Client) (*health, error) {
+	res, err := client.Cluster.Health()
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster health: %w", err)
+	}
+	defer res.Body.Close()
+	// a red cluster answers 200; 408 is returned when a wait times out
+	if res.IsError() && res.StatusCode != 408 {
+		return nil, fmt.Errorf("error reading cluster health: %s", res.String())
+	}
+
+	var h health
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		return nil, fmt.Errorf("error parsing cluster health: %w", err)
+	}
+	return &h, nil
+}
+
+type nodeDisk struct {
+	name        string
+	usedPercent float64
+}
+
+func diskUsage(client *elasticsearch.Client) ([]nodeDisk, error) {
+	res, err := client.Cat.Allocation(
+		client.Cat.Allocation.WithFormat("json"),
+		client.Cat.Allocation.WithH("node", "disk.percent"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading disk allocation: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading disk allocation: %s", res.String())
+	}
+
+	var rows []struct {
+		Node        string  `json:"node"`
+		DiskPercent *string `json:"disk.percent"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("error parsing disk allocation: %w", err)
+	}
+
+	var usage []nodeDisk
+	for _, row := range rows {
+		// unassigned shards are reported as a row without disk figures
+		if row.DiskPercent == nil || row.Node == "UNASSIGNED" {
+			continue
+		}
+		used, err := strconv.ParseFloat(*row.DiskPercent, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing disk usage of %s: %w", row.Node, err)
+		}
+		usage = append(usage, nodeDisk{name: row.Node, usedPercent: used})
+	}
+	return usage, nil
+}
+
+// ClusterVersion returns the version number reported by the cluster
+func ClusterVersion(client *elasticsearch.Client) (string, error) {
+	res, err := client.Info()
+	if err != nil {
+		return "", fmt.Errorf("error reading cluster version: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("error reading cluster version: %s", res.String())
+	}
+
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("error parsing cluster version: %w", err)
+	}
+	return info.Version.Number, nil
+}
+
+// compareVersions compares the dotted numeric versions a and b over the
+// components both specify, so "8.12.1" equals "8" and "8.12"
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.SplitN(a, "-", 2)[0], ".")
+	bs := strings.Split(strings.SplitN(b, "-", 2)[0], ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionInRange(version, min, max string) bool {
+	if min != "" && compareVersions(version, min) < 0 {
+		return false
+	}
+	if max != "" && compareVersions(version, max) > 0 {
+		return false
+	}
+	return true
+}
+
+func versionRange(min, max string) string {
+	switch {
+	case min != "" && max != "":
+		return min + " to " + max
+	case min != "":
+		return min + " or later"
+	default:
+		return max + " or earlier"
+	}
+}
@@ -0,0 +1,75 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestPreflight(t *testing.T) {
+	cluster := func(status string, relocating int) *routeTransport {
+		return &routeTransport{routes: map[string]string{
+			"GET /":                `{"version": {"number": "8.12.1"}}`,
+			"GET /_cluster/health": fmt.Sprintf(`{"status": %q, "relocating_shards": %d}`, status, relocating),
+			"GET /_cat/allocation": `[{"node": "es-1", "disk.percent": "62"}, {"node": "es-2", "disk.percent": "91"}, {"node": "UNASSIGNED", "disk.percent": null}]`,
+		}}
+	}
+
+	t.Run("Test Healthy Cluster Passes", func(t *testing.T) {
+		err := CheckCluster(ClientFromTransport(cluster("green", 0)), Preflight{MinHealth: "green", MinVersion: "7.17", MaxVersion: "8"})
+		if err != nil {
+			t.Errorf("Expected preflight to pass, got %v", err)
+		}
+	})
+
+	t.Run("Test Every Problem Is Reported", func(t *testing.T) {
+		err := CheckCluster(ClientFromTransport(cluster("yellow", 2)), Preflight{
+			MinHealth:          "green",
+			MinFreeDiskPercent: 15,
+			MaxVersion:         "8.11",
+		})
+
+		var preflightErr *PreflightError
+		if !errors.As(err, &preflightErr) {
+			t.Fatalf("Expected a preflight error, got %v", err)
+		}
+		if len(preflightErr.Problems) != 4 {
+			t.Errorf("Expected health, relocation, disk and version problems, got %v", preflightErr.Problems)
+		}
+	})
+
+	t.Run("Test Failed Preflight Applies Nothing", func(t *testing.T) {
+		ran := false
+		mm := NewMigrationManager(ClientFromTransport(cluster("red", 0)), WithStore(NewMemoryStore()), WithPreflight(Preflight{}))
+		mm.Register(NewMigration("Create articles", func(client *elasticsearch.Client) error {
+			ran = true
+			return nil
+		}))
+
+		var preflightErr *PreflightError
+		if err := mm.RunMigrations(); !errors.As(err, &preflightErr) {
+			t.Fatalf("Expected a preflight error, got %v", err)
+		}
+		if ran {
+			t.Error("Expected no migration to run on a red cluster")
+		}
+	})
+
+	t.Run("Test Version Comparison", func(t *testing.T) {
+		for _, test := range []struct {
+			version, min, max string
+			expected          bool
+		}{
+			{"8.12.1", "8.0", "8", true},
+			{"7.17.9", "8", "", false},
+			{"8.12.1", "", "8.11", false},
+			{"9.0.0-SNAPSHOT", "9", "9.0.0", true},
+		} {
+			if got := versionInRange(test.version, test.min, test.max); got != test.expected {
+				t.Errorf("versionInRange(%q, %q, %q) = %v, expected %v", test.version, test.min, test.max, got, test.expected)
+			}
+		}
+	})
+}
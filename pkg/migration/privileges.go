@@ -0,0 +1,260 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Privileges are Elasticsearch security privileges, e.g. cluster
+// "manage_index_templates" or index "create_index"
+type Privileges struct {
+	Cluster []string
+	// Index maps index names or patterns to their privileges
+	Index map[string][]string
+}
+
+// RequiresPrivileges declares privileges the migration needs beyond those
+// derived from the resources it declares, e.g. for indices a Go migration
+// writes to, so CheckPrivileges can report them missing before running
+func (m Migration) RequiresPrivileges(privileges Privileges) Migration {
+	m.privileges = append(append([]Privileges{}, m.privileges...), privileges)
+	return m
+}
+
+// privileges the resources of a migration need, by kind
+var resourcePrivileges = map[ResourceKind]string{
+	ResourceIndexTemplate: "manage_index_templates",
+	ResourcePipeline:      "manage_pipeline",
+	ResourceStoredScript:  "manage",
+	ResourceRole:          "manage_security",
+}
+
+// trackingPrivileges are the index privileges the tracking index needs
+var trackingPrivileges = []string{"create_index", "read", "write", "view_index_metadata"}
+
+// requiredPrivileges returns the privileges the migration needs: those of
+// the resources it creates and removes, of the mappings it declares, and
+// those declared with RequiresPrivileges
+func (m Migration) requiredPrivileges() Privileges {
+	required := Privileges{Index: map[string][]string{}}
+	for _, resource := range m.Resources() {
+		switch {
+		case resource.Kind == ResourceIndex && resource.Absent:
+			required.Index[resource.Name] = append(required.Index[resource.Name], "delete_index")
+		case resource.Kind == ResourceIndex:
+			required.Index[resource.Name] = append(required.Index[resource.Name], "create_index", "manage", "write")
+		default:
+			required.Cluster = append(required.Cluster, resourcePrivileges[resource.Kind])
+		}
+	}
+	for _, desired := range m.desired {
+		required.Index[desired.index] = append(required.Index[desired.index], "manage")
+	}
+	for _, declared := range m.privileges {
+		required.Cluster = append(required.Cluster, declared.Cluster...)
+		for index, privileges := range declared.Index {
+			required.Index[index] = append(required.Index[index], privileges...)
+		}
+	}
+	return required
+}
+
+// MissingPrivilege is a privilege the credentials lack, with the versions of
+// the migrations needing it; none for the tracking index
+type MissingPrivilege struct {
+	// Index is empty for cluster privileges
+	Index     string
+	Privilege string
+	Versions  []string
+}
+
+func (p MissingPrivilege) String() string {
+	what := "cluster privilege " + p.Privilege
+	if p.Index != "" {
+		what = fmt.Sprintf("index privilege %s on %s", p.Privilege, p.Index)
+	}
+	if len(p.Versions) == 0 {
+		return what + " (tracking index)"
+	}
+	return fmt.Sprintf("%s (%s)", what, strings.Join(p.Versions, ", "))
+}
+
+// MissingPrivilegesError lists the privileges pending migrations need that
+// the credentials lack
+type MissingPrivilegesError struct {
+	User    string
+	Missing []MissingPrivilege
+}
+
+func (e *MissingPrivilegesError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, privilege := range e.Missing {
+		missing[i] = privilege.String()
+	}
+	return fmt.Sprintf("user %s lacks privileges required by pending migrations: %s", e.User, strings.Join(missing, "; "))
+}
+
+// WithPrivilegeCheck makes RunMigrations check the privileges of the
+// credentials, see CheckPrivileges, before applying any pending migration
+func WithPrivilegeCheck() Option {
+	return func(mm *MigrationManager) {
+		mm.checkPrivileges = true
+	}
+}
+
+// CheckPrivileges asks the has privileges API whether the credentials of the
+// client can do what pending migrations need: write the tracking index,
+// create, update and delete the indices they declare, manage the templates,
+// pipelines, scripts and roles they declare, snapshot before destructive
+// migrations, and whatever they declare with RequiresPrivileges. It returns
+// a *MissingPrivilegesError listing what is missing. Clusters without
+// security enabled pass.
+func (mm *MigrationManager) CheckPrivileges() error {
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return err
+	}
+	migrations, err := mm.registered()
+	if err != nil {
+		return err
+	}
+	return mm.checkPendingPrivileges(migrations, applied)
+}
+
+func (mm *MigrationManager) checkPendingPrivileges(migrations []Migration, applied map[string]bool) error {
+	// versions needing each privilege, keyed by index and privilege
+	needed := map[[2]string][]string{}
+	need := func(index, privilege, version string) {
+		key := [2]string{index, privilege}
+		if _, ok := needed[key]; !ok {
+			needed[key] = nil
+		}
+		if version != "" {
+			needed[key] = append(needed[key], version)
+		}
+	}
+
+	// migrations are tracked in an ESStore
+	if mm.Store == nil && mm.FilePath == "" {
+		index, err := mm.TrackingIndex()
+		if err != nil {
+			return err
+		}
+		for _, privilege := range trackingPrivileges {
+			need(index, privilege, "")
+		}
+	}
+	for _, migration := range migrations {
+		if applied[migration.Version()] {
+			continue
+		}
+		required := migration.requiredPrivileges()
+		for _, privilege := range required.Cluster {
+			need("", privilege, migration.Version())
+		}
+		for index, privileges := range required.Index {
+			for _, privilege := range privileges {
+				need(index, privilege, migration.Version())
+			}
+		}
+		if migration.IsDestructive() && mm.SnapshotRepository != "" {
+			need("", "create_snapshot", migration.Version())
+		}
+	}
+	if len(needed) == 0 {
+		return nil
+	}
+
+	request := struct {
+		Cluster []string `json:"cluster,omitempty"`
+		Index   []struct {
+			Names      []string `json:"names"`
+			Privileges []string `json:"privileges"`
+		} `json:"index,omitempty"`
+	}{}
+	byIndex := map[string][]string{}
+	for key := range needed {
+		if key[0] == "" {
+			request.Cluster = append(request.Cluster, key[1])
+		} else {
+			byIndex[key[0]] = append(byIndex[key[0]], key[1])
+		}
+	}
+	sort.Strings(request.Cluster)
+	for index, privileges := range byIndex {
+		sort.Strings(privileges)
+		request.Index = append(request.Index, struct {
+			Names      []string `json:"names"`
+			Privileges []string `json:"privileges"`
+		}{Names: []string{index}, Privileges: privileges})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error encoding privileges: %w", err)
+	}
+
+	client := mm.client()
+	res, err := client.Security.HasPrivileges(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error checking privileges: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if strings.Contains(res.String(), "Security must be explicitly enabled") {
+			mm.logf("Security is disabled, skipping the privilege check")
+			return nil
+		}
+		return fmt.Errorf("error checking privileges: %s", res.String())
+	}
+
+	var result struct {
+		Username        string                     `json:"username"`
+		HasAllRequested bool                       `json:"has_all_requested"`
+		Cluster         map[string]bool            `json:"cluster"`
+		Index           map[string]map[string]bool `json:"index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error parsing privileges: %w", err)
+	}
+	if result.HasAllRequested {
+		return nil
+	}
+
+	missingErr := &MissingPrivilegesError{User: result.Username}
+	for key, versions := range needed {
+		granted := result.Cluster[key[1]]
+		if key[0] != "" {
+			granted = result.Index[key[0]][key[1]]
+		}
+		if !granted {
+			missingErr.Missing = append(missingErr.Missing, MissingPrivilege{Index: key[0], Privilege: key[1], Versions: uniqueStrings(versions)})
+		}
+	}
+	if len(missingErr.Missing) == 0 {
+		return nil
+	}
+	sort.Slice(missingErr.Missing, func(i, j int) bool {
+		a, b := missingErr.Missing[i], missingErr.Missing[j]
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return a.Privilege < b.Privilege
+	})
+	return missingErr
+}
+
+// uniqueStrings returns values without repetitions, in order
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
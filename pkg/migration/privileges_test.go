@@ -0,0 +1,97 @@
+package migration
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	applied := NewMigration("Create users index", noop).Declares(Resource{Kind: ResourceIndex, Name: "users"})
+	index := NewMigration("Create articles index", noop).Declares(Resource{Kind: ResourceIndex, Name: "articles"})
+	pipeline := NewMigration("Add orders pipeline", noop).Declares(Resource{Kind: ResourcePipeline, Name: "orders"})
+	backfill := NewMigration("Backfill orders", noop).RequiresPrivileges(Privileges{Index: map[string][]string{"orders-*": {"write"}}})
+
+	newManager := func(response string, opts ...Option) (*MigrationManager, *routeTransport) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: applied.Version(), Description: applied.Description})
+		transport := &routeTransport{routes: map[string]string{
+			"POST /_security/user/_has_privileges": response,
+		}}
+		mm := NewMigrationManager(ClientFromTransport(transport), append([]Option{WithStore(store)}, opts...)...)
+		mm.Register(applied)
+		mm.Register(index)
+		mm.Register(pipeline)
+		mm.Register(backfill)
+		return mm, transport
+	}
+
+	t.Run("Test Missing Privileges Are Listed", func(t *testing.T) {
+		mm, _ := newManager(`{
+			"username": "migrator",
+			"has_all_requested": false,
+			"cluster": {"manage_pipeline": false},
+			"index": {
+				"articles": {"create_index": true, "manage": true, "write": true},
+				"orders-*": {"write": false}
+			}
+		}`)
+
+		err := mm.CheckPrivileges()
+		var missing *MissingPrivilegesError
+		if !errors.As(err, &missing) {
+			t.Fatalf("Expected a MissingPrivilegesError, got %v", err)
+		}
+		if missing.User != "migrator" || len(missing.Missing) != 2 {
+			t.Fatalf("Expected 2 missing privileges of migrator, got %+v", missing)
+		}
+		if got := missing.Missing[0]; got.Index != "" || got.Privilege != "manage_pipeline" || len(got.Versions) != 1 || got.Versions[0] != pipeline.Version() {
+			t.Errorf("Expected manage_pipeline missing for %s, got %+v", pipeline.Version(), got)
+		}
+		if got := missing.Missing[1]; got.Index != "orders-*" || got.Privilege != "write" || got.Versions[0] != backfill.Version() {
+			t.Errorf("Expected write on orders-* missing for %s, got %+v", backfill.Version(), got)
+		}
+		if !strings.Contains(err.Error(), "index privilege write on orders-*") {
+			t.Errorf("Expected the error to name the index privilege, got %v", err)
+		}
+	})
+
+	t.Run("Test Applied Migrations Are Not Checked", func(t *testing.T) {
+		mm, _ := newManager(`{
+			"username": "migrator",
+			"has_all_requested": false,
+			"cluster": {"manage_pipeline": true},
+			"index": {
+				"articles": {"create_index": true, "manage": true, "write": true},
+				"orders-*": {"write": true},
+				"users": {"create_index": false}
+			}
+		}`)
+
+		if err := mm.CheckPrivileges(); err != nil {
+			t.Errorf("Expected privileges for applied migrations to be ignored, got %v", err)
+		}
+	})
+
+	t.Run("Test Run Fails Before Applying", func(t *testing.T) {
+		mm, transport := newManager(`{"username": "migrator", "has_all_requested": false, "cluster": {"manage_pipeline": false}}`, WithPrivilegeCheck())
+
+		var missing *MissingPrivilegesError
+		if err := mm.RunMigrations(); !errors.As(err, &missing) {
+			t.Fatalf("Expected a MissingPrivilegesError, got %v", err)
+		}
+		if !transport.sent("POST /_security/user/_has_privileges") {
+			t.Errorf("Expected the privileges to be checked, got %v", transport.requests)
+		}
+		applied, err := mm.GetAppliedMigrations()
+		if err != nil {
+			t.Fatalf("Failed to read applied migrations: %v", err)
+		}
+		if applied[index.Version()] {
+			t.Errorf("Expected no migration to be applied")
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Progress is the state of a long-running task, such as a reindex or an
+// update by query, as last reported by the Tasks API
+type Progress struct {
+	// Version is the migration running the task
+	Version string
+	// Task is the task id, e.g. "node-1:42"; Action the task action, e.g.
+	// "indices:data/write/reindex"
+	Task        string
+	Action      string
+	Description string
+	// Total is the number of documents the task will process; Processed
+	// those it has created, updated, deleted, skipped or conflicted on
+	Total     int64
+	Processed int64
+	Elapsed   time.Duration
+	Completed bool
+}
+
+// Percent returns the share of documents processed, from 0 to 100
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		if p.Completed {
+			return 100
+		}
+		return 0
+	}
+	return float64(p.Processed) * 100 / float64(p.Total)
+}
+
+// ETA estimates the time left at the rate documents have been processed so
+// far. It is zero until the first documents are processed.
+func (p Progress) ETA() time.Duration {
+	if p.Processed <= 0 || p.Processed >= p.Total {
+		return 0
+	}
+	rate := float64(p.Elapsed) / float64(p.Processed)
+	return time.Duration(rate * float64(p.Total-p.Processed)).Round(time.Second)
+}
+
+// ProgressReporter receives the progress of long-running tasks while a
+// migration waits for them, e.g. to draw a progress bar or forward it to a UI
+type ProgressReporter interface {
+	Report(progress Progress)
+}
+
+// ProgressReporterFunc adapts a function to a ProgressReporter
+type ProgressReporterFunc func(progress Progress)
+
+func (f ProgressReporterFunc) Report(progress Progress) {
+	f(progress)
+}
+
+// WithProgressReporter reports the progress of the tasks migrations wait for,
+// each time the Tasks API is polled. Reindexing, index rebuilds and update
+// and delete by query report progress.
+func WithProgressReporter(reporter ProgressReporter) Option {
+	return func(mm *MigrationManager) {
+		mm.progress = reporter
+	}
+}
+
+// progressTransport reports the progress in the Tasks API responses of a
+// migration
+type progressTransport struct {
+	next     esapi.Transport
+	reporter ProgressReporter
+	version  string
+}
+
+func (t *progressTransport) Perform(req *http.Request) (*http.Response, error) {
+	res, err := t.next.Perform(req)
+	if err != nil || req.Method != http.MethodGet || res.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(req.URL.Path, "/_tasks/") {
+		return res, err
+	}
+
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return res, nil
+	}
+	if progress, ok := parseProgress(data); ok {
+		progress.Version = t.version
+		t.reporter.Report(progress)
+	}
+	return res, nil
+}
+
+// parseProgress reads the progress of a Tasks API get response
+func parseProgress(data []byte) (Progress, bool) {
+	var status struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Node        string `json:"node"`
+			ID          int64  `json:"id"`
+			Action      string `json:"action"`
+			Description string `json:"description"`
+			Status      *struct {
+				Total            int64 `json:"total"`
+				Created          int64 `json:"created"`
+				Updated          int64 `json:"updated"`
+				Deleted          int64 `json:"deleted"`
+				Noops            int64 `json:"noops"`
+				VersionConflicts int64 `json:"version_conflicts"`
+			} `json:"status"`
+			RunningTimeInNanos int64 `json:"running_time_in_nanos"`
+		} `json:"task"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil || status.Task.Status == nil {
+		return Progress{}, false
+	}
+	counts := status.Task.Status
+	progress := Progress{
+		Action:      status.Task.Action,
+		Description: status.Task.Description,
+		Total:       counts.Total,
+		Processed:   counts.Created + counts.Updated + counts.Deleted + counts.Noops + counts.VersionConflicts,
+		Elapsed:     time.Duration(status.Task.RunningTimeInNanos),
+		Completed:   status.Completed,
+	}
+	if status.Task.Node != "" {
+		progress.Task = status.Task.Node + ":" + strconv.FormatInt(status.Task.ID, 10)
+	}
+	return progress, true
+}
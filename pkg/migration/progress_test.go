@@ -0,0 +1,49 @@
+package migration
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	interval := taskPollInterval
+	taskPollInterval = time.Millisecond
+	defer func() { taskPollInterval = interval }()
+
+	t.Run("Test Task Polls Are Reported", func(t *testing.T) {
+		var reports []Progress
+		transport := &taskTransport{polls: 3, response: `{"total": 6, "updated": 6}`}
+		mm := NewMigrationManager(ClientFromTransport(transport),
+			WithStore(NewMemoryStore()),
+			WithProgressReporter(ProgressReporterFunc(func(progress Progress) {
+				reports = append(reports, progress)
+			})),
+		)
+		m := UpdateByQuery("articles", `{"term": {"status": "draft"}}`, "ctx._source.status = 'review'")
+		mm.Register(m)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if len(reports) != 2 {
+			t.Fatalf("Expected 2 progress reports, got %+v", reports)
+		}
+		progress := reports[1]
+		if progress.Version != m.Version() || progress.Task != "node-1:42" || progress.Processed != 4 || progress.Total != 6 {
+			t.Errorf("Expected 4 of 6 documents processed by task node-1:42, got %+v", progress)
+		}
+	})
+
+	t.Run("Test Percent And ETA", func(t *testing.T) {
+		progress := Progress{Total: 200, Processed: 50, Elapsed: 10 * time.Second}
+		if progress.Percent() != 25 {
+			t.Errorf("Expected 25%%, got %v", progress.Percent())
+		}
+		if progress.ETA() != 30*time.Second {
+			t.Errorf("Expected 30s left, got %v", progress.ETA())
+		}
+		if eta := (Progress{Total: 200}).ETA(); eta != 0 {
+			t.Errorf("Expected no estimate before documents are processed, got %v", eta)
+		}
+	})
+}
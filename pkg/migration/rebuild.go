@@ -0,0 +1,254 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// RebuildConfig configures RebuildIndex
+type RebuildConfig struct {
+	// Source is the existing index
+	Source string
+	// Target is the new index to create and fill
+	Target string
+	// Alias, when set, is moved atomically from Source to Target once the copy
+	// has finished
+	Alias string
+	// Settings override the settings copied from Source, using flat keys such
+	// as "index.codec" or "index.sort.field"
+	Settings map[string]interface{}
+	// Mappings replace the mappings copied from Source when set
+	Mappings json.RawMessage
+	// DropSettings are flat keys, or prefixes ending in ".", removed from the
+	// copied settings before Settings are applied
+	DropSettings []string
+	// Verify, when set, is called after the copy and before the alias is
+	// moved; an error leaves the alias on Source
+	Verify func(client *elasticsearch.Client, source, target string) error
+	// DeleteSource removes Source after the alias has been moved
+	DeleteSource bool
+}
+
+// settings that are set by the cluster and cannot be supplied on creation
+var internalSettings = []string{
+	"index.uuid",
+	"index.creation_date",
+	"index.provided_name",
+	"index.version.",
+	"index.routing.allocation.initial_recovery.",
+	"index.resize.",
+	"index.verified_before_close",
+	"index.history.uuid",
+	"index.blocks.",
+}
+
+// RebuildIndex creates Target with the settings and mappings of Source plus the
+// configured overrides, reindexes all documents into it and swaps the alias.
+// It is the path for settings that can only be applied at index creation.
+func RebuildIndex(client *elasticsearch.Client, cfg RebuildConfig) error {
+	if cfg.Source == "" || cfg.Target == "" {
+		return fmt.Errorf("rebuild requires a source and a target index")
+	}
+
+	settings, mappings, err := getIndexDefinition(client, cfg.Source)
+	if err != nil {
+		return err
+	}
+
+	for key := range settings {
+		for _, drop := range cfg.DropSettings {
+			drop = normalizeSettingKey(drop)
+			if key == drop || (strings.HasSuffix(drop, ".") && strings.HasPrefix(key, drop)) {
+				delete(settings, key)
+				break
+			}
+		}
+	}
+	for key, value := range cfg.Settings {
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		settings[key] = value
+	}
+	if len(cfg.Mappings) > 0 {
+		mappings = cfg.Mappings
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"settings": settings,
+		"mappings": mappings,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling index %s: %w", cfg.Target, err)
+	}
+
+	res, err := client.Indices.Create(cfg.Target, client.Indices.Create.WithBody(strings.NewReader(string(body))))
+	if err != nil {
+		return fmt.Errorf("error creating index %s: %w", cfg.Target, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error creating index %s: %s", cfg.Target, res.String())
+	}
+
+	if err := reindex(client, cfg.Source, cfg.Target); err != nil {
+		return err
+	}
+
+	if cfg.Verify != nil {
+		if err := cfg.Verify(client, cfg.Source, cfg.Target); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Alias != "" {
+		if err := swapAlias(client, cfg.Alias, cfg.Source, cfg.Target); err != nil {
+			return err
+		}
+	}
+
+	if cfg.DeleteSource {
+		res, err := client.Indices.Delete([]string{cfg.Source})
+		if err != nil {
+			return fmt.Errorf("error deleting index %s: %w", cfg.Source, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error deleting index %s: %s", cfg.Source, res.String())
+		}
+	}
+
+	return nil
+}
+
+// getIndexDefinition returns the user-settable flat settings and the mappings of index
+func getIndexDefinition(client *elasticsearch.Client, index string) (map[string]interface{}, json.RawMessage, error) {
+	res, err := client.Indices.Get([]string{index}, client.Indices.Get.WithFlatSettings(true))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, nil, fmt.Errorf("error reading index %s: %s", index, res.String())
+	}
+
+	var result map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+		Mappings json.RawMessage        `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, nil, fmt.Errorf("error parsing index %s: %w", index, err)
+	}
+
+	definition, ok := result[index]
+	if !ok {
+		// index may be an alias resolving to a single concrete index
+		for _, d := range result {
+			definition = d
+			break
+		}
+	}
+
+	return userSettings(definition.Settings), definition.Mappings, nil
+}
+
+// userSettings returns the flat settings without those set by the cluster
+func userSettings(flat map[string]interface{}) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for key, value := range flat {
+		internal := false
+		for _, prefix := range internalSettings {
+			if key == prefix || (strings.HasSuffix(prefix, ".") && strings.HasPrefix(key, prefix)) {
+				internal = true
+				break
+			}
+		}
+		if !internal {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+func reindex(client *elasticsearch.Client, source, target string) error {
+	body := fmt.Sprintf(`{"source": {"index": %q}, "dest": {"index": %q}}`, source, target)
+
+	// Submitted as a task so its progress can be reported while waiting
+	res, err := client.Reindex(
+		strings.NewReader(body),
+		client.Reindex.WithWaitForCompletion(false),
+		client.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error reindexing %s into %s: %w", source, target, err)
+	}
+	task, err := submittedTask(res)
+	if err != nil {
+		return fmt.Errorf("error reindexing %s into %s: %w", source, target, err)
+	}
+
+	result, err := waitForTask(context.Background(), client, task)
+	if err != nil {
+		return fmt.Errorf("error reindexing %s into %s: %w", source, target, err)
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("reindex of %s into %s had %d failures: %s", source, target, len(result.Failures), result.Failures[0])
+	}
+
+	return nil
+}
+
+// swapAlias atomically moves alias from one index to another
+func swapAlias(client *elasticsearch.Client, alias, from, to string) error {
+	body := fmt.Sprintf(`{
+		"actions": [
+			{"remove": {"index": %q, "alias": %q, "must_exist": false}},
+			{"add": {"index": %q, "alias": %q}}
+		]
+	}`, from, alias, to, alias)
+
+	res, err := client.Indices.UpdateAliases(strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error moving alias %s to %s: %w", alias, to, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error moving alias %s to %s: %s", alias, to, res.String())
+	}
+
+	return nil
+}
+
+// IndexStoreSize returns the size in bytes of the primary shards of index
+func IndexStoreSize(client *elasticsearch.Client, index string) (int64, error) {
+	res, err := client.Indices.Stats(
+		client.Indices.Stats.WithIndex(index),
+		client.Indices.Stats.WithMetric("store"),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error reading stats of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("error reading stats of %s: %s", index, res.String())
+	}
+
+	var result struct {
+		All struct {
+			Primaries struct {
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"primaries"`
+		} `json:"_all"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("error parsing stats of %s: %w", index, err)
+	}
+
+	return result.All.Primaries.Store.SizeInBytes, nil
+}
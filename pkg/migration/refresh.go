@@ -0,0 +1,218 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ResourceKind is a kind of cluster object a migration creates or deletes
+type ResourceKind string
+
+const (
+	ResourceIndex         ResourceKind = "index"
+	ResourceIndexTemplate ResourceKind = "index_template"
+	ResourcePipeline      ResourceKind = "ingest_pipeline"
+	ResourceStoredScript  ResourceKind = "stored_script"
+	ResourceRole          ResourceKind = "role"
+)
+
+// Resource is a cluster object a migration leaves in place, or removes when
+// Absent is set
+type Resource struct {
+	Kind   ResourceKind
+	Name   string
+	Absent bool
+}
+
+func (r Resource) String() string {
+	return fmt.Sprintf("%s %s", strings.ReplaceAll(string(r.Kind), "_", " "), r.Name)
+}
+
+// Declares records the cluster objects the migration creates, so Refresh
+// can report them missing. Indices with a desired mapping are declared
+// implicitly.
+func (m Migration) Declares(resources ...Resource) Migration {
+	m.resources = append(append([]Resource{}, m.resources...), resources...)
+	return m
+}
+
+// Removes records the cluster objects the migration deletes, so Refresh can
+// report them if they reappear
+func (m Migration) Removes(resources ...Resource) Migration {
+	for _, resource := range resources {
+		resource.Absent = true
+		m.resources = append(append([]Resource{}, m.resources...), resource)
+	}
+	return m
+}
+
+// Resources returns the cluster objects the migration declares
+func (m Migration) Resources() []Resource {
+	resources := append([]Resource{}, m.resources...)
+	for _, desired := range m.desired {
+		resources = append(resources, Resource{Kind: ResourceIndex, Name: desired.index})
+	}
+	return resources
+}
+
+// Drift is a declared cluster object whose state differs from what the
+// migration history says it should be
+type Drift struct {
+	Resource Resource
+	// Version and Description are of the last applied migration declaring
+	// the object
+	Version     string
+	Description string
+}
+
+func (d Drift) String() string {
+	if d.Resource.Absent {
+		return fmt.Sprintf("%s exists, removed by migration %s: %s", d.Resource, d.Version, d.Description)
+	}
+	return fmt.Sprintf("%s is missing, created by migration %s: %s", d.Resource, d.Version, d.Description)
+}
+
+// RefreshReport is the result of Refresh
+type RefreshReport struct {
+	// Checked is the number of cluster objects checked
+	Checked int
+	Drift   []Drift
+}
+
+func (r *RefreshReport) String() string {
+	if len(r.Drift) == 0 {
+		return fmt.Sprintf("No drift in %d declared objects.\n", r.Checked)
+	}
+	var b strings.Builder
+	for _, drift := range r.Drift {
+		fmt.Fprintf(&b, "%s\n", drift)
+	}
+	fmt.Fprintf(&b, "\nRefresh: %d of %d declared objects drifted.\n", len(r.Drift), r.Checked)
+	return b.String()
+}
+
+// Versions returns the versions of the migrations whose objects drifted
+func (r *RefreshReport) Versions() []string {
+	seen := make(map[string]bool)
+	var versions []string
+	for _, drift := range r.Drift {
+		if !seen[drift.Version] {
+			seen[drift.Version] = true
+			versions = append(versions, drift.Version)
+		}
+	}
+	return versions
+}
+
+// Refresh re-reads the cluster objects declared by applied migrations and
+// reports those whose state differs from the history, such as an index
+// deleted by hand. Each object is expected in the state left by the last
+// applied migration declaring it. Nothing is written.
+func (mm *MigrationManager) Refresh() (*RefreshReport, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+	registered, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[string]Migration, len(registered))
+	for _, migration := range registered {
+		byVersion[migration.Version()] = migration
+	}
+
+	type key struct {
+		kind ResourceKind
+		name string
+	}
+	expected := make(map[key]Drift)
+	var order []key
+	for _, record := range records {
+		migration, ok := byVersion[record.Version]
+		if !ok || record.Skipped {
+			continue
+		}
+		for _, resource := range migration.Resources() {
+			k := key{resource.Kind, resource.Name}
+			if _, ok := expected[k]; !ok {
+				order = append(order, k)
+			}
+			expected[k] = Drift{Resource: resource, Version: migration.Version(), Description: migration.Description}
+		}
+	}
+
+	report := &RefreshReport{}
+	for _, k := range order {
+		drift := expected[k]
+		exists, err := ResourceExists(mm.client(), drift.Resource)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+		if exists == drift.Resource.Absent {
+			report.Drift = append(report.Drift, drift)
+		}
+	}
+	return report, nil
+}
+
+// Invalidate removes the records of applied migrations, e.g. those whose
+// objects drifted, so the next run applies them again
+func (mm *MigrationManager) Invalidate(versions ...string) error {
+	if !mm.disableLock {
+		unlock, err := mm.lock("")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	for _, version := range versions {
+		if err := mm.store().Remove(version); err != nil {
+			return err
+		}
+		mm.logf("Invalidated migration %s", version)
+	}
+	return nil
+}
+
+// ResourceExists reports whether a cluster object exists
+func ResourceExists(client *elasticsearch.Client, resource Resource) (bool, error) {
+	var (
+		res *esapi.Response
+		err error
+	)
+	switch resource.Kind {
+	case ResourceIndex:
+		return IndexExists(client, resource.Name)
+	case ResourceIndexTemplate:
+		res, err = client.Indices.ExistsIndexTemplate(resource.Name)
+	case ResourcePipeline:
+		res, err = client.Ingest.GetPipeline(client.Ingest.GetPipeline.WithPipelineID(resource.Name))
+	case ResourceStoredScript:
+		res, err = client.GetScript(resource.Name)
+	case ResourceRole:
+		res, err = client.Security.GetRole(client.Security.GetRole.WithName(resource.Name))
+	default:
+		return false, fmt.Errorf("unknown resource kind %q", resource.Kind)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking %s: %w", resource, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == 404:
+		return false, nil
+	case res.IsError():
+		return false, fmt.Errorf("error checking %s: %s", resource, res.String())
+	}
+	return true, nil
+}
@@ -0,0 +1,83 @@
+package migration
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestRefresh(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	migrations := []Migration{
+		NewMigration("Create articles index", noop).Declares(Resource{Kind: ResourceIndex, Name: "articles"}),
+		PutRole("Add reader role", "reader", `{"indices": [{"names": ["articles"], "privileges": ["read"]}]}`),
+		DeleteStoredScript("Drop old script", "old"),
+		NewMigration("Create comments index", noop).Declares(Resource{Kind: ResourceIndex, Name: "comments"}),
+		NewMigration("Drop comments index", noop).Removes(Resource{Kind: ResourceIndex, Name: "comments"}),
+		NewMigration("Add orders pipeline", noop).Declares(Resource{Kind: ResourcePipeline, Name: "orders"}),
+	}
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version() < sorted[j].Version()
+	})
+
+	store := NewMemoryStore()
+	for _, migration := range sorted[:len(sorted)-1] {
+		store.Record(MigrationRecord{Version: migration.Version(), Description: migration.Description})
+	}
+	transport := &routeTransport{routes: map[string]string{
+		"GET /_security/role/reader": `{"reader": {}}`,
+		"GET /_scripts/old":          `{"_id": "old", "found": true}`,
+	}}
+	mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store))
+	for _, migration := range migrations {
+		mm.Register(migration)
+	}
+
+	report, err := mm.Refresh()
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	t.Run("Test Applied Objects Are Checked", func(t *testing.T) {
+		if report.Checked != 4 {
+			t.Errorf("Expected 4 objects checked, got %d", report.Checked)
+		}
+		if transport.sent("GET /_ingest/pipeline/orders") {
+			t.Errorf("Expected the pipeline of a pending migration not to be checked")
+		}
+	})
+
+	t.Run("Test Drift Is Reported", func(t *testing.T) {
+		if len(report.Drift) != 2 {
+			t.Fatalf("Expected 2 drifted objects, got %v", report.Drift)
+		}
+		drifted := make(map[string]Drift)
+		for _, drift := range report.Drift {
+			drifted[drift.Resource.Name] = drift
+		}
+		missing, reappeared := drifted["articles"], drifted["old"]
+		if missing.Resource.Name != "articles" || missing.Resource.Absent || missing.Description != "Create articles index" {
+			t.Errorf("Expected the articles index missing, got %s", missing)
+		}
+		if reappeared.Resource.Kind != ResourceStoredScript || !reappeared.Resource.Absent {
+			t.Errorf("Expected the deleted script to exist again, got %s", reappeared)
+		}
+	})
+
+	t.Run("Test Invalidate Makes Migrations Pending", func(t *testing.T) {
+		if err := mm.Invalidate(report.Versions()...); err != nil {
+			t.Fatalf("Failed to invalidate: %v", err)
+		}
+		applied, _ := mm.GetAppliedMigrations()
+		for _, drift := range report.Drift {
+			if applied[drift.Version] {
+				t.Errorf("Expected migration %s to be pending", drift.Version)
+			}
+		}
+		if len(applied) != 3 {
+			t.Errorf("Expected 3 migrations still applied, got %d", len(applied))
+		}
+	})
+}
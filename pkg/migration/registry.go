@@ -0,0 +1,42 @@
+package migration
+
+import "sync"
+
+// global holds the migrations registered with RegisterGlobal
+var global struct {
+	mu         sync.Mutex
+	migrations []Migration
+}
+
+// RegisterGlobal adds migrations to the package-level registry, so each
+// migration can register itself from an init function in its own file, like
+// database/sql drivers do. Managers built with WithGlobalRegistry pick them
+// up. Like any other migrations they run in version order, which for the
+// default hashed versions is not the registration order. To run them in
+// registration order, which within a package is the order of the file names,
+// build the manager with WithVersionFunc(SequentialVersion) and name files
+// with a sortable prefix such as 0001_create_articles.go.
+func RegisterGlobal(migrations ...Migration) {
+	global.mu.Lock()
+	defer global.mu.Unlock()
+	global.migrations = append(global.migrations, migrations...)
+}
+
+// GlobalMigrations returns a copy of the migrations registered with
+// RegisterGlobal
+func GlobalMigrations() []Migration {
+	global.mu.Lock()
+	defer global.mu.Unlock()
+	return append([]Migration{}, global.migrations...)
+}
+
+// WithGlobalRegistry registers the migrations of the package-level registry
+// on the manager. Only migrations registered before the manager is built are picked up, which
+// holds for those registered from init functions.
+func WithGlobalRegistry() Option {
+	return func(mm *MigrationManager) {
+		for _, migration := range GlobalMigrations() {
+			mm.Register(migration)
+		}
+	}
+}
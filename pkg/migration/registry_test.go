@@ -0,0 +1,83 @@
+package migration
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestGlobalRegistry(t *testing.T) {
+	defer func() { global.migrations = nil }()
+	noop := func(client *elasticsearch.Client) error { return nil }
+
+	t.Run("Test Manager Picks Up Global Migrations", func(t *testing.T) {
+		global.migrations = nil
+		RegisterGlobal(NewMigration("Create articles index", noop))
+		RegisterGlobal(NewMigration("Add tags field", noop), NewMigration("Add author field", noop))
+
+		store := NewMemoryStore()
+		mm := NewMigrationManager(nil, WithStore(store), WithGlobalRegistry())
+		mm.Register(NewMigration("Add summary field", noop))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		want := []string{"Create articles index", "Add tags field", "Add author field", "Add summary field"}
+		for i, migration := range mm.Migrations {
+			if migration.Description != want[i] {
+				t.Errorf("Expected migration %d to be %q, got %q", i+1, want[i], migration.Description)
+			}
+		}
+		if records, _ := store.GetApplied(); len(records) != len(want) {
+			t.Errorf("Expected %d applied migrations, got %+v", len(want), records)
+		}
+	})
+
+	t.Run("Test Sequential Versions Apply In Registration Order", func(t *testing.T) {
+		global.migrations = nil
+		var applied []string
+		for _, description := range []string{"0001 create articles", "0002 add tags", "0003 add author", "0004 add summary"} {
+			description := description
+			RegisterGlobal(NewMigration(description, func(client *elasticsearch.Client) error {
+				applied = append(applied, description)
+				return nil
+			}))
+		}
+
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()), WithVersionFunc(SequentialVersion), WithGlobalRegistry(), WithLogger(log.New(io.Discard, "", 0)))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		want := []string{"0001 create articles", "0002 add tags", "0003 add author", "0004 add summary"}
+		if strings.Join(applied, ", ") != strings.Join(want, ", ") {
+			t.Errorf("Expected migrations to be applied in registration order %v, got %v", want, applied)
+		}
+	})
+
+	t.Run("Test Managers Without The Option Ignore It", func(t *testing.T) {
+		global.migrations = nil
+		RegisterGlobal(NewMigration("Create articles index", noop))
+
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		if len(mm.Migrations) != 0 {
+			t.Errorf("Expected no migrations, got %d", len(mm.Migrations))
+		}
+	})
+
+	t.Run("Test Versions Are Stamped Per Manager", func(t *testing.T) {
+		global.migrations = nil
+		RegisterGlobal(NewMigration("Create articles index", noop), NewMigration("Add tags field", noop))
+
+		mm := NewMigrationManager(nil, WithVersionFunc(SequentialVersion), WithGlobalRegistry())
+		if mm.Migrations[0].Version() != "0001" || mm.Migrations[1].Version() != "0002" {
+			t.Errorf("Expected sequential versions, got %s and %s", mm.Migrations[0].Version(), mm.Migrations[1].Version())
+		}
+		if GlobalMigrations()[0].Version() == "0001" {
+			t.Errorf("Expected the global registry to keep unstamped migrations")
+		}
+	})
+}
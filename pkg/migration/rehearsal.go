@@ -0,0 +1,480 @@
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// rehearsalIndexPrefix names the sandbox indices of a rehearsal
+const rehearsalIndexPrefix = "elasticmate-rehearsal-"
+
+// RehearsalConfig configures Rehearse
+type RehearsalConfig struct {
+	// Indices are cloned before the first migration runs. Other indices are
+	// cloned when a migration first refers to them.
+	Indices []string
+	// SampleThreshold is the number of documents above which an index is
+	// cloned with a sample of SampleSize documents instead of in full
+	// (defaults 10000 and 1000)
+	SampleThreshold int64
+	SampleSize      int64
+}
+
+// RehearsalClone is an index copied into the sandbox
+type RehearsalClone struct {
+	Index string
+	Clone string
+	// Docs is the number of documents copied; Sampled is set when the index
+	// had more documents than the sample threshold
+	Docs    int64
+	Sampled bool
+}
+
+// RehearsalStep is the outcome of a pending migration in the sandbox
+type RehearsalStep struct {
+	Version     string
+	Description string
+	Duration    time.Duration
+	// Err is the error of the migration or of its first failing
+	// verification
+	Err error
+}
+
+// RehearsalReport is the result of Rehearse
+type RehearsalReport struct {
+	Clones []RehearsalClone
+	Steps  []RehearsalStep
+	// Skipped are the requests to cluster-wide APIs, such as templates and
+	// pipelines, that were not sent because they cannot be sandboxed
+	Skipped []CapturedRequest
+}
+
+// Failed reports whether a migration or verification failed
+func (r *RehearsalReport) Failed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RehearsalReport) String() string {
+	var b strings.Builder
+	for _, clone := range r.Clones {
+		sampled := ""
+		if clone.Sampled {
+			sampled = ", sampled"
+		}
+		fmt.Fprintf(&b, "Cloned %s (%d documents%s)\n", clone.Index, clone.Docs, sampled)
+	}
+	for _, request := range r.Skipped {
+		fmt.Fprintf(&b, "Skipped %s %s\n", request.Method, request.Path)
+	}
+	if len(r.Clones)+len(r.Skipped) > 0 {
+		fmt.Fprintln(&b)
+	}
+
+	failed := 0
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			failed++
+			fmt.Fprintf(&b, "FAIL %s: %s: %v\n", step.Version, step.Description, step.Err)
+			continue
+		}
+		fmt.Fprintf(&b, "ok   %s: %s (%s)\n", step.Version, step.Description, step.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(&b, "\nRehearsal: %d of %d pending migrations failed.\n", failed, len(r.Steps))
+	return b.String()
+}
+
+// Rehearse runs the pending migrations and their verifications against a
+// sandbox before they touch real indices. Every index a migration refers to
+// is cloned, small indices fully and large ones with a sample of their
+// documents, and requests are redirected to the clones. Requests to
+// cluster-wide APIs are skipped and reported. The rehearsal stops at the
+// first failing migration, and the sandbox is deleted when it ends. Nothing
+// is recorded.
+func (mm *MigrationManager) Rehearse(ctx context.Context, cfg RehearsalConfig) (*RehearsalReport, error) {
+	if cfg.SampleThreshold <= 0 {
+		cfg.SampleThreshold = 10000
+	}
+	if cfg.SampleSize <= 0 {
+		cfg.SampleSize = 1000
+	}
+
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	if err := sortMigrations(migrations); err != nil {
+		return nil, err
+	}
+
+	report := &RehearsalReport{}
+	sandbox := &sandboxTransport{
+		client: mm.client(),
+		prefix: rehearsalIndexPrefix + strings.ToLower(newRunID()) + "-",
+		cfg:    cfg,
+		report: report,
+		seen:   map[string]bool{},
+	}
+	defer sandbox.cleanup(mm)
+
+	for _, index := range cfg.Indices {
+		if err := sandbox.clone(index); err != nil {
+			return nil, err
+		}
+	}
+
+	client := ClientFromTransport(sandbox)
+	for _, migration := range migrations {
+		if applied[migration.Version()] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := migration.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid migration %s: %w", migration.Version(), err)
+		}
+
+		mm.logf("Rehearsing migration %s: %s", migration.Version(), migration.Description)
+		start := time.Now()
+		err := migration.run(client, nil)
+		if err == nil {
+			for _, verify := range migration.verifications {
+				if err = verify(ctx, client); err != nil {
+					err = fmt.Errorf("verification failed: %w", err)
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = sandbox.failure()
+		}
+		report.Steps = append(report.Steps, RehearsalStep{
+			Version:     migration.Version(),
+			Description: migration.Description,
+			Duration:    time.Since(start),
+			Err:         err,
+		})
+		if err != nil {
+			mm.logf("Rehearsal of migration %s failed: %v", migration.Version(), err)
+			break
+		}
+	}
+	return report, nil
+}
+
+// sandboxTransport redirects the requests of migrations to sandbox indices,
+// named after the real ones with a prefix, cloning real indices the first
+// time they are referred to
+type sandboxTransport struct {
+	client *elasticsearch.Client
+	prefix string
+	cfg    RehearsalConfig
+
+	mu     sync.Mutex
+	report *RehearsalReport
+	// seen are the index names already cloned or found missing
+	seen map[string]bool
+	// err is the error of a clone made while redirecting a request
+	err error
+}
+
+// failure returns and clears the error of the last clone made on demand
+func (s *sandboxTransport) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+func (s *sandboxTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	readOnly := req.Method == http.MethodGet || req.Method == http.MethodHead
+	switch {
+	case segments[0] == "":
+	case !strings.HasPrefix(segments[0], "_"):
+		names := strings.Split(segments[0], ",")
+		for i, name := range names {
+			names[i] = s.redirect(name)
+		}
+		segments[0] = strings.Join(names, ",")
+	case segments[0] == "_reindex", segments[0] == "_aliases", segments[0] == "_bulk":
+		body = s.redirectBody(segments[0], body)
+	case !readOnly && !readEndpoints[segments[0]] && segments[0] != "_tasks":
+		s.mu.Lock()
+		s.report.Skipped = append(s.report.Skipped, CapturedRequest{Method: req.Method, Path: req.URL.Path, Body: string(body), Write: true})
+		s.mu.Unlock()
+		return jsonResponse(http.StatusOK, `{"acknowledged": true}`), nil
+	}
+
+	req.URL.Path = "/" + strings.Join(segments, "/")
+	req.URL.RawPath = ""
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	res, err := s.client.Transport.Perform(req)
+	if err != nil || res.Body == nil {
+		return res, err
+	}
+
+	// name the sandbox indices as the migration knows them
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.ReplaceAll(data, []byte(`"`+s.prefix), []byte(`"`))
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	res.ContentLength = int64(len(data))
+	return res, nil
+}
+
+// redirect returns the sandbox name of an index, cloning the real index the
+// first time a concrete name is referred to
+func (s *sandboxTransport) redirect(name string) string {
+	if name == "" || name == "_all" || strings.HasPrefix(name, s.prefix) {
+		return name
+	}
+	exclude := strings.HasPrefix(name, "-")
+	name = strings.TrimPrefix(name, "-")
+	if !strings.ContainsAny(name, "*?") {
+		if err := s.clone(name); err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+		}
+	}
+	if exclude {
+		return "-" + s.prefix + name
+	}
+	return s.prefix + name
+}
+
+// redirectBody redirects the indices named in the body of reindex, alias and
+// bulk requests
+func (s *sandboxTransport) redirectBody(endpoint string, body []byte) []byte {
+	switch endpoint {
+	case "_reindex":
+		var payload map[string]interface{}
+		if json.Unmarshal(body, &payload) != nil {
+			return body
+		}
+		if source, ok := payload["source"].(map[string]interface{}); ok {
+			source["index"] = s.redirectValue(source["index"])
+		}
+		if dest, ok := payload["dest"].(map[string]interface{}); ok {
+			dest["index"] = s.redirectValue(dest["index"])
+		}
+		if data, err := json.Marshal(payload); err == nil {
+			return data
+		}
+	case "_aliases":
+		var payload struct {
+			Actions []map[string]map[string]interface{} `json:"actions"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return body
+		}
+		for _, action := range payload.Actions {
+			for _, target := range action {
+				for _, key := range []string{"index", "indices", "alias", "aliases"} {
+					if value, ok := target[key]; ok {
+						target[key] = s.redirectValue(value)
+					}
+				}
+			}
+		}
+		if data, err := json.Marshal(payload); err == nil {
+			return data
+		}
+	case "_bulk":
+		var out bytes.Buffer
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+		action := true
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if action {
+				var meta map[string]map[string]interface{}
+				if json.Unmarshal(line, &meta) == nil {
+					for op, target := range meta {
+						if index, ok := target["_index"]; ok {
+							target["_index"] = s.redirectValue(index)
+						}
+						if data, err := json.Marshal(meta); err == nil {
+							line = data
+						}
+						// deletes have no source line
+						action = op == "delete"
+					}
+				}
+			} else {
+				action = true
+			}
+			out.Write(line)
+			out.WriteByte('\n')
+		}
+		return out.Bytes()
+	}
+	return body
+}
+
+func (s *sandboxTransport) redirectValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		names := strings.Split(v, ",")
+		for i, name := range names {
+			names[i] = s.redirect(name)
+		}
+		return strings.Join(names, ",")
+	case []interface{}:
+		for i, item := range v {
+			v[i] = s.redirectValue(item)
+		}
+		return v
+	}
+	return value
+}
+
+// clone copies a real index into the sandbox, sampling its documents when it
+// has more than the sample threshold. Missing indices are left to be created
+// by the migrations.
+func (s *sandboxTransport) clone(index string) error {
+	s.mu.Lock()
+	if s.seen[index] {
+		s.mu.Unlock()
+		return nil
+	}
+	s.seen[index] = true
+	s.mu.Unlock()
+
+	exists, err := IndexExists(s.client, index)
+	if err != nil || !exists {
+		return err
+	}
+	settings, mappings, err := getIndexDefinition(s.client, index)
+	if err != nil {
+		return err
+	}
+	settings["index.number_of_replicas"] = "0"
+	delete(settings, "index.hidden")
+	body, err := json.Marshal(map[string]interface{}{"settings": settings, "mappings": mappings})
+	if err != nil {
+		return fmt.Errorf("error encoding clone of %s: %w", index, err)
+	}
+
+	clone := s.prefix + index
+	res, err := s.client.Indices.Create(clone, s.client.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return fmt.Errorf("error cloning %s: %w", index, err)
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error cloning %s: %s", index, res.String())
+	}
+
+	docs, err := countDocuments(s.client, index)
+	if err != nil {
+		return err
+	}
+	sampled := docs > s.cfg.SampleThreshold
+	request := map[string]interface{}{
+		"source": map[string]interface{}{"index": index},
+		"dest":   map[string]interface{}{"index": clone},
+	}
+	if sampled {
+		request["max_docs"] = s.cfg.SampleSize
+		docs = s.cfg.SampleSize
+	}
+	if docs > 0 {
+		data, _ := json.Marshal(request)
+		res, err := s.client.Reindex(bytes.NewReader(data), s.client.Reindex.WithRefresh(true))
+		if err != nil {
+			return fmt.Errorf("error copying %s into the sandbox: %w", index, err)
+		}
+		res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error copying %s into the sandbox: %s", index, res.String())
+		}
+	}
+
+	s.mu.Lock()
+	s.report.Clones = append(s.report.Clones, RehearsalClone{Index: index, Clone: clone, Docs: docs, Sampled: sampled})
+	s.mu.Unlock()
+	return nil
+}
+
+// cleanup deletes every index of the sandbox, including those the migrations
+// created
+func (s *sandboxTransport) cleanup(mm *MigrationManager) {
+	res, err := s.client.Indices.Get([]string{s.prefix + "*"}, s.client.Indices.Get.WithExpandWildcards("all"))
+	if err != nil {
+		mm.logf("Failed to list rehearsal indices: %v", err)
+		return
+	}
+	defer res.Body.Close()
+	var indices map[string]json.RawMessage
+	if res.IsError() || json.NewDecoder(res.Body).Decode(&indices) != nil {
+		mm.logf("Failed to list rehearsal indices: %s", res.String())
+		return
+	}
+	for index := range indices {
+		res, err := s.client.Indices.Delete([]string{index})
+		if err != nil {
+			mm.logf("Failed to delete rehearsal index %s: %v", index, err)
+			continue
+		}
+		res.Body.Close()
+		if res.IsError() {
+			mm.logf("Failed to delete rehearsal index %s: %s", index, res.String())
+		}
+	}
+}
+
+// countDocuments returns the number of documents in index
+func countDocuments(client *elasticsearch.Client, index string) (int64, error) {
+	res, err := client.Count(client.Count.WithIndex(index))
+	if err != nil {
+		return 0, fmt.Errorf("error counting documents of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("error counting documents of %s: %s", index, res.String())
+	}
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("error parsing document count of %s: %w", index, err)
+	}
+	return result.Count, nil
+}
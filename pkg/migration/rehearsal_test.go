@@ -0,0 +1,124 @@
+package migration_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestRehearse(t *testing.T) {
+	newCluster := func(t *testing.T) *migrationtest.FakeES {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles", `{"mappings": {"properties": {"title": {"type": "text"}}}}`)
+		for i := 0; i < 30; i++ {
+			es.AddDocument("articles", fmt.Sprint(i), map[string]interface{}{"title": fmt.Sprintf("Article %d", i)})
+		}
+		es.CreateIndex("authors", "")
+		es.AddDocument("authors", "1", map[string]interface{}{"name": "Ada"})
+		return es
+	}
+
+	t.Run("Test Migrations Run Against Clones", func(t *testing.T) {
+		es := newCluster(t)
+		tags := migration.PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}}}`).
+			WithVerify(migration.VerifyFieldExists("articles", "tags"))
+		comments := migration.PutMapping("Create comments index", "comments", `{"properties": {"body": {"type": "text"}}}`)
+		template := migration.NewMigration("Put articles template", func(client *elasticsearch.Client) error {
+			res, err := client.Indices.PutIndexTemplate("articles", strings.NewReader(`{"index_patterns": ["articles-*"]}`))
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if res.IsError() {
+				return errors.New(res.String())
+			}
+			return nil
+		})
+		mm := es.Manager(migration.WithMigrations(tags, comments, template))
+
+		report, err := mm.Rehearse(context.Background(), migration.RehearsalConfig{Indices: []string{"authors"}, SampleThreshold: 20, SampleSize: 5})
+		if err != nil {
+			t.Fatalf("Failed to rehearse: %v", err)
+		}
+		if report.Failed() || len(report.Steps) != 3 {
+			t.Fatalf("Expected 3 rehearsed migrations, got:\n%s", report)
+		}
+
+		clones := map[string]migration.RehearsalClone{}
+		for _, clone := range report.Clones {
+			clones[clone.Index] = clone
+		}
+		if clone := clones["articles"]; !clone.Sampled || clone.Docs != 5 {
+			t.Errorf("Expected articles sampled with 5 documents, got %+v", clone)
+		}
+		if clone := clones["authors"]; clone.Sampled || clone.Docs != 1 {
+			t.Errorf("Expected authors copied in full, got %+v", clone)
+		}
+		if len(report.Skipped) != 1 || report.Skipped[0].Path != "/_index_template/articles" {
+			t.Errorf("Expected the template request skipped, got %+v", report.Skipped)
+		}
+
+		properties := es.Mapping("articles")["properties"].(map[string]interface{})
+		if properties["tags"] != nil {
+			t.Errorf("Expected the real articles index untouched")
+		}
+		if es.IndexExists("comments") {
+			t.Errorf("Expected the comments index created in the sandbox only")
+		}
+		if es.Sent("PUT", "/_index_template/articles") {
+			t.Errorf("Expected the template request not sent")
+		}
+		for _, index := range []string{"articles", "authors"} {
+			if !es.IndexExists(index) {
+				t.Errorf("Expected %s kept", index)
+			}
+		}
+		for _, request := range es.Requests() {
+			if request.Method == "PUT" && strings.HasPrefix(request.Path, "/elasticmate-rehearsal-") && strings.HasSuffix(request.Path, "-comments") {
+				return
+			}
+		}
+		t.Errorf("Expected the comments index created in the sandbox")
+	})
+
+	t.Run("Test Sandbox Is Deleted", func(t *testing.T) {
+		es := newCluster(t)
+		mm := es.Manager(migration.WithMigrations(migration.PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}}}`)))
+		if _, err := mm.Rehearse(context.Background(), migration.RehearsalConfig{}); err != nil {
+			t.Fatalf("Failed to rehearse: %v", err)
+		}
+		for _, request := range es.Requests() {
+			if request.Method == "PUT" && strings.HasPrefix(request.Path, "/elasticmate-rehearsal-") {
+				if es.IndexExists(strings.TrimPrefix(request.Path, "/")) {
+					t.Errorf("Expected %s deleted", request.Path)
+				}
+			}
+		}
+		applied, _ := mm.GetAppliedMigrations()
+		if len(applied) != 0 {
+			t.Errorf("Expected nothing recorded, got %v", applied)
+		}
+	})
+
+	t.Run("Test Failures Stop The Rehearsal", func(t *testing.T) {
+		es := newCluster(t)
+		broken := migration.NewMigration("Check a missing field", func(client *elasticsearch.Client) error { return nil }).
+			WithVerify(migration.VerifyFieldExists("articles", "missing"))
+		later := migration.PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}}}`).DependsOn(broken.Version())
+		mm := es.Manager(migration.WithMigrations(broken, later))
+
+		report, err := mm.Rehearse(context.Background(), migration.RehearsalConfig{})
+		if err != nil {
+			t.Fatalf("Failed to rehearse: %v", err)
+		}
+		if !report.Failed() || len(report.Steps) != 1 || !strings.Contains(report.Steps[0].Err.Error(), "verification failed") {
+			t.Errorf("Expected the rehearsal to stop at the failed verification, got:\n%s", report)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Repeatable makes the migration run again whenever the checksum of content
+// changes, instead of once, for objects that are continuously reconciled such
+// as ingest pipelines, stored scripts and templates. content is what the
+// migration puts, e.g. the pipeline body. Repeatable migrations run after
+// the other pending migrations.
+func (m Migration) Repeatable(content ...[]byte) Migration {
+	hasher := sha256.New()
+	for _, part := range content {
+		hasher.Write(part)
+	}
+	m.checksum = hex.EncodeToString(hasher.Sum(nil))[:16]
+	return m
+}
+
+// IsRepeatable reports whether the migration runs again when its content
+// changes
+func (m Migration) IsRepeatable() bool {
+	return m.checksum != ""
+}
+
+// Checksum returns the checksum of the content of a repeatable migration
+func (m Migration) Checksum() string {
+	return m.checksum
+}
+
+// outdatedRepeatables returns the versions of applied repeatable migrations
+// whose recorded checksum differs from their content
+func (mm *MigrationManager) outdatedRepeatables(migrations []Migration, applied map[string]bool) (map[string]bool, error) {
+	repeatable := false
+	for _, migration := range migrations {
+		repeatable = repeatable || (migration.IsRepeatable() && applied[migration.Version()])
+	}
+	if !repeatable {
+		return nil, nil
+	}
+
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string, len(records))
+	for _, record := range records {
+		checksums[record.Version] = record.Checksum
+	}
+
+	outdated := make(map[string]bool)
+	for _, migration := range migrations {
+		if checksum, ok := checksums[migration.Version()]; ok && migration.IsRepeatable() && checksum != migration.checksum {
+			outdated[migration.Version()] = true
+		}
+	}
+	return outdated, nil
+}
+
+// recordRepeated replaces the record of a repeatable migration applied again
+func (mm *MigrationManager) recordRepeated(record MigrationRecord) error {
+	if err := mm.store().Remove(record.Version); err != nil {
+		return fmt.Errorf("error replacing record of repeatable migration %s: %w", record.Version, err)
+	}
+	return mm.store().Record(record)
+}
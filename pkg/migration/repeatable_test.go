@@ -0,0 +1,97 @@
+package migration
+
+import (
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestRepeatableMigrations(t *testing.T) {
+	store := NewMemoryStore()
+
+	var order []string
+	pipeline := func(body string) Migration {
+		return NewMigration("Put articles pipeline", func(client *elasticsearch.Client) error {
+			order = append(order, "pipeline")
+			return nil
+		}).Repeatable([]byte(body))
+	}
+	index := NewMigration("Create articles index", func(client *elasticsearch.Client) error {
+		order = append(order, "index")
+		return nil
+	})
+
+	run := func(m Migration) {
+		t.Helper()
+		mm := NewMigrationManager(nil, WithStore(store))
+		mm.Register(m)
+		mm.Register(index)
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+	}
+
+	t.Run("Test Repeatable Migrations Run Last", func(t *testing.T) {
+		run(pipeline(`{"processors": []}`))
+		if len(order) != 2 || order[0] != "index" || order[1] != "pipeline" {
+			t.Fatalf("Expected the index before the pipeline, got %v", order)
+		}
+	})
+
+	t.Run("Test Unchanged Content Is Not Repeated", func(t *testing.T) {
+		order = nil
+		run(pipeline(`{"processors": []}`))
+		if len(order) != 0 {
+			t.Errorf("Expected nothing to run, got %v", order)
+		}
+	})
+
+	t.Run("Test Changed Content Is Repeated", func(t *testing.T) {
+		order = nil
+		changed := pipeline(`{"processors": [{"lowercase": {"field": "tags"}}]}`)
+		if changed.Version() != pipeline("").Version() {
+			t.Errorf("Expected the version not to depend on the content")
+		}
+		run(changed)
+		if len(order) != 1 || order[0] != "pipeline" {
+			t.Fatalf("Expected only the pipeline to run again, got %v", order)
+		}
+
+		records, _ := store.GetApplied()
+		for _, record := range records {
+			if record.Version == changed.Version() && record.Checksum != changed.Checksum() {
+				t.Errorf("Expected the record to hold checksum %s, got %s", changed.Checksum(), record.Checksum)
+			}
+		}
+		if len(records) != 2 {
+			t.Errorf("Expected the record to be replaced, got %d records", len(records))
+		}
+	})
+}
+
+func TestRepeatableDeclaration(t *testing.T) {
+	declare := func(field string) Migration {
+		m, err := ParseDeclaration([]byte(`
+description: Keep articles mapping
+action: put_mapping
+index: articles
+repeatable: true
+body:
+  properties:
+    ` + field + `:
+      type: keyword
+`))
+		if err != nil {
+			t.Fatalf("Failed to parse declaration: %v", err)
+		}
+		return m
+	}
+
+	tags, authors := declare("tags"), declare("authors")
+	if !tags.IsRepeatable() {
+		t.Fatal("Expected the declared migration to be repeatable")
+	}
+	if tags.Checksum() == authors.Checksum() {
+		t.Error("Expected the checksum to change with the declaration")
+	}
+}
@@ -0,0 +1,77 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ErrSchemaOutdated is returned by RequireVersion when a required migration
+// is not applied
+var ErrSchemaOutdated = errors.New("required migration is not applied")
+
+// RequireVersion is for services that consume indices another process
+// migrates: it reads the tracking store and returns an error wrapping
+// ErrSchemaOutdated unless the migration of minVersion is applied, so the
+// service fails fast instead of running against an outdated schema. When
+// ctx has a deadline, it waits for the migration until then instead.
+//
+// opts select the store like those of the manager applying the
+// migrations, e.g. WithNamespace. With WithVersionFunc versions are ordered,
+// and any later applied version satisfies minVersion too.
+func RequireVersion(ctx context.Context, client *elasticsearch.Client, minVersion string, opts ...Option) error {
+	mm := NewMigrationManager(client, opts...)
+	_, waits := ctx.Deadline()
+	for logged := false; ; logged = true {
+		applied, err := mm.versionApplied(minVersion)
+		if err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+		if !waits {
+			return fmt.Errorf("%w: migration %s", ErrSchemaOutdated, minVersion)
+		}
+
+		if !logged {
+			mm.logf("Waiting for migration %s to be applied", minVersion)
+		}
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: migration %s (%v)", ErrSchemaOutdated, minVersion, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// versionApplied reports whether the migration of version, or with ordered
+// versions a later one, is recorded as applied. Migrations skipped by a
+// condition don't count. The tracking index is read without being created,
+// as consumers may only have read access.
+func (mm *MigrationManager) versionApplied(version string) (bool, error) {
+	var records []MigrationRecord
+	var err error
+	if store, ok := mm.store().(*ESStore); ok {
+		records, err = store.readApplied()
+	} else {
+		records, err = mm.store().GetApplied()
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record.Skipped {
+			continue
+		}
+		if record.Version == version || (mm.versionFunc != nil && naturalLess(version, record.Version)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
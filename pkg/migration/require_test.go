@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireVersion(t *testing.T) {
+	interval := lockPollInterval
+	lockPollInterval = time.Millisecond
+	defer func() { lockPollInterval = interval }()
+
+	t.Run("Test Missing Migration Fails Fast", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: "3fa2c1d0"})
+		err := RequireVersion(context.Background(), nil, "1b2c3d4e", WithStore(store))
+		if !errors.Is(err, ErrSchemaOutdated) {
+			t.Fatalf("Expected an outdated schema, got %v", err)
+		}
+		if err := RequireVersion(context.Background(), nil, "3fa2c1d0", WithStore(store)); err != nil {
+			t.Errorf("Expected the applied migration accepted, got %v", err)
+		}
+	})
+
+	t.Run("Test Deadline Waits For The Migration", func(t *testing.T) {
+		store := NewMemoryStore()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			store.Record(MigrationRecord{Version: "3fa2c1d0"})
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := RequireVersion(ctx, nil, "3fa2c1d0", WithStore(store)); err != nil {
+			t.Fatalf("Expected the migration awaited, got %v", err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := RequireVersion(ctx, nil, "1b2c3d4e", WithStore(store))
+		if !errors.Is(err, ErrSchemaOutdated) {
+			t.Errorf("Expected an outdated schema after the deadline, got %v", err)
+		}
+	})
+
+	t.Run("Test Ordered Versions Accept Later Ones", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: "0012"})
+		if err := RequireVersion(context.Background(), nil, "0009", WithStore(store), WithVersionFunc(SequentialVersion)); err != nil {
+			t.Errorf("Expected a later version accepted, got %v", err)
+		}
+		if err := RequireVersion(context.Background(), nil, "0013", WithStore(store), WithVersionFunc(SequentialVersion)); !errors.Is(err, ErrSchemaOutdated) {
+			t.Errorf("Expected an earlier version refused, got %v", err)
+		}
+	})
+
+	t.Run("Test Skipped Migrations Are Not Applied", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: "3fa2c1d0", Skipped: true})
+		if err := RequireVersion(context.Background(), nil, "3fa2c1d0", WithStore(store)); !errors.Is(err, ErrSchemaOutdated) {
+			t.Errorf("Expected a skipped migration refused, got %v", err)
+		}
+	})
+
+	t.Run("Test Read Only Credentials", func(t *testing.T) {
+		transport := &readOnlyTransport{}
+		client := ClientFromTransport(transport)
+		if err := RequireVersion(context.Background(), client, "3fa2c1d0"); !errors.Is(err, ErrSchemaOutdated) {
+			t.Errorf("Expected a missing tracking index to be an outdated schema, got %v", err)
+		}
+
+		transport.hits = `[{"_source": {"version": "3fa2c1d0"}}]`
+		if err := RequireVersion(context.Background(), client, "3fa2c1d0"); err != nil {
+			t.Errorf("Expected the applied migration accepted, got %v", err)
+		}
+		if len(transport.writes) != 0 {
+			t.Errorf("Expected no writes, got %v", transport.writes)
+		}
+	})
+}
+
+// readOnlyTransport refuses writes like credentials with read privileges
+// only, and answers searches with hits, or a missing index unless
+// ignore_unavailable is set
+type readOnlyTransport struct {
+	hits   string
+	writes []string
+}
+
+func (r *readOnlyTransport) Perform(req *http.Request) (*http.Response, error) {
+	status, body := http.StatusOK, `{"hits": {"hits": []}}`
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_search"):
+		if r.hits != "" {
+			body = `{"hits": {"hits": ` + r.hits + `}}`
+		} else if req.URL.Query().Get("ignore_unavailable") != "true" {
+			status, body = http.StatusNotFound, `{"error": {"type": "index_not_found_exception"}}`
+		}
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		status, body = http.StatusNotFound, `{"error": {"type": "index_not_found_exception"}}`
+	default:
+		r.writes = append(r.writes, req.Method+" "+req.URL.Path)
+		status, body = http.StatusForbidden, `{"error": {"type": "security_exception"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
@@ -0,0 +1,314 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ResizeKind is the index API a ResizeIndex call uses
+type ResizeKind string
+
+const (
+	// ResizeClone copies an index keeping its number of shards
+	ResizeClone ResizeKind = "clone"
+	// ResizeShrink copies an index into fewer shards, a factor of its own
+	ResizeShrink ResizeKind = "shrink"
+	// ResizeSplit copies an index into more shards, a multiple of its own
+	ResizeSplit ResizeKind = "split"
+)
+
+// ResizeConfig configures CloneIndex, ShrinkIndex, SplitIndex and ResizeIndex
+type ResizeConfig struct {
+	Source string
+	Target string
+	// Shards is the number of primary shards of Target. It is required to
+	// shrink or split and must be left unset, or match Source, to clone.
+	Shards int
+	// Settings are applied to Target, using flat keys such as
+	// "index.number_of_replicas"
+	Settings map[string]interface{}
+	// Node is the node a copy of every shard of Source is moved to before a
+	// shrink, the node holding most of its primaries by default
+	Node string
+	// WaitForStatus is the health Target must reach before the resize is done,
+	// "yellow" by default so its primaries are active
+	WaitForStatus string
+	// Timeout bounds each wait for shards to relocate or become healthy, five
+	// minutes by default
+	Timeout time.Duration
+	// Alias, when set, is moved atomically from Source to Target
+	Alias string
+	// DeleteSource removes Source once Target is healthy and the alias moved.
+	// Otherwise Source is writable again afterwards.
+	DeleteSource bool
+}
+
+// CloneIndex returns a migration copying Source into Target with the same
+// shards, e.g. to change creation-only settings of a large index without
+// reindexing. See ResizeIndex.
+func CloneIndex(description string, cfg ResizeConfig) Migration {
+	return resizeMigration(description, ResizeClone, cfg)
+}
+
+// ShrinkIndex returns a migration copying Source into Target with fewer
+// shards. See ResizeIndex.
+func ShrinkIndex(description string, cfg ResizeConfig) Migration {
+	return resizeMigration(description, ResizeShrink, cfg)
+}
+
+// SplitIndex returns a migration copying Source into Target with more
+// shards. See ResizeIndex.
+func SplitIndex(description string, cfg ResizeConfig) Migration {
+	return resizeMigration(description, ResizeSplit, cfg)
+}
+
+func resizeMigration(description string, kind ResizeKind, cfg ResizeConfig) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		return ResizeIndex(client, kind, cfg)
+	}).Declares(Resource{Kind: ResourceIndex, Name: cfg.Target})
+	if cfg.DeleteSource {
+		m = m.Removes(Resource{Kind: ResourceIndex, Name: cfg.Source})
+	}
+	m.validate = func() error {
+		return cfg.validate(kind)
+	}
+	return m
+}
+
+func (cfg ResizeConfig) validate(kind ResizeKind) error {
+	if cfg.Source == "" || cfg.Target == "" {
+		return fmt.Errorf("%s requires a source and a target index", kind)
+	}
+	switch kind {
+	case ResizeClone:
+	case ResizeShrink, ResizeSplit:
+		if cfg.Shards <= 0 {
+			return fmt.Errorf("%s of %s requires the number of target shards", kind, cfg.Source)
+		}
+	default:
+		return fmt.Errorf("unknown resize %q", kind)
+	}
+	if shards, ok := cfg.Settings["index.number_of_shards"]; ok {
+		return fmt.Errorf("%s of %s sets index.number_of_shards; use Shards instead (got %v)", kind, cfg.Source, shards)
+	}
+	return nil
+}
+
+// ResizeIndex copies Source into Target with the clone, shrink or split API.
+// It checks the target shards against those of Source, blocks writes to
+// Source as the APIs require and, for a shrink, first moves a copy of every
+// shard to one node. Target is created with Settings, without the block, and
+// awaited until it reaches WaitForStatus before Alias is moved. Source is
+// made writable again, or deleted with DeleteSource; a failed resize also
+// lifts the block.
+func ResizeIndex(client *elasticsearch.Client, kind ResizeKind, cfg ResizeConfig) error {
+	if err := cfg.validate(kind); err != nil {
+		return err
+	}
+	if cfg.WaitForStatus == "" {
+		cfg.WaitForStatus = "yellow"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+
+	settings, _, err := getIndexDefinition(client, cfg.Source)
+	if err != nil {
+		return err
+	}
+	shards, _ := strconv.Atoi(fmt.Sprint(settings["index.number_of_shards"]))
+	if err := checkResizeShards(kind, cfg, shards); err != nil {
+		return err
+	}
+
+	// restore lists the settings to reset on Source when done
+	restore := map[string]interface{}{"index.blocks.write": nil}
+	targetSettings := map[string]interface{}{"index.blocks.write": nil}
+	if kind == ResizeShrink {
+		node := cfg.Node
+		if node == "" {
+			if node, err = primaryNode(client, cfg.Source); err != nil {
+				return err
+			}
+		}
+		clientLogf(client)("Moving a copy of every shard of %s to %s", cfg.Source, node)
+		restore["index.routing.allocation.require._name"] = nil
+		targetSettings["index.routing.allocation.require._name"] = nil
+		if err := putSettings(client, cfg.Source, map[string]interface{}{"index.routing.allocation.require._name": node}); err != nil {
+			return err
+		}
+		if err := waitForIndexHealth(client, cfg.Source, "yellow", true, cfg.Timeout); err != nil {
+			putSettings(client, cfg.Source, restore)
+			return err
+		}
+	}
+
+	if err := putSettings(client, cfg.Source, map[string]interface{}{"index.blocks.write": true}); err != nil {
+		if kind == ResizeShrink {
+			putSettings(client, cfg.Source, restore)
+		}
+		return err
+	}
+	if err := resize(client, kind, cfg, targetSettings); err != nil {
+		if restoreErr := putSettings(client, cfg.Source, restore); restoreErr != nil {
+			return fmt.Errorf("%w; %v", err, restoreErr)
+		}
+		return err
+	}
+
+	if cfg.Alias != "" {
+		if err := swapAlias(client, cfg.Alias, cfg.Source, cfg.Target); err != nil {
+			return err
+		}
+	}
+
+	if cfg.DeleteSource {
+		res, err := client.Indices.Delete([]string{cfg.Source})
+		if err != nil {
+			return fmt.Errorf("error deleting index %s: %w", cfg.Source, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error deleting index %s: %s", cfg.Source, res.String())
+		}
+		return nil
+	}
+	return putSettings(client, cfg.Source, restore)
+}
+
+// checkResizeShards checks the target shards of a resize against the shards
+// of its source
+func checkResizeShards(kind ResizeKind, cfg ResizeConfig, source int) error {
+	if source <= 0 {
+		return fmt.Errorf("error reading the number of shards of %s", cfg.Source)
+	}
+	switch kind {
+	case ResizeClone:
+		if cfg.Shards != 0 && cfg.Shards != source {
+			return fmt.Errorf("clone of %s keeps its %d shards; shrink or split it to get %d", cfg.Source, source, cfg.Shards)
+		}
+	case ResizeShrink:
+		if cfg.Shards >= source || source%cfg.Shards != 0 {
+			return fmt.Errorf("cannot shrink %s from %d to %d shards; the target must have fewer shards and be a factor of %d", cfg.Source, source, cfg.Shards, source)
+		}
+	case ResizeSplit:
+		if cfg.Shards <= source || cfg.Shards%source != 0 {
+			return fmt.Errorf("cannot split %s from %d to %d shards; the target must have more shards and be a multiple of %d", cfg.Source, source, cfg.Shards, source)
+		}
+	}
+	return nil
+}
+
+// resize calls the resize API and waits for the target to become healthy
+func resize(client *elasticsearch.Client, kind ResizeKind, cfg ResizeConfig, settings map[string]interface{}) error {
+	for key, value := range cfg.Settings {
+		settings[normalizeSettingKey(key)] = value
+	}
+	if kind != ResizeClone {
+		settings["index.number_of_shards"] = cfg.Shards
+	}
+	body, err := json.Marshal(map[string]interface{}{"settings": settings})
+	if err != nil {
+		return fmt.Errorf("error marshaling %s of %s: %w", kind, cfg.Source, err)
+	}
+
+	clientLogf(client)("Resizing %s into %s (%s)", cfg.Source, cfg.Target, kind)
+	var res *esapi.Response
+	switch kind {
+	case ResizeClone:
+		res, err = client.Indices.Clone(cfg.Source, cfg.Target, client.Indices.Clone.WithBody(strings.NewReader(string(body))))
+	case ResizeShrink:
+		res, err = client.Indices.Shrink(cfg.Source, cfg.Target, client.Indices.Shrink.WithBody(strings.NewReader(string(body))))
+	case ResizeSplit:
+		res, err = client.Indices.Split(cfg.Source, cfg.Target, client.Indices.Split.WithBody(strings.NewReader(string(body))))
+	}
+	if err != nil {
+		return fmt.Errorf("error resizing %s into %s: %w", cfg.Source, cfg.Target, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error resizing %s into %s: %s", cfg.Source, cfg.Target, res.String())
+	}
+
+	return waitForIndexHealth(client, cfg.Target, cfg.WaitForStatus, false, cfg.Timeout)
+}
+
+// waitForIndexHealth waits until index reaches status and, with relocated,
+// until none of the cluster's shards are relocating
+func waitForIndexHealth(client *elasticsearch.Client, index, status string, relocated bool, timeout time.Duration) error {
+	opts := []func(*esapi.ClusterHealthRequest){
+		client.Cluster.Health.WithIndex(index),
+		client.Cluster.Health.WithWaitForStatus(status),
+		client.Cluster.Health.WithTimeout(timeout),
+	}
+	if relocated {
+		opts = append(opts, client.Cluster.Health.WithWaitForNoRelocatingShards(true))
+	}
+	res, err := client.Cluster.Health(opts...)
+	if err != nil {
+		return fmt.Errorf("error waiting for %s to be %s: %w", index, status, err)
+	}
+	defer res.Body.Close()
+	// 408 is returned when the wait times out
+	if res.IsError() && res.StatusCode != 408 {
+		return fmt.Errorf("error waiting for %s to be %s: %s", index, status, res.String())
+	}
+
+	var result struct {
+		Status   string `json:"status"`
+		TimedOut bool   `json:"timed_out"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error parsing health of %s: %w", index, err)
+	}
+	if result.TimedOut {
+		return fmt.Errorf("%s is %s after %s, want %s", index, result.Status, timeout, status)
+	}
+	return nil
+}
+
+// primaryNode returns the node holding most primary shards of index
+func primaryNode(client *elasticsearch.Client, index string) (string, error) {
+	res, err := client.Cat.Shards(
+		client.Cat.Shards.WithIndex(index),
+		client.Cat.Shards.WithFormat("json"),
+		client.Cat.Shards.WithH("prirep", "state", "node"),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error reading shards of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("error reading shards of %s: %s", index, res.String())
+	}
+
+	var rows []struct {
+		Prirep string `json:"prirep"`
+		State  string `json:"state"`
+		Node   string `json:"node"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+		return "", fmt.Errorf("error parsing shards of %s: %w", index, err)
+	}
+	counts := map[string]int{}
+	var node string
+	for _, row := range rows {
+		if row.Prirep != "p" || row.State != "STARTED" || row.Node == "" {
+			continue
+		}
+		counts[row.Node]++
+		if counts[row.Node] > counts[node] || (counts[row.Node] == counts[node] && row.Node < node) {
+			node = row.Node
+		}
+	}
+	if node == "" {
+		return "", fmt.Errorf("no started primary shard of %s to shrink on", index)
+	}
+	return node, nil
+}
@@ -0,0 +1,91 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestResizeIndex(t *testing.T) {
+	newTransport := func(shards int) *routeTransport {
+		return &routeTransport{routes: map[string]string{
+			"GET /logs":                  fmt.Sprintf(`{"logs": {"settings": {"index.number_of_shards": "%d", "index.uuid": "x"}, "mappings": {}}}`, shards),
+			"GET /_cat/shards/logs":      `[{"prirep": "p", "state": "STARTED", "node": "node-2"}, {"prirep": "p", "state": "STARTED", "node": "node-1"}, {"prirep": "p", "state": "STARTED", "node": "node-2"}, {"prirep": "r", "state": "STARTED", "node": "node-1"}]`,
+			"GET /_cluster/health/logs":  `{"status": "green", "timed_out": false}`,
+			"GET /_cluster/health/small": `{"status": "yellow", "timed_out": false}`,
+		}}
+	}
+
+	t.Run("Test Shrink Moves Shards And Blocks Writes", func(t *testing.T) {
+		transport := newTransport(4)
+		err := ResizeIndex(ClientFromTransport(transport), ResizeShrink, ResizeConfig{Source: "logs", Target: "small", Shards: 2, Alias: "logs-read"})
+		if err != nil {
+			t.Fatalf("Failed to shrink: %v", err)
+		}
+		expected := []string{
+			"GET /logs",
+			"GET /_cat/shards/logs",
+			"PUT /logs/_settings",
+			"GET /_cluster/health/logs",
+			"PUT /logs/_settings",
+			"PUT /logs/_shrink/small",
+			"GET /_cluster/health/small",
+			"POST /_aliases",
+			"PUT /logs/_settings",
+		}
+		if strings.Join(transport.requests, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Expected requests\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(transport.requests, "\n"))
+		}
+	})
+
+	t.Run("Test Timed Out Target Fails", func(t *testing.T) {
+		transport := newTransport(2)
+		transport.routes["GET /_cluster/health/small"] = `{"status": "red", "timed_out": true}`
+		err := ResizeIndex(ClientFromTransport(transport), ResizeSplit, ResizeConfig{Source: "logs", Target: "small", Shards: 4})
+		if err == nil || !strings.Contains(err.Error(), "small is red") {
+			t.Fatalf("Expected a health timeout, got %v", err)
+		}
+		if last := transport.requests[len(transport.requests)-1]; last != "PUT /logs/_settings" {
+			t.Errorf("Expected the write block lifted, last request %s", last)
+		}
+	})
+
+	t.Run("Test Shard Counts Are Checked", func(t *testing.T) {
+		for _, tc := range []struct {
+			kind   ResizeKind
+			shards int
+			want   string
+		}{
+			{ResizeShrink, 3, "factor of 4"},
+			{ResizeSplit, 6, "multiple of 4"},
+			{ResizeClone, 8, "keeps its 4 shards"},
+		} {
+			transport := newTransport(4)
+			err := ResizeIndex(ClientFromTransport(transport), tc.kind, ResizeConfig{Source: "logs", Target: "resized", Shards: tc.shards})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected %s to %d shards to fail with %q, got %v", tc.kind, tc.shards, tc.want, err)
+			}
+			if len(transport.requests) != 1 {
+				t.Errorf("Expected nothing changed, got %v", transport.requests)
+			}
+		}
+	})
+
+	t.Run("Test Missing Shards Fail Validation", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		ran := false
+		mm.Register(NewMigration("Runs first", func(client *elasticsearch.Client) error {
+			ran = true
+			return nil
+		}))
+		mm.Register(ShrinkIndex("Shrink logs", ResizeConfig{Source: "logs", Target: "small"}))
+		if err := mm.RunMigrations(); err == nil || !strings.Contains(err.Error(), "number of target shards") {
+			t.Fatalf("Expected a validation error, got %v", err)
+		}
+		if ran {
+			t.Error("Expected no migration to run")
+		}
+	})
+}
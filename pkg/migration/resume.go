@@ -0,0 +1,164 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// taskEndpoints are the APIs whose tasks are recorded for resumption when
+// submitted without waiting for completion
+var taskEndpoints = []string{"/_reindex", "/_update_by_query", "/_delete_by_query"}
+
+// resumeTransport records the tasks a migration submits in a TaskStore. When
+// the migration was interrupted while its task ran, the resubmission of that
+// task is answered with the recorded task instead, so the migration waits for
+// the running copy rather than starting it from scratch.
+type resumeTransport struct {
+	next    esapi.Transport
+	store   TaskStore
+	version string
+	// resume is the task recorded by an earlier run, until it is re-attached
+	resume *MigrationTask
+	logf   func(format string, v ...interface{})
+}
+
+func (t *resumeTransport) Perform(req *http.Request) (*http.Response, error) {
+	if t.resume != nil && t.resume.Index != "" && req.Method == http.MethodPut && req.URL.Path == "/"+t.resume.Index {
+		return t.recreate(req)
+	}
+	if !isTaskSubmission(req) {
+		return t.next.Perform(req)
+	}
+
+	request := req.Method + " " + req.URL.Path
+	if t.resume != nil && t.resume.Request == request {
+		task := t.resume
+		t.resume = nil
+		if t.resumable(task.TaskID) {
+			t.logf("Resuming task %s of migration %s started at %s", task.TaskID, t.version, task.StartedAt.Format(time.RFC3339))
+			return jsonResponse(http.StatusOK, `{"task": "`+task.TaskID+`"}`), nil
+		}
+	}
+
+	var body []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	res, err := t.next.Perform(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return res, nil
+	}
+
+	var submitted struct {
+		Task string `json:"task"`
+	}
+	if json.Unmarshal(data, &submitted) != nil || submitted.Task == "" {
+		return res, nil
+	}
+	var reindex struct {
+		Dest struct {
+			Index string `json:"index"`
+		} `json:"dest"`
+	}
+	json.Unmarshal(body, &reindex)
+	task := MigrationTask{
+		Version:   t.version,
+		TaskID:    submitted.Task,
+		Request:   request,
+		Index:     reindex.Dest.Index,
+		StartedAt: time.Now().UTC(),
+	}
+	if err := t.store.RecordTask(task); err != nil {
+		t.logf("Failed to record task %s of migration %s: %v", task.TaskID, t.version, err)
+	}
+	return res, nil
+}
+
+// recreate creates the destination index of the task being resumed, which
+// the interrupted run created already
+func (t *resumeTransport) recreate(req *http.Request) (*http.Response, error) {
+	res, err := t.next.Perform(req)
+	if err != nil || res.StatusCode != http.StatusBadRequest {
+		return res, err
+	}
+	data, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !bytes.Contains(data, []byte("resource_already_exists_exception")) {
+		res.Body = io.NopCloser(bytes.NewReader(data))
+		return res, nil
+	}
+	return jsonResponse(http.StatusOK, `{"acknowledged": true, "index": "`+t.resume.Index+`"}`), nil
+}
+
+// resumable reports whether a task is still running or has completed
+// without failures, so waiting for it completes the migration
+func (t *resumeTransport) resumable(id string) bool {
+	client := ClientFromTransport(t.next)
+	res, err := client.Tasks.Get(id)
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return false
+	}
+
+	var status struct {
+		Completed bool            `json:"completed"`
+		Error     json.RawMessage `json:"error"`
+		Response  struct {
+			Failures []json.RawMessage `json:"failures"`
+			Canceled string            `json:"canceled"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return false
+	}
+	if !status.Completed {
+		return true
+	}
+	return len(status.Error) == 0 && len(status.Response.Failures) == 0 && status.Response.Canceled == ""
+}
+
+// isTaskSubmission reports whether req starts a task without waiting for it
+func isTaskSubmission(req *http.Request) bool {
+	if req.Method != http.MethodPost || req.URL.Query().Get("wait_for_completion") != "false" {
+		return false
+	}
+	for _, endpoint := range taskEndpoints {
+		if strings.HasSuffix(req.URL.Path, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}, "X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
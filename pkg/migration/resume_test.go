@@ -0,0 +1,75 @@
+package migration
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResumeTasks(t *testing.T) {
+	interval := taskPollInterval
+	taskPollInterval = time.Millisecond
+	defer func() { taskPollInterval = interval }()
+
+	newMigration := func() Migration {
+		return UpdateByQuery("articles", `{"term": {"status": "draft"}}`, "ctx._source.status = 'review'")
+	}
+
+	t.Run("Test Submitted Tasks Are Recorded", func(t *testing.T) {
+		store := NewMemoryStore()
+		transport := &taskTransport{polls: 1, response: `{"total": 5, "updated": 3, "failures": [{"cause": "mapper_parsing_exception"}]}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store))
+		m := newMigration()
+		mm.Register(m)
+
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected the failed task to fail the migration")
+		}
+		task, _ := store.GetTask(m.Version())
+		if task == nil || task.TaskID != "node-1:42" || task.Request != "POST /articles/_update_by_query" {
+			t.Errorf("Expected the task recorded, got %+v", task)
+		}
+	})
+
+	t.Run("Test Interrupted Task Is Resumed", func(t *testing.T) {
+		store := NewMemoryStore()
+		m := newMigration()
+		store.RecordTask(MigrationTask{
+			Version:   m.Version(),
+			TaskID:    "node-1:42",
+			Request:   "POST /articles/_update_by_query",
+			StartedAt: time.Now().Add(-time.Hour),
+		})
+		transport := &taskTransport{polls: 3, response: `{"total": 5, "updated": 5}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store))
+		mm.Register(m)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if transport.submitted != "" {
+			t.Errorf("Expected the running task to be re-attached, got submission %s", transport.submitted)
+		}
+		if task, _ := store.GetTask(m.Version()); task != nil {
+			t.Errorf("Expected the task removed once applied, got %+v", task)
+		}
+		if applied, _ := mm.GetAppliedMigrations(); !applied[m.Version()] {
+			t.Error("Expected the migration applied")
+		}
+	})
+
+	t.Run("Test Task Of Another Request Is Not Resumed", func(t *testing.T) {
+		store := NewMemoryStore()
+		m := newMigration()
+		store.RecordTask(MigrationTask{Version: m.Version(), TaskID: "node-1:42", Request: "POST /_reindex"})
+		transport := &taskTransport{polls: 1, response: `{"total": 5, "updated": 5}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store))
+		mm.Register(m)
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if transport.submitted == "" {
+			t.Error("Expected the task to be submitted again")
+		}
+	})
+}
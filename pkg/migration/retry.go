@@ -0,0 +1,167 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// RetryPolicy controls how requests rejected by an overloaded cluster are
+// retried. Zero fields take their defaults.
+type RetryPolicy struct {
+	// MaxRetries bounds the retries of a request, 3 by default. A negative
+	// value disables retries.
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, 500ms by default. It
+	// doubles with each retry up to MaxBackoff, 30s by default.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Statuses are the response statuses retried, by default 429, 502, 503
+	// and 504
+	Statuses []int
+}
+
+// WithRetry sets how the manager retries requests answered with 429 or 503,
+// or failed by a network error, while tracking migrations and running
+// migrations. Requests are retried with the default policy otherwise.
+func WithRetry(policy RetryPolicy) Option {
+	return func(mm *MigrationManager) {
+		mm.retry = &policy
+	}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxRetries == 0 {
+		p.MaxRetries = 3
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = 500 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.Statuses == nil {
+		p.Statuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	return p
+}
+
+// backoff returns the wait before retry attempt, counted from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff << (attempt - 1)
+	if wait > p.MaxBackoff || wait <= 0 {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// client returns the manager's client, retrying requests under its policy
+func (mm *MigrationManager) client() *elasticsearch.Client {
+	if mm.Client == nil {
+		return nil
+	}
+	return ClientFromTransport(mm.retryTransport(mm.Client.Transport))
+}
+
+func (mm *MigrationManager) retryTransport(next esapi.Transport) esapi.Transport {
+	policy := RetryPolicy{}
+	if mm.retry != nil {
+		policy = *mm.retry
+	}
+	policy = policy.withDefaults()
+	if policy.MaxRetries < 0 {
+		return next
+	}
+	return &retryTransport{next: next, policy: policy, logf: mm.logf}
+}
+
+// retryTransport retries requests rejected by an overloaded cluster or failed
+// by the network, waiting between attempts until the request context is done
+type retryTransport struct {
+	next   esapi.Transport
+	policy RetryPolicy
+	logf   func(format string, v ...interface{})
+}
+
+func (r *retryTransport) Perform(req *http.Request) (*http.Response, error) {
+	// the body is replayed on every attempt
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+
+		res, err := r.next.Perform(req)
+		if attempt >= r.policy.MaxRetries || !r.retryable(req, res, err) {
+			return res, err
+		}
+
+		wait := r.policy.backoff(attempt + 1)
+		if err != nil {
+			r.logf("Retrying %s %s in %s: %v", req.Method, req.URL.Path, wait, err)
+		} else {
+			if after := retryAfter(res); after > 0 && after < r.policy.MaxBackoff {
+				wait = after
+			}
+			r.logf("Retrying %s %s in %s: status %d", req.Method, req.URL.Path, wait, res.StatusCode)
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		if !sleepContext(req.Context(), wait) {
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (r *retryTransport) retryable(req *http.Request, res *http.Response, err error) bool {
+	if err != nil {
+		// a request cut off by the network may have been applied, so only
+		// requests safe to repeat are retried
+		return req.Context().Err() == nil &&
+			!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+			idempotent(req.Method)
+	}
+	for _, status := range r.policy.Statuses {
+		if res.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the wait a response asks for in its Retry-After header
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,109 @@
+package migration
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyTransport rejects the first requests to key, then answers 200
+type flakyTransport struct {
+	key        string
+	rejections int
+	status     int
+	err        error
+
+	requests []string
+	bodies   []string
+}
+
+func (f *flakyTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}
+
+	status := 200
+	if key := req.Method + " " + req.URL.Path; key == f.key {
+		f.requests = append(f.requests, key)
+		f.bodies = append(f.bodies, body)
+		if len(f.requests) <= f.rejections {
+			if f.err != nil {
+				return nil, f.err
+			}
+			status = f.status
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	mapping := `{"properties": {"tags": {"type": "keyword"}}}`
+
+	t.Run("Test Overloaded Requests Are Retried", func(t *testing.T) {
+		transport := &flakyTransport{key: "PUT /articles/_mapping", rejections: 2, status: http.StatusTooManyRequests}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithRetry(policy), WithAllowBreakingChanges())
+		mm.Register(PutMapping("Add tags to articles", "articles", mapping))
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if len(transport.requests) != 3 {
+			t.Fatalf("Expected the mapping to be sent 3 times, got %v", transport.requests)
+		}
+		for _, body := range transport.bodies {
+			if body != mapping {
+				t.Errorf("Expected every attempt to send the mapping, got %q", body)
+			}
+		}
+	})
+
+	t.Run("Test Retries Are Bounded", func(t *testing.T) {
+		transport := &flakyTransport{key: "PUT /articles/_mapping", rejections: 10, status: http.StatusServiceUnavailable}
+		limited := policy
+		limited.MaxRetries = 2
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithRetry(limited), WithAllowBreakingChanges())
+		mm.Register(PutMapping("Add tags to articles", "articles", mapping))
+
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected the migration to fail")
+		}
+		if len(transport.requests) != 3 {
+			t.Errorf("Expected 3 attempts, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Tracking Reads Are Retried", func(t *testing.T) {
+		transport := &flakyTransport{key: "HEAD /.elasticmate_migrations", rejections: 1, err: errors.New("connection reset by peer")}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithRetry(policy))
+
+		if _, err := mm.GetAppliedMigrations(); err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+		if len(transport.requests) != 2 {
+			t.Errorf("Expected the failed request to be repeated, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Retries Can Be Disabled", func(t *testing.T) {
+		transport := &flakyTransport{key: "PUT /articles/_mapping", rejections: 1, status: http.StatusTooManyRequests}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithRetry(RetryPolicy{MaxRetries: -1}), WithAllowBreakingChanges())
+		mm.Register(PutMapping("Add tags to articles", "articles", mapping))
+
+		if err := mm.RunMigrations(); err == nil {
+			t.Fatal("Expected the migration to fail")
+		}
+		if len(transport.requests) != 1 {
+			t.Errorf("Expected a single attempt, got %v", transport.requests)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package migration
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// WithDown sets the function that reverts the migration on Rollback
+func (m Migration) WithDown(down func(client *elasticsearch.Client) error) Migration {
+	m.DownFunc = down
+	return m
+}
+
+// HasDown reports whether the migration can be rolled back
+func (m Migration) HasDown() bool {
+	return m.DownFunc != nil || m.restore != nil
+}
+
+// Rollback reverts an applied migration and removes its record so it is
+// pending again. Migrations that captured state when they were applied are
+// reverted from the state in their record.
+func (mm *MigrationManager) Rollback(version string) error {
+	if !mm.disableLock {
+		unlock, err := mm.lock("")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	migrations, err := mm.registered()
+	if err != nil {
+		return err
+	}
+	var migration *Migration
+	for i := range migrations {
+		if migrations[i].Version() == version {
+			migration = &migrations[i]
+			break
+		}
+	}
+	if migration == nil {
+		return fmt.Errorf("migration %s is not registered", version)
+	}
+	if !migration.HasDown() {
+		return fmt.Errorf("migration %s has no down function", version)
+	}
+
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return err
+	}
+	var record *MigrationRecord
+	for i := range records {
+		if records[i].Version == version {
+			record = &records[i]
+		}
+	}
+	if record == nil {
+		return fmt.Errorf("migration %s is not applied", version)
+	}
+
+	mm.logf("Rolling back migration %s: %s", version, migration.Description)
+
+	if migration.restore != nil {
+		if len(record.State) == 0 {
+			return fmt.Errorf("migration %s has no recorded state to roll back from; the version store may not keep it", version)
+		}
+		err = migration.restore(mm.client(), record.State)
+	} else {
+		err = migration.DownFunc(mm.client())
+	}
+	event := AuditEvent{
+		Action:      AuditRollback,
+		RunID:       record.RunID,
+		Version:     version,
+		Description: migration.Description,
+		Destructive: migration.IsDestructive(),
+		Indices:     record.Indices,
+	}
+	if err != nil {
+		event.Action, event.Error = AuditRollbackFailure, err.Error()
+		mm.audit(event)
+		return fmt.Errorf("failed to roll back migration %s: %w", version, err)
+	}
+	mm.audit(event)
+
+	if err := mm.store().Remove(version); err != nil {
+		return err
+	}
+	if store, ok := mm.store().(RollbackStore); ok {
+		rollback := MigrationRollback{Record: *record, RolledBackAt: time.Now(), RunnerMetadata: mm.runnerMetadata()}
+		if err := store.RecordRollback(rollback); err != nil {
+			mm.logf("Failed to record rollback of migration %s: %v", version, err)
+		}
+	}
+
+	mm.logf("Migration %s rolled back", version)
+	return nil
+}
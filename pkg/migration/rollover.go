@@ -0,0 +1,178 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// RolloverConditions are the conditions of a rollover, any of which rolls
+// the alias over. A rollover without conditions always rolls over.
+type RolloverConditions struct {
+	// MaxAge is the age of the write index, e.g. "30d"
+	MaxAge string `json:"max_age,omitempty"`
+	// MaxDocs is the number of documents of the write index
+	MaxDocs int64 `json:"max_docs,omitempty"`
+	// MaxPrimaryShardSize is the size of its largest primary shard, e.g. "50gb"
+	MaxPrimaryShardSize string `json:"max_primary_shard_size,omitempty"`
+	// MaxPrimaryShardDocs is the number of documents of its largest primary
+	// shard
+	MaxPrimaryShardDocs int64 `json:"max_primary_shard_docs,omitempty"`
+	// MaxSize is the size of all its primary shards
+	MaxSize string `json:"max_size,omitempty"`
+}
+
+func (c RolloverConditions) empty() bool {
+	return c == RolloverConditions{}
+}
+
+func (c RolloverConditions) String() string {
+	var conditions []string
+	for _, condition := range []struct {
+		name  string
+		value interface{}
+		set   bool
+	}{
+		{"max_age", c.MaxAge, c.MaxAge != ""},
+		{"max_docs", c.MaxDocs, c.MaxDocs > 0},
+		{"max_primary_shard_size", c.MaxPrimaryShardSize, c.MaxPrimaryShardSize != ""},
+		{"max_primary_shard_docs", c.MaxPrimaryShardDocs, c.MaxPrimaryShardDocs > 0},
+		{"max_size", c.MaxSize, c.MaxSize != ""},
+	} {
+		if condition.set {
+			conditions = append(conditions, fmt.Sprintf("%s %v", condition.name, condition.value))
+		}
+	}
+	if len(conditions) == 0 {
+		return "unconditionally"
+	}
+	return "on " + strings.Join(conditions, " or ")
+}
+
+// RolloverResult is the outcome of RolloverIndex
+type RolloverResult struct {
+	OldIndex   string `json:"old_index"`
+	NewIndex   string `json:"new_index"`
+	RolledOver bool   `json:"rolled_over"`
+	// Conditions reports which conditions were met
+	Conditions map[string]bool `json:"conditions"`
+}
+
+// Rollover returns a migration rolling alias over to a new write index when
+// any of conditions is met. Unmet conditions leave the alias in place, and
+// the migration is still recorded as applied.
+func Rollover(alias string, conditions RolloverConditions) Migration {
+	description := fmt.Sprintf("Roll over %s %s", alias, conditions)
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		result, err := RolloverIndex(client, alias, conditions)
+		if err != nil {
+			return err
+		}
+		if result.RolledOver {
+			clientLogf(client)("Rolled %s over from %s to %s", alias, result.OldIndex, result.NewIndex)
+		} else {
+			clientLogf(client)("Kept %s on %s: rollover conditions not met", alias, result.OldIndex)
+		}
+		return nil
+	})
+	m.validate = func() error {
+		if alias == "" {
+			return fmt.Errorf("rollover requires an alias")
+		}
+		return nil
+	}
+	return m
+}
+
+// RolloverIndex rolls alias over to a new write index when any of
+// conditions is met
+func RolloverIndex(client *elasticsearch.Client, alias string, conditions RolloverConditions) (*RolloverResult, error) {
+	var opts []func(*esapi.IndicesRolloverRequest)
+	if !conditions.empty() {
+		body, err := json.Marshal(map[string]interface{}{"conditions": conditions})
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling rollover of %s: %w", alias, err)
+		}
+		opts = append(opts, client.Indices.Rollover.WithBody(strings.NewReader(string(body))))
+	}
+	res, err := client.Indices.Rollover(alias, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error rolling over %s: %w", alias, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error rolling over %s: %s", alias, res.String())
+	}
+
+	var result RolloverResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing rollover of %s: %w", alias, err)
+	}
+	return &result, nil
+}
+
+// WriteIndexConfig configures CreateWriteIndex
+type WriteIndexConfig struct {
+	// Index is the first write index, "<alias>-000001" by default. Rollovers
+	// increment its number, so it must end in one.
+	Index string
+	// Settings and Mappings are the definition of the index; indices created
+	// by rollovers take theirs from index templates
+	Settings map[string]interface{}
+	Mappings string
+}
+
+// rolloverIndexPattern matches index names rollovers can increment
+var rolloverIndexPattern = regexp.MustCompile(`-\d+$`)
+
+// CreateWriteIndex returns a migration bootstrapping time-based indices:
+// it creates the first index behind alias, marked as its write index, so
+// Rollover and index lifecycle policies can roll it over. It is skipped when
+// alias exists.
+func CreateWriteIndex(alias string, cfg WriteIndexConfig) Migration {
+	if cfg.Index == "" {
+		cfg.Index = alias + "-000001"
+	}
+	m := NewMigration(fmt.Sprintf("Create write index %s for %s", cfg.Index, alias), func(client *elasticsearch.Client) error {
+		definition := map[string]interface{}{
+			"aliases": map[string]interface{}{alias: map[string]interface{}{"is_write_index": true}},
+		}
+		if len(cfg.Settings) > 0 {
+			definition["settings"] = cfg.Settings
+		}
+		if cfg.Mappings != "" {
+			definition["mappings"] = json.RawMessage(cfg.Mappings)
+		}
+		body, err := json.Marshal(definition)
+		if err != nil {
+			return fmt.Errorf("error marshaling index %s: %w", cfg.Index, err)
+		}
+
+		res, err := client.Indices.Create(cfg.Index, client.Indices.Create.WithBody(strings.NewReader(string(body))))
+		if err != nil {
+			return fmt.Errorf("error creating index %s: %w", cfg.Index, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error creating index %s: %s", cfg.Index, res.String())
+		}
+		return nil
+	}).WithGuard(SkipIfIndexExists(alias)).Declares(Resource{Kind: ResourceIndex, Name: alias})
+	m.validate = func() error {
+		if alias == "" {
+			return fmt.Errorf("write index requires an alias")
+		}
+		if !rolloverIndexPattern.MatchString(cfg.Index) {
+			return fmt.Errorf("write index %s of %s must end in a number, e.g. %s-000001, to be rolled over", cfg.Index, alias, cfg.Index)
+		}
+		if cfg.Mappings != "" && !json.Valid([]byte(cfg.Mappings)) {
+			return fmt.Errorf("mappings of write index %s are not valid JSON", cfg.Index)
+		}
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,53 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRollover(t *testing.T) {
+	t.Run("Test Write Index Is Created Once", func(t *testing.T) {
+		transport := &routeTransport{}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+		mm.Register(CreateWriteIndex("logs", WriteIndexConfig{Mappings: `{"properties": {"@timestamp": {"type": "date"}}}`}))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !transport.sent("PUT /logs-000001") {
+			t.Errorf("Expected logs-000001 created, got %v", transport.requests)
+		}
+
+		transport = &routeTransport{routes: map[string]string{"HEAD /logs": ``}}
+		m := CreateWriteIndex("logs", WriteIndexConfig{})
+		if err := m.UpFunc(ClientFromTransport(transport)); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		if transport.sent("PUT /logs-000001") {
+			t.Error("Expected an existing alias skipped")
+		}
+	})
+
+	t.Run("Test Write Index Must Be Numbered", func(t *testing.T) {
+		err := CreateWriteIndex("logs", WriteIndexConfig{Index: "logs-current"}).Validate()
+		if err == nil || !strings.Contains(err.Error(), "must end in a number") {
+			t.Errorf("Expected a numbering error, got %v", err)
+		}
+	})
+
+	t.Run("Test Rollover Sends Conditions", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{
+			"POST /logs/_rollover": `{"old_index": "logs-000001", "new_index": "logs-000002", "rolled_over": true, "conditions": {"[max_age: 30d]": true}}`,
+		}}
+		m := Rollover("logs", RolloverConditions{MaxAge: "30d", MaxPrimaryShardSize: "50gb"})
+		if m.Description != "Roll over logs on max_age 30d or max_primary_shard_size 50gb" {
+			t.Errorf("Unexpected description %q", m.Description)
+		}
+		result, err := RolloverIndex(ClientFromTransport(transport), "logs", RolloverConditions{MaxAge: "30d"})
+		if err != nil {
+			t.Fatalf("Failed to roll over: %v", err)
+		}
+		if !result.RolledOver || result.NewIndex != "logs-000002" {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package migration
+
+import (
+	"os"
+	"os/user"
+	"runtime/debug"
+	"strings"
+)
+
+// RunnerMetadata identifies who applied a migration and from which build. It
+// is stored with each migration record.
+type RunnerMetadata struct {
+	Hostname   string `json:"hostname,omitempty"`
+	User       string `json:"user,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	GitSHA     string `json:"git_sha,omitempty"`
+}
+
+// MetadataProvider returns the runner metadata of a run. It is called once at
+// the start of each run.
+type MetadataProvider func() RunnerMetadata
+
+// WithMetadataProvider replaces DefaultMetadata, for example to report the
+// release version and commit injected by a deployment pipeline
+func WithMetadataProvider(provider MetadataProvider) Option {
+	return func(mm *MigrationManager) {
+		mm.metadata = provider
+	}
+}
+
+// DefaultMetadata reports the hostname, the current user and the main module
+// version and VCS revision embedded in the binary by the Go toolchain
+func DefaultMetadata() RunnerMetadata {
+	var metadata RunnerMetadata
+	metadata.Hostname, _ = os.Hostname()
+	if current, err := user.Current(); err == nil {
+		metadata.User = current.Username
+	} else {
+		metadata.User = os.Getenv("USER")
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version := info.Main.Version; version != "" && version != "(devel)" {
+			metadata.AppVersion = version
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				metadata.GitSHA = setting.Value
+			}
+		}
+	}
+	return metadata
+}
+
+// describe describes the runner, e.g. "by deploy@ci-7 from v1.4.0 (3fa2c1d)".
+// It is not a String method so records embedding the metadata still print
+// in full.
+func (m RunnerMetadata) describe() string {
+	var parts []string
+	if m.User != "" || m.Hostname != "" {
+		by := m.User
+		if m.Hostname != "" {
+			by += "@" + m.Hostname
+		}
+		parts = append(parts, "by "+strings.TrimPrefix(by, "@"))
+	}
+	if m.AppVersion != "" {
+		parts = append(parts, "from "+m.AppVersion)
+	}
+	if m.GitSHA != "" {
+		sha := m.GitSHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		parts = append(parts, "("+sha+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+func (mm *MigrationManager) runnerMetadata() RunnerMetadata {
+	if mm.metadata == nil {
+		return DefaultMetadata()
+	}
+	return mm.metadata()
+}
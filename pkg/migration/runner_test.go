@@ -0,0 +1,29 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestRunnerMetadata(t *testing.T) {
+	store := NewMemoryStore()
+	mm := NewMigrationManager(nil, WithStore(store), WithMetadataProvider(func() RunnerMetadata {
+		return RunnerMetadata{Hostname: "ci-7", User: "deploy", AppVersion: "v1.4.0", GitSHA: "3fa2c1d0e9b8a7f6"}
+	}))
+	mm.Register(NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil }))
+	if err := mm.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	records, _ := store.GetApplied()
+	if len(records) != 1 || records[0].Hostname != "ci-7" || records[0].GitSHA != "3fa2c1d0e9b8a7f6" {
+		t.Fatalf("Expected the record to carry the runner metadata, got %+v", records)
+	}
+
+	changelog, _ := mm.Changelog()
+	if !strings.Contains(changelog, "by deploy@ci-7 from v1.4.0 (3fa2c1d).") {
+		t.Errorf("Expected the changelog to name the runner, got:\n%s", changelog)
+	}
+}
@@ -0,0 +1,254 @@
+package migration
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Bucket implements ObjectBucket for S3 and S3-compatible services (Google
+// Cloud Storage interoperability mode, MinIO, R2) using path-style requests
+// signed with AWS Signature Version 4
+type S3Bucket struct {
+	Endpoint        string // e.g. https://s3.eu-west-1.amazonaws.com or https://storage.googleapis.com
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Optional, for temporary credentials
+
+	HTTPClient *http.Client
+}
+
+func (b *S3Bucket) Get(key string) ([]byte, string, error) {
+	res, err := b.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, "", ErrObjectNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, "", s3Error(res)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading object %s: %w", key, err)
+	}
+
+	return data, res.Header.Get("ETag"), nil
+}
+
+func (b *S3Bucket) Put(key string, data []byte, ifRevision string) (string, error) {
+	headers := map[string]string{"Content-Type": "application/json"}
+	if ifRevision == "" {
+		headers["If-None-Match"] = "*"
+	} else {
+		headers["If-Match"] = ifRevision
+	}
+
+	res, err := b.do(http.MethodPut, key, data, headers)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	// S3 answers 409 when a concurrent conditional write wins the race
+	if res.StatusCode == http.StatusPreconditionFailed || res.StatusCode == http.StatusConflict {
+		return "", ErrPreconditionFailed
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", s3Error(res)
+	}
+
+	return res.Header.Get("ETag"), nil
+}
+
+func (b *S3Bucket) Delete(key string, ifRevision string) error {
+	var headers map[string]string
+	if ifRevision != "" {
+		headers = map[string]string{"If-Match": ifRevision}
+	}
+
+	res, err := b.do(http.MethodDelete, key, nil, headers)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPreconditionFailed || (ifRevision != "" && res.StatusCode == http.StatusNotFound) {
+		return ErrPreconditionFailed
+	}
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+
+	return nil
+}
+
+func (b *S3Bucket) do(method, key string, body []byte, headers map[string]string) (*http.Response, error) {
+	path := "/" + b.Bucket + "/" + escapeS3Key(key)
+	req, err := http.NewRequest(method, strings.TrimRight(b.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error building request for object %s: %w", key, err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	b.sign(req, path, body, time.Now().UTC())
+
+	client := b.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting object %s: %w", key, err)
+	}
+	return res, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (b *S3Bucket) sign(req *http.Request, path string, body []byte, now time.Time) {
+	credentials := awsCredentials{
+		Region:          b.Region,
+		AccessKeyID:     b.AccessKeyID,
+		SecretAccessKey: b.SecretAccessKey,
+		SessionToken:    b.SessionToken,
+	}
+	credentials.sign(req, "s3", path, body, now)
+}
+
+// awsCredentials sign requests to AWS services
+type awsCredentials struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// sign adds AWS Signature Version 4 headers for service to req, signing the
+// host and every header already set on req
+func (c awsCredentials) sign(req *http.Request, service, path string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	values := map[string]string{"host": req.URL.Host}
+	for name, value := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" || name == "user-agent" {
+			continue
+		}
+		values[name] = strings.Join(strings.Fields(strings.Join(value, ",")), " ")
+	}
+	signed := make([]string, 0, len(values))
+	for name := range values {
+		signed = append(signed, name)
+	}
+	sort.Strings(signed)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.Region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// canonicalQuery sorts and URI-encodes query parameters as required by
+// Signature Version 4
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, escapeAWS(name, false)+"="+escapeAWS(value, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func s3Error(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	return fmt.Errorf("object storage request failed: %s: %s", res.Status, strings.TrimSpace(string(body)))
+}
+
+// escapeS3Key URI-encodes every byte of key except unreserved characters and
+// path separators, as required by Signature Version 4
+func escapeS3Key(key string) string {
+	return escapeAWS(key, true)
+}
+
+// escapeAWS URI-encodes every byte of s except unreserved characters and,
+// when keepSlash is set, path separators
+func escapeAWS(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,203 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MappingConflict is a document of an index that would fail to index under
+// the mapping a pending migration leaves the index with, for example a
+// string that is not a date in a field mapped as date
+type MappingConflict struct {
+	Version     string
+	Description string
+	Index       string
+	// Field is the field that failed to parse, when Elasticsearch names it
+	Field      string
+	DocumentID string
+	Reason     string
+}
+
+func (c MappingConflict) String() string {
+	return fmt.Sprintf("migration %s: document %s of %s: %s", c.Version, c.DocumentID, c.Index, c.Reason)
+}
+
+// MappingConflictError is returned by runs with WithSampleValidation when
+// sampled documents fail to index under pending mappings
+type MappingConflictError struct {
+	Conflicts []MappingConflict
+}
+
+func (e *MappingConflictError) Error() string {
+	fields := map[string]bool{}
+	var names []string
+	for _, conflict := range e.Conflicts {
+		name := conflict.Index + "." + conflict.Field
+		if conflict.Field != "" && !fields[name] {
+			fields[name] = true
+			names = append(names, name)
+		}
+	}
+	first := e.Conflicts[0]
+	message := fmt.Sprintf("%d sampled documents would fail to index under pending mappings, first %s", len(e.Conflicts), first)
+	if len(names) > 0 {
+		message += fmt.Sprintf(" (fields %s)", strings.Join(names, ", "))
+	}
+	return message
+}
+
+// WithSampleValidation makes RunMigrations check, before applying anything,
+// that size documents sampled from every index a pending migration maps
+// would still index under the new mapping, see ValidateSamples
+func WithSampleValidation(size int) Option {
+	return func(mm *MigrationManager) {
+		mm.sampleSize = size
+	}
+}
+
+// failedFieldPattern extracts the field of a parsing error such as "failed to
+// parse field [published] of type [date] in document with id '1'"
+var failedFieldPattern = regexp.MustCompile(`field \[([^\]]+)\]`)
+
+// ValidateSamples indexes a random sample of size documents of every index
+// a pending migration maps into a throwaway copy carrying the mapping the
+// migration leaves the index with, and reports the documents Elasticsearch
+// rejects. This catches date formats and types the existing data does not
+// match before a mapping change, or a reindex into it, fails half-way.
+// Indices the migrations create have no documents to sample. The copies are
+// deleted afterwards.
+func (mm *MigrationManager) ValidateSamples(size int) ([]MappingConflict, error) {
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	if err := sortMigrations(migrations); err != nil {
+		return nil, err
+	}
+	return mm.validatePendingSamples(migrations, applied, size)
+}
+
+func (mm *MigrationManager) validatePendingSamples(migrations []Migration, applied map[string]bool, size int) ([]MappingConflict, error) {
+	v := &mappingValidator{client: mm.client(), prefix: validationIndexPrefix + strings.ToLower(newRunID()) + "-", copies: map[string]string{}}
+	defer v.cleanup(mm)
+
+	var conflicts []MappingConflict
+	for _, migration := range migrations {
+		if applied[migration.Version()] {
+			continue
+		}
+		for _, desired := range migration.desired {
+			if desired.raw == "" {
+				continue
+			}
+			exists, err := IndexExists(mm.client(), desired.index)
+			if err != nil {
+				return nil, err
+			}
+			if err := v.validate(desired); err != nil {
+				return nil, fmt.Errorf("migration %s: mapping of %s is invalid: %w", migration.Version(), desired.index, err)
+			}
+			if !exists {
+				continue
+			}
+
+			failures, err := v.indexSample(desired.index, v.copies[desired.index], size)
+			if err != nil {
+				return nil, err
+			}
+			for _, conflict := range failures {
+				conflict.Version = migration.Version()
+				conflict.Description = migration.Description
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// indexSample copies a random sample of the documents of index into
+// throwaway and returns those rejected
+func (v *mappingValidator) indexSample(index, throwaway string, size int) ([]MappingConflict, error) {
+	query := fmt.Sprintf(`{"size": %d, "query": {"function_score": {"random_score": {}}}}`, size)
+	res, err := v.client.Search(
+		v.client.Search.WithIndex(index),
+		v.client.Search.WithBody(strings.NewReader(query)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error sampling %s: %s", index, res.String())
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string          `json:"_id"`
+				Source json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing sample of %s: %w", index, err)
+	}
+	if len(result.Hits.Hits) == 0 {
+		return nil, nil
+	}
+
+	var body bytes.Buffer
+	for _, hit := range result.Hits.Hits {
+		fmt.Fprintf(&body, `{"index": {"_index": %q, "_id": %q}}`+"\n", throwaway, hit.ID)
+		body.Write(hit.Source)
+		body.WriteByte('\n')
+	}
+	bulk, err := v.client.Bulk(bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("error indexing sample of %s: %w", index, err)
+	}
+	defer bulk.Body.Close()
+	if bulk.IsError() {
+		return nil, fmt.Errorf("error indexing sample of %s: %s", index, bulk.String())
+	}
+	var response struct {
+		Items []map[string]struct {
+			ID    string `json:"_id"`
+			Error *struct {
+				Type     string `json:"type"`
+				Reason   string `json:"reason"`
+				CausedBy struct {
+					Reason string `json:"reason"`
+				} `json:"caused_by"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(bulk.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing sample results of %s: %w", index, err)
+	}
+
+	var conflicts []MappingConflict
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Error == nil {
+				continue
+			}
+			reason := result.Error.Reason
+			if cause := result.Error.CausedBy.Reason; cause != "" {
+				reason += ": " + cause
+			}
+			conflict := MappingConflict{Index: index, DocumentID: result.ID, Reason: reason}
+			if match := failedFieldPattern.FindStringSubmatch(result.Error.Reason); match != nil {
+				conflict.Field = match[1]
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,102 @@
+package migration_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestValidateSamples(t *testing.T) {
+	newCluster := func(t *testing.T) *migrationtest.FakeES {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles", `{"mappings": {"properties": {"title": {"type": "text"}}}}`)
+		es.AddDocument("articles", "1", map[string]interface{}{"title": "Hello", "published": "2024-01-02"})
+		es.AddDocument("articles", "2", map[string]interface{}{"title": "Draft", "published": "yesterday"})
+		// the fake does not enforce mappings, so the rejection is canned
+		es.Respond("POST", "/_bulk", 200, `{"errors": true, "items": [
+			{"index": {"_id": "1", "status": 201}},
+			{"index": {"_id": "2", "status": 400, "error": {
+				"type": "document_parsing_exception",
+				"reason": "[1:36] failed to parse field [published] of type [date] in document with id '2'. Preview of field's value: 'yesterday'",
+				"caused_by": {"type": "illegal_argument_exception", "reason": "failed to parse date field [yesterday] with format [strict_date_optional_time||epoch_millis]"}
+			}}}
+		]}`)
+		return es
+	}
+	published := migration.PutMapping("Map published as date", "articles", `{"properties": {"published": {"type": "date"}}}`)
+	comments := migration.PutMapping("Create comments index", "comments", `{"properties": {"body": {"type": "text"}}}`)
+
+	t.Run("Test Rejected Documents Are Reported", func(t *testing.T) {
+		es := newCluster(t)
+		mm := es.Manager(migration.WithMigrations(published, comments))
+
+		conflicts, err := mm.ValidateSamples(100)
+		if err != nil {
+			t.Fatalf("Failed to validate samples: %v", err)
+		}
+		if len(conflicts) != 1 {
+			t.Fatalf("Expected one conflict, got %+v", conflicts)
+		}
+		conflict := conflicts[0]
+		if conflict.Index != "articles" || conflict.Field != "published" || conflict.DocumentID != "2" || conflict.Version != published.Version() {
+			t.Errorf("Unexpected conflict %+v", conflict)
+		}
+		if !strings.Contains(conflict.Reason, "failed to parse date field [yesterday]") {
+			t.Errorf("Expected the cause in the reason, got %q", conflict.Reason)
+		}
+		if es.IndexExists("comments") {
+			t.Error("Expected comments not created")
+		}
+	})
+
+	t.Run("Test Run Refuses Conflicting Mappings", func(t *testing.T) {
+		es := newCluster(t)
+		mm := es.Manager(migration.WithMigrations(published), migration.WithSampleValidation(100))
+
+		err := mm.RunMigrations()
+		var conflictErr *migration.MappingConflictError
+		if !errors.As(err, &conflictErr) {
+			t.Fatalf("Expected a mapping conflict error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "articles.published") {
+			t.Errorf("Expected the field in the error, got %v", err)
+		}
+		if fieldMapping(es, "articles", "published") != nil {
+			t.Error("Expected the mapping left unchanged")
+		}
+	})
+
+	t.Run("Test Clean Samples Pass", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles", "")
+		es.AddDocument("articles", "1", map[string]interface{}{"published": "2024-01-02"})
+		mm := es.Manager(migration.WithMigrations(published), migration.WithSampleValidation(100))
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		var copies int
+		for _, request := range es.Requests() {
+			if index := strings.TrimPrefix(request.Path, "/"); request.Method == "PUT" && strings.HasPrefix(index, "elasticmate-validate-") {
+				copies++
+				if es.IndexExists(index) {
+					t.Errorf("Expected throwaway index %s deleted", index)
+				}
+			}
+		}
+		if copies == 0 {
+			t.Error("Expected a throwaway index")
+		}
+		if fieldMapping(es, "articles", "published") == nil {
+			t.Error("Expected the mapping applied")
+		}
+	})
+}
+
+func fieldMapping(es *migrationtest.FakeES, index, field string) interface{} {
+	properties, _ := es.Mapping(index)["properties"].(map[string]interface{})
+	return properties[field]
+}
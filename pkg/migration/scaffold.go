@@ -0,0 +1,236 @@
+package migration
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RegistryFile is the file Scaffold generates to register every migration of
+// a directory
+const RegistryFile = "registry.go"
+
+// ScaffoldConfig configures Scaffold
+type ScaffoldConfig struct {
+	// Dir is the directory holding the migrations package
+	Dir string
+	// Package is the Go package name (default the base name of Dir)
+	Package     string
+	Description string
+	// Format is "go" (default) for a Go file with Up and Down stubs, or
+	// "yaml" for a declarative migration
+	Format string
+}
+
+// sequenceFile matches migration files named like 0003_add_tags.go
+var sequenceFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(go|yaml|yml|json)$`)
+
+// scaffolded is a numbered migration file of a migrations directory
+type scaffolded struct {
+	sequence int
+	slug     string
+	file     string
+	declared bool
+}
+
+func scaffoldedFiles(dir string) ([]scaffolded, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %s: %w", dir, err)
+	}
+
+	var files []scaffolded
+	for _, entry := range entries {
+		match := sequenceFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		sequence, _ := strconv.Atoi(match[1])
+		files = append(files, scaffolded{
+			sequence: sequence,
+			slug:     match[2],
+			file:     entry.Name(),
+			declared: match[3] != "go",
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].sequence < files[j].sequence })
+	return files, nil
+}
+
+// NextSequence returns the sequence number following the highest numbered
+// migration file in dir, starting at 1
+func NextSequence(dir string) (int, error) {
+	files, err := scaffoldedFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 1, nil
+	}
+	return files[len(files)-1].sequence + 1, nil
+}
+
+// Scaffold writes a new numbered migration file to cfg.Dir, regenerates the
+// registry file and returns the path of the new file. The sequence number
+// orders the files; like every migration, scaffolded ones are applied in
+// version order.
+func Scaffold(cfg ScaffoldConfig) (string, error) {
+	if cfg.Description == "" {
+		return "", fmt.Errorf("migration requires a description")
+	}
+	if cfg.Package == "" {
+		cfg.Package = filepath.Base(cfg.Dir)
+	}
+	if cfg.Format == "" {
+		cfg.Format = "go"
+	}
+	slug := migrationSlug(cfg.Description)
+	if slug == "" {
+		return "", fmt.Errorf("description %q has no letters or digits to name the migration", cfg.Description)
+	}
+
+	name, sequence, err := nextFileName(cfg.Dir, slug)
+	if err != nil {
+		return "", err
+	}
+
+	var path string
+	var source []byte
+	switch cfg.Format {
+	case "go":
+		path = filepath.Join(cfg.Dir, name+".go")
+		source, err = renderStub(cfg.Package, cfg.Description, goIdentifier(slug), sequence)
+	case "yaml":
+		path = filepath.Join(cfg.Dir, name+".yaml")
+		source = []byte(fmt.Sprintf("description: %s\naction: put_mapping\nindex: my-index\nbody:\n  properties: {}\n",
+			yamlScalar(cfg.Description)))
+	default:
+		return "", fmt.Errorf("unknown format %q, expected go or yaml", cfg.Format)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %w", cfg.Dir, err)
+	}
+	if err := os.WriteFile(path, source, 0644); err != nil {
+		return "", fmt.Errorf("error writing migration: %w", err)
+	}
+	if err := WriteRegistry(cfg.Dir, cfg.Package); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// nextFileName returns the name, without extension, and the sequence number
+// of the next migration file of dir named after slug
+func nextFileName(dir, slug string) (string, int, error) {
+	files, err := scaffoldedFiles(dir)
+	if err != nil {
+		return "", 0, err
+	}
+	sequence := 1
+	for _, file := range files {
+		if file.slug == slug {
+			return "", 0, fmt.Errorf("migration %s already exists", file.file)
+		}
+		sequence = file.sequence + 1
+	}
+	return fmt.Sprintf("%04d_%s", sequence, slug), sequence, nil
+}
+
+// renderStub returns a Go migration with empty Up and Down functions. Named
+// functions are used because the version depends on the function name, which
+// is not stable for closures.
+func renderStub(pkg, description, name string, sequence int) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("import (\n\t\"github.com/elastic/go-elasticsearch/v8\"\n")
+	b.WriteString("\t\"github.com/punitsu/elasticmate/pkg/migration\"\n)\n\n")
+	fmt.Fprintf(&b, "// %s is migration %04d\n", name, sequence)
+	fmt.Fprintf(&b, "var %s = migration.NewMigration(%q, up%s).\nWithDown(down%s)\n\n", name, description, name, name)
+	fmt.Fprintf(&b, "func up%s(client *elasticsearch.Client) error {\n// TODO: apply the change\nreturn nil\n}\n\n", name)
+	fmt.Fprintf(&b, "func down%s(client *elasticsearch.Client) error {\n// TODO: revert the change\nreturn nil\n}\n", name)
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error formatting generated code: %w", err)
+	}
+	return source, nil
+}
+
+// WriteRegistry generates the registry file of dir, declaring a Register
+// function that registers every numbered Go migration and embeds every
+// declarative one
+func WriteRegistry(dir, pkg string) error {
+	files, err := scaffoldedFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var declared []string
+	for _, file := range files {
+		if file.declared {
+			declared = append(declared, file.file)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by elasticmate new. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("import (\n")
+	if len(declared) > 0 {
+		b.WriteString("\t\"embed\"\n\n")
+	}
+	b.WriteString("\t\"github.com/punitsu/elasticmate/pkg/migration\"\n)\n\n")
+	if len(declared) > 0 {
+		fmt.Fprintf(&b, "//go:embed %s\nvar declarations embed.FS\n\n", strings.Join(declared, " "))
+	}
+	b.WriteString("// Register registers the migrations of this package on mm\n")
+	b.WriteString("func Register(mm *migration.MigrationManager) error {\n")
+	for _, file := range files {
+		if !file.declared {
+			fmt.Fprintf(&b, "mm.Register(%s)\n", goIdentifier(file.slug))
+		}
+	}
+	if len(declared) > 0 {
+		b.WriteString("return mm.RegisterFS(declarations)\n}\n")
+	} else {
+		b.WriteString("return nil\n}\n")
+	}
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("error formatting generated code: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, RegistryFile), source, 0644); err != nil {
+		return fmt.Errorf("error writing registry: %w", err)
+	}
+	return nil
+}
+
+// migrationSlug turns a description into a file name part such as
+// add_tags_to_articles
+func migrationSlug(description string) string {
+	var b strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(description) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			separate = b.Len() > 0
+			continue
+		}
+		if separate {
+			b.WriteByte('_')
+			separate = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
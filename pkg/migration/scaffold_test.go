@@ -0,0 +1,62 @@
+package migration
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScaffold(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+
+	goFile, err := Scaffold(ScaffoldConfig{Dir: dir, Description: "Add tags to articles"})
+	if err != nil {
+		t.Fatalf("Failed to scaffold migration: %v", err)
+	}
+	yamlFile, err := Scaffold(ScaffoldConfig{Dir: dir, Description: "Create comments index", Format: "yaml"})
+	if err != nil {
+		t.Fatalf("Failed to scaffold migration: %v", err)
+	}
+
+	t.Run("Test Sequence Numbers", func(t *testing.T) {
+		if filepath.Base(goFile) != "0001_add_tags_to_articles.go" || filepath.Base(yamlFile) != "0002_create_comments_index.yaml" {
+			t.Errorf("Expected numbered files, got %s and %s", goFile, yamlFile)
+		}
+		if next, _ := NextSequence(dir); next != 3 {
+			t.Errorf("Expected next sequence 3, got %d", next)
+		}
+	})
+
+	t.Run("Test Generated Files", func(t *testing.T) {
+		for _, name := range []string{goFile, filepath.Join(dir, RegistryFile)} {
+			if _, err := parser.ParseFile(token.NewFileSet(), name, nil, 0); err != nil {
+				t.Fatalf("Generated code does not parse: %v", err)
+			}
+		}
+
+		registry, _ := os.ReadFile(filepath.Join(dir, RegistryFile))
+		for _, line := range []string{
+			"package migrations",
+			"//go:embed 0002_create_comments_index.yaml",
+			"\tmm.Register(AddTagsToArticles)",
+		} {
+			if !strings.Contains(string(registry), line+"\n") {
+				t.Errorf("Expected registry to contain %q, got:\n%s", line, registry)
+			}
+		}
+
+		declared, _ := os.ReadFile(yamlFile)
+		if _, err := ParseDeclaration(declared); err != nil {
+			t.Errorf("Expected the YAML stub to be a valid declaration, got %v", err)
+		}
+	})
+
+	t.Run("Test Duplicate Description", func(t *testing.T) {
+		if _, err := Scaffold(ScaffoldConfig{Dir: dir, Description: "Add tags to articles"}); err == nil {
+			t.Error("Expected an error for a duplicate migration")
+		}
+	})
+}
@@ -0,0 +1,369 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far ahead the next opening of a cron window is searched for
+const scheduleHorizon = 366 * 24 * time.Hour
+
+// schedule restricts when a migration may be applied
+type schedule struct {
+	notBefore time.Time
+	cron      string
+	window    time.Duration
+	// location the cron expression is evaluated in, UTC when nil
+	location *time.Location
+	// err reports an invalid Window
+	err error
+}
+
+// Window is a recurring off-peak window, either opening at the times matched
+// by a cron expression for Duration, or between Start and End every day
+type Window struct {
+	// Cron is a five-field cron expression (minute hour day-of-month month
+	// day-of-week) the window opens at, staying open for Duration
+	Cron     string
+	Duration time.Duration
+	// Start and End are times of day such as "22:00" and "06:00", used when
+	// Cron is empty; an End before Start closes the window the next day
+	Start string
+	End   string
+	// Location is the time zone of Cron, Start and End; UTC when nil
+	Location *time.Location
+}
+
+// cron returns the cron expression and duration of the window
+func (w Window) cron() (string, time.Duration, error) {
+	if w.Cron != "" {
+		return w.Cron, w.Duration, nil
+	}
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid window start %q: expected HH:MM", w.Start)
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid window end %q: expected HH:MM", w.End)
+	}
+	duration := end.Sub(start)
+	if duration <= 0 {
+		duration += 24 * time.Hour
+	}
+	return fmt.Sprintf("%d %d * * *", start.Minute(), start.Hour()), duration, nil
+}
+
+// ParseWindow parses a daily window written HH:MM-HH:MM, such as
+// "22:00-06:00", in the time zone tz, UTC when empty
+func ParseWindow(spec, tz string) (Window, error) {
+	start, end, ok := strings.Cut(spec, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", spec)
+	}
+	for _, clock := range []string{start, end} {
+		if _, err := time.Parse("15:04", clock); err != nil {
+			return Window{}, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", spec)
+		}
+	}
+	location := time.UTC
+	if tz != "" {
+		var err error
+		if location, err = time.LoadLocation(tz); err != nil {
+			return Window{}, fmt.Errorf("invalid window time zone: %w", err)
+		}
+	}
+	return Window{Start: start, End: end, Location: location}, nil
+}
+
+// DeferredMigration is a pending migration whose schedule did not allow it to
+// run. Next is the earliest time it becomes eligible, zero if none was found
+// within a year.
+type DeferredMigration struct {
+	Version     string
+	Description string
+	Next        time.Time
+	// WaitingForWindow is set when the migration waits for a window to open
+	// rather than for its NotBefore time
+	WaitingForWindow bool
+}
+
+// NotBefore defers the migration until t
+func (m Migration) NotBefore(t time.Time) Migration {
+	s := m.scheduleOrZero()
+	s.notBefore = t
+	m.schedule = &s
+	return m
+}
+
+// InWindow restricts the migration to windows that open at the times matched
+// by the five-field cron expression spec (minute hour day-of-month month
+// day-of-week, in UTC) and stay open for duration. For example
+// InWindow("0 22 * * 5", 48*time.Hour) allows Friday 22:00 to Sunday 22:00.
+func (m Migration) InWindow(spec string, duration time.Duration) Migration {
+	s := m.scheduleOrZero()
+	s.cron = spec
+	s.window = duration
+	s.location, s.err = nil, nil
+	m.schedule = &s
+	return m
+}
+
+// During restricts the migration to an off-peak window, e.g.
+// During(Window{Start: "22:00", End: "06:00", Location: berlin}) allows it
+// every night in Berlin time
+func (m Migration) During(window Window) Migration {
+	s := m.scheduleOrZero()
+	s.cron, s.window, s.err = window.cron()
+	s.location = window.Location
+	m.schedule = &s
+	return m
+}
+
+// WithWindow defers pending migrations without a window of their own,
+// see Migration.InWindow and Migration.During, to an off-peak window of the
+// run. They are reported as waiting for the window and left pending.
+func WithWindow(window Window) Option {
+	return func(mm *MigrationManager) {
+		mm.window = &window
+	}
+}
+
+// scheduleOf returns the schedule of migration, with the window of the run
+// when it has none of its own
+func (mm *MigrationManager) scheduleOf(migration Migration) *schedule {
+	if mm.window == nil || (migration.schedule != nil && migration.schedule.cron != "") {
+		return migration.schedule
+	}
+	s := migration.scheduleOrZero()
+	s.cron, s.window, s.err = mm.window.cron()
+	s.location = mm.window.Location
+	return &s
+}
+
+func (m Migration) scheduleOrZero() schedule {
+	if m.schedule == nil {
+		return schedule{}
+	}
+	return *m.schedule
+}
+
+// validate checks the cron expression of the schedule
+func (s *schedule) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if s.cron == "" {
+		return nil
+	}
+	if s.window <= 0 {
+		return fmt.Errorf("schedule window %q requires a positive duration", s.cron)
+	}
+	_, err := parseCron(s.cron)
+	return err
+}
+
+// next returns the earliest time at or after now when the migration may run,
+// and false when there is none within the search horizon
+func (s *schedule) next(now time.Time) (time.Time, bool) {
+	if s == nil {
+		return now, true
+	}
+
+	from := now
+	if from.Before(s.notBefore) {
+		from = s.notBefore
+	}
+	if s.cron == "" {
+		return from, true
+	}
+
+	expr, err := parseCron(s.cron)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// Inside a window that opened within the last duration
+	location := s.location
+	if location == nil {
+		location = time.UTC
+	}
+	from = from.In(location)
+	start := from.Truncate(time.Minute)
+	for t := start; from.Sub(t) < s.window; t = t.Add(-time.Minute) {
+		if expr.matches(t) {
+			return from, true
+		}
+	}
+
+	for t := start.Add(time.Minute); t.Sub(from) <= scheduleHorizon; t = t.Add(time.Minute) {
+		if expr.matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// eligible reports whether the migration may run at now
+func (s *schedule) eligible(now time.Time) bool {
+	next, ok := s.next(now)
+	return ok && !next.After(now)
+}
+
+// waitsForWindow reports whether the migration is held back at now by its
+// window rather than by its NotBefore time
+func (s *schedule) waitsForWindow(now time.Time) bool {
+	return s != nil && s.cron != "" && !now.Before(s.notBefore)
+}
+
+// Deferred returns the migrations that the last RunMigrations left pending
+// because of their schedule
+func (mm *MigrationManager) Deferred() []DeferredMigration {
+	return append([]DeferredMigration{}, mm.deferred...)
+}
+
+// RunDaemon runs pending migrations every interval until ctx is cancelled,
+// waking up early when the window of a deferred migration opens. Failed runs
+// are logged and retried on the next tick.
+func (mm *MigrationManager) RunDaemon(ctx context.Context, interval time.Duration) error {
+	for {
+		if err := mm.RunMigrations(); err != nil {
+			if errors.Is(err, ErrLocked) {
+				mm.logf("Migrations locked by another runner, retrying in %s", interval)
+			} else {
+				mm.logf("Migration run failed: %v", err)
+			}
+		}
+
+		wait := interval
+		now := mm.clock()
+		for _, deferred := range mm.deferred {
+			if !deferred.Next.IsZero() && deferred.Next.Sub(now) < wait {
+				wait = deferred.Next.Sub(now)
+			}
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+func (mm *MigrationManager) clock() time.Time {
+	if mm.now != nil {
+		return mm.now()
+	}
+	return time.Now()
+}
+
+// cronExpr is a parsed five-field cron expression
+type cronExpr struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+func parseCron(spec string) (*cronExpr, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday is both 0 and 7
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &cronExpr{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = n, n
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *cronExpr) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+
+	// As in cron, a restricted day of month and day of week match either
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,157 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestSchedule(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Test Cron Window", func(t *testing.T) {
+		s := &schedule{cron: "0 22 * * 5", window: 48 * time.Hour}
+
+		next, ok := s.next(now)
+		if !ok || !next.Equal(time.Date(2024, 5, 17, 22, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected window to open on Friday 22:00, got %v", next)
+		}
+		if !s.eligible(time.Date(2024, 5, 19, 21, 59, 0, 0, time.UTC)) {
+			t.Error("Expected migration to be eligible on Sunday evening")
+		}
+		if s.eligible(time.Date(2024, 5, 19, 22, 0, 0, 0, time.UTC)) {
+			t.Error("Expected window to be closed after 48 hours")
+		}
+	})
+
+	t.Run("Test Invalid Cron", func(t *testing.T) {
+		m := NewMigration("Bad window", func(client *elasticsearch.Client) error { return nil }).InWindow("0 25 * * *", time.Hour)
+		if err := m.Validate(); err == nil {
+			t.Error("Expected an invalid hour to fail validation")
+		}
+	})
+
+	t.Run("Test Deferred Migrations", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		mm.now = func() time.Time { return now }
+
+		ran := map[string]bool{}
+		mm.Register(NewMigration("Now", func(client *elasticsearch.Client) error {
+			ran["Now"] = true
+			return nil
+		}))
+		mm.Register(NewMigration("Weekend backfill", func(client *elasticsearch.Client) error {
+			ran["Weekend backfill"] = true
+			return nil
+		}).InWindow("0 22 * * 5", 48*time.Hour))
+		mm.Register(NewMigration("Next month", func(client *elasticsearch.Client) error {
+			ran["Next month"] = true
+			return nil
+		}).NotBefore(now.AddDate(0, 1, 0)))
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !ran["Now"] || len(ran) != 1 {
+			t.Errorf("Expected only the unscheduled migration to run, got %v", ran)
+		}
+		if deferred := mm.Deferred(); len(deferred) != 2 {
+			t.Fatalf("Expected 2 deferred migrations, got %+v", deferred)
+		}
+
+		mm.now = func() time.Time { return time.Date(2024, 5, 18, 3, 0, 0, 0, time.UTC) }
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !ran["Weekend backfill"] || ran["Next month"] {
+			t.Errorf("Expected only the weekend migration to run in its window, got %v", ran)
+		}
+		if deferred := mm.Deferred(); len(deferred) != 1 || deferred[0].Description != "Next month" {
+			t.Errorf("Expected the next month migration to stay deferred, got %+v", deferred)
+		}
+	})
+
+	t.Run("Test Time Range In A Time Zone", func(t *testing.T) {
+		berlin := time.FixedZone("CEST", 2*60*60)
+		m := NewMigration("Nightly backfill", func(client *elasticsearch.Client) error { return nil }).
+			During(Window{Start: "22:00", End: "06:00", Location: berlin})
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Expected a valid window, got %v", err)
+		}
+
+		next, ok := m.schedule.next(now)
+		if !ok || !next.Equal(time.Date(2024, 5, 15, 20, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected the window to open at 22:00 in Berlin, got %v", next)
+		}
+		if !m.schedule.eligible(time.Date(2024, 5, 16, 3, 59, 0, 0, time.UTC)) {
+			t.Error("Expected the migration to be eligible at 05:59 in Berlin")
+		}
+		if m.schedule.eligible(time.Date(2024, 5, 16, 4, 0, 0, 0, time.UTC)) {
+			t.Error("Expected the window to be closed at 06:00 in Berlin")
+		}
+
+		invalid := m.During(Window{Start: "10pm", End: "06:00"})
+		if err := invalid.Validate(); err == nil {
+			t.Error("Expected an invalid start to fail validation")
+		}
+	})
+
+	t.Run("Test Parse Window", func(t *testing.T) {
+		window, err := ParseWindow("22:00-06:00", "Europe/Berlin")
+		if err != nil {
+			t.Fatalf("Failed to parse window: %v", err)
+		}
+		if window.Start != "22:00" || window.End != "06:00" || window.Location.String() != "Europe/Berlin" {
+			t.Errorf("Expected 22:00-06:00 in Berlin, got %+v", window)
+		}
+		if window, err := ParseWindow("01:00-05:00", ""); err != nil || window.Location != time.UTC {
+			t.Errorf("Expected UTC by default, got %+v %v", window, err)
+		}
+
+		for _, spec := range []string{"22:00", "10pm-06:00", "22:00-6", "22:00-06:00-07:00"} {
+			if _, err := ParseWindow(spec, ""); err == nil {
+				t.Errorf("Expected %q to be rejected", spec)
+			}
+		}
+		if _, err := ParseWindow("22:00-06:00", "Mars/Olympus"); err == nil {
+			t.Error("Expected an unknown time zone to be rejected")
+		}
+	})
+
+	t.Run("Test Window Of The Run", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()), WithWindow(Window{Start: "01:00", End: "05:00"}))
+		mm.now = func() time.Time { return now }
+
+		ran := map[string]bool{}
+		mm.Register(NewMigration("Reindex articles", func(client *elasticsearch.Client) error {
+			ran["Reindex articles"] = true
+			return nil
+		}))
+		mm.Register(NewMigration("Lunch break", func(client *elasticsearch.Client) error {
+			ran["Lunch break"] = true
+			return nil
+		}).InWindow("0 12 * * *", time.Hour))
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !ran["Lunch break"] || ran["Reindex articles"] {
+			t.Errorf("Expected only the migration with its own window to run, got %v", ran)
+		}
+		deferred := mm.Deferred()
+		if len(deferred) != 1 || !deferred[0].WaitingForWindow || !deferred[0].Next.Equal(time.Date(2024, 5, 16, 1, 0, 0, 0, time.UTC)) {
+			t.Fatalf("Expected the reindex to wait for the window of the run, got %+v", deferred)
+		}
+
+		plan, err := mm.Plan()
+		if err != nil {
+			t.Fatalf("Failed to plan: %v", err)
+		}
+		if !strings.Contains(plan.String(), "[waiting for window until 2024-05-16T01:00:00Z]") {
+			t.Errorf("Expected the plan to report the window, got %s", plan)
+		}
+	})
+}
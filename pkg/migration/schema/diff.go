@@ -0,0 +1,170 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ChangeKind classifies a field difference
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// mapping parameters Elasticsearch accepts on an existing field
+var updatableParams = map[string]bool{
+	"ignore_above":          true,
+	"search_analyzer":       true,
+	"search_quote_analyzer": true,
+	"meta":                  true,
+	"dynamic":               true,
+}
+
+// Change is a difference in a single field. From is nil for added fields and
+// To is nil for removed ones.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	From *Field
+	To   *Field
+}
+
+// Breaking reports whether the change cannot be applied with a put mapping
+// request and needs the data to be reindexed
+func (c Change) Breaking() bool {
+	switch c.Kind {
+	case Added:
+		return false
+	case Removed:
+		return true
+	}
+
+	if c.From.Type != c.To.Type {
+		return true
+	}
+	for key := range mergeKeys(c.From.Params, c.To.Params) {
+		if !updatableParams[key] && !reflect.DeepEqual(c.From.Params[key], c.To.Params[key]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s (%s)", c.Path, c.To)
+	case Removed:
+		return fmt.Sprintf("- %s (%s)", c.Path, c.From)
+	}
+	return fmt.Sprintf("~ %s (%s -> %s)", c.Path, c.From, c.To)
+}
+
+// Diff lists the changes needed to turn one mapping into another
+type Diff struct {
+	Index   string
+	Changes []Change
+}
+
+// Compare returns the changes between the live and the desired mapping,
+// sorted by field path
+func Compare(live, desired Mapping) Diff {
+	var diff Diff
+
+	for path := range mergeKeys(live, desired) {
+		from, inLive := live[path]
+		to, inDesired := desired[path]
+
+		switch {
+		case !inLive:
+			diff.Changes = append(diff.Changes, Change{Path: path, Kind: Added, To: &to})
+		case !inDesired:
+			diff.Changes = append(diff.Changes, Change{Path: path, Kind: Removed, From: &from})
+		case !reflect.DeepEqual(from, to):
+			diff.Changes = append(diff.Changes, Change{Path: path, Kind: Changed, From: &from, To: &to})
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		return diff.Changes[i].Path < diff.Changes[j].Path
+	})
+	return diff
+}
+
+// Drift compares the live mapping of index against the desired mapping. A
+// missing index is reported as every desired field being added.
+func Drift(client *elasticsearch.Client, index string, desired Mapping) (Diff, error) {
+	live, err := Fetch(client, index)
+	if err != nil && !errors.Is(err, ErrIndexNotFound) {
+		return Diff{}, err
+	}
+
+	diff := Compare(live, desired)
+	diff.Index = index
+	return diff, nil
+}
+
+// Empty reports whether the mappings are identical
+func (d Diff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// Breaking returns the changes that need a reindex
+func (d Diff) Breaking() []Change {
+	var breaking []Change
+	for _, change := range d.Changes {
+		if change.Breaking() {
+			breaking = append(breaking, change)
+		}
+	}
+	return breaking
+}
+
+// Count returns the number of changes of the given kind
+func (d Diff) Count(kind ChangeKind) int {
+	count := 0
+	for _, change := range d.Changes {
+		if change.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+func (d Diff) String() string {
+	var b strings.Builder
+	if d.Index != "" {
+		fmt.Fprintf(&b, "%s\n", d.Index)
+	}
+	if d.Empty() {
+		b.WriteString("  no changes\n")
+		return b.String()
+	}
+	for _, change := range d.Changes {
+		fmt.Fprintf(&b, "  %s", change)
+		if change.Breaking() {
+			b.WriteString(" [requires reindex]")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func mergeKeys[V any](a, b map[string]V) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
@@ -0,0 +1,246 @@
+// Package schema reads index mappings and compares them against declared
+// desired mappings.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// ErrIndexNotFound is returned by Fetch when the index does not exist
+var ErrIndexNotFound = errors.New("index not found")
+
+// top-level mapping parameters, used to tell a mapping without properties
+// from a bare properties object
+var mappingParams = map[string]bool{
+	"dynamic":           true,
+	"dynamic_templates": true,
+	"date_detection":    true,
+	"numeric_detection": true,
+	"runtime":           true,
+	"_source":           true,
+	"_meta":             true,
+	"_routing":          true,
+}
+
+// Field is a single mapped field. Params holds every mapping parameter other
+// than type, properties and fields.
+type Field struct {
+	Type   string
+	Params map[string]interface{}
+}
+
+func (f Field) String() string {
+	if len(f.Params) == 0 {
+		return f.Type
+	}
+	keys := make([]string, 0, len(f.Params))
+	for key := range f.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, fmt.Sprintf("%s: %s", key, formatValue(f.Params[key])))
+	}
+	return fmt.Sprintf("%s, %s", f.Type, strings.Join(params, ", "))
+}
+
+// Mapping is an index mapping flattened to dotted field paths. Object
+// sub-properties and multi-fields both appear under their full name, e.g.
+// "author.name" or "title.raw".
+type Mapping map[string]Field
+
+// Parse reads a mapping from JSON. It accepts an index creation body
+// ({"mappings": {...}}), a mapping ({"properties": {...}}) or a bare
+// properties object.
+func Parse(data []byte) (Mapping, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("error parsing mapping: %w", err)
+	}
+
+	if mappings, ok := body["mappings"]; ok {
+		return Parse(mappings)
+	}
+
+	properties := data
+	if raw, ok := body["properties"]; ok {
+		properties = raw
+	} else {
+		for key := range body {
+			if mappingParams[key] {
+				// a mapping without properties
+				return Mapping{}, nil
+			}
+		}
+	}
+
+	mapping := Mapping{}
+	if err := mapping.addProperties("", properties); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+func (m Mapping) addProperties(prefix string, data json.RawMessage) error {
+	var properties map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &properties); err != nil {
+		return fmt.Errorf("error parsing properties of %q: %w", strings.TrimSuffix(prefix, "."), err)
+	}
+
+	for name, definition := range properties {
+		path := prefix + name
+		field := Field{Params: map[string]interface{}{}}
+
+		for key, value := range definition {
+			switch key {
+			case "type":
+				if err := json.Unmarshal(value, &field.Type); err != nil {
+					return fmt.Errorf("error parsing type of %q: %w", path, err)
+				}
+			case "properties", "fields":
+				if err := m.addProperties(path+".", value); err != nil {
+					return err
+				}
+			default:
+				var param interface{}
+				if err := json.Unmarshal(value, &param); err != nil {
+					return fmt.Errorf("error parsing %s of %q: %w", key, path, err)
+				}
+				field.Params[key] = param
+			}
+		}
+
+		if field.Type == "" {
+			field.Type = "object"
+		}
+		if len(field.Params) == 0 {
+			field.Params = nil
+		}
+		m[path] = field
+	}
+
+	return nil
+}
+
+// Merge returns a copy of m with the fields of other added or replacing
+// existing ones, the way a put mapping request combines with a live mapping
+func (m Mapping) Merge(other Mapping) Mapping {
+	merged := make(Mapping, len(m)+len(other))
+	for path, field := range m {
+		merged[path] = field
+	}
+	for path, field := range other {
+		merged[path] = field
+	}
+	return merged
+}
+
+// Fetch returns the live mapping of index. If index is an alias or pattern
+// resolving to several indices, the mapping of the first one in name order is
+// returned.
+func Fetch(client *elasticsearch.Client, index string) (Mapping, error) {
+	res, err := client.Indices.GetMapping(client.Indices.GetMapping.WithIndex(index))
+	if err != nil {
+		return nil, fmt.Errorf("error reading mapping of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return nil, fmt.Errorf("error reading mapping of %s: %w", index, ErrIndexNotFound)
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading mapping of %s: %s", index, res.String())
+	}
+
+	var result map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing mapping of %s: %w", index, err)
+	}
+
+	definition, ok := result[index]
+	if !ok {
+		names := make([]string, 0, len(result))
+		for name := range result {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("error reading mapping of %s: %w", index, ErrIndexNotFound)
+		}
+		sort.Strings(names)
+		definition = result[names[0]]
+	}
+
+	if len(definition.Mappings) == 0 {
+		return Mapping{}, nil
+	}
+	return Parse(definition.Mappings)
+}
+
+func formatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Render returns the mapping as a {"properties": {...}} body. Fields nested
+// under objects are written as properties and fields nested under other types
+// as multi-fields.
+func (m Mapping) Render() ([]byte, error) {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	// Parents sort before their children
+	sort.Strings(paths)
+
+	root := map[string]interface{}{}
+	definitions := map[string]map[string]interface{}{}
+
+	for _, path := range paths {
+		field := m[path]
+		definition := map[string]interface{}{}
+		for key, value := range field.Params {
+			definition[key] = value
+		}
+		if field.Type != "object" {
+			definition["type"] = field.Type
+		}
+		definitions[path] = definition
+
+		parent, name := root, path
+		if i := strings.LastIndex(path, "."); i >= 0 {
+			if p, ok := definitions[path[:i]]; ok {
+				key := "fields"
+				if t := m[path[:i]].Type; t == "object" || t == "nested" {
+					key = "properties"
+				}
+				if p[key] == nil {
+					p[key] = map[string]interface{}{}
+				}
+				parent, name = p[key].(map[string]interface{}), path[i+1:]
+			}
+		}
+		parent[name] = definition
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"properties": root})
+	if err != nil {
+		return nil, fmt.Errorf("error rendering mapping: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,136 @@
+package schema
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+type mappingTransport struct {
+	status int
+	body   string
+}
+
+func (m mappingTransport) Perform(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: m.status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(m.body)),
+	}, nil
+}
+
+func newClient(transport esapi.Transport) *elasticsearch.Client {
+	return &elasticsearch.Client{BaseClient: elasticsearch.BaseClient{Transport: transport}, API: esapi.New(transport)}
+}
+
+func TestSchema(t *testing.T) {
+	live, err := Parse([]byte(`{
+		"mappings": {
+			"dynamic": "strict",
+			"properties": {
+				"title": {"type": "text", "analyzer": "standard", "fields": {"raw": {"type": "keyword", "ignore_above": 256}}},
+				"author": {"properties": {"name": {"type": "keyword"}}},
+				"legacy": {"type": "keyword"},
+				"views": {"type": "integer"}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse live mapping: %v", err)
+	}
+
+	t.Run("Test Flattened Paths", func(t *testing.T) {
+		for path, typ := range map[string]string{
+			"title":       "text",
+			"title.raw":   "keyword",
+			"author":      "object",
+			"author.name": "keyword",
+		} {
+			if live[path].Type != typ {
+				t.Errorf("Expected %s to be %s, got %q", path, typ, live[path].Type)
+			}
+		}
+	})
+
+	t.Run("Test Render Round Trip", func(t *testing.T) {
+		body, err := live.Render()
+		if err != nil {
+			t.Fatalf("Failed to render mapping: %v", err)
+		}
+		parsed, err := Parse(body)
+		if err != nil {
+			t.Fatalf("Failed to parse rendered mapping: %v", err)
+		}
+		if diff := Compare(live, parsed); !diff.Empty() {
+			t.Errorf("Expected rendered mapping to match, got:\n%s", diff)
+		}
+	})
+
+	t.Run("Test Compare", func(t *testing.T) {
+		desired, err := Parse([]byte(`{
+			"properties": {
+				"title": {"type": "text", "analyzer": "english", "fields": {"raw": {"type": "keyword", "ignore_above": 512}}},
+				"author": {"properties": {"name": {"type": "keyword"}}},
+				"views": {"type": "long"},
+				"tags": {"type": "keyword"}
+			}
+		}`))
+		if err != nil {
+			t.Fatalf("Failed to parse desired mapping: %v", err)
+		}
+
+		diff := Compare(live, desired)
+
+		expected := map[string]struct {
+			kind     ChangeKind
+			breaking bool
+		}{
+			"legacy":    {Removed, true},
+			"tags":      {Added, false},
+			"title":     {Changed, true},
+			"title.raw": {Changed, false},
+			"views":     {Changed, true},
+		}
+		if len(diff.Changes) != len(expected) {
+			t.Fatalf("Expected %d changes, got %d:\n%s", len(expected), len(diff.Changes), diff)
+		}
+		for _, change := range diff.Changes {
+			want, ok := expected[change.Path]
+			if !ok {
+				t.Errorf("Unexpected change %s", change)
+				continue
+			}
+			if change.Kind != want.kind || change.Breaking() != want.breaking {
+				t.Errorf("Expected %s to be %s (breaking %v), got %s (breaking %v)", change.Path, want.kind, want.breaking, change.Kind, change.Breaking())
+			}
+		}
+	})
+
+	t.Run("Test Drift On Missing Index", func(t *testing.T) {
+		client := newClient(mappingTransport{status: 404, body: `{"error": {"type": "index_not_found_exception"}}`})
+
+		diff, err := Drift(client, "articles", live)
+		if err != nil {
+			t.Fatalf("Failed to compute drift: %v", err)
+		}
+		if diff.Count(Added) != len(live) {
+			t.Errorf("Expected every field to be added, got:\n%s", diff)
+		}
+	})
+
+	t.Run("Test Fetch Through Alias", func(t *testing.T) {
+		client := newClient(mappingTransport{status: 200, body: `{"articles_v2": {"mappings": {"properties": {"title": {"type": "text"}}}}}`})
+
+		mapping, err := Fetch(client, "articles")
+		if err != nil {
+			t.Fatalf("Failed to fetch mapping: %v", err)
+		}
+		if mapping["title"].Type != "text" {
+			t.Errorf("Expected title to be text, got %+v", mapping)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// SchemaVersionField is the key of the mapping _meta holding the version of
+// the last migration applied to an index
+const SchemaVersionField = "schema_version"
+
+// WithSchemaVersions makes runs write the version of every applied
+// migration into the mapping _meta of the indices it wrote to or declared a
+// mapping for, as _meta.schema_version, so applications can check the
+// schema of the indices they query with CheckSchemaVersion. Other _meta
+// keys are kept.
+func WithSchemaVersions() Option {
+	return func(mm *MigrationManager) {
+		mm.schemaVersions = true
+	}
+}
+
+// stampSchemaVersion writes the version of an applied migration into the
+// indices of its record. Indices the migration deleted are skipped.
+func (mm *MigrationManager) stampSchemaVersion(record MigrationRecord) {
+	for _, index := range record.Indices {
+		if err := SetSchemaVersion(mm.client(), index, record.Version); err != nil {
+			mm.logf("Failed to write schema version %s to %s: %v", record.Version, index, err)
+		}
+	}
+}
+
+// indexMeta returns the mapping _meta of the concrete indices behind index,
+// nil when it does not exist
+func indexMeta(client *elasticsearch.Client, index string) (map[string]map[string]interface{}, error) {
+	res, err := client.Indices.GetMapping(client.Indices.GetMapping.WithIndex(index))
+	if err != nil {
+		return nil, fmt.Errorf("error reading mapping of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading mapping of %s: %s", index, res.String())
+	}
+
+	var result map[string]struct {
+		Mappings struct {
+			Meta map[string]interface{} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing mapping of %s: %w", index, err)
+	}
+	meta := make(map[string]map[string]interface{}, len(result))
+	for name, definition := range result {
+		meta[name] = definition.Mappings.Meta
+	}
+	return meta, nil
+}
+
+// SetSchemaVersion writes version as _meta.schema_version of every index
+// behind index, keeping its other _meta keys. A missing index is skipped.
+func SetSchemaVersion(client *elasticsearch.Client, index, version string) error {
+	metas, err := indexMeta(client, index)
+	if err != nil {
+		return err
+	}
+	for name, meta := range metas {
+		if meta == nil {
+			meta = make(map[string]interface{})
+		}
+		meta[SchemaVersionField] = version
+		body, err := json.Marshal(map[string]interface{}{"_meta": meta})
+		if err != nil {
+			return fmt.Errorf("error marshaling _meta of %s: %w", name, err)
+		}
+
+		res, err := client.Indices.PutMapping([]string{name}, strings.NewReader(string(body)))
+		if err != nil {
+			return fmt.Errorf("error writing schema version of %s: %w", name, err)
+		}
+		res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error writing schema version of %s: %s", name, res.String())
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the _meta.schema_version of index, empty when it
+// has none. An alias or pattern resolving to indices at different versions
+// is an error.
+func SchemaVersion(client *elasticsearch.Client, index string) (string, error) {
+	metas, err := indexMeta(client, index)
+	if err != nil {
+		return "", err
+	}
+	if metas == nil {
+		return "", fmt.Errorf("index %s does not exist", index)
+	}
+
+	versions := make(map[string][]string)
+	for name, meta := range metas {
+		version, _ := meta[SchemaVersionField].(string)
+		versions[version] = append(versions[version], name)
+	}
+	if len(versions) > 1 {
+		var found []string
+		for version, names := range versions {
+			sort.Strings(names)
+			if version == "" {
+				version = "none"
+			}
+			found = append(found, fmt.Sprintf("%s (%s)", version, strings.Join(names, ", ")))
+		}
+		sort.Strings(found)
+		return "", fmt.Errorf("indices behind %s are at different schema versions: %s", index, strings.Join(found, "; "))
+	}
+	for version := range versions {
+		return version, nil
+	}
+	return "", nil
+}
+
+// SchemaVersionError is returned by CheckSchemaVersion when an index is not
+// at an accepted schema version
+type SchemaVersionError struct {
+	Index    string
+	Version  string
+	Accepted []string
+}
+
+func (e *SchemaVersionError) Error() string {
+	version := e.Version
+	if version == "" {
+		version = "no schema version"
+	}
+	return fmt.Sprintf("index %s is at %s, expected %s", e.Index, version, strings.Join(e.Accepted, " or "))
+}
+
+// CheckSchemaVersion returns a *SchemaVersionError unless index is at one of
+// the accepted schema versions, typically the version of the last migration
+// the application was built against. Accepting the next version too lets
+// instances of the previous release keep starting while a release migrates.
+func CheckSchemaVersion(client *elasticsearch.Client, index string, accepted ...string) error {
+	version, err := SchemaVersion(client, index)
+	if err != nil {
+		return err
+	}
+	for _, want := range accepted {
+		if version == want {
+			return nil
+		}
+	}
+	return &SchemaVersionError{Index: index, Version: version, Accepted: accepted}
+}
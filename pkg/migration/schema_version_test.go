@@ -0,0 +1,75 @@
+package migration_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestSchemaVersions(t *testing.T) {
+	es := migrationtest.NewFakeES(t)
+	es.CreateIndex("articles", `{"mappings": {"_meta": {"owner": "search"}, "properties": {"title": {"type": "text"}}}}`)
+	es.CreateIndex("authors", "")
+
+	tags := migration.PutMapping("Add tags to articles", "articles", `{"properties": {"tags": {"type": "keyword"}}}`)
+	dropAuthors := migration.NewMigration("Drop authors", func(client *elasticsearch.Client) error {
+		res, err := client.Indices.Delete([]string{"authors"})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return errors.New(res.String())
+		}
+		return nil
+	})
+	mm := es.Manager(migration.WithMigrations(tags, dropAuthors), migration.WithSchemaVersions())
+	if err := mm.RunMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	client := es.Client()
+	version, err := migration.SchemaVersion(client, "articles")
+	if err != nil {
+		t.Fatalf("Failed to read schema version: %v", err)
+	}
+	if version != tags.Version() {
+		t.Errorf("Expected articles at %s, got %q", tags.Version(), version)
+	}
+	if meta, _ := es.Mapping("articles")["_meta"].(map[string]interface{}); meta["owner"] != "search" {
+		t.Errorf("Expected other _meta keys kept, got %v", meta)
+	}
+
+	t.Run("Test Check Accepts Listed Versions", func(t *testing.T) {
+		if err := migration.CheckSchemaVersion(client, "articles", "00000000", tags.Version()); err != nil {
+			t.Errorf("Expected the version accepted, got %v", err)
+		}
+		err := migration.CheckSchemaVersion(client, "articles", "00000000")
+		var versionErr *migration.SchemaVersionError
+		if !errors.As(err, &versionErr) || versionErr.Version != tags.Version() {
+			t.Errorf("Expected a schema version error, got %v", err)
+		}
+	})
+
+	t.Run("Test Unstamped Indices Have No Version", func(t *testing.T) {
+		es.CreateIndex("comments", "")
+		err := migration.CheckSchemaVersion(client, "comments", tags.Version())
+		if err == nil || !strings.Contains(err.Error(), "comments is at no schema version") {
+			t.Errorf("Expected a missing version reported, got %v", err)
+		}
+	})
+
+	t.Run("Test Mixed Versions Are Reported", func(t *testing.T) {
+		if err := migration.SetSchemaVersion(client, "comments", "1234abcd"); err != nil {
+			t.Fatalf("Failed to set schema version: %v", err)
+		}
+		_, err := migration.SchemaVersion(client, "articles,comments")
+		if err == nil || !strings.Contains(err.Error(), "different schema versions") {
+			t.Errorf("Expected mixed versions reported, got %v", err)
+		}
+	})
+}
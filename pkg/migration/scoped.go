@@ -0,0 +1,311 @@
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/elastic/go-elasticsearch/v8/typedapi"
+)
+
+// ClientPolicy restricts what migrations can do through the client they are given
+type ClientPolicy struct {
+	// IndexPrefix, when set, is required on every index a migration writes to
+	IndexPrefix string
+	// ProtectedIndices are index name patterns (path.Match syntax) that
+	// migrations may read but not modify. The elasticmate system indices are
+	// always protected.
+	ProtectedIndices []string
+	// Capture records every request made by the migration
+	Capture bool
+}
+
+// CapturedRequest is a request made by a migration through a scoped client
+type CapturedRequest struct {
+	Method  string   `json:"method"`
+	Path    string   `json:"path"`
+	Body    string   `json:"body,omitempty"`
+	Indices []string `json:"indices,omitempty"`
+	Write   bool     `json:"write"`
+}
+
+// ScopedTransport enforces a ClientPolicy on requests before passing them to
+// the underlying client
+type ScopedTransport struct {
+	next   esapi.Transport
+	policy ClientPolicy
+
+	mu       sync.Mutex
+	requests []CapturedRequest
+}
+
+// NewScopedClient wraps client so that every request made through the
+// returned client is checked against policy
+func NewScopedClient(client *elasticsearch.Client, policy ClientPolicy) (*elasticsearch.Client, *ScopedTransport) {
+	transport := &ScopedTransport{next: transportOf(client), policy: policy}
+	return ClientFromTransport(transport), transport
+}
+
+// ClientFromTransport returns an *elasticsearch.Client sending API calls
+// through transport
+func ClientFromTransport(transport esapi.Transport) *elasticsearch.Client {
+	client := &elasticsearch.Client{
+		BaseClient: elasticsearch.BaseClient{Transport: transport},
+	}
+	client.API = esapi.New(transport)
+	return client
+}
+
+// transportOf returns the transport the client sends requests through. The
+// client itself is never used as a transport: its product check rejects
+// servers that are not Elasticsearch, such as OpenSearch.
+func transportOf(client *elasticsearch.Client) esapi.Transport {
+	if client == nil {
+		return nil
+	}
+	return client.Transport
+}
+
+// TypedClientFromTransport returns an *elasticsearch.TypedClient sending API
+// calls through transport
+func TypedClientFromTransport(transport esapi.Transport) *elasticsearch.TypedClient {
+	client := &elasticsearch.TypedClient{
+		BaseClient: elasticsearch.BaseClient{Transport: transport},
+	}
+	client.API = typedapi.New(transport)
+	return client
+}
+
+// Requests returns the requests captured so far
+func (t *ScopedTransport) Requests() []CapturedRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]CapturedRequest{}, t.requests...)
+}
+
+func (t *ScopedTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("scoped client: error reading request body: %w", err)
+		}
+		req.Body.Close()
+		body = data
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	reads, writes := requestIndices(req.Method, req.URL.Path, body)
+
+	for _, index := range writes {
+		if err := t.checkWrite(index); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.policy.Capture {
+		t.mu.Lock()
+		t.requests = append(t.requests, CapturedRequest{
+			Method:  req.Method,
+			Path:    req.URL.RequestURI(),
+			Body:    string(body),
+			Indices: append(append([]string{}, writes...), reads...),
+			Write:   len(writes) > 0,
+		})
+		t.mu.Unlock()
+	}
+
+	return t.next.Perform(req)
+}
+
+// writeRecorder notes the indices written by requests passing through it
+type writeRecorder struct {
+	next esapi.Transport
+
+	mu      sync.Mutex
+	indices map[string]bool
+}
+
+func (r *writeRecorder) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		req.Body.Close()
+		body = data
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	_, writes := requestIndices(req.Method, req.URL.Path, body)
+	if len(writes) > 0 {
+		r.mu.Lock()
+		if r.indices == nil {
+			r.indices = make(map[string]bool)
+		}
+		for _, index := range writes {
+			r.indices[index] = true
+		}
+		r.mu.Unlock()
+	}
+
+	return r.next.Perform(req)
+}
+
+// Indices returns the written indices, sorted
+func (r *writeRecorder) Indices() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	indices := make([]string, 0, len(r.indices))
+	for index := range r.indices {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices
+}
+
+func (t *ScopedTransport) checkWrite(index string) error {
+	if strings.HasPrefix(index, migrationsIndex) || strings.HasPrefix(index, maintenanceIndex) {
+		return fmt.Errorf("scoped client: index %q is reserved for elasticmate", index)
+	}
+
+	if t.policy.IndexPrefix != "" && !strings.HasPrefix(index, t.policy.IndexPrefix) {
+		return fmt.Errorf("scoped client: index %q is outside prefix %q", index, t.policy.IndexPrefix)
+	}
+
+	for _, pattern := range t.policy.ProtectedIndices {
+		if index == "*" || index == "_all" {
+			return fmt.Errorf("scoped client: writing to %q would touch protected indices %q", index, pattern)
+		}
+		if matched, _ := path.Match(pattern, index); matched {
+			return fmt.Errorf("scoped client: index %q is protected", index)
+		}
+	}
+
+	return nil
+}
+
+// readEndpoints are endpoints that only read, even when called with POST
+var readEndpoints = map[string]bool{
+	"_search":       true,
+	"_msearch":      true,
+	"_count":        true,
+	"_field_caps":   true,
+	"_analyze":      true,
+	"_validate":     true,
+	"_explain":      true,
+	"_mget":         true,
+	"_termvectors":  true,
+	"_mtermvectors": true,
+}
+
+// requestIndices returns the indices a request reads from and writes to
+func requestIndices(method, urlPath string, body []byte) (reads, writes []string) {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if len(segments) == 0 || segments[0] == "" {
+		return nil, nil
+	}
+
+	readOnly := method == http.MethodGet || method == http.MethodHead
+	for _, segment := range segments[1:] {
+		if readEndpoints[segment] {
+			readOnly = true
+		}
+	}
+
+	if !strings.HasPrefix(segments[0], "_") {
+		indices := strings.Split(segments[0], ",")
+		if readOnly {
+			return indices, nil
+		}
+		return nil, indices
+	}
+
+	if readOnly {
+		return nil, nil
+	}
+
+	switch segments[0] {
+	case "_reindex":
+		var payload struct {
+			Source struct {
+				Index interface{} `json:"index"`
+			} `json:"source"`
+			Dest struct {
+				Index string `json:"index"`
+			} `json:"dest"`
+		}
+		if json.Unmarshal(body, &payload) == nil {
+			reads = stringOrList(payload.Source.Index)
+			if payload.Dest.Index != "" {
+				writes = []string{payload.Dest.Index}
+			}
+		}
+	case "_aliases":
+		var payload struct {
+			Actions []map[string]struct {
+				Index   string   `json:"index"`
+				Indices []string `json:"indices"`
+			} `json:"actions"`
+		}
+		if json.Unmarshal(body, &payload) == nil {
+			for _, action := range payload.Actions {
+				for _, target := range action {
+					if target.Index != "" {
+						writes = append(writes, target.Index)
+					}
+					writes = append(writes, target.Indices...)
+				}
+			}
+		}
+	case "_bulk":
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+		seen := map[string]bool{}
+		for scanner.Scan() {
+			var action map[string]struct {
+				Index string `json:"_index"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &action) != nil {
+				continue
+			}
+			for op, meta := range action {
+				if op == "index" || op == "create" || op == "update" || op == "delete" {
+					if meta.Index != "" && !seen[meta.Index] {
+						seen[meta.Index] = true
+						writes = append(writes, meta.Index)
+					}
+				}
+			}
+		}
+	}
+
+	return reads, writes
+}
+
+func stringOrList(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return strings.Split(value, ",")
+	case []interface{}:
+		var out []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
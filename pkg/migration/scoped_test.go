@@ -0,0 +1,87 @@
+package migration
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+type stubTransport struct {
+	requests int
+}
+
+func (s *stubTransport) Perform(req *http.Request) (*http.Response, error) {
+	s.requests++
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+func TestScopedClient(t *testing.T) {
+	newScoped := func(policy ClientPolicy) (*ScopedTransport, *stubTransport) {
+		stub := &stubTransport{}
+		return &ScopedTransport{next: stub, policy: policy}, stub
+	}
+
+	t.Run("Test Prefix Enforcement", func(t *testing.T) {
+		scope, stub := newScoped(ClientPolicy{IndexPrefix: "orders_"})
+		client := ClientFromTransport(scope)
+
+		if _, err := client.Indices.Create("users"); err == nil {
+			t.Error("Expected creating an index outside the prefix to fail")
+		}
+		if _, err := client.Indices.Create("orders_v2"); err != nil {
+			t.Errorf("Expected creating an index inside the prefix to succeed, got %v", err)
+		}
+		if _, err := client.Search(client.Search.WithIndex("users")); err != nil {
+			t.Errorf("Expected reads outside the prefix to succeed, got %v", err)
+		}
+		if stub.requests != 2 {
+			t.Errorf("Expected 2 requests to reach the cluster, got %d", stub.requests)
+		}
+	})
+
+	t.Run("Test Protected Indices", func(t *testing.T) {
+		scope, _ := newScoped(ClientPolicy{ProtectedIndices: []string{"prod-*"}})
+		client := ClientFromTransport(scope)
+
+		reindex := `{"source": {"index": "prod-users"}, "dest": {"index": "prod-users-v2"}}`
+		if _, err := client.Reindex(strings.NewReader(reindex)); err == nil {
+			t.Error("Expected reindexing into a protected index to fail")
+		}
+
+		reindex = `{"source": {"index": "prod-users"}, "dest": {"index": "users-v2"}}`
+		if _, err := client.Reindex(strings.NewReader(reindex)); err != nil {
+			t.Errorf("Expected reindexing from a protected index to succeed, got %v", err)
+		}
+
+		if _, err := client.Index(migrationsIndex, strings.NewReader(`{}`)); err == nil {
+			t.Error("Expected writes to the tracking index to fail")
+		}
+	})
+
+	t.Run("Test Request Capture", func(t *testing.T) {
+		scope, _ := newScoped(ClientPolicy{Capture: true})
+		client := ClientFromTransport(scope)
+
+		bulk := "{\"index\": {\"_index\": \"articles\"}}\n{\"title\": \"a\"}\n"
+		req := esapi.BulkRequest{Body: strings.NewReader(bulk)}
+		if _, err := req.Do(context.Background(), client); err != nil {
+			t.Fatalf("Bulk request failed: %v", err)
+		}
+
+		requests := scope.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("Expected 1 captured request, got %d", len(requests))
+		}
+		if !requests[0].Write || len(requests[0].Indices) != 1 || requests[0].Indices[0] != "articles" {
+			t.Errorf("Expected a write to articles, got %+v", requests[0])
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// StoredScript is a script stored in the cluster state under an id
+type StoredScript struct {
+	ID string
+	// Lang is the script language (default "painless"); "mustache" stores a
+	// search template
+	Lang   string
+	Source string
+	// Context is the script context the script is compiled for, such as
+	// "update", "score" or "ingest". Painless scripts without a context are
+	// compile-checked in the painless_test context.
+	Context string
+	// Params are passed to the compile-check execution, for scripts that need
+	// parameters to run
+	Params map[string]interface{}
+}
+
+func (s StoredScript) lang() string {
+	if s.Lang == "" {
+		return "painless"
+	}
+	return s.Lang
+}
+
+// ScriptError is a script the cluster failed to compile
+type ScriptError struct {
+	ID     string
+	Reason string
+	// Stack is the script excerpt around the error, with a marker line
+	// pointing at the failing position
+	Stack []string
+}
+
+func (e *ScriptError) Error() string {
+	message := fmt.Sprintf("script %s does not compile: %s", e.ID, e.Reason)
+	if len(e.Stack) > 0 {
+		message += "\n" + strings.Join(e.Stack, "\n")
+	}
+	return message
+}
+
+// PutStoredScript returns a migration that compile-checks a script against the
+// cluster and stores it, so broken scripts fail the migration instead of
+// surfacing at query time
+func PutStoredScript(description string, script StoredScript) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		if script.lang() == "painless" && executableContext(script.Context) {
+			if err := checkScript(client, script); err != nil {
+				return err
+			}
+		}
+		if err := putScript(client, script); err != nil {
+			return err
+		}
+		return verifyScript(client, script)
+	})
+	m.validate = func() error {
+		if script.ID == "" || script.Source == "" {
+			return fmt.Errorf("stored script requires an id and a source")
+		}
+		return nil
+	}
+	return m.Declares(Resource{Kind: ResourceStoredScript, Name: script.ID})
+}
+
+// DeleteStoredScript returns a migration that removes a stored script
+func DeleteStoredScript(description, id string) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		res, err := client.DeleteScript(id)
+		if err != nil {
+			return fmt.Errorf("error deleting script %s: %w", id, err)
+		}
+		defer res.Body.Close()
+
+		if res.IsError() && res.StatusCode != 404 {
+			return fmt.Errorf("error deleting script %s: %s", id, res.String())
+		}
+		return nil
+	}).MarkDestructive().Removes(Resource{Kind: ResourceStoredScript, Name: id})
+}
+
+// executableContext reports whether the Painless execute API can run scripts
+// of context without an index to run against
+func executableContext(context string) bool {
+	return context == "" || context == "painless_test"
+}
+
+// checkScript runs the script through the Painless execute API. Only compile
+// errors fail the check; a script that compiles but fails at run time without
+// real documents is accepted.
+func checkScript(client *elasticsearch.Client, script StoredScript) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": script.Source,
+			"params": script.Params,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling script %s: %w", script.ID, err)
+	}
+
+	res, err := client.ScriptsPainlessExecute(client.ScriptsPainlessExecute.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return fmt.Errorf("error compiling script %s: %w", script.ID, err)
+	}
+	defer res.Body.Close()
+
+	if !res.IsError() {
+		return nil
+	}
+	data, _ := io.ReadAll(res.Body)
+	if compileErr := scriptCompileError(script.ID, data); compileErr != nil {
+		return compileErr
+	}
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("error compiling script %s: [%d] %s", script.ID, res.StatusCode, data)
+	}
+	return nil
+}
+
+func putScript(client *elasticsearch.Client, script StoredScript) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]string{
+			"lang":   script.lang(),
+			"source": script.Source,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling script %s: %w", script.ID, err)
+	}
+
+	opts := []func(*esapi.PutScriptRequest){}
+	if script.Context != "" {
+		opts = append(opts, client.PutScript.WithScriptContext(script.Context))
+	}
+
+	res, err := client.PutScript(script.ID, bytes.NewReader(body), opts...)
+	if err != nil {
+		return fmt.Errorf("error storing script %s: %w", script.ID, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		data, _ := io.ReadAll(res.Body)
+		if compileErr := scriptCompileError(script.ID, data); compileErr != nil {
+			return compileErr
+		}
+		return fmt.Errorf("error storing script %s: [%d] %s", script.ID, res.StatusCode, data)
+	}
+	return nil
+}
+
+// verifyScript reads the script back to confirm the cluster stored it
+func verifyScript(client *elasticsearch.Client, script StoredScript) error {
+	res, err := client.GetScript(script.ID)
+	if err != nil {
+		return fmt.Errorf("error reading script %s: %w", script.ID, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error reading script %s: %s", script.ID, res.String())
+	}
+
+	var result struct {
+		Found  bool `json:"found"`
+		Script struct {
+			Source string `json:"source"`
+		} `json:"script"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error parsing script %s: %w", script.ID, err)
+	}
+	if !result.Found || result.Script.Source != script.Source {
+		return fmt.Errorf("script %s was not stored", script.ID)
+	}
+
+	clientLogf(client)("Stored script %s", script.ID)
+	return nil
+}
+
+// scriptCompileError returns a *ScriptError when data is a compile error
+// response, nil otherwise
+func scriptCompileError(id string, data []byte) error {
+	var response struct {
+		Error struct {
+			Type        string   `json:"type"`
+			Reason      string   `json:"reason"`
+			ScriptStack []string `json:"script_stack"`
+			CausedBy    struct {
+				Reason string `json:"reason"`
+			} `json:"caused_by"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil
+	}
+	if response.Error.Type != "script_exception" || response.Error.Reason != "compile error" {
+		return nil
+	}
+
+	reason := response.Error.CausedBy.Reason
+	if reason == "" {
+		reason = response.Error.Reason
+	}
+	return &ScriptError{ID: id, Reason: reason, Stack: response.Error.ScriptStack}
+}
@@ -0,0 +1,103 @@
+package migration
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// scriptTransport stores scripts and fails the execute API with compile
+// errors for sources containing "broken"
+type scriptTransport struct {
+	stored   map[string]string
+	requests []string
+}
+
+func (s *scriptTransport) Perform(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.Path
+	s.requests = append(s.requests, key)
+
+	var data []byte
+	if req.Body != nil {
+		data, _ = io.ReadAll(req.Body)
+	}
+
+	status, body := 200, `{}`
+	switch {
+	case req.URL.Path == "/_scripts/painless/_execute":
+		body = `{"result": "0"}`
+		if strings.Contains(string(data), "broken") {
+			status = 400
+			body = `{"error": {"type": "script_exception", "reason": "compile error",
+				"script_stack": ["broken +", "       ^---- HERE"],
+				"caused_by": {"type": "illegal_argument_exception", "reason": "unexpected end of script."}}}`
+		}
+	case strings.HasPrefix(req.URL.Path, "/_scripts/") && req.Method == http.MethodPut:
+		s.stored[strings.Split(req.URL.Path, "/")[2]] = string(data)
+		body = `{"acknowledged": true}`
+	case strings.HasPrefix(req.URL.Path, "/_scripts/") && req.Method == http.MethodGet:
+		id := strings.TrimPrefix(req.URL.Path, "/_scripts/")
+		stored, ok := s.stored[id]
+		if !ok {
+			status, body = 404, `{"_id": "`+id+`", "found": false}`
+			break
+		}
+		source := strings.TrimSuffix(strings.SplitN(stored, `"source":`, 2)[1], "}}")
+		body = `{"_id": "` + id + `", "found": true, "script": {"lang": "painless", "source": ` + source + `}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestStoredScripts(t *testing.T) {
+	t.Run("Test Script Is Checked And Stored", func(t *testing.T) {
+		transport := &scriptTransport{stored: map[string]string{}}
+		m := PutStoredScript("Store popularity script", StoredScript{
+			ID:     "popularity",
+			Source: "params.views * 2",
+			Params: map[string]interface{}{"views": 1},
+		})
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+
+		expected := []string{"POST /_scripts/painless/_execute", "PUT /_scripts/popularity", "GET /_scripts/popularity"}
+		if strings.Join(transport.requests, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected requests %v, got %v", expected, transport.requests)
+		}
+	})
+
+	t.Run("Test Compile Error Fails Before Storing", func(t *testing.T) {
+		transport := &scriptTransport{stored: map[string]string{}}
+		err := PutStoredScript("Store broken script", StoredScript{ID: "broken", Source: "broken +"}).
+			run(ClientFromTransport(transport), nil)
+
+		var scriptErr *ScriptError
+		if !errors.As(err, &scriptErr) || scriptErr.Reason != "unexpected end of script." || len(scriptErr.Stack) != 2 {
+			t.Fatalf("Expected a compile error with a script stack, got %v", err)
+		}
+		if len(transport.stored) != 0 {
+			t.Errorf("Expected no script to be stored, got %v", transport.stored)
+		}
+	})
+
+	t.Run("Test Context Scripts Skip Execute API", func(t *testing.T) {
+		transport := &scriptTransport{stored: map[string]string{}}
+		m := PutStoredScript("Store update script", StoredScript{
+			ID:      "bump",
+			Source:  "ctx._source.counter += 1",
+			Context: "update",
+		})
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		if transport.requests[0] != "PUT /_scripts/bump/update" {
+			t.Errorf("Expected the script to be stored for the update context, got %v", transport.requests)
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package migration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrSecretNotFound is returned by secret providers for references they do
+// not hold
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider looks up secrets, such as cluster credentials or the keys
+// of a snapshot repository, so they never land in migration files. The
+// reference format depends on the provider; those reading JSON secrets
+// select a key with name#key.
+type SecretProvider interface {
+	Secret(ref string) (string, error)
+}
+
+// secretPlaceholder matches ${secret:REF} placeholders
+var secretPlaceholder = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+// ResolveSecrets replaces the ${secret:REF} placeholders of s with the
+// secrets provider holds, e.g. in the body of a snapshot repository a Go
+// migration registers. It fails on the first secret that does not resolve.
+func ResolveSecrets(provider SecretProvider, s string) (string, error) {
+	var err error
+	resolved := secretPlaceholder.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		var secret string
+		secret, err = lookupSecret(provider, secretPlaceholder.FindStringSubmatch(match)[1])
+		return secret
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+func lookupSecret(provider SecretProvider, ref string) (string, error) {
+	if provider == nil {
+		return "", fmt.Errorf("secret %s: no secret provider configured", ref)
+	}
+	secret, err := provider.Secret(ref)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", ref, err)
+	}
+	return secret, nil
+}
+
+// EnvSecrets reads secrets from environment variables named like the
+// reference, with Prefix prepended
+type EnvSecrets struct {
+	Prefix string
+}
+
+func (e EnvSecrets) Secret(ref string) (string, error) {
+	secret, ok := os.LookupEnv(e.Prefix + ref)
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+// FileSecrets reads secrets from files of Dir named like the reference, such
+// as Docker secrets in /run/secrets or a mounted Kubernetes Secret. A
+// trailing newline is removed.
+type FileSecrets struct {
+	Dir string
+}
+
+func (f FileSecrets) Secret(ref string) (string, error) {
+	name := filepath.FromSlash(ref)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("reference %q leaves %s", ref, f.Dir)
+	}
+	data, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading secret: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// VaultSecrets reads secrets from a HashiCorp Vault KV version 2 secrets
+// engine. References are the path of a secret and the key to return, e.g.
+// elasticsearch/migrator#password.
+type VaultSecrets struct {
+	Address string // e.g. https://vault.example.com:8200
+	Token   string
+	// Mount is the path the secrets engine is mounted at, "secret" by
+	// default
+	Mount string
+
+	HTTPClient *http.Client
+}
+
+func (v VaultSecrets) Secret(ref string) (string, error) {
+	path, key, _ := strings.Cut(ref, "#")
+	mount := v.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(v.Address, "/")+"/v1/"+mount+"/data/"+path, nil)
+	if err != nil {
+		return "", fmt.Errorf("error building Vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret from Vault: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", ErrSecretNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return "", fmt.Errorf("error reading secret from Vault: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing Vault secret: %w", err)
+	}
+	return secretKey(result.Data.Data, key)
+}
+
+// AWSSecrets reads secrets from AWS Secrets Manager. References are the name
+// or ARN of a secret, followed by #key to return a key of a JSON secret.
+type AWSSecrets struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Optional, for temporary credentials
+	// Endpoint defaults to https://secretsmanager.<region>.amazonaws.com
+	Endpoint string
+
+	HTTPClient *http.Client
+}
+
+func (a AWSSecrets) Secret(ref string) (string, error) {
+	name, key, _ := strings.Cut(ref, "#")
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", a.Region)
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": name})
+	if err != nil {
+		return "", fmt.Errorf("error encoding secret request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("error building Secrets Manager request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	credentials := awsCredentials{
+		Region:          a.Region,
+		AccessKeyID:     a.AccessKeyID,
+		SecretAccessKey: a.SecretAccessKey,
+		SessionToken:    a.SessionToken,
+	}
+	credentials.sign(req, "secretsmanager", "/", body, time.Now().UTC())
+
+	client := a.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret from Secrets Manager: %w", err)
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Type         string `json:"__type"`
+		Message      string `json:"message"`
+		SecretString *string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing Secrets Manager response: %s", res.Status)
+	}
+	if strings.HasSuffix(result.Type, "ResourceNotFoundException") {
+		return "", ErrSecretNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error reading secret from Secrets Manager: %s: %s %s", res.Status, result.Type, result.Message)
+	}
+	if result.SecretString == nil {
+		return "", fmt.Errorf("secret %s is binary", name)
+	}
+	if key == "" {
+		return *result.SecretString, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(*result.SecretString), &values); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object to read %s from", name, key)
+	}
+	return secretKey(values, key)
+}
+
+// secretKey returns key of a JSON secret, or its only key when key is empty
+func secretKey(values map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(values) != 1 {
+			return "", fmt.Errorf("secret has %d keys; select one with #key", len(values))
+		}
+		for only := range values {
+			key = only
+		}
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("key %s: %w", key, ErrSecretNotFound)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("error encoding key %s: %w", key, err)
+	}
+	return string(encoded), nil
+}
+
+// resolveCredentials resolves the ${secret:REF} placeholders of the
+// credentials of cfg
+func (cfg *ClientConfig) resolveCredentials() error {
+	for _, credential := range []*string{&cfg.Username, &cfg.Password, &cfg.APIKey, &cfg.BearerToken} {
+		if !secretPlaceholder.MatchString(*credential) {
+			continue
+		}
+		resolved, err := ResolveSecrets(cfg.Secrets, *credential)
+		if err != nil {
+			return fmt.Errorf("error resolving credentials: %w", err)
+		}
+		*credential = resolved
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// staticSecrets holds secrets in memory
+type staticSecrets map[string]string
+
+func (s staticSecrets) Secret(ref string) (string, error) {
+	secret, ok := s[ref]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func TestSecretProviders(t *testing.T) {
+	t.Run("Test Environment", func(t *testing.T) {
+		t.Setenv("SECRET_S3_KEY", "abc")
+		if secret, err := (EnvSecrets{Prefix: "SECRET_"}).Secret("S3_KEY"); err != nil || secret != "abc" {
+			t.Errorf("Expected abc, got %q (%v)", secret, err)
+		}
+		if _, err := (EnvSecrets{Prefix: "SECRET_"}).Secret("MISSING"); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("Expected ErrSecretNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Test Files", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "es_password"), []byte("hunter2\n"), 0600)
+
+		files := FileSecrets{Dir: dir}
+		if secret, err := files.Secret("es_password"); err != nil || secret != "hunter2" {
+			t.Errorf("Expected hunter2, got %q (%v)", secret, err)
+		}
+		if _, err := files.Secret("missing"); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("Expected ErrSecretNotFound, got %v", err)
+		}
+		if _, err := files.Secret("../etc/passwd"); err == nil {
+			t.Error("Expected references leaving the directory to be refused")
+		}
+	})
+
+	t.Run("Test Vault", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Vault-Token") != "s.token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.URL.Path != "/v1/kv/data/elasticsearch/migrator" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"data": {"data": {"username": "migrator", "password": "hunter2"}}}`))
+		}))
+		defer server.Close()
+
+		vault := VaultSecrets{Address: server.URL, Token: "s.token", Mount: "kv"}
+		if secret, err := vault.Secret("elasticsearch/migrator#password"); err != nil || secret != "hunter2" {
+			t.Errorf("Expected hunter2, got %q (%v)", secret, err)
+		}
+		if _, err := vault.Secret("elasticsearch/migrator"); err == nil {
+			t.Error("Expected an error for a secret with several keys and no key selected")
+		}
+		if _, err := vault.Secret("elasticsearch/other#password"); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("Expected ErrSecretNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Test AWS Secrets Manager", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var request struct{ SecretId string }
+			json.Unmarshal(body, &request)
+			if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+				!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if request.SecretId != "prod/snapshots" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "Secrets Manager can't find the specified secret."}`))
+				return
+			}
+			w.Write([]byte(`{"Name": "prod/snapshots", "SecretString": "{\"access_key\": \"AKIA\", \"secret_key\": \"s3cr3t\"}"}`))
+		}))
+		defer server.Close()
+
+		aws := AWSSecrets{Region: "eu-west-1", AccessKeyID: "id", SecretAccessKey: "key", Endpoint: server.URL}
+		if secret, err := aws.Secret("prod/snapshots#secret_key"); err != nil || secret != "s3cr3t" {
+			t.Errorf("Expected s3cr3t, got %q (%v)", secret, err)
+		}
+		if _, err := aws.Secret("prod/other"); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("Expected ErrSecretNotFound, got %v", err)
+		}
+	})
+}
+
+func TestSecretPlaceholders(t *testing.T) {
+	secrets := staticSecrets{"snapshots#secret_key": `s3"cr3t`}
+
+	t.Run("Test Resolve", func(t *testing.T) {
+		resolved, err := ResolveSecrets(secrets, `{"secret_key": "${secret:snapshots#secret_key}"}`)
+		if err != nil || resolved != `{"secret_key": "s3"cr3t"}` {
+			t.Errorf("Expected the secret to be resolved, got %q (%v)", resolved, err)
+		}
+		if _, err := ResolveSecrets(secrets, "${secret:missing}"); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("Expected ErrSecretNotFound, got %v", err)
+		}
+		if _, err := ResolveSecrets(nil, "${secret:missing}"); err == nil {
+			t.Error("Expected an error without a provider")
+		}
+	})
+
+	declared := fstest.MapFS{"001_pipeline_settings.yaml": {Data: []byte(`description: Store S3 key
+action: put_settings
+index: articles
+body:
+  index:
+    s3_key: ${secret:snapshots#secret_key}
+`)}}
+
+	t.Run("Test Declarations Resolve And Plans Redact", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{"HEAD /articles": `{}`}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithSecrets(secrets))
+		if err := mm.RegisterFS(declared); err != nil {
+			t.Fatalf("Failed to register migrations: %v", err)
+		}
+
+		plan, err := mm.Migrations[0].plan(func(string) (bool, error) { return true, nil })
+		if err != nil {
+			t.Fatalf("Failed to plan migration: %v", err)
+		}
+		if body := string(plan[0].Body); strings.Contains(body, "cr3t") || !strings.Contains(body, "${secret:snapshots#secret_key}") {
+			t.Errorf("Expected the plan to show the placeholder, got %s", body)
+		}
+
+		d, _ := decodeDeclaration(declared["001_pipeline_settings.yaml"].Data)
+		resolved, err := d.Resolve(Variables{Secrets: secrets})
+		if err != nil {
+			t.Fatalf("Failed to resolve declaration: %v", err)
+		}
+		if body, _ := declaredJSON(resolved.Body); !strings.Contains(body, `s3\"cr3t`) {
+			t.Errorf("Expected the declaration to hold the secret, got %s", body)
+		}
+	})
+
+	t.Run("Test Unresolved Secrets Fail", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()), WithSecrets(staticSecrets{}))
+		if err := mm.RegisterFS(declared); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("Expected ErrSecretNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Test Client Credentials", func(t *testing.T) {
+		var authorization string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			w.Write([]byte(`{}`))
+		}))
+		defer server.Close()
+
+		client, err := NewClient(ClientConfig{
+			Addresses: []string{server.URL},
+			APIKey:    "${secret:es_api_key}",
+			Secrets:   staticSecrets{"es_api_key": "a2V5"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		res, err := client.Info()
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		res.Body.Close()
+		if authorization != "APIKey a2V5" {
+			t.Errorf("Expected the resolved API key, got %q", authorization)
+		}
+	})
+}
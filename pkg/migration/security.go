@@ -0,0 +1,179 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// SecurityUser configures a native realm user
+type SecurityUser struct {
+	Username string
+	Roles    []string
+	FullName string
+	Email    string
+	Metadata map[string]interface{}
+
+	// PasswordEnv names an environment variable holding the password, read
+	// when the migration runs so it stays out of the code. Password and
+	// PasswordHash are used when it is empty; an existing user keeps its
+	// password when none is given.
+	PasswordEnv  string
+	Password     string
+	PasswordHash string
+}
+
+// PutRole returns a migration that creates or updates a security role. role is
+// the role definition, e.g.
+// {"indices": [{"names": ["articles*"], "privileges": ["read"]}]}.
+func PutRole(description, name, role string) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		res, err := client.Security.PutRole(name, strings.NewReader(role))
+		if err != nil {
+			return fmt.Errorf("error putting role %s: %w", name, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error putting role %s: %s", name, res.String())
+		}
+		return nil
+	})
+	m.validate = func() error {
+		return validSecurityObject("role", name, role)
+	}
+	return m.Declares(Resource{Kind: ResourceRole, Name: name})
+}
+
+// PutRoleMapping returns a migration that creates or updates a role mapping,
+// e.g. {"roles": ["reader"], "enabled": true, "rules": {"field": {"groups": "readers"}}}
+func PutRoleMapping(description, name, mapping string) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		res, err := client.Security.PutRoleMapping(name, strings.NewReader(mapping))
+		if err != nil {
+			return fmt.Errorf("error putting role mapping %s: %w", name, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error putting role mapping %s: %s", name, res.String())
+		}
+		return nil
+	})
+	m.validate = func() error {
+		return validSecurityObject("role mapping", name, mapping)
+	}
+	return m
+}
+
+// PutUser returns a migration that creates or updates a native realm user
+func PutUser(description string, user SecurityUser) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		body := map[string]interface{}{
+			"roles": user.Roles,
+		}
+		if user.FullName != "" {
+			body["full_name"] = user.FullName
+		}
+		if user.Email != "" {
+			body["email"] = user.Email
+		}
+		if user.Metadata != nil {
+			body["metadata"] = user.Metadata
+		}
+		switch {
+		case user.PasswordEnv != "":
+			password, ok := os.LookupEnv(user.PasswordEnv)
+			if !ok {
+				return fmt.Errorf("password of user %s: %s is not set", user.Username, user.PasswordEnv)
+			}
+			body["password"] = password
+		case user.Password != "":
+			body["password"] = user.Password
+		case user.PasswordHash != "":
+			body["password_hash"] = user.PasswordHash
+		}
+
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshaling user %s: %w", user.Username, err)
+		}
+
+		res, err := client.Security.PutUser(user.Username, strings.NewReader(string(data)))
+		if err != nil {
+			return fmt.Errorf("error putting user %s: %w", user.Username, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error putting user %s: %s", user.Username, res.String())
+		}
+		return nil
+	})
+	m.validate = func() error {
+		if user.Username == "" {
+			return fmt.Errorf("user requires a username")
+		}
+		if user.Roles == nil {
+			return fmt.Errorf("user %s requires roles", user.Username)
+		}
+		return nil
+	}
+	return m
+}
+
+// DeleteRole returns a migration that deletes a security role
+func DeleteRole(description, name string) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		res, err := client.Security.DeleteRole(name)
+		if err != nil {
+			return fmt.Errorf("error deleting role %s: %w", name, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() && res.StatusCode != 404 {
+			return fmt.Errorf("error deleting role %s: %s", name, res.String())
+		}
+		return nil
+	}).MarkDestructive().Removes(Resource{Kind: ResourceRole, Name: name})
+}
+
+// DeleteRoleMapping returns a migration that deletes a role mapping
+func DeleteRoleMapping(description, name string) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		res, err := client.Security.DeleteRoleMapping(name)
+		if err != nil {
+			return fmt.Errorf("error deleting role mapping %s: %w", name, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() && res.StatusCode != 404 {
+			return fmt.Errorf("error deleting role mapping %s: %s", name, res.String())
+		}
+		return nil
+	}).MarkDestructive()
+}
+
+// DeleteUser returns a migration that deletes a native realm user
+func DeleteUser(description, username string) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		res, err := client.Security.DeleteUser(username)
+		if err != nil {
+			return fmt.Errorf("error deleting user %s: %w", username, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() && res.StatusCode != 404 {
+			return fmt.Errorf("error deleting user %s: %s", username, res.String())
+		}
+		return nil
+	}).MarkDestructive()
+}
+
+func validSecurityObject(kind, name, body string) error {
+	if name == "" {
+		return fmt.Errorf("%s requires a name", kind)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &object); err != nil {
+		return fmt.Errorf("invalid %s %s: %w", kind, name, err)
+	}
+	return nil
+}
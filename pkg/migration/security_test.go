@@ -0,0 +1,58 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecurityObjects(t *testing.T) {
+	t.Run("Test Role And Mapping Requests", func(t *testing.T) {
+		transport := &routeTransport{}
+		client := ClientFromTransport(transport)
+
+		role := PutRole("Add article reader role", "article_reader", `{"indices": [{"names": ["articles*"], "privileges": ["read"]}]}`)
+		mapping := PutRoleMapping("Map readers group", "readers", `{"roles": ["article_reader"], "enabled": true, "rules": {"field": {"groups": "readers"}}}`)
+		for _, m := range []Migration{role, mapping} {
+			if err := m.Validate(); err != nil {
+				t.Fatalf("Failed to validate migration: %v", err)
+			}
+			if err := m.run(client, nil); err != nil {
+				t.Fatalf("Failed to run migration: %v", err)
+			}
+		}
+
+		for _, request := range []string{"PUT /_security/role/article_reader", "PUT /_security/role_mapping/readers"} {
+			if !transport.sent(request) {
+				t.Errorf("Expected request %s, got %v", request, transport.requests)
+			}
+		}
+	})
+
+	t.Run("Test User Password From Environment", func(t *testing.T) {
+		user := PutUser("Add reporting user", SecurityUser{
+			Username:    "reporting",
+			Roles:       []string{"article_reader"},
+			PasswordEnv: "REPORTING_PASSWORD",
+		})
+
+		err := user.run(ClientFromTransport(&routeTransport{}), nil)
+		if err == nil || !strings.Contains(err.Error(), "REPORTING_PASSWORD") {
+			t.Fatalf("Expected an error naming the unset variable, got %v", err)
+		}
+
+		t.Setenv("REPORTING_PASSWORD", "s3cret-passw0rd")
+		transport := &routeTransport{}
+		if err := user.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		if !transport.sent("PUT /_security/user/reporting") {
+			t.Errorf("Expected a put user request, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Invalid Role Fails Validation", func(t *testing.T) {
+		if err := PutRole("Broken role", "broken", `{"indices": [`).Validate(); err == nil {
+			t.Error("Expected validation error for an invalid role body")
+		}
+	})
+}
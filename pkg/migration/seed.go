@@ -0,0 +1,294 @@
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// SeedConfig configures a SeedMigration
+type SeedConfig struct {
+	Index string
+
+	// FS and Path locate the documents; Path may be a glob matching several
+	// files, which are read in name order. Data is used instead when set.
+	FS   fs.FS
+	Path string
+	Data []byte
+
+	// IDField names a document field used as the document ID, so seeding the
+	// same data twice overwrites instead of duplicating documents
+	IDField string
+	// BatchSize is the number of documents per bulk request (default 500)
+	BatchSize int
+	// Refresh is the refresh parameter of the last bulk request (default "true")
+	Refresh string
+	// MaxErrors is the number of documents that may fail to index before the
+	// migration fails
+	MaxErrors int
+}
+
+// SeedFailure is a document that could not be indexed
+type SeedFailure struct {
+	// Document is the position of the document in the source, starting at 0
+	Document int
+	ID       string
+	Reason   string
+}
+
+// SeedError lists the documents that failed to index
+type SeedError struct {
+	Index    string
+	Failures []SeedFailure
+}
+
+func (e *SeedError) Error() string {
+	reasons := make([]string, 0, 3)
+	for i, failure := range e.Failures {
+		if i == 3 {
+			reasons = append(reasons, "...")
+			break
+		}
+		reasons = append(reasons, fmt.Sprintf("document %d: %s", failure.Document, failure.Reason))
+	}
+	return fmt.Sprintf("%d documents failed to index into %s: %s", len(e.Failures), e.Index, strings.Join(reasons, "; "))
+}
+
+// SeedMigration returns a migration that bulk-indexes reference documents from
+// a JSON array, a single JSON object or NDJSON into an index
+func SeedMigration(description string, cfg SeedConfig) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		docs, err := loadSeedDocuments(cfg)
+		if err != nil {
+			return err
+		}
+		return seed(client, cfg, docs)
+	})
+	m.validate = func() error {
+		if cfg.Index == "" {
+			return fmt.Errorf("seed migration requires an index")
+		}
+		if cfg.Data == nil && (cfg.FS == nil || cfg.Path == "") {
+			return fmt.Errorf("seed migration for %s requires data or a file system and path", cfg.Index)
+		}
+		return nil
+	}
+	m.bulkBytes = func() (int64, error) {
+		docs, err := loadSeedDocuments(cfg)
+		if err != nil {
+			return 0, err
+		}
+		return largestSeedBatch(cfg, docs), nil
+	}
+	return m
+}
+
+// largestSeedBatch returns the approximate size of the largest bulk body
+// seeding docs sends
+func largestSeedBatch(cfg SeedConfig, docs []json.RawMessage) int64 {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	// the action line, with room for a document ID
+	action := int64(len(`{"index":{"_index":"","_id":""}}`)+len(cfg.Index)) + 32
+
+	var largest int64
+	for start := 0; start < len(docs); start += batchSize {
+		var size int64
+		for _, doc := range docs[start:min(start+batchSize, len(docs))] {
+			size += action + int64(len(doc)) + 2
+		}
+		largest = max(largest, size)
+	}
+	return largest
+}
+
+func loadSeedDocuments(cfg SeedConfig) ([]json.RawMessage, error) {
+	if cfg.Data != nil {
+		return parseSeedDocuments(cfg.Data, "seed data")
+	}
+
+	paths, err := fs.Glob(cfg.FS, cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error matching seed files %s: %w", cfg.Path, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no seed files match %s", cfg.Path)
+	}
+	sort.Strings(paths)
+
+	var docs []json.RawMessage
+	for _, path := range paths {
+		data, err := fs.ReadFile(cfg.FS, path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading seed file %s: %w", path, err)
+		}
+		parsed, err := parseSeedDocuments(data, path)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, parsed...)
+	}
+	return docs, nil
+}
+
+// parseSeedDocuments reads a JSON array, a single JSON object or NDJSON
+func parseSeedDocuments(data []byte, source string) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' {
+		var docs []json.RawMessage
+		if err := json.Unmarshal(trimmed, &docs); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", source, err)
+		}
+		return docs, nil
+	}
+
+	var docs []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if !json.Valid(text) {
+			// a single pretty-printed object rather than NDJSON
+			if line == 1 && json.Valid(trimmed) {
+				return []json.RawMessage{trimmed}, nil
+			}
+			return nil, fmt.Errorf("error parsing %s: invalid JSON on line %d", source, line)
+		}
+		docs = append(docs, json.RawMessage(append([]byte{}, text...)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", source, err)
+	}
+	return docs, nil
+}
+
+func seed(client *elasticsearch.Client, cfg SeedConfig, docs []json.RawMessage) error {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	refresh := cfg.Refresh
+	if refresh == "" {
+		refresh = "true"
+	}
+
+	seedErr := &SeedError{Index: cfg.Index}
+	for start := 0; start < len(docs); start += batchSize {
+		end := start + batchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		batchRefresh := "false"
+		if end == len(docs) {
+			batchRefresh = refresh
+		}
+
+		failures, err := seedBatch(client, cfg, docs[start:end], start, batchRefresh)
+		if err != nil {
+			return err
+		}
+		seedErr.Failures = append(seedErr.Failures, failures...)
+		if len(seedErr.Failures) > cfg.MaxErrors {
+			return seedErr
+		}
+	}
+
+	if len(seedErr.Failures) > 0 {
+		clientLogf(client)("Seeded %d documents into %s, %d tolerated failures", len(docs)-len(seedErr.Failures), cfg.Index, len(seedErr.Failures))
+	} else {
+		clientLogf(client)("Seeded %d documents into %s", len(docs), cfg.Index)
+	}
+	return nil
+}
+
+func seedBatch(client *elasticsearch.Client, cfg SeedConfig, docs []json.RawMessage, offset int, refresh string) ([]SeedFailure, error) {
+	var body bytes.Buffer
+	ids := make([]string, len(docs))
+
+	for i, doc := range docs {
+		action := map[string]string{"_index": cfg.Index}
+		if cfg.IDField != "" {
+			var fields map[string]interface{}
+			decoder := json.NewDecoder(bytes.NewReader(doc))
+			decoder.UseNumber()
+			if err := decoder.Decode(&fields); err != nil {
+				return nil, fmt.Errorf("error parsing seed document %d: %w", offset+i, err)
+			}
+			id, ok := fields[cfg.IDField]
+			if !ok {
+				return nil, fmt.Errorf("seed document %d has no %s field", offset+i, cfg.IDField)
+			}
+			ids[i] = fmt.Sprint(id)
+			action["_id"] = ids[i]
+		}
+
+		meta, err := json.Marshal(map[string]interface{}{"index": action})
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling bulk action: %w", err)
+		}
+		body.Write(meta)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	res, err := client.Bulk(&body, client.Bulk.WithRefresh(refresh))
+	if err != nil {
+		return nil, fmt.Errorf("error seeding %s: %w", cfg.Index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error seeding %s: %s", cfg.Index, res.String())
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID    string          `json:"_id"`
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+
+	var failures []SeedFailure
+	for i, item := range result.Items {
+		for _, outcome := range item {
+			if len(outcome.Error) == 0 {
+				continue
+			}
+			id := outcome.ID
+			if id == "" && i < len(ids) {
+				id = ids[i]
+			}
+			failures = append(failures, SeedFailure{
+				Document: offset + i,
+				ID:       id,
+				Reason:   string(outcome.Error),
+			})
+		}
+	}
+	return failures, nil
+}
@@ -0,0 +1,91 @@
+package migration
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// bulkTransport records bulk request bodies and fails documents whose source
+// contains "bad"
+type bulkTransport struct {
+	bodies []string
+}
+
+func (b *bulkTransport) Perform(req *http.Request) (*http.Response, error) {
+	data, _ := io.ReadAll(req.Body)
+	b.bodies = append(b.bodies, req.URL.RawQuery+"\n"+string(data))
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var items []string
+	errs := false
+	for i := 1; i < len(lines); i += 2 {
+		if strings.Contains(lines[i], "bad") {
+			errs = true
+			items = append(items, `{"index": {"error": {"type": "mapper_parsing_exception"}}}`)
+		} else {
+			items = append(items, `{"index": {"result": "created"}}`)
+		}
+	}
+	body := `{"errors": ` + map[bool]string{true: "true", false: "false"}[errs] + `, "items": [` + strings.Join(items, ",") + `]}`
+
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestSeedMigration(t *testing.T) {
+	files := fstest.MapFS{
+		"seed/countries.ndjson": {Data: []byte("{\"code\": \"de\"}\n{\"code\": \"fr\"}\n\n{\"code\": \"it\"}\n")},
+		"seed/extra.json":       {Data: []byte(`[{"code": "es"}, {"code": "pt"}]`)},
+	}
+
+	t.Run("Test Batches From FS", func(t *testing.T) {
+		transport := &bulkTransport{}
+		m := SeedMigration("Seed countries", SeedConfig{
+			Index:     "countries",
+			FS:        files,
+			Path:      "seed/*",
+			IDField:   "code",
+			BatchSize: 2,
+		})
+
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to seed: %v", err)
+		}
+		if len(transport.bodies) != 3 {
+			t.Fatalf("Expected 3 bulk requests for 5 documents, got %d", len(transport.bodies))
+		}
+		if !strings.Contains(transport.bodies[0], `"_id":"de"`) {
+			t.Errorf("Expected documents to be indexed by code, got %s", transport.bodies[0])
+		}
+		if !strings.HasPrefix(transport.bodies[0], "refresh=false") || !strings.HasPrefix(transport.bodies[2], "refresh=true") {
+			t.Errorf("Expected only the last batch to refresh, got %q and %q", transport.bodies[0], transport.bodies[2])
+		}
+	})
+
+	t.Run("Test Failures Are Collected", func(t *testing.T) {
+		data := []byte("{\"code\": \"ok\"}\n{\"code\": \"bad1\"}\n{\"code\": \"bad2\"}\n")
+
+		m := SeedMigration("Seed with errors", SeedConfig{Index: "countries", Data: data, MaxErrors: 1})
+		err := m.run(ClientFromTransport(&bulkTransport{}), nil)
+
+		var seedErr *SeedError
+		if !errors.As(err, &seedErr) {
+			t.Fatalf("Expected a SeedError, got %v", err)
+		}
+		if len(seedErr.Failures) != 2 || seedErr.Failures[0].Document != 1 {
+			t.Errorf("Expected documents 1 and 2 to fail, got %+v", seedErr.Failures)
+		}
+
+		m = SeedMigration("Seed tolerating errors", SeedConfig{Index: "countries", Data: data, MaxErrors: 2})
+		if err := m.run(ClientFromTransport(&bulkTransport{}), nil); err != nil {
+			t.Errorf("Expected failures within MaxErrors to be tolerated, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,510 @@
+// Package server is a long-running control service that keeps migration
+// bundles for many services and runs them against configured clusters, so a
+// platform team can manage schemas centrally. It backs `elasticmate serve`.
+//
+// A bundle is a zip archive of declarative migration files (see
+// migration.LoadFS). Each service's migrations are tracked in its own
+// namespace on every cluster.
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// maxBundleSize bounds uploaded bundles
+const maxBundleSize = 32 << 20
+
+// ClusterConfig is a cluster runs can target
+type ClusterConfig struct {
+	Name        string   `json:"name"`
+	Addresses   []string `json:"addresses,omitempty"`
+	CloudID     string   `json:"cloud_id,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	Password    string   `json:"password,omitempty"`
+	APIKey      string   `json:"api_key,omitempty"`
+	BearerToken string   `json:"bearer_token,omitempty"`
+	CACertFile  string   `json:"ca_cert_file,omitempty"`
+	OpenSearch  bool     `json:"opensearch,omitempty"`
+}
+
+// ProfileConfig is a named set of run options a run can select with
+// ?profile=name
+type ProfileConfig struct {
+	Name string `json:"name"`
+	// RequestsPerSecond and Slices throttle reindex and by query tasks
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	Slices            string  `json:"slices,omitempty"`
+	// Window holds pending migrations until an off-peak window, as
+	// HH:MM-HH:MM in WindowTimeZone (UTC by default)
+	Window         string `json:"window,omitempty"`
+	WindowTimeZone string `json:"window_tz,omitempty"`
+}
+
+// NotificationConfig is a target told the outcome of every run
+type NotificationConfig struct {
+	// Webhook receives the run summary as JSON, with Headers added
+	Webhook string            `json:"webhook,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Slack is a Slack incoming webhook receiving the summary as text
+	Slack string `json:"slack,omitempty"`
+}
+
+// Config configures the server
+type Config struct {
+	// Token must be sent as a bearer token with every request
+	Token         string               `json:"-"`
+	Clusters      []ClusterConfig      `json:"clusters"`
+	Profiles      []ProfileConfig      `json:"profiles,omitempty"`
+	Notifications []NotificationConfig `json:"notifications,omitempty"`
+	// BundleDir, when set, persists uploaded bundles so they survive restarts
+	BundleDir string `json:"bundle_dir,omitempty"`
+	// RunTimeout, when set, bounds each run
+	RunTimeout time.Duration `json:"-"`
+}
+
+// Bundle is the uploaded migrations of a service
+type Bundle struct {
+	Service    string                    `json:"service"`
+	UploadedAt time.Time                 `json:"uploaded_at"`
+	Migrations []migration.ManifestEntry `json:"migrations"`
+
+	data []byte
+}
+
+// Server serves the control API:
+//
+//	GET  /bundles                              list bundles
+//	PUT  /bundles/{service}                    upload a bundle (zip body)
+//	GET  /bundles/{service}                    show a bundle
+//	GET  /bundles/{service}/status?cluster=x   applied and pending migrations
+//	POST /bundles/{service}/run?cluster=x      apply pending migrations, with
+//	                                           &profile=y for a run profile
+type Server struct {
+	cfg Config
+	mux *http.ServeMux
+
+	mu sync.Mutex
+	// runs holds the clusters, profiles and notification targets runs
+	// started from now on use; Reload replaces it
+	runs    runConfig
+	bundles map[string]*Bundle
+	// running holds the service and cluster pairs with a run in progress
+	running map[string]bool
+
+	// newManager is replaced in tests
+	newManager func(cluster ClusterConfig, service string, opts ...migration.Option) (*migration.MigrationManager, error)
+}
+
+// runConfig is the part of the configuration Reload replaces
+type runConfig struct {
+	clusters      map[string]ClusterConfig
+	profiles      map[string][]migration.Option
+	notifications []migration.Option
+}
+
+var serviceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// New returns a server, loading the bundles persisted in cfg.BundleDir
+func New(cfg Config) (*Server, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("server requires a token")
+	}
+	runs, err := newRunConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:        cfg,
+		runs:       runs,
+		mux:        http.NewServeMux(),
+		bundles:    map[string]*Bundle{},
+		running:    map[string]bool{},
+		newManager: newManager,
+	}
+	if err := s.loadBundles(); err != nil {
+		return nil, err
+	}
+
+	s.mux.HandleFunc("GET /bundles", s.listBundles)
+	s.mux.HandleFunc("PUT /bundles/{service}", s.putBundle)
+	s.mux.HandleFunc("GET /bundles/{service}", s.getBundle)
+	s.mux.HandleFunc("GET /bundles/{service}/status", s.status)
+	s.mux.HandleFunc("POST /bundles/{service}/run", s.run)
+	return s, nil
+}
+
+// Reload replaces the clusters, profiles and notification targets with those
+// of cfg. Runs in progress finish with the configuration they started with;
+// the token, bundle directory and run timeout are kept. An invalid cfg is
+// rejected and the current configuration stays in place.
+func (s *Server) Reload(cfg Config) error {
+	runs, err := newRunConfig(cfg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.runs = runs
+	s.mu.Unlock()
+	return nil
+}
+
+// newRunConfig validates the clusters, profiles and notification targets of cfg
+func newRunConfig(cfg Config) (runConfig, error) {
+	runs := runConfig{
+		clusters: map[string]ClusterConfig{},
+		profiles: map[string][]migration.Option{},
+	}
+	for _, cluster := range cfg.Clusters {
+		if cluster.Name == "" || len(cluster.Addresses) == 0 {
+			return runs, fmt.Errorf("every cluster requires a name and addresses")
+		}
+		if _, ok := runs.clusters[cluster.Name]; ok {
+			return runs, fmt.Errorf("cluster %s is configured twice", cluster.Name)
+		}
+		runs.clusters[cluster.Name] = cluster
+	}
+	for _, profile := range cfg.Profiles {
+		if profile.Name == "" {
+			return runs, fmt.Errorf("every profile requires a name")
+		}
+		if _, ok := runs.profiles[profile.Name]; ok {
+			return runs, fmt.Errorf("profile %s is configured twice", profile.Name)
+		}
+		opts, err := profile.options()
+		if err != nil {
+			return runs, fmt.Errorf("profile %s: %w", profile.Name, err)
+		}
+		runs.profiles[profile.Name] = opts
+	}
+	for _, target := range cfg.Notifications {
+		switch {
+		case target.Webhook != "":
+			runs.notifications = append(runs.notifications, migration.WithNotifier(&migration.WebhookNotifier{URL: target.Webhook, Headers: target.Headers}))
+		case target.Slack != "":
+			runs.notifications = append(runs.notifications, migration.WithNotifier(&migration.SlackNotifier{WebhookURL: target.Slack}))
+		default:
+			return runs, fmt.Errorf("every notification requires a webhook or slack URL")
+		}
+	}
+	return runs, nil
+}
+
+// options returns the manager options of the profile
+func (p ProfileConfig) options() ([]migration.Option, error) {
+	var opts []migration.Option
+	if p.RequestsPerSecond != 0 || p.Slices != "" {
+		opts = append(opts, migration.WithThrottle(migration.Throttle{RequestsPerSecond: p.RequestsPerSecond, Slices: p.Slices}))
+	}
+	if p.Window != "" {
+		window, err := migration.ParseWindow(p.Window, p.WindowTimeZone)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, migration.WithWindow(window))
+	}
+	return opts, nil
+}
+
+// LoadConfig reads a JSON server configuration listing the clusters
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading server config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("error parsing server config: %w", err)
+	}
+	return cfg, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// parseBundle reads the migrations of a zip bundle
+func parseBundle(service string, data []byte) (*Bundle, []migration.Migration, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading bundle: %w", err)
+	}
+	migrations, err := migration.LoadFS(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, nil, fmt.Errorf("bundle contains no migrations")
+	}
+
+	bundle := &Bundle{Service: service, UploadedAt: time.Now().UTC(), data: data}
+	for _, m := range migrations {
+		if err := m.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid migration %s: %w", m.Version(), err)
+		}
+		bundle.Migrations = append(bundle.Migrations, migration.ManifestEntry{Version: m.Version(), Description: m.Description})
+	}
+	sort.Slice(bundle.Migrations, func(i, j int) bool { return bundle.Migrations[i].Version < bundle.Migrations[j].Version })
+	return bundle, migrations, nil
+}
+
+func (s *Server) loadBundles() error {
+	if s.cfg.BundleDir == "" {
+		return nil
+	}
+	paths, err := filepath.Glob(filepath.Join(s.cfg.BundleDir, "*.zip"))
+	if err != nil {
+		return fmt.Errorf("error listing bundles: %w", err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading bundle %s: %w", path, err)
+		}
+		service := strings.TrimSuffix(filepath.Base(path), ".zip")
+		bundle, _, err := parseBundle(service, data)
+		if err != nil {
+			return fmt.Errorf("error loading bundle %s: %w", path, err)
+		}
+		if info, err := os.Stat(path); err == nil {
+			bundle.UploadedAt = info.ModTime().UTC()
+		}
+		s.bundles[service] = bundle
+	}
+	return nil
+}
+
+func (s *Server) listBundles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	bundles := make([]*Bundle, 0, len(s.bundles))
+	for _, bundle := range s.bundles {
+		bundles = append(bundles, bundle)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Service < bundles[j].Service })
+	writeJSON(w, http.StatusOK, bundles)
+}
+
+func (s *Server) putBundle(w http.ResponseWriter, r *http.Request) {
+	service := r.PathValue("service")
+	if !serviceName.MatchString(service) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid service name %q", service))
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBundleSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("error reading bundle: %w", err))
+		return
+	}
+	bundle, _, err := parseBundle(service, data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if s.cfg.BundleDir != "" {
+		if err := os.MkdirAll(s.cfg.BundleDir, 0755); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("error creating bundle directory: %w", err))
+			return
+		}
+		if err := os.WriteFile(filepath.Join(s.cfg.BundleDir, service+".zip"), data, 0644); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("error writing bundle: %w", err))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.bundles[service] = bundle
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, bundle)
+}
+
+func (s *Server) getBundle(w http.ResponseWriter, r *http.Request) {
+	bundle, ok := s.bundle(r.PathValue("service"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no bundle for service %s", r.PathValue("service")))
+		return
+	}
+	writeJSON(w, http.StatusOK, bundle)
+}
+
+func (s *Server) bundle(service string) (*Bundle, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bundle, ok := s.bundles[service]
+	return bundle, ok
+}
+
+// manager returns a manager for the service's bundle on the cluster named in
+// the request, configured with the profile it names and the notification
+// targets, writing an error response when there is none
+func (s *Server) manager(w http.ResponseWriter, r *http.Request) (*migration.MigrationManager, string, bool) {
+	service := r.PathValue("service")
+	bundle, ok := s.bundle(service)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no bundle for service %s", service))
+		return nil, "", false
+	}
+
+	s.mu.Lock()
+	runs := s.runs
+	s.mu.Unlock()
+
+	cluster, ok := runs.clusters[r.URL.Query().Get("cluster")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown cluster %q", r.URL.Query().Get("cluster")))
+		return nil, "", false
+	}
+	opts := runs.notifications
+	if name := r.URL.Query().Get("profile"); name != "" {
+		profile, ok := runs.profiles[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown profile %q", name))
+			return nil, "", false
+		}
+		opts = append(append([]migration.Option{}, opts...), profile...)
+	}
+
+	mm, err := s.newManager(cluster, service, opts...)
+	if err == nil {
+		_, migrations, parseErr := parseBundle(service, bundle.data)
+		err = parseErr
+		for _, m := range migrations {
+			mm.Register(m)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, "", false
+	}
+	return mm, service + "@" + cluster.Name, true
+}
+
+// Status is the response of the status endpoint
+type Status struct {
+	Cluster   string                     `json:"cluster"`
+	Namespace string                     `json:"namespace"`
+	Index     string                     `json:"index"`
+	Applied   int                        `json:"applied"`
+	Current   *migration.MigrationRecord `json:"current,omitempty"`
+	Pending   []migration.ManifestEntry  `json:"pending"`
+	// Unknown are applied versions the bundle no longer contains
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	mm, _, ok := s.manager(w, r)
+	if !ok {
+		return
+	}
+	status, err := mm.Status()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	response := Status{
+		Cluster:   r.URL.Query().Get("cluster"),
+		Namespace: status.Namespace,
+		Index:     status.Index,
+		Applied:   status.Applied,
+		Current:   status.Current,
+		Pending:   status.Pending,
+		Unknown:   status.Unknown,
+	}
+	if response.Pending == nil {
+		response.Pending = []migration.ManifestEntry{}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) run(w http.ResponseWriter, r *http.Request) {
+	mm, key, ok := s.manager(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if s.running[key] {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("a run of %s is already in progress", key))
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, key)
+		s.mu.Unlock()
+	}()
+
+	// the run is not cancelled when the caller disconnects
+	ctx := context.WithoutCancel(r.Context())
+	if s.cfg.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.RunTimeout)
+		defer cancel()
+	}
+
+	summary, err := mm.RunMigrationsSummary(ctx)
+	switch {
+	case errors.Is(err, migration.ErrLocked):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, summary)
+	default:
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// newManager connects to cluster and tracks the service in its own namespace
+func newManager(cluster ClusterConfig, service string, opts ...migration.Option) (*migration.MigrationManager, error) {
+	mm, err := migration.NewMigrationManagerFromConfig(migration.ClientConfig{
+		Addresses:   cluster.Addresses,
+		CloudID:     cluster.CloudID,
+		Username:    cluster.Username,
+		Password:    cluster.Password,
+		APIKey:      cluster.APIKey,
+		BearerToken: cluster.BearerToken,
+		CACertFile:  cluster.CACertFile,
+		OpenSearch:  cluster.OpenSearch,
+	}, append([]migration.Option{migration.WithNamespace(service), migration.WithManifestExport()}, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to cluster %s: %w", cluster.Name, err)
+	}
+	return mm, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
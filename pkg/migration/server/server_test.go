@@ -0,0 +1,268 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// okTransport answers every request with 200 {}
+type okTransport struct{}
+
+func (okTransport) Perform(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}, "X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader("{}")),
+	}, nil
+}
+
+func bundleZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create bundle file: %v", err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+	return buf.Bytes()
+}
+
+const articlesMapping = `description: Add articles mapping
+action: put_mapping
+index: articles
+body:
+  properties:
+    title:
+      type: text
+`
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Token:     "secret",
+		Clusters:  []ClusterConfig{{Name: "eu", Addresses: []string{"http://localhost:9200"}}},
+		BundleDir: dir,
+	}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	// every service and cluster pair keeps its own state
+	stores := map[string]*migration.MemoryStore{}
+	srv.newManager = func(cluster ClusterConfig, service string, opts ...migration.Option) (*migration.MigrationManager, error) {
+		key := service + "@" + cluster.Name
+		if stores[key] == nil {
+			stores[key] = migration.NewMemoryStore()
+		}
+		client := migration.ClientFromTransport(okTransport{})
+		return migration.NewMigrationManager(client, migration.WithNamespace(service), migration.WithStore(stores[key])), nil
+	}
+
+	request := func(method, path, token string, body []byte, response interface{}) int {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if response != nil {
+			json.NewDecoder(rec.Body).Decode(response)
+		}
+		return rec.Code
+	}
+
+	t.Run("Test Token Is Required", func(t *testing.T) {
+		if code := request("GET", "/bundles", "", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 without a token, got %d", code)
+		}
+	})
+
+	t.Run("Test Invalid Bundles Are Rejected", func(t *testing.T) {
+		if code := request("PUT", "/bundles/orders", "secret", []byte("not a zip"), nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a bundle that is not a zip, got %d", code)
+		}
+		bundle := bundleZip(t, map[string]string{"0001.yaml": "action: explode\n"})
+		if code := request("PUT", "/bundles/orders", "secret", bundle, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid declaration, got %d", code)
+		}
+		if code := request("PUT", "/bundles/Bad..Name", "secret", bundleZip(t, map[string]string{"0001.yaml": articlesMapping}), nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid service name, got %d", code)
+		}
+	})
+
+	t.Run("Test Upload And Run", func(t *testing.T) {
+		var bundle Bundle
+		code := request("PUT", "/bundles/articles", "secret", bundleZip(t, map[string]string{"0001_mapping.yaml": articlesMapping}), &bundle)
+		if code != http.StatusOK || len(bundle.Migrations) != 1 {
+			t.Fatalf("Expected the bundle to be stored with 1 migration, got %d %+v", code, bundle)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "articles.zip")); err != nil {
+			t.Errorf("Expected the bundle to be persisted: %v", err)
+		}
+
+		var bundles []Bundle
+		if request("GET", "/bundles", "secret", nil, &bundles); len(bundles) != 1 || bundles[0].Service != "articles" {
+			t.Errorf("Expected the articles bundle to be listed, got %+v", bundles)
+		}
+
+		if code := request("POST", "/bundles/articles/run?cluster=us", "secret", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown cluster, got %d", code)
+		}
+		if code := request("POST", "/bundles/missing/run?cluster=eu", "secret", nil, nil); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown bundle, got %d", code)
+		}
+
+		var before Status
+		if code := request("GET", "/bundles/articles/status?cluster=eu", "secret", nil, &before); code != http.StatusOK || len(before.Pending) != 1 {
+			t.Fatalf("Expected 1 pending migration, got %d %+v", code, before)
+		}
+
+		var summary migration.RunSummary
+		if code := request("POST", "/bundles/articles/run?cluster=eu", "secret", nil, &summary); code != http.StatusOK || len(summary.Applied) != 1 {
+			t.Fatalf("Expected the run to apply 1 migration, got %d %+v", code, summary)
+		}
+		if summary.Namespace != "articles" {
+			t.Errorf("Expected the run to use the service namespace, got %q", summary.Namespace)
+		}
+
+		var after Status
+		request("GET", "/bundles/articles/status?cluster=eu", "secret", nil, &after)
+		if after.Applied != 1 || len(after.Pending) != 0 {
+			t.Errorf("Expected the migration to be applied, got %+v", after)
+		}
+	})
+
+	t.Run("Test Bundles Are Reloaded", func(t *testing.T) {
+		reloaded, err := New(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create server: %v", err)
+		}
+		if _, ok := reloaded.bundle("articles"); !ok {
+			t.Errorf("Expected the persisted bundle to be loaded")
+		}
+	})
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Errorf("Expected a token to be required")
+	}
+	cluster := ClusterConfig{Name: "eu", Addresses: []string{"http://localhost:9200"}}
+	if _, err := New(Config{Token: "secret", Clusters: []ClusterConfig{cluster, cluster}}); err == nil {
+		t.Errorf("Expected duplicate clusters to be rejected")
+	}
+}
+
+// blockingTransport holds the first request until release is closed
+type blockingTransport struct {
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingTransport) Perform(req *http.Request) (*http.Response, error) {
+	b.once.Do(func() {
+		close(b.entered)
+		<-b.release
+	})
+	return okTransport{}.Perform(req)
+}
+
+func TestServerReload(t *testing.T) {
+	eu := ClusterConfig{Name: "eu", Addresses: []string{"http://localhost:9200"}}
+	us := ClusterConfig{Name: "us", Addresses: []string{"http://localhost:9201"}}
+	srv, err := New(Config{Token: "secret", Clusters: []ClusterConfig{eu}, BundleDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	blocking := &blockingTransport{entered: make(chan struct{}), release: make(chan struct{})}
+	var mu sync.Mutex
+	options := map[string]int{}
+	srv.newManager = func(cluster ClusterConfig, service string, opts ...migration.Option) (*migration.MigrationManager, error) {
+		mu.Lock()
+		options[cluster.Name] = len(opts)
+		mu.Unlock()
+		client := migration.ClientFromTransport(blocking)
+		return migration.NewMigrationManager(client, migration.WithNamespace(service), migration.WithStore(migration.NewMemoryStore())), nil
+	}
+
+	request := func(method, path string, body []byte) int {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := request("PUT", "/bundles/articles", bundleZip(t, map[string]string{"0001_mapping.yaml": articlesMapping})); code != http.StatusOK {
+		t.Fatalf("Expected the bundle to be stored, got %d", code)
+	}
+
+	// a run on eu is in progress while the configuration is replaced
+	done := make(chan int)
+	go func() { done <- request("POST", "/bundles/articles/run?cluster=eu", nil) }()
+	<-blocking.entered
+
+	err = srv.Reload(Config{
+		Clusters:      []ClusterConfig{us},
+		Profiles:      []ProfileConfig{{Name: "nightly", RequestsPerSecond: 500, Window: "22:00-06:00", WindowTimeZone: "Europe/Berlin"}},
+		Notifications: []NotificationConfig{{Webhook: "http://localhost:9/hook"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	close(blocking.release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("Expected the run in progress to finish, got %d", code)
+	}
+
+	t.Run("Test New Runs Use The Reloaded Config", func(t *testing.T) {
+		if code := request("POST", "/bundles/articles/run?cluster=eu", nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a removed cluster, got %d", code)
+		}
+		if code := request("POST", "/bundles/articles/run?cluster=us&profile=daily", nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown profile, got %d", code)
+		}
+		if code := request("POST", "/bundles/articles/run?cluster=us&profile=nightly", nil); code != http.StatusOK {
+			t.Errorf("Expected the run on the added cluster to succeed, got %d", code)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if options["eu"] != 0 || options["us"] != 3 {
+			t.Errorf("Expected the notifier, throttle and window on the new run only, got %v", options)
+		}
+	})
+
+	t.Run("Test Invalid Config Keeps The Current One", func(t *testing.T) {
+		invalid := []Config{
+			{Clusters: []ClusterConfig{eu, eu}},
+			{Profiles: []ProfileConfig{{Name: "nightly", Window: "22:00"}}},
+			{Notifications: []NotificationConfig{{}}},
+		}
+		for _, cfg := range invalid {
+			if err := srv.Reload(cfg); err == nil {
+				t.Errorf("Expected %+v to be rejected", cfg)
+			}
+		}
+		if code := request("GET", "/bundles/articles/status?cluster=us", nil); code != http.StatusOK {
+			t.Errorf("Expected the us cluster to remain, got %d", code)
+		}
+	})
+}
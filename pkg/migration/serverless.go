@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// serverlessSettings are index settings Elastic Cloud Serverless manages
+// itself and rejects, as flat keys; keys ending in a dot are prefixes
+var serverlessSettings = []string{
+	"index.number_of_shards",
+	"index.number_of_replicas",
+	"index.number_of_routing_shards",
+	"index.auto_expand_replicas",
+	"index.routing_partition_size",
+	"index.shard.check_on_startup",
+	"index.unassigned.node_left.delayed_timeout",
+	"index.routing.allocation.",
+	"index.store.",
+	"index.translog.",
+	"index.merge.",
+}
+
+// IsServerless reports whether client is connected to an Elastic Cloud
+// Serverless project
+func IsServerless(client *elasticsearch.Client) (bool, error) {
+	res, err := client.Info()
+	if err != nil {
+		return false, fmt.Errorf("error reading cluster info: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return false, fmt.Errorf("error reading cluster info: %s", res.String())
+	}
+
+	var info struct {
+		Version struct {
+			BuildFlavor string `json:"build_flavor"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return false, fmt.Errorf("error parsing cluster info: %w", err)
+	}
+	return info.Version.BuildFlavor == "serverless", nil
+}
+
+// WithServerlessCompatibility detects Elastic Cloud Serverless projects and
+// adapts to them: declarative migrations registered with RegisterFS drop the
+// settings Serverless manages itself, see Declaration.ForServerless, and the
+// tracking index is named without the leading dot Serverless reserves for
+// system indices, e.g. elasticmate_migrations. An explicit WithTrackingIndex
+// name is kept.
+func WithServerlessCompatibility() Option {
+	return func(mm *MigrationManager) {
+		mm.serverlessCompat = true
+	}
+}
+
+// serverless reports whether the manager adapts to a Serverless project,
+// detecting it once. A failed detection is tried again on the next call.
+func (mm *MigrationManager) serverless() (bool, error) {
+	if !mm.serverlessCompat || mm.Client == nil {
+		return false, nil
+	}
+	mm.serverlessMu.Lock()
+	defer mm.serverlessMu.Unlock()
+	if mm.serverlessDetected == nil {
+		detected, err := IsServerless(mm.client())
+		if err != nil {
+			return false, err
+		}
+		mm.serverlessDetected = &detected
+		if detected {
+			mm.logf("Connected to a Serverless project; unsupported settings are dropped from declarative migrations")
+		}
+	}
+	return *mm.serverlessDetected, nil
+}
+
+// ForServerless returns the declaration without the index settings Elastic
+// Cloud Serverless rejects, such as number_of_shards and number_of_replicas,
+// along with the flat keys removed, sorted. A put_settings declaration left
+// without settings does nothing when applied.
+func (d Declaration) ForServerless() (Declaration, []string) {
+	var removed []string
+	switch d.Action {
+	case "create_index":
+		settings, ok := d.Body["settings"].(map[string]interface{})
+		if !ok {
+			return d, nil
+		}
+		body := make(map[string]interface{}, len(d.Body))
+		for key, value := range d.Body {
+			body[key] = value
+		}
+		kept := withoutServerlessSettings("", settings, &removed)
+		if len(kept) == 0 {
+			delete(body, "settings")
+		} else {
+			body["settings"] = kept
+		}
+		d.Body = body
+	case "put_settings":
+		d.Body = withoutServerlessSettings("", d.Body, &removed)
+		d.serverlessNoop = len(d.Body) == 0 && len(removed) > 0
+	default:
+		return d, nil
+	}
+	sort.Strings(removed)
+	return d, removed
+}
+
+// withoutServerlessSettings copies settings, nested under prefix, leaving out
+// unsupported keys and the objects left empty
+func withoutServerlessSettings(prefix string, settings map[string]interface{}, removed *[]string) map[string]interface{} {
+	kept := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		flat := prefix + key
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			if sub := withoutServerlessSettings(flat+".", nested, removed); len(sub) > 0 {
+				kept[key] = sub
+			}
+			continue
+		}
+		if serverlessUnsupported(flat) {
+			*removed = append(*removed, normalizeSettingKey(flat))
+			continue
+		}
+		kept[key] = value
+	}
+	return kept
+}
+
+func serverlessUnsupported(key string) bool {
+	key = normalizeSettingKey(key)
+	for _, setting := range serverlessSettings {
+		if key == setting || (strings.HasSuffix(setting, ".") && strings.HasPrefix(key, setting)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package migration
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// serverlessTransport stands in for a cluster of the given build flavor,
+// recording the body of every request. Cluster info fails with infoStatus
+// when set.
+type serverlessTransport struct {
+	flavor     string
+	infoStatus int
+
+	mu     sync.Mutex
+	bodies map[string]string
+}
+
+func (s *serverlessTransport) Perform(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := req.Method + " " + req.URL.Path
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		s.bodies[key] = string(data)
+	} else {
+		s.bodies[key] = ""
+	}
+
+	status, body := 200, `{}`
+	switch {
+	case key == "GET /" && s.infoStatus != 0:
+		status, body = s.infoStatus, `{"error": {"type": "security_exception"}}`
+	case key == "GET /":
+		body = `{"version": {"number": "8.11.0", "build_flavor": "` + s.flavor + `"}}`
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		status, body = 404, `{"error": {"type": "index_not_found_exception"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestServerless(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_create_articles.yaml": {Data: []byte(`description: Create articles index
+action: create_index
+index: articles
+body:
+  settings:
+    number_of_shards: 3
+    index:
+      number_of_replicas: 1
+      refresh_interval: 30s
+  mappings:
+    properties:
+      title:
+        type: text
+`)},
+		"002_replicas.yaml": {Data: []byte(`description: Add a replica to articles
+action: put_settings
+index: articles
+body:
+  index.number_of_replicas: 2
+`)},
+	}
+
+	t.Run("Test Unsupported Settings Are Dropped", func(t *testing.T) {
+		transport := &serverlessTransport{flavor: "serverless", bodies: map[string]string{}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithServerlessCompatibility())
+		if err := mm.RegisterFS(fsys); err != nil {
+			t.Fatalf("Failed to register migrations: %v", err)
+		}
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		created := transport.bodies["PUT /articles"]
+		if strings.Contains(created, "number_of") || !strings.Contains(created, "refresh_interval") {
+			t.Errorf("Expected only supported settings to be sent, got %s", created)
+		}
+		if _, ok := transport.bodies["PUT /articles/_settings"]; ok {
+			t.Errorf("Expected the replica update to send nothing, got %v", transport.bodies)
+		}
+		if applied, _ := mm.GetAppliedMigrations(); len(applied) != 2 {
+			t.Errorf("Expected both migrations to be recorded, got %v", applied)
+		}
+	})
+
+	t.Run("Test Tracking Index Drops The Dot", func(t *testing.T) {
+		transport := &serverlessTransport{flavor: "serverless", bodies: map[string]string{}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithNamespace("ordersvc"), WithServerlessCompatibility())
+		if index, err := mm.TrackingIndex(); err != nil || index != "elasticmate_migrations_ordersvc" {
+			t.Errorf("Expected elasticmate_migrations_ordersvc, got %s %v", index, err)
+		}
+	})
+
+	t.Run("Test Detection Is Safe For Concurrent Use", func(t *testing.T) {
+		transport := &serverlessTransport{flavor: "serverless", bodies: map[string]string{}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithServerlessCompatibility())
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if index, err := mm.TrackingIndex(); err != nil || index != "elasticmate_migrations" {
+					t.Errorf("Expected elasticmate_migrations, got %s %v", index, err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Test Failed Detection Is Returned", func(t *testing.T) {
+		transport := &serverlessTransport{infoStatus: http.StatusUnauthorized, bodies: map[string]string{}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithServerlessCompatibility())
+		if index, err := mm.TrackingIndex(); err == nil {
+			t.Errorf("Expected the detection error rather than %s", index)
+		}
+		if err := mm.RunMigrations(); err == nil || !strings.Contains(err.Error(), "Serverless") {
+			t.Errorf("Expected the run to fail on the detection error, got %v", err)
+		}
+		if _, ok := transport.bodies["PUT /.elasticmate_migrations-000001"]; ok {
+			t.Errorf("Expected no dotted tracking index to be created")
+		}
+
+		// detection is tried again once the cluster answers
+		transport.mu.Lock()
+		transport.infoStatus, transport.flavor = 0, "serverless"
+		transport.mu.Unlock()
+		if index, err := mm.TrackingIndex(); err != nil || index != "elasticmate_migrations" {
+			t.Errorf("Expected elasticmate_migrations after a retry, got %s %v", index, err)
+		}
+	})
+
+	t.Run("Test Other Clusters Are Left Alone", func(t *testing.T) {
+		transport := &serverlessTransport{flavor: "default", bodies: map[string]string{}}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithServerlessCompatibility())
+		if err := mm.RegisterFS(fsys); err != nil {
+			t.Fatalf("Failed to register migrations: %v", err)
+		}
+		if index, err := mm.TrackingIndex(); err != nil || index != migrationsIndex {
+			t.Errorf("Expected %s, got %s %v", migrationsIndex, index, err)
+		}
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !strings.Contains(transport.bodies["PUT /articles"], "number_of_shards") {
+			t.Errorf("Expected the declared settings to be sent, got %s", transport.bodies["PUT /articles"])
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotPrefix = "elasticmate-"
+)
+
+type snapshotInfo struct {
+	Snapshot  string   `json:"snapshot"`
+	Indices   []string `json:"indices"`
+	StartTime int64    `json:"start_time_in_millis"`
+	Metadata  struct {
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"metadata"`
+}
+
+// takeSnapshot snapshots all non-system indices into the configured repository
+// before a destructive migration is applied
+func (mm *MigrationManager) takeSnapshot(migration Migration) error {
+	name := fmt.Sprintf("%s%s-%s", snapshotPrefix, migration.Version(), time.Now().UTC().Format("20060102150405"))
+
+	body := map[string]interface{}{
+		"indices":              "*,-.*",
+		"include_global_state": false,
+		"metadata": map[string]string{
+			"version":     migration.Version(),
+			"description": migration.Description,
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshaling snapshot request: %w", err)
+	}
+
+	mm.logf("Creating snapshot %s in repository %s before migration %s", name, mm.SnapshotRepository, migration.Version())
+
+	client := mm.client()
+	res, err := client.Snapshot.Create(
+		mm.SnapshotRepository,
+		name,
+		client.Snapshot.Create.WithBody(strings.NewReader(string(data))),
+		client.Snapshot.Create.WithWaitForCompletion(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot %s: %w", name, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating snapshot %s: %s", name, res.String())
+	}
+
+	return nil
+}
+
+// lastSnapshot returns the most recent snapshot taken by elasticmate in the
+// configured repository
+func (mm *MigrationManager) lastSnapshot() (*snapshotInfo, error) {
+	client := mm.client()
+	res, err := client.Snapshot.Get(
+		mm.SnapshotRepository,
+		[]string{snapshotPrefix + "*"},
+		client.Snapshot.Get.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error listing snapshots: %s", res.String())
+	}
+
+	var result struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing snapshots: %w", err)
+	}
+
+	var last *snapshotInfo
+	for i := range result.Snapshots {
+		if last == nil || result.Snapshots[i].StartTime > last.StartTime {
+			last = &result.Snapshots[i]
+		}
+	}
+
+	if last == nil {
+		return nil, fmt.Errorf("no elasticmate snapshots found in repository %s", mm.SnapshotRepository)
+	}
+
+	return last, nil
+}
+
+// RestoreLastSnapshot restores the indices captured by the most recent
+// pre-migration snapshot and removes the record of the migration that followed it.
+// Existing indices with the same names are closed before the restore. It holds
+// the migration lock, so it does not race a run.
+func (mm *MigrationManager) RestoreLastSnapshot() error {
+	if mm.SnapshotRepository == "" {
+		return fmt.Errorf("snapshot repository not configured")
+	}
+
+	if !mm.disableLock {
+		unlock, err := mm.lock("")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	snapshot, err := mm.lastSnapshot()
+	if err != nil {
+		return err
+	}
+
+	mm.logf("Restoring snapshot %s from repository %s", snapshot.Snapshot, mm.SnapshotRepository)
+
+	client := mm.client()
+	if len(snapshot.Indices) > 0 {
+		res, err := client.Indices.Close(
+			snapshot.Indices,
+			client.Indices.Close.WithIgnoreUnavailable(true),
+			client.Indices.Close.WithAllowNoIndices(true),
+		)
+		if err != nil {
+			return fmt.Errorf("error closing indices before restore: %w", err)
+		}
+		defer res.Body.Close()
+
+		if res.IsError() {
+			return fmt.Errorf("error closing indices before restore: %s", res.String())
+		}
+	}
+
+	body := map[string]interface{}{
+		"indices":              strings.Join(snapshot.Indices, ","),
+		"include_global_state": false,
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshaling restore request: %w", err)
+	}
+
+	res, err := client.Snapshot.Restore(
+		mm.SnapshotRepository,
+		snapshot.Snapshot,
+		client.Snapshot.Restore.WithBody(strings.NewReader(string(data))),
+		client.Snapshot.Restore.WithWaitForCompletion(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error restoring snapshot %s: %w", snapshot.Snapshot, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error restoring snapshot %s: %s", snapshot.Snapshot, res.String())
+	}
+
+	if snapshot.Metadata.Version != "" {
+		if err := mm.RemoveMigrationRecord(snapshot.Metadata.Version); err != nil {
+			return err
+		}
+	}
+
+	mm.logf("Snapshot %s restored successfully", snapshot.Snapshot)
+	return nil
+}
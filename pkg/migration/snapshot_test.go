@@ -0,0 +1,194 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// snapshotTransport records requests with their bodies and lists snapshots
+type snapshotTransport struct {
+	snapshots string
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *snapshotTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.bodies = append(s.bodies, string(body))
+	s.mu.Unlock()
+
+	response := `{}`
+	if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/_snapshot/backups/") {
+		response = s.snapshots
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+	}, nil
+}
+
+// find returns the index, request and body of the first request matching
+func (s *snapshotTransport) find(match func(req *http.Request) bool) (int, *http.Request, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, req := range s.requests {
+		if match(req) {
+			return i, req, s.bodies[i]
+		}
+	}
+	return -1, nil, ""
+}
+
+func TestSnapshots(t *testing.T) {
+	dropIndex := func(index string) func(client *elasticsearch.Client) error {
+		return func(client *elasticsearch.Client) error {
+			res, err := client.Indices.Delete([]string{index})
+			if err != nil {
+				return err
+			}
+			return res.Body.Close()
+		}
+	}
+
+	t.Run("Test Mark Destructive", func(t *testing.T) {
+		m := NewMigration("Drop legacy index", dropIndex("legacy"))
+		destructive := m.MarkDestructive()
+		if m.IsDestructive() || !destructive.IsDestructive() {
+			t.Errorf("Expected only the copy to be destructive")
+		}
+		if destructive.Version() != m.Version() {
+			t.Errorf("Expected the version to be kept, got %s and %s", m.Version(), destructive.Version())
+		}
+	})
+
+	t.Run("Test Destructive Migration Is Snapshotted First", func(t *testing.T) {
+		transport := &snapshotTransport{}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithSnapshotRepository("backups"))
+		drop := NewMigration("Drop legacy index", dropIndex("legacy")).MarkDestructive()
+		mm.Register(drop)
+		mm.Register(NewMigration("Drop old index", dropIndex("old")))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		isSnapshot := func(req *http.Request) bool {
+			return req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/_snapshot/backups/"+snapshotPrefix)
+		}
+		position, snapshot, body := transport.find(isSnapshot)
+		if snapshot == nil {
+			t.Fatalf("Expected a snapshot, got %v", transport.requests)
+		}
+		if !strings.HasPrefix(snapshot.URL.Path, "/_snapshot/backups/"+snapshotPrefix+drop.Version()+"-") {
+			t.Errorf("Expected the snapshot to be named after the migration, got %s", snapshot.URL.Path)
+		}
+		if snapshot.URL.Query().Get("wait_for_completion") != "true" {
+			t.Errorf("Expected the snapshot to be awaited, got %s", snapshot.URL.RawQuery)
+		}
+		var request struct {
+			Indices  string            `json:"indices"`
+			Metadata map[string]string `json:"metadata"`
+		}
+		json.Unmarshal([]byte(body), &request)
+		if request.Indices != "*,-.*" || request.Metadata["version"] != drop.Version() {
+			t.Errorf("Expected every non-system index and the version in the snapshot, got %s", body)
+		}
+
+		deleted, _, _ := transport.find(func(req *http.Request) bool {
+			return req.Method == http.MethodDelete && req.URL.Path == "/legacy"
+		})
+		if deleted < position {
+			t.Errorf("Expected the snapshot before the destructive migration ran")
+		}
+		snapshots := 0
+		for _, req := range transport.requests {
+			if isSnapshot(req) {
+				snapshots++
+			}
+		}
+		if snapshots != 1 {
+			t.Errorf("Expected no snapshot before the other migration, got %d snapshots", snapshots)
+		}
+	})
+
+	t.Run("Test Restore Last Snapshot", func(t *testing.T) {
+		store := NewMemoryStore()
+		for _, version := range []string{"aaaa0001", "aaaa0002"} {
+			store.Record(MigrationRecord{Version: version, AppliedAt: time.Now()})
+		}
+		transport := &snapshotTransport{snapshots: `{"snapshots": [
+			{"snapshot": "elasticmate-aaaa0001-20240515120000", "indices": ["articles"], "start_time_in_millis": 1000, "metadata": {"version": "aaaa0001"}},
+			{"snapshot": "elasticmate-aaaa0002-20240516120000", "indices": ["articles", "users"], "start_time_in_millis": 2000, "metadata": {"version": "aaaa0002"}}
+		]}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store), WithSnapshotRepository("backups"))
+		if err := mm.RestoreLastSnapshot(); err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+
+		closed, closeReq, _ := transport.find(func(req *http.Request) bool {
+			return req.Method == http.MethodPost && req.URL.Path == "/articles,users/_close"
+		})
+		if closeReq == nil || closeReq.URL.Query().Get("ignore_unavailable") != "true" {
+			t.Fatalf("Expected the indices of the snapshot to be closed, got %v", transport.requests)
+		}
+		restored, _, body := transport.find(func(req *http.Request) bool {
+			return req.Method == http.MethodPost && req.URL.Path == "/_snapshot/backups/elasticmate-aaaa0002-20240516120000/_restore"
+		})
+		if restored < closed {
+			t.Fatalf("Expected the latest snapshot to be restored after closing, got %v", transport.requests)
+		}
+		if !strings.Contains(body, `"indices":"articles,users"`) {
+			t.Errorf("Expected the indices of the snapshot to be restored, got %s", body)
+		}
+
+		records, _ := store.GetApplied()
+		if len(records) != 1 || records[0].Version != "aaaa0001" {
+			t.Errorf("Expected only the record of the migration after the snapshot to be removed, got %+v", records)
+		}
+	})
+
+	t.Run("Test Restore Waits For The Lock", func(t *testing.T) {
+		store := NewMemoryStore()
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Failed to lock: %v", err)
+		}
+		defer unlock()
+		transport := &snapshotTransport{snapshots: `{"snapshots": [
+			{"snapshot": "elasticmate-aaaa0001-20240515120000", "indices": ["articles"], "start_time_in_millis": 1000, "metadata": {"version": "aaaa0001"}}
+		]}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store), WithSnapshotRepository("backups"))
+		if err := mm.RestoreLastSnapshot(); !errors.Is(err, ErrLocked) {
+			t.Fatalf("Expected the restore to need the lock, got %v", err)
+		}
+		if len(transport.requests) != 0 {
+			t.Errorf("Expected nothing restored while locked, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Restore Without Snapshots", func(t *testing.T) {
+		transport := &snapshotTransport{snapshots: `{"snapshots": []}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithSnapshotRepository("backups"))
+		if err := mm.RestoreLastSnapshot(); err == nil || !strings.Contains(err.Error(), "no elasticmate snapshots") {
+			t.Errorf("Expected no snapshots to fail, got %v", err)
+		}
+		if err := NewMigrationManager(ClientFromTransport(transport)).RestoreLastSnapshot(); err == nil {
+			t.Error("Expected a restore without a repository to fail")
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// SoftDeleteConfig configures a soft-delete migration
+type SoftDeleteConfig struct {
+	// Index holding the documents to delete
+	Index string
+	// Query selects the documents to delete, e.g. {"term": {"status": "spam"}}
+	Query string
+	// Alias is the filtered alias that hides deleted documents. Readers should
+	// query the alias instead of the index.
+	Alias string
+	// Field is the boolean flag marking deleted documents (default "deleted").
+	// A "<field>_at" date field records when documents were deleted.
+	Field string
+}
+
+func (c SoftDeleteConfig) field() string {
+	if c.Field == "" {
+		return "deleted"
+	}
+	return c.Field
+}
+
+// SoftDelete returns a migration that flags documents matching the query as
+// deleted instead of removing them, and (re)creates a filtered alias that hides
+// flagged documents. It is meant for teams whose audit requirements forbid
+// hard deletes.
+func SoftDelete(description string, cfg SoftDeleteConfig) Migration {
+	return NewMigration(description, func(client *elasticsearch.Client) error {
+		if cfg.Index == "" || cfg.Query == "" {
+			return fmt.Errorf("soft delete requires an index and a query")
+		}
+
+		if err := ensureSoftDeleteMapping(client, cfg.Index, cfg.field()); err != nil {
+			return err
+		}
+
+		if err := markDeleted(client, cfg); err != nil {
+			return err
+		}
+
+		if cfg.Alias != "" {
+			return EnsureSoftDeleteAlias(client, cfg.Index, cfg.Alias, cfg.field())
+		}
+		return nil
+	})
+}
+
+func ensureSoftDeleteMapping(client *elasticsearch.Client, index, field string) error {
+	mapping := fmt.Sprintf(`{
+		"properties": {
+			%q: { "type": "boolean" },
+			%q: { "type": "date" }
+		}
+	}`, field, field+"_at")
+
+	res, err := client.Indices.PutMapping([]string{index}, strings.NewReader(mapping))
+	if err != nil {
+		return fmt.Errorf("error adding soft delete fields to %s: %w", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error adding soft delete fields to %s: %s", index, res.String())
+	}
+
+	return nil
+}
+
+func markDeleted(client *elasticsearch.Client, cfg SoftDeleteConfig) error {
+	var query json.RawMessage
+	if err := json.Unmarshal([]byte(cfg.Query), &query); err != nil {
+		return fmt.Errorf("invalid soft delete query: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"query": query,
+		"script": map[string]interface{}{
+			"lang":   "painless",
+			"source": "ctx._source[params.field] = true; ctx._source[params.field + '_at'] = params.now",
+			"params": map[string]interface{}{
+				"field": cfg.field(),
+				"now":   time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling soft delete request: %w", err)
+	}
+
+	res, err := client.UpdateByQuery(
+		[]string{cfg.Index},
+		client.UpdateByQuery.WithBody(strings.NewReader(string(body))),
+		client.UpdateByQuery.WithConflicts("proceed"),
+		client.UpdateByQuery.WithRefresh(true),
+		client.UpdateByQuery.WithWaitForCompletion(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error soft deleting documents in %s: %w", cfg.Index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error soft deleting documents in %s: %s", cfg.Index, res.String())
+	}
+
+	var result struct {
+		Updated  int               `json:"updated"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error parsing soft delete response: %w", err)
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("soft delete in %s had %d failures: %s", cfg.Index, len(result.Failures), result.Failures[0])
+	}
+
+	clientLogf(client)("Soft deleted %d documents in %s", result.Updated, cfg.Index)
+	return nil
+}
+
+// EnsureSoftDeleteAlias points alias at index with a filter excluding
+// documents flagged as deleted
+func EnsureSoftDeleteAlias(client *elasticsearch.Client, index, alias, field string) error {
+	if field == "" {
+		field = "deleted"
+	}
+
+	body := fmt.Sprintf(`{
+		"actions": [
+			{
+				"add": {
+					"index": %q,
+					"alias": %q,
+					"filter": {"bool": {"must_not": {"term": {%q: true}}}}
+				}
+			}
+		]
+	}`, index, alias, field)
+
+	res, err := client.Indices.UpdateAliases(strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating soft delete alias %s: %w", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error creating soft delete alias %s: %s", alias, res.String())
+	}
+
+	return nil
+}
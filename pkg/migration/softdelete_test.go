@@ -0,0 +1,96 @@
+package migration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestSoftDelete(t *testing.T) {
+	t.Run("Test Documents Are Flagged And Hidden Behind A Filtered Alias", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("comments", `{}`)
+		es.AddDocument("comments", "1", map[string]interface{}{"status": "spam"})
+		es.AddDocument("comments", "2", map[string]interface{}{"status": "ok"})
+		var output bytes.Buffer
+		mm := es.Manager(migration.WithStore(migration.NewMemoryStore()), migration.WithLogger(log.New(&output, "", 0)))
+		mm.Register(migration.SoftDelete("Soft delete spam", migration.SoftDeleteConfig{
+			Index: "comments",
+			Query: `{"term": {"status": "spam"}}`,
+			Alias: "visible_comments",
+			Field: "hidden",
+		}))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		var update, aliases *migrationtest.Request
+		for _, request := range es.Requests() {
+			request := request
+			switch {
+			case request.Method == "POST" && request.Path == "/comments/_update_by_query":
+				update = &request
+			case request.Method == "POST" && request.Path == "/_aliases":
+				aliases = &request
+			}
+		}
+		if update == nil || aliases == nil {
+			t.Fatalf("Expected an update by query and an alias update, got %v", es.Requests())
+		}
+
+		var body struct {
+			Query  map[string]interface{} `json:"query"`
+			Script struct {
+				Source string                 `json:"source"`
+				Params map[string]interface{} `json:"params"`
+			} `json:"script"`
+		}
+		if err := json.Unmarshal(update.Body, &body); err != nil {
+			t.Fatalf("Failed to parse the update by query body: %v", err)
+		}
+		if term, _ := json.Marshal(body.Query); string(term) != `{"term":{"status":"spam"}}` {
+			t.Errorf("Expected the query of the config, got %s", term)
+		}
+		if body.Script.Params["field"] != "hidden" || body.Script.Params["now"] == "" || !strings.Contains(body.Script.Source, "params.field + '_at'") {
+			t.Errorf("Expected the script to flag and date documents, got %+v", body.Script)
+		}
+		if update.Query.Get("conflicts") != "proceed" {
+			t.Errorf("Expected version conflicts to be tolerated, got %v", update.Query)
+		}
+
+		var actions struct {
+			Actions []struct {
+				Add struct {
+					Index  string          `json:"index"`
+					Alias  string          `json:"alias"`
+					Filter json.RawMessage `json:"filter"`
+				} `json:"add"`
+			} `json:"actions"`
+		}
+		if err := json.Unmarshal(aliases.Body, &actions); err != nil {
+			t.Fatalf("Failed to parse the alias body: %v", err)
+		}
+		if len(actions.Actions) != 1 {
+			t.Fatalf("Expected one alias action, got %s", aliases.Body)
+		}
+		add := actions.Actions[0].Add
+		var filter bytes.Buffer
+		json.Compact(&filter, add.Filter)
+		if add.Index != "comments" || add.Alias != "visible_comments" || filter.String() != `{"bool":{"must_not":{"term":{"hidden":true}}}}` {
+			t.Errorf("Expected a filtered alias hiding flagged documents, got %s", aliases.Body)
+		}
+
+		mapping, _ := json.Marshal(es.Mapping("comments"))
+		if !strings.Contains(string(mapping), `"hidden":{"type":"boolean"}`) || !strings.Contains(string(mapping), `"hidden_at":{"type":"date"}`) {
+			t.Errorf("Expected the flag fields to be mapped, got %s", mapping)
+		}
+		if !strings.Contains(output.String(), "Soft deleted 1 documents in comments") {
+			t.Errorf("Expected the result in the manager log, got %q", output.String())
+		}
+	})
+}
@@ -0,0 +1,413 @@
+package migration
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// maxBundleSize bounds the bundles HTTP and OCI sources download
+const maxBundleSize = 64 << 20
+
+// MigrationSource provides declarative migration files, so a central runner
+// can pull the migrations of a team from where they are published
+type MigrationSource interface {
+	Open(ctx context.Context) (fs.FS, error)
+}
+
+// RegisterSource registers the declarative migrations of source, see
+// RegisterFS
+func (mm *MigrationManager) RegisterSource(ctx context.Context, source MigrationSource) error {
+	fsys, err := source.Open(ctx)
+	if err != nil {
+		return err
+	}
+	return mm.RegisterFS(fsys)
+}
+
+// ParseSource returns the source of spec:
+//
+//	migrations/                                  a local directory
+//	git+https://host/repo.git@REF#path           a git ref, optionally a subdirectory
+//	https://host/bundle.zip#sha256:HEX           a zip bundle, optionally pinned to a digest
+//	oci://registry/repository@sha256:HEX         an OCI artifact holding a zip bundle
+func ParseSource(spec string) (MigrationSource, error) {
+	switch {
+	case strings.HasPrefix(spec, "git+"):
+		repository, subdir, _ := strings.Cut(strings.TrimPrefix(spec, "git+"), "#")
+		// the ref follows the last @ after the host, leaving user@host alone
+		i := strings.LastIndex(repository, "@")
+		if i < 0 || strings.Contains(repository[i:], "/") {
+			return nil, fmt.Errorf("git source %q requires a ref, as repository@ref", spec)
+		}
+		return GitSource{Repository: repository[:i], Ref: repository[i+1:], Path: subdir}, nil
+	case strings.HasPrefix(spec, "https://"), strings.HasPrefix(spec, "http://"):
+		address, digest, _ := strings.Cut(spec, "#")
+		return HTTPSource{URL: address, Digest: digest}, nil
+	case strings.HasPrefix(spec, "oci://"):
+		return OCISource{Reference: strings.TrimPrefix(spec, "oci://")}, nil
+	case strings.Contains(spec, "://"):
+		return nil, fmt.Errorf("unsupported migration source %q", spec)
+	}
+	return DirSource(spec), nil
+}
+
+// DirSource reads migration files from a local directory
+type DirSource string
+
+func (d DirSource) Open(ctx context.Context) (fs.FS, error) {
+	info, err := os.Stat(string(d))
+	if err != nil {
+		return nil, fmt.Errorf("error opening migration directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", d)
+	}
+	return os.DirFS(string(d)), nil
+}
+
+// FSSource reads migration files from a file system, e.g. one embedded in
+// the binary with go:embed
+type FSSource struct {
+	FS fs.FS
+}
+
+func (s FSSource) Open(ctx context.Context) (fs.FS, error) {
+	return s.FS, nil
+}
+
+// GitSource reads migration files from a ref of a git repository, fetched
+// with the git command. A full commit hash pins the migrations; branches and
+// tags are resolved when Open is called.
+type GitSource struct {
+	// Repository is anything git fetch accepts, e.g. https://github.com/org/repo.git
+	Repository string
+	// Ref is a branch, tag or commit hash; HEAD when empty
+	Ref string
+	// Path is the directory of the migrations within the repository
+	Path string
+}
+
+func (g GitSource) Open(ctx context.Context) (fs.FS, error) {
+	if g.Path != "" && !filepath.IsLocal(filepath.FromSlash(g.Path)) {
+		return nil, fmt.Errorf("path %q leaves the repository", g.Path)
+	}
+	dir, err := os.MkdirTemp("", "elasticmate-git-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating checkout directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ref := g.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", g.Repository, ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("error fetching %s of %s: git %s: %w: %s", ref, g.Repository, args[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	// the checkout is removed on return, so its files are kept in memory
+	return archiveDir(filepath.Join(dir, filepath.FromSlash(g.Path)))
+}
+
+// archiveDir returns the files of dir as an in-memory zip archive
+func archiveDir(dir string) (fs.FS, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		w, err := archive.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+	return zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// HTTPSource downloads a zip bundle of migration files, the format
+// `elasticmate serve` accepts
+type HTTPSource struct {
+	URL string
+	// Digest, as sha256:HEX, pins the bundle; a download with another digest
+	// is refused
+	Digest string
+	// Headers are added to the request, e.g. for authentication
+	Headers map[string]string
+	// Client sends the request; http.DefaultClient is used when nil
+	Client *http.Client
+}
+
+func (s HTTPSource) Open(ctx context.Context) (fs.FS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building bundle request: %w", err)
+	}
+	for key, value := range s.Headers {
+		req.Header.Set(key, value)
+	}
+	data, err := download(s.Client, req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading bundle %s: %w", s.URL, err)
+	}
+	if s.Digest != "" {
+		if err := checkDigest(data, s.Digest); err != nil {
+			return nil, fmt.Errorf("bundle %s: %w", s.URL, err)
+		}
+	}
+	return openBundle(data)
+}
+
+// OCISource pulls a zip bundle of migration files stored as the single layer
+// of an OCI artifact, e.g. pushed with
+// oras push ghcr.io/org/migrations:v1 bundle.zip:application/zip
+type OCISource struct {
+	// Reference is registry/repository followed by :tag or @sha256:HEX;
+	// digests pin the artifact
+	Reference string
+	// Username and Password authenticate with the registry, anonymously when
+	// empty
+	Username string
+	Password string
+	// Client sends the requests; http.DefaultClient is used when nil
+	Client *http.Client
+	// PlainHTTP talks to the registry over HTTP, e.g. a local one
+	PlainHTTP bool
+}
+
+// ociManifestTypes are the manifest media types OCISource accepts
+const ociManifestTypes = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+
+func (s OCISource) Open(ctx context.Context) (fs.FS, error) {
+	registry, repository, ok := strings.Cut(s.Reference, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid OCI reference %q: expected registry/repository", s.Reference)
+	}
+	reference := "latest"
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, reference = repository[:i], repository[i+1:]
+	} else if i := strings.LastIndex(repository, ":"); i >= 0 {
+		repository, reference = repository[:i], repository[i+1:]
+	}
+	scheme := "https"
+	if s.PlainHTTP {
+		scheme = "http"
+	}
+	base := fmt.Sprintf("%s://%s/v2/%s", scheme, registry, repository)
+	client := &registryClient{client: s.Client, username: s.Username, password: s.Password, repository: repository}
+
+	data, err := client.get(ctx, base+"/manifests/"+reference, ociManifestTypes)
+	if err != nil {
+		return nil, fmt.Errorf("error pulling manifest of %s: %w", s.Reference, err)
+	}
+	if strings.HasPrefix(reference, "sha256:") {
+		if err := checkDigest(data, reference); err != nil {
+			return nil, fmt.Errorf("manifest of %s: %w", s.Reference, err)
+		}
+	}
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest of %s: %w", s.Reference, err)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("artifact %s has %d layers, expected a single zip bundle", s.Reference, len(manifest.Layers))
+	}
+
+	layer := manifest.Layers[0]
+	data, err = client.get(ctx, base+"/blobs/"+layer.Digest, "")
+	if err != nil {
+		return nil, fmt.Errorf("error pulling bundle of %s: %w", s.Reference, err)
+	}
+	if err := checkDigest(data, layer.Digest); err != nil {
+		return nil, fmt.Errorf("bundle of %s: %w", s.Reference, err)
+	}
+	return openBundle(data)
+}
+
+// registryClient sends requests to an OCI registry, fetching a bearer token
+// when the registry asks for one
+type registryClient struct {
+	client             *http.Client
+	username, password string
+	repository         string
+	token              string
+}
+
+func (r *registryClient) get(ctx context.Context, address, accept string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		switch {
+		case r.token != "":
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		case r.username != "":
+			req.SetBasicAuth(r.username, r.password)
+		}
+
+		client := r.client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		challenge := res.Header.Get("WWW-Authenticate")
+		if res.StatusCode != http.StatusUnauthorized || attempt > 0 || !strings.HasPrefix(challenge, "Bearer ") {
+			return readBundle(res)
+		}
+		res.Body.Close()
+		if r.token, err = r.fetchToken(ctx, challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchToken answers a Bearer challenge of the registry with a token for
+// pulling the repository
+func (r *registryClient) fetchToken(ctx context.Context, challenge string) (string, error) {
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		params[key] = strings.Trim(value, `"`)
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry challenge %q has no realm", challenge)
+	}
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + r.repository + ":pull"
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	data, err := download(r.client, req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching registry token: %w", err)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return "", fmt.Errorf("error parsing registry token: %w", err)
+	}
+	if token.Token == "" {
+		return token.AccessToken, nil
+	}
+	return token.Token, nil
+}
+
+// download sends req and returns the response body
+func download(client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readBundle(res)
+}
+
+// readBundle returns the body of a successful response, up to maxBundleSize
+func readBundle(res *http.Response) ([]byte, error) {
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxBundleSize)
+	}
+	return data, nil
+}
+
+// checkDigest verifies data against a sha256:HEX digest
+func checkDigest(data []byte, digest string) error {
+	algorithm, expected, _ := strings.Cut(digest, ":")
+	if algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest %q, expected sha256:HEX", digest)
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != strings.ToLower(expected) {
+		return fmt.Errorf("digest is sha256:%s, expected %s", actual, digest)
+	}
+	return nil
+}
+
+// openBundle returns the files of a zip bundle
+func openBundle(data []byte) (fs.FS, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle: %w", err)
+	}
+	return archive, nil
+}
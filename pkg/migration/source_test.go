@@ -0,0 +1,173 @@
+package migration
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sourceMigration = `{
+	"description": "Create articles index",
+	"action": "create_index",
+	"index": "articles_v1",
+	"body": {"mappings": {"properties": {"title": {"type": "text"}}}}
+}`
+
+func zipBundle(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Failed to close bundle: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func loadSource(t *testing.T, source MigrationSource) ([]Migration, error) {
+	t.Helper()
+	fsys, err := source.Open(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return LoadFS(fsys)
+}
+
+func TestSources(t *testing.T) {
+	bundle := zipBundle(t, map[string]string{"migrations/001_create_articles.json": sourceMigration})
+
+	t.Run("Test HTTP Bundle Pinned To A Digest", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(bundle)
+		}))
+		defer server.Close()
+
+		migrations, err := loadSource(t, HTTPSource{URL: server.URL + "/bundle.zip", Digest: sha256Digest(bundle)})
+		if err != nil {
+			t.Fatalf("Failed to load the bundle: %v", err)
+		}
+		if len(migrations) != 1 || migrations[0].Description != "Create articles index" {
+			t.Errorf("Expected the migration of the bundle, got %+v", migrations)
+		}
+
+		if _, err := loadSource(t, HTTPSource{URL: server.URL, Digest: sha256Digest([]byte("other"))}); err == nil || !strings.Contains(err.Error(), "digest") {
+			t.Errorf("Expected a digest mismatch, got %v", err)
+		}
+	})
+
+	t.Run("Test OCI Artifact With A Registry Token", func(t *testing.T) {
+		layer := sha256Digest(bundle)
+		manifest := []byte(fmt.Sprintf(`{"schemaVersion": 2, "layers": [{"mediaType": "application/zip", "digest": %q, "size": %d}]}`, layer, len(bundle)))
+		var registry *httptest.Server
+		registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/token":
+				if r.URL.Query().Get("scope") != "repository:team/migrations:pull" {
+					t.Errorf("Expected a pull scope, got %s", r.URL.RawQuery)
+				}
+				w.Write([]byte(`{"token": "pull-token"}`))
+			case r.Header.Get("Authorization") != "Bearer pull-token":
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, registry.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+			case r.URL.Path == "/v2/team/migrations/manifests/"+sha256Digest(manifest):
+				w.Write(manifest)
+			case r.URL.Path == "/v2/team/migrations/blobs/"+layer:
+				w.Write(bundle)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer registry.Close()
+
+		host := strings.TrimPrefix(registry.URL, "http://")
+		migrations, err := loadSource(t, OCISource{Reference: host + "/team/migrations@" + sha256Digest(manifest), PlainHTTP: true})
+		if err != nil {
+			t.Fatalf("Failed to pull the artifact: %v", err)
+		}
+		if len(migrations) != 1 {
+			t.Errorf("Expected the migration of the artifact, got %+v", migrations)
+		}
+
+		pinned := host + "/team/migrations@" + sha256Digest([]byte("other"))
+		if _, err := loadSource(t, OCISource{Reference: pinned, PlainHTTP: true}); err == nil {
+			t.Error("Expected an unknown digest to fail")
+		}
+	})
+
+	t.Run("Test Git Ref", func(t *testing.T) {
+		if _, err := exec.LookPath("git"); err != nil {
+			t.Skip("git is not installed")
+		}
+		repo := t.TempDir()
+		git := func(args ...string) string {
+			t.Helper()
+			cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+			cmd.Dir = repo
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("git %s failed: %v: %s", args[0], err, output)
+			}
+			return strings.TrimSpace(string(output))
+		}
+		git("init", "--quiet")
+		os.MkdirAll(filepath.Join(repo, "es"), 0755)
+		os.WriteFile(filepath.Join(repo, "es", "001_create_articles.json"), []byte(sourceMigration), 0644)
+		git("add", ".")
+		git("commit", "--quiet", "-m", "Add migrations")
+		commit := git("rev-parse", "HEAD")
+
+		source, err := ParseSource("git+file://" + repo + "@" + commit + "#es")
+		if err != nil {
+			t.Fatalf("Failed to parse the source: %v", err)
+		}
+		migrations, err := loadSource(t, source)
+		if err != nil {
+			t.Fatalf("Failed to fetch the ref: %v", err)
+		}
+		if len(migrations) != 1 {
+			t.Errorf("Expected the migration of the repository, got %+v", migrations)
+		}
+	})
+
+	t.Run("Test Parse Source", func(t *testing.T) {
+		for spec, expected := range map[string]MigrationSource{
+			"migrations": DirSource("migrations"),
+			"git+ssh://git@github.com/org/repo.git@v1": GitSource{Repository: "ssh://git@github.com/org/repo.git", Ref: "v1"},
+			"https://example.com/b.zip#sha256:ab":      HTTPSource{URL: "https://example.com/b.zip", Digest: "sha256:ab"},
+			"oci://ghcr.io/org/migrations:v1":          OCISource{Reference: "ghcr.io/org/migrations:v1"},
+		} {
+			source, err := ParseSource(spec)
+			if err != nil {
+				t.Errorf("Failed to parse %s: %v", spec, err)
+				continue
+			}
+			if fmt.Sprintf("%#v", source) != fmt.Sprintf("%#v", expected) {
+				t.Errorf("Expected %s to parse into %#v, got %#v", spec, expected, source)
+			}
+		}
+		if _, err := ParseSource("git+https://github.com/org/repo.git"); err == nil {
+			t.Error("Expected a git source without a ref to fail")
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package migration
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Squashes marks the migration as a baseline replacing the migrations of
+// versions. On a store where all of them are applied, the baseline is
+// recorded as applied without running and their records are removed. On a
+// store where none are, it runs like any other migration.
+func (m Migration) Squashes(versions ...string) Migration {
+	m.squashes = append(append([]string{}, m.squashes...), versions...)
+	return m
+}
+
+// Squashed returns the versions of the migrations a baseline replaces
+func (m Migration) Squashed() []string {
+	return m.squashes
+}
+
+// Squash collapses the registered migrations up to and including
+// upToVersion, in version order, into baseline. When all of them are applied
+// their records are replaced by a record of baseline, and they are replaced
+// by baseline in mm.Migrations. It returns baseline marked with Squashes,
+// which should be registered in their place so other stores are rewritten
+// on their next run.
+func (mm *MigrationManager) Squash(upToVersion string, baseline Migration) (Migration, error) {
+	if !mm.disableLock {
+		unlock, err := mm.lock("")
+		if err != nil {
+			return baseline, err
+		}
+		defer unlock()
+	}
+
+	mm.mu.Lock()
+	migrations := append([]Migration{}, mm.Migrations...)
+	mm.mu.Unlock()
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version() < migrations[j].Version()
+	})
+
+	end := -1
+	for i, migration := range migrations {
+		if migration.Version() == upToVersion {
+			end = i
+		}
+	}
+	if end < 0 {
+		return baseline, fmt.Errorf("migration %s is not registered", upToVersion)
+	}
+
+	squashed := make(map[string]bool, end+1)
+	var versions []string
+	for _, migration := range migrations[:end+1] {
+		if migration.tenant != nil {
+			return baseline, fmt.Errorf("migration %s is templated per tenant and cannot be squashed", migration.Version())
+		}
+		squashed[migration.Version()] = true
+		versions = append(versions, migration.Version())
+	}
+	for _, migration := range migrations[end+1:] {
+		if migration.Version() == baseline.Version() {
+			return baseline, fmt.Errorf("baseline %s is already registered", baseline.Version())
+		}
+	}
+	if squashed[baseline.Version()] {
+		return baseline, fmt.Errorf("baseline %s cannot squash itself", baseline.Version())
+	}
+	baseline = baseline.Squashes(versions...)
+
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return baseline, err
+	}
+	if _, err := mm.adoptSquashed(baseline, applied, newRunID()); err != nil {
+		return baseline, err
+	}
+
+	mm.mu.Lock()
+	registered := []Migration{}
+	for _, migration := range mm.Migrations {
+		if !squashed[migration.Version()] {
+			registered = append(registered, migration)
+		}
+	}
+	mm.Migrations = append(registered, baseline)
+	mm.mu.Unlock()
+
+	mm.logf("Squashed %d migrations into %s: %s", len(versions), baseline.Version(), baseline.Description)
+	return baseline, nil
+}
+
+// adoptSquashed records a pending baseline as applied, without running it,
+// when every migration it squashes is applied, and removes their records. It
+// returns the record written, nil when the baseline stays pending. Stores
+// with only some of them applied are refused, as the baseline would skip the
+// rest.
+func (mm *MigrationManager) adoptSquashed(baseline Migration, applied map[string]bool, runID string) (*MigrationRecord, error) {
+	var missing []string
+	for _, version := range baseline.squashes {
+		if !applied[version] {
+			missing = append(missing, version)
+		}
+	}
+	if len(missing) == len(baseline.squashes) {
+		return nil, nil
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("baseline %s squashes migrations %s that are not applied; apply them with the previous release first", baseline.Version(), strings.Join(missing, ", "))
+	}
+
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	squashed := make(map[string]bool, len(baseline.squashes))
+	for _, version := range baseline.squashes {
+		squashed[version] = true
+	}
+
+	// The baseline takes the place of the last squashed migration, so
+	// migrations applied after the chain stay after it
+	record := mm.newRecord(baseline)
+	record.AppliedAt = time.Time{}
+	record.RunID = runID
+	record.RunnerMetadata = mm.runnerMetadata()
+	indices := make(map[string]bool)
+	for _, squashedRecord := range records {
+		if !squashed[squashedRecord.Version] {
+			continue
+		}
+		if squashedRecord.AppliedAt.After(record.AppliedAt) {
+			record.AppliedAt = squashedRecord.AppliedAt
+		}
+		for _, index := range squashedRecord.Indices {
+			indices[index] = true
+		}
+	}
+	for index := range indices {
+		record.Indices = append(record.Indices, index)
+	}
+	sort.Strings(record.Indices)
+
+	if err := mm.store().Record(record); err != nil {
+		return nil, err
+	}
+	for _, version := range baseline.squashes {
+		if err := mm.store().Remove(version); err != nil {
+			return &record, fmt.Errorf("error removing record of squashed migration %s: %w", version, err)
+		}
+	}
+	mm.logf("Recorded baseline %s in place of %d squashed migrations", baseline.Version(), len(baseline.squashes))
+	return &record, nil
+}
+
+// checkSquashed refuses registering a baseline alongside a migration it
+// squashes, as both would run on a store where neither is applied
+func checkSquashed(migrations []Migration) error {
+	registered := make(map[string]bool, len(migrations))
+	for _, migration := range migrations {
+		registered[migration.Version()] = true
+	}
+	for _, migration := range migrations {
+		for _, version := range migration.squashes {
+			if registered[version] {
+				return fmt.Errorf("migration %s is squashed into %s; remove it", version, migration.Version())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package migration
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestSquash(t *testing.T) {
+	appliedAt := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+	noop := func(client *elasticsearch.Client) error { return nil }
+
+	chain := []Migration{
+		NewMigration("Create articles index", noop),
+		NewMigration("Add tags to articles", noop),
+		NewMigration("Add authors to articles", noop),
+	}
+	sorted := append([]Migration{}, chain...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version() < sorted[j].Version()
+	})
+	upTo := sorted[1].Version()
+
+	baselineRuns := 0
+	newBaseline := func() Migration {
+		return NewMigration("Articles baseline", func(client *elasticsearch.Client) error {
+			baselineRuns++
+			return nil
+		})
+	}
+
+	t.Run("Test Squash Rewrites Records", func(t *testing.T) {
+		store := NewMemoryStore()
+		for i, migration := range sorted {
+			store.Record(MigrationRecord{
+				Version:   migration.Version(),
+				AppliedAt: appliedAt.Add(time.Duration(i) * time.Hour),
+				Indices:   []string{"articles"},
+			})
+		}
+		mm := NewMigrationManager(nil, WithStore(store))
+		for _, migration := range chain {
+			mm.Register(migration)
+		}
+
+		baseline, err := mm.Squash(upTo, newBaseline())
+		if err != nil {
+			t.Fatalf("Failed to squash: %v", err)
+		}
+		if squashed := baseline.Squashed(); len(squashed) != 2 || squashed[0] != sorted[0].Version() || squashed[1] != upTo {
+			t.Errorf("Expected the first two migrations squashed, got %v", squashed)
+		}
+		if len(mm.Migrations) != 2 {
+			t.Errorf("Expected the baseline and the last migration registered, got %d", len(mm.Migrations))
+		}
+
+		records, _ := store.GetApplied()
+		if len(records) != 2 || records[0].Version != baseline.Version() || records[1].Version != sorted[2].Version() {
+			t.Fatalf("Expected the baseline record before the last migration, got %+v", records)
+		}
+		if !records[0].AppliedAt.Equal(appliedAt.Add(time.Hour)) || len(records[0].Indices) != 1 {
+			t.Errorf("Expected the baseline to take the place of the last squashed record, got %+v", records[0])
+		}
+
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if baselineRuns != 0 {
+			t.Errorf("Expected the baseline not to run, ran %d times", baselineRuns)
+		}
+	})
+
+	t.Run("Test Baseline Adopted On Other Stores", func(t *testing.T) {
+		store := NewMemoryStore()
+		for _, migration := range sorted[:2] {
+			store.Record(MigrationRecord{Version: migration.Version(), AppliedAt: appliedAt})
+		}
+		mm := NewMigrationManager(nil, WithStore(store))
+		mm.Register(newBaseline().Squashes(sorted[0].Version(), upTo))
+
+		summary, err := mm.RunMigrationsSummary(t.Context())
+		if err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if baselineRuns != 0 || len(summary.Applied) != 1 {
+			t.Errorf("Expected the baseline recorded without running, got %d runs and %+v", baselineRuns, summary)
+		}
+		if records, _ := store.GetApplied(); len(records) != 1 {
+			t.Errorf("Expected only the baseline record, got %+v", records)
+		}
+	})
+
+	t.Run("Test Baseline Runs On Fresh Stores", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		mm.Register(newBaseline().Squashes(sorted[0].Version(), upTo))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if baselineRuns != 1 {
+			t.Errorf("Expected the baseline to run once, ran %d times", baselineRuns)
+		}
+	})
+
+	t.Run("Test Partially Applied Chain", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Record(MigrationRecord{Version: sorted[0].Version(), AppliedAt: appliedAt})
+		mm := NewMigrationManager(nil, WithStore(store))
+		mm.Register(newBaseline().Squashes(sorted[0].Version(), upTo))
+		if err := mm.RunMigrations(); err == nil || !strings.Contains(err.Error(), upTo) {
+			t.Errorf("Expected an error naming the unapplied migration, got %v", err)
+		}
+	})
+
+	t.Run("Test Squashed Migration Still Registered", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		mm.Register(sorted[0])
+		mm.Register(newBaseline().Squashes(sorted[0].Version()))
+		if err := mm.RunMigrations(); err == nil {
+			t.Error("Expected an error registering a squashed migration with its baseline")
+		}
+	})
+}
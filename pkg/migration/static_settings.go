@@ -0,0 +1,278 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// creation-only settings, or prefixes ending in ".", that Elasticsearch
+// rejects on an existing index even when it is closed
+var creationOnlySettings = []string{
+	"index.sort.",
+	"index.number_of_shards",
+	"index.number_of_routing_shards",
+	"index.routing_partition_size",
+	"index.mode",
+	"index.soft_deletes.enabled",
+	"index.store.type",
+	"index.mapping.source.mode",
+	"index.time_series.",
+	"index.routing_path",
+}
+
+// IsCreationOnlySetting reports whether key can only be set when an index is
+// created. Keys may be given with or without the "index." prefix.
+func IsCreationOnlySetting(key string) bool {
+	key = normalizeSettingKey(key)
+	for _, setting := range creationOnlySettings {
+		if key == setting || (strings.HasSuffix(setting, ".") && strings.HasPrefix(key, setting)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreationOnlySettings returns the keys of settings that cannot be applied in
+// place, sorted
+func CreationOnlySettings(settings map[string]interface{}) []string {
+	var keys []string
+	for key := range settings {
+		if IsCreationOnlySetting(key) {
+			keys = append(keys, normalizeSettingKey(key))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// static settings, or prefixes ending in ".", that can only be updated while
+// an index is closed
+var staticSettings = []string{
+	"index.analysis.",
+	"index.similarity.",
+	"index.codec",
+	"index.shard.check_on_startup",
+	"index.load_fixed_bitset_filters_eagerly",
+	"index.store.preload",
+}
+
+// IsStaticSetting reports whether key can only be updated on a closed index.
+// Keys may be given with or without the "index." prefix.
+func IsStaticSetting(key string) bool {
+	key = normalizeSettingKey(key)
+	for _, setting := range staticSettings {
+		if key == setting || (strings.HasSuffix(setting, ".") && strings.HasPrefix(key, setting)) {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticSettings returns the keys of settings that require closing the index,
+// sorted. Nested settings are flattened first.
+func StaticSettings(settings map[string]interface{}) []string {
+	var keys []string
+	for key := range flattenSettings(settings) {
+		if IsStaticSetting(key) {
+			keys = append(keys, normalizeSettingKey(key))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flattenSettings turns nested settings such as {"analysis": {"analyzer": ...}}
+// into flat keys such as "analysis.analyzer..."
+func flattenSettings(settings map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		nested, ok := value.(map[string]interface{})
+		if !ok || len(nested) == 0 {
+			flat[prefix] = value
+			return
+		}
+		for key, child := range nested {
+			walk(prefix+"."+key, child)
+		}
+	}
+	for key, value := range settings {
+		walk(key, value)
+	}
+	return flat
+}
+
+func normalizeSettingKey(key string) string {
+	if !strings.HasPrefix(key, "index.") {
+		return "index." + key
+	}
+	return key
+}
+
+// SettingsChange configures a ChangeSettings migration
+type SettingsChange struct {
+	Index string
+	// Settings use flat keys such as "index.sort.field"
+	Settings map[string]interface{}
+
+	// Target is the index created with the new settings when any of them is
+	// creation-only. Alias is then moved from Index to Target.
+	Target       string
+	Alias        string
+	DeleteSource bool
+}
+
+// ChangeSettings returns a migration that applies settings to an index. Dynamic
+// settings are updated in place; if any setting can only be set at creation the
+// index is rebuilt into Target and Alias is swapped. A change that needs a
+// rebuild but has no Target fails validation before any migration runs.
+func ChangeSettings(description string, change SettingsChange) Migration {
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		if static := CreationOnlySettings(change.Settings); len(static) > 0 || change.Target != "" {
+			clientLogf(client)("Rebuilding %s into %s to apply %s", change.Index, change.Target, strings.Join(static, ", "))
+			return RebuildIndex(client, RebuildConfig{
+				Source:       change.Index,
+				Target:       change.Target,
+				Alias:        change.Alias,
+				Settings:     change.Settings,
+				DeleteSource: change.DeleteSource,
+			})
+		}
+		return putSettings(client, change.Index, change.Settings)
+	})
+	m.validate = func() error {
+		if change.Index == "" || len(change.Settings) == 0 {
+			return fmt.Errorf("settings change requires an index and settings")
+		}
+		if static := CreationOnlySettings(change.Settings); len(static) > 0 && change.Target == "" {
+			return fmt.Errorf("%s can only be set at index creation; set a target index to rebuild %s", strings.Join(static, ", "), change.Index)
+		}
+		return nil
+	}
+	return m
+}
+
+// PutSettings returns a migration that updates settings of an existing index.
+// Dynamic settings are applied to the open index. Static settings, such as
+// analysis, need the index closed while they are applied; this takes it
+// offline, so the migration fails validation unless it is flagged with
+// AllowDowntime. Creation-only settings are rejected; use ChangeSettings to
+// rebuild the index instead.
+func PutSettings(description, index string, settings map[string]interface{}) Migration {
+	static := StaticSettings(settings)
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		if len(static) == 0 {
+			return putSettings(client, index, settings)
+		}
+
+		clientLogf(client)("Closing %s to apply %s", index, strings.Join(static, ", "))
+		if err := closeIndex(client, index); err != nil {
+			return err
+		}
+		err := putSettings(client, index, settings)
+		// reopen even when the update failed so the index is not left offline
+		clientLogf(client)("Reopening %s", index)
+		if openErr := openIndex(client, index); openErr != nil {
+			if err != nil {
+				return fmt.Errorf("%w; %v", err, openErr)
+			}
+			return openErr
+		}
+		return err
+	})
+	m.downtimeSettings = static
+	m.validate = func() error {
+		if index == "" || len(settings) == 0 {
+			return fmt.Errorf("settings update requires an index and settings")
+		}
+		if creationOnly := CreationOnlySettings(flattenSettings(settings)); len(creationOnly) > 0 {
+			return fmt.Errorf("%s can only be set at index creation; use ChangeSettings to rebuild %s", strings.Join(creationOnly, ", "), index)
+		}
+		return nil
+	}
+	return m
+}
+
+// AllowDowntime permits the migration to close indices while it runs
+func (m Migration) AllowDowntime() Migration {
+	m.allowDowntime = true
+	return m
+}
+
+// IndexSort configures a SortIndex migration
+type IndexSort struct {
+	Index string
+	// Fields are sorted on in order. Order, Mode and Missing are optional and,
+	// when set, must have one entry per field.
+	Fields  []string
+	Order   []string
+	Mode    []string
+	Missing []string
+
+	Target       string
+	Alias        string
+	DeleteSource bool
+}
+
+// SortIndex returns a migration that rebuilds Index into Target with index
+// sorting on the given fields and swaps Alias to it
+func SortIndex(description string, cfg IndexSort) Migration {
+	settings := map[string]interface{}{
+		"index.sort.field": cfg.Fields,
+	}
+	for key, values := range map[string][]string{
+		"index.sort.order":   cfg.Order,
+		"index.sort.mode":    cfg.Mode,
+		"index.sort.missing": cfg.Missing,
+	} {
+		if len(values) > 0 {
+			settings[key] = values
+		}
+	}
+
+	m := ChangeSettings(description, SettingsChange{
+		Index:        cfg.Index,
+		Settings:     settings,
+		Target:       cfg.Target,
+		Alias:        cfg.Alias,
+		DeleteSource: cfg.DeleteSource,
+	})
+	validateSettings := m.validate
+	m.validate = func() error {
+		if len(cfg.Fields) == 0 {
+			return fmt.Errorf("index sort of %s requires at least one field", cfg.Index)
+		}
+		for name, values := range map[string][]string{"order": cfg.Order, "mode": cfg.Mode, "missing": cfg.Missing} {
+			if len(values) > 0 && len(values) != len(cfg.Fields) {
+				return fmt.Errorf("index sort of %s has %d fields but %d %s values", cfg.Index, len(cfg.Fields), len(values), name)
+			}
+		}
+		return validateSettings()
+	}
+	return m
+}
+
+func putSettings(client *elasticsearch.Client, index string, settings map[string]interface{}) error {
+	body, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("error marshaling settings of %s: %w", index, err)
+	}
+
+	res, err := client.Indices.PutSettings(
+		strings.NewReader(string(body)),
+		client.Indices.PutSettings.WithIndex(index),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating settings of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error updating settings of %s: %s", index, res.String())
+	}
+
+	return nil
+}
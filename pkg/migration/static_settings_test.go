@@ -0,0 +1,105 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestStaticSettings(t *testing.T) {
+	t.Run("Detect Creation Only Settings", func(t *testing.T) {
+		for key, expected := range map[string]bool{
+			"index.sort.field":         true,
+			"sort.order":               true,
+			"index.number_of_shards":   true,
+			"index.number_of_replicas": false,
+			"index.refresh_interval":   false,
+		} {
+			if got := IsCreationOnlySetting(key); got != expected {
+				t.Errorf("IsCreationOnlySetting(%q) = %v, expected %v", key, got, expected)
+			}
+		}
+	})
+
+	t.Run("Rebuild Without Target Fails Before Running", func(t *testing.T) {
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+
+		ran := false
+		mm.Register(NewMigration("Runs first", func(client *elasticsearch.Client) error {
+			ran = true
+			return nil
+		}))
+		mm.Register(SortIndex("Sort products by date", IndexSort{
+			Index:  "products",
+			Fields: []string{"created_at"},
+			Order:  []string{"desc"},
+		}))
+
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "index.sort.field") {
+			t.Fatalf("Expected validation error naming index.sort.field, got %v", err)
+		}
+		if ran {
+			t.Errorf("Expected no migration to run when validation fails")
+		}
+	})
+
+	t.Run("Mismatched Sort Order", func(t *testing.T) {
+		m := SortIndex("Sort products", IndexSort{
+			Index:  "products",
+			Fields: []string{"created_at", "id"},
+			Order:  []string{"desc"},
+			Target: "products_v2",
+		})
+		if err := m.Validate(); err == nil {
+			t.Errorf("Expected validation error for mismatched sort order")
+		}
+	})
+
+	t.Run("Put Static Settings Requires Downtime", func(t *testing.T) {
+		analysis := map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"analyzer": map[string]interface{}{"folding": map[string]interface{}{"tokenizer": "standard"}},
+			},
+		}
+		m := PutSettings("Add folding analyzer", "articles", analysis)
+		if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "AllowDowntime") {
+			t.Fatalf("Expected validation error asking for AllowDowntime, got %v", err)
+		}
+
+		transport := &routeTransport{}
+		m = m.AllowDowntime()
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Failed to validate migration: %v", err)
+		}
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		expected := []string{"POST /articles/_close", "PUT /articles/_settings", "POST /articles/_open"}
+		if strings.Join(transport.requests, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected requests %v, got %v", expected, transport.requests)
+		}
+	})
+
+	t.Run("Put Dynamic Settings Keeps Index Open", func(t *testing.T) {
+		transport := &routeTransport{}
+		m := PutSettings("Reduce refresh", "articles", map[string]interface{}{"index.refresh_interval": "30s"})
+		if err := m.Validate(); err != nil {
+			t.Fatalf("Failed to validate migration: %v", err)
+		}
+		if err := m.run(ClientFromTransport(transport), nil); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		if len(transport.requests) != 1 || transport.requests[0] != "PUT /articles/_settings" {
+			t.Errorf("Expected a single settings update, got %v", transport.requests)
+		}
+	})
+
+	t.Run("Put Creation Only Settings Fails Validation", func(t *testing.T) {
+		m := PutSettings("Reshard", "articles", map[string]interface{}{"index": map[string]interface{}{"number_of_shards": 3}})
+		if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "index.number_of_shards") {
+			t.Errorf("Expected validation error naming index.number_of_shards, got %v", err)
+		}
+	})
+}
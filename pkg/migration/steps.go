@@ -0,0 +1,129 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Step is a step of a migration made with Steps
+type Step struct {
+	Description string
+	Up          func(client *elasticsearch.Client) error
+
+	// validate checks the migration a step was made from
+	validate func() error
+}
+
+// MigrationStep makes a step of a migration, such as one returned by
+// ShrinkIndex or PutMapping, described and validated like the migration
+func MigrationStep(m Migration) Step {
+	return Step{
+		Description: m.Description,
+		Up: func(client *elasticsearch.Client) error {
+			return m.run(client, nil)
+		},
+		validate: m.Validate,
+	}
+}
+
+// Steps returns a migration running steps in order, each with its own
+// description. Stores implementing CheckpointStore, such as the default
+// tracking index, keep a checkpoint after every completed step, so a run
+// after a failure in step 3 of 5 resumes at step 3 instead of repeating
+// steps 1 and 2. The checkpoint is removed once every step is done. The
+// version hashes the description and those of the steps.
+func Steps(description string, steps ...Step) Migration {
+	steps = append([]Step{}, steps...)
+	m := NewMigration(description, func(client *elasticsearch.Client) error {
+		return runSteps(client, steps, 0, func(int) error { return nil })
+	})
+	m.steps = steps
+
+	hasher := sha256.New()
+	hasher.Write([]byte(description))
+	for _, step := range steps {
+		hasher.Write([]byte(step.Description))
+	}
+	m.version = hex.EncodeToString(hasher.Sum(nil))[:8]
+
+	m.validate = func() error {
+		if len(steps) == 0 {
+			return fmt.Errorf("migration %q has no steps", description)
+		}
+		for i, step := range steps {
+			if step.Description == "" || step.Up == nil {
+				return fmt.Errorf("step %d of %q requires a description and a function", i+1, description)
+			}
+			if step.validate != nil {
+				if err := step.validate(); err != nil {
+					return fmt.Errorf("step %d of %q: %w", i+1, description, err)
+				}
+			}
+		}
+		return nil
+	}
+	return m
+}
+
+// runSteps runs the steps from start on, calling completed with the number
+// of steps done after each of them
+func runSteps(client *elasticsearch.Client, steps []Step, start int, completed func(int) error) error {
+	for i := start; i < len(steps); i++ {
+		if err := steps[i].Up(client); err != nil {
+			return fmt.Errorf("step %d of %d (%s) failed: %w", i+1, len(steps), steps[i].Description, err)
+		}
+		if err := completed(i + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepsFunc returns the function of a Steps migration resuming at the step
+// after its checkpoint and checkpointing every completed step, wrapped in
+// the guards of the migration
+func (mm *MigrationManager) stepsFunc(migration Migration) func(client *elasticsearch.Client) error {
+	up := func(client *elasticsearch.Client) error {
+		checkpoints, ok := mm.store().(CheckpointStore)
+		if !ok {
+			return runSteps(client, migration.steps, 0, func(done int) error {
+				mm.logf("Completed step %d of %d of migration %s: %s", done, len(migration.steps), migration.Version(), migration.steps[done-1].Description)
+				return nil
+			})
+		}
+
+		start := 0
+		checkpoint, err := checkpoints.GetCheckpoint(migration.Version())
+		if err != nil {
+			return err
+		}
+		if checkpoint != nil && checkpoint.Completed < len(migration.steps) {
+			start = checkpoint.Completed
+			mm.logf("Resuming migration %s at step %d of %d: %s", migration.Version(), start+1, len(migration.steps), migration.steps[start].Description)
+		}
+		err = runSteps(client, migration.steps, start, func(done int) error {
+			mm.logf("Completed step %d of %d of migration %s: %s", done, len(migration.steps), migration.Version(), migration.steps[done-1].Description)
+			return checkpoints.RecordCheckpoint(MigrationCheckpoint{
+				Version:   migration.Version(),
+				Completed: done,
+				Step:      migration.steps[done-1].Description,
+				UpdatedAt: time.Now().UTC(),
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if err := checkpoints.RemoveCheckpoint(migration.Version()); err != nil {
+			mm.logf("Failed to remove checkpoint of migration %s: %v", migration.Version(), err)
+		}
+		return nil
+	}
+	for _, guard := range migration.stepGuards {
+		up = guard(up)
+	}
+	return up
+}
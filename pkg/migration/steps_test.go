@@ -0,0 +1,86 @@
+package migration
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestSteps(t *testing.T) {
+	t.Run("Test Failed Run Resumes At The Failed Step", func(t *testing.T) {
+		store := NewMemoryStore()
+		var ran []string
+		fail := true
+		step := func(name string) Step {
+			return Step{Description: name, Up: func(client *elasticsearch.Client) error {
+				if name == "third" && fail {
+					return errors.New("cluster busy")
+				}
+				ran = append(ran, name)
+				return nil
+			}}
+		}
+		m := Steps("Reshard orders", step("first"), step("second"), step("third"), step("fourth"))
+
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(store), WithMigrations(m))
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "step 3 of 4 (third) failed: cluster busy") {
+			t.Fatalf("Expected step 3 to fail, got %v", err)
+		}
+		checkpoint, _ := store.GetCheckpoint(m.Version())
+		if checkpoint == nil || checkpoint.Completed != 2 || checkpoint.Step != "second" {
+			t.Fatalf("Expected a checkpoint after step 2, got %+v", checkpoint)
+		}
+
+		fail = false
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to resume: %v", err)
+		}
+		if got := strings.Join(ran, " "); got != "first second third fourth" {
+			t.Errorf("Expected completed steps not repeated, ran %s", got)
+		}
+		if checkpoint, _ := store.GetCheckpoint(m.Version()); checkpoint != nil {
+			t.Errorf("Expected the checkpoint removed, got %+v", checkpoint)
+		}
+		if applied, _ := mm.GetAppliedMigrations(); !applied[m.Version()] {
+			t.Error("Expected the migration recorded")
+		}
+	})
+
+	t.Run("Test Guards Wrap The Steps", func(t *testing.T) {
+		ran := false
+		m := Steps("Guarded", Step{Description: "only", Up: func(client *elasticsearch.Client) error {
+			ran = true
+			return nil
+		}}).WithGuard(SkipIf("already done", func(client *elasticsearch.Client) (bool, error) { return true, nil }))
+
+		mm := NewMigrationManager(ClientFromTransport(&routeTransport{}), WithStore(NewMemoryStore()), WithMigrations(m))
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if ran {
+			t.Error("Expected the guard to skip the steps")
+		}
+	})
+
+	t.Run("Test Steps Are Validated", func(t *testing.T) {
+		m := Steps("Reshard", MigrationStep(ShrinkIndex("Shrink logs", ResizeConfig{Source: "logs", Target: "small"})))
+		if err := m.Validate(); err == nil || !strings.Contains(err.Error(), "step 1 of \"Reshard\"") {
+			t.Errorf("Expected the step validated, got %v", err)
+		}
+		if err := Steps("Empty").Validate(); err == nil {
+			t.Error("Expected a migration without steps refused")
+		}
+	})
+
+	t.Run("Test Version Depends On Steps", func(t *testing.T) {
+		up := func(client *elasticsearch.Client) error { return nil }
+		a := Steps("Reshard", Step{Description: "one", Up: up})
+		b := Steps("Reshard", Step{Description: "two", Up: up})
+		if a.Version() == b.Version() {
+			t.Error("Expected different steps to change the version")
+		}
+	})
+}
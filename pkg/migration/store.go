@@ -0,0 +1,135 @@
+package migration
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLocked is returned by VersionStore.Lock when another runner holds the lock
+var ErrLocked = errors.New("migration lock is held by another runner")
+
+// ErrLockLost is returned when releasing a lock that another runner took
+// over, e.g. after it expired
+var ErrLockLost = errors.New("migration lock was taken over by another runner")
+
+// VersionStore persists the records of applied migrations
+type VersionStore interface {
+	// GetApplied returns the records of all applied migrations in the order
+	// they were applied
+	GetApplied() ([]MigrationRecord, error)
+	// Record stores the record of an applied migration
+	Record(record MigrationRecord) error
+	// Remove deletes the record of a migration so it is considered pending again
+	Remove(version string) error
+	// Lock acquires an exclusive lock for running migrations. It returns
+	// ErrLocked without blocking if the lock is held elsewhere.
+	Lock() (unlock func() error, err error)
+}
+
+// failedStore is the store of a manager that can't determine where migrations
+// are tracked, failing every call with err
+type failedStore struct {
+	err error
+}
+
+func (s failedStore) GetApplied() ([]MigrationRecord, error) { return nil, s.err }
+func (s failedStore) Record(record MigrationRecord) error    { return s.err }
+func (s failedStore) Remove(version string) error            { return s.err }
+func (s failedStore) Lock() (func() error, error)            { return nil, s.err }
+
+// MigrationFailure is a failed attempt to apply a migration
+type MigrationFailure struct {
+	Version     string    `json:"version"`
+	Description string    `json:"description"`
+	FailedAt    time.Time `json:"failed_at"`
+	RunID       string    `json:"run_id,omitempty"`
+	DurationMS  int64     `json:"duration_ms,omitempty"`
+	Error       string    `json:"error"`
+}
+
+// FailureStore is implemented by version stores that keep failed attempts
+type FailureStore interface {
+	// RecordFailure stores a failed attempt
+	RecordFailure(failure MigrationFailure) error
+	// GetFailures returns failed attempts in the order they happened
+	GetFailures() ([]MigrationFailure, error)
+}
+
+// MigrationRollback is an applied migration that was rolled back
+type MigrationRollback struct {
+	// Record is the record the migration had before it was rolled back
+	Record       MigrationRecord `json:"record"`
+	RolledBackAt time.Time       `json:"rolled_back_at"`
+	RunnerMetadata
+}
+
+// RollbackStore is implemented by version stores that keep rollbacks
+type RollbackStore interface {
+	// RecordRollback stores a rollback
+	RecordRollback(rollback MigrationRollback) error
+	// GetRollbacks returns rollbacks in the order they happened
+	GetRollbacks() ([]MigrationRollback, error)
+}
+
+// QuarantineStore is implemented by version stores that can quarantine failed
+// migrations so later runs skip them
+type QuarantineStore interface {
+	// Quarantine marks the failed migration as quarantined
+	Quarantine(failure MigrationFailure) error
+	// Quarantined returns the failures of quarantined migrations
+	Quarantined() ([]MigrationFailure, error)
+	// Release lifts the quarantine of a migration
+	Release(version string) error
+}
+
+// MigrationTask is a long-running Elasticsearch task started by a migration,
+// such as a reindex, kept so a later run can re-attach to it when the run
+// that started it is interrupted
+type MigrationTask struct {
+	Version string `json:"version"`
+	TaskID  string `json:"task_id"`
+	// Request is the method and path that started the task, e.g.
+	// "POST /_reindex"
+	Request string `json:"request"`
+	// Index is the destination index of a reindex
+	Index     string    `json:"index,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// TaskStore is implemented by version stores that keep the tasks of running
+// migrations, so an interrupted migration resumes its task on the next run
+// instead of starting it again
+type TaskStore interface {
+	// RecordTask stores the task of a migration, replacing an earlier one
+	RecordTask(task MigrationTask) error
+	// GetTask returns the task of a migration, nil when there is none
+	GetTask(version string) (*MigrationTask, error)
+	// RemoveTask deletes the task of a migration
+	RemoveTask(version string) error
+}
+
+// MigrationCheckpoint is the progress of a migration made with Steps, kept so
+// a later run resumes after the last completed step when the run that
+// started it failed
+type MigrationCheckpoint struct {
+	Version string `json:"version"`
+	// Completed is the number of completed steps
+	Completed int `json:"completed"`
+	// Step is the description of the last completed step
+	Step      string    `json:"step"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CheckpointStore is implemented by version stores that keep the checkpoints
+// of migrations made with Steps. Without it, every step runs again after a
+// failure.
+type CheckpointStore interface {
+	// RecordCheckpoint stores the checkpoint of a migration, replacing an
+	// earlier one
+	RecordCheckpoint(checkpoint MigrationCheckpoint) error
+	// GetCheckpoint returns the checkpoint of a migration, nil when there is
+	// none
+	GetCheckpoint(version string) (*MigrationCheckpoint, error)
+	// RemoveCheckpoint deletes the checkpoint of a migration
+	RemoveCheckpoint(version string) error
+}
@@ -0,0 +1,1090 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+const (
+	lockDocumentID = "_elasticmate_lock"
+	defaultLockTTL = 15 * time.Minute
+)
+
+// ESStore keeps migration records in an Elasticsearch index
+type ESStore struct {
+	Client *elasticsearch.Client
+	Index  string
+
+	// LockTTL is how long a lock is honored before it is considered stale and
+	// can be taken over by another runner
+	LockTTL time.Duration
+
+	// Refresh is the refresh parameter used when writing records and locks
+	Refresh string
+	// RequestTimeout bounds each request when greater than zero
+	RequestTimeout time.Duration
+}
+
+func NewESStore(client *elasticsearch.Client) *ESStore {
+	return &ESStore{
+		Client:  client,
+		Index:   migrationsIndex,
+		LockTTL: defaultLockTTL,
+		Refresh: "true",
+	}
+}
+
+// context returns the context for a single request
+func (s *ESStore) context() (context.Context, context.CancelFunc) {
+	if s.RequestTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.RequestTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+type lockDocument struct {
+	Owner     string    `json:"owner"`
+	LockedAt  time.Time `json:"locked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// trackingMapping maps every document the store writes. It is strict so a
+// stray write cannot add fields to the tracking index.
+const trackingMapping = `{
+	"dynamic": "strict",
+	"properties": {
+		"version": { "type": "keyword" },
+		"description": { "type": "text" },
+		"applied_at": { "type": "date" },
+		"func_name": { "type": "keyword" },
+		"run_id": { "type": "keyword" },
+		"duration_ms": { "type": "long" },
+		"indices": { "type": "keyword" },
+		"state": { "type": "object", "enabled": false },
+		"checksum": { "type": "keyword" },
+		"skipped": { "type": "boolean" },
+		"hostname": { "type": "keyword" },
+		"user": { "type": "keyword" },
+		"app_version": { "type": "keyword" },
+		"git_sha": { "type": "keyword" },
+		"owner": { "type": "keyword" },
+		"locked_at": { "type": "date" },
+		"expires_at": { "type": "date" },
+		"namespace": { "type": "keyword" },
+		"exported_at": { "type": "date" },
+		"migrations": { "type": "object", "enabled": false },
+		"failure": {
+			"properties": {
+				"version": { "type": "keyword" },
+				"description": { "type": "text" },
+				"failed_at": { "type": "date" },
+				"run_id": { "type": "keyword" },
+				"duration_ms": { "type": "long" },
+				"error": { "type": "text" }
+			}
+		},
+		"rollback": {
+			"properties": {
+				"record": { "type": "object", "enabled": false },
+				"rolled_back_at": { "type": "date" },
+				"hostname": { "type": "keyword" },
+				"user": { "type": "keyword" },
+				"app_version": { "type": "keyword" },
+				"git_sha": { "type": "keyword" }
+			}
+		},
+		"quarantine": {
+			"properties": {
+				"version": { "type": "keyword" },
+				"description": { "type": "text" },
+				"failed_at": { "type": "date" },
+				"run_id": { "type": "keyword" },
+				"duration_ms": { "type": "long" },
+				"error": { "type": "text" }
+			}
+		},
+		"running_task": {
+			"properties": {
+				"version": { "type": "keyword" },
+				"task_id": { "type": "keyword" },
+				"request": { "type": "keyword" },
+				"index": { "type": "keyword" },
+				"started_at": { "type": "date" }
+			}
+		},
+		"checkpoint": {
+			"properties": {
+				"version": { "type": "keyword" },
+				"completed": { "type": "integer" },
+				"step": { "type": "text" },
+				"updated_at": { "type": "date" }
+			}
+		}
+	}
+}`
+
+// trackingGeneration is the suffix of the concrete index behind the
+// tracking alias
+const trackingGeneration = "-000001"
+
+// ensureIndex creates the tracking index unless an index or alias of that name
+// exists. It is created explicitly, so clusters with automatic index creation
+// disabled work, as a hidden index behind a write alias named Index, so it
+// can later be rolled over or reindexed without renaming the store.
+func (s *ESStore) ensureIndex() error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Indices.Exists([]string{s.Index}, s.Client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error checking migrations index: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 404 {
+		return nil
+	}
+
+	body := fmt.Sprintf(`{
+		"settings": {"index.hidden": true},
+		"aliases": {%q: {"is_write_index": true, "is_hidden": true}},
+		"mappings": %s
+	}`, s.Index, trackingMapping)
+	create, err := s.Client.Indices.Create(
+		s.Index+trackingGeneration,
+		s.Client.Indices.Create.WithBody(strings.NewReader(body)),
+		s.Client.Indices.Create.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating migrations index: %w", err)
+	}
+	defer create.Body.Close()
+
+	// another runner may have created it first
+	if create.IsError() && !strings.Contains(create.String(), "resource_already_exists_exception") {
+		return fmt.Errorf("error creating migrations index %s: %s", s.Index, create.String())
+	}
+	return nil
+}
+
+func (s *ESStore) GetApplied() ([]MigrationRecord, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+	return s.readApplied()
+}
+
+// readApplied returns the applied migrations without creating the tracking
+// index, so read-only credentials suffice. A missing index has none.
+func (s *ESStore) readApplied() ([]MigrationRecord, error) {
+	query := `{
+		"query": {"exists": {"field": "version"}},
+		"sort": [{"applied_at": "asc"}]
+	}`
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Search(
+		s.Client.Search.WithContext(ctx),
+		s.Client.Search.WithIndex(s.Index),
+		s.Client.Search.WithBody(strings.NewReader(query)),
+		s.Client.Search.WithSize(1000),
+		s.Client.Search.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying migrations: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error querying migrations: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source MigrationRecord `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing migrations: %w", err)
+	}
+
+	// Older releases could index duplicate records for one version
+	seen := make(map[string]bool, len(result.Hits.Hits))
+	records := make([]MigrationRecord, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		if seen[hit.Source.Version] {
+			continue
+		}
+		seen[hit.Source.Version] = true
+		records = append(records, hit.Source)
+	}
+
+	return records, nil
+}
+
+// Record indexes the record with the version as document ID and op_type
+// create, so recording the same version twice is a no-op and concurrent
+// runners cannot create duplicates
+func (s *ESStore) Record(record MigrationRecord) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshaling migration record: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	create := func() (*esapi.Response, error) {
+		return s.Client.Create(
+			s.Index,
+			record.Version,
+			strings.NewReader(string(data)),
+			s.Client.Create.WithContext(ctx),
+			s.Client.Create.WithRefresh(s.Refresh),
+		)
+	}
+	res, err := create()
+	if err != nil {
+		return fmt.Errorf("error recording migration: %w", err)
+	}
+	defer res.Body.Close()
+
+	// tracking indices created by older releases lack newer record fields
+	if res.StatusCode == 400 && strings.Contains(res.String(), "strict_dynamic_mapping_exception") {
+		if err := s.updateMapping(ctx); err != nil {
+			return err
+		}
+		if res, err = create(); err != nil {
+			return fmt.Errorf("error recording migration: %w", err)
+		}
+		defer res.Body.Close()
+	}
+
+	if res.StatusCode == 409 {
+		return nil
+	}
+	if res.IsError() {
+		return fmt.Errorf("error recording migration: %s", res.String())
+	}
+
+	return nil
+}
+
+// updateMapping adds the fields of trackingMapping missing from the tracking
+// index
+func (s *ESStore) updateMapping(ctx context.Context) error {
+	res, err := s.Client.Indices.PutMapping(
+		[]string{s.Index},
+		strings.NewReader(trackingMapping),
+		s.Client.Indices.PutMapping.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("error updating migrations index mapping: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error updating migrations index mapping: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) Remove(version string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Delete(
+		s.Index,
+		version,
+		s.Client.Delete.WithContext(ctx),
+		s.Client.Delete.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error removing migration record: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return s.removeLegacy(ctx, version)
+	}
+	if res.IsError() {
+		return fmt.Errorf("error removing migration record: %s", res.String())
+	}
+
+	return nil
+}
+
+// removeLegacy deletes records indexed with generated IDs by older releases
+func (s *ESStore) removeLegacy(ctx context.Context, version string) error {
+	query := fmt.Sprintf(`{"query": {"term": {"version": %q}}}`, version)
+	res, err := s.Client.DeleteByQuery(
+		[]string{s.Index},
+		strings.NewReader(query),
+		s.Client.DeleteByQuery.WithContext(ctx),
+		s.Client.DeleteByQuery.WithRefresh(s.Refresh != "false"),
+		s.Client.DeleteByQuery.WithIgnoreUnavailable(true),
+	)
+	if err != nil {
+		return fmt.Errorf("error removing migration record: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error removing migration record: %s", res.String())
+	}
+
+	return nil
+}
+
+// RecordFailure indexes a failed attempt. Failures are nested under a
+// "failure" field so GetApplied does not mistake them for records.
+func (s *ESStore) RecordFailure(failure MigrationFailure) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]MigrationFailure{"failure": failure})
+	if err != nil {
+		return fmt.Errorf("error marshaling migration failure: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Index(
+		s.Index,
+		strings.NewReader(string(data)),
+		s.Client.Index.WithContext(ctx),
+		s.Client.Index.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording migration failure: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error recording migration failure: %s", res.String())
+	}
+	return nil
+}
+
+// RecordRollback indexes a rollback, nested under a "rollback" field like
+// failures
+func (s *ESStore) RecordRollback(rollback MigrationRollback) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]MigrationRollback{"rollback": rollback})
+	if err != nil {
+		return fmt.Errorf("error marshaling migration rollback: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Index(
+		s.Index,
+		strings.NewReader(string(data)),
+		s.Client.Index.WithContext(ctx),
+		s.Client.Index.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording migration rollback: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error recording migration rollback: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) GetRollbacks() ([]MigrationRollback, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+
+	query := `{
+		"query": {"exists": {"field": "rollback.rolled_back_at"}},
+		"sort": [{"rollback.rolled_back_at": {"order": "asc", "unmapped_type": "date"}}]
+	}`
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Search(
+		s.Client.Search.WithContext(ctx),
+		s.Client.Search.WithIndex(s.Index),
+		s.Client.Search.WithBody(strings.NewReader(query)),
+		s.Client.Search.WithSize(1000),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying migration rollbacks: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error querying migration rollbacks: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source struct {
+					Rollback MigrationRollback `json:"rollback"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing migration rollbacks: %w", err)
+	}
+
+	rollbacks := make([]MigrationRollback, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		rollbacks = append(rollbacks, hit.Source.Rollback)
+	}
+	return rollbacks, nil
+}
+
+func (s *ESStore) GetFailures() ([]MigrationFailure, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+
+	query := `{
+		"query": {"exists": {"field": "failure.version"}},
+		"sort": [{"failure.failed_at": {"order": "asc", "unmapped_type": "date"}}]
+	}`
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Search(
+		s.Client.Search.WithContext(ctx),
+		s.Client.Search.WithIndex(s.Index),
+		s.Client.Search.WithBody(strings.NewReader(query)),
+		s.Client.Search.WithSize(1000),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying migration failures: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error querying migration failures: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source struct {
+					Failure MigrationFailure `json:"failure"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing migration failures: %w", err)
+	}
+
+	failures := make([]MigrationFailure, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		failures = append(failures, hit.Source.Failure)
+	}
+	return failures, nil
+}
+
+// Quarantine indexes the failure under a per-version document ID, replacing
+// an earlier quarantine of the same migration
+func (s *ESStore) Quarantine(failure MigrationFailure) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]MigrationFailure{"quarantine": failure})
+	if err != nil {
+		return fmt.Errorf("error marshaling quarantine: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Index(
+		s.Index,
+		strings.NewReader(string(data)),
+		s.Client.Index.WithDocumentID(quarantineDocumentID(failure.Version)),
+		s.Client.Index.WithContext(ctx),
+		s.Client.Index.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error quarantining migration: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error quarantining migration: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) Quarantined() ([]MigrationFailure, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+
+	query := `{
+		"query": {"exists": {"field": "quarantine.version"}},
+		"sort": [{"quarantine.failed_at": {"order": "asc", "unmapped_type": "date"}}]
+	}`
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Search(
+		s.Client.Search.WithContext(ctx),
+		s.Client.Search.WithIndex(s.Index),
+		s.Client.Search.WithBody(strings.NewReader(query)),
+		s.Client.Search.WithSize(1000),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying quarantined migrations: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error querying quarantined migrations: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source struct {
+					Quarantine MigrationFailure `json:"quarantine"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing quarantined migrations: %w", err)
+	}
+
+	failures := make([]MigrationFailure, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		failures = append(failures, hit.Source.Quarantine)
+	}
+	return failures, nil
+}
+
+func (s *ESStore) Release(version string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Delete(
+		s.Index,
+		quarantineDocumentID(version),
+		s.Client.Delete.WithContext(ctx),
+		s.Client.Delete.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error releasing migration: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error releasing migration: %s", res.String())
+	}
+	return nil
+}
+
+func quarantineDocumentID(version string) string {
+	return "_quarantine_" + version
+}
+
+// RecordTask indexes the task under a per-version document ID, replacing an
+// earlier task of the same migration
+func (s *ESStore) RecordTask(task MigrationTask) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]MigrationTask{"running_task": task})
+	if err != nil {
+		return fmt.Errorf("error marshaling migration task: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	index := func() (*esapi.Response, error) {
+		return s.Client.Index(
+			s.Index,
+			strings.NewReader(string(data)),
+			s.Client.Index.WithDocumentID(taskDocumentID(task.Version)),
+			s.Client.Index.WithContext(ctx),
+			s.Client.Index.WithRefresh(s.Refresh),
+		)
+	}
+	res, err := index()
+	if err != nil {
+		return fmt.Errorf("error recording migration task: %w", err)
+	}
+	defer res.Body.Close()
+
+	// tracking indices created by older releases lack the task fields
+	if res.StatusCode == 400 && strings.Contains(res.String(), "strict_dynamic_mapping_exception") {
+		if err := s.updateMapping(ctx); err != nil {
+			return err
+		}
+		if res, err = index(); err != nil {
+			return fmt.Errorf("error recording migration task: %w", err)
+		}
+		defer res.Body.Close()
+	}
+
+	if res.IsError() {
+		return fmt.Errorf("error recording migration task: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) GetTask(version string) (*MigrationTask, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Get(s.Index, taskDocumentID(version), s.Client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading migration task: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading migration task: %s", res.String())
+	}
+
+	var result struct {
+		Source struct {
+			Task *MigrationTask `json:"running_task"`
+		} `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing migration task: %w", err)
+	}
+	return result.Source.Task, nil
+}
+
+func (s *ESStore) RemoveTask(version string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Delete(
+		s.Index,
+		taskDocumentID(version),
+		s.Client.Delete.WithContext(ctx),
+		s.Client.Delete.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error removing migration task: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error removing migration task: %s", res.String())
+	}
+	return nil
+}
+
+func taskDocumentID(version string) string {
+	return "_task_" + version
+}
+
+// Lock creates a lock document in the migrations index. A lock older than
+// LockTTL is treated as stale and replaced, so while the lock is held its
+// expiry is extended every third of LockTTL. Releasing the lock only deletes
+// the document this runner wrote; it returns ErrLockLost when another runner
+// took the lock over in the meantime.
+func (s *ESStore) Lock() (func() error, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+
+	lock, err := s.createLock()
+	if err != nil {
+		return nil, err
+	}
+
+	if lock == nil {
+		stale, err := s.removeStaleLock()
+		if err != nil {
+			return nil, err
+		}
+		if !stale {
+			return nil, ErrLocked
+		}
+		if lock, err = s.createLock(); err != nil {
+			return nil, err
+		}
+		if lock == nil {
+			return nil, ErrLocked
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.heartbeat(lock, stop)
+	}()
+
+	var once sync.Once
+	var unlockErr error
+	return func() error {
+		once.Do(func() {
+			close(stop)
+			<-done
+			unlockErr = s.releaseLock(lock)
+		})
+		return unlockErr
+	}, nil
+}
+
+// esLock is a lock document written by this runner, identified by the
+// sequence number and primary term of its last write
+type esLock struct {
+	document    lockDocument
+	seqNo       int
+	primaryTerm int
+	// lost is set when a renewal found the document changed by another runner
+	lost bool
+}
+
+// heartbeat extends the expiry of lock every third of the TTL until stop is
+// closed or the lock is lost
+func (s *ESStore) heartbeat(lock *esLock, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.lockTTL() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		// a failed renewal is retried on the next tick, well before the lock
+		// expires, unless another runner took the lock over
+		if err := s.renewLock(lock); err != nil && lock.lost {
+			return
+		}
+	}
+}
+
+// renewLock moves the expiry of lock one TTL ahead, provided the document is
+// still the one this runner wrote
+func (s *ESStore) renewLock(lock *esLock) error {
+	document := lock.document
+	document.ExpiresAt = time.Now().Add(s.lockTTL())
+	data, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("error marshaling migration lock: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Index(
+		s.Index,
+		strings.NewReader(string(data)),
+		s.Client.Index.WithDocumentID(lockDocumentID),
+		s.Client.Index.WithIfSeqNo(lock.seqNo),
+		s.Client.Index.WithIfPrimaryTerm(lock.primaryTerm),
+		s.Client.Index.WithContext(ctx),
+		s.Client.Index.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error renewing migration lock: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 409 || res.StatusCode == 404 {
+		lock.lost = true
+		return ErrLockLost
+	}
+	if res.IsError() {
+		return fmt.Errorf("error renewing migration lock: %s", res.String())
+	}
+	seqNo, primaryTerm, err := writtenSeqNo(res)
+	if err != nil {
+		return fmt.Errorf("error renewing migration lock: %w", err)
+	}
+	lock.document, lock.seqNo, lock.primaryTerm = document, seqNo, primaryTerm
+	return nil
+}
+
+// releaseLock deletes the lock document if it is still the one this runner
+// wrote
+func (s *ESStore) releaseLock(lock *esLock) error {
+	if lock.lost {
+		return ErrLockLost
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Delete(
+		s.Index,
+		lockDocumentID,
+		s.Client.Delete.WithIfSeqNo(lock.seqNo),
+		s.Client.Delete.WithIfPrimaryTerm(lock.primaryTerm),
+		s.Client.Delete.WithContext(ctx),
+		s.Client.Delete.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error releasing migration lock: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 409 || res.StatusCode == 404 {
+		return ErrLockLost
+	}
+	if res.IsError() {
+		return fmt.Errorf("error releasing migration lock: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) lockTTL() time.Duration {
+	if s.LockTTL <= 0 {
+		return defaultLockTTL
+	}
+	return s.LockTTL
+}
+
+// createLock writes the lock document, returning nil when another runner
+// holds it
+func (s *ESStore) createLock() (*esLock, error) {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	document := lockDocument{
+		Owner:     fmt.Sprintf("%s/%d", hostname, os.Getpid()),
+		LockedAt:  now,
+		ExpiresAt: now.Add(s.lockTTL()),
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling migration lock: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Create(
+		s.Index,
+		lockDocumentID,
+		strings.NewReader(string(data)),
+		s.Client.Create.WithContext(ctx),
+		s.Client.Create.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 409 {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error acquiring migration lock: %s", res.String())
+	}
+
+	seqNo, primaryTerm, err := writtenSeqNo(res)
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	return &esLock{document: document, seqNo: seqNo, primaryTerm: primaryTerm}, nil
+}
+
+// writtenSeqNo returns the sequence number and primary term of a written
+// document
+func writtenSeqNo(res *esapi.Response) (int, int, error) {
+	var result struct {
+		SeqNo       *int `json:"_seq_no"`
+		PrimaryTerm int  `json:"_primary_term"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, 0, fmt.Errorf("error parsing write response: %w", err)
+	}
+	if result.SeqNo == nil {
+		return 0, 0, fmt.Errorf("no sequence number in write response")
+	}
+	return *result.SeqNo, result.PrimaryTerm, nil
+}
+
+// removeStaleLock deletes the current lock document if it has expired. The
+// delete is conditional on the document not having changed since it was read.
+func (s *ESStore) removeStaleLock() (bool, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Get(s.Index, lockDocumentID, s.Client.Get.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("error reading migration lock: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return true, nil
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("error reading migration lock: %s", res.String())
+	}
+
+	var doc struct {
+		SeqNo       int          `json:"_seq_no"`
+		PrimaryTerm int          `json:"_primary_term"`
+		Source      lockDocument `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return false, fmt.Errorf("error parsing migration lock: %w", err)
+	}
+
+	if time.Now().Before(doc.Source.ExpiresAt) {
+		return false, nil
+	}
+
+	del, err := s.Client.Delete(
+		s.Index,
+		lockDocumentID,
+		s.Client.Delete.WithIfSeqNo(doc.SeqNo),
+		s.Client.Delete.WithIfPrimaryTerm(doc.PrimaryTerm),
+		s.Client.Delete.WithContext(ctx),
+		s.Client.Delete.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return false, fmt.Errorf("error removing stale migration lock: %w", err)
+	}
+	defer del.Body.Close()
+
+	if del.StatusCode == 409 || del.StatusCode == 404 {
+		return false, nil
+	}
+	if del.IsError() {
+		return false, fmt.Errorf("error removing stale migration lock: %s", del.String())
+	}
+
+	return true, nil
+}
+
+// RecordCheckpoint indexes the checkpoint under a per-version document ID,
+// replacing an earlier checkpoint of the same migration
+func (s *ESStore) RecordCheckpoint(checkpoint MigrationCheckpoint) error {
+	if err := s.ensureIndex(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]MigrationCheckpoint{"checkpoint": checkpoint})
+	if err != nil {
+		return fmt.Errorf("error marshaling migration checkpoint: %w", err)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	index := func() (*esapi.Response, error) {
+		return s.Client.Index(
+			s.Index,
+			strings.NewReader(string(data)),
+			s.Client.Index.WithDocumentID(checkpointDocumentID(checkpoint.Version)),
+			s.Client.Index.WithContext(ctx),
+			s.Client.Index.WithRefresh(s.Refresh),
+		)
+	}
+	res, err := index()
+	if err != nil {
+		return fmt.Errorf("error recording migration checkpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	// tracking indices created by older releases lack the checkpoint fields
+	if res.StatusCode == 400 && strings.Contains(res.String(), "strict_dynamic_mapping_exception") {
+		if err := s.updateMapping(ctx); err != nil {
+			return err
+		}
+		if res, err = index(); err != nil {
+			return fmt.Errorf("error recording migration checkpoint: %w", err)
+		}
+		defer res.Body.Close()
+	}
+
+	if res.IsError() {
+		return fmt.Errorf("error recording migration checkpoint: %s", res.String())
+	}
+	return nil
+}
+
+func (s *ESStore) GetCheckpoint(version string) (*MigrationCheckpoint, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Get(s.Index, checkpointDocumentID(version), s.Client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading migration checkpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("error reading migration checkpoint: %s", res.String())
+	}
+
+	var result struct {
+		Source struct {
+			Checkpoint *MigrationCheckpoint `json:"checkpoint"`
+		} `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing migration checkpoint: %w", err)
+	}
+	return result.Source.Checkpoint, nil
+}
+
+func (s *ESStore) RemoveCheckpoint(version string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	res, err := s.Client.Delete(
+		s.Index,
+		checkpointDocumentID(version),
+		s.Client.Delete.WithContext(ctx),
+		s.Client.Delete.WithRefresh(s.Refresh),
+	)
+	if err != nil {
+		return fmt.Errorf("error removing migration checkpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != 404 {
+		return fmt.Errorf("error removing migration checkpoint: %s", res.String())
+	}
+	return nil
+}
+
+func checkpointDocumentID(version string) string {
+	return "_checkpoint_" + version
+}
@@ -0,0 +1,83 @@
+package migration
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// createIndexTransport stands in for a cluster without a tracking index,
+// answering index creation with status
+type createIndexTransport struct {
+	status int
+	paths  []string
+	body   string
+}
+
+func (c *createIndexTransport) Perform(req *http.Request) (*http.Response, error) {
+	status, body := 200, `{"hits": {"hits": []}}`
+	switch req.Method {
+	case http.MethodHead:
+		status, body = 404, ``
+	case http.MethodPut:
+		c.paths = append(c.paths, req.URL.Path)
+		data, _ := io.ReadAll(req.Body)
+		c.body = string(data)
+		status, body = c.status, `{}`
+		if c.status == 403 {
+			body = `{"error": {"type": "security_exception", "reason": "action [indices:admin/create] is unauthorized"}}`
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func TestESStoreIndex(t *testing.T) {
+	t.Run("Test Index Is Hidden Behind A Write Alias", func(t *testing.T) {
+		transport := &createIndexTransport{status: 200}
+		store := NewESStore(ClientFromTransport(transport))
+		store.Index = ".elasticmate_migrations_orders"
+		if _, err := store.GetApplied(); err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+
+		if len(transport.paths) != 1 || transport.paths[0] != "/.elasticmate_migrations_orders-000001" {
+			t.Fatalf("Expected the first generation to be created, got %v", transport.paths)
+		}
+		var body struct {
+			Settings map[string]interface{} `json:"settings"`
+			Aliases  map[string]struct {
+				IsWriteIndex bool `json:"is_write_index"`
+			} `json:"aliases"`
+			Mappings struct {
+				Dynamic string `json:"dynamic"`
+			} `json:"mappings"`
+		}
+		if err := json.Unmarshal([]byte(transport.body), &body); err != nil {
+			t.Fatalf("Failed to parse create index body: %v", err)
+		}
+		if body.Settings["index.hidden"] != true {
+			t.Errorf("Expected a hidden index, got %v", body.Settings)
+		}
+		if !body.Aliases[store.Index].IsWriteIndex {
+			t.Errorf("Expected a write alias named %s, got %v", store.Index, body.Aliases)
+		}
+		if body.Mappings.Dynamic != "strict" {
+			t.Errorf("Expected a strict mapping, got %q", body.Mappings.Dynamic)
+		}
+	})
+
+	t.Run("Test Refused Creation Is Reported", func(t *testing.T) {
+		transport := &createIndexTransport{status: 403}
+		store := NewESStore(ClientFromTransport(transport))
+		err := store.Record(MigrationRecord{Version: "aaaa0001"})
+		if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+			t.Errorf("Expected the creation error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,443 @@
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileStore keeps the history of migrations in a text file, one JSON entry
+// per line, appended as migrations are applied, removed, fail or are rolled
+// back:
+//
+//	{"status":"applied","at":"2024-05-15T12:00:00Z","version":"abcd1234","description":"Create users index",...}
+//	{"status":"removed","at":"2024-05-16T08:30:00Z","version":"abcd1234","description":"Create users index",...}
+//
+// Files written by older releases, a JSON object mapping versions to true or
+// to their records, are read too and converted on the next write.
+//
+// Writes hold an flock(2) lock on a .lock file next to the history, so
+// several processes can share it. A last line torn by a crash is ignored and
+// dropped on the next write.
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+var (
+	// heldLocks are the lock files this process holds through Lock, whose
+	// holder may write without locking again
+	heldLocks   = map[string]bool{}
+	heldLocksMu sync.Mutex
+	// fileWriteMu serializes the writes of this process
+	fileWriteMu sync.Mutex
+)
+
+func (s *FileStore) lockPath() string {
+	path, err := filepath.Abs(s.Path + ".lock")
+	if err != nil {
+		return s.Path + ".lock"
+	}
+	return path
+}
+
+// readHistory reads the entries of the history file, reporting whether it
+// must be rewritten rather than appended to: when it has an older format or
+// ends with a torn line
+func (s *FileStore) readHistory() ([]HistoryEntry, bool, error) {
+	if s.Path == "" {
+		return nil, false, fmt.Errorf("text file path not provided")
+	}
+
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read version file: %w", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, false, nil
+	}
+	if legacy, ok := legacyHistory(data); ok {
+		return legacy, true, nil
+	}
+
+	var entries []HistoryEntry
+	torn := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var entry HistoryEntry
+		err := json.Unmarshal(text, &entry)
+		if err == nil && entry.Status == "" {
+			err = fmt.Errorf("entry has no status")
+		}
+		if err != nil {
+			// only an unterminated last line can be cut short by a crash
+			if scanner.Scan() || data[len(data)-1] == '\n' {
+				return nil, false, fmt.Errorf("failed to decode line %d of version file: %w", line, err)
+			}
+			torn = true
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read version file: %w", err)
+	}
+	return entries, torn, nil
+}
+
+// legacyHistory converts a file written by older releases, a JSON object
+// keyed by version, to applied entries
+func legacyHistory(data []byte) ([]HistoryEntry, bool) {
+	var versions map[string]json.RawMessage
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, false
+	}
+	if _, ok := versions["status"]; ok {
+		// a history of a single entry
+		return nil, false
+	}
+
+	var records []MigrationRecord
+	for version, value := range versions {
+		var applied bool
+		if err := json.Unmarshal(value, &applied); err == nil {
+			if applied {
+				records = append(records, MigrationRecord{Version: version})
+			}
+			continue
+		}
+		var record MigrationRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return nil, false
+		}
+		record.Version = version
+		records = append(records, record)
+	}
+	sortRecords(records)
+
+	entries := make([]HistoryEntry, len(records))
+	for i, record := range records {
+		entries[i] = HistoryEntry{Status: HistoryApplied, At: record.AppliedAt, MigrationRecord: record}
+	}
+	return entries, true
+}
+
+// sortRecords orders records by application time, then version
+func sortRecords(records []MigrationRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].AppliedAt.Equal(records[j].AppliedAt) {
+			return records[i].AppliedAt.Before(records[j].AppliedAt)
+		}
+		return records[i].Version < records[j].Version
+	})
+}
+
+// appliedRecords folds a history into the records of applied migrations
+func appliedRecords(history []HistoryEntry) map[string]MigrationRecord {
+	records := make(map[string]MigrationRecord)
+	for _, entry := range history {
+		switch entry.Status {
+		case HistoryApplied:
+			records[entry.Version] = entry.MigrationRecord
+		case HistoryRemoved:
+			delete(records, entry.Version)
+		}
+	}
+	return records
+}
+
+// writeHistory replaces the history file with entries, writing a temporary
+// file next to it and renaming it into place
+func (s *FileStore) writeHistory(entries []HistoryEntry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create version file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write version file: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to replace version file: %w", err)
+	}
+	return nil
+}
+
+// appendHistory appends entries to the history file in a single write
+func (s *FileStore) appendHistory(entries []HistoryEntry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open version file: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write version file: %w", err)
+	}
+	return nil
+}
+
+func encodeEntries(entries []HistoryEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, fmt.Errorf("failed to encode version file: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// update appends the entries next returns for the current history, under the
+// file lock unless this process already holds it through Lock
+func (s *FileStore) update(next func(history []HistoryEntry) []HistoryEntry) error {
+	for {
+		held := s.held()
+		if !held {
+			// waits for runners of this process holding the lock, too
+			lock, err := s.openLock()
+			if err != nil {
+				return err
+			}
+			defer lock.Close()
+			if err := flock(lock, true); err != nil {
+				return fmt.Errorf("failed to lock version file: %w", err)
+			}
+			defer funlock(lock)
+		}
+
+		fileWriteMu.Lock()
+		if held && !s.held() {
+			// released meanwhile; lock the file instead
+			fileWriteMu.Unlock()
+			continue
+		}
+		defer fileWriteMu.Unlock()
+
+		history, rewrite, err := s.readHistory()
+		if err != nil {
+			return err
+		}
+		added := next(history)
+		if rewrite {
+			return s.writeHistory(append(history, added...))
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		return s.appendHistory(added)
+	}
+}
+
+func (s *FileStore) held() bool {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	return heldLocks[s.lockPath()]
+}
+
+func (s *FileStore) openLock() (*os.File, error) {
+	file, err := os.OpenFile(s.lockPath(), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	return file, nil
+}
+
+// GetApplied returns the applied records in the order they were applied.
+// Records without an application time, written by older releases, come
+// first, sorted by version.
+func (s *FileStore) GetApplied() ([]MigrationRecord, error) {
+	history, _, err := s.readHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := appliedRecords(history)
+	records := make([]MigrationRecord, 0, len(applied))
+	for _, record := range applied {
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+// Record appends an applied entry unless the version is applied
+func (s *FileStore) Record(record MigrationRecord) error {
+	return s.update(func(history []HistoryEntry) []HistoryEntry {
+		if _, ok := appliedRecords(history)[record.Version]; ok {
+			return nil
+		}
+		return []HistoryEntry{{Status: HistoryApplied, At: record.AppliedAt, MigrationRecord: record}}
+	})
+}
+
+// Remove appends a removed entry when the version is applied
+func (s *FileStore) Remove(version string) error {
+	return s.update(func(history []HistoryEntry) []HistoryEntry {
+		record, ok := appliedRecords(history)[version]
+		if !ok {
+			return nil
+		}
+		return []HistoryEntry{{Status: HistoryRemoved, At: time.Now().UTC(), MigrationRecord: record}}
+	})
+}
+
+// RecordFailure appends a failed entry
+func (s *FileStore) RecordFailure(failure MigrationFailure) error {
+	return s.update(func([]HistoryEntry) []HistoryEntry {
+		return []HistoryEntry{{
+			Status: HistoryFailed,
+			At:     failure.FailedAt,
+			MigrationRecord: MigrationRecord{
+				Version:     failure.Version,
+				Description: failure.Description,
+				RunID:       failure.RunID,
+				DurationMS:  failure.DurationMS,
+			},
+			Error: failure.Error,
+		}}
+	})
+}
+
+func (s *FileStore) GetFailures() ([]MigrationFailure, error) {
+	history, _, err := s.readHistory()
+	if err != nil {
+		return nil, err
+	}
+	var failures []MigrationFailure
+	for _, entry := range history {
+		if entry.Status != HistoryFailed {
+			continue
+		}
+		failures = append(failures, MigrationFailure{
+			Version:     entry.Version,
+			Description: entry.Description,
+			FailedAt:    entry.At,
+			RunID:       entry.RunID,
+			DurationMS:  entry.DurationMS,
+			Error:       entry.Error,
+		})
+	}
+	return failures, nil
+}
+
+// RecordRollback appends a rolled back entry with the record of the
+// migration, carrying the metadata of the runner that rolled it back
+func (s *FileStore) RecordRollback(rollback MigrationRollback) error {
+	return s.update(func([]HistoryEntry) []HistoryEntry {
+		record := rollback.Record
+		record.RunnerMetadata = rollback.RunnerMetadata
+		return []HistoryEntry{{Status: HistoryRolledBack, At: rollback.RolledBackAt, MigrationRecord: record}}
+	})
+}
+
+func (s *FileStore) GetRollbacks() ([]MigrationRollback, error) {
+	history, _, err := s.readHistory()
+	if err != nil {
+		return nil, err
+	}
+	var rollbacks []MigrationRollback
+	applied := map[string]MigrationRecord{}
+	for _, entry := range history {
+		switch entry.Status {
+		case HistoryApplied:
+			applied[entry.Version] = entry.MigrationRecord
+		case HistoryRolledBack:
+			rollback := MigrationRollback{Record: entry.MigrationRecord, RolledBackAt: entry.At, RunnerMetadata: entry.RunnerMetadata}
+			// the entry carries the runner of the rollback; the record keeps
+			// the runner that applied it
+			if record, ok := applied[entry.Version]; ok {
+				rollback.Record.RunnerMetadata = record.RunnerMetadata
+			}
+			rollbacks = append(rollbacks, rollback)
+		}
+	}
+	return rollbacks, nil
+}
+
+// Lock takes an flock(2) lock on a lock file next to the version file. The
+// lock is released on unlock or when the process exits, so a crashed runner
+// does not leave it held.
+func (s *FileStore) Lock() (func() error, error) {
+	lockPath := s.lockPath()
+	file, err := s.openLock()
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(file, false); err != nil {
+		file.Close()
+		if err == errFileLocked {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("failed to lock lock file: %w", err)
+	}
+	// note the holder for whoever finds the file
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+
+	heldLocksMu.Lock()
+	heldLocks[lockPath] = true
+	heldLocksMu.Unlock()
+
+	return func() error {
+		// let writes in progress finish under the lock
+		fileWriteMu.Lock()
+		defer fileWriteMu.Unlock()
+		heldLocksMu.Lock()
+		delete(heldLocks, lockPath)
+		heldLocksMu.Unlock()
+
+		// the file is kept: removing it would let another runner lock a
+		// new file while a third still waits on the old one
+		if err := funlock(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to unlock lock file: %w", err)
+		}
+		return file.Close()
+	}, nil
+}
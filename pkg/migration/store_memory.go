@@ -0,0 +1,194 @@
+package migration
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps migration records in memory. It is useful for tests and
+// for processes that re-apply migrations against ephemeral clusters.
+type MemoryStore struct {
+	mu          sync.Mutex
+	records     map[string]MigrationRecord
+	failures    []MigrationFailure
+	rollbacks   []MigrationRollback
+	quarantined map[string]MigrationFailure
+	tasks       map[string]MigrationTask
+	checkpoints map[string]MigrationCheckpoint
+	locked      bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records:     make(map[string]MigrationRecord),
+		quarantined: make(map[string]MigrationFailure),
+		tasks:       make(map[string]MigrationTask),
+		checkpoints: make(map[string]MigrationCheckpoint),
+	}
+}
+
+func (s *MemoryStore) GetApplied() ([]MigrationRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]MigrationRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].AppliedAt.Equal(records[j].AppliedAt) {
+			return records[i].Version < records[j].Version
+		}
+		return records[i].AppliedAt.Before(records[j].AppliedAt)
+	})
+
+	return records, nil
+}
+
+func (s *MemoryStore) Record(record MigrationRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[record.Version] = record
+	return nil
+}
+
+func (s *MemoryStore) Remove(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, version)
+	return nil
+}
+
+func (s *MemoryStore) RecordFailure(failure MigrationFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, failure)
+	return nil
+}
+
+func (s *MemoryStore) GetFailures() ([]MigrationFailure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]MigrationFailure{}, s.failures...), nil
+}
+
+func (s *MemoryStore) RecordRollback(rollback MigrationRollback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rollbacks = append(s.rollbacks, rollback)
+	return nil
+}
+
+func (s *MemoryStore) GetRollbacks() ([]MigrationRollback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]MigrationRollback{}, s.rollbacks...), nil
+}
+
+func (s *MemoryStore) Quarantine(failure MigrationFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.quarantined[failure.Version] = failure
+	return nil
+}
+
+func (s *MemoryStore) Quarantined() ([]MigrationFailure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures := make([]MigrationFailure, 0, len(s.quarantined))
+	for _, failure := range s.quarantined {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].FailedAt.Before(failures[j].FailedAt)
+	})
+	return failures, nil
+}
+
+func (s *MemoryStore) Release(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.quarantined, version)
+	return nil
+}
+
+func (s *MemoryStore) RecordTask(task MigrationTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tasks[task.Version] = task
+	return nil
+}
+
+func (s *MemoryStore) GetTask(version string) (*MigrationTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[version]
+	if !ok {
+		return nil, nil
+	}
+	return &task, nil
+}
+
+func (s *MemoryStore) RemoveTask(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tasks, version)
+	return nil
+}
+
+func (s *MemoryStore) RecordCheckpoint(checkpoint MigrationCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[checkpoint.Version] = checkpoint
+	return nil
+}
+
+func (s *MemoryStore) GetCheckpoint(version string) (*MigrationCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoint, ok := s.checkpoints[version]
+	if !ok {
+		return nil, nil
+	}
+	return &checkpoint, nil
+}
+
+func (s *MemoryStore) RemoveCheckpoint(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.checkpoints, version)
+	return nil
+}
+
+func (s *MemoryStore) Lock() (func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked {
+		return nil, ErrLocked
+	}
+	s.locked = true
+
+	return func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.locked = false
+		return nil
+	}, nil
+}
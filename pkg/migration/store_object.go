@@ -0,0 +1,295 @@
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrObjectNotFound is returned by ObjectBucket.Get when the object does not exist
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrPreconditionFailed is returned by ObjectBucket.Put and a conditional
+	// ObjectBucket.Delete when the object revision does not match the expected
+	// one
+	ErrPreconditionFailed = errors.New("object precondition failed")
+)
+
+// ObjectBucket is the minimal object storage API needed by ObjectStore.
+// S3Bucket, GCSBucket and AzureBlobBucket are dependency-free implementations;
+// it can also be implemented on top of an existing SDK client.
+type ObjectBucket interface {
+	// Get returns the object content and its revision (ETag or generation)
+	Get(key string) (data []byte, revision string, err error)
+	// Put writes the object if its current revision is ifRevision. An empty
+	// ifRevision requires that the object does not exist yet.
+	Put(key string, data []byte, ifRevision string) (revision string, err error)
+	// Delete removes the object if its current revision is ifRevision, or
+	// unconditionally when ifRevision is empty. Deleting a missing object is
+	// not an error unless the delete is conditional.
+	Delete(key string, ifRevision string) error
+}
+
+// ObjectStore keeps migration records in a single JSON object in object
+// storage, using conditional writes for consistency and a lock object for
+// mutual exclusion
+type ObjectStore struct {
+	Bucket ObjectBucket
+	Key    string
+
+	// LockTTL is how long a lock is honored before it is considered stale
+	LockTTL time.Duration
+}
+
+func NewObjectStore(bucket ObjectBucket, key string) *ObjectStore {
+	return &ObjectStore{
+		Bucket:  bucket,
+		Key:     key,
+		LockTTL: defaultLockTTL,
+	}
+}
+
+type objectState struct {
+	Records []MigrationRecord `json:"records"`
+}
+
+const objectWriteAttempts = 10
+
+func (s *ObjectStore) read() (objectState, string, error) {
+	var state objectState
+
+	data, revision, err := s.Bucket.Get(s.Key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return state, "", nil
+	}
+	if err != nil {
+		return state, "", fmt.Errorf("failed to read version object: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, "", fmt.Errorf("failed to decode version object: %w", err)
+	}
+
+	return state, revision, nil
+}
+
+// update applies fn to the stored state and writes it back, retrying when the
+// object was changed concurrently
+func (s *ObjectStore) update(fn func(state *objectState)) error {
+	for attempt := 0; attempt < objectWriteAttempts; attempt++ {
+		state, revision, err := s.read()
+		if err != nil {
+			return err
+		}
+
+		fn(&state)
+
+		data, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode version object: %w", err)
+		}
+
+		_, err = s.Bucket.Put(s.Key, data, revision)
+		if errors.Is(err, ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write version object: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed to write version object: too many concurrent updates")
+}
+
+func (s *ObjectStore) GetApplied() ([]MigrationRecord, error) {
+	state, _, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	records := append([]MigrationRecord{}, state.Records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].AppliedAt.Before(records[j].AppliedAt)
+	})
+
+	return records, nil
+}
+
+func (s *ObjectStore) Record(record MigrationRecord) error {
+	return s.update(func(state *objectState) {
+		for i := range state.Records {
+			if state.Records[i].Version == record.Version {
+				state.Records[i] = record
+				return
+			}
+		}
+		state.Records = append(state.Records, record)
+	})
+}
+
+func (s *ObjectStore) Remove(version string) error {
+	return s.update(func(state *objectState) {
+		records := state.Records[:0]
+		for _, record := range state.Records {
+			if record.Version != version {
+				records = append(records, record)
+			}
+		}
+		state.Records = records
+	})
+}
+
+// Lock creates a lock object next to the version object. An expired lock is
+// taken over with a conditional write, so while the lock is held it is
+// rewritten with a later expiry every third of LockTTL. Releasing the lock
+// only deletes the revision this runner wrote; it returns ErrLockLost when
+// another runner took the lock over in the meantime.
+func (s *ObjectStore) Lock() (func() error, error) {
+	lock, err := s.acquireLock()
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.heartbeat(lock, stop)
+	}()
+
+	var once sync.Once
+	var unlockErr error
+	return func() error {
+		once.Do(func() {
+			close(stop)
+			<-done
+			unlockErr = s.releaseLock(lock)
+		})
+		return unlockErr
+	}, nil
+}
+
+// objectLock is a lock object written by this runner
+type objectLock struct {
+	key      string
+	revision string
+	// lost is set when a renewal found the object changed by another runner
+	lost bool
+}
+
+func (s *ObjectStore) lockTTL() time.Duration {
+	if s.LockTTL <= 0 {
+		return defaultLockTTL
+	}
+	return s.LockTTL
+}
+
+func (s *ObjectStore) lockData() ([]byte, error) {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	data, err := json.Marshal(lockDocument{
+		Owner:     fmt.Sprintf("%s/%d", hostname, os.Getpid()),
+		LockedAt:  now,
+		ExpiresAt: now.Add(s.lockTTL()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lock object: %w", err)
+	}
+	return data, nil
+}
+
+func (s *ObjectStore) acquireLock() (*objectLock, error) {
+	lockKey := s.Key + ".lock"
+	data, err := s.lockData()
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := s.Bucket.Put(lockKey, data, "")
+	if err == nil {
+		return &objectLock{key: lockKey, revision: revision}, nil
+	}
+	if !errors.Is(err, ErrPreconditionFailed) {
+		return nil, fmt.Errorf("failed to create lock object: %w", err)
+	}
+
+	existing, current, err := s.Bucket.Get(lockKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock object: %w", err)
+	}
+
+	var document lockDocument
+	if err := json.Unmarshal(existing, &document); err != nil {
+		return nil, fmt.Errorf("failed to decode lock object: %w", err)
+	}
+	if time.Now().Before(document.ExpiresAt) {
+		return nil, ErrLocked
+	}
+
+	revision, err = s.Bucket.Put(lockKey, data, current)
+	if err != nil {
+		if errors.Is(err, ErrPreconditionFailed) {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("failed to take over stale lock object: %w", err)
+	}
+	return &objectLock{key: lockKey, revision: revision}, nil
+}
+
+// heartbeat extends the expiry of lock every third of the TTL until stop is
+// closed or the lock is lost
+func (s *ObjectStore) heartbeat(lock *objectLock, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.lockTTL() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		// a failed renewal is retried on the next tick, well before the lock
+		// expires, unless another runner took the lock over
+		if err := s.renewLock(lock); err != nil && lock.lost {
+			return
+		}
+	}
+}
+
+func (s *ObjectStore) renewLock(lock *objectLock) error {
+	data, err := s.lockData()
+	if err != nil {
+		return err
+	}
+	revision, err := s.Bucket.Put(lock.key, data, lock.revision)
+	if errors.Is(err, ErrPreconditionFailed) {
+		lock.lost = true
+		return ErrLockLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to renew lock object: %w", err)
+	}
+	lock.revision = revision
+	return nil
+}
+
+func (s *ObjectStore) releaseLock(lock *objectLock) error {
+	if lock.lost {
+		return ErrLockLost
+	}
+	err := s.Bucket.Delete(lock.key, lock.revision)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove lock object: %w", err)
+	}
+	return nil
+}
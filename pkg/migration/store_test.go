@@ -0,0 +1,294 @@
+package migration_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+	"github.com/punitsu/elasticmate/pkg/migration/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) migration.VersionStore {
+		return migration.NewMemoryStore()
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) migration.VersionStore {
+		return migration.NewFileStore(filepath.Join(t.TempDir(), "versions.json"))
+	})
+}
+
+func TestESStoreLock(t *testing.T) {
+	lockPath := "/.elasticmate_migrations/_doc/_elasticmate_lock"
+
+	t.Run("Test Lock Is Renewed While Held", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		store := migration.NewESStore(es.Client())
+		store.LockTTL = 30 * time.Millisecond
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		if _, err := migration.NewESStore(es.Client()).Lock(); !errors.Is(err, migration.ErrLocked) {
+			t.Errorf("Expected a renewed lock to be held past its TTL, got %v", err)
+		}
+		if !es.Sent("PUT", lockPath) {
+			t.Error("Expected the lock to be renewed")
+		}
+		if err := unlock(); err != nil {
+			t.Errorf("Unlock failed: %v", err)
+		}
+	})
+
+	t.Run("Test Unlock Keeps A Lock Taken Over", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		store := migration.NewESStore(es.Client())
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		// another runner replaces the lock, e.g. after it expired
+		es.AddDocument(".elasticmate_migrations", "_elasticmate_lock", map[string]interface{}{
+			"owner":      "other/1",
+			"locked_at":  time.Now(),
+			"expires_at": time.Now().Add(time.Hour),
+		})
+
+		if err := unlock(); !errors.Is(err, migration.ErrLockLost) {
+			t.Errorf("Expected ErrLockLost, got %v", err)
+		}
+		if _, err := migration.NewESStore(es.Client()).Lock(); !errors.Is(err, migration.ErrLocked) {
+			t.Errorf("Expected the lock of the other runner to be kept, got %v", err)
+		}
+	})
+}
+
+func TestFileStoreFormat(t *testing.T) {
+	t.Run("Test Records Keep Their Metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		store := migration.NewFileStore(filepath.Join(dir, "versions.json"))
+		appliedAt := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)
+		if err := store.Record(migration.MigrationRecord{Version: "aaaa0001", Description: "Create articles", AppliedAt: appliedAt}); err != nil {
+			t.Fatalf("Failed to record migration: %v", err)
+		}
+
+		records, err := migration.NewFileStore(filepath.Join(dir, "versions.json")).GetApplied()
+		if err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+		if len(records) != 1 || records[0].Description != "Create articles" || !records[0].AppliedAt.Equal(appliedAt) {
+			t.Errorf("Expected the record to keep its metadata, got %+v", records)
+		}
+
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.Contains(entry.Name(), ".tmp-") {
+				t.Errorf("Expected no temporary files to be left, got %s", entry.Name())
+			}
+		}
+	})
+
+	t.Run("Test Older Files Are Read", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "versions.json")
+		if err := os.WriteFile(path, []byte(`{"aaaa0002": true, "aaaa0001": true, "aaaa0003": false}`), 0o644); err != nil {
+			t.Fatalf("Failed to write version file: %v", err)
+		}
+		store := migration.NewFileStore(path)
+		if err := store.Record(migration.MigrationRecord{Version: "aaaa0004", AppliedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to record migration: %v", err)
+		}
+
+		records, err := store.GetApplied()
+		if err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+		var versions []string
+		for _, record := range records {
+			versions = append(versions, record.Version)
+		}
+		if strings.Join(versions, ",") != "aaaa0001,aaaa0002,aaaa0004" {
+			t.Errorf("Expected aaaa0001,aaaa0002,aaaa0004, got %v", versions)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read version file: %v", err)
+		}
+		if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 {
+			t.Errorf("Expected the file to be converted to 3 history lines, got %s", data)
+		}
+	})
+
+	t.Run("Test History Is Appended", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "versions.json")
+		store := migration.NewFileStore(path)
+		if err := store.Record(migration.MigrationRecord{Version: "aaaa0001", Description: "Create articles", AppliedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to record migration: %v", err)
+		}
+		if err := store.Remove("aaaa0001"); err != nil {
+			t.Fatalf("Failed to remove migration: %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read version file: %v", err)
+		}
+		var statuses []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var entry migration.HistoryEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Failed to parse history line %q: %v", line, err)
+			}
+			if entry.Version != "aaaa0001" || entry.Description != "Create articles" {
+				t.Errorf("Expected the entry to carry the record, got %+v", entry)
+			}
+			statuses = append(statuses, string(entry.Status))
+		}
+		if strings.Join(statuses, ",") != "applied,removed" {
+			t.Errorf("Expected applied,removed, got %v", statuses)
+		}
+		if records, _ := store.GetApplied(); len(records) != 0 {
+			t.Errorf("Expected no applied migrations, got %v", records)
+		}
+	})
+
+	t.Run("Test Torn Last Line Is Ignored", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "versions.json")
+		history := `{"status":"applied","at":"2024-05-15T12:00:00Z","version":"aaaa0001"}` + "\n" + `{"status":"appl`
+		if err := os.WriteFile(path, []byte(history), 0o644); err != nil {
+			t.Fatalf("Failed to write version file: %v", err)
+		}
+		store := migration.NewFileStore(path)
+		if err := store.Record(migration.MigrationRecord{Version: "aaaa0002", AppliedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to record migration: %v", err)
+		}
+
+		records, err := store.GetApplied()
+		if err != nil {
+			t.Fatalf("Failed to get applied migrations: %v", err)
+		}
+		if len(records) != 2 {
+			t.Errorf("Expected 2 applied migrations, got %v", records)
+		}
+	})
+
+	t.Run("Test Corrupt Lines Are Reported", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "versions.json")
+		if err := os.WriteFile(path, []byte("not json\n{\"status\":\"applied\",\"version\":\"aaaa0001\"}\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write version file: %v", err)
+		}
+		if _, err := migration.NewFileStore(path).GetApplied(); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+func TestObjectStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) migration.VersionStore {
+		return migration.NewObjectStore(newMemoryBucket(), "elasticmate/versions.json")
+	})
+}
+
+func TestObjectStoreLock(t *testing.T) {
+	t.Run("Test Lock Is Renewed While Held", func(t *testing.T) {
+		bucket := newMemoryBucket()
+		store := migration.NewObjectStore(bucket, "elasticmate/versions.json")
+		store.LockTTL = 30 * time.Millisecond
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		if _, err := migration.NewObjectStore(bucket, "elasticmate/versions.json").Lock(); !errors.Is(err, migration.ErrLocked) {
+			t.Errorf("Expected a renewed lock to be held past its TTL, got %v", err)
+		}
+		if err := unlock(); err != nil {
+			t.Errorf("Unlock failed: %v", err)
+		}
+	})
+
+	t.Run("Test Unlock Keeps A Lock Taken Over", func(t *testing.T) {
+		bucket := newMemoryBucket()
+		store := migration.NewObjectStore(bucket, "elasticmate/versions.json")
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		// another runner replaces the lock, e.g. after it expired
+		_, revision, _ := bucket.Get("elasticmate/versions.json.lock")
+		lock := fmt.Sprintf(`{"owner": "other/1", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		if _, err := bucket.Put("elasticmate/versions.json.lock", []byte(lock), revision); err != nil {
+			t.Fatalf("Failed to take over the lock: %v", err)
+		}
+
+		if err := unlock(); !errors.Is(err, migration.ErrLockLost) {
+			t.Errorf("Expected ErrLockLost, got %v", err)
+		}
+		if _, err := store.Lock(); !errors.Is(err, migration.ErrLocked) {
+			t.Errorf("Expected the lock of the other runner to be kept, got %v", err)
+		}
+	})
+}
+
+// memoryBucket is an in-memory ObjectBucket with S3-like conditional writes
+type memoryBucket struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	versions map[string]int
+}
+
+func newMemoryBucket() *memoryBucket {
+	return &memoryBucket{
+		objects:  make(map[string][]byte),
+		versions: make(map[string]int),
+	}
+}
+
+func (b *memoryBucket) Get(key string) ([]byte, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, "", migration.ErrObjectNotFound
+	}
+	return data, fmt.Sprint(b.versions[key]), nil
+}
+
+func (b *memoryBucket) Put(key string, data []byte, ifRevision string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, exists := b.objects[key]
+	if (ifRevision == "" && exists) || (ifRevision != "" && ifRevision != fmt.Sprint(b.versions[key])) {
+		return "", migration.ErrPreconditionFailed
+	}
+
+	b.objects[key] = data
+	b.versions[key]++
+	return fmt.Sprint(b.versions[key]), nil
+}
+
+func (b *memoryBucket) Delete(key string, ifRevision string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.objects[key]; ifRevision != "" && (!exists || ifRevision != fmt.Sprint(b.versions[key])) {
+		return migration.ErrPreconditionFailed
+	}
+	delete(b.objects, key)
+	return nil
+}
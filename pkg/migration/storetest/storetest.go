@@ -0,0 +1,206 @@
+// Package storetest provides a conformance test suite for migration.VersionStore
+// implementations.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+// Run runs the conformance suite against stores returned by newStore. Each call
+// to newStore must return an empty store that no other test uses.
+//
+// Records in the ordering checks are written with versions whose lexical order
+// matches the order they are applied in, so stores that do not keep
+// application times may order by version.
+func Run(t *testing.T, newStore func(t *testing.T) migration.VersionStore) {
+	t.Run("Empty Store", func(t *testing.T) {
+		store := newStore(t)
+
+		records, err := store.GetApplied()
+		if err != nil {
+			t.Fatalf("GetApplied failed: %v", err)
+		}
+		if len(records) != 0 {
+			t.Errorf("Expected no records, got %d", len(records))
+		}
+	})
+
+	t.Run("Record And List", func(t *testing.T) {
+		store := newStore(t)
+
+		if err := store.Record(newRecord("0001", time.Now())); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+
+		assertVersions(t, store, "0001")
+	})
+
+	t.Run("Record Is Idempotent", func(t *testing.T) {
+		store := newStore(t)
+
+		record := newRecord("0001", time.Now())
+		for i := 0; i < 2; i++ {
+			if err := store.Record(record); err != nil {
+				t.Fatalf("Record attempt %d failed: %v", i+1, err)
+			}
+		}
+
+		assertVersions(t, store, "0001")
+	})
+
+	t.Run("Ordering", func(t *testing.T) {
+		store := newStore(t)
+
+		base := time.Now().Add(-time.Hour)
+		for i, version := range []string{"0001", "0002", "0003"} {
+			if err := store.Record(newRecord(version, base.Add(time.Duration(i)*time.Minute))); err != nil {
+				t.Fatalf("Record %s failed: %v", version, err)
+			}
+		}
+
+		assertVersions(t, store, "0001", "0002", "0003")
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		store := newStore(t)
+
+		for _, version := range []string{"0001", "0002"} {
+			if err := store.Record(newRecord(version, time.Now())); err != nil {
+				t.Fatalf("Record %s failed: %v", version, err)
+			}
+		}
+
+		if err := store.Remove("0001"); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+		assertVersions(t, store, "0002")
+
+		if err := store.Remove("missing"); err != nil {
+			t.Errorf("Expected removing an unknown version to succeed, got %v", err)
+		}
+	})
+
+	t.Run("Lock Is Exclusive", func(t *testing.T) {
+		store := newStore(t)
+
+		unlock, err := store.Lock()
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+
+		if _, err := store.Lock(); !errors.Is(err, migration.ErrLocked) {
+			t.Errorf("Expected ErrLocked while lock is held, got %v", err)
+		}
+
+		if err := unlock(); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+
+		unlock, err = store.Lock()
+		if err != nil {
+			t.Fatalf("Expected lock to be available after unlock, got %v", err)
+		}
+		if err := unlock(); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+	})
+
+	t.Run("Concurrent Locked Writes", func(t *testing.T) {
+		store := newStore(t)
+
+		const writers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+
+		for i := 0; i < writers; i++ {
+			version := fmt.Sprintf("%04d", i)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- lockedRecord(store, newRecord(version, time.Now()))
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("Locked write failed: %v", err)
+			}
+		}
+
+		records, err := store.GetApplied()
+		if err != nil {
+			t.Fatalf("GetApplied failed: %v", err)
+		}
+		if len(records) != writers {
+			t.Errorf("Expected %d records, got %d", writers, len(records))
+		}
+	})
+
+	t.Run("Concurrent Runners", func(t *testing.T) {
+		store := newStore(t)
+
+		migrationtest.RunConcurrent(t, migrationtest.ConcurrencyConfig{
+			NewManager: func() *migration.MigrationManager {
+				return migration.NewMigrationManager(nil, migration.WithStore(store))
+			},
+		})
+	})
+}
+
+func newRecord(version string, appliedAt time.Time) migration.MigrationRecord {
+	return migration.MigrationRecord{
+		Version:     version,
+		Description: "storetest migration " + version,
+		AppliedAt:   appliedAt,
+		FuncName:    "storetest.migration",
+	}
+}
+
+// lockedRecord records a migration while holding the store lock, retrying
+// while the lock is held by another writer
+func lockedRecord(store migration.VersionStore, record migration.MigrationRecord) error {
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		unlock, err := store.Lock()
+		if errors.Is(err, migration.ErrLocked) && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		recordErr := store.Record(record)
+		if err := unlock(); err != nil {
+			return err
+		}
+		return recordErr
+	}
+}
+
+func assertVersions(t *testing.T, store migration.VersionStore, expected ...string) {
+	t.Helper()
+
+	records, err := store.GetApplied()
+	if err != nil {
+		t.Fatalf("GetApplied failed: %v", err)
+	}
+
+	var versions []string
+	for _, record := range records {
+		versions = append(versions, record.Version)
+	}
+
+	if fmt.Sprint(versions) != fmt.Sprint(expected) {
+		t.Errorf("Expected versions %v, got %v", expected, versions)
+	}
+}
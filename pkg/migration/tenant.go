@@ -0,0 +1,160 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// TenantPlaceholder is replaced by the tenant in the index pattern of a
+// templated migration
+const TenantPlaceholder = "{tenant}"
+
+// TenantSource returns the tenants templated migrations are applied for. It is
+// called whenever the registered migrations are listed, such as at the start
+// of each run.
+type TenantSource func() ([]string, error)
+
+// StaticTenants returns a fixed list of tenants
+func StaticTenants(tenants ...string) TenantSource {
+	return func() ([]string, error) {
+		return tenants, nil
+	}
+}
+
+// WithTenants expands migrations created with ForEachTenant for the tenants
+// source returns
+func WithTenants(source TenantSource) Option {
+	return func(mm *MigrationManager) {
+		mm.tenants = source
+	}
+}
+
+// tenantTemplate is the per tenant part of a templated migration
+type tenantTemplate struct {
+	pattern string
+	up      func(client *elasticsearch.Client, index string) error
+	down    func(client *elasticsearch.Client, index string) error
+	// guards wrap the function of every tenant, innermost first
+	guards []Guard
+}
+
+// tenantName restricts tenants to names valid in an index name
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ForEachTenant returns a migration applied to the index of every tenant, the
+// pattern with TenantPlaceholder replaced by the tenant, e.g. orders_{tenant}.
+// Each tenant is tracked as a migration of its own, versioned
+// "<version>_<tenant>", so a tenant added to the source later receives every
+// templated migration on the next run, in order. The manager must be
+// created WithTenants.
+func ForEachTenant(description, pattern string, up func(client *elasticsearch.Client, index string) error) Migration {
+	m := Migration{
+		Description: description,
+		// the migration only runs once expanded for a tenant
+		UpFunc: func(client *elasticsearch.Client) error {
+			return fmt.Errorf("templated migration was not expanded for tenants")
+		},
+		tenant: &tenantTemplate{pattern: pattern, up: up},
+	}
+
+	hasher := sha256.New()
+	hasher.Write([]byte(runtime.FuncForPC(reflect.ValueOf(up).Pointer()).Name()))
+	hasher.Write([]byte(pattern))
+	hasher.Write([]byte(description))
+	m.version = hex.EncodeToString(hasher.Sum(nil))[:8]
+
+	m.validate = func() error {
+		return fmt.Errorf("migration is templated per tenant; create the manager WithTenants")
+	}
+	return m
+}
+
+// WithTenantDown sets the function reverting a templated migration for the
+// index of one tenant on Rollback
+func (m Migration) WithTenantDown(down func(client *elasticsearch.Client, index string) error) Migration {
+	if m.tenant != nil {
+		tenant := *m.tenant
+		tenant.down = down
+		m.tenant = &tenant
+	}
+	return m
+}
+
+// Tenant returns the tenant a templated migration was expanded for, empty for
+// other migrations
+func (m Migration) Tenant() string {
+	return m.tenantID
+}
+
+// forTenant returns the migration applying the template to the index of tenant
+func (m Migration) forTenant(tenant string) Migration {
+	template := m.tenant
+	index := strings.ReplaceAll(template.pattern, TenantPlaceholder, tenant)
+
+	m.tenant = nil
+	m.tenantID = tenant
+	m.version = m.version + "_" + tenant
+	m.Description = fmt.Sprintf("%s (tenant %s)", m.Description, tenant)
+	m.UpFunc = func(client *elasticsearch.Client) error {
+		return template.up(client, index)
+	}
+	for _, guard := range template.guards {
+		m.UpFunc = guard(m.UpFunc)
+	}
+	if template.down != nil {
+		m.DownFunc = func(client *elasticsearch.Client) error {
+			return template.down(client, index)
+		}
+	}
+	m.validate = nil
+	return m
+}
+
+// registered returns a copy of the registered migrations with templated
+// migrations expanded for every tenant
+func (mm *MigrationManager) registered() ([]Migration, error) {
+	mm.mu.Lock()
+	registered := append([]Migration{}, mm.Migrations...)
+	mm.mu.Unlock()
+
+	templated := false
+	for _, migration := range registered {
+		templated = templated || migration.tenant != nil
+	}
+	if !templated || mm.tenants == nil {
+		return registered, nil
+	}
+
+	tenants, err := mm.tenants()
+	if err != nil {
+		return nil, fmt.Errorf("error listing tenants: %w", err)
+	}
+	for _, tenant := range tenants {
+		if !tenantName.MatchString(tenant) {
+			return nil, fmt.Errorf("invalid tenant %q: tenants must be lowercase letters, digits, - and _", tenant)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(registered))
+	for _, migration := range registered {
+		if migration.tenant == nil {
+			migrations = append(migrations, migration)
+			continue
+		}
+		if !strings.Contains(migration.tenant.pattern, TenantPlaceholder) {
+			return nil, fmt.Errorf("invalid migration %s: index pattern %q has no %s placeholder",
+				migration.Version(), migration.tenant.pattern, TenantPlaceholder)
+		}
+		for _, tenant := range tenants {
+			migrations = append(migrations, migration.forTenant(tenant))
+		}
+	}
+	return migrations, nil
+}
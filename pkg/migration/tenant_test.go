@@ -0,0 +1,120 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestForEachTenant(t *testing.T) {
+	var applied []string
+	createOrders := ForEachTenant("Create orders index", "orders_{tenant}", func(client *elasticsearch.Client, index string) error {
+		applied = append(applied, "create "+index)
+		return nil
+	})
+	addStatus := ForEachTenant("Add status field", "orders_{tenant}", func(client *elasticsearch.Client, index string) error {
+		applied = append(applied, "status "+index)
+		return nil
+	})
+
+	tenants := []string{"acme", "globex"}
+	store := NewMemoryStore()
+	mm := NewMigrationManager(nil, WithStore(store), WithTenants(func() ([]string, error) {
+		return tenants, nil
+	}))
+	mm.Register(createOrders)
+	mm.Register(addStatus)
+
+	t.Run("Test Migrations Are Applied Per Tenant", func(t *testing.T) {
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if len(applied) != 4 {
+			t.Fatalf("Expected 2 migrations for 2 tenants, got %v", applied)
+		}
+
+		records, _ := store.GetApplied()
+		versions := map[string]bool{}
+		for _, record := range records {
+			versions[record.Version] = true
+		}
+		for _, tenant := range tenants {
+			if !versions[createOrders.Version()+"_"+tenant] || !versions[addStatus.Version()+"_"+tenant] {
+				t.Errorf("Expected both migrations to be recorded for %s, got %v", tenant, versions)
+			}
+		}
+	})
+
+	t.Run("Test New Tenants Are Backfilled", func(t *testing.T) {
+		applied = nil
+		tenants = append(tenants, "initech")
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if len(applied) != 2 {
+			t.Fatalf("Expected only the new tenant to be migrated, got %v", applied)
+		}
+		// each tenant receives the templated migrations in registration order
+		first, second := "create orders_initech", "status orders_initech"
+		if createOrders.Version() > addStatus.Version() {
+			first, second = second, first
+		}
+		if applied[0] != first || applied[1] != second {
+			t.Errorf("Expected the migrations in version order, got %v", applied)
+		}
+	})
+
+	t.Run("Test Status Lists Tenant Migrations", func(t *testing.T) {
+		tenants = append(tenants, "umbrella")
+		status, err := mm.Status()
+		if err != nil {
+			t.Fatalf("Failed to get status: %v", err)
+		}
+		if len(status.Pending) != 2 || !strings.HasSuffix(status.Pending[0].Description, "(tenant umbrella)") {
+			t.Errorf("Expected the new tenant's migrations to be pending, got %+v", status.Pending)
+		}
+	})
+
+	t.Run("Test Tenants Are Required", func(t *testing.T) {
+		unconfigured := NewMigrationManager(nil, WithStore(NewMemoryStore()))
+		unconfigured.Register(createOrders)
+		if err := unconfigured.RunMigrations(); err == nil || !strings.Contains(err.Error(), "WithTenants") {
+			t.Errorf("Expected templated migrations to require WithTenants, got %v", err)
+		}
+	})
+
+	t.Run("Test Invalid Tenants Are Rejected", func(t *testing.T) {
+		invalid := NewMigrationManager(nil, WithStore(NewMemoryStore()), WithTenants(StaticTenants("Acme Corp")))
+		invalid.Register(createOrders)
+		if err := invalid.RunMigrations(); err == nil || !strings.Contains(err.Error(), "invalid tenant") {
+			t.Errorf("Expected an invalid tenant to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("Test Guards Wrap Every Tenant", func(t *testing.T) {
+		var calls []string
+		guard := func(name string) Guard {
+			return func(up func(client *elasticsearch.Client) error) func(client *elasticsearch.Client) error {
+				return func(client *elasticsearch.Client) error {
+					calls = append(calls, name)
+					return up(client)
+				}
+			}
+		}
+		guarded := ForEachTenant("Add region field", "orders_{tenant}", func(client *elasticsearch.Client, index string) error {
+			calls = append(calls, "up "+index)
+			return nil
+		}).WithGuard(guard("a")).WithGuard(guard("b"), guard("c"))
+
+		mm := NewMigrationManager(nil, WithStore(NewMemoryStore()), WithTenants(StaticTenants("acme", "globex")))
+		mm.Register(guarded)
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if got := strings.Join(calls, ","); got != "b,c,a,up orders_acme,b,c,a,up orders_globex" &&
+			got != "b,c,a,up orders_globex,b,c,a,up orders_acme" {
+			t.Errorf("Expected the guards around the function of each tenant, got %v", calls)
+		}
+	})
+}
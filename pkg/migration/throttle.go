@@ -0,0 +1,89 @@
+package migration
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Throttle limits the load reindex, update by query and delete by query
+// tasks put on the cluster, so migrations running against a busy cluster
+// leave room for live traffic
+type Throttle struct {
+	// RequestsPerSecond bounds the documents per second each task processes;
+	// zero leaves requests unthrottled
+	RequestsPerSecond float64
+	// Slices is the number of slices tasks run in parallel, or "auto"; empty
+	// keeps the slices of the request
+	Slices string
+}
+
+// WithThrottle throttles the reindex, update by query and delete by query
+// tasks of every migration, overriding the parameters they submit. A
+// migration throttled with Migration.WithThrottle uses the fields it sets
+// instead.
+func WithThrottle(throttle Throttle) Option {
+	return func(mm *MigrationManager) {
+		mm.throttle = throttle
+	}
+}
+
+// WithThrottle throttles the reindex, update by query and delete by query
+// tasks of the migration, e.g. an UpdateByQuery or a RebuildIndex, taking
+// precedence over the throttle of the manager
+func (m Migration) WithThrottle(throttle Throttle) Migration {
+	m.throttle = &throttle
+	return m
+}
+
+// throttleFor returns the throttle of the manager with the fields the
+// migration sets replaced
+func (mm *MigrationManager) throttleFor(migration Migration) Throttle {
+	throttle := mm.throttle
+	if migration.throttle != nil {
+		if migration.throttle.RequestsPerSecond != 0 {
+			throttle.RequestsPerSecond = migration.throttle.RequestsPerSecond
+		}
+		if migration.throttle.Slices != "" {
+			throttle.Slices = migration.throttle.Slices
+		}
+	}
+	return throttle
+}
+
+// throttledEndpoints are the endpoints of the tasks a Throttle applies to
+var throttledEndpoints = []string{"/_reindex", "/_update_by_query", "/_delete_by_query"}
+
+// throttleTransport sets the requests_per_second and slices parameters of
+// reindex, update by query and delete by query requests
+type throttleTransport struct {
+	next     esapi.Transport
+	throttle Throttle
+}
+
+func (t *throttleTransport) Perform(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !throttled(req.URL.Path) {
+		return t.next.Perform(req)
+	}
+	query := req.URL.Query()
+	if t.throttle.RequestsPerSecond != 0 {
+		query.Set("requests_per_second", strconv.FormatFloat(t.throttle.RequestsPerSecond, 'f', -1, 64))
+	}
+	if t.throttle.Slices != "" {
+		query.Set("slices", t.throttle.Slices)
+	}
+	req = req.Clone(req.Context())
+	req.URL.RawQuery = query.Encode()
+	return t.next.Perform(req)
+}
+
+func throttled(path string) bool {
+	for _, endpoint := range throttledEndpoints {
+		if strings.HasSuffix(path, endpoint) {
+			return true
+		}
+	}
+	return false
+}
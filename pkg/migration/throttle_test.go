@@ -0,0 +1,45 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	interval := taskPollInterval
+	taskPollInterval = time.Millisecond
+	defer func() { taskPollInterval = interval }()
+
+	run := func(t *testing.T, migration Migration, opts ...Option) string {
+		t.Helper()
+		transport := &taskTransport{polls: 1, response: `{"total": 1, "updated": 1}`}
+		mm := NewMigrationManager(ClientFromTransport(transport), append([]Option{WithStore(NewMemoryStore())}, opts...)...)
+		mm.Register(migration)
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		return transport.submitted
+	}
+	update := UpdateByQuery("articles", `{"match_all": {}}`, "ctx._source.views = 0")
+
+	t.Run("Test Manager Throttle", func(t *testing.T) {
+		submitted := run(t, update, WithThrottle(Throttle{RequestsPerSecond: 500}))
+		if !strings.Contains(submitted, "requests_per_second=500") || !strings.Contains(submitted, "slices=auto") {
+			t.Errorf("Expected 500 requests per second and the default slices, got %s", submitted)
+		}
+	})
+
+	t.Run("Test Migration Throttle Takes Precedence", func(t *testing.T) {
+		submitted := run(t, update.WithThrottle(Throttle{Slices: "4"}), WithThrottle(Throttle{RequestsPerSecond: 500, Slices: "auto"}))
+		if !strings.Contains(submitted, "requests_per_second=500") || !strings.Contains(submitted, "slices=4") {
+			t.Errorf("Expected the slices of the migration and the rate of the manager, got %s", submitted)
+		}
+	})
+
+	t.Run("Test Unthrottled", func(t *testing.T) {
+		if submitted := run(t, update); strings.Contains(submitted, "requests_per_second") {
+			t.Errorf("Expected no throttle, got %s", submitted)
+		}
+	})
+}
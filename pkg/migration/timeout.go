@@ -0,0 +1,109 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ErrMigrationTimeout is returned when a migration runs longer than its
+// timeout
+var ErrMigrationTimeout = errors.New("migration timed out")
+
+// WithTimeout bounds how long the migration may run. Requests still in flight
+// when it expires are cancelled and the run stops with ErrMigrationTimeout.
+func (m Migration) WithTimeout(timeout time.Duration) Migration {
+	m.timeout = timeout
+	return m
+}
+
+// runWithDeadline runs handle under the migration timeout and the run context.
+// The migration is handed a context that apply attaches to its requests;
+// when it expires the migration is abandoned rather than waited for.
+func runWithDeadline(ctx context.Context, migration Migration, handle Handler) error {
+	runCtx := ctx
+	if migration.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, migration.timeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return handle(migration)
+	}
+	migration.ctx = ctx
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handle(migration)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if runCtx.Err() != nil {
+			return fmt.Errorf("run deadline exceeded: %w", runCtx.Err())
+		}
+		return fmt.Errorf("%w after %s", ErrMigrationTimeout, migration.timeout)
+	}
+}
+
+// contextTransport cancels requests when ctx is done
+type contextTransport struct {
+	next esapi.Transport
+	ctx  context.Context
+}
+
+// detachedKey marks requests cleaning up after a migration, such as task
+// cancellations, which are still sent once the migration's context is done
+type detachedKey struct{}
+
+// detached returns a context for such requests, not cancelled with ctx
+func detached(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), detachedKey{}, true)
+}
+
+func (t *contextTransport) Perform(req *http.Request) (*http.Response, error) {
+	if req.Context().Value(detachedKey{}) != nil {
+		return t.next.Perform(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	// cancelled with t.ctx, once the migration has finished or timed out,
+	// so response bodies can still be read after Perform returns
+	stop := context.AfterFunc(t.ctx, cancel)
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			stop()
+			cancel()
+		})
+	}
+
+	res, err := t.next.Perform(req.WithContext(ctx))
+	if err != nil || res == nil || res.Body == nil {
+		release()
+		return res, err
+	}
+	// released with the body, so a long-lived run context does not collect
+	// a registration for every request
+	res.Body = &releaseBody{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// releaseBody calls release once the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
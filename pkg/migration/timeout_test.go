@@ -0,0 +1,171 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// hangingTransport blocks every request until it is cancelled
+type hangingTransport struct{}
+
+func (hangingTransport) Perform(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestTimeouts(t *testing.T) {
+	slowReindex := func(client *elasticsearch.Client) error {
+		res, err := client.Reindex(strings.NewReader(`{"source": {"index": "a"}, "dest": {"index": "b"}}`))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	t.Run("Test Migration Timeout Aborts And Records Failure", func(t *testing.T) {
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(hangingTransport{}), WithStore(store))
+		mm.Register(NewMigration("Slow reindex", slowReindex).WithTimeout(20 * time.Millisecond))
+
+		err := mm.RunMigrations()
+		if !errors.Is(err, ErrMigrationTimeout) {
+			t.Fatalf("Expected a timeout error, got %v", err)
+		}
+
+		failures, _ := store.GetFailures()
+		if len(failures) != 1 || !strings.Contains(failures[0].Error, "timed out") {
+			t.Errorf("Expected the timeout to be recorded, got %v", failures)
+		}
+		if records, _ := store.GetApplied(); len(records) != 0 {
+			t.Errorf("Expected no applied records, got %v", records)
+		}
+	})
+
+	t.Run("Test Run Deadline Stops Remaining Migrations", func(t *testing.T) {
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(hangingTransport{}), WithStore(store))
+
+		ran := false
+		slow := NewMigration("Slow reindex", slowReindex)
+		mm.Register(slow)
+		// migrations run in version order, so pick a description sorting after
+		for i := 0; ; i++ {
+			next := NewMigration(fmt.Sprintf("Never reached %d", i), func(client *elasticsearch.Client) error {
+				ran = true
+				return nil
+			})
+			if next.Version() > slow.Version() {
+				mm.Register(next)
+				break
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := mm.RunMigrationsContext(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected a deadline error, got %v", err)
+		}
+		if ran {
+			t.Error("Expected no migration to run after the deadline")
+		}
+	})
+	t.Run("Test Abandoned Migration Does Not Race The Run", func(t *testing.T) {
+		transport := &routeTransport{}
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store), WithFailureMode(ContinueOnError))
+
+		// the slow migration ignores its context and keeps writing after it
+		// was abandoned, while the next migration runs
+		finished := make(chan struct{})
+		slow := NewMigration("Outlives its timeout", func(client *elasticsearch.Client) error {
+			defer close(finished)
+			time.Sleep(50 * time.Millisecond)
+			res, err := client.Indices.Create("late")
+			if err == nil {
+				res.Body.Close()
+			}
+			return err
+		}).WithTimeout(10 * time.Millisecond)
+		mm.Register(slow)
+		for i := 0; ; i++ {
+			next := NewMigration(fmt.Sprintf("Runs after the timeout %d", i), func(client *elasticsearch.Client) error {
+				time.Sleep(100 * time.Millisecond)
+				res, err := client.Indices.Create("articles")
+				if err == nil {
+					res.Body.Close()
+				}
+				return err
+			})
+			if next.Version() > slow.Version() {
+				mm.Register(next)
+				break
+			}
+		}
+
+		if err := mm.RunMigrations(); !errors.Is(err, ErrMigrationTimeout) {
+			t.Fatalf("Expected a timeout error, got %v", err)
+		}
+		<-finished
+		records, _ := store.GetApplied()
+		if len(records) != 1 || strings.Join(records[0].Indices, ",") != "articles" {
+			t.Errorf("Expected the next migration to be recorded with its index, got %+v", records)
+		}
+	})
+
+	t.Run("Test Request Contexts Are Released", func(t *testing.T) {
+		runCtx, cancelRun := context.WithCancel(context.Background())
+		defer cancelRun()
+
+		var requestCtx context.Context
+		var fail error
+		transport := &contextTransport{ctx: runCtx, next: transportFunc(func(req *http.Request) (*http.Response, error) {
+			requestCtx = req.Context()
+			if fail != nil {
+				return nil, fail
+			}
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		})}
+
+		res, err := transport.Perform(httptest.NewRequest(http.MethodGet, "/articles", nil))
+		if err != nil {
+			t.Fatalf("Failed to perform the request: %v", err)
+		}
+		if requestCtx.Err() != nil {
+			t.Fatal("Expected the request context to stay open until the body is closed")
+		}
+		res.Body.Close()
+		if requestCtx.Err() == nil {
+			t.Error("Expected closing the body to release the request context")
+		}
+
+		fail = errors.New("connection refused")
+		if _, err := transport.Perform(httptest.NewRequest(http.MethodGet, "/articles", nil)); err == nil {
+			t.Fatal("Expected the request to fail")
+		}
+		if requestCtx.Err() == nil {
+			t.Error("Expected a failed request to release its context")
+		}
+		if runCtx.Err() != nil {
+			t.Error("Expected the run context to be unaffected")
+		}
+	})
+}
+
+// transportFunc adapts a function to esapi.Transport
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) Perform(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,89 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans the manager starts
+const tracerName = "github.com/punitsu/elasticmate/pkg/migration"
+
+// WithTracerProvider wraps each migration and each request it sends in
+// OpenTelemetry spans. Migration spans are children of the span in the
+// context passed to RunMigrationsContext, such as application startup.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(mm *MigrationManager) {
+		mm.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// startSpan starts the span of a migration being applied. Without a tracer
+// it returns ctx and a nil span.
+func (mm *MigrationManager) startSpan(ctx context.Context, migration Migration, runID string) (context.Context, trace.Span) {
+	if mm.tracer == nil {
+		return ctx, nil
+	}
+	return mm.tracer.Start(ctx, "migration "+migration.Version(),
+		trace.WithAttributes(
+			attribute.String("migration.version", migration.Version()),
+			attribute.String("migration.description", migration.Description),
+			attribute.String("migration.run_id", runID),
+			attribute.Bool("migration.destructive", migration.IsDestructive()),
+		),
+	)
+}
+
+// endSpan ends the span of a migration with the outcome of err
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrSkipMigration):
+		span.SetAttributes(attribute.Bool("migration.skipped", true))
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingTransport starts a client span for each request, as a child of the
+// span in ctx
+type tracingTransport struct {
+	next   esapi.Transport
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+func (t *tracingTransport) Perform(req *http.Request) (*http.Response, error) {
+	_, span := t.tracer.Start(t.ctx, "elasticsearch "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "elasticsearch"),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	defer span.End()
+
+	// instrumentation of the underlying client nests its spans under this one
+	res, err := t.next.Perform(req.WithContext(trace.ContextWithSpan(req.Context(), span)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return res, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	// existence checks answer 404 without anything having failed
+	if res.StatusCode >= 400 && !(req.Method == http.MethodHead && res.StatusCode == 404) {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	return res, nil
+}
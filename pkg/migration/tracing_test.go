@@ -0,0 +1,114 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingProvider keeps the spans started by its tracers
+type recordingProvider struct {
+	embedded.TracerProvider
+	spans []*recordedSpan
+}
+
+func (p *recordingProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return &recordingTracer{provider: p}
+}
+
+type recordingTracer struct {
+	embedded.Tracer
+	provider *recordingProvider
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent, _ := trace.SpanFromContext(ctx).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attributes: map[attribute.Key]attribute.Value{}}
+	config := trace.NewSpanStartConfig(opts...)
+	span.SetAttributes(config.Attributes()...)
+	t.provider.spans = append(t.provider.spans, span)
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedSpan struct {
+	noop.Span
+	name       string
+	parent     *recordedSpan
+	attributes map[attribute.Key]attribute.Value
+	status     codes.Code
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, attr := range kv {
+		s.attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, description string) { s.status = code }
+func (s *recordedSpan) End(options ...trace.SpanEndOption)            { s.ended = true }
+
+func TestTracing(t *testing.T) {
+	provider := &recordingProvider{}
+	tracer := provider.Tracer("application")
+	ctx, startup := tracer.Start(context.Background(), "startup")
+
+	transport := &routeTransport{}
+	mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithTracerProvider(provider))
+	mm.Register(NewMigration("Create articles index", func(client *elasticsearch.Client) error {
+		res, err := client.Indices.Create("articles")
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}))
+	mm.Register(NewMigration("Broken migration", func(client *elasticsearch.Client) error {
+		return errors.New("boom")
+	}))
+	mm.RunMigrationsContext(ctx)
+
+	migrations := map[string]*recordedSpan{}
+	var requests []*recordedSpan
+	for _, span := range provider.spans {
+		switch {
+		case strings.HasPrefix(span.name, "migration "):
+			migrations[span.attributes["migration.description"].AsString()] = span
+		case strings.HasPrefix(span.name, "elasticsearch "):
+			requests = append(requests, span)
+		}
+	}
+
+	t.Run("Test Migration Spans", func(t *testing.T) {
+		created := migrations["Create articles index"]
+		if created == nil || created.parent != startup.(*recordedSpan) || !created.ended {
+			t.Fatalf("Expected an ended migration span under startup, got %+v", created)
+		}
+		if version := created.attributes["migration.version"].AsString(); version == "" {
+			t.Error("Expected the migration version attribute")
+		}
+		if broken := migrations["Broken migration"]; broken == nil || broken.status != codes.Error {
+			t.Errorf("Expected the failed migration span to have an error status, got %+v", broken)
+		}
+	})
+
+	t.Run("Test Request Spans", func(t *testing.T) {
+		if len(requests) != 1 {
+			t.Fatalf("Expected 1 request span, got %d", len(requests))
+		}
+		request := requests[0]
+		if request.parent != migrations["Create articles index"] {
+			t.Error("Expected the request span to be a child of its migration span")
+		}
+		if request.attributes["url.path"].AsString() != "/articles" || request.attributes["http.response.status_code"].AsInt64() != 200 {
+			t.Errorf("Expected request attributes, got %v", request.attributes)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package migration_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestNewTypedMigration(t *testing.T) {
+	t.Run("Test Typed Migration Runs Against The Typed Client", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		store := migration.NewMemoryStore()
+		mm := es.Manager(migration.WithStore(store))
+		create := migration.NewTypedMigration("Create articles index", func(client *elasticsearch.TypedClient) error {
+			_, err := client.Indices.Create("articles").Do(context.Background())
+			return err
+		})
+		mm.Register(create)
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		if !es.IndexExists("articles") {
+			t.Error("Expected the typed migration to create the index")
+		}
+		records, _ := store.GetApplied()
+		if len(records) != 1 || records[0].Version != create.Version() || records[0].Description != "Create articles index" {
+			t.Errorf("Expected the typed migration to be recorded, got %+v", records)
+		}
+	})
+
+	t.Run("Test Typed Versions Depend On The Description", func(t *testing.T) {
+		up := func(client *elasticsearch.TypedClient) error { return nil }
+		first := migration.NewTypedMigration("Create articles index", up)
+		if first.Version() == "" || first.Version() == migration.NewTypedMigration("Create users index", up).Version() {
+			t.Errorf("Expected distinct versions for distinct descriptions, got %s", first.Version())
+		}
+		if first.Version() != migration.NewTypedMigration("Create articles index", up).Version() {
+			t.Error("Expected the version to be stable")
+		}
+	})
+}
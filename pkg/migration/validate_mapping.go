@@ -0,0 +1,228 @@
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// validationIndexPrefix names the throwaway indices mappings are validated in
+const validationIndexPrefix = "elasticmate-validate-"
+
+// WithMappingValidation makes RunMigrations validate the mappings of pending
+// migrations against the cluster, see ValidateMappings, before applying any
+func WithMappingValidation() Option {
+	return func(mm *MigrationManager) {
+		mm.validateMappings = true
+	}
+}
+
+// ValidateMappings sends the mappings declared by pending migrations, in the
+// order they would be applied, to throwaway copies of their indices so that
+// invalid field types, unknown analyzers and conflicts with existing fields
+// are reported with the version of the offending migration before anything
+// is applied. A copy starts from the live mapping and analysis settings of
+// its index, when it exists. Migrations without a declared mapping, such as
+// migrations generated from desired state, are not validated.
+func (mm *MigrationManager) ValidateMappings() error {
+	applied, err := mm.GetAppliedMigrations()
+	if err != nil {
+		return err
+	}
+	migrations, err := mm.registered()
+	if err != nil {
+		return err
+	}
+	sorted := append([]Migration{}, migrations...)
+	if err := sortMigrations(sorted); err != nil {
+		return err
+	}
+	return mm.validatePendingMappings(sorted, applied)
+}
+
+func (mm *MigrationManager) validatePendingMappings(migrations []Migration, applied map[string]bool) error {
+	v := &mappingValidator{client: mm.client(), prefix: validationIndexPrefix + strings.ToLower(newRunID()) + "-", copies: map[string]string{}}
+	defer v.cleanup(mm)
+
+	for _, migration := range migrations {
+		if applied[migration.Version()] {
+			continue
+		}
+		for _, desired := range migration.desired {
+			if desired.raw == "" {
+				continue
+			}
+			if err := v.validate(desired); err != nil {
+				return fmt.Errorf("migration %s: mapping of %s is invalid: %w", migration.Version(), desired.index, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mappingValidator keeps a throwaway copy of every index a pending migration
+// maps, so later migrations are validated against the fields of earlier ones
+type mappingValidator struct {
+	client *elasticsearch.Client
+	prefix string
+	// copies maps each index to its throwaway copy
+	copies map[string]string
+}
+
+func (v *mappingValidator) validate(desired desiredMapping) error {
+	if throwaway, ok := v.copies[desired.index]; ok {
+		return v.putMapping(throwaway, desired.raw)
+	}
+
+	throwaway := fmt.Sprintf("%s%d", v.prefix, len(v.copies))
+	live, analysis, found, err := v.liveIndex(desired.index)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the migration creates the index; validate it as created
+		analysis = declaredAnalysis(desired.settings)
+		live = json.RawMessage(desired.raw)
+	}
+
+	settings := map[string]interface{}{
+		"index.number_of_shards":   1,
+		"index.number_of_replicas": 0,
+		"index.hidden":             true,
+	}
+	if analysis != nil {
+		settings["analysis"] = analysis
+	}
+	body, err := json.Marshal(map[string]interface{}{"settings": settings, "mappings": live})
+	if err != nil {
+		return fmt.Errorf("error encoding validation index: %w", err)
+	}
+
+	res, err := v.client.Indices.Create(throwaway, v.client.Indices.Create.WithBody(strings.NewReader(string(body))))
+	if err != nil {
+		return fmt.Errorf("error creating validation index: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s", errorReason(res))
+	}
+	v.copies[desired.index] = throwaway
+
+	if !found {
+		return nil
+	}
+	return v.putMapping(throwaway, desired.raw)
+}
+
+func (v *mappingValidator) putMapping(index, mapping string) error {
+	res, err := v.client.Indices.PutMapping([]string{index}, strings.NewReader(mapping))
+	if err != nil {
+		return fmt.Errorf("error updating validation index: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s", errorReason(res))
+	}
+	return nil
+}
+
+// liveIndex returns the mapping and analysis settings of index, reporting
+// whether it exists
+func (v *mappingValidator) liveIndex(index string) (json.RawMessage, interface{}, bool, error) {
+	res, err := v.client.Indices.GetMapping(v.client.Indices.GetMapping.WithIndex(index))
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error getting mapping of %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil, false, nil
+	}
+	if res.IsError() {
+		return nil, nil, false, fmt.Errorf("error getting mapping of %s: %s", index, res.String())
+	}
+
+	var mappings map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&mappings); err != nil {
+		return nil, nil, false, fmt.Errorf("error parsing mapping of %s: %w", index, err)
+	}
+	var mapping json.RawMessage
+	for _, m := range mappings {
+		// an alias resolves to its indices, which share the mapping
+		mapping = m.Mappings
+		break
+	}
+
+	settingsRes, err := v.client.Indices.GetSettings(
+		v.client.Indices.GetSettings.WithIndex(index),
+		v.client.Indices.GetSettings.WithName("index.analysis*"),
+	)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error getting settings of %s: %w", index, err)
+	}
+	defer settingsRes.Body.Close()
+	if settingsRes.IsError() {
+		return nil, nil, false, fmt.Errorf("error getting settings of %s: %s", index, settingsRes.String())
+	}
+	var settings map[string]struct {
+		Settings struct {
+			Index struct {
+				Analysis interface{} `json:"analysis"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(settingsRes.Body).Decode(&settings); err != nil {
+		return nil, nil, false, fmt.Errorf("error parsing settings of %s: %w", index, err)
+	}
+	var analysis interface{}
+	for _, s := range settings {
+		analysis = s.Settings.Index.Analysis
+		break
+	}
+	return mapping, analysis, true, nil
+}
+
+// declaredAnalysis returns the analysis settings declared to create an index,
+// given as either {"analysis": ...} or {"index": {"analysis": ...}}
+func declaredAnalysis(settings map[string]interface{}) interface{} {
+	if analysis, ok := settings["analysis"]; ok {
+		return analysis
+	}
+	if index, ok := settings["index"].(map[string]interface{}); ok {
+		return index["analysis"]
+	}
+	return nil
+}
+
+func (v *mappingValidator) cleanup(mm *MigrationManager) {
+	for _, throwaway := range v.copies {
+		res, err := v.client.Indices.Delete([]string{throwaway})
+		if err != nil {
+			mm.logf("Failed to delete validation index %s: %v", throwaway, err)
+			continue
+		}
+		res.Body.Close()
+		if res.IsError() {
+			mm.logf("Failed to delete validation index %s: %s", throwaway, res.String())
+		}
+	}
+}
+
+// errorReason returns the reason of an Elasticsearch error response, e.g.
+// "No handler for type [txt] declared on field [title]"
+func errorReason(res *esapi.Response) string {
+	var body struct {
+		Error struct {
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Error.Reason == "" {
+		return res.Status()
+	}
+	return body.Error.Reason
+}
@@ -0,0 +1,92 @@
+package migration
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// validationTransport stands in for a cluster with an articles index,
+// rejecting mappings with unknown field types
+type validationTransport struct {
+	requests []string
+}
+
+func (v *validationTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body string
+	if req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+	}
+	v.requests = append(v.requests, req.Method+" "+req.URL.Path)
+
+	status, response := 200, `{}`
+	switch {
+	case strings.Contains(body, `"type":"txt"`) || strings.Contains(body, `"type": "txt"`):
+		status, response = 400, `{"error": {"type": "mapper_parsing_exception", "reason": "No handler for type [txt] declared on field [summary]"}}`
+	case req.Method == http.MethodGet && req.URL.Path == "/articles/_mapping":
+		response = `{"articles": {"mappings": {"properties": {"title": {"type": "text", "analyzer": "folding"}}}}}`
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/articles/_settings"):
+		response = `{"articles": {"settings": {"index": {"analysis": {"analyzer": {"folding": {"tokenizer": "standard"}}}}}}}`
+	case req.Method == http.MethodGet:
+		status, response = 404, `{"error": {"type": "index_not_found_exception", "reason": "no such index"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"X-Elastic-Product": []string{"Elasticsearch"}},
+		Body:       io.NopCloser(strings.NewReader(response)),
+	}, nil
+}
+
+func (v *validationTransport) sent(prefix string) []string {
+	var sent []string
+	for _, request := range v.requests {
+		if strings.HasPrefix(request, prefix) {
+			sent = append(sent, request)
+		}
+	}
+	return sent
+}
+
+func TestValidateMappings(t *testing.T) {
+	t.Run("Test Valid Mappings Pass", func(t *testing.T) {
+		transport := &validationTransport{}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()))
+		mm.Register(PutMapping("Add summary to articles", "articles", `{"properties": {"summary": {"type": "text"}}}`))
+		mm.Register(PutMapping("Create comments index", "comments", `{"properties": {"body": {"type": "text"}}}`))
+
+		if err := mm.ValidateMappings(); err != nil {
+			t.Fatalf("Expected the mappings to be valid, got %v", err)
+		}
+		if created := transport.sent("PUT /" + validationIndexPrefix); len(created) < 2 {
+			t.Errorf("Expected a throwaway index per mapped index, got %v", transport.requests)
+		}
+		if deleted := transport.sent("DELETE /" + validationIndexPrefix); len(deleted) != 2 {
+			t.Errorf("Expected the throwaway indices to be deleted, got %v", transport.requests)
+		}
+		for _, request := range transport.requests {
+			if request == "PUT /articles/_mapping" || request == "PUT /comments" {
+				t.Errorf("Expected the real indices to be left alone, got %s", request)
+			}
+		}
+	})
+
+	t.Run("Test Invalid Mapping Names The Migration", func(t *testing.T) {
+		transport := &validationTransport{}
+		mm := NewMigrationManager(ClientFromTransport(transport), WithStore(NewMemoryStore()), WithMappingValidation())
+		invalid := PutMapping("Add summary to articles", "articles", `{"properties": {"summary": {"type": "txt"}}}`)
+		mm.Register(invalid)
+
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), invalid.Version()) || !strings.Contains(err.Error(), "No handler for type [txt]") {
+			t.Fatalf("Expected the error to name the migration and the reason, got %v", err)
+		}
+		if applied, _ := mm.GetAppliedMigrations(); len(applied) != 0 {
+			t.Errorf("Expected nothing to be applied, got %v", applied)
+		}
+		if deleted := transport.sent("DELETE /" + validationIndexPrefix); len(deleted) != 1 {
+			t.Errorf("Expected the throwaway index to be deleted, got %v", transport.requests)
+		}
+	})
+}
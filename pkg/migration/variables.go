@@ -0,0 +1,171 @@
+package migration
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Variables resolves ${NAME} placeholders in the index, body, query and script
+// of declared migrations, so one set of files serves every environment:
+//
+//	body:
+//	  index:
+//	    number_of_replicas: ${REPLICAS}
+//
+// A value that is a single placeholder takes the type of the resolved YAML
+// scalar, so REPLICAS=2 sets a number. Descriptions are never substituted so
+// versions stay the same across environments. ${secret:REF} placeholders are
+// resolved with Secrets and always fail when they do not resolve; Plan shows
+// them as written.
+type Variables struct {
+	// Values take precedence over the environment
+	Values map[string]string
+	// Env resolves placeholders missing from Values from environment variables
+	Env bool
+	// Strict fails on placeholders that do not resolve; otherwise they are
+	// left as written
+	Strict bool
+	// Secrets resolves ${secret:REF} placeholders
+	Secrets SecretProvider
+}
+
+// WithVariables resolves placeholders in the migrations RegisterFS loads
+func WithVariables(vars Variables) Option {
+	return func(mm *MigrationManager) {
+		mm.variables = &vars
+	}
+}
+
+// WithSecrets resolves ${secret:REF} placeholders in the migrations
+// RegisterFS loads with provider, taking precedence over Variables.Secrets
+func WithSecrets(provider SecretProvider) Option {
+	return func(mm *MigrationManager) {
+		mm.secrets = provider
+	}
+}
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func (v Variables) lookup(name string) (string, bool) {
+	if value, ok := v.Values[name]; ok {
+		return value, true
+	}
+	if v.Env {
+		return os.LookupEnv(name)
+	}
+	return "", false
+}
+
+// LoadFSVariables reads the migrations declared in fsys like LoadFS,
+// resolving their placeholders with vars
+func LoadFSVariables(fsys fs.FS, vars Variables) ([]Migration, error) {
+	return loadFS(fsys, func(d Declaration) (Declaration, error) {
+		return d.Resolve(vars)
+	})
+}
+
+// Resolve returns the declaration with its placeholders resolved
+func (d Declaration) Resolve(vars Variables) (Declaration, error) {
+	r := resolver{vars: vars}
+	d.Index = r.text(d.Index)
+	d.Script = r.text(d.Script)
+	if d.Body != nil {
+		d.Body = r.value(d.Body).(map[string]interface{})
+	}
+	if d.Query != nil {
+		d.Query = r.value(d.Query).(map[string]interface{})
+	}
+
+	if r.err != nil {
+		return Declaration{}, fmt.Errorf("declaration %q: %w", d.Description, r.err)
+	}
+	d.secrets = r.secrets
+	if vars.Strict && len(r.missing) > 0 {
+		names := make([]string, 0, len(r.missing))
+		for name := range r.missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Declaration{}, fmt.Errorf("declaration %q has unresolved variables: %s", d.Description, strings.Join(names, ", "))
+	}
+	return d, nil
+}
+
+// resolver substitutes placeholders, noting those that do not resolve and
+// the placeholders of the secrets it resolved, by secret
+type resolver struct {
+	vars    Variables
+	missing map[string]bool
+	secrets map[string]string
+	err     error
+}
+
+func (r *resolver) text(s string) string {
+	s = secretPlaceholder.ReplaceAllStringFunc(s, func(match string) string {
+		if r.err != nil {
+			return match
+		}
+		secret, err := lookupSecret(r.vars.Secrets, secretPlaceholder.FindStringSubmatch(match)[1])
+		if err != nil {
+			r.err = err
+			return match
+		}
+		if r.secrets == nil {
+			r.secrets = map[string]string{}
+		}
+		r.secrets[secret] = match
+		return secret
+	})
+	return placeholder.ReplaceAllStringFunc(s, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		value, ok := r.vars.lookup(name)
+		if !ok {
+			if r.missing == nil {
+				r.missing = map[string]bool{}
+			}
+			r.missing[name] = true
+			return match
+		}
+		return value
+	})
+}
+
+// value resolves the placeholders in a decoded YAML value
+func (r *resolver) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			resolved[r.text(key)] = r.value(value)
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, value := range v {
+			resolved[i] = r.value(value)
+		}
+		return resolved
+	case string:
+		text := r.text(v)
+		if text == v || placeholder.FindString(v) != v {
+			return text
+		}
+		// a lone placeholder takes the type of its value, e.g. a number
+		var scalar interface{}
+		if err := yaml.Unmarshal([]byte(text), &scalar); err != nil {
+			return text
+		}
+		switch scalar.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			return text
+		}
+		return scalar
+	}
+	return v
+}
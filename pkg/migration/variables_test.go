@@ -0,0 +1,80 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestVariables(t *testing.T) {
+	settings := []byte(`description: Set replicas
+action: put_settings
+index: articles_${ENV}
+body:
+  index:
+    number_of_replicas: ${REPLICAS}
+    refresh_interval: "${REFRESH}s"
+`)
+
+	t.Run("Test Placeholders Are Resolved", func(t *testing.T) {
+		t.Setenv("REFRESH", "30")
+		migrations, err := LoadFSVariables(fstest.MapFS{"001_replicas.yaml": {Data: settings}}, Variables{
+			Values: map[string]string{"ENV": "prod", "REPLICAS": "2"},
+			Env:    true,
+			Strict: true,
+		})
+		if err != nil {
+			t.Fatalf("Failed to load migrations: %v", err)
+		}
+
+		plan, err := migrations[0].plan(func(string) (bool, error) { return true, nil })
+		if err != nil {
+			t.Fatalf("Failed to plan migration: %v", err)
+		}
+		if plan[0].Path != "/articles_prod/_settings" {
+			t.Errorf("Expected the index to be resolved, got %s", plan[0].Path)
+		}
+		if body := string(plan[0].Body); !strings.Contains(body, `"number_of_replicas":2`) || !strings.Contains(body, `"refresh_interval":"30s"`) {
+			t.Errorf("Expected a numeric replica count and a resolved interval, got %s", body)
+		}
+	})
+
+	t.Run("Test Versions Do Not Depend On Values", func(t *testing.T) {
+		load := func(env string) string {
+			migrations, err := LoadFSVariables(fstest.MapFS{"001_replicas.yaml": {Data: settings}}, Variables{
+				Values: map[string]string{"ENV": env, "REPLICAS": "1", "REFRESH": "1"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to load migrations: %v", err)
+			}
+			return migrations[0].Version()
+		}
+		if load("staging") != load("prod") {
+			t.Errorf("Expected the same version in every environment")
+		}
+	})
+
+	t.Run("Test Strict Mode Reports Unresolved Variables", func(t *testing.T) {
+		_, err := LoadFSVariables(fstest.MapFS{"001_replicas.yaml": {Data: settings}}, Variables{
+			Values: map[string]string{"ENV": "prod"},
+			Strict: true,
+		})
+		if err == nil || !strings.Contains(err.Error(), "REFRESH, REPLICAS") || !strings.Contains(err.Error(), "001_replicas.yaml") {
+			t.Errorf("Expected the unresolved variables and file to be named, got %v", err)
+		}
+	})
+
+	t.Run("Test Unresolved Variables Are Kept Without Strict Mode", func(t *testing.T) {
+		d, err := decodeDeclaration(settings)
+		if err != nil {
+			t.Fatalf("Failed to parse declaration: %v", err)
+		}
+		resolved, err := d.Resolve(Variables{})
+		if err != nil {
+			t.Fatalf("Failed to resolve declaration: %v", err)
+		}
+		if resolved.Index != "articles_${ENV}" {
+			t.Errorf("Expected the placeholder to be left as written, got %s", resolved.Index)
+		}
+	})
+}
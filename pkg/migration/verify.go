@@ -0,0 +1,130 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Verification checks a postcondition of an applied migration, for example
+// that a field it added is still mapped. It returns an error describing how
+// the cluster differs.
+type Verification func(ctx context.Context, client *elasticsearch.Client) error
+
+// WithVerify declares postconditions of the migration, checked by Verify
+// while it is applied
+func (m Migration) WithVerify(verifications ...Verification) Migration {
+	m.verifications = append(append([]Verification{}, m.verifications...), verifications...)
+	return m
+}
+
+// VerifyIndexExists returns a verification that index, or an alias of that
+// name, exists
+func VerifyIndexExists(index string) Verification {
+	return func(ctx context.Context, client *elasticsearch.Client) error {
+		exists, err := IndexExists(client, index)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("index %s does not exist", index)
+		}
+		return nil
+	}
+}
+
+// VerifyFieldExists returns a verification that index maps field; nested
+// fields use dotted paths such as "author.name"
+func VerifyFieldExists(index, field string) Verification {
+	return func(ctx context.Context, client *elasticsearch.Client) error {
+		exists, err := FieldExists(client, index, field)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("field %s is not mapped in %s", field, index)
+		}
+		return nil
+	}
+}
+
+// VerificationFailure is a postcondition of an applied migration that no
+// longer holds
+type VerificationFailure struct {
+	Version     string
+	Description string
+	Err         error
+}
+
+func (f VerificationFailure) String() string {
+	return fmt.Sprintf("migration %s (%s): %v", f.Version, f.Description, f.Err)
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	// Verified is the number of applied migrations whose postconditions were
+	// checked
+	Verified int
+	Failures []VerificationFailure
+}
+
+func (r *VerifyReport) String() string {
+	var b strings.Builder
+	for _, failure := range r.Failures {
+		fmt.Fprintf(&b, "FAIL %s\n", failure)
+	}
+	if len(r.Failures) > 0 {
+		fmt.Fprintln(&b)
+	}
+	fmt.Fprintf(&b, "Verify: %d of %d applied migrations failed verification.\n", len(r.Failures), r.Verified)
+	return b.String()
+}
+
+// Verify runs the verifications of every applied migration, in version
+// order, to confirm the cluster still matches the history. Every verification
+// runs; those failing are reported rather than returned as an error. Nothing
+// is written.
+func (mm *MigrationManager) Verify(ctx context.Context) (*VerifyReport, error) {
+	records, err := mm.store().GetApplied()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]bool, len(records))
+	for _, record := range records {
+		// migrations skipped by condition never ran, so nothing holds
+		applied[record.Version] = !record.Skipped
+	}
+	migrations, err := mm.registered()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version() < migrations[j].Version()
+	})
+
+	report := &VerifyReport{}
+	for _, migration := range migrations {
+		if !applied[migration.Version()] || len(migration.verifications) == 0 {
+			continue
+		}
+		report.Verified++
+		for _, verify := range migration.verifications {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := verify(ctx, mm.client()); err != nil {
+				report.Failures = append(report.Failures, VerificationFailure{
+					Version:     migration.Version(),
+					Description: migration.Description,
+					Err:         err,
+				})
+				mm.logf("Verification of migration %s failed: %v", migration.Version(), err)
+				break
+			}
+		}
+	}
+	return report, nil
+}
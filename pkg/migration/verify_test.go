@@ -0,0 +1,55 @@
+package migration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestVerify(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	tags := NewMigration("Add tags to articles", noop).WithVerify(VerifyFieldExists("articles", "tags"))
+	author := NewMigration("Add author to articles", noop).WithVerify(VerifyIndexExists("articles"), VerifyFieldExists("articles", "author"))
+	comments := NewMigration("Create comments index", noop).WithVerify(VerifyIndexExists("comments"))
+	pending := NewMigration("Create drafts index", noop).WithVerify(VerifyIndexExists("drafts"))
+
+	store := NewMemoryStore()
+	store.Record(MigrationRecord{Version: tags.Version()})
+	store.Record(MigrationRecord{Version: author.Version()})
+	store.Record(MigrationRecord{Version: comments.Version(), Skipped: true})
+
+	transport := &routeTransport{routes: map[string]string{
+		"HEAD /articles":                      ``,
+		"GET /articles/_mapping/field/tags":   `{"articles_v1": {"mappings": {"tags": {"full_name": "tags", "mapping": {"tags": {"type": "keyword"}}}}}}`,
+		"GET /articles/_mapping/field/author": `{"articles_v1": {"mappings": {}}}`,
+	}}
+	mm := NewMigrationManager(ClientFromTransport(transport), WithStore(store))
+	for _, migration := range []Migration{tags, author, comments, pending} {
+		mm.Register(migration)
+	}
+
+	report, err := mm.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+
+	t.Run("Test Applied Migrations Are Verified", func(t *testing.T) {
+		if report.Verified != 2 {
+			t.Errorf("Expected 2 migrations verified, got %d", report.Verified)
+		}
+		if transport.sent("HEAD /comments") || transport.sent("HEAD /drafts") {
+			t.Errorf("Expected skipped and pending migrations not to be verified, sent %v", transport.requests)
+		}
+	})
+
+	t.Run("Test Failed Postconditions Are Reported", func(t *testing.T) {
+		if len(report.Failures) != 1 {
+			t.Fatalf("Expected 1 failure, got %v", report.Failures)
+		}
+		failure := report.Failures[0]
+		if failure.Version != author.Version() || failure.Err.Error() != "field author is not mapped in articles" {
+			t.Errorf("Expected the author field missing, got %s", failure)
+		}
+	})
+}
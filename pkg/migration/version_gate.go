@@ -0,0 +1,60 @@
+package migration
+
+import (
+	"fmt"
+)
+
+// WithESVersions sets the cluster versions the migration is compatible with.
+// Either bound may be empty.
+func (m Migration) WithESVersions(min, max string) Migration {
+	m.MinESVersion = min
+	m.MaxESVersion = max
+	return m
+}
+
+// WithSkipIncompatible leaves migrations that are not compatible with the
+// cluster version pending instead of failing the run. Skipped migrations run
+// once the cluster is upgraded to a compatible version.
+func WithSkipIncompatible() Option {
+	return func(mm *MigrationManager) {
+		mm.skipIncompatible = true
+	}
+}
+
+func (m Migration) compatibleWith(version string) bool {
+	return versionInRange(version, m.MinESVersion, m.MaxESVersion)
+}
+
+// incompatible returns the cluster version by the version of each pending
+// migration that cannot run on it. The cluster is only queried when a pending
+// migration declares a version bound, and without WithSkipIncompatible the
+// first incompatible migration fails the run before any is applied.
+func (mm *MigrationManager) incompatible(migrations []Migration, applied map[string]bool) (map[string]string, error) {
+	var gated []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version()] && (migration.MinESVersion != "" || migration.MaxESVersion != "") {
+			gated = append(gated, migration)
+		}
+	}
+	if len(gated) == 0 {
+		return nil, nil
+	}
+
+	version, err := ClusterVersion(mm.client())
+	if err != nil {
+		return nil, err
+	}
+
+	incompatible := make(map[string]string)
+	for _, migration := range gated {
+		if migration.compatibleWith(version) {
+			continue
+		}
+		if !mm.skipIncompatible {
+			return nil, fmt.Errorf("migration %s requires Elasticsearch %s, cluster runs %s",
+				migration.Version(), versionRange(migration.MinESVersion, migration.MaxESVersion), version)
+		}
+		incompatible[migration.Version()] = version
+	}
+	return incompatible, nil
+}
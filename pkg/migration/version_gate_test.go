@@ -0,0 +1,53 @@
+package migration
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestVersionGating(t *testing.T) {
+	transport := &routeTransport{routes: map[string]string{"GET /": `{"version": {"number": "7.17.9"}}`}}
+
+	setup := func(opts ...Option) (*MigrationManager, *MemoryStore, map[string]bool) {
+		store := NewMemoryStore()
+		mm := NewMigrationManager(ClientFromTransport(transport), append([]Option{WithStore(store)}, opts...)...)
+
+		ran := map[string]bool{}
+		register := func(description, min, max string) {
+			mm.Register(NewMigration(description, func(client *elasticsearch.Client) error {
+				ran[description] = true
+				return nil
+			}).WithESVersions(min, max))
+		}
+		register("Legacy 7.x template", "7", "7")
+		register("Use subobjects", "8.3", "")
+		register("Any version", "", "")
+		return mm, store, ran
+	}
+
+	t.Run("Test Incompatible Migration Fails Before Running", func(t *testing.T) {
+		mm, _, ran := setup()
+		err := mm.RunMigrations()
+		if err == nil || !strings.Contains(err.Error(), "requires Elasticsearch 8.3 or later") {
+			t.Fatalf("Expected a version error, got %v", err)
+		}
+		if len(ran) != 0 {
+			t.Errorf("Expected no migration to run, got %v", ran)
+		}
+	})
+
+	t.Run("Test Incompatible Migration Is Skipped", func(t *testing.T) {
+		mm, store, ran := setup(WithSkipIncompatible())
+		if err := mm.RunMigrations(); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if !ran["Legacy 7.x template"] || !ran["Any version"] || ran["Use subobjects"] {
+			t.Errorf("Expected only compatible migrations to run, got %v", ran)
+		}
+		if records, _ := store.GetApplied(); len(records) != 2 {
+			t.Errorf("Expected the skipped migration to stay pending, got %d records", len(records))
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// VersionFunc derives the version of a registered migration. position is the
+// number of migrations registered before it.
+type VersionFunc func(m Migration, position int) string
+
+// WithVersionFunc derives the versions of registered migrations with fn
+// instead of hashing their function name and description, which changes when
+// code is refactored or renamed. Migrations given a version with WithVersion
+// keep it. Versions from fn are compared in natural order, so "10" follows
+// "9" and "v1.10.0" follows "v1.9.0".
+//
+// Changing the strategy changes the versions of applied migrations, which
+// are then pending again; adopt it in new projects or import the history
+// under the new versions.
+func WithVersionFunc(fn VersionFunc) Option {
+	return func(mm *MigrationManager) {
+		mm.versionFunc = fn
+		// migrations registered by earlier options
+		for i := range mm.Migrations {
+			mm.Migrations[i] = mm.stampVersion(mm.Migrations[i], i)
+		}
+	}
+}
+
+// WithVersion sets the version of the migration explicitly, e.g. a sequence
+// number or timestamp. Explicit versions are compared in natural order.
+func (m Migration) WithVersion(version string) Migration {
+	m.version = version
+	m.customVersion = true
+	m.fixedVersion = true
+	return m
+}
+
+// stampVersion sets the version of a migration registered at position with
+// the version func of the manager
+func (mm *MigrationManager) stampVersion(m Migration, position int) Migration {
+	if mm.versionFunc == nil || m.fixedVersion {
+		return m
+	}
+	m.version = mm.versionFunc(m, position)
+	m.customVersion = true
+	return m
+}
+
+// HashVersion derives the version from the function name and description of
+// the migration. It is the default.
+func HashVersion(m Migration, position int) string {
+	return m.computeVersion()
+}
+
+// SequentialVersion numbers migrations in registration order from 0001, so
+// migrations must only ever be appended
+func SequentialVersion(m Migration, position int) string {
+	return fmt.Sprintf("%04d", position+1)
+}
+
+// DescriptionPrefixVersion takes the version from the start of the
+// description, up to the first space, underscore or colon, e.g.
+// "20240515120000 Create articles index" or "v1.2.0: Add tags". Descriptions
+// without such a prefix are hashed like HashVersion.
+func DescriptionPrefixVersion(m Migration, position int) string {
+	end := strings.IndexAny(m.Description, " _:")
+	if end < 0 {
+		return m.computeVersion()
+	}
+	prefix := m.Description[:end]
+	if number := strings.TrimPrefix(prefix, "v"); number == "" || !isDigit(number[0]) {
+		return m.computeVersion()
+	}
+	return prefix
+}
+
+// FileNameVersion takes the version of declared migrations from the start of
+// their file name, up to the first underscore, e.g. "0003" for
+// migrations/0003_add_tags.yaml. Other migrations are hashed like
+// HashVersion.
+func FileNameVersion(m Migration, position int) string {
+	prefix, _, found := strings.Cut(path.Base(m.source), "_")
+	if m.source == "" || !found || prefix == "" {
+		return m.computeVersion()
+	}
+	return prefix
+}
+
+// ContentVersion hashes the description and the declared content of the
+// migration, such as its desired mappings and the content of a repeatable
+// migration, but not its function name, so moving or renaming code keeps the
+// version
+func ContentVersion(m Migration, position int) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(m.Description))
+	for _, desired := range m.desired {
+		hasher.Write([]byte(desired.index))
+		hasher.Write([]byte(desired.raw))
+	}
+	hasher.Write([]byte(m.checksum))
+	return hex.EncodeToString(hasher.Sum(nil))[:8]
+}
+
+// versionLess orders migrations by version. Versions set explicitly or by a
+// VersionFunc are compared in natural order, hashes as strings.
+func versionLess(a, b Migration) bool {
+	if a.customVersion && b.customVersion {
+		return naturalLess(a.Version(), b.Version())
+	}
+	return a.Version() < b.Version()
+}
+
+// naturalLess compares strings with runs of digits compared by value
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, ra := leadingDigits(a)
+			nb, rb := leadingDigits(b)
+			na, nb = strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingDigits splits s after its leading digits
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
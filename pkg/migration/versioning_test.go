@@ -0,0 +1,98 @@
+package migration
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestVersionFunc(t *testing.T) {
+	noop := func(client *elasticsearch.Client) error { return nil }
+	client := ClientFromTransport(&routeTransport{})
+
+	t.Run("Test Sequential Versions", func(t *testing.T) {
+		create := NewMigration("Create articles index", noop)
+		tags := NewMigration("Add tags to articles", noop)
+		// WithMigrations registers before WithVersionFunc is applied
+		mm := NewMigrationManager(client, WithMigrations(create, tags), WithVersionFunc(SequentialVersion))
+		mm.Register(NewMigration("Add author to articles", noop))
+
+		for i, expected := range []string{"0001", "0002", "0003"} {
+			if version := mm.Migrations[i].Version(); version != expected {
+				t.Errorf("Expected version %s for migration %d, got %s", expected, i, version)
+			}
+		}
+	})
+
+	t.Run("Test Explicit Versions Are Kept", func(t *testing.T) {
+		mm := NewMigrationManager(client, WithVersionFunc(SequentialVersion))
+		mm.Register(NewMigration("Create articles index", noop).WithVersion("20240515120000"))
+		if version := mm.Migrations[0].Version(); version != "20240515120000" {
+			t.Errorf("Expected the explicit version, got %s", version)
+		}
+	})
+
+	t.Run("Test Description Prefix Versions", func(t *testing.T) {
+		for description, expected := range map[string]string{
+			"v1.2.0: Add tags":                     "v1.2.0",
+			"20240515120000 Create articles index": "20240515120000",
+			"0003_add_author":                      "0003",
+		} {
+			if version := DescriptionPrefixVersion(NewMigration(description, noop), 0); version != expected {
+				t.Errorf("Expected version %s for %q, got %s", expected, description, version)
+			}
+		}
+		migration := NewMigration("Create articles index", noop)
+		if version := DescriptionPrefixVersion(migration, 0); version != migration.computeVersion() {
+			t.Errorf("Expected the hash without a prefix, got %s", version)
+		}
+	})
+
+	t.Run("Test File Name Versions", func(t *testing.T) {
+		migrations, err := LoadFS(fstest.MapFS{
+			"migrations/0003_add_tags.yaml": {Data: []byte("description: Add tags\naction: put_mapping\nindex: articles\nbody:\n  properties:\n    tags:\n      type: keyword\n")},
+		})
+		if err != nil {
+			t.Fatalf("Failed to load migrations: %v", err)
+		}
+		mm := NewMigrationManager(client, WithVersionFunc(FileNameVersion), WithMigrations(migrations...))
+		if version := mm.Migrations[0].Version(); version != "0003" {
+			t.Errorf("Expected version 0003, got %s", version)
+		}
+	})
+
+	t.Run("Test Content Versions Ignore The Function", func(t *testing.T) {
+		a := NewMigration("Create articles index", noop)
+		b := NewMigration("Create articles index", func(client *elasticsearch.Client) error { return nil })
+		if ContentVersion(a, 0) != ContentVersion(b, 1) {
+			t.Errorf("Expected the same content version, got %s and %s", ContentVersion(a, 0), ContentVersion(b, 1))
+		}
+		c := NewMigration("Create comments index", noop)
+		if ContentVersion(a, 0) == ContentVersion(c, 0) {
+			t.Errorf("Expected different content versions for different descriptions")
+		}
+	})
+
+	t.Run("Test Custom Versions Sort Naturally", func(t *testing.T) {
+		migrations := []Migration{
+			NewMigration("Ten", noop).WithVersion("v1.10.0"),
+			NewMigration("Nine", noop).WithVersion("v1.9.0"),
+			NewMigration("Second", noop).WithVersion("10"),
+			NewMigration("First", noop).WithVersion("9"),
+		}
+		if err := sortMigrations(migrations); err != nil {
+			t.Fatalf("Failed to sort migrations: %v", err)
+		}
+		var order []string
+		for _, migration := range migrations {
+			order = append(order, migration.Version())
+		}
+		expected := []string{"9", "10", "v1.9.0", "v1.10.0"}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Fatalf("Expected %v, got %v", expected, order)
+			}
+		}
+	})
+}
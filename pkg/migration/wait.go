@@ -0,0 +1,167 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Wait blocks until an asynchronous operation of Elasticsearch has settled,
+// such as shards being allocated after an index is created, and fails when
+// it has not within its timeout
+type Wait func(ctx context.Context, client *elasticsearch.Client) error
+
+// WithWait runs waits in order after the migration function succeeds, so
+// the next migration does not race ahead of what this one started. A wait
+// that times out fails the migration.
+func (m Migration) WithWait(waits ...Wait) Migration {
+	return m.WithGuard(func(up func(client *elasticsearch.Client) error) func(client *elasticsearch.Client) error {
+		return func(client *elasticsearch.Client) error {
+			if err := up(client); err != nil {
+				return err
+			}
+			for _, wait := range waits {
+				if err := wait(context.Background(), client); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	})
+}
+
+// WaitForClusterHealth returns a wait for the cluster to reach status,
+// "green", "yellow" or "red", or a better one
+func WaitForClusterHealth(status string, timeout time.Duration) Wait {
+	return WaitForIndexHealth("", status, timeout)
+}
+
+// WaitForIndexHealth returns a wait for index to reach status, or a better
+// one; "green" means every replica is allocated. The whole cluster is
+// waited for when index is empty.
+func WaitForIndexHealth(index, status string, timeout time.Duration) Wait {
+	return func(ctx context.Context, client *elasticsearch.Client) error {
+		what := "cluster"
+		if index != "" {
+			what = index
+		}
+		if _, ok := healthRank[status]; !ok {
+			return fmt.Errorf("unknown health status %q to wait for %s", status, what)
+		}
+		return poll(ctx, timeout, fmt.Sprintf("%s to be %s", what, status), func() (bool, string, error) {
+			current, err := healthStatus(ctx, client, index)
+			if err != nil {
+				return false, "", err
+			}
+			rank, ok := healthRank[current]
+			return ok && rank >= healthRank[status], current, nil
+		})
+	}
+}
+
+func healthStatus(ctx context.Context, client *elasticsearch.Client, index string) (string, error) {
+	opts := []func(*esapi.ClusterHealthRequest){client.Cluster.Health.WithContext(ctx)}
+	if index != "" {
+		opts = append(opts, client.Cluster.Health.WithIndex(index))
+	}
+	res, err := client.Cluster.Health(opts...)
+	if err != nil {
+		return "", fmt.Errorf("error reading health: %w", err)
+	}
+	defer res.Body.Close()
+	// a missing index answers 408 with a red status
+	if res.IsError() && res.StatusCode != 408 {
+		return "", fmt.Errorf("error reading health: %s", res.String())
+	}
+	var result struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing health: %w", err)
+	}
+	return result.Status, nil
+}
+
+// WaitForTasks returns a wait for every running task whose action matches
+// actions, e.g. "*reindex" or "indices:data/write/update/byquery", to
+// complete, such as one submitted without waiting for completion
+func WaitForTasks(actions string, timeout time.Duration) Wait {
+	return func(ctx context.Context, client *elasticsearch.Client) error {
+		return poll(ctx, timeout, fmt.Sprintf("%s tasks to complete", actions), func() (bool, string, error) {
+			running, err := runningTasks(ctx, client, actions)
+			if err != nil {
+				return false, "", err
+			}
+			return running == 0, fmt.Sprintf("%d running", running), nil
+		})
+	}
+}
+
+func runningTasks(ctx context.Context, client *elasticsearch.Client, actions string) (int, error) {
+	res, err := client.Tasks.List(
+		client.Tasks.List.WithContext(ctx),
+		client.Tasks.List.WithActions(actions),
+		client.Tasks.List.WithGroupBy("none"),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error listing %s tasks: %w", actions, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("error listing %s tasks: %s", actions, res.String())
+	}
+	var result struct {
+		Tasks []json.RawMessage `json:"tasks"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("error parsing %s tasks: %w", actions, err)
+	}
+	return len(result.Tasks), nil
+}
+
+// WaitForDocCount returns a wait for index to hold at least count
+// searchable documents, e.g. while an external process backfills it
+func WaitForDocCount(index string, count int64, timeout time.Duration) Wait {
+	return func(ctx context.Context, client *elasticsearch.Client) error {
+		return poll(ctx, timeout, fmt.Sprintf("%s to hold %d documents", index, count), func() (bool, string, error) {
+			current, err := countDocuments(client, index)
+			if err != nil {
+				return false, "", err
+			}
+			return current >= count, fmt.Sprintf("%d documents", current), nil
+		})
+	}
+}
+
+// poll calls check every taskPollInterval until it reports done, failing
+// with the last state it reported once timeout has passed
+func poll(ctx context.Context, timeout time.Duration, what string, check func() (done bool, state string, err error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var last string
+	for {
+		done, state, err := check()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("error waiting for %s: %w", what, err)
+		}
+		if done {
+			return nil
+		}
+		last = state
+		if !sleepContext(ctx, taskPollInterval) {
+			break
+		}
+	}
+	if last == "" {
+		return fmt.Errorf("timed out after %s waiting for %s", timeout, what)
+	}
+	return fmt.Errorf("timed out after %s waiting for %s, last %s", timeout, what, last)
+}
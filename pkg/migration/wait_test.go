@@ -0,0 +1,65 @@
+package migration
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestWait(t *testing.T) {
+	interval := taskPollInterval
+	taskPollInterval = time.Millisecond
+	defer func() { taskPollInterval = interval }()
+
+	t.Run("Test Waits Run After The Migration", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{
+			"GET /_cluster/health/articles": `{"status": "green"}`,
+			"GET /_tasks":                   `{"tasks": []}`,
+			"POST /articles/_count":         `{"count": 10}`,
+		}}
+		m := NewMigration("Create articles", func(client *elasticsearch.Client) error {
+			transport.requests = append(transport.requests, "up")
+			return nil
+		}).WithWait(
+			WaitForIndexHealth("articles", "green", time.Second),
+			WaitForTasks("*reindex", time.Second),
+			WaitForDocCount("articles", 10, time.Second),
+		)
+		if err := m.UpFunc(ClientFromTransport(transport)); err != nil {
+			t.Fatalf("Failed to run migration: %v", err)
+		}
+		expected := "up GET /_cluster/health/articles GET /_tasks POST /articles/_count"
+		if got := strings.Join(transport.requests, " "); got != expected {
+			t.Errorf("Expected %s, got %s", expected, got)
+		}
+	})
+
+	t.Run("Test Unmet Waits Time Out", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{
+			"GET /_cluster/health":  `{"status": "yellow"}`,
+			"GET /_tasks":           `{"tasks": [{"action": "indices:data/write/reindex"}]}`,
+			"POST /articles/_count": `{"count": 3}`,
+		}}
+		for expected, wait := range map[string]Wait{
+			"waiting for cluster to be green, last yellow":                WaitForClusterHealth("green", 20*time.Millisecond),
+			"waiting for *reindex tasks to complete, last 1 running":      WaitForTasks("*reindex", 20*time.Millisecond),
+			"waiting for articles to hold 10 documents, last 3 documents": WaitForDocCount("articles", 10, 20*time.Millisecond),
+		} {
+			m := NewMigration("Wait", func(client *elasticsearch.Client) error { return nil }).WithWait(wait)
+			err := m.UpFunc(ClientFromTransport(transport))
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected an error %q, got %v", expected, err)
+			}
+		}
+	})
+
+	t.Run("Test Yellow Is Met By Green", func(t *testing.T) {
+		transport := &routeTransport{routes: map[string]string{"GET /_cluster/health": `{"status": "green"}`}}
+		if err := WaitForClusterHealth("yellow", time.Second)(context.Background(), ClientFromTransport(transport)); err != nil {
+			t.Errorf("Expected green to satisfy yellow, got %v", err)
+		}
+	})
+}
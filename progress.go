@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// progressBarWidth is the number of cells of the progress bar
+const progressBarWidth = 30
+
+// progressBar draws the progress of long-running tasks on standard error.
+// Terminals get a bar redrawn in place; other outputs, such as CI logs, get a
+// line every tenth of the task.
+type progressBar struct {
+	w        io.Writer
+	terminal bool
+	task     string
+	decile   int
+}
+
+func newProgressBar() *progressBar {
+	info, err := os.Stderr.Stat()
+	return &progressBar{w: os.Stderr, terminal: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+func (b *progressBar) Report(progress migration.Progress) {
+	if progress.Task != b.task {
+		b.task, b.decile = progress.Task, -1
+	}
+	line := fmt.Sprintf("%s %5.1f%% %d/%d docs", progress.Description, progress.Percent(), progress.Processed, progress.Total)
+	if eta := progress.ETA(); eta > 0 {
+		line += fmt.Sprintf(" ETA %s", eta)
+	}
+
+	if !b.terminal {
+		decile := int(progress.Percent()) / 10
+		if decile > b.decile {
+			b.decile = decile
+			fmt.Fprintln(b.w, line)
+		}
+		return
+	}
+
+	filled := int(progress.Percent() * progressBarWidth / 100)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(b.w, "\r\033[K[%s] %s", bar, line)
+	if progress.Completed || progress.Processed >= progress.Total {
+		fmt.Fprintln(b.w)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// runRefresh reports cluster objects that drifted from the migration history
+func runRefresh(args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	invalidate := fs.Bool("invalidate", false, "Remove the records of migrations whose objects drifted, so the next run applies them again")
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	report, err := mm.Refresh()
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+
+	if !*invalidate || len(report.Drift) == 0 {
+		return nil
+	}
+	versions := report.Versions()
+	if err := mm.Invalidate(versions...); err != nil {
+		return err
+	}
+	fmt.Printf("Invalidated %d migrations; they are pending again.\n", len(versions))
+	return nil
+}
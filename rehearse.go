@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// runRehearse rehearses pending migrations against clones of their indices
+func runRehearse(args []string) error {
+	fs := flag.NewFlagSet("rehearse", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	indices := fs.String("indices", "", "Comma separated indices to clone up front; others are cloned when a migration refers to them")
+	threshold := fs.Int64("sample-threshold", 10000, "Number of documents above which an index is cloned with a sample")
+	sampleSize := fs.Int64("sample-size", 1000, "Number of documents copied from indices above the threshold")
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	cfg := migration.RehearsalConfig{SampleThreshold: *threshold, SampleSize: *sampleSize}
+	if *indices != "" {
+		cfg.Indices = strings.Split(*indices, ",")
+	}
+	report, err := mm.Rehearse(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	if report.Failed() {
+		return fmt.Errorf("rehearsal failed")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// Exit codes of a migration run. exitApplied is only used with
+// -detailed-exit-code; otherwise a run that applied migrations exits with
+// exitOK.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitApplied = 2
+	exitLocked  = 3
+)
+
+// Results of a migration run, as reported with -output json
+const (
+	resultNothingToDo = "nothing_to_do"
+	resultApplied     = "applied"
+	resultLocked      = "locked"
+	resultFailed      = "failed"
+)
+
+// runResult is the machine-readable outcome of a migration run
+type runResult struct {
+	Result   string `json:"result"`
+	ExitCode int    `json:"exit_code"`
+	migration.RunSummary
+}
+
+// newRunResult classifies the outcome of a run
+func newRunResult(summary migration.RunSummary, err error, detailed bool) runResult {
+	result := runResult{Result: resultNothingToDo, ExitCode: exitOK, RunSummary: summary}
+	switch {
+	case errors.Is(err, migration.ErrLocked):
+		result.Result, result.ExitCode = resultLocked, exitLocked
+	case err != nil:
+		result.Result, result.ExitCode = resultFailed, exitFailure
+	case len(summary.Applied) > 0:
+		result.Result = resultApplied
+		if detailed {
+			result.ExitCode = exitApplied
+		}
+	}
+	return result
+}
+
+// report prints the result in format, "text" or "json", to stdout, or errors
+// to stderr
+func (r runResult) report(stdout, stderr io.Writer, format string) {
+	if format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(r)
+		return
+	}
+	switch r.Result {
+	case resultNothingToDo:
+		fmt.Fprintln(stdout, "Nothing to do")
+	case resultApplied:
+		fmt.Fprintf(stdout, "Applied %d migrations\n", len(r.Applied))
+	case resultLocked, resultFailed:
+		fmt.Fprintf(stderr, "Error: %s\n", r.Error)
+	}
+}
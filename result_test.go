@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+	"github.com/punitsu/elasticmate/pkg/migration/migrationtest"
+)
+
+func TestRunResult(t *testing.T) {
+	applied := migration.RunSummary{Applied: []migration.MigrationResult{{Version: "aaaa0001", Description: "Create articles"}}}
+
+	for _, test := range []struct {
+		name     string
+		summary  migration.RunSummary
+		err      error
+		detailed bool
+		result   string
+		exitCode int
+	}{
+		{name: "Test Nothing To Do", result: resultNothingToDo, exitCode: exitOK},
+		{name: "Test Nothing To Do With Detailed Exit Code", detailed: true, result: resultNothingToDo, exitCode: exitOK},
+		{name: "Test Applied", summary: applied, result: resultApplied, exitCode: exitOK},
+		{name: "Test Applied With Detailed Exit Code", summary: applied, detailed: true, result: resultApplied, exitCode: exitApplied},
+		{name: "Test Failed", summary: applied, err: errors.New("boom"), detailed: true, result: resultFailed, exitCode: exitFailure},
+		{name: "Test Locked", err: fmt.Errorf("error acquiring lock: %w", migration.ErrLocked), result: resultLocked, exitCode: exitLocked},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			result := newRunResult(test.summary, test.err, test.detailed)
+			if result.Result != test.result || result.ExitCode != test.exitCode {
+				t.Errorf("Expected %s with exit code %d, got %s with %d", test.result, test.exitCode, result.Result, result.ExitCode)
+			}
+		})
+	}
+
+	t.Run("Test JSON Output Is Not Mixed With Progress", func(t *testing.T) {
+		es := migrationtest.NewFakeES(t)
+		es.CreateIndex("articles", `{}`)
+		es.AddDocument("articles", "1", map[string]interface{}{"status": "spam"})
+		var stdout, stderr bytes.Buffer
+		mm := es.Manager(migration.WithStore(migration.NewMemoryStore()), migration.WithLogger(log.New(&stderr, "", 0)))
+		mm.Register(migration.DeleteByQuery("articles", `{"term": {"status": "spam"}}`))
+
+		summary, err := mm.RunMigrationsSummary(t.Context())
+		newRunResult(summary, err, true).report(&stdout, &stderr, "json")
+
+		var result runResult
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			t.Fatalf("Expected only the result on standard output, got %q: %v", stdout.String(), err)
+		}
+		if result.Result != resultApplied || result.ExitCode != exitApplied || len(result.Applied) != 1 {
+			t.Errorf("Expected one applied migration and exit code 2, got %+v", result)
+		}
+		if !bytes.Contains(stderr.Bytes(), []byte("Deleted 1 of 1 documents in articles")) {
+			t.Errorf("Expected progress on standard error, got %q", stderr.String())
+		}
+	})
+
+	t.Run("Test Text Output", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		newRunResult(applied, nil, false).report(&stdout, &stderr, "text")
+		newRunResult(migration.RunSummary{Error: "migration lock is held by another runner"}, migration.ErrLocked, false).report(&stdout, &stderr, "text")
+		if stdout.String() != "Applied 1 migrations\n" || stderr.String() != "Error: migration lock is held by another runner\n" {
+			t.Errorf("Expected the result on standard output and the error on standard error, got %q and %q", stdout.String(), stderr.String())
+		}
+	})
+}
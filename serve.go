@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration/server"
+)
+
+// runServe runs the control server that stores migration bundles and applies
+// them to the configured clusters
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "Address to listen on")
+	configPath := fs.String("config", "", "Path to the JSON configuration listing the clusters")
+	bundleDir := fs.String("bundles", "", "Optional directory persisting uploaded bundles, overriding the configuration")
+	runTimeout := fs.Duration("run-timeout", 0, "Optional limit on the duration of each run")
+	configPoll := fs.Duration("config-poll", 10*time.Second, "How often -config is checked for changes to reload, 0 to reload on SIGHUP only")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return fmt.Errorf("serve requires -config")
+	}
+	cfg, err := server.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg.Token = os.Getenv("ELASTICMATE_TOKEN")
+	if *bundleDir != "" {
+		cfg.BundleDir = *bundleDir
+	}
+	cfg.RunTimeout = *runTimeout
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	go reloadConfig(srv, *configPath, *configPoll)
+
+	log.Printf("Serving migration bundles for %d clusters on %s", len(cfg.Clusters), *listen)
+	return http.ListenAndServe(*listen, srv)
+}
+
+// reloadConfig reloads the clusters, profiles and notification targets of
+// path into srv on SIGHUP and, when poll is set, whenever the file changes.
+// A configuration that fails to load is logged and the current one is kept.
+func reloadConfig(srv *server.Server, path string, poll time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modified := configModTime(path)
+
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			if current := configModTime(path); current.Equal(modified) {
+				continue
+			}
+		}
+		modified = configModTime(path)
+
+		cfg, err := server.LoadConfig(path)
+		if err == nil {
+			err = srv.Reload(cfg)
+		}
+		if err != nil {
+			log.Printf("Failed to reload %s, keeping the current configuration: %v", path, err)
+			continue
+		}
+		log.Printf("Reloaded %s: %d clusters, %d profiles, %d notification targets", path, len(cfg.Clusters), len(cfg.Profiles), len(cfg.Notifications))
+	}
+}
+
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// runStatus reports applied and pending migrations
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	allNamespaces := fs.Bool("all-namespaces", false, "Report every namespace tracked in the cluster")
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	var statuses []migration.NamespaceStatus
+	if *allNamespaces {
+		statuses, err = migration.FleetStatus(mm.Client)
+	} else {
+		var status migration.NamespaceStatus
+		status, err = mm.Status()
+		statuses = append(statuses, status)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tAPPLIED\tCURRENT\tAPPLIED AT\tPENDING\tUNKNOWN")
+	for _, status := range statuses {
+		namespace := status.Namespace
+		if namespace == "" {
+			namespace = "(default)"
+		}
+
+		current, appliedAt := "-", "-"
+		if status.Current != nil {
+			current = status.Current.Version
+			appliedAt = status.Current.AppliedAt.UTC().Format("2006-01-02 15:04")
+		}
+
+		pending, unknown := "no manifest", "-"
+		if status.Manifest != nil {
+			pending = fmt.Sprint(len(status.Pending))
+			unknown = fmt.Sprint(len(status.Unknown))
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", namespace, status.Applied, current, appliedAt, pending, unknown)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !*allNamespaces {
+		for _, entry := range statuses[0].Pending {
+			fmt.Printf("Pending %s: %s\n", entry.Version, entry.Description)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// runVerify checks the postconditions of applied migrations
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	conn := connectionFlags(fs)
+	fs.Parse(args)
+
+	mm, err := conn.manager()
+	if err != nil {
+		return err
+	}
+
+	report, err := mm.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("%d migrations failed verification", len(report.Failures))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/punitsu/elasticmate/pkg/migration"
+)
+
+// runWatch runs as a daemon applying the declarative migrations of -dir as
+// files are added to it, e.g. by a config sync, until interrupted. SIGHUP
+// runs it again right away, reading the connection settings afresh.
+func runWatch(args []string) error {
+	fset := flag.NewFlagSet("watch", flag.ExitOnError)
+	conn := connectionFlags(fset)
+	interval := fset.Duration("interval", time.Minute, "How often -dir is checked for new migration files")
+	window := fset.String("window", "", "Off-peak window pending migrations wait for, as HH:MM-HH:MM")
+	windowZone := fset.String("window-tz", "UTC", "Time zone of -window, e.g. Europe/Berlin")
+	fset.Parse(args)
+
+	if *conn.dir == "" {
+		return fmt.Errorf("watch requires -dir")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("watch requires a positive -interval")
+	}
+	var opts []migration.Option
+	if *window != "" {
+		parsed, err := migration.ParseWindow(*window, *windowZone)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, migration.WithWindow(parsed))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	log.Printf("Watching %s for migrations every %s", *conn.dir, *interval)
+
+	var state watchState
+	for {
+		fingerprint, err := dirFingerprint(*conn.dir)
+		if err != nil {
+			log.Printf("Failed to read %s: %v", *conn.dir, err)
+		} else if state.due(fingerprint, time.Now()) {
+			wake, err := watchRun(ctx, conn, opts)
+			if err != nil {
+				log.Printf("Migration run failed, retrying in %s: %v", *interval, err)
+			} else {
+				state.ran(fingerprint, wake)
+			}
+		}
+
+		timer := time.NewTimer(*interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		case <-hup:
+			timer.Stop()
+			log.Printf("Reloading on SIGHUP")
+			state.applied = ""
+		}
+	}
+}
+
+// watchState decides when the watched directory is run
+type watchState struct {
+	// applied is the fingerprint of the directory last run without failure
+	applied string
+	// wake is when a migration waiting for its window becomes eligible
+	wake time.Time
+}
+
+// due reports whether the directory has changed since it was last run, or a
+// deferred migration has become eligible by now
+func (s *watchState) due(fingerprint string, now time.Time) bool {
+	return fingerprint != s.applied || (!s.wake.IsZero() && !now.Before(s.wake))
+}
+
+// ran records a successful run of the directory with fingerprint
+func (s *watchState) ran(fingerprint string, wake time.Time) {
+	s.applied = fingerprint
+	s.wake = wake
+}
+
+// watchRun applies the pending migrations with a manager reading -dir
+// afresh, returning when the earliest deferred migration becomes eligible.
+// SIGINT and SIGTERM cancel ctx, ending the run without applying the rest.
+func watchRun(ctx context.Context, conn *connection, opts []migration.Option) (time.Time, error) {
+	mm, err := conn.manager(opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := mm.RunMigrationsContext(ctx); err != nil {
+		if errors.Is(err, migration.ErrLocked) {
+			return time.Time{}, fmt.Errorf("migrations are locked by another runner")
+		}
+		return time.Time{}, err
+	}
+
+	var wake time.Time
+	for _, deferred := range mm.Deferred() {
+		if !deferred.Next.IsZero() && (wake.IsZero() || deferred.Next.Before(wake)) {
+			wake = deferred.Next
+		}
+	}
+	return wake, nil
+}
+
+// dirFingerprint hashes the names, sizes and modification times of the files
+// under dir, so added and changed migration files are noticed
+func dirFingerprint(dir string) (string, error) {
+	h := fnv.New64a()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	fingerprint := func() string {
+		t.Helper()
+		fingerprint, err := dirFingerprint(dir)
+		if err != nil {
+			t.Fatalf("Failed to fingerprint %s: %v", dir, err)
+		}
+		return fingerprint
+	}
+
+	write("001_create_articles.yaml", "action: create_index\n")
+	initial := fingerprint()
+	if fingerprint() != initial {
+		t.Errorf("Expected an unchanged directory to keep its fingerprint")
+	}
+
+	write("002_add_mapping.yaml", "action: put_mapping\n")
+	added := fingerprint()
+	if added == initial {
+		t.Errorf("Expected an added file to change the fingerprint")
+	}
+
+	write("002_add_mapping.yaml", "action: put_mapping\nindex: articles\n")
+	if fingerprint() == added {
+		t.Errorf("Expected a changed file to change the fingerprint")
+	}
+
+	os.MkdirAll(filepath.Join(dir, "nested"), 0755)
+	before := fingerprint()
+	write(filepath.Join("nested", "003_alias.yaml"), "action: add_alias\n")
+	if fingerprint() == before {
+		t.Errorf("Expected a file in a subdirectory to change the fingerprint")
+	}
+
+	if _, err := dirFingerprint(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Expected a missing directory to fail")
+	}
+}
+
+func TestWatchState(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Test Changed Directory Is Due", func(t *testing.T) {
+		var state watchState
+		if !state.due("a", now) {
+			t.Errorf("Expected the first check to run")
+		}
+		state.ran("a", time.Time{})
+		if state.due("a", now) {
+			t.Errorf("Expected an unchanged directory not to run again")
+		}
+		if !state.due("b", now) {
+			t.Errorf("Expected a changed directory to run")
+		}
+	})
+
+	t.Run("Test Failed Run Is Retried", func(t *testing.T) {
+		var state watchState
+		state.ran("a", time.Time{})
+		// a failed run of b is not recorded, so the next check retries it
+		if !state.due("b", now) || !state.due("b", now.Add(time.Minute)) {
+			t.Errorf("Expected the changed directory to stay due until it runs")
+		}
+	})
+
+	t.Run("Test Deferred Migration Wakes The Watcher", func(t *testing.T) {
+		var state watchState
+		state.ran("a", now.Add(10*time.Hour))
+		if state.due("a", now.Add(9*time.Hour)) {
+			t.Errorf("Expected no run before the window opens")
+		}
+		if !state.due("a", now.Add(10*time.Hour)) {
+			t.Errorf("Expected a run once the window opens")
+		}
+		if !state.due("a", now.Add(11*time.Hour)) {
+			t.Errorf("Expected a run after a missed wake")
+		}
+
+		state.ran("a", time.Time{})
+		if state.due("a", now.Add(11*time.Hour)) {
+			t.Errorf("Expected no run once nothing is deferred")
+		}
+	})
+}